@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/cache"
+	"github.com/larkwiot/booker/internal/util"
+	"log/slog"
+	"path/filepath"
+)
+
+type cacheCommand struct {
+	InputPath  string `short:"i" long:"input" description:"filepath to previous JSON output to inspect or update" required:"true"`
+	OutputPath string `short:"o" long:"output" description:"filepath to write the updated books to, instead of updating the input in place"`
+	Force      bool   `long:"force" description:"replace --output if it already exists"`
+	List       bool   `long:"list" description:"print the filepath and title, or error, of every book picked by --errored and --provider"`
+	Prune      bool   `long:"prune" description:"remove the books picked by --errored and --provider, so that they are processed again"`
+	Errored    bool   `long:"errored" description:"only pick books that failed"`
+	Provider   string `long:"provider" description:"only pick books with metadata from this provider, like google or comic"`
+	Rebase     string `long:"rebase" description:"move the books under one directory to another after moving the library, given as /old:/new or C:\\old:D:\\new"`
+	Validate   bool   `long:"validate" description:"check the books for problems, exiting with an error if there are any"`
+	CheckFiles bool   `long:"check-files" description:"with --validate, also report the books whose files no longer exist"`
+}
+
+func (c *cacheCommand) run(globals *globalOptions) error {
+	if !c.List && !c.Prune && len(c.Rebase) == 0 && !c.Validate {
+		return fmt.Errorf("error: nothing to do, give --list, --prune, --rebase or --validate")
+	}
+	filter := cache.Filter{Errored: c.Errored, Provider: c.Provider}
+	if c.Prune && filter == (cache.Filter{}) {
+		return fmt.Errorf("error: --prune needs --errored or --provider to pick the books to remove")
+	}
+
+	input, err := util.AbsPath(c.InputPath)
+	if err != nil {
+		return fmt.Errorf("error: could not get absolute input path: %s", err.Error())
+	}
+	data, err := util.ReadFile(input)
+	if err != nil {
+		return fmt.Errorf("error: could not read input %s: %s", c.InputPath, err.Error())
+	}
+	books, err := cache.Parse(data)
+	if err != nil {
+		return fmt.Errorf("error: %s is not a valid output: %s", c.InputPath, err.Error())
+	}
+
+	output := input
+	if len(c.OutputPath) > 0 {
+		output, err = resolveOutputPath(c.OutputPath, "output", c.Force)
+		if err != nil {
+			return err
+		}
+	}
+
+	changed := output != input
+	if c.Prune {
+		pruned := cache.Prune(books, filter)
+		slog.Info("pruned books", "books", pruned)
+		changed = changed || pruned > 0
+	}
+	if len(c.Rebase) > 0 {
+		from, to, ok := cutRebase(c.Rebase)
+		if !ok || len(from) == 0 || len(to) == 0 {
+			return fmt.Errorf("error: --rebase takes the old and new directories as /old:/new")
+		}
+		rebased := cache.Rebase(books, filepath.Clean(util.ExpandUser(from)), filepath.Clean(util.ExpandUser(to)))
+		slog.Info("rebased books", "books", rebased, "from", from, "to", to)
+		changed = changed || rebased > 0
+	}
+	if changed {
+		err = writeJsonFile(output, books)
+		if err != nil {
+			return fmt.Errorf("error: failed to write %s: %s", output, err.Error())
+		}
+	}
+
+	if c.List {
+		for _, bk := range cache.Select(books, filter) {
+			if len(bk.ErrorMessage) > 0 {
+				fmt.Printf("%s\terror: %s\n", bk.Filepath, bk.ErrorMessage)
+			} else {
+				fmt.Printf("%s\t%s\n", bk.Filepath, bk.Title)
+			}
+		}
+	}
+
+	if c.Validate {
+		problems := cache.Validate(books, c.CheckFiles)
+		for _, problem := range problems {
+			fmt.Printf("%s\t%s\n", problem.Filepath, problem.Message)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("error: found %d problems with the books in %s", len(problems), c.InputPath)
+		}
+		slog.Info("found no problems", "books", len(books))
+	}
+
+	return nil
+}
+
+// cutRebase splits --rebase into the old and new directories at the first colon that isn't part of a Windows drive
+// letter, so that C:\old:D:\new is C:\old and D:\new
+func cutRebase(rebase string) (string, string, bool) {
+	for i := 0; i < len(rebase); i++ {
+		if rebase[i] != ':' || isDriveColon(rebase, i) {
+			continue
+		}
+		return rebase[:i], rebase[i+1:], true
+	}
+	return rebase, "", false
+}
+
+// isDriveColon is whether the colon at i in s ends the drive letter of a path that starts there, as in C:\books
+func isDriveColon(s string, i int) bool {
+	start := i - 1
+	if start < 0 || (start > 0 && s[start-1] != ':') {
+		return false
+	}
+	letter := s[start]
+	if ('a' > letter || letter > 'z') && ('A' > letter || letter > 'Z') {
+		return false
+	}
+	return i+1 < len(s) && (s[i+1] == '\\' || s[i+1] == '/')
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/larkwiot/booker/internal/config"
+	"log/slog"
+	"os"
+)
+
+type configCommand struct {
+	Check configCheckCommand `command:"check" description:"check the configuration file and print the configuration it resolves to"`
+	Init  configInitCommand  `command:"init" description:"write a configuration file with every section and its defaults, commented"`
+}
+
+type configCheckCommand struct {
+	ShowSecrets bool `long:"show-secrets" description:"print API keys, passwords and headers as they are rather than hiding them"`
+}
+
+type configInitCommand struct {
+	Force bool `long:"force" description:"replace the configuration file if it already exists"`
+}
+
+func (c *configCommand) run(globals *globalOptions, subcommand string) error {
+	switch subcommand {
+	case "check":
+		return c.Check.run(globals)
+	case "init":
+		return c.Init.run(globals)
+	}
+	return nil
+}
+
+// run writes the default configuration to --config, only readable by the user since API keys go in it
+func (c *configInitCommand) run(globals *globalOptions) error {
+	path := globals.ConfigPath
+	if len(path) == 0 {
+		path = config.DefaultPath
+	}
+	path, err := resolveOutputPath(path, "config", c.Force)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(path, config.DefaultFile, 0o600)
+	if err != nil {
+		return fmt.Errorf("error: could not write %s: %s", path, err.Error())
+	}
+	slog.Info("wrote configuration", "path", path)
+	return nil
+}
+
+// run prints the configuration with its profile, environment variables and defaults applied as TOML, failing if it is
+// invalid or has keys that aren't used
+func (c *configCheckCommand) run(globals *globalOptions) error {
+	conf, unknown, err := config.Load(globals.configSource())
+	if err != nil {
+		return fmt.Errorf("error: could not read the configuration: %s", err.Error())
+	}
+	for _, key := range unknown {
+		slog.Warn("unknown config key", "key", key)
+	}
+	err = conf.Validate()
+	if err != nil {
+		return fmt.Errorf("error: invalid configuration: %s", err.Error())
+	}
+
+	// the profiles have already been applied, or weren't picked
+	conf.Profiles = nil
+	if !c.ShowSecrets {
+		conf.Redact()
+	}
+	err = toml.NewEncoder(os.Stdout).Encode(conf)
+	if err != nil {
+		return fmt.Errorf("error: could not print the configuration: %s", err.Error())
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("error: the configuration has %d keys that aren't used", len(unknown))
+	}
+	return nil
+}
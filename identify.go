@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type identifyCommand struct {
+	Cache         string `long:"cache" description:"filepath to previous JSON output to use as cache"`
+	Offline       bool   `long:"offline" description:"don't use any providers, identifying the book only by its embedded metadata and the cache"`
+	EmbedMetadata bool   `long:"embed-metadata" description:"write the title, authors, and ISBN found back into the file if it is an EPUB or PDF"`
+	CoversDir     string `long:"covers-dir" description:"download the book's cover into this directory with a thumbnail, recording their paths in the output"`
+	Args          struct {
+		File string `positional-arg-name:"file" description:"book to identify"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// run identifies the one book and prints it to stdout, the book is still printed with its error message if it fails
+func (c *identifyCommand) run(globals *globalOptions) error {
+	conf, err := config.NewConfig(globals.configSource())
+	if err != nil {
+		return err
+	}
+	conf.Offline = c.Offline
+	if c.Offline && len(c.CoversDir) > 0 {
+		return fmt.Errorf("error: --covers-dir downloads covers, which can't be done --offline")
+	}
+
+	bm, err := internal.NewBookManager(conf, 0)
+	if err != nil {
+		return err
+	}
+	defer bm.Shutdown()
+	bm.SetEmbedMetadata(c.EmbedMetadata)
+	if len(c.CoversDir) > 0 {
+		err = bm.SetCoversDir(util.ExpandUser(c.CoversDir))
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(c.Cache) != 0 {
+		err = bm.Import(c.Cache, nil)
+		if err != nil {
+			return fmt.Errorf("error: book manager failed to import cache %s: %s", c.Cache, err.Error())
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bk, identifyErr := bm.Identify(ctx, c.Args.File)
+	if len(bk.Filepath) == 0 {
+		return identifyErr
+	}
+
+	data, err := json.MarshalIndent(bk, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error: could not marshal book: %s", err.Error())
+	}
+	fmt.Println(string(data))
+	return identifyErr
+}
@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/nwaples/rardecode/v2"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Separator splits the path of an archive from the path of a book inside it, as in archive.zip::inner/path.pdf
+const Separator = "::"
+
+// errFound stops walking an archive once the member wanted has been read
+var errFound = errors.New("found")
+
+// IsArchive is whether the file is an archive books can be found in, by its extension
+func IsArchive(filePath string) bool {
+	name := strings.ToLower(filePath)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz", ".rar"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Join is the path of a member of an archive
+func Join(archivePath string, member string) string {
+	return archivePath + Separator + member
+}
+
+// Split is the archive and member a path refers to, ok is false if the path isn't inside an archive
+func Split(filePath string) (archivePath string, member string, ok bool) {
+	archivePath, member, ok = strings.Cut(filePath, Separator)
+	if !ok || len(member) == 0 || !IsArchive(archivePath) {
+		return filePath, "", false
+	}
+	return archivePath, member, true
+}
+
+// IsMember is whether the path is inside an archive
+func IsMember(filePath string) bool {
+	_, _, ok := Split(filePath)
+	return ok
+}
+
+// Exists is whether the file exists, or for a member of an archive whether the archive still holds it
+func Exists(filePath string) bool {
+	archivePath, member, ok := Split(filePath)
+	if !ok {
+		_, err := os.Stat(filePath)
+		return err == nil
+	}
+	members, err := Members(archivePath)
+	if err != nil {
+		return false
+	}
+	for _, m := range members {
+		if m == member {
+			return true
+		}
+	}
+	return false
+}
+
+// Members lists the path of every file in the archive
+func Members(archivePath string) ([]string, error) {
+	members := make([]string, 0)
+	err := walk(archivePath, func(name string, r io.Reader) error {
+		members = append(members, name)
+		return nil
+	})
+	return members, err
+}
+
+// Extract copies a member of an archive out to a temporary file with the same extension, which the caller removes
+func Extract(archivePath string, member string) (string, error) {
+	var extracted string
+	err := walk(archivePath, func(name string, r io.Reader) error {
+		if name != member {
+			return nil
+		}
+		fh, err := os.CreateTemp("", "booker-*"+path.Ext(member))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fh, r)
+		if closeErr := fh.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(fh.Name())
+			return err
+		}
+		extracted = fh.Name()
+		return errFound
+	})
+	if errors.Is(err, errFound) {
+		return extracted, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("error: %s is not in %s", member, archivePath)
+}
+
+// ReadMember reads the first file in the archive whose path matches, comic book archives can be read too
+func ReadMember(archivePath string, match func(name string) bool) ([]byte, error) {
+	var data []byte
+	err := walk(archivePath, func(name string, r io.Reader) error {
+		if !match(name) {
+			return nil
+		}
+		var err error
+		data, err = io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return errFound
+	})
+	if errors.Is(err, errFound) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("error: no such file in %s", archivePath)
+}
+
+// walk calls visit with every file in the archive in order, until visit returns an error
+func walk(archivePath string, visit func(name string, r io.Reader) error) error {
+	name := strings.ToLower(archivePath)
+	switch {
+	// comic book archives are only zips and rars of images
+	case strings.HasSuffix(name, ".zip"), strings.HasSuffix(name, ".cbz"):
+		return walkZip(archivePath, visit)
+	case strings.HasSuffix(name, ".rar"), strings.HasSuffix(name, ".cbr"):
+		return walkRar(archivePath, visit)
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return walkTar(archivePath, visit)
+	default:
+		return fmt.Errorf("error: %s is not a supported archive", filepath.Base(archivePath))
+	}
+}
+
+func walkZip(archivePath string, visit func(name string, r io.Reader) error) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error: unable to open zip %s: %s", archivePath, err.Error())
+	}
+	defer archive.Close()
+
+	for _, file := range archive.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("error: unable to read %s in zip %s: %s", file.Name, archivePath, err.Error())
+		}
+		err = visit(file.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(archivePath string, visit func(name string, r io.Reader) error) error {
+	fh, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("error: unable to open tar %s: %s", archivePath, err.Error())
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if name := strings.ToLower(archivePath); strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return fmt.Errorf("error: %s is not gzip compressed: %s", archivePath, err.Error())
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error: unable to read tar %s: %s", archivePath, err.Error())
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		err = visit(header.Name, archive)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func walkRar(archivePath string, visit func(name string, r io.Reader) error) error {
+	archive, err := rardecode.OpenReader(archivePath)
+	if err != nil {
+		return fmt.Errorf("error: unable to open rar %s: %s", archivePath, err.Error())
+	}
+	defer archive.Close()
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error: unable to read rar %s: %s", archivePath, err.Error())
+		}
+		if header.IsDir {
+			continue
+		}
+		err = visit(header.Name, archive)
+		if err != nil {
+			return err
+		}
+	}
+}
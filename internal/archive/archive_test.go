@@ -0,0 +1,87 @@
+package archive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var members = map[string]string{
+	"dune.epub":        "Dune",
+	"papers/taocp.pdf": "TAOCP",
+	"papers/notes.txt": "notes",
+}
+
+func writeZip(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "books.zip")
+	fh, err := os.Create(path)
+	assert.NoError(t, err)
+	w := zip.NewWriter(fh)
+	_, err = w.Create("papers/")
+	assert.NoError(t, err)
+	for name, content := range members {
+		mw, err := w.Create(name)
+		assert.NoError(t, err)
+		mw.Write([]byte(content))
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, fh.Close())
+	return path
+}
+
+func writeTarGz(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "books.tar.gz")
+	fh, err := os.Create(path)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(fh)
+	w := tar.NewWriter(gz)
+	assert.NoError(t, w.WriteHeader(&tar.Header{Name: "papers/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range members {
+		assert.NoError(t, w.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		w.Write([]byte(content))
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, fh.Close())
+	return path
+}
+
+func TestArchives(t *testing.T) {
+	for _, path := range []string{writeZip(t), writeTarGz(t)} {
+		assert.True(t, archive.IsArchive(path))
+
+		listed, err := archive.Members(path)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"dune.epub", "papers/taocp.pdf", "papers/notes.txt"}, listed)
+
+		extracted, err := archive.Extract(path, "papers/taocp.pdf")
+		assert.NoError(t, err)
+		assert.Equal(t, ".pdf", filepath.Ext(extracted))
+		data, err := os.ReadFile(extracted)
+		assert.NoError(t, err)
+		assert.Equal(t, "TAOCP", string(data))
+		os.Remove(extracted)
+
+		_, err = archive.Extract(path, "missing.pdf")
+		assert.Error(t, err)
+
+		assert.True(t, archive.Exists(archive.Join(path, "dune.epub")))
+		assert.False(t, archive.Exists(archive.Join(path, "missing.pdf")))
+	}
+}
+
+func TestSplit(t *testing.T) {
+	archivePath, member, ok := archive.Split("/books/comics.zip::vol 1/issue.pdf")
+	assert.True(t, ok)
+	assert.Equal(t, "/books/comics.zip", archivePath)
+	assert.Equal(t, "vol 1/issue.pdf", member)
+
+	_, _, ok = archive.Split("/books/odd::name.pdf")
+	assert.False(t, ok)
+	assert.False(t, archive.IsMember("/books/dune.epub"))
+}
@@ -1,15 +1,23 @@
 package book
 
 import (
-	"fmt"
 	"github.com/samber/mo"
-	"math"
+	"regexp"
 	"strings"
+	"unicode"
 )
 
 type ISBN string
 type ISBN10 ISBN
 type ISBN13 ISBN
+type DOI string
+
+// ASIN is Amazon's product identifier. Kindle editions have ones starting with B0 while print books use their ISBN-10
+type ASIN string
+
+// ArxivId is the identifier of a preprint on arXiv, like 2101.12345 or hep-th/9901001 for those from before 2007,
+// without its version
+type ArxivId string
 
 var badIsbns = map[string]struct{}{
 	"0123456789": {},
@@ -44,6 +52,37 @@ func IsIsbnCandidate(s string) bool {
 	return !isBad
 }
 
+var asinPattern = regexp.MustCompile(`^B0[0-9A-Z]{8}$`)
+
+func (asin *ASIN) IsValid() bool {
+	if asinPattern.MatchString(string(*asin)) {
+		return true
+	}
+	isbn := ISBN10(*asin)
+	return IsIsbnCandidate(string(isbn)) && isbn.IsValid()
+}
+
+// Isbn10 returns the ISBN-10 of an ISBN-13 in the 978 prefix, which is all that ISBN-10s could express
+func (isbn *ISBN13) Isbn10() (ISBN10, bool) {
+	s := string(*isbn)
+	if len(s) != 13 || !strings.HasPrefix(s, "978") {
+		return "", false
+	}
+
+	sum := 0
+	for i, c := range s[3:12] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+		sum += (10 - i) * int(c-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return ISBN10(s[3:12] + "X"), true
+	}
+	return ISBN10(s[3:12] + string(rune('0'+check))), true
+}
+
 func (isbn *ISBN10) IsValid() bool {
 	ctoi := func(c int32) int {
 		return int(c - '0')
@@ -89,23 +128,63 @@ func (isbn *ISBN13) IsValid() bool {
 }
 
 type Book struct {
-	Title        string   `json:"title"`
-	Authors      []string `json:"authors,omitempty"`
-	Isbn10       ISBN10   `json:"isbn10,omitempty"`
-	Isbn13       ISBN13   `json:"isbn13,omitempty"`
-	Uom          string   `json:"uom,omitempty"`
-	LowYear      uint     `json:"low_year,omitempty"`
-	HighYear     uint     `json:"high_year,omitempty"`
-	PublishDate  string   `json:"publish_date,omitempty"`
-	Publisher    string   `json:"publisher,omitempty"`
-	Filepath     string   `json:"filepath"`
-	ErrorMessage string   `json:"error,omitempty"`
+	Title       string   `json:"title"`
+	Authors     []string `json:"authors,omitempty"`
+	Editors     []string `json:"editors,omitempty"`
+	Isbn10      ISBN10   `json:"isbn10,omitempty"`
+	Isbn13      ISBN13   `json:"isbn13,omitempty"`
+	Uom         string   `json:"uom,omitempty"`
+	Oclc        string   `json:"oclc,omitempty"`
+	Doi         DOI      `json:"doi,omitempty"`
+	Asin        ASIN     `json:"asin,omitempty"`
+	Arxiv       ArxivId  `json:"arxiv,omitempty"`
+	LowYear     uint     `json:"low_year,omitempty"`
+	HighYear    uint     `json:"high_year,omitempty"`
+	PublishDate string   `json:"publish_date,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	Edition     string   `json:"edition,omitempty"`
+	Binding     string   `json:"binding,omitempty"`
+	Pages       uint     `json:"pages,omitempty"`
+	Series      string   `json:"series,omitempty"`
+	SeriesIndex float64  `json:"series_index,omitempty"`
+	Subjects    []string `json:"subjects,omitempty"`
+	Lcc         string   `json:"lcc,omitempty"`
+	Ddc         string   `json:"ddc,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	CoverUrl    string   `json:"cover_url,omitempty"`
+	Cover       string   `json:"cover,omitempty"`
+	Thumbnail   string   `json:"thumbnail,omitempty"`
+	Filepath    string   `json:"filepath"`
+	Hash        string   `json:"hash,omitempty"`
+	// Size and ModTime (in Unix nanoseconds) are those of the file when it was processed, to tell when it changes
+	Size         int64  `json:"size,omitempty"`
+	ModTime      int64  `json:"mtime,omitempty"`
+	DuplicateOf  string `json:"duplicate_of,omitempty"`
+	ErrorMessage string `json:"error,omitempty"`
+	// ErrorCode is the kind of failure ErrorMessage describes
+	ErrorCode ErrorCode `json:"error_code,omitempty"`
+	// UnsearchedIsbns are the least likely of the ISBNs found in the file, past advanced.max_isbn_lookups_per_file,
+	// which weren't looked up
+	UnsearchedIsbns []ISBN `json:"unsearched_isbns,omitempty"`
+	// Confidence scores the match out of 100, see scoring.Score
+	Confidence float64 `json:"confidence,omitempty"`
+	// Provenance names the provider or embedded metadata each field came from
+	Provenance map[string]string `json:"provenance,omitempty"`
 }
 
 //func (b *Book) String() string {
 //	return fmt.Sprintf("{\"title\": \"%s\", \"authors\": %s, \"isbn10\": %s, \"isbn13\": %s, \"filepath\": %s}", b.Title, b.Authors, b.Isbn10, b.Isbn13, b.Filepath)
 //}
 
+// SetProvenance records source as where field came from
+func (b *Book) SetProvenance(field string, source string) {
+	if b.Provenance == nil {
+		b.Provenance = make(map[string]string)
+	}
+	b.Provenance[field] = source
+}
+
 func (b *Book) BestIdentifier() string {
 	if b.Isbn13 != "" {
 		return string(b.Isbn13)
@@ -113,9 +192,21 @@ func (b *Book) BestIdentifier() string {
 	if b.Isbn10 != "" {
 		return string(b.Isbn10)
 	}
+	if b.Doi != "" {
+		return string(b.Doi)
+	}
+	if b.Arxiv != "" {
+		return string(b.Arxiv)
+	}
 	if b.Uom != "" {
 		return b.Uom
 	}
+	if b.Oclc != "" {
+		return b.Oclc
+	}
+	if b.Asin != "" {
+		return string(b.Asin)
+	}
 	if len(b.Title) != 0 {
 		return b.Title
 	}
@@ -126,59 +217,109 @@ type BookResult struct {
 	Filepath           string
 	Title              mo.Option[string]
 	Authors            mo.Option[[]string]
+	Editors            mo.Option[[]string]
 	Isbn10             mo.Option[ISBN10]
 	Isbn13             mo.Option[ISBN13]
 	Uom                mo.Option[string]
+	Oclc               mo.Option[string]
+	Doi                mo.Option[DOI]
+	Asin               mo.Option[ASIN]
+	Arxiv              mo.Option[ArxivId]
 	LowYear            mo.Option[uint]
 	HighYear           mo.Option[uint]
 	PublishDate        mo.Option[string]
 	Publisher          mo.Option[string]
+	Edition            mo.Option[string]
+	Binding            mo.Option[string]
+	Pages              mo.Option[uint]
+	Series             mo.Option[string]
+	SeriesIndex        mo.Option[float64]
+	Subjects           mo.Option[[]string]
+	Lcc                mo.Option[string]
+	Ddc                mo.Option[string]
+	Description        mo.Option[string]
+	Language           mo.Option[string]
+	CoverUrl           mo.Option[string]
 	Confidence         float64
 	SourceProviderName string
+	// Provenance names the source of each field that was filled in, by its name in the output
+	Provenance map[string]string
 }
 
 func (br *BookResult) IsUnidentified() bool {
-	return br.Title.IsAbsent() && br.Authors.IsAbsent() && br.Isbn10.IsAbsent() && br.Isbn13.IsAbsent()
+	return br.Title.IsAbsent() && br.Authors.IsAbsent() && br.Isbn10.IsAbsent() && br.Isbn13.IsAbsent() && br.Doi.IsAbsent() && br.Asin.IsAbsent() && br.Arxiv.IsAbsent()
+}
+
+// comparableTitle reduces a title to its lowercase letters and digits, leaving off any subtitle
+func comparableTitle(title string) string {
+	title, _, _ = strings.Cut(strings.ToLower(title), ":")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, title)
+}
+
+// SharesIdentifier reports whether both results have the same ISBN, DOI, ASIN or arXiv identifier
+func (br *BookResult) SharesIdentifier(other *BookResult) bool {
+	if isbn, ok := br.Isbn13.Get(); ok && isbn == other.Isbn13.OrEmpty() {
+		return true
+	}
+	if isbn, ok := br.Isbn10.Get(); ok && isbn == other.Isbn10.OrEmpty() {
+		return true
+	}
+	if doi, ok := br.Doi.Get(); ok && strings.EqualFold(string(doi), string(other.Doi.OrEmpty())) {
+		return true
+	}
+	if asin, ok := br.Asin.Get(); ok && asin == other.Asin.OrEmpty() {
+		return true
+	}
+	if arxiv, ok := br.Arxiv.Get(); ok && arxiv == other.Arxiv.OrEmpty() {
+		return true
+	}
+	return false
+}
+
+// Agrees reports whether the two results look like the same book, sharing an identifier or a title
+func (br *BookResult) Agrees(other *BookResult) bool {
+	if br.SharesIdentifier(other) {
+		return true
+	}
+
+	title := comparableTitle(br.Title.OrEmpty())
+	return len(title) > 0 && title == comparableTitle(other.Title.OrEmpty())
 }
 
 func (br *BookResult) ToBook() Book {
 	return Book{
 		Filepath:    br.Filepath,
 		Title:       br.Title.OrEmpty(),
-		Authors:     br.Authors.MustGet(),
+		Authors:     br.Authors.OrEmpty(),
+		Editors:     br.Editors.OrEmpty(),
 		Isbn10:      br.Isbn10.OrEmpty(),
 		Isbn13:      br.Isbn13.OrEmpty(),
 		Uom:         br.Uom.OrEmpty(),
+		Oclc:        br.Oclc.OrEmpty(),
+		Doi:         br.Doi.OrEmpty(),
+		Asin:        br.Asin.OrEmpty(),
+		Arxiv:       br.Arxiv.OrEmpty(),
 		LowYear:     br.LowYear.OrEmpty(),
 		HighYear:    br.HighYear.OrEmpty(),
 		PublishDate: br.PublishDate.OrEmpty(),
 		Publisher:   br.Publisher.OrEmpty(),
+		Edition:     br.Edition.OrEmpty(),
+		Binding:     br.Binding.OrEmpty(),
+		Pages:       br.Pages.OrEmpty(),
+		Series:      br.Series.OrEmpty(),
+		SeriesIndex: br.SeriesIndex.OrEmpty(),
+		Subjects:    br.Subjects.OrEmpty(),
+		Lcc:         br.Lcc.OrEmpty(),
+		Ddc:         br.Ddc.OrEmpty(),
+		Description: br.Description.OrEmpty(),
+		Language:    br.Language.OrEmpty(),
+		CoverUrl:    br.CoverUrl.OrEmpty(),
+		Confidence:  br.Confidence,
+		Provenance:  br.Provenance,
 	}
 }
-
-func ChooseBestResult(results []BookResult) (*BookResult, error) {
-	if len(results) == 0 {
-		return nil, fmt.Errorf("no results")
-	}
-
-	highestConfidence := 0.0
-	var bestBook *BookResult = nil
-
-	for _, br := range results {
-		confidence := br.Confidence
-		if math.IsNaN(confidence) {
-			continue
-		}
-
-		if confidence > highestConfidence {
-			highestConfidence = confidence
-			bestBook = &br
-		}
-	}
-
-	if bestBook == nil {
-		return nil, fmt.Errorf("none of the results had a confidence %v", results)
-	}
-
-	return bestBook, nil
-}
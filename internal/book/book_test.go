@@ -1,7 +1,11 @@
 package book_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/larkwiot/booker/internal/book"
+	"github.com/samber/mo"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -20,6 +24,29 @@ func TestIsbn10Validity(t *testing.T) {
 	assert.True(t, isbn.IsValid())
 }
 
+func TestIsbn13ToIsbn10(t *testing.T) {
+	isbn := book.ISBN13("9781718501263")
+	isbn10, ok := isbn.Isbn10()
+	assert.True(t, ok)
+	assert.Equal(t, book.ISBN10("1718501269"), isbn10)
+
+	isbn = book.ISBN13("9798886450000")
+	_, ok = isbn.Isbn10()
+	assert.False(t, ok)
+}
+
+func TestAsinValidity(t *testing.T) {
+	asin := book.ASIN("B00B7NPRY8")
+	assert.True(t, asin.IsValid())
+	asin = book.ASIN("1718501269")
+	assert.True(t, asin.IsValid())
+
+	asin = book.ASIN("B00B7NPRY")
+	assert.False(t, asin.IsValid())
+	asin = book.ASIN("A00B7NPRY8")
+	assert.False(t, asin.IsValid())
+}
+
 func TestIsbn13Validity(t *testing.T) {
 	isbn := book.ISBN13("9781718501263")
 	assert.True(t, isbn.IsValid())
@@ -29,3 +56,79 @@ func TestIsbn13Validity(t *testing.T) {
 	isbn = book.ISBN13("1234567891123")
 	assert.False(t, isbn.IsValid())
 }
+
+func TestAgrees(t *testing.T) {
+	embedded := book.BookResult{Title: mo.Some("The Go Programming Language")}
+
+	found := book.BookResult{Title: mo.Some("The Go programming language: a guide"), Isbn13: mo.Some(book.ISBN13("9780134190440"))}
+	assert.True(t, found.Agrees(&embedded))
+
+	other := book.BookResult{Title: mo.Some("The Rust Programming Language")}
+	assert.False(t, other.Agrees(&embedded))
+
+	embedded.Isbn13 = mo.Some(book.ISBN13("9780134190440"))
+	other.Isbn13 = mo.Some(book.ISBN13("9780134190440"))
+	assert.True(t, other.Agrees(&embedded))
+
+	assert.False(t, (&book.BookResult{}).Agrees(&book.BookResult{}))
+}
+
+func TestCollate(t *testing.T) {
+	isbn := mo.Some(book.ISBN13("9780134190440"))
+	results := []book.BookResult{
+		{Title: mo.Some("The Go Programming Language"), Isbn13: isbn, Confidence: 100, SourceProviderName: "google"},
+		{Title: mo.Some("The Go Programming Language"), Isbn13: isbn, Authors: mo.Some([]string{"Alan A. A. Donovan", "Brian W. Kernighan"}), Publisher: mo.Some("Addison-Wesley"), Confidence: 100, SourceProviderName: "isbndb"},
+		{Title: mo.Some("The Rust Programming Language"), Authors: mo.Some([]string{"Steve Klabnik"}), Confidence: 75, SourceProviderName: "worldcat"},
+	}
+
+	policy, err := book.NewCollationPolicy(nil, nil, "")
+	assert.NoError(t, err)
+	result, err := policy.Collate(results)
+	assert.NoError(t, err)
+	assert.Equal(t, "google", result.SourceProviderName)
+
+	policy, _ = book.NewCollationPolicy(nil, nil, book.TieBreakerCompleteness)
+	result, _ = policy.Collate(results)
+	assert.Equal(t, "isbndb", result.SourceProviderName)
+
+	policy, _ = book.NewCollationPolicy(map[string]float64{"Google": 0.5}, nil, "")
+	result, _ = policy.Collate(results)
+	assert.Equal(t, "isbndb", result.SourceProviderName)
+	assert.Equal(t, 100.0, result.Confidence)
+
+	// fields are only taken from results about the same book
+	policy, _ = book.NewCollationPolicy(nil, map[string][]string{"authors": {"worldcat", "isbndb"}, "publisher": {"google"}}, "")
+	result, _ = policy.Collate(results)
+	assert.Equal(t, "google", result.SourceProviderName)
+	assert.Equal(t, []string{"Alan A. A. Donovan", "Brian W. Kernighan"}, result.Authors.OrEmpty())
+	assert.Equal(t, "isbndb", result.Provenance["authors"])
+
+	// what the kept result is missing comes from the others with the same ISBN, never from other books
+	assert.Equal(t, "Addison-Wesley", result.Publisher.OrEmpty())
+	assert.Equal(t, map[string]string{"title": "google", "isbn13": "google", "authors": "isbndb", "publisher": "isbndb"}, result.Provenance)
+
+	policy, _ = book.NewCollationPolicy(map[string]float64{"worldcat": 2}, nil, "")
+	result, _ = policy.Collate(results)
+	assert.Equal(t, []string{"Steve Klabnik"}, result.Authors.OrEmpty())
+	assert.True(t, result.Publisher.IsAbsent())
+
+	_, err = book.NewCollationPolicy(nil, map[string][]string{"author": {"google"}}, "")
+	assert.Error(t, err)
+}
+
+func TestErrorCodes(t *testing.T) {
+	err := book.NewError(book.ErrorNoResults, fmt.Errorf("error: no results found"))
+	assert.Equal(t, book.ErrorNoResults, book.ErrorCodeOf(err))
+	assert.Equal(t, book.ErrorNoResults, book.ErrorCodeOf(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, book.ErrorFailed, book.ErrorCodeOf(errors.New("anything else")))
+	// running out of time is what matters, whatever the stage made of it
+	assert.Equal(t, book.ErrorTimeout, book.ErrorCodeOf(errors.Join(err, context.DeadlineExceeded)))
+
+	var bk book.Book
+	bk.SetError(err)
+	assert.Equal(t, "error: no results found", bk.ErrorMessage)
+	assert.Equal(t, book.ErrorNoResults, bk.ErrorCode)
+	bk.ClearError()
+	assert.Empty(t, bk.ErrorMessage)
+	assert.Empty(t, bk.ErrorCode)
+}
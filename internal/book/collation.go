@@ -0,0 +1,210 @@
+package book
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/samber/mo"
+	"math"
+	"slices"
+	"strings"
+)
+
+const (
+	// TieBreakerOrder keeps the first of the results tied for the highest confidence, embedded metadata first and then
+	// providers in the order they were enabled
+	TieBreakerOrder = "order"
+	// TieBreakerCompleteness keeps whichever of the tied results has the most fields filled in
+	TieBreakerCompleteness = "completeness"
+)
+
+func copyOption[T any](dst *mo.Option[T], src mo.Option[T]) bool {
+	if src.IsAbsent() {
+		return false
+	}
+	*dst = src
+	return true
+}
+
+// resultFields copies each field of a result by its name in the output, reporting whether src had it to copy
+var resultFields = map[string]func(dst, src *BookResult) bool{
+	"title":        func(dst, src *BookResult) bool { return copyOption(&dst.Title, src.Title) },
+	"authors":      func(dst, src *BookResult) bool { return copyOption(&dst.Authors, src.Authors) },
+	"editors":      func(dst, src *BookResult) bool { return copyOption(&dst.Editors, src.Editors) },
+	"isbn10":       func(dst, src *BookResult) bool { return copyOption(&dst.Isbn10, src.Isbn10) },
+	"isbn13":       func(dst, src *BookResult) bool { return copyOption(&dst.Isbn13, src.Isbn13) },
+	"uom":          func(dst, src *BookResult) bool { return copyOption(&dst.Uom, src.Uom) },
+	"oclc":         func(dst, src *BookResult) bool { return copyOption(&dst.Oclc, src.Oclc) },
+	"doi":          func(dst, src *BookResult) bool { return copyOption(&dst.Doi, src.Doi) },
+	"asin":         func(dst, src *BookResult) bool { return copyOption(&dst.Asin, src.Asin) },
+	"arxiv":        func(dst, src *BookResult) bool { return copyOption(&dst.Arxiv, src.Arxiv) },
+	"low_year":     func(dst, src *BookResult) bool { return copyOption(&dst.LowYear, src.LowYear) },
+	"high_year":    func(dst, src *BookResult) bool { return copyOption(&dst.HighYear, src.HighYear) },
+	"publish_date": func(dst, src *BookResult) bool { return copyOption(&dst.PublishDate, src.PublishDate) },
+	"publisher":    func(dst, src *BookResult) bool { return copyOption(&dst.Publisher, src.Publisher) },
+	"edition":      func(dst, src *BookResult) bool { return copyOption(&dst.Edition, src.Edition) },
+	"binding":      func(dst, src *BookResult) bool { return copyOption(&dst.Binding, src.Binding) },
+	"pages":        func(dst, src *BookResult) bool { return copyOption(&dst.Pages, src.Pages) },
+	"series":       func(dst, src *BookResult) bool { return copyOption(&dst.Series, src.Series) },
+	"series_index": func(dst, src *BookResult) bool { return copyOption(&dst.SeriesIndex, src.SeriesIndex) },
+	"subjects":     func(dst, src *BookResult) bool { return copyOption(&dst.Subjects, src.Subjects) },
+	"lcc":          func(dst, src *BookResult) bool { return copyOption(&dst.Lcc, src.Lcc) },
+	"ddc":          func(dst, src *BookResult) bool { return copyOption(&dst.Ddc, src.Ddc) },
+	"description":  func(dst, src *BookResult) bool { return copyOption(&dst.Description, src.Description) },
+	"language":     func(dst, src *BookResult) bool { return copyOption(&dst.Language, src.Language) },
+	"cover_url":    func(dst, src *BookResult) bool { return copyOption(&dst.CoverUrl, src.CoverUrl) },
+}
+
+func (br *BookResult) hasField(field string) bool {
+	return resultFields[field](&BookResult{}, br)
+}
+
+// completeness counts the fields a result has filled in
+func (br *BookResult) completeness() int {
+	count := 0
+	for field := range resultFields {
+		if br.hasField(field) {
+			count++
+		}
+	}
+	return count
+}
+
+// Merge fills in the fields br is missing with those of other, recording other as their source
+func (br *BookResult) Merge(other *BookResult) {
+	for field, copyField := range resultFields {
+		if !br.hasField(field) && copyField(br, other) {
+			br.setProvenance(field, other.SourceProviderName)
+		}
+	}
+}
+
+func (br *BookResult) setProvenance(field string, source string) {
+	if br.Provenance == nil {
+		br.Provenance = make(map[string]string)
+	}
+	br.Provenance[field] = source
+}
+
+// CollationPolicy decides which of the results for a book is kept, and which of its fields are taken from other
+// results for the same book instead
+type CollationPolicy struct {
+	weights    map[string]float64
+	fields     map[string][]string
+	tieBreaker string
+}
+
+// NewCollationPolicy makes a policy that weighs the confidence of each source's results by weights, a missing source
+// weighing 1, and takes each of fields from the first of its sources that found the same book as the kept result
+func NewCollationPolicy(weights map[string]float64, fields map[string][]string, tieBreaker string) (*CollationPolicy, error) {
+	cp := CollationPolicy{
+		weights:    make(map[string]float64),
+		fields:     make(map[string][]string),
+		tieBreaker: tieBreaker,
+	}
+
+	for source, weight := range weights {
+		cp.weights[strings.ToLower(source)] = weight
+	}
+	for field, sources := range fields {
+		if _, known := resultFields[field]; !known {
+			return nil, fmt.Errorf("collation.fields has unknown field %s", field)
+		}
+		for _, source := range sources {
+			cp.fields[field] = append(cp.fields[field], strings.ToLower(source))
+		}
+	}
+
+	switch tieBreaker {
+	case "":
+		cp.tieBreaker = TieBreakerOrder
+	case TieBreakerOrder, TieBreakerCompleteness:
+	default:
+		return nil, fmt.Errorf("unknown collation tie breaker %s", tieBreaker)
+	}
+
+	return &cp, nil
+}
+
+func (cp *CollationPolicy) weigh(br *BookResult) float64 {
+	if weight, exists := cp.weights[strings.ToLower(br.SourceProviderName)]; exists {
+		return br.Confidence * weight
+	}
+	return br.Confidence
+}
+
+// Collate picks the result with the highest weighted confidence, then fills in the fields the policy prefers other
+// sources for. Fields it is still missing are taken from the other results with the same identifier, the most
+// confident first.
+func (cp *CollationPolicy) Collate(results []BookResult) (*BookResult, error) {
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no results")
+	}
+
+	highestConfidence := 0.0
+	var best *BookResult = nil
+
+	for idx := range results {
+		br := &results[idx]
+		confidence := cp.weigh(br)
+		if math.IsNaN(confidence) {
+			continue
+		}
+
+		if confidence > highestConfidence {
+			highestConfidence = confidence
+			best = br
+		} else if confidence == highestConfidence && best != nil && cp.tieBreaker == TieBreakerCompleteness && br.completeness() > best.completeness() {
+			best = br
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("none of the results had a confidence %v", results)
+	}
+
+	collated := *best
+	collated.Confidence = highestConfidence
+	collated.Provenance = nil
+	for field := range resultFields {
+		if best.hasField(field) {
+			collated.setProvenance(field, best.SourceProviderName)
+		}
+	}
+
+	for field, sources := range cp.fields {
+		copyField := resultFields[field]
+		for _, source := range sources {
+			if source == strings.ToLower(best.SourceProviderName) && best.hasField(field) {
+				break
+			}
+			found := false
+			for idx := range results {
+				other := &results[idx]
+				if strings.ToLower(other.SourceProviderName) == source && other.Agrees(best) && copyField(&collated, other) {
+					collated.setProvenance(field, other.SourceProviderName)
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+	}
+
+	ranked := make([]*BookResult, 0, len(results))
+	for idx := range results {
+		other := &results[idx]
+		if other != best && !math.IsNaN(cp.weigh(other)) && other.SharesIdentifier(best) {
+			ranked = append(ranked, other)
+		}
+	}
+	slices.SortStableFunc(ranked, func(a, b *BookResult) int {
+		return cmp.Compare(cp.weigh(b), cp.weigh(a))
+	})
+	for _, other := range ranked {
+		collated.Merge(other)
+	}
+
+	return &collated, nil
+}
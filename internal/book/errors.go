@@ -0,0 +1,75 @@
+package book
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrorCode says what kind of failure a book had, so that the failures of one kind can be picked out of the output
+type ErrorCode string
+
+const (
+	// ErrorExtractFailed is for books none of whose text or metadata could be read
+	ErrorExtractFailed ErrorCode = "extract_failed"
+	// ErrorNoIsbnFound is for books with neither an identifier nor a title to search for
+	ErrorNoIsbnFound ErrorCode = "no_isbn_found"
+	// ErrorNoResults is for books none of the providers found
+	ErrorNoResults ErrorCode = "no_results"
+	// ErrorRateLimited is for books that weren't found while some of the providers were rate limited, so might be later
+	ErrorRateLimited ErrorCode = "rate_limited"
+	// ErrorTimeout is for books that took longer than advanced.timeout_seconds in a stage
+	ErrorTimeout ErrorCode = "timeout"
+	// ErrorNeedsReview is for books whose best result scored under the minimum confidence
+	ErrorNeedsReview ErrorCode = "needs_review"
+	// ErrorFailed is for every other failure
+	ErrorFailed ErrorCode = "failed"
+)
+
+// ErrorCodes are all the codes, for checking those given by name
+var ErrorCodes = []ErrorCode{
+	ErrorExtractFailed, ErrorNoIsbnFound, ErrorNoResults, ErrorRateLimited, ErrorTimeout, ErrorNeedsReview, ErrorFailed,
+}
+
+// codedError is an error with the code the book it failed is recorded under
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// NewError gives err the code the book it fails is recorded under
+func NewError(code ErrorCode, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+// ErrorCodeOf is the code a book that failed with err is recorded under: timeout if it ran out of time, the code it was
+// given by NewError, and otherwise failed
+func ErrorCodeOf(err error) ErrorCode {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return ErrorFailed
+}
+
+// SetError records that the book failed with err
+func (b *Book) SetError(err error) {
+	b.ErrorMessage = err.Error()
+	b.ErrorCode = ErrorCodeOf(err)
+}
+
+// ClearError records that the book didn't fail after all
+func (b *Book) ClearError() {
+	b.ErrorMessage = ""
+	b.ErrorCode = ""
+}
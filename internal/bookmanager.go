@@ -1,21 +1,40 @@
 package internal
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/larkwiot/booker/internal/archive"
 	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/calibre"
 	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/covers"
+	"github.com/larkwiot/booker/internal/embed"
 	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/larkwiot/booker/internal/httpclient"
+	"github.com/larkwiot/booker/internal/intake"
 	"github.com/larkwiot/booker/internal/pipeline"
+	"github.com/larkwiot/booker/internal/providercache"
 	"github.com/larkwiot/booker/internal/providers"
+	"github.com/larkwiot/booker/internal/review"
+	"github.com/larkwiot/booker/internal/scoring"
 	"github.com/larkwiot/booker/internal/service"
+	"github.com/larkwiot/booker/internal/stats"
 	"github.com/larkwiot/booker/internal/util"
+	"github.com/larkwiot/booker/internal/webdav"
+	"github.com/larkwiot/booker/internal/webhooks"
 	"github.com/samber/lo"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -24,7 +43,11 @@ import (
 var acceptedFileTypes = []string{
 	".pdf",
 	".epub",
+	".djvu",
+	".djv",
 	".mobi",
+	".azw",
+	".azw3",
 	".chm",
 	".htm",
 	".html",
@@ -33,17 +56,58 @@ var acceptedFileTypes = []string{
 	".txt",
 	".doc",
 	".docx",
+	".cbz",
+	".cbr",
 }
 
+// healthCheckInterval is how often the extractors and providers are checked, and outageCheckInterval how often a scan
+// paused by an outage looks for one that is back
+var healthCheckInterval = 15 * time.Second
+var outageCheckInterval = time.Second
+
 type BookManager struct {
 	providers         []providers.Provider
 	extractors        []extractors.Extractor
 	pipe              *pipeline.Pipeline
-	maxCharacters     uint
+	textLimit         extractors.TextLimit
 	bookStateLock     *sync.RWMutex
 	books             map[string]book.Book
+	booksByHash       map[string]book.Book
+	hashOwners        map[string]string
+	stale             map[string]book.Book
 	dryRun            bool
+	dryRunEntries     map[string]DryRunEntry
+	identifiersWriter util.ObjectWriter[*Identifiers]
+	dispatcher        Dispatcher
+	offline           bool
+	embedMetadata     bool
+	covers            *covers.Fetcher
+	httpClient        *http.Client
+	webdav            *webdav.Client
+	classifyText      bool
+	scanArchives      bool
+	followSymlinks    bool
+	oneFileSystem     bool
+	maxDepth          uint
+	maxFiles          uint
+	commandFileTypes  []string
+	filter            pathFilter
+	collation         *book.CollationPolicy
+	nameOrder         string
+	checkpoint        *checkpoint
+	checkpointPath    string
+	minConfidence     float64
+	earlyExit         float64
+	outageTimeout     time.Duration
+	outageLock        sync.Mutex
+	outages           map[string]*outage
+	maxIsbnLookups    uint
+	debugDir          string
+	providerCache     *providercache.Cache
+	reviewWriter      util.ObjectWriter[*review.Entry]
+	webhooks          *webhooks.Notifier
 	writer            util.ObjectWriter[*book.Book]
+	stats             *stats.Stats
 	extractorsManager *service.ServiceManager
 	providersManager  *service.ServiceManager
 }
@@ -55,32 +119,171 @@ func NewBookManager(conf *config.Config, threads int64) (*BookManager, error) {
 	}
 
 	var bm = BookManager{
-		providers:         make([]providers.Provider, 0),
-		extractors:        make([]extractors.Extractor, 0),
-		maxCharacters:     conf.Advanced.MaxCharactersToSearchForIsbn,
+		providers:  make([]providers.Provider, 0),
+		extractors: make([]extractors.Extractor, 0),
+		textLimit: extractors.TextLimit{
+			Head: conf.Advanced.MaxCharactersToSearchForIsbn,
+			Tail: conf.Advanced.TailCharactersToSearchForIsbn,
+		},
 		bookStateLock:     &sync.RWMutex{},
 		books:             make(map[string]book.Book),
+		booksByHash:       make(map[string]book.Book),
+		hashOwners:        make(map[string]string),
+		stale:             make(map[string]book.Book),
 		dryRun:            false,
-		extractorsManager: service.NewServiceManager(15 * time.Second),
-		providersManager:  service.NewServiceManager(15 * time.Second),
+		offline:           conf.Offline,
+		filter:            newPathFilter(&conf.Scan),
+		scanArchives:      conf.Scan.Archives,
+		followSymlinks:    conf.Scan.FollowSymlinks,
+		oneFileSystem:     conf.Scan.OneFileSystem,
+		maxDepth:          conf.Scan.MaxDepth,
+		maxFiles:          conf.Scan.MaxFiles,
+		classifyText:      conf.Advanced.ClassificationFromText,
+		earlyExit:         conf.Advanced.EarlyExitConfidence,
+		outageTimeout:     time.Duration(conf.Advanced.OutageTimeoutSeconds) * time.Second,
+		outages:           make(map[string]*outage),
+		nameOrder:         conf.Collation.NameOrder,
+		maxIsbnLookups:    conf.Advanced.MaxIsbnLookupsPerFile,
+		extractorsManager: service.NewServiceManager(healthCheckInterval),
+		providersManager:  service.NewServiceManager(healthCheckInterval),
+	}
+
+	bm.httpClient, err = httpclient.New(&conf.Http)
+	if err != nil {
+		return nil, err
+	}
+	bm.webhooks = webhooks.NewNotifier(&conf.Webhooks, bm.httpClient)
+	if conf.Webdav.Enable {
+		bm.webdav = webdav.NewClient(&conf.Webdav, bm.httpClient)
+	}
+
+	bm.collation, err = book.NewCollationPolicy(conf.Collation.Weights, conf.Collation.Fields, conf.Collation.TieBreaker)
+	if err != nil {
+		return nil, err
 	}
 
+	var tika *extractors.TikaCluster
 	if conf.Tika.Enable {
-		bm.extractors = append(bm.extractors, extractors.NewTikaServer(&conf.Tika))
+		tika = extractors.NewTikaCluster(&conf.Tika, httpclient.WithoutTimeout(bm.httpClient))
+		bm.extractors = append(bm.extractors, tika)
+	}
+
+	if conf.Epub.Enable {
+		bm.extractors = append(bm.extractors, extractors.NewEpubExtractor())
+	}
+
+	if conf.Pdf.Enable {
+		bm.extractors = append(bm.extractors, extractors.NewPdfExtractor())
+	}
+
+	if conf.Pdf.Metadata {
+		bm.extractors = append(bm.extractors, extractors.NewPdfMetadataExtractor())
 	}
 
-	if conf.Google.Enable {
-		bm.providers = append(bm.providers, providers.NewGoogle(&conf.Google))
+	if conf.Mobi.Enable {
+		bm.extractors = append(bm.extractors, extractors.NewMobiExtractor())
+	}
+
+	if conf.Djvu.Enable {
+		bm.extractors = append(bm.extractors, extractors.NewDjvuExtractor(&conf.Djvu))
+	}
+
+	for idx := range conf.Commands {
+		bm.extractors = append(bm.extractors, extractors.NewCommandExtractor(&conf.Commands[idx]))
+		bm.commandFileTypes = append(bm.commandFileTypes, conf.Commands[idx].Extensions...)
+	}
+
+	if conf.Comic.Enable {
+		bm.extractors = append(bm.extractors, extractors.NewComicExtractor())
+	}
+
+	// offline, books are only identified by their own metadata and the cache
+	if !bm.offline {
+		for idx := range conf.Google {
+			if conf.Google[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewGoogle(&conf.Google[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Isbndb {
+			if conf.Isbndb[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewIsbndb(&conf.Isbndb[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Worldcat {
+			if conf.Worldcat[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewWorldcat(&conf.Worldcat[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Crossref {
+			if conf.Crossref[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewCrossref(&conf.Crossref[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Springer {
+			if conf.Springer[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewSpringer(&conf.Springer[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Arxiv {
+			if conf.Arxiv[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewArxiv(&conf.Arxiv[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Amazon {
+			if conf.Amazon[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewAmazon(&conf.Amazon[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Comicvine {
+			if conf.Comicvine[idx].Enable {
+				bm.providers = append(bm.providers, providers.NewComicvine(&conf.Comicvine[idx], bm.httpClient))
+			}
+		}
+
+		for idx := range conf.Sru {
+			bm.providers = append(bm.providers, providers.NewSru(&conf.Sru[idx], bm.httpClient))
+		}
+
+		for idx := range conf.Plugins {
+			bm.providers = append(bm.providers, providers.NewExternalProvider(&conf.Plugins[idx]))
+		}
+
+		if conf.ProviderCache.Enable {
+			day := 24 * time.Hour
+			bm.providerCache, err = providercache.Open(conf.ProviderCache.Path, time.Duration(conf.ProviderCache.IdentifierTtlDays)*day, time.Duration(conf.ProviderCache.QueryTtlDays)*day)
+			if err != nil {
+				return nil, err
+			}
+			for _, provider := range bm.providers {
+				if generic, ok := provider.(*providers.Generic); ok {
+					generic.SetStore(bm.providerCache)
+				}
+			}
+		}
 	}
 
 	if len(bm.extractors) == 0 {
 		return nil, fmt.Errorf("at least one extractor must be enabled")
 	}
 
-	if len(bm.providers) == 0 {
+	if len(bm.providers) == 0 && !bm.offline {
 		return nil, fmt.Errorf("at least one provider must be enabled")
 	}
 
+	if tika != nil {
+		err = tika.Start()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, extractor := range bm.extractors {
 		bm.extractorsManager.Manage(extractor)
 	}
@@ -90,27 +293,40 @@ func NewBookManager(conf *config.Config, threads int64) (*BookManager, error) {
 
 	if threads == 0 {
 		threads = int64(bm.bestThreadCount())
-		log.Printf("info: determined best thread count as: %d\n", threads)
+		slog.Info("determined best thread count", "threads", threads)
 	}
 	if threads > 2000 {
 		threads = 2000
-		log.Printf("info: capping thread count to %d\n", threads)
+		slog.Info("capping thread count", "threads", threads)
 	}
 	if threads&1 > 0 {
-		log.Printf("info: making thread count even to %d\n", threads)
+		slog.Info("making thread count even", "threads", threads)
 		threads += 1
 	}
 
 	bm.pipe = pipeline.NewPipeline(threads)
+	bm.pipe.SetItemTimeout(time.Duration(conf.Advanced.TimeoutSeconds) * time.Second)
+	// books on a WebDAV server are downloaded by a stage of their own, so that the next ones are on their way while
+	// others are read
+	if bm.webdav != nil {
+		bm.pipe.AppendStage("download", bm.download)
+	}
 	bm.pipe.AppendStage("extract", bm.extract)
-	bm.pipe.AppendStage("search", bm.search)
+	bm.pipe.AppendStage("heuristics", bm.heuristics)
+	// a search mostly waits on the providers, so it has more books going at once than the stages kept busy by the CPU
+	bm.pipe.AppendWeightedStage("search", searchStageWeight, bm.search)
 	bm.pipe.AppendStage("collate", bm.collate)
+	bm.pipe.AppendStage("cover", bm.cover)
 	bm.pipe.CollectorStage(bm.finishBook)
 
 	return &bm, nil
 }
 
 func (bm *BookManager) Shutdown() {
+	if bm.checkpoint != nil {
+		bm.checkpoint.close()
+		bm.checkpoint = nil
+	}
 	for _, provider := range bm.providers {
 		provider.Shutdown()
 	}
@@ -118,22 +334,52 @@ func (bm *BookManager) Shutdown() {
 		extractor.Shutdown()
 	}
 	bm.pipe.Wait()
+	if bm.providerCache != nil {
+		bm.providerCache.Close()
+	}
+	bm.webhooks.Close()
+	bm.webhooks = nil
 }
 
+// searchStageWeight is how many times the threads of each other stage the search stage gets
+const searchStageWeight = 3
+
 func (bm *BookManager) bestThreadCount() int {
+	if bm.offline {
+		// nothing waits on a provider, so extraction is all there is to keep busy
+		return runtime.NumCPU()
+	}
 	if len(bm.providers) == 0 {
-		log.Printf("warning: cannot calculate best thread count without any providers initialized. Please create an issue for this")
+		slog.Warn("cannot calculate best thread count without any providers initialized. Please create an issue for this")
 		return 0
 	}
-	return (runtime.NumCPU() / len(bm.extractors)) * len(bm.providers)
+	// at least a thread for every provider, however many extractors share the CPUs
+	return max(runtime.NumCPU()/max(len(bm.extractors), 1), 1) * len(bm.providers)
 }
 
 func (bm *BookManager) finishBook(b any) {
-	if bm.writer == nil {
+	switch stopped := b.(type) {
+	case DryRunEntry:
+		bm.stats.Finish(stopped.Filepath, len(stopped.ErrorMessage) == 0)
+		bm.recordDryRun(stopped)
+		return
+	case Identifiers:
+		bm.stats.Finish(stopped.Filepath, len(stopped.ErrorMessage) == 0)
+		bm.identifiersWriter.WriteObject(&stopped)
 		return
 	}
 
 	bk := b.(book.Book)
+	bm.stats.Finish(bk.Filepath, len(bk.ErrorMessage) == 0)
+	bm.writeBook(bk)
+	bm.webhooks.BookFinished(&bk)
+}
+
+// writeBook writes a book to the output and records it as processed
+func (bm *BookManager) writeBook(bk book.Book) {
+	if bm.writer == nil {
+		return
+	}
 
 	if bm.isBookProcessed(bk.Filepath) {
 		//log.Printf("error: book %s was already processed\n", bk.Filepath)
@@ -144,7 +390,34 @@ func (bm *BookManager) finishBook(b any) {
 	defer bm.bookStateLock.Unlock()
 
 	bm.writer.WriteObject(&bk)
+	if bm.checkpoint != nil {
+		bm.checkpoint.write(&bk)
+	}
 	bm.books[bk.Filepath] = bk
+	delete(bm.stale, bk.Filepath)
+	if len(bk.Hash) > 0 {
+		if _, exists := bm.booksByHash[bk.Hash]; !exists {
+			bm.booksByHash[bk.Hash] = bk
+		}
+	}
+}
+
+// claimHash records filePath as the first file seen with hash, returning whichever file claimed it first
+func (bm *BookManager) claimHash(hash string, filePath string) string {
+	bm.bookStateLock.Lock()
+	defer bm.bookStateLock.Unlock()
+	if owner, claimed := bm.hashOwners[hash]; claimed {
+		return owner
+	}
+	bm.hashOwners[hash] = filePath
+	return filePath
+}
+
+func (bm *BookManager) getProcessedBookByHash(hash string) (book.Book, bool) {
+	bm.bookStateLock.RLock()
+	defer bm.bookStateLock.RUnlock()
+	bk, isProcessed := bm.booksByHash[hash]
+	return bk, isProcessed
 }
 
 func (bm *BookManager) isBookProcessed(filePath string) bool {
@@ -172,152 +445,1015 @@ func (bm *BookManager) getProcessedBookCount() uint64 {
 	return uint64(len(bm.books))
 }
 
+// StartDryRun stops books before they are searched, reporting what was found in them to DryRunReport instead
 func (bm *BookManager) StartDryRun() {
 	bm.dryRun = true
+	bm.dryRunEntries = make(map[string]DryRunEntry)
 }
 
 func (bm *BookManager) EndDryRun() {
 	bm.dryRun = false
 }
 
+// SetEmbedMetadata makes the book manager write the metadata it finds back into the books that support it
+func (bm *BookManager) SetEmbedMetadata(embedMetadata bool) {
+	bm.embedMetadata = embedMetadata
+}
+
+// SetCoversDir makes the book manager download the cover of every book it finds into dir, along with a thumbnail
+func (bm *BookManager) SetCoversDir(dir string) error {
+	fetcher, err := covers.NewFetcher(dir, bm.httpClient)
+	if err != nil {
+		return err
+	}
+	bm.covers = fetcher
+	return nil
+}
+
 func (bm *BookManager) IsDryRun() bool {
 	return bm.dryRun
 }
 
-func (bm *BookManager) Scan(scanPath string, dryRun bool, writer util.ObjectWriter[*book.Book]) {
-	scanPath, err := filepath.Abs(util.ExpandUser(scanPath))
-	if err != nil {
-		log.Printf("error: could not get absolute scan path: %s\n", err.Error())
-		return
+// Interrupt stops the current scan from submitting new books, books already in flight are still finished and written
+func (bm *BookManager) Interrupt() {
+	bm.pipe.Interrupt()
+}
+
+func (bm *BookManager) isAcceptedFile(d fs.DirEntry) bool {
+	if d.Type() == os.ModeSymlink {
+		return false
 	}
 
-	if exists, err := util.PathExists(scanPath); !exists {
-		log.Printf("error: could not stat scan path: %s\n", err)
-		return
+	return bm.isAcceptedName(d.Name())
+}
+
+func (bm *BookManager) isAcceptedName(name string) bool {
+	ext := filepath.Ext(name)
+	if !lo.Contains(acceptedFileTypes, ext) && !lo.Contains(bm.commandFileTypes, strings.ToLower(ext)) {
+		//log.Printf("%s is not an accepted filetype\n", ext)
+		return false
 	}
 
+	return true
+}
+
+// Start runs the pipeline, writing every book submitted from then on to writer until Stop is called
+func (bm *BookManager) Start(ctx context.Context, dryRun bool, writer util.ObjectWriter[*book.Book]) {
 	bm.writer = writer
-	defer func() {
-		bm.writer.Close()
-		bm.writer = nil
-	}()
+	bm.stats = stats.New()
+	bm.startCheckpoint()
 
 	if dryRun {
 		bm.StartDryRun()
-		defer bm.EndDryRun()
 	}
 
-	log.Printf("book manager: preparing to scan with %d threads\n", bm.pipe.TotalThreadCount)
+	slog.Info("preparing to scan", "threads", bm.pipe.TotalThreadCount)
 
 	// write any existing books back out (mainly if we imported a cache)
 	for _, bk := range bm.books {
 		bm.writer.WriteObject(&bk)
 	}
 
-	log.Printf("book manager: loaded %d cached entries\n", bm.getProcessedBookCount())
+	slog.Info("loaded cached entries", "entries", bm.getProcessedBookCount())
 
-	log.Printf("book manager: beginning scan on %s\n", scanPath)
+	bm.pipe.Run(ctx, bm.failHandler)
+}
 
-	bm.pipe.Run(bm.failHandler)
+// walkBooks calls visit with the absolute path of every accepted book under scanPath that hasn't been processed yet,
+// stopping early once visit returns false. What it finds and skips is counted to counts, if not nil.
+func (bm *BookManager) walkBooks(scanPath string, counts *stats.Stats, visit func(path string) bool) error {
+	if webdav.IsUrl(scanPath) {
+		return bm.walkWebdav(scanPath, counts, visit)
+	}
+	walk := &bookWalk{bm: bm, scanPath: scanPath, counts: counts, visited: make(map[string]bool), files: make(map[string]uint)}
+	walk.visit = func(path string) bool {
+		walk.stopped = !visit(path)
+		return !walk.stopped
+	}
+	root := scanPath
+	if bm.followSymlinks {
+		// the scan path can be a symlink to the library itself
+		if real, err := filepath.EvalSymlinks(scanPath); err == nil {
+			root = real
+		}
+	}
+	if bm.oneFileSystem {
+		if info, err := os.Stat(root); err == nil {
+			walk.device = deviceId(root, info)
+		}
+	}
+	return walk.walk(scanPath, root)
+}
 
-	bookCount := bm.getProcessedBookCount()
+// bookWalk is a walk of the books under a scan path. Every directory is walked once, even if a bind mount or a symlink
+// makes it appear more than once, so a symlink to a directory that has already been walked, like one to a parent, is
+// skipped.
+type bookWalk struct {
+	bm       *BookManager
+	scanPath string
+	counts   *stats.Stats
+	visit    func(path string) bool
+	// visited has the fileId of every directory walked, and of every book when symlinks are followed
+	visited map[string]bool
+	// files is how many entries other than directories have been walked in each directory, for scan.max_files
+	files map[string]uint
+	// device is the deviceId of the scan path with --one-file-system
+	device  string
+	stopped bool
+}
 
-	err = filepath.WalkDir(scanPath, func(path string, d fs.DirEntry, err error) error {
-		if d.IsDir() {
+// note warns about what the walk skipped, only in the walk that counts the books, since a scan on disk walks twice
+func (w *bookWalk) note(msg string, args ...any) {
+	if w.counts != nil {
+		slog.Warn(msg, args...)
+	}
+}
+
+// depth is how many directories below the scan path dirPath is
+func (w *bookWalk) depth(dirPath string) uint {
+	rel, err := filepath.Rel(w.scanPath, dirPath)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return uint(strings.Count(rel, string(filepath.Separator)) + 1)
+}
+
+// first is whether the file or directory at path hasn't been walked through another path, marking it walked
+func (w *bookWalk) first(path string, info fs.FileInfo) bool {
+	id := fileId(path, info)
+	if w.visited[id] {
+		return false
+	}
+	w.visited[id] = true
+	return true
+}
+
+// walk walks the directory at realPath as if it were at dirPath, which is where the symlink to it is when they differ
+func (w *bookWalk) walk(dirPath string, realPath string) error {
+	return filepath.WalkDir(realPath, func(walked string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// only a scan path that can't be read fails the scan, anything below it that can't is skipped
+			if dirPath == w.scanPath && walked == realPath {
+				return err
+			}
+			w.note("skipping what could not be read", "path", walked, "error", err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
+		path := walked
+		if dirPath != realPath {
+			rel, err := filepath.Rel(realPath, walked)
+			if err != nil {
+				return err
+			}
+			path = filepath.Join(dirPath, rel)
+		}
 
-		if d.Type() == os.ModeSymlink {
+		if d.IsDir() {
+			if w.bm.filter.skipDir(w.scanPath, path) {
+				return filepath.SkipDir
+			}
+			if w.bm.maxDepth > 0 && w.depth(path) > w.bm.maxDepth {
+				return filepath.SkipDir
+			}
+			info, err := d.Info()
+			if err != nil {
+				w.note("skipping what could not be read", "path", walked, "error", err)
+				return filepath.SkipDir
+			}
+			if len(w.device) > 0 && deviceId(walked, info) != w.device {
+				w.note("skipping directory on another file system", "path", path)
+				return filepath.SkipDir
+			}
+			if !w.first(walked, info) {
+				slog.Debug("skipping directory already scanned through another path", "path", path)
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
-		ext := filepath.Ext(d.Name())
-		if !lo.Contains(acceptedFileTypes, ext) {
-			//log.Printf("%s is not an accepted filetype\n", ext)
+		if w.bm.maxFiles > 0 {
+			dir := filepath.Dir(walked)
+			w.files[dir]++
+			if w.files[dir] > w.bm.maxFiles {
+				w.note("skipping the rest of a directory with more files than scan.max_files", "path", filepath.Dir(path), "max_files", w.bm.maxFiles)
+				return filepath.SkipDir
+			}
+		}
+
+		accepted, regular := w.bm.isAcceptedFile(d), d.Type().IsRegular()
+		var info fs.FileInfo
+		if d.Type() == os.ModeSymlink && w.bm.followSymlinks {
+			info, err = os.Stat(walked)
+			if err != nil {
+				slog.Warn("could not follow symlink", "path", path, "error", err)
+				return nil
+			}
+			if info.IsDir() {
+				target, err := filepath.EvalSymlinks(walked)
+				if err != nil {
+					slog.Warn("could not follow symlink", "path", path, "error", err)
+					return nil
+				}
+				err = w.walk(path, target)
+				if err == nil && w.stopped {
+					return filepath.SkipAll
+				}
+				return err
+			}
+			// books in a symlink farm are often named only by the symlink
+			accepted, regular = w.bm.isAcceptedName(d.Name()), info.Mode().IsRegular()
+		}
+		// a book symlinked from more than one place is only processed once
+		isFirst := func() bool {
+			if !w.bm.followSymlinks {
+				return true
+			}
+			if info == nil {
+				if info, err = d.Info(); err != nil {
+					return true
+				}
+			}
+			return w.first(walked, info)
+		}
+
+		if w.bm.scanArchives && regular && archive.IsArchive(path) {
+			// an archive is walked like a directory of books
+			if w.bm.filter.skipDir(w.scanPath, path) || !isFirst() {
+				return nil
+			}
+			err = w.bm.walkArchive(w.scanPath, path, w.counts, w.visit)
+			if err == nil && w.stopped {
+				return filepath.SkipAll
+			}
+			return err
+		}
+
+		if !accepted {
+			if regular {
+				w.counts.SkipExtension(filepath.Ext(path))
+			}
+			return nil
+		}
+		if w.bm.filter.skipFile(w.scanPath, path) {
+			w.counts.Exclude()
+			return nil
+		}
+		if !isFirst() {
 			return nil
 		}
+		w.counts.Discover()
 
 		path, err = filepath.Abs(path)
 		if err != nil {
 			return err
 		}
 
-		if bm.isBookProcessed(path) {
+		if w.bm.isBookProcessed(path) {
 			//log.Printf("book manager: skipping already-processed %s\n", path)
+			w.counts.CacheHit()
 			return nil
 		}
 
-		bookCount++
+		if !w.visit(path) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
 
-		bm.pipe.Frontend <- book.Book{Filepath: path}
+// walkWebdav calls visit with the URL of every accepted book under the collection of a WebDAV server at scanPath, like
+// walkBooks
+func (bm *BookManager) walkWebdav(scanPath string, counts *stats.Stats, visit func(path string) bool) error {
+	if bm.webdav == nil {
+		return fmt.Errorf("error: %s is a URL, which can only be scanned with [webdav] enabled", scanPath)
+	}
+	return bm.webdav.Walk(context.Background(), scanPath, func(entry webdav.Entry) error {
+		if entry.Dir {
+			if bm.filter.skipDir(scanPath, entry.Url) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !bm.isAcceptedName(entry.Name) {
+			counts.SkipExtension(filepath.Ext(entry.Name))
+			return nil
+		}
+		if bm.filter.skipFile(scanPath, entry.Url) {
+			counts.Exclude()
+			return nil
+		}
+		counts.Discover()
+		if bm.isBookProcessed(entry.Url) {
+			counts.CacheHit()
+			return nil
+		}
+		if !visit(entry.Url) {
+			return fs.SkipAll
+		}
 		return nil
 	})
+}
 
+// walkArchive calls visit with the path of every accepted book inside the archive at archivePath, like walkBooks
+func (bm *BookManager) walkArchive(scanPath string, archivePath string, counts *stats.Stats, visit func(path string) bool) error {
+	archivePath, err := filepath.Abs(archivePath)
 	if err != nil {
-		log.Printf("error: failed to completely scan %s: %s\n", scanPath, err)
+		return err
 	}
 
-	//log.Printf("%sbook manager: all jobs created, waiting for processing to complete", util.ClearTermLineString())
+	members, err := archive.Members(archivePath)
+	if err != nil {
+		// one broken archive shouldn't stop the rest of the scan
+		slog.Warn("could not read archive", "path", archivePath, "error", err)
+		return nil
+	}
 
-	for bm.getProcessedBookCount() != bookCount {
-		if len(bm.extractorsManager.GetLiveServices()) == 0 {
-			log.Println("error: all extractors down")
-			bm.pipe.Wait()
-			bm.pipe.Close()
-			return
+	for _, member := range members {
+		path := archive.Join(archivePath, member)
+		if !bm.isAcceptedName(member) {
+			counts.SkipExtension(filepath.Ext(member))
+			continue
 		}
-		if len(bm.providersManager.GetLiveServices()) == 0 {
-			log.Println("error: all providers down")
-			bm.pipe.Wait()
-			bm.pipe.Close()
-			return
+		if bm.filter.skipFile(scanPath, path) {
+			counts.Exclude()
+			continue
+		}
+		counts.Discover()
+		if bm.isBookProcessed(path) {
+			counts.CacheHit()
+			continue
+		}
+		if !visit(path) {
+			return filepath.SkipAll
 		}
-		time.Sleep(500 * time.Millisecond)
 	}
+	return nil
+}
 
-	bm.pipe.Wait()
+// SubmitPath submits the book at scanPath, or every accepted book under it if it is a directory, returning how many
+// books were submitted
+func (bm *BookManager) SubmitPath(scanPath string) (uint64, error) {
+	// counting the books on disk first gives the progress line a total to estimate from, any error is hit again below.
+	// Those on a WebDAV server aren't counted, since listing them twice doubles the requests, so the total grows as they
+	// are submitted instead.
+	counts := bm.stats
+	if !webdav.IsUrl(scanPath) {
+		var discovered int64
+		_ = bm.walkBooks(scanPath, counts, func(string) bool {
+			discovered++
+			return true
+		})
+		bm.pipe.Discover(discovered)
+		counts = nil
+	}
+
+	var submitted uint64
+	err := bm.walkBooks(scanPath, counts, func(path string) bool {
+		if !bm.pipe.Submit(book.Book{Filepath: path}) {
+			return false
+		}
+		submitted++
+		return true
+	})
+
+	return submitted, err
+}
+
+// Drain stops new books from being submitted and waits for every book in flight to finish, without stopping
+func (bm *BookManager) Drain() {
+	bm.pipe.Drain()
+}
+
+// Stop waits for every book in flight to finish, then shuts down the pipeline and closes the writer
+func (bm *BookManager) Stop() error {
+	bm.pipe.Drain()
 	bm.pipe.Close()
 
-	log.Println("book manager: scan complete")
+	var err error
+	if len(bm.extractorsManager.GetLiveServices()) == 0 {
+		err = fmt.Errorf("error: all extractors down")
+	} else if len(bm.providersManager.GetLiveServices()) == 0 && !bm.offline {
+		err = fmt.Errorf("error: all providers down")
+	}
+
+	bm.keepStaleBooks()
+	bm.writer.Close()
+	bm.writer = nil
+	if bm.identifiersWriter != nil {
+		bm.identifiersWriter.Close()
+		bm.identifiersWriter = nil
+	}
+	bm.EndDryRun()
+
+	if bm.checkpoint != nil {
+		bm.checkpoint.close()
+		bm.checkpoint = nil
+	}
+
+	return err
 }
 
-func (bm *BookManager) Import(cache string, removeErrored bool) error {
-	if exists, err := util.PathExists(cache); !exists || err != nil {
-		return fmt.Errorf("error: could not open cache %s: %s", cache, err)
+// Identify processes the book at filePath right away, outside of the pipeline, and returns what was found for it. A book
+// that couldn't be identified is returned with its error message set, along with the error.
+func (bm *BookManager) Identify(ctx context.Context, filePath string) (book.Book, error) {
+	if err := webdav.CheckSource(filePath); err != nil {
+		return book.Book{}, fmt.Errorf("error: %s", err.Error())
 	}
-	data, err := os.ReadFile(cache)
+	filePath, err := util.AbsPath(filePath)
 	if err != nil {
-		return err
+		return book.Book{}, fmt.Errorf("error: could not get absolute path: %s", err.Error())
+	}
+	if exists, err := util.PathExists(filePath); !exists {
+		return book.Book{}, fmt.Errorf("error: could not stat %s: %s", filePath, err)
+	}
+
+	stages := []func(context.Context, any) (any, error){bm.extract, bm.heuristics, bm.search, bm.collate, bm.cover}
+	var item any = book.Book{Filepath: filePath}
+	for _, stage := range stages {
+		stageCtx, cancel := ctx, context.CancelFunc(func() {})
+		if timeout := bm.pipe.ItemTimeout(); timeout > 0 {
+			stageCtx, cancel = pipeline.WithItemTimeout(ctx, timeout)
+		}
+		result, err := stage(stageCtx, item)
+		if err != nil && errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+			err = pipeline.TimedOut(err)
+		}
+		cancel()
+
+		if err != nil {
+			failed := book.Book{Filepath: filePath}
+			switch failedItem := result.(type) {
+			case book.Book:
+				failed = failedItem
+			case bookJob:
+				failed = failedItem.book
+			}
+			failed.SetError(err)
+			return failed, err
+		}
+		if completed, ok := pipeline.Completed(result); ok {
+			return completed.(book.Book), nil
+		}
+		item = result
+	}
+
+	bk := item.(book.Book)
+	if len(bk.ErrorMessage) > 0 {
+		return bk, errors.New(bk.ErrorMessage)
+	}
+	return bk, nil
+}
+
+// Scan processes every accepted file under scanPath, cancelling ctx abandons any books still in flight. With watch,
+// it then keeps processing new and modified files until interrupted
+func (bm *BookManager) Scan(ctx context.Context, scanPath string, dryRun bool, watch bool, writer util.ObjectWriter[*book.Book]) error {
+	var err error
+	if err := webdav.CheckSource(scanPath); err != nil {
+		return fmt.Errorf("error: %s", err.Error())
+	}
+	if webdav.IsUrl(scanPath) {
+		if bm.webdav == nil {
+			return fmt.Errorf("error: the scan path %s is a URL, which can only be scanned with [webdav] enabled", scanPath)
+		}
+		if watch {
+			return fmt.Errorf("error: --watch only watches directories on disk, not a WebDAV server")
+		}
+		scanPath = strings.TrimSuffix(scanPath, "/")
+	} else {
+		scanPath, err = util.AbsPath(scanPath)
+		if err != nil {
+			return fmt.Errorf("error: could not get absolute scan path: %s", err.Error())
+		}
+
+		if exists, err := util.PathExists(scanPath); !exists {
+			return fmt.Errorf("error: could not stat scan path: %s", err)
+		}
+	}
+
+	bm.Start(ctx, dryRun, writer)
+
+	slog.Info("beginning scan", "path", scanPath)
+
+	_, err = bm.SubmitPath(scanPath)
+	if err != nil {
+		slog.Error("failed to completely scan", "path", scanPath, "error", err)
+	}
+
+	watching := watch && !bm.pipe.IsInterrupted()
+	if watching {
+		slog.Info("watching for new and modified books, interrupt to stop", "path", scanPath)
+		err = bm.watch(scanPath)
+		if err != nil {
+			slog.Error("stopped watching", "path", scanPath, "error", err)
+		}
+	}
+
+	//slog.Debug("all jobs created, waiting for processing to complete")
+
+	return bm.finishScan(watching)
+}
+
+// ScanList processes the books at the paths listed in list, one per line, instead of walking a directory. A listed
+// directory is scanned like with Scan, and paths that don't exist are skipped.
+func (bm *BookManager) ScanList(ctx context.Context, list io.Reader, dryRun bool, writer util.ObjectWriter[*book.Book]) error {
+	bm.Start(ctx, dryRun, writer)
+
+	slog.Info("beginning scan of listed paths")
+
+	lines := bufio.NewScanner(list)
+	for !bm.pipe.IsInterrupted() && lines.Scan() {
+		line := strings.TrimSuffix(lines.Text(), "\r")
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		bm.submitListed(line)
+	}
+	if err := lines.Err(); err != nil {
+		slog.Error("failed to read the whole list of paths", "error", err)
+	}
+
+	return bm.finishScan(false)
+}
+
+// ScanIntake processes the books at the paths taken from source as they arrive, until interrupted. Paths are handled
+// like those listed for ScanList.
+func (bm *BookManager) ScanIntake(ctx context.Context, source *intake.Intake, dryRun bool, writer util.ObjectWriter[*book.Book]) error {
+	bm.Start(ctx, dryRun, writer)
+
+	slog.Info("taking paths from intake, interrupt to stop", "intake", source.String())
+
+	intakeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-bm.Interrupted():
+			cancel()
+		case <-intakeCtx.Done():
+		}
+	}()
+
+	for {
+		line, err := source.Next(intakeCtx)
+		if err != nil {
+			break
+		}
+		slog.Debug("took path from intake", "path", line)
+		bm.submitListed(line)
+	}
+
+	// interrupting is the only way to stop taking paths, like watching
+	return bm.finishScan(true)
+}
+
+// submitListed submits the book or directory at a path that was handed to the book manager rather than found by a walk,
+// skipping it if it doesn't exist
+func (bm *BookManager) submitListed(line string) {
+	if err := webdav.CheckSource(line); err != nil {
+		slog.Warn("skipping listed path", "path", line, "error", err)
+		return
+	}
+	if webdav.IsUrl(line) {
+		if bm.webdav == nil {
+			slog.Warn("skipping listed URL, [webdav] isn't enabled", "path", line)
+			return
+		}
+		_, err := bm.SubmitPath(strings.TrimSuffix(line, "/"))
+		if err != nil {
+			slog.Error("failed to completely scan", "path", line, "error", err)
+		}
+		return
+	}
+
+	scanPath, err := util.AbsPath(line)
+	if err != nil {
+		slog.Warn("skipping listed path", "path", line, "error", err)
+		return
+	}
+	if exists, err := util.PathExists(scanPath); !exists {
+		slog.Warn("skipping listed path", "path", scanPath, "error", err)
+		return
+	}
+
+	_, err = bm.SubmitPath(scanPath)
+	if err != nil {
+		slog.Error("failed to completely scan", "path", scanPath, "error", err)
+	}
+}
+
+// finishScan stops the book manager once a scan has submitted all of its books
+func (bm *BookManager) finishScan(watching bool) error {
+	if bm.pipe.IsInterrupted() {
+		slog.Info("interrupted, waiting for in-flight books to finish")
 	}
 
-	err = json.Unmarshal(data, &bm.books)
+	// a dry run has no effects outside of Booker
+	notify := !bm.IsDryRun()
+	err := bm.Stop()
+	// interrupting is the only way to stop watching, so it isn't an error then
+	if err == nil && bm.pipe.IsInterrupted() && !watching {
+		err = fmt.Errorf("error: scan interrupted, output contains only the books finished so far")
+	}
+	if notify {
+		bm.webhooks.ScanFinished(bm.stats.Report(), err)
+	}
 	if err != nil {
 		return err
 	}
-	if removeErrored {
+
+	slog.Info("scan complete")
+	return nil
+}
+
+// Stats is what the last scan did, or nil if nothing was ever scanned
+func (bm *BookManager) Stats() *stats.Stats {
+	return bm.stats
+}
+
+// Interrupted is closed once the book manager has been interrupted
+func (bm *BookManager) Interrupted() <-chan struct{} {
+	return bm.pipe.Interrupted()
+}
+
+// Books returns a copy of every processed book keyed by filepath
+func (bm *BookManager) Books() map[string]book.Book {
+	bm.bookStateLock.RLock()
+	defer bm.bookStateLock.RUnlock()
+	books := make(map[string]book.Book, len(bm.books))
+	for p, bk := range bm.books {
+		books[p] = bk
+	}
+	return books
+}
+
+func (bm *BookManager) BookByHash(hash string) (book.Book, bool) {
+	return bm.getProcessedBookByHash(hash)
+}
+
+type Status struct {
+	Discovered     int64    `json:"discovered"`
+	Processed      uint64   `json:"processed"`
+	InFlight       int64    `json:"in_flight"`
+	Failed         int64    `json:"failed"`
+	Interrupted    bool     `json:"interrupted"`
+	Paused         bool     `json:"paused"`
+	LiveExtractors []string `json:"live_extractors"`
+	LiveProviders  []string `json:"live_providers"`
+}
+
+func (bm *BookManager) Status() Status {
+	names := func(services []service.Service) []string {
+		return lo.Map(services, func(svc service.Service, _ int) string {
+			return svc.Name()
+		})
+	}
+	return Status{
+		Discovered:     bm.pipe.Total(),
+		Processed:      bm.getProcessedBookCount(),
+		InFlight:       bm.pipe.InFlight(),
+		Failed:         bm.pipe.FailedCount(),
+		Interrupted:    bm.pipe.IsInterrupted(),
+		Paused:         bm.pipe.IsPaused(),
+		LiveExtractors: names(bm.extractorsManager.GetLiveServices()),
+		LiveProviders:  names(bm.providersManager.GetLiveServices()),
+	}
+}
+
+// Import reads the books of a previous output in, which are then skipped. With retry, the failed books it picks are
+// left out so that they are processed again.
+func (bm *BookManager) Import(cache string, retry *RetryFilter) error {
+	if exists, err := util.PathExists(cache); !exists || err != nil {
+		return fmt.Errorf("error: could not open cache %s: %s", cache, err)
+	}
+
+	if calibre.IsLibrary(cache) {
+		books, err := calibre.ReadLibrary(cache)
+		if err != nil {
+			return err
+		}
+		for _, bk := range books {
+			bm.books[bk.Filepath] = bk
+		}
+	} else {
+		data, err := util.ReadFile(cache)
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(data, &bm.books)
+		if err != nil {
+			return err
+		}
+	}
+	if retry != nil {
 		for p, bk := range bm.books {
-			if len(bk.ErrorMessage) > 0 {
+			if retry.matches(&bk) {
 				bm.removeProcessedBook(p)
 			}
 		}
 	}
+
+	// a file that changed since it was processed is processed again, whether or not it failed before
+	for p, bk := range bm.books {
+		if changedOnDisk(bk) {
+			bm.stale[p] = bk
+			bm.removeProcessedBook(p)
+		}
+	}
+	if len(bm.stale) > 0 {
+		slog.Info("cached books changed on disk since, processing them again", "books", len(bm.stale))
+	}
+
+	bm.indexCachedHashes()
 	return nil
 }
 
-func (bm *BookManager) extract(a any) (any, error) {
+// statBook records the size and modification time of a book's file, books inside archives are only tracked by path
+// and those on a WebDAV server are stat'ed as they are downloaded
+func statBook(bk *book.Book) {
+	if archive.IsMember(bk.Filepath) || webdav.IsUrl(bk.Filepath) {
+		return
+	}
+	info, err := os.Stat(bk.Filepath)
+	if err != nil {
+		return
+	}
+	bk.Size = info.Size()
+	bk.ModTime = info.ModTime().UnixNano()
+}
+
+// changedOnDisk reports whether a cached book's file is no longer the one it was processed from. Only books recorded
+// with their size and modification time can tell, and a file that is gone may have just moved, which its hash finds.
+func changedOnDisk(bk book.Book) bool {
+	if bk.Size == 0 && bk.ModTime == 0 {
+		return false
+	}
+	info, err := os.Stat(bk.Filepath)
+	if err != nil {
+		return false
+	}
+	return info.Size() != bk.Size || info.ModTime().UnixNano() != bk.ModTime
+}
+
+// keepStaleBooks writes out the cached books that changed on disk but weren't processed again, because they weren't
+// under the scan path or the scan was cut short, so that they aren't lost from the output
+func (bm *BookManager) keepStaleBooks() {
+	bm.bookStateLock.RLock()
+	stale := slices.Collect(maps.Values(bm.stale))
+	bm.bookStateLock.RUnlock()
+
+	for _, bk := range stale {
+		bm.writeBook(bk)
+	}
+}
+
+// indexCachedHashes reuses cached books by content, so that moved or renamed files don't need to be searched again
+func (bm *BookManager) indexCachedHashes() {
+	for _, bk := range bm.books {
+		if len(bk.Hash) == 0 {
+			continue
+		}
+		if _, exists := bm.booksByHash[bk.Hash]; !exists {
+			bm.booksByHash[bk.Hash] = bk
+			bm.hashOwners[bk.Hash] = bk.Filepath
+		}
+	}
+}
+
+// bookJob carries a book through the pipeline stages along with everything learned about it so far
+type bookJob struct {
+	book    book.Book
+	search  providers.SearchTerms
+	results []book.BookResult
+	snippet string
+	// language is the language detected in the book's text, which is what the file really is even when its metadata
+	// says otherwise
+	language string
+	// lcc and ddc are the call numbers printed in the book, for when no provider knows them
+	lcc string
+	ddc string
+	// edition is why the results were or weren't taken for the edition in the file
+	edition []string
+}
+
+// cachedByHash is the cached book with the same contents as bk, recorded under the path of bk
+func (bm *BookManager) cachedByHash(bk book.Book) (book.Book, bool) {
+	cached, ok := bm.getProcessedBookByHash(bk.Hash)
+	if !ok {
+		return book.Book{}, false
+	}
+	bm.stats.CacheHit()
+	cached.Filepath = bk.Filepath
+	cached.Size = bk.Size
+	cached.ModTime = bk.ModTime
+	cached.DuplicateOf = bk.DuplicateOf
+	return cached, true
+}
+
+// downloaded is a book on a WebDAV server along with the copy of it that extract reads, and then removes
+type downloaded struct {
+	book  book.Book
+	local string
+}
+
+// download copies a book on a WebDAV server to a temporary file for extract to read, everything else is passed on as
+// it is. Books handed out to workers are downloaded by the coordinator when a worker asks for them instead.
+func (bm *BookManager) download(ctx context.Context, a any) (any, error) {
+	bk, ok := a.(book.Book)
+	if !ok || !webdav.IsUrl(bk.Filepath) || bm.dispatcher != nil {
+		return a, nil
+	}
+	local, entry, err := bm.webdav.Download(ctx, bk.Filepath)
+	if err != nil {
+		return bk, fmt.Errorf("could not download: %s", err.Error())
+	}
+	bk.Size = entry.Size
+	if !entry.ModTime.IsZero() {
+		bk.ModTime = entry.ModTime.UnixNano()
+	}
+	return downloaded{book: bk, local: local}, nil
+}
+
+// Download copies a book on a WebDAV server to a temporary file, which the caller removes
+func (bm *BookManager) Download(ctx context.Context, fileUrl string) (string, error) {
+	if bm.webdav == nil {
+		return "", fmt.Errorf("error: [webdav] isn't enabled")
+	}
+	local, _, err := bm.webdav.Download(ctx, fileUrl)
+	return local, err
+}
+
+// outage is every service of a kind being down, which the whole scan waits out together
+type outage struct {
+	// over is closed once a service is back or the scan stops waiting, err being why if none is back
+	over chan struct{}
+	err  error
+}
+
+// awaitServices are the live services of manager. When every one of them is down, no more books are started until one
+// is back, for up to the outage timeout, so that the books left aren't lost to a server being restarted. The books
+// waiting don't use up their own time meanwhile. If none is back by then, the books that haven't started are skipped,
+// since they couldn't succeed either.
+func (bm *BookManager) awaitServices(ctx context.Context, manager *service.ServiceManager, kind string) ([]service.Service, error) {
+	live := manager.GetLiveServices()
+	if len(live) > 0 {
+		return live, nil
+	}
+	if bm.pipe.IsInterrupted() {
+		return nil, fmt.Errorf("error: no live %s found", kind)
+	}
+
+	o := bm.startOutage(manager, kind)
+	defer pipeline.Hold(ctx)()
+	select {
+	case <-o.over:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("error: no live %s found before the scan was cancelled", kind)
+	}
+	if live = manager.GetLiveServices(); len(live) > 0 {
+		return live, nil
+	}
+	return nil, o.err
+}
+
+// startOutage is the outage of the services of manager, pausing the scan until it is over if it has only just begun.
+// There is one for every kind of service, so however many books wait on it there is only the one outage timeout.
+func (bm *BookManager) startOutage(manager *service.ServiceManager, kind string) *outage {
+	bm.outageLock.Lock()
+	defer bm.outageLock.Unlock()
+	if o, ok := bm.outages[kind]; ok {
+		return o
+	}
+
+	o := &outage{over: make(chan struct{})}
+	bm.outages[kind] = o
+	if bm.pipe.Pause() {
+		slog.Warn("every service is down, pausing until one is back", "services", kind, "timeout", bm.outageTimeout)
+	}
+	go bm.watchOutage(manager, kind, o)
+	return o
+}
+
+// watchOutage ends the outage once a service of manager is back, or interrupts the scan once the outage timeout is up
+func (bm *BookManager) watchOutage(manager *service.ServiceManager, kind string, o *outage) {
+	timeout := time.NewTimer(bm.outageTimeout)
+	defer timeout.Stop()
+	check := time.NewTicker(outageCheckInterval)
+	defer check.Stop()
+	for o.err == nil {
+		select {
+		case <-check.C:
+			if len(manager.GetLiveServices()) > 0 {
+				bm.endOutage(kind, o)
+				if !bm.pipe.IsInterrupted() {
+					slog.Info("services are back, resuming", "services", kind)
+				}
+				return
+			}
+		case <-timeout.C:
+			o.err = fmt.Errorf("error: no live %s found for %s", kind, bm.outageTimeout)
+			bm.pipe.Interrupt()
+		case <-bm.pipe.Interrupted():
+			o.err = fmt.Errorf("error: no live %s found before the scan was interrupted", kind)
+		}
+	}
+	bm.endOutage(kind, o)
+}
+
+func (bm *BookManager) endOutage(kind string, o *outage) {
+	bm.outageLock.Lock()
+	defer bm.outageLock.Unlock()
+	delete(bm.outages, kind)
+	close(o.over)
+	bm.pipe.Resume()
+}
+
+func (bm *BookManager) extract(ctx context.Context, a any) (any, error) {
+	// the identifiers of the book were found by an earlier run
+	if ids, ok := a.(Identifiers); ok {
+		return bm.resumeIdentifiers(ids)
+	}
+
+	// a book on a WebDAV server is read from the copy downloaded, but recorded by its URL
+	var copied string
+	if d, ok := a.(downloaded); ok {
+		defer os.Remove(d.local)
+		a, copied = d.book, d.local
+	}
+
 	bk := a.(book.Book)
+	if bm.dispatcher != nil {
+		return bm.dispatch(ctx, bk)
+	}
+	bm.stats.Start(bk.Filepath)
+
+	// a book inside an archive is read from a copy of it, but recorded by its path in the archive
+	source := bk
+	if len(copied) > 0 {
+		source.Filepath = copied
+	} else if archivePath, member, ok := archive.Split(bk.Filepath); ok {
+		extracted, err := archive.Extract(archivePath, member)
+		if err != nil {
+			return bk, fmt.Errorf("could not extract from archive: %s", err.Error())
+		}
+		defer os.Remove(extracted)
+		source.Filepath = extracted
+	}
+
+	statBook(&bk)
+	hash, err := util.HashFile(source.Filepath)
+	if err != nil {
+		return bk, book.NewError(book.ErrorExtractFailed, fmt.Errorf("could not hash file: %s", err.Error()))
+	}
+	bk.Hash = hash
+
+	if owner := bm.claimHash(hash, bk.Filepath); owner != bk.Filepath {
+		// if the other file is gone then this one was moved or renamed rather than duplicated
+		// a book on a WebDAV server is taken to still be there, rather than asking the server
+		if webdav.IsUrl(owner) || archive.Exists(owner) {
+			bk.DuplicateOf = owner
+		}
+	}
+
+	// an extraction only run leaves it to the run that searches its identifiers, which writes the output
+	if bm.identifiersWriter == nil {
+		if cached, ok := bm.cachedByHash(bk); ok {
+			return pipeline.Complete(cached), nil
+		}
+	}
 
 	texts := make([]string, 0)
+	embedded := make([]book.BookResult, 0)
+	var pages uint
 
-	liveExtractors := bm.extractorsManager.GetLiveServices()
-	if len(liveExtractors) == 0 {
-		return nil, fmt.Errorf("error: no live extractors found")
+	liveExtractors, err := bm.awaitServices(ctx, bm.extractorsManager, "extractors")
+	if err != nil {
+		return nil, err
 	}
 
 	for _, svc := range liveExtractors {
 		extractor := svc.(extractors.Extractor)
+		if !extractor.Accepts(source.Filepath) {
+			continue
+		}
+
+		if metadataExtractor, ok := extractor.(extractors.MetadataExtractor); ok {
+			result, err := metadataExtractor.ExtractMetadata(ctx, &source)
+			if err == nil {
+				result.Filepath = bk.Filepath
+				embedded = append(embedded, result)
+			}
+		}
+
+		if counter, ok := extractor.(extractors.PageCounter); ok && pages == 0 {
+			if count, err := counter.PageCount(ctx, &source); err == nil {
+				pages = count
+			}
+		}
 
-		text, err := extractor.ExtractText(&bk, bm.maxCharacters)
+		text, err := extractor.ExtractText(ctx, &source, bm.textLimit)
 		if err != nil {
 			//log.Printf("error: failed to extract text from %s: %s\n", bk.Filepath, err)
 			continue
@@ -325,85 +1461,354 @@ func (bm *BookManager) extract(a any) (any, error) {
 		texts = append(texts, text)
 	}
 
-	if len(texts) == 0 {
-		return bk, fmt.Errorf("no texts extracted")
+	if len(texts) == 0 && len(embedded) == 0 {
+		return bk, book.NewError(book.ErrorExtractFailed, fmt.Errorf("no texts extracted"))
 	}
 
-	isbn10s := make([]book.ISBN10, 0)
-	isbn13s := make([]book.ISBN13, 0)
+	isbns := make([]book.ISBN, 0)
+	dois := make([]book.DOI, 0)
+	asins := make([]book.ASIN, 0)
+	arxivs := make([]book.ArxivId, 0)
 
 	for _, text := range texts {
-		isbn10s = append(isbn10s, util.IdentifyIsbn10s(text)...)
-		isbn13s = append(isbn13s, util.IdentifyIsbn13s(text)...)
+		isbns = append(isbns, util.IdentifyIsbns(text)...)
+		dois = append(dois, util.IdentifyDois(text)...)
+		asins = append(asins, util.IdentifyAsins(text)...)
+		arxivs = append(arxivs, util.IdentifyArxivs(text)...)
+	}
+
+	if asin := util.ParseFilename(bk.Filepath).Asin; len(asin) > 0 {
+		asins = append([]book.ASIN{asin}, asins...)
+	}
+
+	// embedded identifiers are the most trustworthy, so they are searched first
+	for _, result := range embedded {
+		if isbn, ok := result.Isbn10.Get(); ok {
+			isbns = append([]book.ISBN{book.ISBN(isbn)}, isbns...)
+		}
+		if isbn, ok := result.Isbn13.Get(); ok {
+			isbns = append([]book.ISBN{book.ISBN(isbn)}, isbns...)
+		}
+		if doi, ok := result.Doi.Get(); ok {
+			dois = append([]book.DOI{doi}, dois...)
+		}
+		if asin, ok := result.Asin.Get(); ok {
+			asins = append([]book.ASIN{asin}, asins...)
+		}
+	}
+
+	// only the likeliest ISBNs are looked up, since a file full of numbers could otherwise send dozens of requests
+	isbns = lo.Uniq(isbns)
+	if uint(len(isbns)) > bm.maxIsbnLookups {
+		bk.UnsearchedIsbns = isbns[bm.maxIsbnLookups:]
+		isbns = isbns[:bm.maxIsbnLookups]
+	}
+	isbn10s := make([]book.ISBN10, 0)
+	isbn13s := make([]book.ISBN13, 0)
+	for _, isbn := range isbns {
+		if len(isbn) == 10 {
+			isbn10s = append(isbn10s, book.ISBN10(isbn))
+		} else {
+			isbn13s = append(isbn13s, book.ISBN13(isbn))
+		}
 	}
 
+	language := util.DetectLanguage(strings.Join(texts, "\n"))
+
 	search := providers.SearchTerms{
 		Isbn10s:  isbn10s,
 		Isbn13s:  isbn13s,
+		Dois:     lo.Uniq(dois),
+		Asins:    lo.Uniq(asins),
+		Arxivs:   lo.Uniq(arxivs),
+		Pages:    pages,
 		Filepath: bk.Filepath,
+		Embedded: embedded,
+		Language: language,
+	}
+	// the year and pages of the edition in the file tell apart the editions a title search finds
+	search.Year, _ = util.CopyrightYear(strings.Join(texts, "\n"))
+	// without enough text to tell, the file's own metadata is trusted with its language
+	for _, result := range embedded {
+		if len(search.Language) > 0 {
+			break
+		}
+		search.Language = result.Language.OrEmpty()
 	}
 
-	return search, nil
+	job := bookJob{book: bk, search: search, language: language}
+	if bm.classifyText {
+		for _, text := range texts {
+			if lccs := util.IdentifyLccs(text); len(lccs) > 0 && len(job.lcc) == 0 {
+				job.lcc = lccs[0]
+			}
+			if ddcs := util.IdentifyDdcs(text); len(ddcs) > 0 && len(job.ddc) == 0 {
+				job.ddc = ddcs[0]
+			}
+		}
+	}
+	if bm.reviewWriter != nil || len(bm.debugDir) > 0 || bm.identifiersWriter != nil {
+		job.snippet = snippet(texts)
+	}
+	return job, nil
 }
 
-func (bm *BookManager) search(a any) (any, error) {
-	search := a.(providers.SearchTerms)
+// heuristics fills in title/author search terms when no identifiers were extracted, preferring embedded
+// metadata and falling back to parsing the filename
+func (bm *BookManager) heuristics(ctx context.Context, a any) (any, error) {
+	job := a.(bookJob)
 
-	if bm.IsDryRun() {
-		return nil, fmt.Errorf("dry run")
+	if job.search.HasIdentifiers() {
+		return job, nil
 	}
 
-	results := make([]book.BookResult, 0)
+	for _, result := range job.search.Embedded {
+		if title, ok := result.Title.Get(); ok {
+			job.search.Title = title
+			job.search.Author = strings.Join(result.Authors.OrEmpty(), " ")
+			return job, nil
+		}
+	}
 
-	liveProviders := bm.providersManager.GetLiveServices()
-	if len(liveProviders) == 0 {
-		return nil, fmt.Errorf("error: no live providers found")
+	terms := util.ParseFilename(job.search.Filepath)
+	if len(terms.Title) == 0 {
+		return job, book.NewError(book.ErrorNoIsbnFound, fmt.Errorf("no identifiers or title found"))
 	}
 
-	for _, svc := range liveProviders {
-		provider := svc.(providers.Provider)
-		res, err := provider.GetBookMetadata(&search)
-		if err != nil {
-			continue
+	job.search.Title = terms.Title
+	job.search.Author = terms.Author
+	// the copyright notice is of the file itself, where the file name may have been given by anyone
+	if job.search.Year == 0 {
+		job.search.Year = terms.Year
+	}
+
+	return job, nil
+}
+
+func (bm *BookManager) search(ctx context.Context, a any) (any, error) {
+	job := a.(bookJob)
+
+	if bm.stopsBeforeSearch() {
+		return pipeline.Complete(bm.stopBeforeSearch(&job, nil)), nil
+	}
+
+	if len(bm.debugDir) > 0 {
+		recorder := &providers.Recorder{}
+		ctx = providers.WithRecorder(ctx, recorder)
+		defer bm.writeDebug(&job, recorder)
+	}
+
+	job.results = slices.Clone(job.search.Embedded)
+
+	if bm.offline {
+		if len(job.results) == 0 {
+			return job, book.NewError(book.ErrorNoResults, fmt.Errorf("error: no embedded metadata to identify the book by offline"))
+		}
+		scoring.Score(job.results, job.search.Filepath, job.search.Embedded)
+		scoring.PreferLanguage(job.results, job.search.Language)
+		return job, nil
+	}
+
+	liveProviders, err := bm.awaitServices(ctx, bm.providersManager, "providers")
+	if err != nil {
+		return nil, err
+	}
+
+	results, errs := bm.searchProviders(ctx, &job.search, liveProviders)
+	job.results = append(job.results, results...)
+
+	if len(job.results) == 0 {
+		// the book may well be found once the providers that turned it away take requests again
+		if slices.ContainsFunc(errs, providers.IsRateLimited) {
+			return job, book.NewError(book.ErrorRateLimited, fmt.Errorf("error: no results found while providers were rate limited"))
 		}
-		results = append(results, res...)
+		return job, book.NewError(book.ErrorNoResults, fmt.Errorf("error: no results found"))
 	}
 
-	if len(results) == 0 {
-		return results, fmt.Errorf("error: no results found")
+	scoring.Score(job.results, job.search.Filepath, job.search.Embedded)
+	scoring.PreferLanguage(job.results, job.search.Language)
+	// an identifier finds its own edition, but a title finds all of them
+	if !job.search.HasIdentifiers() {
+		job.edition = scoring.PreferEdition(job.results, job.search.Year, job.search.Pages)
 	}
 
-	return results, nil
+	return job, nil
+}
+
+// errConfident cancels the searches of the providers that are no longer needed after a confident match
+var errConfident = errors.New("found a confident match")
+
+// searchProviders asks all the providers about a book at once. Their results are kept in the order of the providers, so
+// that ties are broken the same way however fast each one answered, and are returned with the errors of the providers
+// that failed. Once the results so far score at least earlyExit, the providers that haven't answered yet are cancelled.
+func (bm *BookManager) searchProviders(ctx context.Context, search *providers.SearchTerms, liveProviders []service.Service) ([]book.BookResult, []error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	type answer struct {
+		idx     int
+		results []book.BookResult
+		err     error
+	}
+	// buffered so the providers cancelled by an early exit don't block
+	answers := make(chan answer, len(liveProviders))
+	for idx, svc := range liveProviders {
+		provider := svc.(providers.Provider)
+		go func() {
+			res, err := provider.GetBookMetadata(ctx, search)
+			if !errors.Is(context.Cause(ctx), errConfident) {
+				bm.stats.Search(provider.Name(), len(res), err)
+			}
+			answers <- answer{idx: idx, results: res, err: err}
+		}()
+	}
+
+	byProvider := make([][]book.BookResult, len(liveProviders))
+	errs := make([]error, 0)
+	for range liveProviders {
+		a := <-answers
+		byProvider[a.idx] = a.results
+		if a.err != nil {
+			errs = append(errs, a.err)
+		}
+		if bm.earlyExit > 0 && bm.confident(slices.Concat(search.Embedded, slices.Concat(byProvider...)), search) {
+			slog.Debug("found a confident match, not waiting on the other providers", "path", search.Filepath)
+			cancel(errConfident)
+			break
+		}
+	}
+	return slices.Concat(byProvider...), errs
+}
+
+// confident reports whether the best of results scores at least earlyExit
+func (bm *BookManager) confident(results []book.BookResult, search *providers.SearchTerms) bool {
+	scoring.Score(results, search.Filepath, search.Embedded)
+	scoring.PreferLanguage(results, search.Language)
+	if !search.HasIdentifiers() {
+		scoring.PreferEdition(results, search.Year, search.Pages)
+	}
+	return slices.ContainsFunc(results, func(result book.BookResult) bool {
+		return result.Confidence >= bm.earlyExit
+	})
 }
 
-func (bm *BookManager) collate(a any) (any, error) {
-	results := a.([]book.BookResult)
-	result, err := book.ChooseBestResult(results)
+func (bm *BookManager) collate(ctx context.Context, a any) (any, error) {
+	job := a.(bookJob)
+	result, err := bm.collation.Collate(job.results)
 	if err != nil {
-		return book.Book{}, fmt.Errorf("could not collate: %s", err.Error())
+		return job, fmt.Errorf("could not collate: %s", err.Error())
 	}
 
-	return result.ToBook(), nil
+	bk := result.ToBook()
+	bk.Hash = job.book.Hash
+	bk.Size = job.book.Size
+	bk.ModTime = job.book.ModTime
+	bk.DuplicateOf = job.book.DuplicateOf
+	bk.UnsearchedIsbns = job.book.UnsearchedIsbns
+	// names are written the same way whichever source they came from, so that books sort and dedupe by author
+	bk.Authors = util.NormalizeAuthors(bk.Authors)
+	bk.Editors = util.NormalizeAuthors(bk.Editors)
+	if bm.nameOrder == "last_first" {
+		bk.Authors = lo.Map(bk.Authors, func(name string, _ int) string { return util.InvertAuthor(name) })
+		bk.Editors = lo.Map(bk.Editors, func(name string, _ int) string { return util.InvertAuthor(name) })
+	}
+	if len(job.language) > 0 {
+		bk.Language = job.language
+		bk.SetProvenance("language", "text")
+	}
+	// the file name is the last place a series is looked for, since it's only a guess
+	if terms := util.ParseFilename(bk.Filepath); len(bk.Series) == 0 && len(terms.Series) > 0 {
+		bk.Series = terms.Series
+		bk.SeriesIndex = terms.SeriesIndex
+		bk.SetProvenance("series", "filename")
+		bk.SetProvenance("series_index", "filename")
+	}
+	// when no source gave the years, they are read from the publish date and credited to its source
+	if low, high, ok := util.ParseYears(bk.PublishDate); bk.LowYear == 0 && bk.HighYear == 0 && ok {
+		bk.LowYear = low
+		bk.HighYear = high
+		if source, ok := bk.Provenance["publish_date"]; ok {
+			bk.SetProvenance("low_year", source)
+			bk.SetProvenance("high_year", source)
+		}
+	}
+	if len(bk.Lcc) == 0 && len(job.lcc) > 0 {
+		bk.Lcc = job.lcc
+		bk.SetProvenance("lcc", "text")
+	}
+	if len(bk.Ddc) == 0 && len(job.ddc) > 0 {
+		bk.Ddc = job.ddc
+		bk.SetProvenance("ddc", "text")
+	}
+
+	// the best guess is kept along with the error, so the output still shows what was found
+	if err := bm.needsReview(bk, job.results, job.snippet); err != nil {
+		bk.SetError(err)
+		return bk, nil
+	}
+
+	if bm.embedMetadata && embed.Accepts(bk.Filepath) && !archive.IsMember(bk.Filepath) && !webdav.IsUrl(bk.Filepath) {
+		err = embed.Metadata(&bk)
+		if err != nil {
+			slog.Warn("failed to embed metadata", "path", bk.Filepath, "error", err)
+		} else if hash, err := util.HashFile(bk.Filepath); err == nil {
+			// the file changed, so the cache has to match it by its new contents
+			bk.Hash = hash
+			statBook(&bk)
+		}
+	}
+
+	return bk, nil
+}
+
+// cover downloads the cover of a book that was found, a book without one is still written
+func (bm *BookManager) cover(ctx context.Context, a any) (any, error) {
+	bk := a.(book.Book)
+	if bm.covers == nil || len(bk.ErrorMessage) > 0 {
+		return bk, nil
+	}
+
+	err := bm.covers.Fetch(ctx, &bk)
+	if err != nil {
+		slog.Debug("could not download cover", "path", bk.Filepath, "error", err)
+	}
+	return bk, nil
 }
 
 func (bm *BookManager) failHandler(a any, err error) {
 	if a == nil {
-		if strings.Contains(err.Error(), "dry run") {
-			return
-		}
-		log.Println(err.Error())
+		slog.Error(err.Error())
+		return
+	}
+
+	if d, ok := a.(downloaded); ok {
+		// extract normally removes the copy, unless the book failed before it got to run
+		os.Remove(d.local)
+		a = d.book
+	}
+
+	// a book that fails before it would have been searched is reported along with the others
+	if ids, ok := a.(Identifiers); ok {
+		a = ids.job()
+	}
+	if bk, ok := a.(book.Book); ok && bm.stopsBeforeSearch() {
+		a = bookJob{book: bk}
+	}
+	if job, ok := a.(bookJob); ok && bm.stopsBeforeSearch() {
+		bm.finishBook(bm.stopBeforeSearch(&job, err))
 		return
 	}
 
 	switch a.(type) {
 	case book.Book:
 		b := a.(book.Book)
-		b.ErrorMessage = err.Error()
+		b.SetError(err)
+		bm.finishBook(b)
+	case bookJob:
+		b := a.(bookJob).book
+		b.SetError(err)
 		bm.finishBook(b)
-	case book.BookResult:
-	case []book.BookResult:
-	case providers.SearchTerms:
 	default:
-		log.Printf("warning: fail handler cannot handle type %s with %s\n", a, err.Error())
+		slog.Warn("fail handler cannot handle type", "type", fmt.Sprintf("%T", a), "error", err)
 	}
 }
@@ -0,0 +1,380 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/stats"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// bookWriter keeps every book written to it
+type bookWriter struct {
+	lock  sync.Mutex
+	books []*book.Book
+}
+
+func (w *bookWriter) WriteObject(bk *book.Book) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.books = append(w.books, bk)
+}
+
+func (w *bookWriter) Close() {}
+
+// paths are the sorted paths of the books written
+func (w *bookWriter) paths() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	paths := make([]string, 0, len(w.books))
+	for _, bk := range w.books {
+		paths = append(paths, bk.Filepath)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// scanOffline scans the books under scanPath with only the EPUB extractor, keeping the paths of the books written
+func scanOffline(t *testing.T, scanPath string, followSymlinks bool) []string {
+	conf := &config.Config{Offline: true}
+	conf.Epub.Enable = true
+	conf.Scan.FollowSymlinks = followSymlinks
+	paths, _ := scan(t, conf, scanPath)
+	return paths
+}
+
+// scan scans the books under scanPath with conf, returning the paths of the books written and the books discovered
+func scan(t *testing.T, conf *config.Config, scanPath string) ([]string, int64) {
+	bm, err := internal.NewBookManager(conf, 2)
+	assert.NoError(t, err)
+	defer bm.Shutdown()
+
+	writer := &bookWriter{}
+	err = bm.Scan(context.Background(), scanPath, false, false, writer)
+	assert.NoError(t, err)
+	return writer.paths(), bm.Stats().Report().Discovered
+}
+
+func TestScanSymlinks(t *testing.T) {
+	lib := t.TempDir()
+	for _, dir := range []string{"a", "c"} {
+		assert.NoError(t, os.Mkdir(filepath.Join(lib, dir), 0o755))
+	}
+	for _, name := range []string{"a/one.epub", "c/two.epub"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(lib, name), []byte("not really an epub"), 0o644))
+	}
+	// a cycle back to the library, a second path to a directory and a second path to a book
+	if err := os.Symlink("..", filepath.Join(lib, "a", "loop")); err != nil {
+		t.Skip("symlinks can't be made here")
+	}
+	assert.NoError(t, os.Symlink("a", filepath.Join(lib, "b")))
+	assert.NoError(t, os.Symlink(filepath.Join("..", "a", "one.epub"), filepath.Join(lib, "c", "alias.epub")))
+
+	want := []string{filepath.Join(lib, "a", "one.epub"), filepath.Join(lib, "c", "two.epub")}
+	assert.Equal(t, want, scanOffline(t, lib, true))
+	assert.Equal(t, want, scanOffline(t, lib, false))
+
+	// a symlink to the library is scanned as the library, each book under the path it was found by
+	link := filepath.Join(t.TempDir(), "library")
+	assert.NoError(t, os.Symlink(lib, link))
+	assert.Equal(t, []string{filepath.Join(link, "a", "one.epub"), filepath.Join(link, "c", "two.epub")}, scanOffline(t, link, true))
+}
+
+// writeBooks writes a file that isn't really a book at each of names under lib, making the directories they are in
+func writeBooks(t *testing.T, lib string, names ...string) {
+	for _, name := range names {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(lib, name)), 0o755))
+		assert.NoError(t, os.WriteFile(filepath.Join(lib, name), []byte("not really a book "+name), 0o644))
+	}
+}
+
+// under are the paths of names under lib
+func under(lib string, names ...string) []string {
+	paths := make([]string, 0, len(names))
+	for _, name := range names {
+		paths = append(paths, filepath.Join(lib, name))
+	}
+	return paths
+}
+
+func TestScanLimits(t *testing.T) {
+	lib := t.TempDir()
+	writeBooks(t, lib, "top.epub", "a/one.epub", "a/b/two.epub", "many/0.epub", "many/1.epub", "many/2.epub", "many/3.epub")
+	limited := func(maxDepth uint, maxFiles uint) []string {
+		conf := &config.Config{Offline: true}
+		conf.Epub.Enable = true
+		conf.Scan.MaxDepth = maxDepth
+		conf.Scan.MaxFiles = maxFiles
+		paths, _ := scan(t, conf, lib)
+		return paths
+	}
+
+	all := under(lib, "a/b/two.epub", "a/one.epub", "many/0.epub", "many/1.epub", "many/2.epub", "many/3.epub", "top.epub")
+	assert.Equal(t, all, limited(0, 0))
+	// the scan path is at depth 0, so a depth of 1 walks the directories in it but not theirs
+	assert.Equal(t, under(lib, "a/one.epub", "many/0.epub", "many/1.epub", "many/2.epub", "many/3.epub", "top.epub"), limited(1, 0))
+	// only the first files of each directory are taken, in the order they are walked
+	assert.Equal(t, under(lib, "a/b/two.epub", "a/one.epub", "many/0.epub", "many/1.epub", "top.epub"), limited(0, 2))
+}
+
+func TestScanUnreadable(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the extractor is a script run with sh")
+	}
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	// reading the first book removes a directory the walk has listed but not yet walked, which is skipped while the
+	// directory after it is still walked. There is only the one extractor thread and room for two books ahead of it, so
+	// the fourth book isn't submitted until the first has been read.
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "library")
+	writeBooks(t, lib, "a/0.md", "a/1.md", "a/2.md", "a/3.md", "b/gone.md", "c/after.md")
+	extractor := filepath.Join(dir, "extractor")
+	script := "#!/bin/sh\ncase \"$1\" in */0.md) rm -rf '" + filepath.Join(lib, "b") + "' ;; esac\ncat \"$1\"\n"
+	assert.NoError(t, os.WriteFile(extractor, []byte(script), 0o755))
+	conf := &config.Config{Offline: true, Commands: []config.CommandConfig{{Name: "remover", Command: extractor + " {file}", Extensions: []string{".md"}}}}
+	paths, _ := scan(t, conf, lib)
+	assert.Equal(t, under(lib, "a/0.md", "a/1.md", "a/2.md", "a/3.md", "c/after.md"), paths)
+	assert.NotContains(t, logs.String(), "failed to completely scan")
+
+	// a directory that can't be listed is skipped the same way, for anyone it applies to
+	if os.Geteuid() == 0 {
+		return
+	}
+	writeBooks(t, lib, "locked/hidden.md")
+	assert.NoError(t, os.Chmod(filepath.Join(lib, "locked"), 0))
+	defer os.Chmod(filepath.Join(lib, "locked"), 0o755)
+	paths, _ = scan(t, conf, lib)
+	assert.Equal(t, under(lib, "a/0.md", "a/1.md", "a/2.md", "a/3.md", "c/after.md"), paths)
+	assert.Contains(t, logs.String(), `msg="skipping what could not be read" path=`+filepath.Join(lib, "locked"))
+}
+
+func TestScanFilters(t *testing.T) {
+	lib := t.TempDir()
+	writeBooks(t, lib, "novel.epub", "draft.tmp.epub", "samples/sample.epub", "fiction/dune.epub", "fiction/sci/foundation.epub", "fiction/samples/excerpt.epub")
+	filtered := func(include []string, exclude []string) ([]string, int64) {
+		conf := &config.Config{Offline: true}
+		conf.Epub.Enable = true
+		conf.Scan.Include = include
+		conf.Scan.Exclude = exclude
+		bm, err := internal.NewBookManager(conf, 2)
+		assert.NoError(t, err)
+		defer bm.Shutdown()
+		writer := &bookWriter{}
+		assert.NoError(t, bm.Scan(context.Background(), lib, false, false, writer))
+		return writer.paths(), bm.Stats().Report().Excluded
+	}
+
+	// an excluded directory is left out along with everything in it, wherever it is, and isn't counted as a file
+	paths, excluded := filtered(nil, []string{"samples/", "*.tmp.epub"})
+	assert.Equal(t, under(lib, "fiction/dune.epub", "fiction/sci/foundation.epub", "novel.epub"), paths)
+	assert.Equal(t, int64(1), excluded)
+
+	// a pattern with a slash in it is matched against the whole path under the scan path
+	paths, _ = filtered(nil, []string{"fiction/sci/"})
+	assert.Equal(t, under(lib, "draft.tmp.epub", "fiction/dune.epub", "fiction/samples/excerpt.epub", "novel.epub", "samples/sample.epub"), paths)
+
+	// only the files matching an include are taken, and an exclude still wins over it
+	paths, excluded = filtered([]string{"fiction/**"}, []string{"samples/"})
+	assert.Equal(t, under(lib, "fiction/dune.epub", "fiction/sci/foundation.epub"), paths)
+	assert.Equal(t, int64(2), excluded)
+}
+
+func TestScanWebdav(t *testing.T) {
+	listings := map[string][]string{
+		"/dav/Books/":     {"/dav/Books/", "/dav/Books/Sub/", "/dav/Books/a.epub"},
+		"/dav/Books/Sub/": {"/dav/Books/Sub/", "/dav/Books/Sub/b.epub"},
+	}
+	var lock sync.Mutex
+	listed := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PROPFIND" {
+			fmt.Fprint(w, "not really an epub")
+			return
+		}
+		lock.Lock()
+		listed[r.URL.Path]++
+		lock.Unlock()
+		responses := make([]string, 0)
+		for _, href := range listings[r.URL.Path] {
+			resourceType := ""
+			if strings.HasSuffix(href, "/") {
+				resourceType = "<d:collection/>"
+			}
+			responses = append(responses, fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype>%s</d:resourcetype></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, resourceType))
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">%s</d:multistatus>`, strings.Join(responses, ""))
+	}))
+	defer server.Close()
+
+	conf := &config.Config{Offline: true}
+	conf.Epub.Enable = true
+	conf.Webdav.Enable = true
+	paths, discovered := scan(t, conf, server.URL+"/dav/Books/")
+	assert.Equal(t, []string{server.URL + "/dav/Books/Sub/b.epub", server.URL + "/dav/Books/a.epub"}, paths)
+	assert.Equal(t, int64(2), discovered)
+	// the server is only listed once, rather than once to count the books and again to submit them
+	assert.Equal(t, map[string]int{"/dav/Books/": 1, "/dav/Books/Sub/": 1}, listed)
+}
+
+func TestSearchProviders(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the providers are plugins run with sh")
+	}
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "library")
+	assert.NoError(t, os.Mkdir(lib, 0o755))
+	assert.NoError(t, os.WriteFile(filepath.Join(lib, "book.md"), []byte("ISBN 978-1-7185-0126-3\n"), 0o644))
+	plugin := func(name string, delay string) config.PluginConfig {
+		command := filepath.Join(dir, name)
+		answer := fmt.Sprintf(`{"title": "Book", "authors": ["A Writer"], "isbn13": "9781718501263", "publisher": "%s"}`, name)
+		assert.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\ncat >/dev/null\nsleep "+delay+"\necho '"+answer+"'\n"), 0o755))
+		return config.PluginConfig{Name: name, Command: command, Lookups: []string{"isbn"}, Retry: config.RetryConfig{MaxAttempts: 1}}
+	}
+	search := func(earlyExit float64, slowDelay string) (*book.Book, stats.Report) {
+		conf := &config.Config{Commands: []config.CommandConfig{{Name: "cat", Command: "cat {file}", Extensions: []string{".md"}}}}
+		conf.Plugins = []config.PluginConfig{plugin("Slow", slowDelay), plugin("Fast", "0")}
+		conf.Advanced.EarlyExitConfidence = earlyExit
+		bm, err := internal.NewBookManager(conf, 2)
+		assert.NoError(t, err)
+		defer bm.Shutdown()
+		writer := &bookWriter{}
+		assert.NoError(t, bm.Scan(context.Background(), lib, false, false, writer))
+		assert.Len(t, writer.books, 1)
+		return writer.books[0], bm.Stats().Report()
+	}
+
+	// the fast provider is confident, so the slow one is cancelled rather than waited on and isn't counted
+	began := time.Now()
+	bk, report := search(60, "30")
+	assert.Less(t, time.Since(began), 15*time.Second)
+	assert.Equal(t, "Fast", bk.Publisher)
+	assert.Equal(t, map[string]stats.ProviderCounts{"Fast": {Found: 1}}, report.Providers)
+
+	// waiting on both, the results are in the order of the providers rather than that of their answers
+	bk, report = search(0, "1")
+	assert.Equal(t, "Slow", bk.Publisher)
+	assert.Equal(t, map[string]stats.ProviderCounts{"Fast": {Found: 1}, "Slow": {Found: 1}}, report.Providers)
+}
+
+func TestScanUnsupportedUrl(t *testing.T) {
+	conf := &config.Config{Offline: true}
+	conf.Epub.Enable = true
+	bm, err := internal.NewBookManager(conf, 2)
+	assert.NoError(t, err)
+	defer bm.Shutdown()
+	err = bm.Scan(context.Background(), "sftp://nas.local/books", false, false, &bookWriter{})
+	assert.EqualError(t, err, "error: SFTP servers can't be read from yet, mount sftp://nas.local/books with sshfs and use the mount instead")
+
+	// a listed URL is skipped with the same reason, rather than taken for a relative path that doesn't exist
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	lib := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(lib, "one.epub"), []byte("not really an epub"), 0o644))
+	bm, err = internal.NewBookManager(conf, 2)
+	assert.NoError(t, err)
+	defer bm.Shutdown()
+	writer := &bookWriter{}
+	list := strings.NewReader("sftp://nas.local/books\n" + filepath.Join(lib, "one.epub") + "\n")
+	assert.NoError(t, bm.ScanList(context.Background(), list, false, writer))
+	assert.Equal(t, []string{filepath.Join(lib, "one.epub")}, writer.paths())
+	assert.Contains(t, logs.String(), `msg="skipping listed path" path=sftp://nas.local/books error="SFTP servers can't be read from yet`)
+}
+
+func TestOutage(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the provider is a plugin run with sh")
+	}
+	internal.SetCheckIntervals(t, 10*time.Millisecond, 10*time.Millisecond)
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "library")
+	assert.NoError(t, os.Mkdir(lib, 0o755))
+	// the provider is down while its command is missing, like a server that is being restarted
+	command := filepath.Join(dir, "plugin")
+	up := func() {
+		answer := `{"title": "Book", "authors": ["A Writer"], "isbn13": "9781718501263"}`
+		assert.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\ncat >/dev/null\necho '"+answer+"'\n"), 0o755))
+	}
+	up()
+
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	scanDown := func(books int, threads int64, outageTimeout uint, whileDown func(bm *internal.BookManager)) (*bookWriter, error) {
+		assert.NoError(t, os.RemoveAll(lib))
+		assert.NoError(t, os.Mkdir(lib, 0o755))
+		for n := range books {
+			contents := fmt.Sprintf("book %d\nISBN 978-1-7185-0126-3\n", n)
+			assert.NoError(t, os.WriteFile(filepath.Join(lib, fmt.Sprintf("%d.md", n)), []byte(contents), 0o644))
+		}
+		logs.Reset()
+
+		conf := &config.Config{Commands: []config.CommandConfig{{Name: "cat", Command: "cat {file}", Extensions: []string{".md"}}}}
+		conf.Plugins = []config.PluginConfig{{Name: "Plugin", Command: command, Lookups: []string{"isbn"}, Retry: config.RetryConfig{MaxAttempts: 1}}}
+		conf.Advanced.TimeoutSeconds = 1
+		conf.Advanced.OutageTimeoutSeconds = outageTimeout
+		bm, err := internal.NewBookManager(conf, threads)
+		assert.NoError(t, err)
+		defer bm.Shutdown()
+
+		assert.NoError(t, os.Remove(command))
+		assert.Eventually(t, func() bool { return len(bm.Status().LiveProviders) == 0 }, 5*time.Second, 10*time.Millisecond)
+		writer := &bookWriter{}
+		scanned := make(chan error)
+		go func() {
+			scanned <- bm.Scan(context.Background(), lib, false, false, writer)
+		}()
+		assert.Eventually(t, func() bool { return bm.Status().Paused }, 5*time.Second, 10*time.Millisecond)
+		whileDown(bm)
+		return writer, <-scanned
+	}
+
+	// the books wait out the outage together, for longer than their own timeout_seconds, and none of them fails. There
+	// are enough threads for every book to be searched at once.
+	writer, err := scanDown(4, 28, 30, func(bm *internal.BookManager) {
+		time.Sleep(2 * time.Second)
+		assert.True(t, bm.Status().Paused)
+		assert.Equal(t, int64(4), bm.Status().InFlight)
+		up()
+	})
+	assert.NoError(t, err)
+	assert.Len(t, writer.books, 4)
+	for _, bk := range writer.books {
+		assert.Empty(t, bk.ErrorMessage, bk.Filepath)
+		assert.Equal(t, "Book", bk.Title)
+	}
+	assert.Equal(t, 1, strings.Count(logs.String(), `msg="every service is down, pausing until one is back"`))
+	assert.Contains(t, logs.String(), `msg="services are back, resuming" services=providers`)
+
+	// if none is back in time the scan is interrupted, after the one deadline however many books are waiting. Those
+	// books fail with why, and the books that hadn't started are left out for the next scan to pick up.
+	began := time.Now()
+	writer, err = scanDown(20, 14, 1, func(*internal.BookManager) {})
+	assert.EqualError(t, err, "error: all providers down")
+	assert.Less(t, time.Since(began), 5*time.Second)
+	assert.Greater(t, len(writer.books), 1)
+	assert.Less(t, len(writer.books), 20)
+	for _, bk := range writer.books {
+		assert.Equal(t, "error: no live providers found for 1s", bk.ErrorMessage, bk.Filepath)
+	}
+	assert.Equal(t, 1, strings.Count(logs.String(), `msg="every service is down, pausing until one is back"`))
+	up()
+}
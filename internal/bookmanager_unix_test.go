@@ -0,0 +1,40 @@
+//go:build !windows
+
+package internal_test
+
+import (
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestScanOneFileSystem(t *testing.T) {
+	lib := t.TempDir()
+	writeBooks(t, lib, "top.epub", "a/one.epub")
+	// a directory on another file system, here reached through a symlink, is left out with --one-file-system
+	other, err := os.MkdirTemp("/dev/shm", "booker-")
+	if err != nil {
+		t.Skip("there's no other file system to scan here")
+	}
+	defer os.RemoveAll(other)
+	var libStat, otherStat syscall.Stat_t
+	if syscall.Stat(lib, &libStat) != nil || syscall.Stat(other, &otherStat) != nil || libStat.Dev == otherStat.Dev {
+		t.Skip("there's no other file system to scan here")
+	}
+	writeBooks(t, other, "mounted.epub")
+	assert.NoError(t, os.Symlink(other, filepath.Join(lib, "mount")))
+
+	oneFileSystem := func(enable bool) []string {
+		conf := &config.Config{Offline: true}
+		conf.Epub.Enable = true
+		conf.Scan.FollowSymlinks = true
+		conf.Scan.OneFileSystem = enable
+		paths, _ := scan(t, conf, lib)
+		return paths
+	}
+	assert.Equal(t, under(lib, "a/one.epub", "mount/mounted.epub", "top.epub"), oneFileSystem(false))
+	assert.Equal(t, under(lib, "a/one.epub", "top.epub"), oneFileSystem(true))
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/webdav"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Filter picks the books of a cache an operation applies to, the zero value picks every book
+type Filter struct {
+	// Errored only picks books that failed
+	Errored bool
+	// Provider only picks books with at least one field from this provider, as named in their provenance
+	Provider string
+}
+
+func (f Filter) Matches(bk *book.Book) bool {
+	if f.Errored && len(bk.ErrorMessage) == 0 {
+		return false
+	}
+	if len(f.Provider) > 0 {
+		for _, source := range bk.Provenance {
+			if strings.EqualFold(source, f.Provider) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Select is the books matching the filter, sorted by filepath
+func Select(books map[string]book.Book, filter Filter) []book.Book {
+	selected := make([]book.Book, 0)
+	for _, bk := range books {
+		if filter.Matches(&bk) {
+			selected = append(selected, bk)
+		}
+	}
+	slices.SortFunc(selected, func(a, b book.Book) int {
+		return strings.Compare(a.Filepath, b.Filepath)
+	})
+	return selected
+}
+
+// Prune removes the books matching the filter, returning how many were removed
+func Prune(books map[string]book.Book, filter Filter) int {
+	var pruned int
+	for p, bk := range books {
+		if filter.Matches(&bk) {
+			delete(books, p)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// rebasePath replaces the from prefix of filePath with to. Only whole path elements match, so /books doesn't rebase
+// /bookshelf, and the path of a book inside an archive is rebased by the path of the archive. Either separator ends an
+// element, since a cache can have been made on Windows.
+func rebasePath(filePath string, from string, to string) (string, bool) {
+	rest, ok := strings.CutPrefix(filePath, from)
+	if !ok {
+		return filePath, false
+	}
+	if len(rest) > 0 && !strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, `\`) && !strings.HasPrefix(rest, archive.Separator) {
+		return filePath, false
+	}
+	return to + rest, true
+}
+
+// Rebase moves every book under from to the same place under to, like after the library was moved, returning how many
+// were moved. The paths of duplicates, covers and thumbnails under from are moved along with them.
+func Rebase(books map[string]book.Book, from string, to string) int {
+	from = strings.TrimRight(from, `/\`)
+	to = strings.TrimRight(to, `/\`)
+
+	var rebased int
+	// the keys are taken first, since a book rebased under the old path mustn't be rebased again
+	for _, p := range slices.Collect(maps.Keys(books)) {
+		bk := books[p]
+		var moved bool
+		bk.Filepath, moved = rebasePath(bk.Filepath, from, to)
+		bk.DuplicateOf, _ = rebasePath(bk.DuplicateOf, from, to)
+		bk.Cover, _ = rebasePath(bk.Cover, from, to)
+		bk.Thumbnail, _ = rebasePath(bk.Thumbnail, from, to)
+		if !moved {
+			books[p] = bk
+			continue
+		}
+		delete(books, p)
+		books[bk.Filepath] = bk
+		rebased++
+	}
+	return rebased
+}
+
+// Problem is something wrong with one book in a cache
+type Problem struct {
+	Filepath string
+	Message  string
+}
+
+// Parse reads a cache, giving the line and column of where it stops being valid JSON
+func Parse(data []byte) (map[string]book.Book, error) {
+	var books map[string]book.Book
+	err := json.Unmarshal(data, &books)
+	if err == nil {
+		return books, nil
+	}
+
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return nil, err
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return nil, fmt.Errorf("line %d, column %d: %s", line, column, err.Error())
+}
+
+// Validate checks every book in a cache, with missing also reporting the books whose files are gone
+func Validate(books map[string]book.Book, missing bool) []Problem {
+	problems := make([]Problem, 0)
+	report := func(p string, format string, args ...any) {
+		problems = append(problems, Problem{Filepath: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for p, bk := range books {
+		if len(bk.Filepath) == 0 {
+			report(p, "has no filepath")
+		} else if bk.Filepath != p {
+			report(p, "is keyed by a different path than its filepath %s", bk.Filepath)
+		}
+		if len(bk.Isbn10) > 0 && !bk.Isbn10.IsValid() {
+			report(p, "has an invalid ISBN-10 %s", bk.Isbn10)
+		}
+		if len(bk.Isbn13) > 0 && !bk.Isbn13.IsValid() {
+			report(p, "has an invalid ISBN-13 %s", bk.Isbn13)
+		}
+		if bk.LowYear > 0 && bk.HighYear > 0 && bk.LowYear > bk.HighYear {
+			report(p, "has a low_year %d after its high_year %d", bk.LowYear, bk.HighYear)
+		}
+		if bk.Confidence < 0 || bk.Confidence > 100 {
+			report(p, "has a confidence of %.0f, outside of 0 to 100", bk.Confidence)
+		}
+		// books on a WebDAV server can't be checked without asking it
+		if missing && len(bk.Filepath) > 0 && !webdav.IsUrl(bk.Filepath) && !archive.Exists(bk.Filepath) {
+			report(p, "its file no longer exists")
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a, b Problem) int {
+		return strings.Compare(a.Filepath, b.Filepath)
+	})
+	return problems
+}
@@ -0,0 +1,86 @@
+package cache_test
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testBooks() map[string]book.Book {
+	return map[string]book.Book{
+		"/books/ghost.pdf": {
+			Title:      "How to Hack Like a Ghost",
+			Isbn13:     "9781718501263",
+			Filepath:   "/books/ghost.pdf",
+			Cover:      "/books/.covers/ghost.jpg",
+			Provenance: map[string]string{"title": "google", "isbn13": "google"},
+		},
+		"/books/papers.zip::draft.pdf": {
+			Filepath:     "/books/papers.zip::draft.pdf",
+			ErrorMessage: "no texts extracted",
+		},
+		"/bookshelf/saga.cbz": {
+			Title:      "Saga",
+			Filepath:   "/bookshelf/saga.cbz",
+			Provenance: map[string]string{"title": "comic"},
+		},
+	}
+}
+
+func TestPrune(t *testing.T) {
+	books := testBooks()
+	assert.Len(t, cache.Select(books, cache.Filter{Provider: "Google"}), 1)
+	assert.Equal(t, "/books/ghost.pdf", cache.Select(books, cache.Filter{})[0].Filepath)
+
+	assert.Equal(t, 1, cache.Prune(books, cache.Filter{Errored: true}))
+	assert.Len(t, books, 2)
+	assert.NotContains(t, books, "/books/papers.zip::draft.pdf")
+
+	assert.Equal(t, 1, cache.Prune(books, cache.Filter{Provider: "comic"}))
+	assert.Contains(t, books, "/books/ghost.pdf")
+}
+
+func TestRebase(t *testing.T) {
+	books := testBooks()
+	assert.Equal(t, 2, cache.Rebase(books, "/books/", "/books/library"))
+
+	bk := books["/books/library/ghost.pdf"]
+	assert.Equal(t, "/books/library/ghost.pdf", bk.Filepath)
+	assert.Equal(t, "/books/library/.covers/ghost.jpg", bk.Cover)
+	assert.Contains(t, books, "/books/library/papers.zip::draft.pdf")
+	// only whole path elements are rebased
+	assert.Contains(t, books, "/bookshelf/saga.cbz")
+	assert.Len(t, books, 3)
+}
+
+func TestRebaseWindows(t *testing.T) {
+	books := map[string]book.Book{
+		`C:\Books\dune.epub`:         {Filepath: `C:\Books\dune.epub`},
+		`C:\Bookshelf\saga.cbz`:      {Filepath: `C:\Bookshelf\saga.cbz`},
+		`\\nas\share\Books\emma.pdf`: {Filepath: `\\nas\share\Books\emma.pdf`},
+	}
+	assert.Equal(t, 1, cache.Rebase(books, `C:\Books\`, `D:\Library`))
+	assert.Contains(t, books, `D:\Library\dune.epub`)
+	assert.Contains(t, books, `C:\Bookshelf\saga.cbz`)
+
+	assert.Equal(t, 1, cache.Rebase(books, `\\nas\share`, `E:\`))
+	assert.Contains(t, books, `E:\Books\emma.pdf`)
+}
+
+func TestValidate(t *testing.T) {
+	books := testBooks()
+	assert.Empty(t, cache.Validate(books, false))
+
+	books["/books/moved.pdf"] = book.Book{Title: "Moved", Filepath: "/books/elsewhere.pdf", Isbn13: "9781718501264"}
+	problems := cache.Validate(books, false)
+	assert.Len(t, problems, 2)
+	assert.Equal(t, "/books/moved.pdf", problems[0].Filepath)
+
+	assert.Len(t, cache.Validate(books, true), 6)
+
+	_, err := cache.Parse([]byte("{\n  \"/books/a.pdf\": {\"title\": \"A\",}\n}"))
+	assert.ErrorContains(t, err, "line 2, column 34")
+	_, err = cache.Parse([]byte(`{"/books/a.pdf": {"pages": "many"}}`))
+	assert.Error(t, err)
+}
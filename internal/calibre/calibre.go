@@ -0,0 +1,515 @@
+package calibre
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/larkwiot/booker/internal/webdav"
+	"io"
+	"log/slog"
+	"modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const databaseName = "metadata.db"
+
+// pathComponentLimit keeps paths about as short as Calibre's own, which cuts them off at a similar length
+const pathComponentLimit = 100
+
+var titleArticles = []string{"A ", "An ", "The "}
+
+func init() {
+	// Calibre's triggers call these, which Calibre itself normally provides to the database
+	sqlite.MustRegisterDeterministicScalarFunction("title_sort", 1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		title, _ := args[0].(string)
+		return titleSort(title), nil
+	})
+	sqlite.MustRegisterScalarFunction("uuid4", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return uuid.NewString(), nil
+	})
+}
+
+// titleSort moves a leading article to the end, like "Hobbit, The"
+func titleSort(title string) string {
+	for _, article := range titleArticles {
+		if len(title) > len(article) && strings.EqualFold(title[:len(article)], article) {
+			return fmt.Sprintf("%s, %s", title[len(article):], strings.TrimSpace(title[:len(article)]))
+		}
+	}
+	return title
+}
+
+// authorSort turns "First Middle Last" into "Last, First Middle"
+func authorSort(author string) string {
+	names := strings.Fields(author)
+	if len(names) < 2 || strings.Contains(author, ",") {
+		return author
+	}
+	return fmt.Sprintf("%s, %s", names[len(names)-1], strings.Join(names[:len(names)-1], " "))
+}
+
+// sanitizePathComponent replaces what Calibre doesn't allow in its directory and file names
+func sanitizePathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\?<>:*|"`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, s)
+	for len(s) > pathComponentLimit {
+		_, size := utf8.DecodeLastRuneInString(s)
+		s = s[:len(s)-size]
+	}
+	s = strings.Trim(s, " .")
+	if len(s) == 0 {
+		return "Unknown"
+	}
+	return s
+}
+
+// IsLibrary reports whether libraryPath is a Calibre library directory
+func IsLibrary(libraryPath string) bool {
+	exists, _ := util.PathExists(filepath.Join(libraryPath, databaseName))
+	return exists
+}
+
+func openDatabase(libraryPath string, readOnly bool) (*sql.DB, error) {
+	if !IsLibrary(libraryPath) {
+		return nil, fmt.Errorf("error: %s is not a Calibre library, create an empty library in Calibre first", libraryPath)
+	}
+
+	dsn := filepath.Join(libraryPath, databaseName)
+	if readOnly {
+		dsn = fmt.Sprintf("file:%s?mode=ro", dsn)
+	}
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// Calibre expects one writer at a time
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// ReadLibrary returns a book for every file in a Calibre library, hashing each one so they can be used as a cache
+func ReadLibrary(libraryPath string) ([]book.Book, error) {
+	db, err := openDatabase(libraryPath, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	identifiers := make(map[int64]map[string]string)
+	rows, err := db.Query("SELECT book, type, val FROM identifiers")
+	if err != nil {
+		return nil, fmt.Errorf("error: unable to read Calibre identifiers: %s", err.Error())
+	}
+	for rows.Next() {
+		var id int64
+		var kind, value string
+		err = rows.Scan(&id, &kind, &value)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if _, exists := identifiers[id]; !exists {
+			identifiers[id] = make(map[string]string)
+		}
+		identifiers[id][strings.ToLower(kind)] = value
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+SELECT b.id, b.title, b.path, COALESCE(b.pubdate, ''),
+	COALESCE((SELECT group_concat(a.name, char(31)) FROM books_authors_link l JOIN authors a ON a.id = l.author WHERE l.book = b.id), ''),
+	COALESCE((SELECT p.name FROM books_publishers_link l JOIN publishers p ON p.id = l.publisher WHERE l.book = b.id), ''),
+	COALESCE((SELECT s.name FROM books_series_link l JOIN series s ON s.id = l.series WHERE l.book = b.id), ''), b.series_index,
+	COALESCE((SELECT group_concat(t.name, char(31)) FROM books_tags_link l JOIN tags t ON t.id = l.tag WHERE l.book = b.id), ''),
+	COALESCE((SELECT c.text FROM comments c WHERE c.book = b.id), ''),
+	d.format, d.name
+FROM books b JOIN data d ON d.book = b.id`)
+	if err != nil {
+		return nil, fmt.Errorf("error: unable to read Calibre books: %s", err.Error())
+	}
+	defer rows.Close()
+
+	books := make([]book.Book, 0)
+	for rows.Next() {
+		var id int64
+		var title, bookPath, pubdate, authors, publisher, series, tags, comments, format, name string
+		var seriesIndex float64
+		err = rows.Scan(&id, &title, &bookPath, &pubdate, &authors, &publisher, &series, &seriesIndex, &tags, &comments, &format, &name)
+		if err != nil {
+			return nil, err
+		}
+
+		bk := book.Book{
+			Title:       title,
+			Publisher:   publisher,
+			Description: comments,
+			Filepath:    filepath.Join(libraryPath, filepath.FromSlash(bookPath), fmt.Sprintf("%s.%s", name, strings.ToLower(format))),
+		}
+
+		if len(tags) > 0 {
+			bk.Subjects = strings.Split(tags, "\x1f")
+		}
+
+		// every book has a series index, even those not in a series
+		if len(series) > 0 {
+			bk.Series = series
+			bk.SeriesIndex = seriesIndex
+		}
+
+		if len(authors) > 0 {
+			for _, author := range strings.Split(authors, "\x1f") {
+				// Calibre stores commas in author names as |
+				bk.Authors = append(bk.Authors, strings.ReplaceAll(author, "|", ","))
+			}
+		}
+
+		// Calibre marks an unknown publication date with the year 101
+		if len(pubdate) >= 10 && pubdate[:4] > "0999" {
+			bk.PublishDate = pubdate[:10]
+		}
+
+		for kind, value := range identifiers[id] {
+			switch kind {
+			case "isbn":
+				value = strings.ReplaceAll(value, "-", "")
+				if len(value) == 13 {
+					bk.Isbn13 = book.ISBN13(value)
+				} else if len(value) == 10 {
+					bk.Isbn10 = book.ISBN10(value)
+				}
+			case "doi":
+				bk.Doi = book.DOI(strings.ToLower(value))
+			case "oclc":
+				bk.Oclc = value
+			case "amazon":
+				bk.Asin = book.ASIN(strings.ToUpper(value))
+			case "arxiv":
+				bk.Arxiv = book.ArxivId(value)
+			}
+		}
+
+		hash, err := util.HashFile(bk.Filepath)
+		if err != nil {
+			slog.Warn("skipping missing Calibre book file", "path", bk.Filepath, "error", err)
+			continue
+		}
+		bk.Hash = hash
+
+		books = append(books, bk)
+	}
+
+	return books, rows.Err()
+}
+
+// Writer adds books to an existing Calibre library, copying their files into Calibre's directory layout
+type Writer struct {
+	LibraryPath     string
+	Input           chan *book.Book
+	waiter          sync.WaitGroup
+	db              *sql.DB
+	hasDirtiedTable bool
+}
+
+func NewWriter(libraryPath string) (*Writer, error) {
+	db, err := openDatabase(libraryPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name IN ('books', 'authors', 'books_authors_link', 'data', 'identifiers')").Scan(&count)
+	if err != nil || count != 5 {
+		db.Close()
+		return nil, fmt.Errorf("error: %s does not look like a Calibre database", filepath.Join(libraryPath, databaseName))
+	}
+
+	writer := &Writer{
+		LibraryPath: libraryPath,
+		Input:       make(chan *book.Book, 10000),
+		waiter:      sync.WaitGroup{},
+		db:          db,
+	}
+
+	// books listed here get their metadata.opf backup written the next time Calibre runs
+	err = db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'metadata_dirtied'").Scan(&count)
+	writer.hasDirtiedTable = err == nil && count == 1
+
+	writer.waiter.Add(1)
+	go writer.writer()
+
+	return writer, nil
+}
+
+func (writer *Writer) writer() {
+	defer writer.waiter.Done()
+
+	for bk := range writer.Input {
+		if len(bk.ErrorMessage) > 0 || len(bk.Title) == 0 {
+			continue
+		}
+		if archive.IsMember(bk.Filepath) {
+			slog.Info("skipping book inside an archive, Calibre needs a file of its own", "path", bk.Filepath)
+			continue
+		}
+		if webdav.IsUrl(bk.Filepath) {
+			slog.Info("skipping book on a WebDAV server, Calibre needs a file on disk", "path", bk.Filepath)
+			continue
+		}
+
+		err := writer.addBook(bk)
+		if err != nil {
+			slog.Error("failed to add book to Calibre library", "path", bk.Filepath, "library", writer.LibraryPath, "error", err)
+		}
+	}
+}
+
+// findExisting returns the id of a book already in the library with the same ISBN, or the same title and authors
+func (writer *Writer) findExisting(tx *sql.Tx, bk *book.Book) (int64, bool) {
+	var id int64
+
+	if isbn := bestIsbn(bk); len(isbn) > 0 {
+		err := tx.QueryRow("SELECT book FROM identifiers WHERE type = 'isbn' AND replace(val, '-', '') = ?", isbn).Scan(&id)
+		return id, err == nil
+	}
+
+	err := tx.QueryRow(`
+SELECT b.id FROM books b
+WHERE b.title = ? AND COALESCE((SELECT group_concat(a.name, char(31)) FROM books_authors_link l JOIN authors a ON a.id = l.author WHERE l.book = b.id), '') = ?`,
+		bk.Title, strings.Join(calibreAuthorNames(bk.Authors), "\x1f")).Scan(&id)
+	return id, err == nil
+}
+
+func bestIsbn(bk *book.Book) string {
+	if len(bk.Isbn13) > 0 {
+		return string(bk.Isbn13)
+	}
+	return string(bk.Isbn10)
+}
+
+func calibreAuthorNames(authors []string) []string {
+	names := make([]string, 0, len(authors))
+	for _, author := range authors {
+		names = append(names, strings.ReplaceAll(author, ",", "|"))
+	}
+	return names
+}
+
+func (writer *Writer) addBook(bk *book.Book) error {
+	info, err := os.Stat(bk.Filepath)
+	if err != nil {
+		return err
+	}
+
+	tx, err := writer.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if id, exists := writer.findExisting(tx, bk); exists {
+		slog.Info("skipping book already in the Calibre library", "path", bk.Filepath, "id", id)
+		return nil
+	}
+
+	authors := bk.Authors
+	if len(authors) == 0 {
+		authors = []string{"Unknown"}
+	}
+
+	pubdate := "0101-01-01 00:00:00+00:00"
+	date := bk.PublishDate
+	if len(date) < 4 && bk.LowYear > 0 {
+		date = fmt.Sprintf("%04d", bk.LowYear)
+	}
+	if len(date) >= 4 {
+		if len(date) == 4 {
+			date += "-01-01"
+		} else if len(date) == 7 {
+			date += "-01"
+		}
+		pubdate = fmt.Sprintf("%s 00:00:00+00:00", date[:min(len(date), 10)])
+	}
+
+	result, err := tx.Exec("INSERT INTO books (title, author_sort, pubdate, timestamp, last_modified) VALUES (?, ?, ?, datetime('now') || '+00:00', datetime('now') || '+00:00')",
+		bk.Title, authorSort(authors[0]), pubdate)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	authorDir := sanitizePathComponent(strings.Join(authors, " & "))
+	bookDir := path(authorDir, fmt.Sprintf("%s (%d)", sanitizePathComponent(bk.Title), id))
+	name := sanitizePathComponent(fmt.Sprintf("%s - %s", bk.Title, strings.Join(authors, " & ")))
+	format := strings.ToUpper(strings.TrimPrefix(filepath.Ext(bk.Filepath), "."))
+
+	_, err = tx.Exec("UPDATE books SET path = ? WHERE id = ?", bookDir, id)
+	if err != nil {
+		return err
+	}
+
+	for idx, author := range calibreAuthorNames(authors) {
+		_, err = tx.Exec("INSERT OR IGNORE INTO authors (name, sort) VALUES (?, ?)", author, authorSort(authors[idx]))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO books_authors_link (book, author) SELECT ?, id FROM authors WHERE name = ?", id, author)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(bk.Publisher) > 0 {
+		_, err = tx.Exec("INSERT OR IGNORE INTO publishers (name) VALUES (?)", bk.Publisher)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO books_publishers_link (book, publisher) SELECT ?, id FROM publishers WHERE name = ?", id, bk.Publisher)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(bk.Series) > 0 {
+		_, err = tx.Exec("INSERT OR IGNORE INTO series (name, sort) VALUES (?, ?)", bk.Series, titleSort(bk.Series))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO books_series_link (book, series) SELECT ?, id FROM series WHERE name = ?", id, bk.Series)
+		if err != nil {
+			return err
+		}
+		if bk.SeriesIndex > 0 {
+			_, err = tx.Exec("UPDATE books SET series_index = ? WHERE id = ?", bk.SeriesIndex, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, subject := range bk.Subjects {
+		_, err = tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", subject)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT OR IGNORE INTO books_tags_link (book, tag) SELECT ?, id FROM tags WHERE name = ?", id, subject)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(bk.Description) > 0 {
+		_, err = tx.Exec("INSERT OR REPLACE INTO comments (book, text) VALUES (?, ?)", id, bk.Description)
+		if err != nil {
+			return err
+		}
+	}
+
+	identifiers := map[string]string{
+		"isbn":   bestIsbn(bk),
+		"doi":    string(bk.Doi),
+		"oclc":   bk.Oclc,
+		"amazon": string(bk.Asin),
+		"arxiv":  string(bk.Arxiv),
+	}
+	for kind, value := range identifiers {
+		if len(value) == 0 {
+			continue
+		}
+		_, err = tx.Exec("INSERT OR REPLACE INTO identifiers (book, type, val) VALUES (?, ?, ?)", id, kind, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO data (book, format, uncompressed_size, name) VALUES (?, ?, ?, ?)", id, format, info.Size(), name)
+	if err != nil {
+		return err
+	}
+
+	if writer.hasDirtiedTable {
+		_, err = tx.Exec("INSERT OR IGNORE INTO metadata_dirtied (book) VALUES (?)", id)
+		if err != nil {
+			return err
+		}
+	}
+
+	destinationDir := filepath.Join(writer.LibraryPath, filepath.FromSlash(bookDir))
+	err = os.MkdirAll(destinationDir, 0755)
+	if err != nil {
+		return err
+	}
+	err = copyFile(bk.Filepath, filepath.Join(destinationDir, fmt.Sprintf("%s.%s", name, strings.ToLower(format))))
+	if err != nil {
+		os.RemoveAll(destinationDir)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		os.RemoveAll(destinationDir)
+		return err
+	}
+	return nil
+}
+
+// path joins with forward slashes, which Calibre uses in its database on every platform
+func path(components ...string) string {
+	return strings.Join(components, "/")
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, source)
+	closeErr := destination.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (writer *Writer) WriteObject(bk *book.Book) {
+	// copy so callers are free to reuse their book
+	cp := *bk
+	writer.Input <- &cp
+}
+
+func (writer *Writer) Close() {
+	if writer.Input == nil {
+		return
+	}
+
+	close(writer.Input)
+
+	writer.waiter.Wait()
+
+	err := writer.db.Close()
+	if err != nil {
+		slog.Error("failed to close Calibre database", "error", err)
+	}
+}
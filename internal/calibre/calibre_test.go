@@ -0,0 +1,106 @@
+package calibre_test
+
+import (
+	"database/sql"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/calibre"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the parts of Calibre's schema the writer touches, including the trigger that calls back into title_sort and uuid4
+const schema = `
+CREATE TABLE books (id INTEGER PRIMARY KEY AUTOINCREMENT, title TEXT NOT NULL DEFAULT 'Unknown' COLLATE NOCASE, sort TEXT COLLATE NOCASE, timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP, pubdate TIMESTAMP DEFAULT CURRENT_TIMESTAMP, series_index REAL NOT NULL DEFAULT 1.0, author_sort TEXT COLLATE NOCASE, isbn TEXT DEFAULT "" COLLATE NOCASE, lccn TEXT DEFAULT "" COLLATE NOCASE, path TEXT NOT NULL DEFAULT "", flags INTEGER NOT NULL DEFAULT 1, uuid TEXT, has_cover BOOL DEFAULT 0, last_modified TIMESTAMP NOT NULL DEFAULT "2000-01-01 00:00:00+00:00");
+CREATE TRIGGER books_insert_trg AFTER INSERT ON books BEGIN UPDATE books SET sort=title_sort(NEW.title),uuid=uuid4() WHERE id=NEW.id; END;
+CREATE TRIGGER books_update_trg AFTER UPDATE ON books BEGIN UPDATE books SET sort=title_sort(NEW.title) WHERE id=NEW.id AND OLD.title <> NEW.title; END;
+CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT NOT NULL COLLATE NOCASE, sort TEXT COLLATE NOCASE, link TEXT NOT NULL DEFAULT "", UNIQUE(name));
+CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, author INTEGER NOT NULL, UNIQUE(book, author));
+CREATE TABLE publishers (id INTEGER PRIMARY KEY, name TEXT NOT NULL COLLATE NOCASE, sort TEXT COLLATE NOCASE, UNIQUE(name));
+CREATE TABLE books_publishers_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, publisher INTEGER NOT NULL, UNIQUE(book));
+CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT NOT NULL COLLATE NOCASE, sort TEXT COLLATE NOCASE, link TEXT NOT NULL DEFAULT "", UNIQUE(name));
+CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, series INTEGER NOT NULL, UNIQUE(book));
+CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT NOT NULL COLLATE NOCASE, link TEXT NOT NULL DEFAULT "", UNIQUE(name));
+CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, tag INTEGER NOT NULL, UNIQUE(book, tag));
+CREATE TABLE comments (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, text TEXT NOT NULL COLLATE NOCASE, UNIQUE(book));
+CREATE TABLE identifiers (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, type TEXT NOT NULL DEFAULT "isbn" COLLATE NOCASE, val TEXT NOT NULL COLLATE NOCASE, UNIQUE(book, type));
+CREATE TABLE data (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, format TEXT NOT NULL COLLATE NOCASE, uncompressed_size INTEGER NOT NULL, name TEXT NOT NULL, UNIQUE(book, format));
+CREATE TABLE metadata_dirtied (id INTEGER PRIMARY KEY, book INTEGER NOT NULL, UNIQUE(book));
+`
+
+func newLibrary(t *testing.T) string {
+	library := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(library, "metadata.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+	_, err = db.Exec(schema)
+	assert.NoError(t, err)
+	return library
+}
+
+func TestWriteAndRead(t *testing.T) {
+	library := newLibrary(t)
+	assert.True(t, calibre.IsLibrary(library))
+	assert.False(t, calibre.IsLibrary(t.TempDir()))
+
+	source := filepath.Join(t.TempDir(), "ghost.pdf")
+	assert.NoError(t, os.WriteFile(source, []byte("%PDF-1.4 ghost"), 0644))
+
+	bk := book.Book{
+		Title:       "The Ghost Book",
+		Authors:     []string{"Sparc Flow", "Doe, Jane"},
+		Isbn13:      "9781718501263",
+		PublishDate: "2021-05-04",
+		Publisher:   "No Starch Press",
+		Series:      "Ghost Books",
+		SeriesIndex: 2,
+		Subjects:    []string{"Computer security", "Hacking"},
+		Description: "A guide to attacking cloud networks.",
+		Filepath:    source,
+	}
+
+	// writing the same book twice, even from separate runs, only adds it once
+	for range 2 {
+		writer, err := calibre.NewWriter(library)
+		assert.NoError(t, err)
+		writer.WriteObject(&bk)
+		writer.WriteObject(&book.Book{Title: "Errored", Filepath: source, ErrorMessage: "no results"})
+		writer.Close()
+	}
+
+	books, err := calibre.ReadLibrary(library)
+	assert.NoError(t, err)
+	assert.Len(t, books, 1)
+
+	read := books[0]
+	assert.Equal(t, bk.Title, read.Title)
+	assert.Equal(t, bk.Authors, read.Authors)
+	assert.Equal(t, bk.Isbn13, read.Isbn13)
+	assert.Equal(t, bk.PublishDate, read.PublishDate)
+	assert.Equal(t, bk.Publisher, read.Publisher)
+	assert.Equal(t, bk.Series, read.Series)
+	assert.Equal(t, bk.SeriesIndex, read.SeriesIndex)
+	assert.ElementsMatch(t, bk.Subjects, read.Subjects)
+	assert.Equal(t, bk.Description, read.Description)
+	assert.Equal(t, filepath.Join(library, "Sparc Flow & Doe, Jane", "The Ghost Book (1)", "The Ghost Book - Sparc Flow & Doe, Jane.pdf"), read.Filepath)
+	assert.NotEmpty(t, read.Hash)
+
+	data, err := os.ReadFile(read.Filepath)
+	assert.NoError(t, err)
+	assert.Equal(t, "%PDF-1.4 ghost", string(data))
+
+	db, err := sql.Open("sqlite", filepath.Join(library, "metadata.db"))
+	assert.NoError(t, err)
+	defer db.Close()
+	var sort, authorSort, uuid string
+	assert.NoError(t, db.QueryRow("SELECT sort, author_sort, uuid FROM books").Scan(&sort, &authorSort, &uuid))
+	assert.Equal(t, "Ghost Book, The", sort)
+	assert.Equal(t, "Flow, Sparc", authorSort)
+	assert.Len(t, uuid, 36)
+}
+
+func TestNewWriterRequiresLibrary(t *testing.T) {
+	_, err := calibre.NewWriter(t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is how often the books finished since the last flush are written to the checkpoint
+const checkpointInterval = 10 * time.Second
+
+// checkpoint appends every finished book to a file as a line of JSON, so that a scan which crashed before its output
+// was closed can be resumed
+type checkpoint struct {
+	Filepath string
+	lock     sync.Mutex
+	fh       *os.File
+	buffer   *bufio.Writer
+	quit     chan struct{}
+	wait     sync.WaitGroup
+}
+
+func openCheckpoint(filePath string) (*checkpoint, error) {
+	fh, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	// the books of a resumed run go after a last line cut off by the crash, rather than onto the end of it
+	if info, err := fh.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := fh.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			fh.Write([]byte("\n"))
+		}
+	}
+	c := &checkpoint{
+		Filepath: filePath,
+		fh:       fh,
+		buffer:   bufio.NewWriter(fh),
+		quit:     make(chan struct{}),
+	}
+
+	c.wait.Add(1)
+	go func() {
+		defer c.wait.Done()
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.flush(); err != nil {
+					slog.Error("failed to write checkpoint", "path", c.Filepath, "error", err)
+				}
+			case <-c.quit:
+				return
+			}
+		}
+	}()
+
+	return c, nil
+}
+
+func (c *checkpoint) write(bk *book.Book) {
+	data, err := json.Marshal(bk)
+	if err != nil {
+		slog.Warn("could not add book to checkpoint", "path", bk.Filepath, "error", err)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.buffer.Write(data)
+	c.buffer.WriteByte('\n')
+}
+
+func (c *checkpoint) flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.buffer.Flush()
+	if err != nil {
+		return err
+	}
+	return c.fh.Sync()
+}
+
+// close flushes the checkpoint one last time
+func (c *checkpoint) close() {
+	close(c.quit)
+	c.wait.Wait()
+
+	err := c.flush()
+	if err != nil {
+		slog.Error("failed to write checkpoint", "path", c.Filepath, "error", err)
+	}
+	c.fh.Close()
+}
+
+// readCheckpoint reads every book in a checkpoint, keyed by filepath. A crash can cut off the last line, which is
+// skipped since the book is simply processed again.
+func readCheckpoint(filePath string) (map[string]book.Book, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error: could not read checkpoint %s: %s", filePath, err.Error())
+	}
+
+	books := make(map[string]book.Book)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var bk book.Book
+		if err := json.Unmarshal(line, &bk); err != nil {
+			continue
+		}
+		books[bk.Filepath] = bk
+	}
+	return books, nil
+}
+
+// SetCheckpoint records every book finished once the scan starts in the checkpoint at filePath, until RemoveCheckpoint
+// is called once the output is in place. With resume, the books in an existing checkpoint are loaded first, like a
+// cache.
+func (bm *BookManager) SetCheckpoint(filePath string, resume bool) error {
+	if resume {
+		books, err := readCheckpoint(filePath)
+		if err != nil {
+			return err
+		}
+		for p, bk := range books {
+			bm.books[p] = bk
+			delete(bm.stale, p)
+		}
+		bm.indexCachedHashes()
+		slog.Info("resuming from checkpoint", "path", filePath, "books", len(books))
+	} else if exists, _ := util.PathExists(filePath); exists {
+		return fmt.Errorf("error: checkpoint %s already exists, a previous scan didn't finish. Run again with --resume to continue it, or delete the checkpoint", filePath)
+	}
+
+	bm.checkpointPath = filePath
+	return nil
+}
+
+// startCheckpoint opens the checkpoint if one was set, a scan without one can still finish so it only logs failures
+func (bm *BookManager) startCheckpoint() {
+	if len(bm.checkpointPath) == 0 || bm.checkpoint != nil {
+		return
+	}
+	c, err := openCheckpoint(bm.checkpointPath)
+	if err != nil {
+		slog.Error("could not open checkpoint, the scan can't be resumed if it crashes", "path", bm.checkpointPath, "error", err)
+		return
+	}
+	bm.checkpoint = c
+}
+
+// RemoveCheckpoint deletes the checkpoint, which is no longer needed once the output it was kept for is complete
+func (bm *BookManager) RemoveCheckpoint() {
+	if len(bm.checkpointPath) == 0 {
+		return
+	}
+	if err := os.Remove(bm.checkpointPath); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove checkpoint", "path", bm.checkpointPath, "error", err)
+	}
+	bm.checkpointPath = ""
+}
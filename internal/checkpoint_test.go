@@ -0,0 +1,107 @@
+package internal_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkpointed reads the books in the checkpoint at path, and the lines that aren't books
+func checkpointed(t *testing.T, path string) ([]book.Book, []string) {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	books := make([]book.Book, 0)
+	broken := make([]string, 0)
+	for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+		var bk book.Book
+		if err := json.Unmarshal(line, &bk); err != nil {
+			broken = append(broken, string(line))
+			continue
+		}
+		books = append(books, bk)
+	}
+	return books, broken
+}
+
+func TestCheckpoint(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the extractor and provider are scripts run with sh")
+	}
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "library")
+	assert.NoError(t, os.Mkdir(lib, 0o755))
+	names := []string{"a.md", "b.md", "c.md"}
+	for n, name := range names {
+		contents := fmt.Sprintf("book %d\nISBN 978-1-7185-0126-3\n", n)
+		assert.NoError(t, os.WriteFile(filepath.Join(lib, name), []byte(contents), 0o644))
+	}
+	// the extractor writes down every book it reads, which is every book processed
+	runs := filepath.Join(dir, "runs")
+	extractor := filepath.Join(dir, "extractor")
+	assert.NoError(t, os.WriteFile(extractor, []byte("#!/bin/sh\necho \"$1\" >>"+runs+"\ncat \"$1\"\n"), 0o755))
+	plugin := filepath.Join(dir, "plugin")
+	answer := `{"title": "Book", "authors": ["A Writer"], "isbn13": "9781718501263"}`
+	assert.NoError(t, os.WriteFile(plugin, []byte("#!/bin/sh\ncat >/dev/null\necho '"+answer+"'\n"), 0o755))
+
+	checkpoint := filepath.Join(dir, "books.json.checkpoint")
+	scanFrom := func(resume bool) []string {
+		assert.NoError(t, os.WriteFile(runs, nil, 0o644))
+		conf := &config.Config{Commands: []config.CommandConfig{{Name: "counted", Command: extractor + " {file}", Extensions: []string{".md"}}}}
+		conf.Plugins = []config.PluginConfig{{Name: "Plugin", Command: plugin, Lookups: []string{"isbn"}, Retry: config.RetryConfig{MaxAttempts: 1}}}
+		bm, err := internal.NewBookManager(conf, 2)
+		assert.NoError(t, err)
+		defer bm.Shutdown()
+		assert.NoError(t, bm.SetCheckpoint(checkpoint, resume))
+		writer := &bookWriter{}
+		assert.NoError(t, bm.Scan(context.Background(), lib, false, false, writer))
+		want := make([]string, 0)
+		for _, name := range names {
+			want = append(want, filepath.Join(lib, name))
+		}
+		assert.Equal(t, want, writer.paths())
+
+		data, err := os.ReadFile(runs)
+		assert.NoError(t, err)
+		return strings.Fields(string(data))
+	}
+
+	// every book finished is checkpointed
+	assert.Len(t, scanFrom(false), 3)
+	books, broken := checkpointed(t, checkpoint)
+	assert.Len(t, books, 3)
+	assert.Empty(t, broken)
+	for _, bk := range books {
+		assert.Equal(t, "Book", bk.Title)
+	}
+
+	// a checkpoint left behind by a run that didn't finish isn't started over without --resume
+	bm, err := internal.NewBookManager(&config.Config{Offline: true, Commands: []config.CommandConfig{{Name: "cat", Command: "cat {file}", Extensions: []string{".md"}}}}, 2)
+	assert.NoError(t, err)
+	err = bm.SetCheckpoint(checkpoint, false)
+	bm.Shutdown()
+	assert.EqualError(t, err, "error: checkpoint "+checkpoint+" already exists, a previous scan didn't finish. Run again with --resume to continue it, or delete the checkpoint")
+
+	// a crash cuts off the last line, whose book is processed again while the others are only written back out
+	data, err := os.ReadFile(checkpoint)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	cut := lines[len(lines)-1]
+	assert.NoError(t, os.Truncate(checkpoint, int64(len(data)-1-len(cut)+len(cut)/2)))
+	assert.Equal(t, []string{books[2].Filepath}, scanFrom(true))
+
+	// and checkpointed on a line of its own, so resuming again processes nothing
+	books, broken = checkpointed(t, checkpoint)
+	assert.Len(t, books, 3)
+	assert.Equal(t, []string{cut[:len(cut)/2]}, broken)
+	assert.Empty(t, scanFrom(true))
+}
@@ -1,87 +1,970 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type TikaConfig struct {
-	Enable bool   `toml:"enable"`
-	Host   string `toml:"host"`
-	Port   int    `toml:"port"`
+	Enable             bool              `toml:"enable"`
+	Host               string            `toml:"host"`
+	Port               int               `toml:"port"`
+	Hosts              []string          `toml:"hosts"`
+	Scheme             string            `toml:"scheme"`
+	AuthToken          string            `toml:"auth_token"`
+	AuthTokenFile      string            `toml:"auth_token_file"`
+	AuthTokenCmd       string            `toml:"auth_token_cmd"`
+	Headers            map[string]string `toml:"headers"`
+	Managed            bool              `toml:"managed"`
+	Launcher           string            `toml:"launcher"`
+	Image              string            `toml:"image"`
+	Jar                string            `toml:"jar"`
+	Version            string            `toml:"version"`
+	MaxFileSize        uint              `toml:"max_file_size"`
+	FrontMatterBytes   uint              `toml:"front_matter_bytes"`
+	FrontMatterFormats []string          `toml:"front_matter_formats"`
+}
+
+// Endpoints lists every Tika server as host:port, host first and then hosts, using port for those without one. A managed
+// server takes the place of host.
+func (c *TikaConfig) Endpoints() []string {
+	hosts := c.Hosts
+	if len(c.Host) != 0 && !c.Managed {
+		hosts = append([]string{c.Host}, hosts...)
+	}
+	endpoints := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if _, _, err := net.SplitHostPort(host); err == nil {
+			endpoints = append(endpoints, host)
+			continue
+		}
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(c.Port)))
+	}
+	return endpoints
+}
+
+type EpubConfig struct {
+	Enable bool `toml:"enable"`
+}
+
+type PdfConfig struct {
+	Enable   bool `toml:"enable"`
+	Metadata bool `toml:"metadata"`
+}
+
+type MobiConfig struct {
+	Enable bool `toml:"enable"`
+}
+
+type ComicConfig struct {
+	Enable bool `toml:"enable"`
+}
+
+type DjvuConfig struct {
+	Enable  bool   `toml:"enable"`
+	Command string `toml:"command"`
+}
+
+// CommandConfig is an extractor outside of Booker, a command that writes the text of the books with its extensions to
+// its standard output. The command is split on spaces, without a shell, and {file} in it is replaced by the book's path
+type CommandConfig struct {
+	Name       string   `toml:"name"`
+	Command    string   `toml:"command"`
+	Extensions []string `toml:"extensions"`
+}
+
+// RetryConfig is how a provider tries again after a request fails with a server error or gets no answer at all, waiting
+// longer after each attempt, and how long it is left alone after being rate limited
+type RetryConfig struct {
+	MaxAttempts         uint    `toml:"max_attempts"`
+	BackoffMilliseconds uint    `toml:"backoff_milliseconds"`
+	Jitter              float64 `toml:"jitter"`
+	CooldownSeconds     uint    `toml:"cooldown_seconds"`
+}
+
+// validate fills in the defaults of the retry settings of a provider
+func (r *RetryConfig) validate(provider string) error {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = uint(Defaults["retry.max_attempts"].(int))
+	}
+	if r.BackoffMilliseconds == 0 {
+		r.BackoffMilliseconds = uint(Defaults["retry.backoff_milliseconds"].(int))
+	}
+	if r.CooldownSeconds == 0 {
+		r.CooldownSeconds = uint(Defaults["retry.cooldown_seconds"].(int))
+	}
+	if r.Jitter < 0 || r.Jitter > 1 {
+		return fmt.Errorf("%s.retry.jitter must be between 0 and 1 but was %g", provider, r.Jitter)
+	}
+	return nil
 }
 
 type GoogleConfig struct {
-	Enable                 bool   `toml:"enable"`
-	Url                    string `toml:"url"`
-	MillisecondsPerRequest uint   `toml:"requests_per_second"`
-	ApiKey                 string `toml:"api_key"`
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	ApiKey                 string      `toml:"api_key"`
+	ApiKeyFile             string      `toml:"api_key_file"`
+	ApiKeyCmd              string      `toml:"api_key_cmd"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type IsbndbConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	ApiKey                 string      `toml:"api_key"`
+	ApiKeyFile             string      `toml:"api_key_file"`
+	ApiKeyCmd              string      `toml:"api_key_cmd"`
+	Plan                   string      `toml:"plan"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type WorldcatConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	TokenUrl               string      `toml:"token_url"`
+	ClientId               string      `toml:"client_id"`
+	ClientSecret           string      `toml:"client_secret"`
+	ClientSecretFile       string      `toml:"client_secret_file"`
+	ClientSecretCmd        string      `toml:"client_secret_cmd"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type CrossrefConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	Mailto                 string      `toml:"mailto"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type SpringerConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	ApiKey                 string      `toml:"api_key"`
+	ApiKeyFile             string      `toml:"api_key_file"`
+	ApiKeyCmd              string      `toml:"api_key_cmd"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type ArxivConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type ComicvineConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Url                    string      `toml:"url"`
+	ApiKey                 string      `toml:"api_key"`
+	ApiKeyFile             string      `toml:"api_key_file"`
+	ApiKeyCmd              string      `toml:"api_key_cmd"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+type AmazonConfig struct {
+	Name                   string      `toml:"name"`
+	Enable                 bool        `toml:"enable"`
+	Host                   string      `toml:"host"`
+	Region                 string      `toml:"region"`
+	Marketplace            string      `toml:"marketplace"`
+	AccessKey              string      `toml:"access_key"`
+	AccessKeyFile          string      `toml:"access_key_file"`
+	AccessKeyCmd           string      `toml:"access_key_cmd"`
+	SecretKey              string      `toml:"secret_key"`
+	SecretKeyFile          string      `toml:"secret_key_file"`
+	SecretKeyCmd           string      `toml:"secret_key_cmd"`
+	PartnerTag             string      `toml:"partner_tag"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+// validate fills in the defaults of an enabled Google instance, key being its table, like google or google[1]
+func (g *GoogleConfig) validate(key string) error {
+	if len(g.Url) == 0 {
+		g.Url = Defaults["google.url"].(string)
+	}
+	if g.MillisecondsPerRequest == 0 {
+		g.MillisecondsPerRequest = uint(Defaults["google.milliseconds_per_request"].(int))
+	}
+	return g.Retry.validate(key)
+}
+
+func (i *IsbndbConfig) validate(key string) error {
+	if len(i.ApiKey) == 0 {
+		return fmt.Errorf("%s.api_key must be configured if %s is enabled", key, key)
+	}
+	if len(i.Plan) == 0 {
+		i.Plan = Defaults["isbndb.plan"].(string)
+	}
+	plan, ok := isbndbPlans[strings.ToLower(i.Plan)]
+	if !ok {
+		return fmt.Errorf("%s.plan must be one of basic, premium, or pro but was %s", key, i.Plan)
+	}
+	if len(i.Url) == 0 {
+		i.Url = plan.url
+	}
+	if i.MillisecondsPerRequest == 0 {
+		i.MillisecondsPerRequest = plan.millisecondsPerRequest
+	}
+	return i.Retry.validate(key)
+}
+
+func (w *WorldcatConfig) validate(key string) error {
+	errorMsg := "%s.%s must be configured if %s is enabled"
+
+	if len(w.ClientId) == 0 {
+		return fmt.Errorf(errorMsg, key, "client_id", key)
+	}
+	if len(w.ClientSecret) == 0 {
+		return fmt.Errorf(errorMsg, key, "client_secret", key)
+	}
+	if len(w.Url) == 0 {
+		w.Url = Defaults["worldcat.url"].(string)
+	}
+	if len(w.TokenUrl) == 0 {
+		w.TokenUrl = Defaults["worldcat.token_url"].(string)
+	}
+	if w.MillisecondsPerRequest == 0 {
+		w.MillisecondsPerRequest = uint(Defaults["worldcat.milliseconds_per_request"].(int))
+	}
+	return w.Retry.validate(key)
+}
+
+func (c *CrossrefConfig) validate(key string) error {
+	if len(c.Url) == 0 {
+		c.Url = Defaults["crossref.url"].(string)
+	}
+	if c.MillisecondsPerRequest == 0 {
+		c.MillisecondsPerRequest = uint(Defaults["crossref.milliseconds_per_request"].(int))
+	}
+	return c.Retry.validate(key)
+}
+
+func (s *SpringerConfig) validate(key string) error {
+	if len(s.ApiKey) == 0 {
+		return fmt.Errorf("%s.api_key must be configured if %s is enabled", key, key)
+	}
+	if len(s.Url) == 0 {
+		s.Url = Defaults["springer.url"].(string)
+	}
+	if s.MillisecondsPerRequest == 0 {
+		s.MillisecondsPerRequest = uint(Defaults["springer.milliseconds_per_request"].(int))
+	}
+	return s.Retry.validate(key)
+}
+
+func (a *ArxivConfig) validate(key string) error {
+	if len(a.Url) == 0 {
+		a.Url = Defaults["arxiv.url"].(string)
+	}
+	if a.MillisecondsPerRequest == 0 {
+		a.MillisecondsPerRequest = uint(Defaults["arxiv.milliseconds_per_request"].(int))
+	}
+	return a.Retry.validate(key)
+}
+
+func (c *ComicvineConfig) validate(key string) error {
+	if len(c.ApiKey) == 0 {
+		return fmt.Errorf("%s.api_key must be configured if %s is enabled", key, key)
+	}
+	if len(c.Url) == 0 {
+		c.Url = Defaults["comicvine.url"].(string)
+	}
+	if c.MillisecondsPerRequest == 0 {
+		c.MillisecondsPerRequest = uint(Defaults["comicvine.milliseconds_per_request"].(int))
+	}
+	return c.Retry.validate(key)
+}
+
+func (a *AmazonConfig) validate(key string) error {
+	errorMsg := "%s.%s must be configured if %s is enabled"
+
+	if len(a.AccessKey) == 0 {
+		return fmt.Errorf(errorMsg, key, "access_key", key)
+	}
+	if len(a.SecretKey) == 0 {
+		return fmt.Errorf(errorMsg, key, "secret_key", key)
+	}
+	if len(a.PartnerTag) == 0 {
+		return fmt.Errorf(errorMsg, key, "partner_tag", key)
+	}
+	if len(a.Host) == 0 {
+		a.Host = Defaults["amazon.host"].(string)
+	}
+	if len(a.Region) == 0 {
+		a.Region = Defaults["amazon.region"].(string)
+	}
+	if len(a.Marketplace) == 0 {
+		a.Marketplace = Defaults["amazon.marketplace"].(string)
+	}
+	if a.MillisecondsPerRequest == 0 {
+		a.MillisecondsPerRequest = uint(Defaults["amazon.milliseconds_per_request"].(int))
+	}
+	return a.Retry.validate(key)
+}
+
+// PluginConfig is a provider outside of Booker, a command that is run for every lookup with the request as JSON on its
+// standard input and that answers with the book it found as JSON on its standard output
+type PluginConfig struct {
+	Name    string   `toml:"name"`
+	Command string   `toml:"command"`
+	Args    []string `toml:"args"`
+	// Lookups are the kinds of searches the plugin is sent, of isbn, doi, asin, arxiv and query
+	Lookups                []string    `toml:"lookups"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+// SruConfig is a library catalog searched over SRU, like those of the national libraries, with the indexes of its queries
+// named the way the catalog names them
+type SruConfig struct {
+	Name string `toml:"name"`
+	// Url is the catalog's endpoint, like https://services.dnb.de/sru/dnb
+	Url          string `toml:"url"`
+	Version      string `toml:"version"`
+	RecordSchema string `toml:"record_schema"`
+	// Format is the kind of MARC the records are in, marc21 or unimarc
+	Format                 string      `toml:"format"`
+	IsbnIndex              string      `toml:"isbn_index"`
+	TitleIndex             string      `toml:"title_index"`
+	AuthorIndex            string      `toml:"author_index"`
+	MillisecondsPerRequest uint        `toml:"milliseconds_per_request"`
+	Retry                  RetryConfig `toml:"retry"`
+}
+
+// ProviderCacheConfig is where the results of the providers are kept between runs and for how long, by how they were
+// found
+type ProviderCacheConfig struct {
+	Enable            bool   `toml:"enable"`
+	Path              string `toml:"path"`
+	IdentifierTtlDays uint   `toml:"identifier_ttl_days"`
+	QueryTtlDays      uint   `toml:"query_ttl_days"`
+}
+
+// CollationConfig decides which provider's result is kept for a book and which of its fields come from elsewhere
+type CollationConfig struct {
+	Weights    map[string]float64  `toml:"weights"`
+	Fields     map[string][]string `toml:"fields"`
+	TieBreaker string              `toml:"tie_breaker"`
+	NameOrder  string              `toml:"name_order"`
+}
+
+type ScanConfig struct {
+	Include        []string `toml:"include"`
+	Exclude        []string `toml:"exclude"`
+	Archives       bool     `toml:"archives"`
+	FollowSymlinks bool     `toml:"follow_symlinks"`
+	OneFileSystem  bool     `toml:"one_file_system"`
+	// MaxDepth is how many directories below the scan path are walked, and MaxFiles how many files of one directory
+	// are, both unlimited if 0
+	MaxDepth uint `toml:"max_depth"`
+	MaxFiles uint `toml:"max_files"`
+}
+
+// WebdavConfig is how WebDAV servers are reached when the scan path is a URL, like a Nextcloud or Synology share
+type WebdavConfig struct {
+	Enable       bool   `toml:"enable"`
+	Username     string `toml:"username"`
+	Password     string `toml:"password"`
+	PasswordFile string `toml:"password_file"`
+	PasswordCmd  string `toml:"password_cmd"`
+}
+
+// HttpConfig is how Booker reaches the providers, Tika servers and cover images over HTTP
+type HttpConfig struct {
+	Proxy                     string   `toml:"proxy"`
+	NoProxy                   []string `toml:"no_proxy"`
+	CaBundle                  string   `toml:"ca_bundle"`
+	TimeoutSeconds            uint     `toml:"timeout_seconds"`
+	MaxIdleConnections        int      `toml:"max_idle_connections"`
+	MaxIdleConnectionsPerHost int      `toml:"max_idle_connections_per_host"`
+	MaxConnectionsPerHost     int      `toml:"max_connections_per_host"`
+}
+
+// IntakeConfig is the Redis list or NATS subject scan --intake takes the paths of new books from
+type IntakeConfig struct {
+	// Url is the server, like redis://:password@localhost:6379/0 or nats://localhost:4222
+	Url string `toml:"url"`
+	// Key is the Redis list paths are popped from, or the NATS subject they are published on
+	Key string `toml:"key"`
+	// QueueGroup has the Booker instances subscribed to a NATS subject in the same group share its paths
+	QueueGroup string `toml:"queue_group"`
+}
+
+// WebhooksConfig is where JSON about each book and scan is POSTed as they finish, by the URLs of each event
+type WebhooksConfig struct {
+	OnBookIdentified []string `toml:"on_book_identified"`
+	OnBookFailed     []string `toml:"on_book_failed"`
+	OnScanComplete   []string `toml:"on_scan_complete"`
+	// Headers are sent with every request, like an Authorization header the receiver wants
+	Headers        map[string]string `toml:"headers"`
+	TimeoutSeconds uint              `toml:"timeout_seconds"`
 }
 
 type advanced struct {
-	MaxCharactersToSearchForIsbn uint `toml:"max_characters_to_search_for_isbn"`
+	MaxCharactersToSearchForIsbn  uint `toml:"max_characters_to_search_for_isbn"`
+	TailCharactersToSearchForIsbn uint `toml:"tail_characters_to_search_for_isbn"`
+	TimeoutSeconds                uint `toml:"timeout_seconds"`
+	ClassificationFromText        bool `toml:"classification_from_text"`
+	MaxIsbnLookupsPerFile         uint `toml:"max_isbn_lookups_per_file"`
+	// EarlyExitConfidence is the score out of 100 at which a book's search stops waiting on the providers that haven't
+	// answered yet, 0 waiting on all of them
+	EarlyExitConfidence float64 `toml:"early_exit_confidence"`
+	// OutageTimeoutSeconds is how long a scan is paused for when every extractor or every provider is down, waiting on
+	// one of them to come back before giving up on the books left
+	OutageTimeoutSeconds uint `toml:"outage_timeout_seconds"`
+	// WorkerTimeoutSeconds is how long a worker has to send back a book it took from a coordinator, by default what a
+	// worker with the same timeout_seconds could take over all of a book's stages
+	WorkerTimeoutSeconds uint `toml:"worker_timeout_seconds"`
 }
 
 type Config struct {
-	Tika     TikaConfig   `toml:"tika"`
-	Google   GoogleConfig `toml:"google"`
-	Advanced advanced     `toml:"advanced"`
+	Tika     TikaConfig      `toml:"tika"`
+	Epub     EpubConfig      `toml:"epub"`
+	Pdf      PdfConfig       `toml:"pdf"`
+	Mobi     MobiConfig      `toml:"mobi"`
+	Comic    ComicConfig     `toml:"comic"`
+	Djvu     DjvuConfig      `toml:"djvu"`
+	Commands []CommandConfig `toml:"commands"`
+	// Google and the providers up to Comicvine can each be configured more than once, as [[google]] tables with names
+	// of their own. A single [google] table, like that of a profile, changes every one there is.
+	Google    []GoogleConfig    `toml:"google"`
+	Isbndb    []IsbndbConfig    `toml:"isbndb"`
+	Worldcat  []WorldcatConfig  `toml:"worldcat"`
+	Crossref  []CrossrefConfig  `toml:"crossref"`
+	Springer  []SpringerConfig  `toml:"springer"`
+	Arxiv     []ArxivConfig     `toml:"arxiv"`
+	Amazon    []AmazonConfig    `toml:"amazon"`
+	Comicvine []ComicvineConfig `toml:"comicvine"`
+	Plugins   []PluginConfig    `toml:"plugins"`
+	Sru       []SruConfig       `toml:"sru"`
+	Collation CollationConfig   `toml:"collation"`
+	Scan      ScanConfig        `toml:"scan"`
+	Webdav    WebdavConfig      `toml:"webdav"`
+	Http      HttpConfig        `toml:"http"`
+	Advanced  advanced          `toml:"advanced"`
+	// ProviderCache is kept apart from the output, which only has the result picked for each book
+	ProviderCache ProviderCacheConfig `toml:"provider_cache"`
+	// Intake is only used by scan --intake
+	Intake   IntakeConfig   `toml:"intake"`
+	Webhooks WebhooksConfig `toml:"webhooks"`
+	// Profiles are tables of keys that replace those above when chosen with --profile, as in [profile.fast.tika]
+	Profiles map[string]toml.Primitive `toml:"profile,omitempty"`
+	// Offline is set by --offline rather than in the file, and keeps every provider from being used
+	Offline bool `toml:"-"`
+}
+
+// isbndbPlans maps each ISBNdb subscription plan to its endpoint and rate limit
+var isbndbPlans = map[string]struct {
+	url                    string
+	millisecondsPerRequest uint
+}{
+	"basic":   {url: "api2.isbndb.com", millisecondsPerRequest: 1000},
+	"premium": {url: "api.premium.isbndb.com", millisecondsPerRequest: 334},
+	"pro":     {url: "api.pro.isbndb.com", millisecondsPerRequest: 200},
+}
+
+// sourceNames are the providers and embedded metadata results come from, which plugins and SRU catalogs can't share a
+// name with
+var sourceNames = map[string]struct{}{
+	"google": {}, "isbndb": {}, "worldcat": {}, "crossref": {}, "springer": {}, "arxiv": {}, "amazon": {},
+	"comicvine": {}, "epub": {}, "pdf": {}, "mobi": {}, "comic": {},
 }
 
 var Defaults = map[string]any{
-	"tika.port": 9998,
+	"tika.port":                 9998,
+	"tika.scheme":               "http",
+	"tika.launcher":             "docker",
+	"tika.image":                "apache/tika:latest",
+	"tika.version":              "3.0.0",
+	"tika.front_matter_bytes":   1048576,
+	"tika.front_matter_formats": []string{".txt", ".html", ".htm", ".rst", ".rtf"},
+
+	"djvu.command": "djvutxt",
 
 	"google.url":                      "www.googleapis.com/books/v1/volumes",
 	"google.milliseconds_per_request": 1000,
 
+	"isbndb.plan": "basic",
+
+	"worldcat.url":                      "americas.discovery.api.oclc.org/worldcat/search/v2",
+	"worldcat.token_url":                "oauth.oclc.org/token",
+	"worldcat.milliseconds_per_request": 1000,
+
+	"crossref.url":                      "api.crossref.org",
+	"crossref.milliseconds_per_request": 200,
+
+	"springer.url":                      "api.springernature.com/meta/v2/json",
+	"springer.milliseconds_per_request": 1000,
+
+	// arXiv asks for no more than one request every three seconds
+	"arxiv.url":                      "export.arxiv.org/api/query",
+	"arxiv.milliseconds_per_request": 3000,
+
+	"comicvine.url":                      "comicvine.gamespot.com/api",
+	"comicvine.milliseconds_per_request": 18000,
+
+	"amazon.host":                     "webservices.amazon.com",
+	"amazon.region":                   "us-east-1",
+	"amazon.marketplace":              "www.amazon.com",
+	"amazon.milliseconds_per_request": 1000,
+
+	"plugins.lookups":                  []string{"isbn"},
+	"plugins.milliseconds_per_request": 200,
+
+	"sru.version":                  "1.1",
+	"sru.record_schema":            "marcxml",
+	"sru.format":                   "marc21",
+	"sru.isbn_index":               "bath.isbn",
+	"sru.title_index":              "dc.title",
+	"sru.author_index":             "dc.creator",
+	"sru.milliseconds_per_request": 1000,
+
+	"provider_cache.identifier_ttl_days": 90,
+	"provider_cache.query_ttl_days":      7,
+
+	"retry.max_attempts":         3,
+	"retry.backoff_milliseconds": 1000,
+	"retry.cooldown_seconds":     900,
+
+	"collation.tie_breaker": "order",
+	"collation.name_order":  "first_last",
+
+	"webhooks.timeout_seconds": 10,
+
+	"http.timeout_seconds":               60,
+	"http.max_idle_connections":          100,
+	"http.max_idle_connections_per_host": 10,
+
 	"advanced.max_characters_to_search_for_isbn": 10000,
+	"advanced.timeout_seconds":                   300,
+	"advanced.max_isbn_lookups_per_file":         10,
+	"advanced.outage_timeout_seconds":            1800,
 }
 
-func NewConfig(configPath string) (*Config, error) {
-	configData, err := os.ReadFile(configPath)
+// workerStages are the stages a worker takes a book through, each with the whole of advanced.timeout_seconds
+const workerStages = 5
+
+// DefaultPath is the config file read if none is given, when it exists
+const DefaultPath = "booker.toml"
+
+// Source is where a config is read from
+type Source struct {
+	// Path is the config file. If empty, it is DefaultPath if there is one and otherwise DefaultFile.
+	Path string
+	// Profile is the profile in the file whose keys replace its own, if any
+	Profile string
+	// Settings are keys given as key=value, like tika.host=localhost, which replace those of the file and the
+	// environment
+	Settings []string
+}
+
+// NewConfig reads the config of source with the keys of its profile in place of its own, then those given as BOOKER_
+// environment variables and then its settings. Keys it doesn't know are warned about and otherwise ignored.
+func NewConfig(source Source) (*Config, error) {
+	config, unknown, err := Load(source)
 	if err != nil {
 		return nil, err
 	}
+	for _, key := range unknown {
+		slog.Warn("ignoring unknown config key", "key", key)
+	}
 
-	var config Config
-	_, err = toml.Decode(string(configData), &config)
+	err = config.Validate()
 	if err != nil {
 		return nil, err
 	}
 
-	err = config.Validate()
+	return config, nil
+}
+
+// Load reads the config like NewConfig, returning the keys it doesn't know rather than warning about them and leaving
+// it to be validated
+func Load(source Source) (*Config, []UnknownKey, error) {
+	configPath := source.Path
+	if len(configPath) == 0 {
+		configPath = DefaultPath
+	}
+	configData, err := os.ReadFile(configPath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && len(source.Path) == 0:
+		// without a config file of its own, a run uses a local Tika server and Google Books
+		configPath, configData = "the default config", DefaultFile
+	case errors.Is(err, fs.ErrNotExist):
+		return nil, nil, fmt.Errorf("there is no config at %s, write one with booker config init", configPath)
+	case err != nil:
+		return nil, nil, err
+	}
+
+	var config Config
+	var tables map[string]toml.Primitive
+	metadata, err := toml.Decode(string(configData), &tables)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	undecoded, err := decodeTables(metadata, tables, nil, &config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	profile := source.Profile
+	if _, ok := config.Profiles[profile]; len(profile) > 0 && !ok {
+		return nil, nil, fmt.Errorf("profile %s isn't in %s", profile, configPath)
+	}
+	// every profile is decoded, so that a mistake in one is found before it is picked
+	for _, name := range slices.Sorted(maps.Keys(config.Profiles)) {
+		target := &Config{}
+		if name == profile {
+			target = &config
+		}
+		var profileTables map[string]toml.Primitive
+		err = metadata.PrimitiveDecode(config.Profiles[name], &profileTables)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid profile %s: %s", name, err.Error())
+		}
+		unknown, err := decodeTables(metadata, profileTables, []string{"profile", name}, target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid profile %s: %s", name, err.Error())
+		}
+		undecoded = append(undecoded, unknown...)
+	}
+
+	overrides := newOverrides()
+	err = overrides.environment(os.Environ())
+	if err != nil {
+		return nil, nil, err
+	}
+	err = overrides.settings(source.Settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = overrides.apply(&config)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return &config, nil
+	return &config, unknownKeys(append(metadata.Undecoded(), undecoded...)), nil
 }
 
 func (c *Config) Validate() error {
+	err := resolveSecrets(reflect.ValueOf(c).Elem(), nil)
+	if err != nil {
+		return err
+	}
+
 	if c.Tika.Enable {
 		errorMsg := "%s must be configured if tika is enabled"
 
-		if len(c.Tika.Host) == 0 {
-			return fmt.Errorf(errorMsg, "tika.host")
+		if len(c.Tika.Host) == 0 && len(c.Tika.Hosts) == 0 && !c.Tika.Managed {
+			return fmt.Errorf(errorMsg, "tika.host or tika.hosts")
+		}
+		for _, host := range c.Tika.Hosts {
+			if len(host) == 0 {
+				return fmt.Errorf("tika.hosts must not contain an empty host")
+			}
 		}
 		if c.Tika.Port == 0 {
 			c.Tika.Port = Defaults["tika.port"].(int)
 		}
+		if c.Tika.Port < 0 || c.Tika.Port > 65535 {
+			return fmt.Errorf("tika.port must be between 1 and 65535 but was %d", c.Tika.Port)
+		}
+		if len(c.Tika.Scheme) == 0 {
+			c.Tika.Scheme = Defaults["tika.scheme"].(string)
+		}
+		if c.Tika.Scheme != "http" && c.Tika.Scheme != "https" {
+			return fmt.Errorf("tika.scheme must be one of http or https but was %s", c.Tika.Scheme)
+		}
+		if len(c.Tika.Launcher) == 0 {
+			c.Tika.Launcher = Defaults["tika.launcher"].(string)
+		}
+		if c.Tika.Launcher != "docker" && c.Tika.Launcher != "java" {
+			return fmt.Errorf("tika.launcher must be one of docker or java but was %s", c.Tika.Launcher)
+		}
+		if len(c.Tika.Image) == 0 {
+			c.Tika.Image = Defaults["tika.image"].(string)
+		}
+		if len(c.Tika.Version) == 0 {
+			c.Tika.Version = Defaults["tika.version"].(string)
+		}
+		if c.Tika.FrontMatterBytes == 0 {
+			c.Tika.FrontMatterBytes = uint(Defaults["tika.front_matter_bytes"].(int))
+		}
+		if c.Tika.FrontMatterFormats == nil {
+			c.Tika.FrontMatterFormats = Defaults["tika.front_matter_formats"].([]string)
+		}
+	}
+
+	if c.Djvu.Enable {
+		if len(c.Djvu.Command) == 0 {
+			c.Djvu.Command = Defaults["djvu.command"].(string)
+		}
 	}
 
-	if c.Google.Enable {
-		if len(c.Google.Url) == 0 {
-			c.Google.Url = Defaults["google.url"].(string)
+	commands := make(map[string]struct{})
+	for idx := range c.Commands {
+		command := &c.Commands[idx]
+		if len(command.Name) == 0 {
+			return fmt.Errorf("commands[%d].name must be configured", idx)
+		}
+		if _, taken := commands[strings.ToLower(command.Name)]; taken {
+			return fmt.Errorf("command %s is configured more than once", command.Name)
+		}
+		commands[strings.ToLower(command.Name)] = struct{}{}
+		if !strings.Contains(command.Command, "{file}") {
+			return fmt.Errorf("command %s must have a command with {file} in it", command.Name)
 		}
-		if c.Google.MillisecondsPerRequest == 0 {
-			c.Google.MillisecondsPerRequest = uint(Defaults["google.milliseconds_per_request"].(int))
+		if len(command.Extensions) == 0 {
+			return fmt.Errorf("command %s must have the extensions of the books it reads", command.Name)
+		}
+		for extIdx, ext := range command.Extensions {
+			ext = strings.ToLower(ext)
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			command.Extensions[extIdx] = ext
 		}
 	}
 
+	names := make(map[string]struct{})
+	for _, table := range instanceTables {
+		if err := c.validateInstances(table, names); err != nil {
+			return err
+		}
+	}
+	for idx := range c.Plugins {
+		plugin := &c.Plugins[idx]
+		if len(plugin.Name) == 0 {
+			return fmt.Errorf("plugins[%d].name must be configured", idx)
+		}
+		name := strings.ToLower(plugin.Name)
+		if _, builtin := sourceNames[name]; builtin {
+			return fmt.Errorf("plugin %s must not be named after one of Booker's own sources", plugin.Name)
+		}
+		if _, taken := names[name]; taken {
+			return fmt.Errorf("plugin %s is configured more than once, or shares its name with a provider", plugin.Name)
+		}
+		names[name] = struct{}{}
+		if len(plugin.Command) == 0 {
+			return fmt.Errorf("plugin %s must have a command", plugin.Name)
+		}
+		if len(plugin.Lookups) == 0 {
+			plugin.Lookups = Defaults["plugins.lookups"].([]string)
+		}
+		for _, lookup := range plugin.Lookups {
+			if lookup != "isbn" && lookup != "doi" && lookup != "asin" && lookup != "arxiv" && lookup != "query" {
+				return fmt.Errorf("plugin %s lookups must be isbn, doi, asin, arxiv or query but was %s", plugin.Name, lookup)
+			}
+		}
+		if plugin.MillisecondsPerRequest == 0 {
+			plugin.MillisecondsPerRequest = uint(Defaults["plugins.milliseconds_per_request"].(int))
+		}
+		if err := plugin.Retry.validate(plugin.Name); err != nil {
+			return err
+		}
+	}
+
+	for idx := range c.Sru {
+		catalog := &c.Sru[idx]
+		if len(catalog.Name) == 0 {
+			return fmt.Errorf("sru[%d].name must be configured", idx)
+		}
+		name := strings.ToLower(catalog.Name)
+		if _, builtin := sourceNames[name]; builtin {
+			return fmt.Errorf("sru %s must not be named after one of Booker's own sources", catalog.Name)
+		}
+		if _, taken := names[name]; taken {
+			return fmt.Errorf("sru %s is configured more than once, or shares its name with a plugin or a provider", catalog.Name)
+		}
+		names[name] = struct{}{}
+		if endpoint, err := url.Parse(catalog.Url); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+			return fmt.Errorf("sru %s url must be a URL like https://services.dnb.de/sru/dnb but was %s", catalog.Name, catalog.Url)
+		}
+		if len(catalog.Version) == 0 {
+			catalog.Version = Defaults["sru.version"].(string)
+		}
+		if len(catalog.RecordSchema) == 0 {
+			catalog.RecordSchema = Defaults["sru.record_schema"].(string)
+		}
+		if len(catalog.Format) == 0 {
+			catalog.Format = Defaults["sru.format"].(string)
+		}
+		catalog.Format = strings.ToLower(catalog.Format)
+		if catalog.Format != "marc21" && catalog.Format != "unimarc" {
+			return fmt.Errorf("sru %s format must be marc21 or unimarc but was %s", catalog.Name, catalog.Format)
+		}
+		if len(catalog.IsbnIndex) == 0 {
+			catalog.IsbnIndex = Defaults["sru.isbn_index"].(string)
+		}
+		if len(catalog.TitleIndex) == 0 {
+			catalog.TitleIndex = Defaults["sru.title_index"].(string)
+		}
+		if len(catalog.AuthorIndex) == 0 {
+			catalog.AuthorIndex = Defaults["sru.author_index"].(string)
+		}
+		if catalog.MillisecondsPerRequest == 0 {
+			catalog.MillisecondsPerRequest = uint(Defaults["sru.milliseconds_per_request"].(int))
+		}
+		if err := catalog.Retry.validate(catalog.Name); err != nil {
+			return err
+		}
+	}
+
+	if c.ProviderCache.Enable {
+		if len(c.ProviderCache.Path) == 0 {
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				return fmt.Errorf("provider_cache.path must be configured since there is no cache directory: %s", err.Error())
+			}
+			c.ProviderCache.Path = filepath.Join(cacheDir, "booker", "providers.db")
+		}
+		if c.ProviderCache.IdentifierTtlDays == 0 {
+			c.ProviderCache.IdentifierTtlDays = uint(Defaults["provider_cache.identifier_ttl_days"].(int))
+		}
+		if c.ProviderCache.QueryTtlDays == 0 {
+			c.ProviderCache.QueryTtlDays = uint(Defaults["provider_cache.query_ttl_days"].(int))
+		}
+	}
+
+	if len(c.Collation.TieBreaker) == 0 {
+		c.Collation.TieBreaker = Defaults["collation.tie_breaker"].(string)
+	}
+	if c.Collation.TieBreaker != "order" && c.Collation.TieBreaker != "completeness" {
+		return fmt.Errorf("collation.tie_breaker must be one of order or completeness but was %s", c.Collation.TieBreaker)
+	}
+	if len(c.Collation.NameOrder) == 0 {
+		c.Collation.NameOrder = Defaults["collation.name_order"].(string)
+	}
+	if c.Collation.NameOrder != "first_last" && c.Collation.NameOrder != "last_first" {
+		return fmt.Errorf("collation.name_order must be one of first_last or last_first but was %s", c.Collation.NameOrder)
+	}
+	for source, weight := range c.Collation.Weights {
+		if weight < 0 {
+			return fmt.Errorf("collation.weights.%s must not be negative", source)
+		}
+	}
+
+	for _, pattern := range c.Scan.Include {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("scan.include pattern %s is invalid: %s", pattern, err.Error())
+		}
+	}
+	for _, pattern := range c.Scan.Exclude {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("scan.exclude pattern %s is invalid: %s", pattern, err.Error())
+		}
+	}
+
+	if c.Webdav.Enable && len(c.Webdav.Password) > 0 && len(c.Webdav.Username) == 0 {
+		return fmt.Errorf("webdav.username must be configured if webdav.password is")
+	}
+
+	if len(c.Http.Proxy) > 0 {
+		if proxy, err := url.Parse(c.Http.Proxy); err != nil || len(proxy.Host) == 0 {
+			return fmt.Errorf("http.proxy must be a URL like http://proxy.example.com:3128 but was %s", c.Http.Proxy)
+		}
+	}
+	if len(c.Http.CaBundle) > 0 {
+		if _, err := os.Stat(c.Http.CaBundle); err != nil {
+			return fmt.Errorf("http.ca_bundle %s could not be read: %s", c.Http.CaBundle, err.Error())
+		}
+	}
+	if c.Http.TimeoutSeconds == 0 {
+		c.Http.TimeoutSeconds = uint(Defaults["http.timeout_seconds"].(int))
+	}
+	if c.Http.MaxIdleConnections == 0 {
+		c.Http.MaxIdleConnections = Defaults["http.max_idle_connections"].(int)
+	}
+	if c.Http.MaxIdleConnectionsPerHost == 0 {
+		c.Http.MaxIdleConnectionsPerHost = Defaults["http.max_idle_connections_per_host"].(int)
+	}
+	if c.Http.MaxIdleConnections < 0 {
+		return fmt.Errorf("http.max_idle_connections must not be negative")
+	}
+	if c.Http.MaxIdleConnectionsPerHost < 0 {
+		return fmt.Errorf("http.max_idle_connections_per_host must not be negative")
+	}
+	if c.Http.MaxConnectionsPerHost < 0 {
+		return fmt.Errorf("http.max_connections_per_host must not be negative")
+	}
+
+	if len(c.Intake.Url) > 0 {
+		intake, err := url.Parse(c.Intake.Url)
+		if err != nil || (intake.Scheme != "redis" && intake.Scheme != "nats") || len(intake.Host) == 0 {
+			return fmt.Errorf("intake.url must be a URL like redis://localhost:6379 or nats://localhost:4222 but was %s", c.Intake.Url)
+		}
+		if len(c.Intake.Key) == 0 {
+			return fmt.Errorf("intake.key must be configured if intake.url is")
+		}
+		if len(c.Intake.QueueGroup) > 0 && intake.Scheme == "redis" {
+			return fmt.Errorf("intake.queue_group is only used with NATS, a Redis list already hands each path out once")
+		}
+	}
+
+	for event, urls := range map[string][]string{
+		"on_book_identified": c.Webhooks.OnBookIdentified,
+		"on_book_failed":     c.Webhooks.OnBookFailed,
+		"on_scan_complete":   c.Webhooks.OnScanComplete,
+	} {
+		for _, hook := range urls {
+			if endpoint, err := url.Parse(hook); err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || len(endpoint.Host) == 0 {
+				return fmt.Errorf("webhooks.%s must be URLs like https://example.com/booker but had %s", event, hook)
+			}
+		}
+	}
+	if c.Webhooks.TimeoutSeconds == 0 {
+		c.Webhooks.TimeoutSeconds = uint(Defaults["webhooks.timeout_seconds"].(int))
+	}
+
 	if c.Advanced.MaxCharactersToSearchForIsbn == 0 {
 		c.Advanced.MaxCharactersToSearchForIsbn = uint(Defaults["advanced.max_characters_to_search_for_isbn"].(int))
 	}
 
+	if c.Advanced.TimeoutSeconds == 0 {
+		c.Advanced.TimeoutSeconds = uint(Defaults["advanced.timeout_seconds"].(int))
+	}
+
+	if c.Advanced.MaxIsbnLookupsPerFile == 0 {
+		c.Advanced.MaxIsbnLookupsPerFile = uint(Defaults["advanced.max_isbn_lookups_per_file"].(int))
+	}
+
+	if c.Advanced.OutageTimeoutSeconds == 0 {
+		c.Advanced.OutageTimeoutSeconds = uint(Defaults["advanced.outage_timeout_seconds"].(int))
+	}
+
+	if c.Advanced.WorkerTimeoutSeconds == 0 {
+		c.Advanced.WorkerTimeoutSeconds = workerStages * c.Advanced.TimeoutSeconds
+	}
+
+	if c.Advanced.EarlyExitConfidence < 0 || c.Advanced.EarlyExitConfidence > 100 {
+		return fmt.Errorf("advanced.early_exit_confidence must be between 0 and 100 but was %g", c.Advanced.EarlyExitConfidence)
+	}
+
 	return nil
 }
@@ -0,0 +1,176 @@
+package config_test
+
+import (
+	"bytes"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// load reads contents as a config file, picking profile if it isn't empty
+func load(t *testing.T, contents string, profile string, settings ...string) (*config.Config, []config.UnknownKey, error) {
+	path := filepath.Join(t.TempDir(), "booker.toml")
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return config.Load(config.Source{Path: path, Profile: profile, Settings: settings})
+}
+
+func TestSecrets(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("the commands are scripts run with sh")
+	}
+	dir := t.TempDir()
+	script := func(name string, body string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o700))
+		return path
+	}
+	keyFile := filepath.Join(dir, "key")
+	assert.NoError(t, os.WriteFile(keyFile, []byte("from-file\n"), 0o600))
+	pass := script("pass", "echo '  from-command  '\necho second line")
+	empty := script("empty", "true")
+	failing := script("failing", "exit 1")
+
+	tests := []struct {
+		name string
+		key  string
+		want string
+		err  string
+	}{
+		{name: "key", key: `api_key = "given"`, want: "given"},
+		{name: "file without its newline", key: `api_key_file = "` + keyFile + `"`, want: "from-file"},
+		{name: "first line of the command", key: `api_key_cmd = "` + pass + `"`, want: "from-command"},
+		{name: "missing file", key: `api_key_file = "` + filepath.Join(dir, "missing") + `"`, err: "google.api_key_file could not be read"},
+		{name: "failing command", key: `api_key_cmd = "` + failing + `"`, err: "google.api_key_cmd failed: exit status 1"},
+		{name: "command printing nothing", key: `api_key_cmd = "` + empty + `"`, err: "google.api_key_cmd failed: " + empty + " printed nothing"},
+		{name: "key and file", key: `api_key = "given"` + "\n" + `api_key_file = "` + keyFile + `"`, err: "only one of google.api_key, google.api_key_file and google.api_key_cmd can be configured"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf, _, err := load(t, "[google]\nenable = true\n"+test.key+"\n", "")
+			assert.NoError(t, err)
+			err = conf.Validate()
+			if len(test.err) > 0 {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, conf.Google[0].ApiKey)
+			assert.Empty(t, conf.Google[0].ApiKeyFile)
+			assert.Empty(t, conf.Google[0].ApiKeyCmd)
+		})
+	}
+
+	// a disabled provider's command isn't run
+	conf, _, err := load(t, "[google]\nenable = false\napi_key_cmd = \""+failing+"\"\n", "")
+	assert.NoError(t, err)
+	assert.NoError(t, conf.Validate())
+}
+
+const profilesConfig = `
+[tika]
+host = "file"
+[scan]
+exclude = ["node_modules", "*.tmp"]
+[advanced]
+early_exit_confidence = 50
+
+[profile.fast.tika]
+host = "profile"
+[profile.fast.scan]
+exclude = [".git"]
+`
+
+func TestOverrides(t *testing.T) {
+	conf, _, err := load(t, profilesConfig, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "file", conf.Tika.Host)
+	assert.Equal(t, []string{"node_modules", "*.tmp"}, conf.Scan.Exclude)
+
+	// the profile replaces the keys of the file, lists and all, and leaves the rest
+	conf, _, err = load(t, profilesConfig, "fast")
+	assert.NoError(t, err)
+	assert.Equal(t, "profile", conf.Tika.Host)
+	assert.Equal(t, []string{".git"}, conf.Scan.Exclude)
+	assert.Equal(t, 50.0, conf.Advanced.EarlyExitConfidence)
+
+	_, _, err = load(t, profilesConfig, "slow")
+	assert.ErrorContains(t, err, "profile slow isn't in")
+
+	// the environment replaces the profile, and --set the environment
+	t.Setenv("BOOKER_TIKA_HOST", "env")
+	t.Setenv("BOOKER_SCAN_EXCLUDE", "build,dist")
+	t.Setenv("BOOKER_ADVANCED_EARLY_EXIT_CONFIDENCE", "80")
+	conf, _, err = load(t, profilesConfig, "fast")
+	assert.NoError(t, err)
+	assert.Equal(t, "env", conf.Tika.Host)
+	assert.Equal(t, []string{"build", "dist"}, conf.Scan.Exclude)
+	assert.Equal(t, 80.0, conf.Advanced.EarlyExitConfidence)
+
+	conf, _, err = load(t, profilesConfig, "fast", "tika.host=set")
+	assert.NoError(t, err)
+	assert.Equal(t, "set", conf.Tika.Host)
+
+	_, _, err = load(t, profilesConfig, "", "tika.hots=set")
+	assert.EqualError(t, err, "--set tika.hots isn't a config key")
+
+	t.Setenv("BOOKER_ADVANCED_EARLY_EXIT_CONFIDENCE", "high")
+	_, _, err = load(t, profilesConfig, "")
+	assert.ErrorContains(t, err, "BOOKER_ADVANCED_EARLY_EXIT_CONFIDENCE has an invalid value for advanced.early_exit_confidence")
+}
+
+func TestUnknownKeys(t *testing.T) {
+	contents := `
+[tika]
+enable = false
+schme = "https"
+[google]
+requests_per_second = 1000
+[goggle]
+enable = true
+[profile.fast.advanced]
+early_exit_confidance = 80
+`
+	_, unknown, err := load(t, contents, "")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []config.UnknownKey{
+		{Key: "tika.schme", Suggestion: "tika.scheme"},
+		{Key: "google.requests_per_second", Suggestion: "google.milliseconds_per_request"},
+		{Key: "goggle", Suggestion: "google"},
+		{Key: "profile.fast.advanced.early_exit_confidance", Suggestion: "profile.fast.advanced.early_exit_confidence"},
+	}, unknown)
+
+	// they are warned about and otherwise ignored, like a variable that isn't a key
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	t.Setenv("BOOKER_TIKA_HOTS", "localhost")
+	path := filepath.Join(t.TempDir(), "booker.toml")
+	assert.NoError(t, os.WriteFile(path, []byte("[tika]\nenable = false\nschme = \"https\"\n"), 0o600))
+	_, err = config.NewConfig(config.Source{Path: path})
+	assert.NoError(t, err)
+	assert.Contains(t, logs.String(), `msg="ignoring unknown config key" key="tika.schme (did you mean tika.scheme?)"`)
+	assert.Contains(t, logs.String(), `msg="ignoring environment variable that isn't a config key" variable=BOOKER_TIKA_HOTS`)
+
+	// a known key with a value out of range is an error rather than a warning
+	conf, unknown, err := load(t, "[advanced]\nearly_exit_confidence = 120\n", "")
+	assert.NoError(t, err)
+	assert.Empty(t, unknown)
+	assert.EqualError(t, conf.Validate(), "advanced.early_exit_confidence must be between 0 and 100 but was 120")
+}
+
+func TestWorkerTimeout(t *testing.T) {
+	// by default a worker has as long as it could take over a book's five stages
+	conf, _, err := load(t, "[advanced]\ntimeout_seconds = 60\n", "")
+	assert.NoError(t, err)
+	assert.NoError(t, conf.Validate())
+	assert.Equal(t, uint(300), conf.Advanced.WorkerTimeoutSeconds)
+
+	conf, _, err = load(t, "[advanced]\nworker_timeout_seconds = 7200\n", "")
+	assert.NoError(t, err)
+	assert.NoError(t, conf.Validate())
+	assert.Equal(t, uint(7200), conf.Advanced.WorkerTimeoutSeconds)
+}
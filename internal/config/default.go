@@ -0,0 +1,8 @@
+package config
+
+import _ "embed"
+
+// DefaultFile is the config config init writes, with every section and its defaults commented
+//
+//go:embed booker.toml
+var DefaultFile []byte
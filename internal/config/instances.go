@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// instanceTables are the providers that can be configured more than once, as [[google]], each instance finding results
+// under its own name and waiting on its own rate limit
+var instanceTables = []string{"google", "isbndb", "worldcat", "crossref", "springer", "arxiv", "amazon", "comicvine"}
+
+// field is the field of v with the toml tag name, if any
+func field(v reflect.Value, name string) reflect.Value {
+	for i := range v.NumField() {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("toml"), ",")
+		if tag == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+// validateInstances validates every enabled instance of the provider of table, keyed as google or, when there are
+// several, as google[1]. The name of each, or that of the provider for one without a name, is added to names and must
+// not be there already.
+func (c *Config) validateInstances(table string, names map[string]struct{}) error {
+	instances := field(reflect.ValueOf(c).Elem(), table)
+	for idx := range instances.Len() {
+		instance := instances.Index(idx)
+		if !instance.FieldByName("Enable").Bool() {
+			continue
+		}
+		key := table
+		if instances.Len() > 1 {
+			key = fmt.Sprintf("%s[%d]", table, idx)
+		}
+
+		name := strings.ToLower(instance.FieldByName("Name").String())
+		if len(name) == 0 {
+			name = table
+		}
+		if _, builtin := sourceNames[name]; builtin && name != table {
+			return fmt.Errorf("%s.name must not be named after one of Booker's own sources", key)
+		}
+		if _, taken := names[name]; taken {
+			return fmt.Errorf("%s is named %s like another instance, give each instance of %s a name of its own", key, name, table)
+		}
+		names[name] = struct{}{}
+
+		if err := instance.Addr().Interface().(interface{ validate(string) error }).validate(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeTables decodes the tables of a config into config, keys being where they are in the file, like profile.fast
+// for those of a profile. A table given once for a list of them, like [google] or [profile.fast.sru], is decoded over
+// every one there already is, or as the only one if there are none. The top-level keys config doesn't have are returned,
+// since they count as decoded.
+func decodeTables(metadata toml.MetaData, tables map[string]toml.Primitive, keys []string, config *Config) ([]toml.Key, error) {
+	v := reflect.ValueOf(config).Elem()
+	unknown := make([]toml.Key, 0)
+	for _, name := range slices.Sorted(maps.Keys(tables)) {
+		key := append(append(toml.Key{}, keys...), name)
+		target := field(v, name)
+		if !target.IsValid() {
+			unknown = append(unknown, key)
+			continue
+		}
+
+		if target.Kind() == reflect.Slice && target.Type().Elem().Kind() == reflect.Struct && metadata.Type(key...) == "Hash" {
+			if target.Len() == 0 {
+				target.Set(reflect.MakeSlice(target.Type(), 1, 1))
+			}
+			for idx := range target.Len() {
+				if err := metadata.PrimitiveDecode(tables[name], target.Index(idx).Addr().Interface()); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if err := metadata.PrimitiveDecode(tables[name], target.Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return unknown, nil
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"github.com/BurntSushi/toml"
+	"github.com/larkwiot/booker/internal/util"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// renamedKeys are the keys that used to be read by another name, to point a config still using the old one to the new
+var renamedKeys = map[string]string{
+	"google.requests_per_second": "google.milliseconds_per_request",
+}
+
+// secretKeys are the names of the keys Redact hides
+var secretKeys = map[string]bool{
+	"api_key": true, "auth_token": true, "client_secret": true, "password": true, "secret_key": true, "access_key": true,
+}
+
+// UnknownKey is a key in a config that Booker doesn't know, with the key that was likely meant, if any
+type UnknownKey struct {
+	Key        string
+	Suggestion string
+}
+
+func (k UnknownKey) String() string {
+	if len(k.Suggestion) == 0 {
+		return k.Key
+	}
+	return k.Key + " (did you mean " + k.Suggestion + "?)"
+}
+
+// knownKeys adds every key of a config of type t to keys, including those of the tables in arrays like [[plugins]]
+func knownKeys(t reflect.Type, tables []string, keys map[string]bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if len(name) == 0 || name == "-" || (len(tables) == 0 && name == "profile") {
+			continue
+		}
+		path := append(append([]string{}, tables...), name)
+		keys[strings.Join(path, ".")] = true
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			knownKeys(field.Type, path, keys)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			knownKeys(field.Type.Elem(), path, keys)
+		}
+	}
+}
+
+// unknownKeys are the keys a config was decoded with but doesn't have, suggesting a key of the same table with a
+// similar name for a typo
+func unknownKeys(undecoded []toml.Key) []UnknownKey {
+	keys := make(map[string]bool)
+	knownKeys(reflect.TypeOf(Config{}), nil, keys)
+
+	tables := make(map[string]bool)
+	for _, key := range undecoded {
+		tables[key.String()] = true
+	}
+
+	unknown := make([]UnknownKey, 0, len(undecoded))
+	reported := make(map[string]bool)
+	for _, key := range undecoded {
+		// a table Booker doesn't know is reported once, rather than along with every key in it, and the keys of the
+		// profile picked are undecoded twice
+		if (len(key) > 1 && tables[key[:len(key)-1].String()]) || reported[key.String()] {
+			continue
+		}
+		reported[key.String()] = true
+		// the keys of a profile are those of the config
+		path := []string(key)
+		if len(path) > 2 && path[0] == "profile" {
+			path = path[2:]
+		}
+
+		name := strings.Join(path, ".")
+		suggestion, renamed := renamedKeys[name]
+		if !renamed {
+			table, field := "", name
+			if idx := strings.LastIndex(name, "."); idx >= 0 {
+				table, field = name[:idx+1], name[idx+1:]
+			}
+			// only a couple of characters off counts as a typo
+			best := 3
+			for _, known := range slices.Sorted(maps.Keys(keys)) {
+				rest, ok := strings.CutPrefix(known, table)
+				if !ok || strings.Contains(rest, ".") {
+					continue
+				}
+				if distance := util.LevenshteinDistance(field, rest); distance < best {
+					best, suggestion = distance, known
+				}
+			}
+		}
+		if len(suggestion) > 0 && len(path) < len(key) {
+			suggestion = strings.Join(key[:2], ".") + "." + suggestion
+		}
+		unknown = append(unknown, UnknownKey{Key: key.String(), Suggestion: suggestion})
+	}
+	return unknown
+}
+
+// Redact hides the values of the keys that hold credentials, like api_key and password, and of the headers sent, so
+// the config can be printed
+func (c *Config) Redact() {
+	redact(reflect.ValueOf(c).Elem())
+}
+
+func redact(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+			value := v.Field(i)
+			switch {
+			case !field.IsExported():
+			case value.Kind() == reflect.String && secretKeys[name] && value.Len() > 0:
+				value.SetString("<redacted>")
+			case value.Kind() == reflect.Map && name == "headers":
+				for _, header := range value.MapKeys() {
+					value.SetMapIndex(header, reflect.ValueOf("<redacted>"))
+				}
+			default:
+				redact(value)
+			}
+		}
+	case reflect.Slice:
+		for i := range v.Len() {
+			redact(v.Index(i))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"log/slog"
+	"reflect"
+	"strings"
+)
+
+// EnvironmentPrefix starts the name of every environment variable that overrides a key of the config, as in
+// BOOKER_TIKA_HOST for tika.host
+const EnvironmentPrefix = "BOOKER_"
+
+// overrideKey is a key of the config that can be overridden, as its tables and name, with the type of its value
+type overrideKey struct {
+	path []string
+	kind reflect.Type
+}
+
+// overrideKeys maps every key of a config of type t that can be overridden to the key, keyed as in tika.host
+func overrideKeys(t reflect.Type, tables []string, keys map[string]overrideKey) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		// profiles are chosen with --profile rather than overridden
+		if len(name) == 0 || name == "-" || (len(tables) == 0 && name == "profile") {
+			continue
+		}
+		path := append(append([]string{}, tables...), name)
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			overrideKeys(field.Type, path, keys)
+			continue
+		// a key of a list of tables, like sru.milliseconds_per_request, is overridden in every one of them
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+			overrideKeys(field.Type.Elem(), path, keys)
+			continue
+		}
+		keys[strings.Join(path, ".")] = overrideKey{path: path, kind: field.Type}
+	}
+}
+
+// parseOverride is the value of an overridden key. Strings are taken as they are, lists of strings can be comma
+// separated, and anything else is written like in TOML.
+func parseOverride(value string, kind reflect.Type) (any, error) {
+	if kind.Kind() == reflect.String {
+		return value, nil
+	}
+	var parsed map[string]any
+	_, err := toml.Decode("value = "+value, &parsed)
+	if err == nil {
+		return parsed["value"], nil
+	}
+	if kind.Kind() == reflect.Slice && kind.Elem().Kind() == reflect.String {
+		return strings.Split(value, ","), nil
+	}
+	return nil, err
+}
+
+// overrides are the keys of a config to replace with other values, as they are given
+type overrides struct {
+	keys   map[string]overrideKey
+	values map[string]any
+}
+
+func newOverrides() *overrides {
+	o := &overrides{keys: make(map[string]overrideKey), values: make(map[string]any)}
+	overrideKeys(reflect.TypeOf(Config{}), nil, o.keys)
+	return o
+}
+
+// set overrides key with value, origin being where it was given for errors
+func (o *overrides) set(origin string, key overrideKey, value string) error {
+	parsed, err := parseOverride(value, key.kind)
+	if err != nil {
+		return fmt.Errorf("%s has an invalid value for %s: %s", origin, strings.Join(key.path, "."), err.Error())
+	}
+
+	table := o.values
+	for _, name := range key.path[:len(key.path)-1] {
+		if _, ok := table[name]; !ok {
+			table[name] = make(map[string]any)
+		}
+		table = table[name].(map[string]any)
+	}
+	table[key.path[len(key.path)-1]] = parsed
+	return nil
+}
+
+// environment overrides the keys given as environment variables in environ, which is formatted like os.Environ
+func (o *overrides) environment(environ []string) error {
+	names := make(map[string]overrideKey, len(o.keys))
+	for _, key := range o.keys {
+		names[EnvironmentPrefix+strings.ToUpper(strings.Join(key.path, "_"))] = key
+	}
+
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, EnvironmentPrefix) || name == EnvironmentPrefix+"PROFILE" {
+			continue
+		}
+		key, ok := names[name]
+		if !ok {
+			slog.Warn("ignoring environment variable that isn't a config key", "variable", name)
+			continue
+		}
+		if err := o.set(name, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// settings overrides the keys given as key=value, like with --set
+func (o *overrides) settings(settings []string) error {
+	for _, setting := range settings {
+		name, value, ok := strings.Cut(setting, "=")
+		if !ok {
+			return fmt.Errorf("--set %s must be given as key=value, like tika.host=localhost", setting)
+		}
+		key, ok := o.keys[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("--set %s isn't a config key", name)
+		}
+		if err := o.set("--set "+name, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply decodes the overrides over config like a file of their own, so only the keys given change
+func (o *overrides) apply(config *Config) error {
+	if len(o.values) == 0 {
+		return nil
+	}
+	var buffer bytes.Buffer
+	err := toml.NewEncoder(&buffer).Encode(o.values)
+	if err != nil {
+		return fmt.Errorf("could not encode overridden config: %s", err.Error())
+	}
+	var tables map[string]toml.Primitive
+	metadata, err := toml.Decode(buffer.String(), &tables)
+	if err == nil {
+		_, err = decodeTables(metadata, tables, nil, config)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid overridden config: %s", err.Error())
+	}
+	return nil
+}
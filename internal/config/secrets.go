@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/larkwiot/booker/internal/util"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+// resolveSecrets reads the credentials of the enabled sections of v given as a file, like api_key_file, or as the
+// output of a command, like api_key_cmd = "pass show google-books", into the key itself. The file or command is
+// cleared once read, so that resolving again leaves the key as it is.
+func resolveSecrets(v reflect.Value, tables []string) error {
+	t := v.Type()
+	if enable := v.FieldByName("Enable"); enable.IsValid() && enable.Kind() == reflect.Bool && !enable.Bool() {
+		return nil
+	}
+
+	fields := make(map[string]reflect.Value)
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("toml"), ",")
+		if t.Field(i).IsExported() && len(name) > 0 && name != "-" {
+			fields[name] = v.Field(i)
+		}
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		value := fields[name]
+		if !value.IsValid() {
+			continue
+		}
+		if value.Kind() == reflect.Struct {
+			if err := resolveSecrets(value, append(append([]string{}, tables...), name)); err != nil {
+				return err
+			}
+			continue
+		}
+		if value.Kind() == reflect.Slice && value.Type().Elem().Kind() == reflect.Struct {
+			for idx := range value.Len() {
+				table := name
+				if value.Len() > 1 {
+					table = fmt.Sprintf("%s[%d]", name, idx)
+				}
+				if err := resolveSecrets(value.Index(idx), append(append([]string{}, tables...), table)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !secretKeys[name] {
+			continue
+		}
+
+		key := strings.Join(append(tables, name), ".")
+		file, cmd := fields[name+"_file"], fields[name+"_cmd"]
+		if !file.IsValid() || !cmd.IsValid() {
+			continue
+		}
+		given := 0
+		for _, source := range []reflect.Value{value, file, cmd} {
+			if source.Len() > 0 {
+				given++
+			}
+		}
+		if given > 1 {
+			return fmt.Errorf("only one of %s, %s_file and %s_cmd can be configured", key, key, key)
+		}
+
+		switch {
+		case file.Len() > 0:
+			data, err := os.ReadFile(util.ExpandUser(file.String()))
+			if err != nil {
+				return fmt.Errorf("%s_file could not be read: %s", key, err.Error())
+			}
+			value.SetString(strings.TrimSpace(string(data)))
+			file.SetString("")
+		case cmd.Len() > 0:
+			secret, err := runSecretCommand(cmd.String())
+			if err != nil {
+				return fmt.Errorf("%s_cmd failed: %s", key, err.Error())
+			}
+			value.SetString(secret)
+			cmd.SetString("")
+		}
+	}
+	return nil
+}
+
+// runSecretCommand is the first line the command prints, which is where password managers like pass put the password.
+// It is run with the terminal, so that it can ask for a passphrase.
+func runSecretCommand(command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", fmt.Errorf("no command")
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(util.ExpandUser(args[0]), args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	secret := strings.TrimSpace(line)
+	if len(secret) == 0 {
+		return "", fmt.Errorf("%s printed nothing", args[0])
+	}
+	return secret, nil
+}
@@ -0,0 +1,168 @@
+package covers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"golang.org/x/image/draw"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ThumbnailWidth is how wide thumbnails are made, covers narrower than it are kept at their own size
+const ThumbnailWidth = 200
+
+// maxCoverSize is the most that is downloaded for a cover, anything bigger isn't a cover
+const maxCoverSize = 20 * 1024 * 1024
+
+// openLibraryInterval keeps requests to the Open Library Covers API under its limit of 100 per 5 minutes for covers
+// looked up by ISBN
+const openLibraryInterval = 3 * time.Second
+
+// openLibraryUrl is the cover of an ISBN in the Open Library Covers API, which answers 404 rather than a blank image if
+// it has none
+func openLibraryUrl(isbn string) string {
+	return fmt.Sprintf("https://covers.openlibrary.org/b/isbn/%s-L.jpg?default=false", isbn)
+}
+
+// Fetcher downloads the covers of books into a directory, named by the hash of the book along with a thumbnail
+type Fetcher struct {
+	dir         string
+	openLibrary <-chan time.Time
+	client      *http.Client
+}
+
+// NewFetcher makes a fetcher that downloads into dir, creating it if it doesn't exist
+func NewFetcher(dir string, client *http.Client) (*Fetcher, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error: could not create covers directory %s: %s", dir, err.Error())
+	}
+	return &Fetcher{
+		dir:         dir,
+		openLibrary: time.Tick(openLibraryInterval),
+		client:      client,
+	}, nil
+}
+
+func download(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cover download returned bad status code %d", response.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, maxCoverSize))
+}
+
+// thumbnail scales img down to ThumbnailWidth, keeping its aspect ratio
+func thumbnail(img image.Image) image.Image {
+	bounds := img.Bounds()
+	if bounds.Dx() <= ThumbnailWidth {
+		return img
+	}
+	height := max(1, bounds.Dy()*ThumbnailWidth/bounds.Dx())
+	scaled := image.NewRGBA(image.Rect(0, 0, ThumbnailWidth, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, bounds, draw.Over, nil)
+	return scaled
+}
+
+// save writes the cover as it was downloaded and a JPEG thumbnail of it, returning their paths
+func (f *Fetcher) save(name string, data []byte) (string, string, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", fmt.Errorf("error: cover is not an image: %s", err.Error())
+	}
+
+	extension := map[string]string{"jpeg": ".jpg", "png": ".png", "gif": ".gif"}[format]
+	cover := filepath.Join(f.dir, name+extension)
+	err = os.WriteFile(cover, data, 0644)
+	if err != nil {
+		return "", "", fmt.Errorf("error: could not write cover %s: %s", cover, err.Error())
+	}
+
+	var buffer bytes.Buffer
+	err = jpeg.Encode(&buffer, thumbnail(img), &jpeg.Options{Quality: 85})
+	if err != nil {
+		return "", "", fmt.Errorf("error: could not make thumbnail of %s: %s", cover, err.Error())
+	}
+	thumb := filepath.Join(f.dir, name+".thumb.jpg")
+	err = os.WriteFile(thumb, buffer.Bytes(), 0644)
+	if err != nil {
+		return "", "", fmt.Errorf("error: could not write thumbnail %s: %s", thumb, err.Error())
+	}
+
+	return cover, thumb, nil
+}
+
+// Fetch downloads the cover a provider gave the book, or else the one Open Library has for its ISBN, and records where
+// it and its thumbnail were saved in the book. A book with neither is left as it is.
+func (f *Fetcher) Fetch(ctx context.Context, bk *book.Book) error {
+	if len(bk.Hash) == 0 {
+		return fmt.Errorf("error: book has no hash to name its cover by")
+	}
+
+	type source struct {
+		url         string
+		openLibrary bool
+	}
+	sources := make([]source, 0)
+	if len(bk.CoverUrl) > 0 {
+		sources = append(sources, source{url: bk.CoverUrl})
+	}
+	if len(bk.Isbn13) > 0 {
+		sources = append(sources, source{url: openLibraryUrl(string(bk.Isbn13)), openLibrary: true})
+	} else if len(bk.Isbn10) > 0 {
+		sources = append(sources, source{url: openLibraryUrl(string(bk.Isbn10)), openLibrary: true})
+	}
+
+	var lastErr error
+	for _, s := range sources {
+		if s.openLibrary {
+			select {
+			case <-f.openLibrary:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		data, err := download(ctx, f.client, s.url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cover, thumb, err := f.save(bk.Hash, data)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		bk.Cover = cover
+		bk.Thumbnail = thumb
+		if s.openLibrary {
+			bk.CoverUrl = s.url
+			bk.SetProvenance("cover_url", "openlibrary")
+		}
+		return nil
+	}
+	return lastErr
+}
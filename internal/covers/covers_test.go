@@ -0,0 +1,67 @@
+package covers_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/covers"
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 600))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 600; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	assert.NoError(t, png.Encode(&encoded, img))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cover.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(encoded.Bytes())
+	}))
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "covers")
+	fetcher, err := covers.NewFetcher(dir, http.DefaultClient)
+	assert.NoError(t, err)
+
+	bk := book.Book{Title: "Algorithms", Hash: "abc", CoverUrl: server.URL + "/cover.png"}
+	assert.NoError(t, fetcher.Fetch(context.Background(), &bk))
+	assert.Equal(t, filepath.Join(dir, "abc.png"), bk.Cover)
+	assert.Equal(t, filepath.Join(dir, "abc.thumb.jpg"), bk.Thumbnail)
+
+	saved, err := os.ReadFile(bk.Cover)
+	assert.NoError(t, err)
+	assert.Equal(t, encoded.Bytes(), saved)
+
+	fh, err := os.Open(bk.Thumbnail)
+	assert.NoError(t, err)
+	defer fh.Close()
+	thumbnail, err := jpeg.DecodeConfig(fh)
+	assert.NoError(t, err)
+	assert.Equal(t, covers.ThumbnailWidth, thumbnail.Width)
+	assert.Equal(t, 300, thumbnail.Height)
+
+	missing := book.Book{Title: "Algorithms", Hash: "def", CoverUrl: server.URL + "/missing.png"}
+	assert.Error(t, fetcher.Fetch(context.Background(), &missing))
+	assert.Empty(t, missing.Cover)
+
+	none := book.Book{Title: "Algorithms", Hash: "ghi"}
+	assert.NoError(t, fetcher.Fetch(context.Background(), &none), "books without a cover are left alone")
+	assert.Empty(t, none.Thumbnail)
+}
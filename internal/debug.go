@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/providers"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// debugDump is what was found in a book and what the providers answered about it, written to the debug output to see
+// why the book was identified the way it was
+type debugDump struct {
+	Filepath        string               `json:"filepath"`
+	Snippet         string               `json:"snippet"`
+	Isbn10s         []book.ISBN10        `json:"isbn10s"`
+	Isbn13s         []book.ISBN13        `json:"isbn13s"`
+	UnsearchedIsbns []book.ISBN          `json:"unsearched_isbns,omitempty"`
+	Dois            []book.DOI           `json:"dois"`
+	Asins           []book.ASIN          `json:"asins"`
+	Arxivs          []book.ArxivId       `json:"arxivs"`
+	Title           string               `json:"title,omitempty"`
+	Author          string               `json:"author,omitempty"`
+	Language        string               `json:"language,omitempty"`
+	Year            uint                 `json:"year,omitempty"`
+	Pages           uint                 `json:"pages,omitempty"`
+	Edition         []string             `json:"edition,omitempty"`
+	Responses       []providers.Response `json:"responses"`
+}
+
+// SetDebugOutput has a JSON file written to dir for every book that is searched, with the start of its text, the
+// identifiers found in it and the raw response to every request made for it
+func (bm *BookManager) SetDebugOutput(dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return fmt.Errorf("error: unable to create debug output directory %s: %s", dir, err.Error())
+	}
+	bm.debugDir = dir
+	return nil
+}
+
+// debugPath is where the dump of a book is written, named after the file and its path so that books with the same name
+// in different directories don't overwrite each other
+func (bm *BookManager) debugPath(filePath string) string {
+	sum := sha256.Sum256([]byte(filePath))
+	return filepath.Join(bm.debugDir, fmt.Sprintf("%s-%x.json", filepath.Base(filePath), sum[:4]))
+}
+
+func (bm *BookManager) writeDebug(job *bookJob, recorder *providers.Recorder) {
+	dump := debugDump{
+		Filepath:        job.book.Filepath,
+		Snippet:         job.snippet,
+		Isbn10s:         job.search.Isbn10s,
+		Isbn13s:         job.search.Isbn13s,
+		UnsearchedIsbns: job.book.UnsearchedIsbns,
+		Dois:            job.search.Dois,
+		Asins:           job.search.Asins,
+		Arxivs:          job.search.Arxivs,
+		Title:           job.search.Title,
+		Author:          job.search.Author,
+		Language:        job.search.Language,
+		Year:            job.search.Year,
+		Pages:           job.search.Pages,
+		Edition:         job.edition,
+		Responses:       recorder.Responses(),
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err == nil {
+		err = os.WriteFile(bm.debugPath(job.book.Filepath), data, 0644)
+	}
+	if err != nil {
+		slog.Warn("failed to write debug output", "path", job.book.Filepath, "error", err)
+	}
+}
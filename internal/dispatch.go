@@ -0,0 +1,38 @@
+package internal
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/pipeline"
+)
+
+// Dispatcher hands books to other machines, which process them from start to finish and answer with what they found
+type Dispatcher interface {
+	Dispatch(ctx context.Context, bk book.Book) (book.Book, error)
+}
+
+// SetDispatcher has every book processed through dispatcher instead of by this book manager
+func (bm *BookManager) SetDispatcher(dispatcher Dispatcher) {
+	bm.dispatcher = dispatcher
+}
+
+// dispatch processes a book elsewhere, which was read from a copy of the file there
+func (bm *BookManager) dispatch(ctx context.Context, bk book.Book) (any, error) {
+	bm.stats.Start(bk.Filepath)
+	result, err := bm.dispatcher.Dispatch(ctx, bk)
+	if err != nil {
+		return bk, err
+	}
+	result.Filepath = bk.Filepath
+	statBook(&result)
+	return pipeline.Complete(result), nil
+}
+
+// FinishLate writes out a book a dispatcher sent back after the book manager stopped waiting for it, in place of the
+// timeout it failed with
+func (bm *BookManager) FinishLate(filePath string, result book.Book) {
+	result.Filepath = filePath
+	statBook(&result)
+	bm.removeProcessedBook(filePath)
+	bm.finishBook(result)
+}
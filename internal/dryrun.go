@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"github.com/larkwiot/booker/internal/providers"
+	"maps"
+)
+
+// DryRunEntry is what a dry run found in a book and what it would have asked the providers about it
+type DryRunEntry struct {
+	Identifiers
+	// Queries are the lookups each provider would have been sent, by the name of the provider
+	Queries map[string][]string `json:"queries"`
+}
+
+// planSearch is the entry of a book that a dry run stops before searching, with the lookups the live providers would
+// have made for it. Offline, no provider would have been asked.
+func (bm *BookManager) planSearch(job *bookJob, ids Identifiers) DryRunEntry {
+	entry := DryRunEntry{Identifiers: ids, Queries: make(map[string][]string)}
+	if bm.offline || len(ids.ErrorMessage) > 0 {
+		return entry
+	}
+	for _, svc := range bm.providersManager.GetLiveServices() {
+		provider := svc.(providers.Provider)
+		if queries := provider.Queries(&job.search); len(queries) > 0 {
+			entry.Queries[provider.Name()] = queries
+		}
+	}
+	return entry
+}
+
+// recordDryRun adds the entry of a book to the dry run report
+func (bm *BookManager) recordDryRun(entry DryRunEntry) {
+	bm.bookStateLock.Lock()
+	defer bm.bookStateLock.Unlock()
+	bm.dryRunEntries[entry.Filepath] = entry
+}
+
+// DryRunReport is an entry for every book the last dry run got to, keyed by filepath like the output
+func (bm *BookManager) DryRunReport() map[string]DryRunEntry {
+	bm.bookStateLock.RLock()
+	defer bm.bookStateLock.RUnlock()
+	return maps.Clone(bm.dryRunEntries)
+}
@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/samber/lo"
+	"os"
+	"slices"
+	"strings"
+)
+
+type DuplicateGroup struct {
+	// Reason is "hash" for files with identical contents or "isbn13" for different files of the same work
+	Reason    string   `json:"reason"`
+	Key       string   `json:"key"`
+	Filepaths []string `json:"filepaths"`
+}
+
+// FindDuplicates groups processed books that are the same file or the same work
+func (bm *BookManager) FindDuplicates() []DuplicateGroup {
+	bm.bookStateLock.RLock()
+	defer bm.bookStateLock.RUnlock()
+
+	byHash := make(map[string][]book.Book)
+	byIsbn13 := make(map[string][]book.Book)
+	for _, bk := range bm.books {
+		if len(bk.Hash) > 0 {
+			byHash[bk.Hash] = append(byHash[bk.Hash], bk)
+		}
+		if len(bk.Isbn13) > 0 && len(bk.ErrorMessage) == 0 {
+			byIsbn13[string(bk.Isbn13)] = append(byIsbn13[string(bk.Isbn13)], bk)
+		}
+	}
+
+	groups := make([]DuplicateGroup, 0)
+	addGroups := func(reason string, grouped map[string][]book.Book) {
+		for key, books := range grouped {
+			if len(books) < 2 {
+				continue
+			}
+			// identical files are already reported by hash, so only report works that span different files
+			if reason == "isbn13" && len(lo.UniqBy(books, func(bk book.Book) string { return bk.Hash })) < 2 {
+				continue
+			}
+			filepaths := lo.Map(books, func(bk book.Book, _ int) string { return bk.Filepath })
+			slices.Sort(filepaths)
+			groups = append(groups, DuplicateGroup{Reason: reason, Key: key, Filepaths: filepaths})
+		}
+	}
+	addGroups("hash", byHash)
+	addGroups("isbn13", byIsbn13)
+
+	slices.SortFunc(groups, func(a, b DuplicateGroup) int {
+		if c := strings.Compare(a.Reason, b.Reason); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Filepaths[0], b.Filepaths[0])
+	})
+
+	return groups
+}
+
+func (bm *BookManager) WriteDuplicates(filePath string) error {
+	data, err := json.MarshalIndent(bm.FindDuplicates(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0666)
+}
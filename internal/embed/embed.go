@@ -0,0 +1,41 @@
+package embed
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"path/filepath"
+	"strings"
+)
+
+// Accepts reports whether metadata can be written into the file at filePath
+func Accepts(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".epub", ".pdf":
+		return true
+	default:
+		return false
+	}
+}
+
+// Metadata writes the title, authors, and ISBN of bk into the book file itself
+func Metadata(bk *book.Book) error {
+	if len(bk.Title) == 0 {
+		return fmt.Errorf("error: refusing to embed metadata without a title: %s", bk.Filepath)
+	}
+
+	switch strings.ToLower(filepath.Ext(bk.Filepath)) {
+	case ".epub":
+		return embedEpub(bk)
+	case ".pdf":
+		return embedPdf(bk)
+	default:
+		return fmt.Errorf("error: embedding metadata is not supported for %s", bk.Filepath)
+	}
+}
+
+func bookIsbn(bk *book.Book) string {
+	if len(bk.Isbn13) > 0 {
+		return string(bk.Isbn13)
+	}
+	return string(bk.Isbn10)
+}
@@ -0,0 +1,240 @@
+package embed_test
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/embed"
+	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/larkwiot/booker/internal/pdf"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const container = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// the Dublin Core prefix isn't always dc
+const epub2Package = `<?xml version="1.0"?>
+<package version="2.0" xmlns="http://www.idpf.org/2007/opf" unique-identifier="id">
+  <metadata xmlns:dcterms="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dcterms:title>Dun</dcterms:title>
+    <dcterms:creator opf:role="aut">F. Herbert</dcterms:creator>
+    <dcterms:identifier id="id" opf:scheme="ISBN">978-0-441-01359-3</dcterms:identifier>
+    <dcterms:publisher>Ace</dcterms:publisher>
+  </metadata>
+  <manifest><item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/></manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`
+
+const epub3Package = `<?xml version="1.0"?>
+<package version="3.0" xmlns="http://www.idpf.org/2007/opf" unique-identifier="id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title id="title">Dun</dc:title>
+    <meta refines="#title" property="title-type">main</meta>
+    <dc:creator id="creator1">F. Herbert</dc:creator>
+    <meta refines="#creator1" property="role" scheme="marc:relators">aut</meta>
+    <dc:identifier id="id">urn:uuid:0b6a1b4e-60ab-4b54-bd3a-4b4d3b0e7b4f</dc:identifier>
+    <meta refines="#id" property="identifier-type">uuid</meta>
+    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+  </metadata>
+  <manifest><item id="ch1" href="ch1.xhtml" media-type="application/xhtml+xml"/></manifest>
+  <spine><itemref idref="ch1"/></spine>
+</package>`
+
+// writeEpub writes an EPUB around opf, with its mimetype entry stored and first as the specification requires
+func writeEpub(t *testing.T, opf string) string {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o640)
+	assert.NoError(t, err)
+	defer out.Close()
+
+	writer := zip.NewWriter(out)
+	entry, err := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	assert.NoError(t, err)
+	io.WriteString(entry, "application/epub+zip")
+	for name, contents := range map[string]string{
+		"META-INF/container.xml": container,
+		"OEBPS/content.opf":      opf,
+		"OEBPS/ch1.xhtml":        "<html><body><p>A beginning is the time for taking the most delicate care.</p></body></html>",
+	} {
+		entry, err = writer.Create(name)
+		assert.NoError(t, err)
+		io.WriteString(entry, contents)
+	}
+	assert.NoError(t, writer.Close())
+	return path
+}
+
+// readPackage reads the package document back out of the EPUB at path, checking the mimetype entry is still first
+func readPackage(t *testing.T, path string) string {
+	archive, err := zip.OpenReader(path)
+	assert.NoError(t, err)
+	defer archive.Close()
+	assert.Equal(t, "mimetype", archive.File[0].Name)
+	assert.Equal(t, zip.Store, archive.File[0].Method)
+
+	fh, err := archive.Open("OEBPS/content.opf")
+	assert.NoError(t, err)
+	defer fh.Close()
+	data, err := io.ReadAll(fh)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestEmbedEpub(t *testing.T) {
+	tests := []struct {
+		name    string
+		opf     string
+		keep    []string
+		removed []string
+		isbns   int
+	}{
+		{
+			name:    "EPUB 2",
+			opf:     epub2Package,
+			keep:    []string{"<dcterms:title>Dune</dcterms:title>", "<dcterms:publisher>Ace</dcterms:publisher>"},
+			removed: []string{"F. Herbert", "<dc:"},
+			// already there, hyphenated
+			isbns: 1,
+		},
+		{
+			name: "EPUB 3",
+			opf:  epub3Package,
+			keep: []string{"<dc:title>Dune</dc:title>", `refines="#id"`, "dcterms:modified"},
+			// the refinements of the old title and creator go with them
+			removed: []string{"F. Herbert", `refines="#title"`, `refines="#creator1"`},
+			isbns:   1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeEpub(t, test.opf)
+			bk := &book.Book{Filepath: path, Title: "Dune", Authors: []string{"Frank Herbert", "Brian Herbert"}, Isbn13: "9780441013593"}
+			assert.NoError(t, embed.Metadata(bk))
+
+			opf := readPackage(t, path)
+			for _, want := range test.keep {
+				assert.Contains(t, opf, want)
+			}
+			for _, unwanted := range test.removed {
+				assert.NotContains(t, opf, unwanted)
+			}
+			assert.Equal(t, test.isbns, strings.Count(strings.ReplaceAll(opf, "-", ""), "9780441013593"))
+
+			info, err := os.Stat(path)
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0o640), info.Mode().Perm())
+			entries, err := os.ReadDir(filepath.Dir(path))
+			assert.NoError(t, err)
+			assert.Len(t, entries, 1)
+
+			// and it still reads as an EPUB
+			result, err := extractors.NewEpubExtractor().ExtractMetadata(context.Background(), &book.Book{Filepath: path})
+			assert.NoError(t, err)
+			assert.Equal(t, "Dune", result.Title.OrEmpty())
+			assert.Equal(t, []string{"Frank Herbert", "Brian Herbert"}, result.Authors.OrEmpty())
+			assert.Equal(t, book.ISBN13("9780441013593"), result.Isbn13.OrEmpty())
+		})
+	}
+
+	// a package without the Dublin Core namespace is left alone
+	path := writeEpub(t, strings.ReplaceAll(epub3Package, "http://purl.org/dc/elements/1.1/", "urn:other"))
+	err := embed.Metadata(&book.Book{Filepath: path, Title: "Dune"})
+	assert.ErrorContains(t, err, "package document does not declare the Dublin Core namespace")
+	assert.Contains(t, readPackage(t, path), "<dc:title id=\"title\">Dun</dc:title>")
+}
+
+var pdfObjects = []string{
+	"<< /Type /Catalog /Pages 2 0 R >>",
+	"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+	"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] >>",
+	"<< /Title (Dun) /Producer (test) >>",
+}
+
+// writePdf writes pdfObjects numbered from 1 with a cross-reference table, or a cross-reference stream if xrefStream
+func writePdf(t *testing.T, xrefStream bool) string {
+	var data strings.Builder
+	data.WriteString("%PDF-1.5\n")
+	offsets := make([]int, 0)
+	for idx, object := range pdfObjects {
+		offsets = append(offsets, data.Len())
+		data.WriteString(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", idx+1, object))
+	}
+	xref := data.Len()
+	size := len(pdfObjects) + 1
+	if !xrefStream {
+		data.WriteString(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", size))
+		for _, offset := range offsets {
+			data.WriteString(fmt.Sprintf("%010d 00000 n \n", offset))
+		}
+		data.WriteString(fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\n", size))
+	} else {
+		// the stream is its own last object, with entries of a type byte, a 4 byte offset and a 2 byte generation
+		offsets = append(offsets, xref)
+		size++
+		entries := []byte{0, 0, 0, 0, 0, 0xff, 0xff}
+		for _, offset := range offsets {
+			entries = append(entries, 1, byte(offset>>24), byte(offset>>16), byte(offset>>8), byte(offset), 0, 0)
+		}
+		data.WriteString(fmt.Sprintf("%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] /Root 1 0 R /Info 4 0 R /Length %d >>\nstream\n", size-1, size, len(entries)))
+		data.Write(entries)
+		data.WriteString("\nendstream\nendobj\n")
+	}
+	data.WriteString(fmt.Sprintf("startxref\n%d\n%%%%EOF\n", xref))
+
+	path := filepath.Join(t.TempDir(), "book.pdf")
+	assert.NoError(t, os.WriteFile(path, []byte(data.String()), 0o644))
+	return path
+}
+
+func TestEmbedPdf(t *testing.T) {
+	for name, xrefStream := range map[string]bool{"cross-reference table": false, "cross-reference stream": true} {
+		t.Run(name, func(t *testing.T) {
+			path := writePdf(t, xrefStream)
+			bk := &book.Book{Filepath: path, Title: "Dune", Authors: []string{"Frank Herbert", "Brian Herbert"}, Isbn13: "9780441013593"}
+			assert.NoError(t, embed.Metadata(bk))
+
+			// the update is appended, cross-referenced the same way as the rest of the file
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			if xrefStream {
+				assert.Equal(t, 2, strings.Count(string(data), "/Type /XRef"))
+			} else {
+				assert.Equal(t, 2, strings.Count(string(data), "\nxref\n"))
+			}
+
+			file, err := pdf.Open(path)
+			assert.NoError(t, err)
+			defer file.Close()
+			info := file.Info()
+			assert.Equal(t, "Dune", info["Title"])
+			assert.Equal(t, "Frank Herbert, Brian Herbert", info["Author"])
+			assert.Equal(t, "9780441013593", info["ISBN"])
+			// what was there before is kept
+			assert.Equal(t, "test", info["Producer"])
+
+			metadata, err := file.Metadata()
+			assert.NoError(t, err)
+			assert.Contains(t, string(metadata), `<rdf:li xml:lang="x-default">Dune</rdf:li>`)
+			assert.Contains(t, string(metadata), "<rdf:li>Frank Herbert</rdf:li><rdf:li>Brian Herbert</rdf:li>")
+			assert.Contains(t, string(metadata), "<rdf:value>9780441013593</rdf:value>")
+
+			count, err := file.PageCount()
+			assert.NoError(t, err)
+			assert.Equal(t, 1, count)
+
+			// and the PDF extractor reads it back the same way
+			result, err := extractors.NewPdfMetadataExtractor().ExtractMetadata(context.Background(), &book.Book{Filepath: path})
+			assert.NoError(t, err)
+			assert.Equal(t, "Dune", result.Title.OrEmpty())
+		})
+	}
+}
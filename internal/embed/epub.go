@@ -0,0 +1,165 @@
+package embed
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+var dcNamespacePattern = regexp.MustCompile(`xmlns:([A-Za-z_][\w.-]*)\s*=\s*["']http://purl.org/dc/elements/1.1/["']`)
+var metadataEndPattern = regexp.MustCompile(`</(?:[A-Za-z_][\w.-]*:)?metadata\s*>`)
+var idAttributePattern = regexp.MustCompile(`\bid\s*=\s*["']([^"']+)["']`)
+
+func xmlEscape(s string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
+
+// updatePackage replaces the titles and creators in an OPF package document and adds the ISBN if it is missing
+func updatePackage(opf string, bk *book.Book) (string, error) {
+	match := dcNamespacePattern.FindStringSubmatch(opf)
+	if match == nil {
+		return "", fmt.Errorf("package document does not declare the Dublin Core namespace")
+	}
+	dc := match[1]
+
+	end := metadataEndPattern.FindStringIndex(opf)
+	if end == nil {
+		return "", fmt.Errorf("package document has no metadata")
+	}
+	metadata, rest := opf[:end[0]], opf[end[0]:]
+
+	// EPUB 3 refines elements by id, so refinements of removed elements have to go too
+	removedIds := make([]string, 0)
+	for _, element := range []string{"title", "creator"} {
+		pattern := regexp.MustCompile(fmt.Sprintf(`(?s)<%s:%s\b[^>]*?(?:/>|>.*?</%s:%s\s*>)\s*`, dc, element, dc, element))
+		metadata = pattern.ReplaceAllStringFunc(metadata, func(removed string) string {
+			if id := idAttributePattern.FindStringSubmatch(removed[:strings.IndexByte(removed, '>')]); id != nil {
+				removedIds = append(removedIds, id[1])
+			}
+			return ""
+		})
+	}
+	for _, id := range removedIds {
+		pattern := regexp.MustCompile(fmt.Sprintf(`(?s)<meta\b[^>]*\brefines\s*=\s*["']#%s["'][^>]*?(?:/>|>.*?</meta\s*>)\s*`, regexp.QuoteMeta(id)))
+		metadata = pattern.ReplaceAllString(metadata, "")
+	}
+
+	added := strings.Builder{}
+	added.WriteString(fmt.Sprintf("  <%s:title>%s</%s:title>\n", dc, xmlEscape(bk.Title), dc))
+	for _, author := range bk.Authors {
+		added.WriteString(fmt.Sprintf("  <%s:creator>%s</%s:creator>\n", dc, xmlEscape(author), dc))
+	}
+	// identifiers are often hyphenated, which shouldn't make the ISBN look missing
+	unhyphenated := strings.NewReplacer("-", "", " ", "").Replace(metadata)
+	if isbn := bookIsbn(bk); len(isbn) > 0 && !strings.Contains(unhyphenated, isbn) {
+		added.WriteString(fmt.Sprintf("  <%s:identifier>urn:isbn:%s</%s:identifier>\n", dc, isbn, dc))
+	}
+
+	return metadata + added.String() + rest, nil
+}
+
+func embedEpub(bk *book.Book) error {
+	archive, err := zip.OpenReader(bk.Filepath)
+	if err != nil {
+		return fmt.Errorf("error: epub unable to open file: %s: %s", bk.Filepath, err.Error())
+	}
+	defer archive.Close()
+
+	containerData, err := readZipFile(archive, "META-INF/container.xml")
+	if err != nil {
+		return fmt.Errorf("error: epub has no container: %s: %s", bk.Filepath, err.Error())
+	}
+
+	var container epubContainer
+	err = xml.Unmarshal(containerData, &container)
+	if err != nil || len(container.Rootfiles) == 0 {
+		return fmt.Errorf("error: epub container lists no package document: %s", bk.Filepath)
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	opfData, err := readZipFile(archive, opfPath)
+	if err != nil {
+		return fmt.Errorf("error: epub unable to read package document %s: %s: %s", opfPath, bk.Filepath, err.Error())
+	}
+
+	opf, err := updatePackage(string(opfData), bk)
+	if err != nil {
+		return fmt.Errorf("error: epub unable to update package document %s: %s: %s", opfPath, bk.Filepath, err.Error())
+	}
+
+	info, err := os.Stat(bk.Filepath)
+	if err != nil {
+		return err
+	}
+
+	// the archive is rewritten next to the original and swapped in, so a failure never leaves a broken book behind
+	temp, err := os.CreateTemp(filepath.Dir(bk.Filepath), ".booker-*.epub")
+	if err != nil {
+		return fmt.Errorf("error: epub unable to create temporary file for %s: %s", bk.Filepath, err.Error())
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	writer := zip.NewWriter(temp)
+	for _, file := range archive.File {
+		if file.Name != opfPath {
+			// copied without recompressing, which also keeps the mimetype entry stored and first
+			err = writer.Copy(file)
+			if err != nil {
+				return fmt.Errorf("error: epub unable to copy %s: %s: %s", file.Name, bk.Filepath, err.Error())
+			}
+			continue
+		}
+
+		header := file.FileHeader
+		header.Method = zip.Deflate
+		header.Extra = nil
+		entry, err := writer.CreateHeader(&header)
+		if err != nil {
+			return fmt.Errorf("error: epub unable to write package document: %s: %s", bk.Filepath, err.Error())
+		}
+		_, err = io.WriteString(entry, opf)
+		if err != nil {
+			return fmt.Errorf("error: epub unable to write package document: %s: %s", bk.Filepath, err.Error())
+		}
+	}
+
+	err = writer.Close()
+	if err == nil {
+		err = temp.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("error: epub unable to finish writing %s: %s", bk.Filepath, err.Error())
+	}
+	temp.Close()
+	// Windows won't replace a file that is still open
+	archive.Close()
+
+	return os.Rename(temp.Name(), bk.Filepath)
+}
+
+func readZipFile(archive *zip.ReadCloser, name string) ([]byte, error) {
+	fh, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return io.ReadAll(fh)
+}
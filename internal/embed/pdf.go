@@ -0,0 +1,116 @@
+package embed
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/pdf"
+	"strings"
+	"time"
+)
+
+const xmpTemplate = `<?xpacket begin="` + "\uFEFF" + `" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:xmpidq="http://ns.adobe.com/xmp/Identifier/qual/1.0/"
+    xmlns:pdf="http://ns.adobe.com/pdf/1.3/">
+%s  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// buildXmp renders the metadata packet that readers like Calibre prefer over the Info dictionary
+func buildXmp(bk *book.Book, modified time.Time) string {
+	properties := strings.Builder{}
+	properties.WriteString(fmt.Sprintf("   <dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", xmlEscape(bk.Title)))
+	if len(bk.Authors) > 0 {
+		properties.WriteString("   <dc:creator><rdf:Seq>")
+		for _, author := range bk.Authors {
+			properties.WriteString(fmt.Sprintf("<rdf:li>%s</rdf:li>", xmlEscape(author)))
+		}
+		properties.WriteString("</rdf:Seq></dc:creator>\n")
+	}
+	if len(bk.Publisher) > 0 {
+		properties.WriteString(fmt.Sprintf("   <dc:publisher><rdf:Bag><rdf:li>%s</rdf:li></rdf:Bag></dc:publisher>\n", xmlEscape(bk.Publisher)))
+	}
+	if isbn := bookIsbn(bk); len(isbn) > 0 {
+		properties.WriteString(fmt.Sprintf("   <xmp:Identifier><rdf:Bag><rdf:li rdf:parseType=\"Resource\"><xmpidq:Scheme>isbn</xmpidq:Scheme><rdf:value>%s</rdf:value></rdf:li></rdf:Bag></xmp:Identifier>\n", isbn))
+	}
+	properties.WriteString(fmt.Sprintf("   <xmp:MetadataDate>%s</xmp:MetadataDate>\n", modified.Format(time.RFC3339)))
+	properties.WriteString(fmt.Sprintf("   <xmp:ModifyDate>%s</xmp:ModifyDate>\n", modified.Format(time.RFC3339)))
+	return fmt.Sprintf(xmpTemplate, properties.String())
+}
+
+func pdfDate(t time.Time) string {
+	_, offset := t.Zone()
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("(D:%s%s%02d'%02d')", t.Format("20060102150405"), sign, offset/3600, offset%3600/60)
+}
+
+// embedPdf updates the Info dictionary and replaces the XMP metadata with an incremental update
+func embedPdf(bk *book.Book) error {
+	file, err := pdf.Open(bk.Filepath)
+	if err != nil {
+		return fmt.Errorf("error: pdf unable to read %s: %s", bk.Filepath, err.Error())
+	}
+	defer file.Close()
+
+	if file.IsEncrypted() {
+		return fmt.Errorf("error: pdf is encrypted, refusing to embed metadata: %s", bk.Filepath)
+	}
+
+	rootRef, exists := file.Trailer().GetRef("Root")
+	if !exists {
+		return fmt.Errorf("error: pdf has no document catalog: %s", bk.Filepath)
+	}
+	root, err := file.Object(rootRef)
+	if err != nil || root.Dict == nil {
+		return fmt.Errorf("error: pdf unable to read document catalog: %s", bk.Filepath)
+	}
+
+	update := file.NewUpdate()
+	now := time.Now()
+
+	info := pdf.NewDict()
+	infoRef, exists := file.Trailer().GetRef("Info")
+	if exists {
+		existing, err := file.Object(infoRef)
+		if err == nil && existing.Dict != nil {
+			info = existing.Dict.Clone()
+		}
+	} else {
+		infoRef = update.NewRef()
+		update.Trailer().Set("Info", infoRef.String())
+	}
+	info.Set("Title", pdf.EncodeString(bk.Title))
+	if len(bk.Authors) > 0 {
+		info.Set("Author", pdf.EncodeString(strings.Join(bk.Authors, ", ")))
+	}
+	if isbn := bookIsbn(bk); len(isbn) > 0 {
+		info.Set("ISBN", pdf.EncodeString(isbn))
+	}
+	info.Set("ModDate", pdfDate(now))
+	update.SetObject(infoRef, info.String())
+
+	metadata := pdf.NewDict()
+	metadata.Set("Type", "/Metadata")
+	metadata.Set("Subtype", "/XML")
+	metadataRef := update.NewRef()
+	update.SetStream(metadataRef, metadata, []byte(buildXmp(bk, now)))
+
+	catalog := root.Dict.Clone()
+	catalog.Set("Metadata", metadataRef.String())
+	update.SetObject(rootRef, catalog.String())
+
+	err = update.Write()
+	if err != nil {
+		return fmt.Errorf("error: pdf unable to write metadata to %s: %s", bk.Filepath, err.Error())
+	}
+	return nil
+}
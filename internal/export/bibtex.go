@@ -0,0 +1,179 @@
+package export
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"golang.org/x/text/unicode/norm"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var bibtexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`,
+	"}", `\}`,
+	"&", `\&`,
+	"%", `\%`,
+	"$", `\$`,
+	"#", `\#`,
+	"_", `\_`,
+	"~", `\textasciitilde{}`,
+	"^", `\textasciicircum{}`,
+)
+
+// words skipped when picking the title word of a citation key
+var bibtexStopWords = map[string]struct{}{
+	"a": {}, "an": {}, "the": {}, "of": {}, "on": {}, "in": {}, "and": {}, "to": {}, "for": {},
+}
+
+// Bibtex writes an @book entry for every book, with keys like "knuth1997art"
+type Bibtex struct {
+	keys map[string]struct{}
+}
+
+func NewBibtex() *Bibtex {
+	return &Bibtex{keys: make(map[string]struct{})}
+}
+
+func bibtexEscape(s string) string {
+	return bibtexEscaper.Replace(s)
+}
+
+// keyPart folds s down to lowercase ASCII letters and digits, so "Gödel" becomes "godel"
+func keyPart(s string) string {
+	var part strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			part.WriteRune(r)
+		}
+	}
+	return part.String()
+}
+
+// lastName guesses the family name of an author written either "First Last" or "Last, First"
+func lastName(author string) string {
+	if before, _, found := strings.Cut(author, ","); found {
+		return before
+	}
+	names := strings.Fields(author)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[len(names)-1]
+}
+
+func (b *Bibtex) citationKey(bk *book.Book) string {
+	var key strings.Builder
+	if len(bk.Authors) > 0 {
+		key.WriteString(keyPart(lastName(bk.Authors[0])))
+	}
+	key.WriteString(year(bk))
+	for _, word := range strings.Fields(bk.Title) {
+		part := keyPart(word)
+		if _, isStopWord := bibtexStopWords[part]; isStopWord || len(part) == 0 {
+			continue
+		}
+		key.WriteString(part)
+		break
+	}
+
+	base := key.String()
+	if len(base) == 0 {
+		base = "book"
+	}
+
+	// later books with the same key get a letter appended, like "knuth1997arta"
+	unique := base
+	for suffix := 0; ; suffix++ {
+		if _, taken := b.keys[unique]; !taken {
+			break
+		}
+		unique = base + bibtexSuffix(suffix)
+	}
+	b.keys[unique] = struct{}{}
+	return unique
+}
+
+// bibtexSuffix returns "a" to "z", then "aa", "ab" and so on
+func bibtexSuffix(n int) string {
+	suffix := ""
+	for n++; n > 0; n = (n - 1) / 26 {
+		suffix = string(rune('a'+(n-1)%26)) + suffix
+	}
+	return suffix
+}
+
+// bibtexNames joins the names of authors or editors the way BibTeX splits them
+func bibtexNames(names []string) string {
+	escaped := make([]string, 0, len(names))
+	for _, name := range names {
+		name = bibtexEscape(name)
+		// an "and" inside a name would otherwise split it into two people
+		if strings.Contains(strings.ToLower(name), " and ") {
+			name = "{" + name + "}"
+		}
+		escaped = append(escaped, name)
+	}
+	return strings.Join(escaped, " and ")
+}
+
+func (b *Bibtex) Header() string {
+	return ""
+}
+
+func (b *Bibtex) Record(bk *book.Book) (string, error) {
+	fields := make([][2]string, 0)
+	add := func(name string, value string) {
+		if len(value) > 0 {
+			fields = append(fields, [2]string{name, bibtexEscape(value)})
+		}
+	}
+
+	add("title", bk.Title)
+	add("edition", bk.Edition)
+	add("year", year(bk))
+	add("publisher", bk.Publisher)
+	add("series", bk.Series)
+	if len(bk.Series) > 0 && bk.SeriesIndex > 0 {
+		add("number", strconv.FormatFloat(bk.SeriesIndex, 'f', -1, 64))
+	}
+	if len(bk.Isbn13) > 0 {
+		add("isbn", string(bk.Isbn13))
+	} else {
+		add("isbn", string(bk.Isbn10))
+	}
+	add("doi", string(bk.Doi))
+	if len(bk.Arxiv) > 0 {
+		add("eprint", string(bk.Arxiv))
+		add("archiveprefix", "arXiv")
+	}
+	add("language", bk.Language)
+	add("keywords", strings.Join(bk.Subjects, ", "))
+	add("abstract", bk.Description)
+	if bk.Pages > 0 {
+		add("pagetotal", fmt.Sprint(bk.Pages))
+	}
+	if len(bk.Filepath) > 0 {
+		// reference managers read the path as is
+		fields = append(fields, [2]string{"file", bk.Filepath})
+	}
+
+	var entry strings.Builder
+	entry.WriteString(fmt.Sprintf("@book{%s,\n", b.citationKey(bk)))
+	if len(bk.Authors) > 0 {
+		entry.WriteString(fmt.Sprintf("  author = {%s},\n", bibtexNames(bk.Authors)))
+	}
+	if len(bk.Editors) > 0 {
+		entry.WriteString(fmt.Sprintf("  editor = {%s},\n", bibtexNames(bk.Editors)))
+	}
+	for _, field := range fields {
+		entry.WriteString(fmt.Sprintf("  %s = {%s},\n", field[0], field[1]))
+	}
+	entry.WriteString("}\n\n")
+	return entry.String(), nil
+}
+
+func (b *Bibtex) Footer() string {
+	return ""
+}
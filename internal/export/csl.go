@@ -0,0 +1,135 @@
+package export
+
+import (
+	"encoding/json"
+	"github.com/larkwiot/booker/internal/book"
+	"strconv"
+	"strings"
+)
+
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+type cslItem struct {
+	Id               string    `json:"id"`
+	Type             string    `json:"type"`
+	Title            string    `json:"title"`
+	Author           []cslName `json:"author,omitempty"`
+	Editor           []cslName `json:"editor,omitempty"`
+	Issued           *cslDate  `json:"issued,omitempty"`
+	Publisher        string    `json:"publisher,omitempty"`
+	Edition          string    `json:"edition,omitempty"`
+	CollectionTitle  string    `json:"collection-title,omitempty"`
+	CollectionNumber string    `json:"collection-number,omitempty"`
+	NumberOfPages    string    `json:"number-of-pages,omitempty"`
+	Isbn             string    `json:"ISBN,omitempty"`
+	Doi              string    `json:"DOI,omitempty"`
+	CallNumber       string    `json:"call-number,omitempty"`
+	Language         string    `json:"language,omitempty"`
+	Keyword          string    `json:"keyword,omitempty"`
+	Abstract         string    `json:"abstract,omitempty"`
+}
+
+// cslAuthor splits an author written either "First Last" or "Last, First" into their family and given names. Names
+// that can't be split, like a single name or an organization, are kept whole.
+func cslAuthor(author string) cslName {
+	if family, given, found := strings.Cut(author, ","); found {
+		return cslName{Family: strings.TrimSpace(family), Given: strings.TrimSpace(given)}
+	}
+	names := strings.Fields(author)
+	if len(names) < 2 || strings.Contains(strings.ToLower(author), " and ") {
+		return cslName{Literal: author}
+	}
+	return cslName{Family: names[len(names)-1], Given: strings.Join(names[:len(names)-1], " ")}
+}
+
+// cslIssued returns the publication date down to the month or day if it is known
+func cslIssued(bk *book.Book) *cslDate {
+	parts := make([]int, 0, 3)
+	for _, part := range strings.Split(bk.PublishDate, "-") {
+		value, err := strconv.Atoi(part)
+		if err != nil || len(parts) == 3 {
+			break
+		}
+		parts = append(parts, value)
+	}
+	if len(parts) == 0 || parts[0] == 0 {
+		if bk.LowYear == 0 {
+			return nil
+		}
+		parts = []int{int(bk.LowYear)}
+	}
+	return &cslDate{DateParts: [][]int{parts}}
+}
+
+// Csl writes a CSL-JSON array of books, which Zotero and other reference managers import. Items are given the same
+// ids as the citation keys of Bibtex.
+type Csl struct {
+	keys    *Bibtex
+	written bool
+}
+
+func NewCsl() *Csl {
+	return &Csl{keys: NewBibtex()}
+}
+
+func (c *Csl) Header() string {
+	return "[\n"
+}
+
+func (c *Csl) Record(bk *book.Book) (string, error) {
+	item := cslItem{
+		Type:            "book",
+		Title:           bk.Title,
+		Issued:          cslIssued(bk),
+		Publisher:       bk.Publisher,
+		Edition:         bk.Edition,
+		CollectionTitle: bk.Series,
+		Doi:             string(bk.Doi),
+		CallNumber:      bk.Lcc,
+		Language:        bk.Language,
+		Keyword:         strings.Join(bk.Subjects, ", "),
+		Abstract:        bk.Description,
+	}
+	for _, author := range bk.Authors {
+		item.Author = append(item.Author, cslAuthor(author))
+	}
+	for _, editor := range bk.Editors {
+		item.Editor = append(item.Editor, cslAuthor(editor))
+	}
+	if len(bk.Series) > 0 && bk.SeriesIndex > 0 {
+		item.CollectionNumber = strconv.FormatFloat(bk.SeriesIndex, 'f', -1, 64)
+	}
+	if bk.Pages > 0 {
+		item.NumberOfPages = strconv.FormatUint(uint64(bk.Pages), 10)
+	}
+	if len(bk.Isbn13) > 0 {
+		item.Isbn = string(bk.Isbn13)
+	} else {
+		item.Isbn = string(bk.Isbn10)
+	}
+	item.Id = c.keys.citationKey(bk)
+
+	data, err := json.MarshalIndent(item, "  ", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	record := "  " + string(data)
+	if c.written {
+		record = ",\n" + record
+	}
+	c.written = true
+	return record, nil
+}
+
+func (c *Csl) Footer() string {
+	return "\n]\n"
+}
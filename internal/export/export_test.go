@@ -0,0 +1,266 @@
+package export_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/export"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBibtex(t *testing.T) {
+	bibtex := export.NewBibtex()
+
+	bk := book.Book{
+		Title:       "The Art of Computer Programming",
+		Authors:     []string{"Donald E. Knuth"},
+		Isbn13:      "9780201896831",
+		PublishDate: "1997-07-17",
+		Publisher:   "Addison-Wesley",
+		Filepath:    "/books/taocp.pdf",
+	}
+	record, err := bibtex.Record(&bk)
+	assert.NoError(t, err)
+	assert.Equal(t, `@book{knuth1997art,
+  author = {Donald E. Knuth},
+  title = {The Art of Computer Programming},
+  year = {1997},
+  publisher = {Addison-Wesley},
+  isbn = {9780201896831},
+  file = {/books/taocp.pdf},
+}
+
+`, record)
+
+	// the same key again gets a letter
+	record, err = bibtex.Record(&bk)
+	assert.NoError(t, err)
+	assert.Contains(t, record, "@book{knuth1997arta,")
+
+	bk = book.Book{
+		Title:    "Profit & Loss: 100% of $5_000",
+		Authors:  []string{"Gödel, Kurt", "Simon and Schuster"},
+		LowYear:  1931,
+		Filepath: "/books/pl.epub",
+	}
+	record, err = bibtex.Record(&bk)
+	assert.NoError(t, err)
+	assert.Contains(t, record, "@book{godel1931profit,")
+	assert.Contains(t, record, `author = {Gödel, Kurt and {Simon and Schuster}},`)
+	assert.Contains(t, record, `title = {Profit \& Loss: 100\% of \$5\_000},`)
+
+	record, err = bibtex.Record(&book.Book{Title: "The"})
+	assert.NoError(t, err)
+	assert.Contains(t, record, "@book{book,")
+}
+
+func TestCsl(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "books.json")
+	writer, err := export.NewWriter(output, util.NoCompression, export.NewCsl())
+	assert.NoError(t, err)
+
+	writer.WriteObject(&book.Book{
+		Title:       "The Art of Computer Programming",
+		Authors:     []string{"Donald E. Knuth", "Gödel, Kurt", "Simon and Schuster"},
+		Isbn13:      "9780201896831",
+		PublishDate: "1997-07",
+		Pages:       672,
+		Series:      "TAOCP",
+		SeriesIndex: 1,
+		Filepath:    "/books/taocp.pdf",
+	})
+	writer.WriteObject(&book.Book{Filepath: "/books/failed.pdf", ErrorMessage: "no results"})
+	writer.WriteObject(&book.Book{Title: "Dune", LowYear: 1965, Doi: "10.1000/dune", Filepath: "/books/dune.epub"})
+	writer.Close()
+
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	var items []map[string]any
+	assert.NoError(t, json.Unmarshal(data, &items))
+	assert.Len(t, items, 2)
+
+	assert.Equal(t, "knuth1997art", items[0]["id"])
+	assert.Equal(t, "book", items[0]["type"])
+	assert.Equal(t, []any{
+		map[string]any{"family": "Knuth", "given": "Donald E."},
+		map[string]any{"family": "Gödel", "given": "Kurt"},
+		map[string]any{"literal": "Simon and Schuster"},
+	}, items[0]["author"])
+	assert.Equal(t, map[string]any{"date-parts": []any{[]any{1997.0, 7.0}}}, items[0]["issued"])
+	assert.Equal(t, "9780201896831", items[0]["ISBN"])
+	assert.Equal(t, "672", items[0]["number-of-pages"])
+	assert.Equal(t, "1", items[0]["collection-number"])
+
+	assert.Equal(t, "1965dune", items[1]["id"])
+	assert.Equal(t, "10.1000/dune", items[1]["DOI"])
+	assert.Equal(t, map[string]any{"date-parts": []any{[]any{1965.0}}}, items[1]["issued"])
+	assert.NotContains(t, items[1], "author")
+}
+
+func TestWriter(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "books.bib")
+	writer, err := export.NewWriter(output, util.NoCompression, export.NewBibtex())
+	assert.NoError(t, err)
+
+	writer.WriteObject(&book.Book{Title: "Dune", Authors: []string{"Frank Herbert"}, PublishDate: "1965", Filepath: "/books/dune.epub"})
+	writer.WriteObject(&book.Book{Filepath: "/books/failed.pdf", ErrorMessage: "no results"})
+	writer.Close()
+
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "@book{herbert1965dune,\n  author = {Frank Herbert},\n  title = {Dune},\n  year = {1965},\n  file = {/books/dune.epub},\n}\n\n", string(data))
+}
+
+func TestMarc(t *testing.T) {
+	marc := export.NewMarc(false)
+	marc.Created = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	bk := book.Book{
+		Title:       "The Art of Computer Programming",
+		Authors:     []string{"Donald E. Knuth", "Ann Editor"},
+		Isbn13:      "9780201896831",
+		Oclc:        "36241708",
+		PublishDate: "1997-07-17",
+		Publisher:   "Addison-Wesley",
+		Hash:        "abc",
+		Filepath:    "/books/taocp.pdf",
+	}
+	record, err := marc.Record(&bk)
+	assert.NoError(t, err)
+
+	assert.Equal(t, fmt.Sprintf("%05d", len(record)), record[:5])
+	assert.Equal(t, "nam a22", record[5:12])
+	assert.Equal(t, byte(0x1d), record[len(record)-1])
+
+	baseAddress, err := strconv.Atoi(record[12:17])
+	assert.NoError(t, err)
+	fields := make(map[string][]string)
+	for entry := record[24 : baseAddress-1]; len(entry) >= 12; entry = entry[12:] {
+		length, _ := strconv.Atoi(entry[3:7])
+		start, _ := strconv.Atoi(entry[7:12])
+		value := record[baseAddress+start : baseAddress+start+length]
+		assert.Equal(t, byte(0x1e), value[len(value)-1])
+		fields[entry[:3]] = append(fields[entry[:3]], value[:len(value)-1])
+	}
+
+	assert.Equal(t, []string{"abc"}, fields["001"])
+	assert.Equal(t, []string{"20240301123000.0"}, fields["005"])
+	assert.Len(t, fields["008"][0], 40)
+	assert.Equal(t, "240301s1997", fields["008"][0][:11])
+	assert.Equal(t, "und", fields["008"][0][35:38])
+	assert.Equal(t, []string{"  \x1fa9780201896831"}, fields["020"])
+	assert.Equal(t, []string{"  \x1fa(OCoLC)36241708"}, fields["035"])
+	assert.Equal(t, []string{"1 \x1faKnuth, Donald E."}, fields["100"])
+	assert.Equal(t, []string{"14\x1faThe Art of Computer Programming"}, fields["245"])
+	assert.Equal(t, []string{" 1\x1fbAddison-Wesley\x1fc1997"}, fields["264"])
+	assert.Equal(t, []string{"1 \x1faEditor, Ann"}, fields["700"])
+	assert.Equal(t, []string{"4 \x1fufile:///books/taocp.pdf"}, fields["856"])
+}
+
+func TestMarcXml(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "books.xml")
+	writer, err := export.NewWriter(output, util.NoCompression, export.NewMarc(true))
+	assert.NoError(t, err)
+	writer.WriteObject(&book.Book{Title: "Dune & Sons", Authors: []string{"Frank Herbert"}, PublishDate: "1965", Filepath: "/books/dune.epub"})
+	writer.Close()
+
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+
+	var collection struct {
+		XMLName xml.Name `xml:"http://www.loc.gov/MARC21/slim collection"`
+		Records []struct {
+			Leader    string `xml:"leader"`
+			Datafield []struct {
+				Tag       string `xml:"tag,attr"`
+				Ind1      string `xml:"ind1,attr"`
+				Subfields []struct {
+					Code  string `xml:"code,attr"`
+					Value string `xml:",chardata"`
+				} `xml:"subfield"`
+			} `xml:"datafield"`
+		} `xml:"record"`
+	}
+	assert.NoError(t, xml.Unmarshal(data, &collection))
+	assert.Len(t, collection.Records, 1)
+	assert.Len(t, collection.Records[0].Leader, 24)
+
+	titles := make([]string, 0)
+	for _, field := range collection.Records[0].Datafield {
+		if field.Tag == "245" {
+			titles = append(titles, field.Subfields[0].Value)
+			assert.Equal(t, "1", field.Ind1)
+		}
+	}
+	assert.Equal(t, []string{"Dune & Sons"}, titles)
+}
+
+func TestOnix(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "books.xml")
+	onix := export.NewOnix()
+	onix.Sent = time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	writer, err := export.NewWriter(output, util.NoCompression, onix)
+	assert.NoError(t, err)
+	writer.WriteObject(&book.Book{
+		Title:       "Dune",
+		Authors:     []string{"Frank Herbert"},
+		Isbn13:      "9780441013593",
+		Isbn10:      "0441013597",
+		PublishDate: "1965-08",
+		Publisher:   "Chilton",
+		Hash:        "abc",
+		Filepath:    "/books/dune.epub",
+	})
+	// without an identifier there is no way to describe the product
+	writer.WriteObject(&book.Book{Title: "Untitled", Filepath: "/books/untitled.pdf"})
+	writer.Close()
+
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+
+	var message struct {
+		XMLName      xml.Name `xml:"http://ns.editeur.org/onix/3.0/reference ONIXMessage"`
+		Release      string   `xml:"release,attr"`
+		SentDateTime string   `xml:"Header>SentDateTime"`
+		Products     []struct {
+			RecordReference    string `xml:"RecordReference"`
+			ProductIdentifiers []struct {
+				ProductIDType string `xml:"ProductIDType"`
+				IDValue       string `xml:"IDValue"`
+			} `xml:"ProductIdentifier"`
+			ProductFormDetail string `xml:"DescriptiveDetail>ProductFormDetail"`
+			TitleText         string `xml:"DescriptiveDetail>TitleDetail>TitleElement>TitleText"`
+			PersonName        string `xml:"DescriptiveDetail>Contributor>PersonName"`
+			PublisherName     string `xml:"PublishingDetail>Publisher>PublisherName"`
+			Date              struct {
+				Format string `xml:"dateformat,attr"`
+				Value  string `xml:",chardata"`
+			} `xml:"PublishingDetail>PublishingDate>Date"`
+		} `xml:"Product"`
+	}
+	assert.NoError(t, xml.Unmarshal(data, &message))
+
+	assert.Equal(t, "3.0", message.Release)
+	assert.Equal(t, "20240301T1230Z", message.SentDateTime)
+	assert.Len(t, message.Products, 1)
+	product := message.Products[0]
+	assert.Equal(t, "booker:abc", product.RecordReference)
+	assert.Len(t, product.ProductIdentifiers, 2)
+	assert.Equal(t, "15", product.ProductIdentifiers[0].ProductIDType)
+	assert.Equal(t, "9780441013593", product.ProductIdentifiers[0].IDValue)
+	assert.Equal(t, "02", product.ProductIdentifiers[1].ProductIDType)
+	assert.Equal(t, "0441013597", product.ProductIdentifiers[1].IDValue)
+	assert.Equal(t, "E101", product.ProductFormDetail)
+	assert.Equal(t, "Dune", product.TitleText)
+	assert.Equal(t, "Frank Herbert", product.PersonName)
+	assert.Equal(t, "Chilton", product.PublisherName)
+	assert.Equal(t, "01", product.Date.Format)
+	assert.Equal(t, "196508", product.Date.Value)
+}
@@ -0,0 +1,244 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	marcFieldTerminator    = "\x1e"
+	marcRecordTerminator   = "\x1d"
+	marcSubfieldDelimiter  = "\x1f"
+	marcMaxRecordLength    = 99999
+	marcXmlNamespace       = "http://www.loc.gov/MARC21/slim"
+	marcControlNumberAgent = "booker"
+)
+
+type marcSubfield struct {
+	code  byte
+	value string
+}
+
+type marcField struct {
+	tag       string
+	ind1      byte
+	ind2      byte
+	subfields []marcSubfield
+	// control fields (00X) have a value instead of indicators and subfields
+	value string
+}
+
+func (f *marcField) isControl() bool {
+	return strings.HasPrefix(f.tag, "00")
+}
+
+// encode returns the field as it appears in an ISO 2709 record, including its terminator
+func (f *marcField) encode() string {
+	if f.isControl() {
+		return f.value + marcFieldTerminator
+	}
+	var encoded strings.Builder
+	encoded.WriteByte(f.ind1)
+	encoded.WriteByte(f.ind2)
+	for _, subfield := range f.subfields {
+		encoded.WriteString(marcSubfieldDelimiter)
+		encoded.WriteByte(subfield.code)
+		encoded.WriteString(subfield.value)
+	}
+	encoded.WriteString(marcFieldTerminator)
+	return encoded.String()
+}
+
+// nonfilingCharacters counts the leading article a library catalog should skip when sorting a title
+func nonfilingCharacters(title string) byte {
+	for _, article := range []string{"The ", "An ", "A "} {
+		if len(title) > len(article) && strings.EqualFold(title[:len(article)], article) {
+			return byte('0' + len(article))
+		}
+	}
+	return '0'
+}
+
+// invertName turns "First Last" into "Last, First", which is how MARC records personal names
+func invertName(author string) string {
+	names := strings.Fields(author)
+	if len(names) < 2 || strings.Contains(author, ",") {
+		return author
+	}
+	return fmt.Sprintf("%s, %s", names[len(names)-1], strings.Join(names[:len(names)-1], " "))
+}
+
+// marcFields maps a book to a minimal bibliographic record, created is used for the record's own dates
+func marcFields(bk *book.Book, created time.Time) []marcField {
+	fields := make([]marcField, 0)
+	data := func(tag string, ind1 byte, ind2 byte, subfields ...marcSubfield) {
+		for idx := range subfields {
+			// control characters include MARC's own delimiters, which would break the record
+			subfields[idx].value = strings.Map(func(r rune) rune {
+				if r < ' ' {
+					return ' '
+				}
+				return r
+			}, subfields[idx].value)
+		}
+		fields = append(fields, marcField{tag: tag, ind1: ind1, ind2: ind2, subfields: subfields})
+	}
+
+	if len(bk.Hash) > 0 {
+		fields = append(fields, marcField{tag: "001", value: bk.Hash})
+		fields = append(fields, marcField{tag: "003", value: marcControlNumberAgent})
+	}
+	fields = append(fields, marcField{tag: "005", value: created.UTC().Format("20060102150405.0")})
+	// an electronic resource of unspecified kind
+	fields = append(fields, marcField{tag: "007", value: "cu"})
+
+	dateType, date1 := "n", "uuuu"
+	if publishYear := year(bk); len(publishYear) == 4 {
+		dateType, date1 = "s", publishYear
+	}
+	// entered, date type and dates, unknown place, electronic form of item, undetermined language, other source
+	fixed := created.UTC().Format("060102") + dateType + date1 + "    " + "xx " + strings.Repeat(" ", 5) + "s" +
+		strings.Repeat(" ", 11) + "und" + " " + "d"
+	fields = append(fields, marcField{tag: "008", value: fixed})
+
+	if len(bk.Isbn13) > 0 {
+		data("020", ' ', ' ', marcSubfield{'a', string(bk.Isbn13)})
+	}
+	if len(bk.Isbn10) > 0 {
+		data("020", ' ', ' ', marcSubfield{'a', string(bk.Isbn10)})
+	}
+	if len(bk.Doi) > 0 {
+		data("024", '7', ' ', marcSubfield{'a', string(bk.Doi)}, marcSubfield{'2', "doi"})
+	}
+	if len(bk.Oclc) > 0 {
+		data("035", ' ', ' ', marcSubfield{'a', "(OCoLC)" + bk.Oclc})
+	}
+	// neither call number is known to have been assigned by the Library of Congress itself
+	if len(bk.Lcc) > 0 {
+		data("050", ' ', '4', marcSubfield{'a', bk.Lcc})
+	}
+	if len(bk.Ddc) > 0 {
+		data("082", '0', '4', marcSubfield{'a', bk.Ddc})
+	}
+
+	titleInd1 := byte('0')
+	if len(bk.Authors) > 0 {
+		data("100", '1', ' ', marcSubfield{'a', invertName(bk.Authors[0])})
+		titleInd1 = '1'
+	}
+	data("245", titleInd1, nonfilingCharacters(bk.Title), marcSubfield{'a', bk.Title})
+
+	publication := make([]marcSubfield, 0)
+	if len(bk.Publisher) > 0 {
+		publication = append(publication, marcSubfield{'b', bk.Publisher})
+	}
+	if publishYear := year(bk); len(publishYear) > 0 {
+		publication = append(publication, marcSubfield{'c', publishYear})
+	}
+	if len(publication) > 0 {
+		data("264", ' ', '1', publication...)
+	}
+
+	if bk.Pages > 0 {
+		data("300", ' ', ' ', marcSubfield{'a', fmt.Sprintf("%d pages", bk.Pages)})
+	}
+
+	for _, author := range bk.Authors[min(1, len(bk.Authors)):] {
+		data("700", '1', ' ', marcSubfield{'a', invertName(author)})
+	}
+
+	if len(bk.Filepath) > 0 {
+		location := url.URL{Scheme: "file", Path: filepath.ToSlash(bk.Filepath)}
+		data("856", '4', ' ', marcSubfield{'u', location.String()})
+	}
+
+	return fields
+}
+
+// marcLeader describes a new, minimal level, Unicode record for a monograph
+func marcLeader(recordLength int, baseAddress int) string {
+	return fmt.Sprintf("%05dnam a22%05d7u 4500", recordLength, baseAddress)
+}
+
+// encodeMarc encodes fields as an ISO 2709 record
+func encodeMarc(fields []marcField) (string, error) {
+	var directory, body strings.Builder
+	for _, field := range fields {
+		encoded := field.encode()
+		directory.WriteString(fmt.Sprintf("%s%04d%05d", field.tag, len(encoded), body.Len()))
+		body.WriteString(encoded)
+	}
+	directory.WriteString(marcFieldTerminator)
+
+	baseAddress := 24 + directory.Len()
+	recordLength := baseAddress + body.Len() + len(marcRecordTerminator)
+	if recordLength > marcMaxRecordLength {
+		return "", fmt.Errorf("MARC record would be %d bytes, more than the maximum of %d", recordLength, marcMaxRecordLength)
+	}
+
+	return marcLeader(recordLength, baseAddress) + directory.String() + body.String() + marcRecordTerminator, nil
+}
+
+// Marc writes a MARC21 bibliographic record for every book, either as ISO 2709 or as MARCXML
+type Marc struct {
+	Xml     bool
+	Created time.Time
+}
+
+func NewMarc(asXml bool) *Marc {
+	return &Marc{Xml: asXml, Created: time.Now()}
+}
+
+func (m *Marc) Header() string {
+	if !m.Xml {
+		return ""
+	}
+	return fmt.Sprintf("%s<collection xmlns=\"%s\">\n", xml.Header, marcXmlNamespace)
+}
+
+func (m *Marc) Record(bk *book.Book) (string, error) {
+	fields := marcFields(bk, m.Created)
+	record, err := encodeMarc(fields)
+	if err != nil {
+		return "", err
+	}
+	if !m.Xml {
+		return record, nil
+	}
+
+	var encoded strings.Builder
+	encoded.WriteString("  <record>\n")
+	// the leader is kept as ISO 2709 has it, so converting back to binary gives the same record
+	encoded.WriteString(fmt.Sprintf("    <leader>%s</leader>\n", record[:24]))
+	for _, field := range fields {
+		if field.isControl() {
+			encoded.WriteString(fmt.Sprintf("    <controlfield tag=\"%s\">%s</controlfield>\n", field.tag, xmlEscape(field.value)))
+			continue
+		}
+		encoded.WriteString(fmt.Sprintf("    <datafield tag=\"%s\" ind1=\"%c\" ind2=\"%c\">\n", field.tag, field.ind1, field.ind2))
+		for _, subfield := range field.subfields {
+			encoded.WriteString(fmt.Sprintf("      <subfield code=\"%c\">%s</subfield>\n", subfield.code, xmlEscape(subfield.value)))
+		}
+		encoded.WriteString("    </datafield>\n")
+	}
+	encoded.WriteString("  </record>\n")
+	return encoded.String(), nil
+}
+
+func (m *Marc) Footer() string {
+	if !m.Xml {
+		return ""
+	}
+	return "</collection>\n"
+}
+
+func xmlEscape(s string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
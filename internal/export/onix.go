@@ -0,0 +1,196 @@
+package export
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const onixNamespace = "http://ns.editeur.org/onix/3.0/reference"
+
+// ONIX code list 175 product form details by file extension
+var onixFormDetails = map[string]string{
+	".azw3": "E116",
+	".epub": "E101",
+	".mobi": "E127",
+	".pdf":  "E107",
+	".txt":  "E112",
+}
+
+type onixProductIdentifier struct {
+	ProductIDType string `xml:"ProductIDType"`
+	IDTypeName    string `xml:"IDTypeName,omitempty"`
+	IDValue       string `xml:"IDValue"`
+}
+
+type onixTitleDetail struct {
+	TitleType    string `xml:"TitleType"`
+	TitleElement struct {
+		TitleElementLevel string `xml:"TitleElementLevel"`
+		TitleText         string `xml:"TitleText"`
+	} `xml:"TitleElement"`
+}
+
+type onixContributor struct {
+	SequenceNumber  int    `xml:"SequenceNumber"`
+	ContributorRole string `xml:"ContributorRole"`
+	PersonName      string `xml:"PersonName"`
+}
+
+type onixExtent struct {
+	ExtentType  string `xml:"ExtentType"`
+	ExtentValue string `xml:"ExtentValue"`
+	ExtentUnit  string `xml:"ExtentUnit"`
+}
+
+type onixDescriptiveDetail struct {
+	ProductComposition string            `xml:"ProductComposition"`
+	ProductForm        string            `xml:"ProductForm"`
+	ProductFormDetail  string            `xml:"ProductFormDetail,omitempty"`
+	TitleDetail        onixTitleDetail   `xml:"TitleDetail"`
+	Contributors       []onixContributor `xml:"Contributor"`
+	NoContributor      *struct{}         `xml:"NoContributor"`
+	Extent             *onixExtent       `xml:"Extent"`
+}
+
+type onixPublisher struct {
+	PublishingRole string `xml:"PublishingRole"`
+	PublisherName  string `xml:"PublisherName"`
+}
+
+type onixDate struct {
+	Format string `xml:"dateformat,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type onixPublishingDate struct {
+	PublishingDateRole string   `xml:"PublishingDateRole"`
+	Date               onixDate `xml:"Date"`
+}
+
+type onixPublishingDetail struct {
+	Publisher      *onixPublisher      `xml:"Publisher"`
+	PublishingDate *onixPublishingDate `xml:"PublishingDate"`
+}
+
+type onixProduct struct {
+	XMLName            xml.Name                `xml:"Product"`
+	RecordReference    string                  `xml:"RecordReference"`
+	NotificationType   string                  `xml:"NotificationType"`
+	ProductIdentifiers []onixProductIdentifier `xml:"ProductIdentifier"`
+	DescriptiveDetail  onixDescriptiveDetail   `xml:"DescriptiveDetail"`
+	PublishingDetail   *onixPublishingDetail   `xml:"PublishingDetail"`
+}
+
+// onixPublishDate returns a publication date in the most precise ONIX date format it fits
+func onixPublishDate(bk *book.Book) *onixPublishingDate {
+	date := strings.ReplaceAll(bk.PublishDate, "-", "")
+	var format string
+	switch len(date) {
+	case 8:
+		format = "00"
+	case 6:
+		format = "01"
+	case 4:
+		format = "05"
+	default:
+		date = year(bk)
+		format = "05"
+	}
+	if _, err := strconv.Atoi(date); err != nil || len(date) == 0 {
+		return nil
+	}
+	return &onixPublishingDate{PublishingDateRole: "01", Date: onixDate{Format: format, Value: date}}
+}
+
+// Onix writes an ONIX for Books 3.0 product record for every book
+type Onix struct {
+	Sender string
+	Sent   time.Time
+}
+
+func NewOnix() *Onix {
+	return &Onix{Sender: "booker", Sent: time.Now()}
+}
+
+func (o *Onix) Header() string {
+	return fmt.Sprintf("%s<ONIXMessage release=\"3.0\" xmlns=\"%s\">\n  <Header>\n    <Sender>\n      <SenderName>%s</SenderName>\n    </Sender>\n    <SentDateTime>%s</SentDateTime>\n  </Header>\n",
+		xml.Header, onixNamespace, xmlEscape(o.Sender), o.Sent.UTC().Format("20060102T1504Z"))
+}
+
+func (o *Onix) Record(bk *book.Book) (string, error) {
+	product := onixProduct{
+		// a new record, booker has no way of knowing whether the receiver has seen the book before
+		NotificationType: "03",
+	}
+
+	// code list 5 product identifier types
+	if len(bk.Isbn13) > 0 {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, onixProductIdentifier{ProductIDType: "15", IDValue: string(bk.Isbn13)})
+	}
+	if len(bk.Isbn10) > 0 {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, onixProductIdentifier{ProductIDType: "02", IDValue: string(bk.Isbn10)})
+	}
+	if len(bk.Doi) > 0 {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, onixProductIdentifier{ProductIDType: "06", IDValue: string(bk.Doi)})
+	}
+	if len(bk.Oclc) > 0 {
+		product.ProductIdentifiers = append(product.ProductIdentifiers, onixProductIdentifier{ProductIDType: "01", IDTypeName: "OCLC", IDValue: bk.Oclc})
+	}
+	if len(product.ProductIdentifiers) == 0 {
+		return "", fmt.Errorf("ONIX products need an ISBN, DOI or OCLC number")
+	}
+
+	if len(bk.Hash) > 0 {
+		product.RecordReference = "booker:" + bk.Hash
+	} else {
+		product.RecordReference = "booker:" + product.ProductIdentifiers[0].IDValue
+	}
+
+	// a single item downloaded as a file
+	product.DescriptiveDetail.ProductComposition = "00"
+	product.DescriptiveDetail.ProductForm = "ED"
+	product.DescriptiveDetail.ProductFormDetail = onixFormDetails[strings.ToLower(filepath.Ext(bk.Filepath))]
+
+	product.DescriptiveDetail.TitleDetail.TitleType = "01"
+	product.DescriptiveDetail.TitleDetail.TitleElement.TitleElementLevel = "01"
+	product.DescriptiveDetail.TitleDetail.TitleElement.TitleText = bk.Title
+
+	for idx, author := range bk.Authors {
+		product.DescriptiveDetail.Contributors = append(product.DescriptiveDetail.Contributors, onixContributor{
+			SequenceNumber:  idx + 1,
+			ContributorRole: "A01",
+			PersonName:      author,
+		})
+	}
+	if len(bk.Authors) == 0 {
+		product.DescriptiveDetail.NoContributor = &struct{}{}
+	}
+
+	if bk.Pages > 0 {
+		// main content page count, in pages
+		product.DescriptiveDetail.Extent = &onixExtent{ExtentType: "00", ExtentValue: strconv.FormatUint(uint64(bk.Pages), 10), ExtentUnit: "03"}
+	}
+
+	publishing := onixPublishingDetail{PublishingDate: onixPublishDate(bk)}
+	if len(bk.Publisher) > 0 {
+		publishing.Publisher = &onixPublisher{PublishingRole: "01", PublisherName: bk.Publisher}
+	}
+	if publishing.Publisher != nil || publishing.PublishingDate != nil {
+		product.PublishingDetail = &publishing
+	}
+
+	data, err := xml.MarshalIndent(product, "  ", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+func (o *Onix) Footer() string {
+	return "</ONIXMessage>\n"
+}
@@ -0,0 +1,130 @@
+package export
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Format serializes books into a file format that is written record by record
+type Format interface {
+	Header() string
+	Record(bk *book.Book) (string, error)
+	Footer() string
+}
+
+// Writer writes the books that have metadata to a file in the given format, books that failed are left out
+type Writer struct {
+	Filepath string
+	Input    chan *book.Book
+	waiter   sync.WaitGroup
+	fh       *os.File
+	out      util.Compressor
+	format   Format
+}
+
+func NewWriter(filePath string, compression util.Compression, format Format) (*Writer, error) {
+	fh, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	out, err := compression.Writer(fh)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	writer := &Writer{
+		Filepath: filePath,
+		Input:    make(chan *book.Book, 10000),
+		waiter:   sync.WaitGroup{},
+		fh:       fh,
+		out:      out,
+		format:   format,
+	}
+
+	_, err = io.WriteString(out, format.Header())
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	writer.waiter.Add(1)
+	go writer.writer()
+
+	return writer, nil
+}
+
+func (writer *Writer) writer() {
+	defer writer.waiter.Done()
+
+	for bk := range writer.Input {
+		if len(bk.ErrorMessage) > 0 || len(bk.Title) == 0 {
+			continue
+		}
+
+		record, err := writer.format.Record(bk)
+		if err != nil {
+			slog.Warn("could not export book", "path", bk.Filepath, "error", err)
+			continue
+		}
+
+		_, err = io.WriteString(writer.out, record)
+		if err == nil {
+			err = writer.out.Flush()
+		}
+		if err == nil {
+			err = writer.fh.Sync()
+		}
+		if err != nil {
+			slog.Error("failed to write book", "path", bk.Filepath, "output", writer.Filepath, "error", err)
+		}
+	}
+}
+
+func (writer *Writer) WriteObject(bk *book.Book) {
+	// copy so callers are free to reuse their book
+	cp := *bk
+	writer.Input <- &cp
+}
+
+func (writer *Writer) Close() {
+	if writer.Input == nil {
+		return
+	}
+
+	close(writer.Input)
+
+	writer.waiter.Wait()
+
+	_, err := io.WriteString(writer.out, writer.format.Footer())
+	if err == nil {
+		err = writer.out.Close()
+	}
+	if err == nil {
+		err = writer.fh.Sync()
+	}
+	if err != nil {
+		slog.Error("failed to finish output", "output", writer.Filepath, "error", err)
+	}
+
+	err = writer.fh.Close()
+	if err != nil {
+		slog.Error("failed to close file handle", "output", writer.Filepath, "error", err)
+	}
+}
+
+// year returns the publication year of a book, or an empty string if it isn't known
+func year(bk *book.Book) string {
+	if len(bk.PublishDate) >= 4 {
+		return bk.PublishDate[:4]
+	}
+	if bk.LowYear > 0 {
+		return strconv.FormatUint(uint64(bk.LowYear), 10)
+	}
+	return ""
+}
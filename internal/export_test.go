@@ -0,0 +1,16 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+// SetCheckIntervals checks the services of the book managers made until the test ends every health interval, and looks
+// for the end of an outage every outage interval, so that a test can take a service down and bring it back quickly
+func SetCheckIntervals(t *testing.T, health time.Duration, outage time.Duration) {
+	oldHealth, oldOutage := healthCheckInterval, outageCheckInterval
+	healthCheckInterval, outageCheckInterval = health, outage
+	t.Cleanup(func() {
+		healthCheckInterval, outageCheckInterval = oldHealth, oldOutage
+	})
+}
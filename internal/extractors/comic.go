@@ -0,0 +1,167 @@
+package extractors
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// comicInfo is the ComicInfo.xml metadata ComicRack and most comic taggers put in the archive
+type comicInfo struct {
+	Title     string `xml:"Title"`
+	Series    string `xml:"Series"`
+	Number    string `xml:"Number"`
+	Year      int    `xml:"Year"`
+	Month     int    `xml:"Month"`
+	Day       int    `xml:"Day"`
+	Writer    string `xml:"Writer"`
+	Publisher string `xml:"Publisher"`
+	PageCount uint   `xml:"PageCount"`
+	Gtin      string `xml:"GTIN"`
+	Language  string `xml:"LanguageISO"`
+	Genre     string `xml:"Genre"`
+	Summary   string `xml:"Summary"`
+}
+
+// ComicExtractor reads the ComicInfo.xml of .cbz and .cbr comic book archives
+type ComicExtractor struct {
+}
+
+func NewComicExtractor() *ComicExtractor {
+	return &ComicExtractor{}
+}
+
+func (ce *ComicExtractor) Shutdown() {
+}
+
+func (ce *ComicExtractor) Name() string {
+	return "Comic"
+}
+
+func (ce *ComicExtractor) Accepts(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".cbz" || ext == ".cbr"
+}
+
+// ExtractText has nothing to give since comics are only images, but succeeds so that a comic without ComicInfo.xml is
+// still searched for by its filename
+func (ce *ComicExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	return "", nil
+}
+
+func (ce *ComicExtractor) ExtractMetadata(ctx context.Context, bk *book.Book) (book.BookResult, error) {
+	data, err := archive.ReadMember(bk.Filepath, func(name string) bool {
+		return strings.EqualFold(path.Base(name), "ComicInfo.xml")
+	})
+	if err != nil {
+		return book.BookResult{}, fmt.Errorf("error: comic has no ComicInfo.xml: %s: %s", bk.Filepath, err.Error())
+	}
+
+	var info comicInfo
+	err = xml.Unmarshal(data, &info)
+	if err != nil {
+		return book.BookResult{}, fmt.Errorf("error: comic has an invalid ComicInfo.xml: %s: %s", bk.Filepath, err.Error())
+	}
+
+	result := book.BookResult{
+		Filepath:           bk.Filepath,
+		Confidence:         50,
+		SourceProviderName: "comic",
+	}
+
+	if title := comicTitle(&info); len(title) > 0 {
+		result.Title = mo.Some(title)
+	}
+
+	authors := make([]string, 0)
+	for _, writer := range strings.Split(info.Writer, ",") {
+		if writer = strings.TrimSpace(writer); len(writer) > 0 {
+			authors = append(authors, writer)
+		}
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	if publisher := strings.TrimSpace(info.Publisher); len(publisher) > 0 {
+		result.Publisher = mo.Some(publisher)
+	}
+	genres := make([]string, 0)
+	for _, genre := range strings.Split(info.Genre, ",") {
+		if genre = strings.TrimSpace(genre); len(genre) > 0 {
+			genres = append(genres, genre)
+		}
+	}
+	if len(genres) > 0 {
+		result.Subjects = mo.Some(genres)
+	}
+	if summary := util.ShortDescription(info.Summary); len(summary) > 0 {
+		result.Description = mo.Some(summary)
+	}
+	if series := strings.TrimSpace(info.Series); len(series) > 0 {
+		result.Series = mo.Some(series)
+		if number, err := strconv.ParseFloat(strings.TrimSpace(info.Number), 64); err == nil {
+			result.SeriesIndex = mo.Some(number)
+		}
+	}
+	if language := util.PrimaryLanguage(info.Language); len(language) > 0 {
+		result.Language = mo.Some(language)
+	}
+
+	if info.Year > 0 {
+		date := strconv.Itoa(info.Year)
+		if info.Month > 0 {
+			date = fmt.Sprintf("%s-%02d", date, info.Month)
+			if info.Day > 0 {
+				date = fmt.Sprintf("%s-%02d", date, info.Day)
+			}
+		}
+		result.PublishDate = mo.Some(date)
+		result.LowYear = mo.Some(uint(info.Year))
+		result.HighYear = mo.Some(uint(info.Year))
+	}
+
+	if info.PageCount > 0 {
+		result.Pages = mo.Some(info.PageCount)
+	}
+
+	// the GTIN of a collected edition is usually its ISBN, a single issue's is a UPC which isn't one
+	gtin := book.ISBN13(strings.NewReplacer("-", "", " ", "").Replace(info.Gtin))
+	if len(gtin) == 13 && gtin.IsValid() && (strings.HasPrefix(string(gtin), "978") || strings.HasPrefix(string(gtin), "979")) {
+		result.Isbn13 = mo.Some(gtin)
+	}
+
+	return result, nil
+}
+
+// comicTitle is how comics are usually named, by their series and issue number followed by the title of the story
+func comicTitle(info *comicInfo) string {
+	title := strings.TrimSpace(info.Title)
+	series := strings.TrimSpace(info.Series)
+	if len(series) == 0 {
+		return title
+	}
+	if number := strings.TrimSpace(info.Number); len(number) > 0 {
+		series = fmt.Sprintf("%s #%s", series, number)
+	}
+	if len(title) == 0 || strings.EqualFold(title, series) {
+		return series
+	}
+	return fmt.Sprintf("%s: %s", series, title)
+}
+
+func (ce *ComicExtractor) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+func (ce *ComicExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
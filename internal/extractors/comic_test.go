@@ -0,0 +1,59 @@
+package extractors_test
+
+import (
+	"archive/zip"
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComicExtractMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Saga 001.cbz")
+	fh, err := os.Create(path)
+	assert.NoError(t, err)
+	w := zip.NewWriter(fh)
+	page, err := w.Create("001.jpg")
+	assert.NoError(t, err)
+	page.Write([]byte{0xFF, 0xD8, 0xFF})
+	info, err := w.Create("ComicInfo.xml")
+	assert.NoError(t, err)
+	info.Write([]byte(`<?xml version="1.0"?>
+<ComicInfo>
+  <Title>Chapter One</Title>
+  <Series>Saga</Series>
+  <Number>1</Number>
+  <Year>2012</Year>
+  <Month>3</Month>
+  <Writer>Brian K. Vaughan</Writer>
+  <Penciller>Fiona Staples</Penciller>
+  <Publisher>Image</Publisher>
+  <PageCount>44</PageCount>
+  <GTIN>978-1-60706-601-9</GTIN>
+</ComicInfo>`))
+	assert.NoError(t, w.Close())
+	assert.NoError(t, fh.Close())
+
+	comic := extractors.NewComicExtractor()
+	assert.True(t, comic.Accepts(path))
+
+	bk := book.Book{Filepath: path}
+	result, err := comic.ExtractMetadata(context.Background(), &bk)
+	assert.NoError(t, err)
+	assert.Equal(t, "Saga #1: Chapter One", result.Title.OrEmpty())
+	assert.Equal(t, []string{"Brian K. Vaughan"}, result.Authors.OrEmpty())
+	assert.Equal(t, "Image", result.Publisher.OrEmpty())
+	assert.Equal(t, "Saga", result.Series.OrEmpty())
+	assert.Equal(t, 1.0, result.SeriesIndex.OrEmpty())
+	assert.Equal(t, "2012-03", result.PublishDate.OrEmpty())
+	assert.Equal(t, uint(44), result.Pages.OrEmpty())
+	assert.Equal(t, book.ISBN13("9781607066019"), result.Isbn13.OrEmpty())
+
+	// comics are only pictures, which leaves their filename to search by
+	text, err := comic.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 1000})
+	assert.NoError(t, err)
+	assert.Empty(t, text)
+}
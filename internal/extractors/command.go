@@ -0,0 +1,106 @@
+package extractors
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CommandExtractor reads the text of books with any command that writes it to its standard output, like pdftotext
+type CommandExtractor struct {
+	name       string
+	command    []string
+	extensions []string
+}
+
+func NewCommandExtractor(conf *config.CommandConfig) *CommandExtractor {
+	command := strings.Fields(conf.Command)
+	command[0] = util.ExpandUser(command[0])
+	return &CommandExtractor{
+		name:       conf.Name,
+		command:    command,
+		extensions: conf.Extensions,
+	}
+}
+
+func (ce *CommandExtractor) Shutdown() {
+}
+
+func (ce *CommandExtractor) Name() string {
+	return ce.name
+}
+
+func (ce *CommandExtractor) Accepts(filePath string) bool {
+	return slices.Contains(ce.extensions, strings.ToLower(filepath.Ext(filePath)))
+}
+
+func (ce *CommandExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := make([]string, 0, len(ce.command)-1)
+	for _, arg := range ce.command[1:] {
+		args = append(args, strings.ReplaceAll(arg, "{file}", util.LongPath(bk.Filepath)))
+	}
+	cmd := exec.CommandContext(ctx, ce.command[0], args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("error: %s unable to create pipe: %s", ce.name, err.Error())
+	}
+	stderr := strings.Builder{}
+	cmd.Stderr = &stderr
+	// don't wait on anything the command left behind holding its output open once it is killed
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("error: %s unable to run %s: %s", ce.name, ce.command[0], err.Error())
+	}
+
+	// unless the end of the book is wanted, the command is stopped once it has written enough of the front
+	text := newTextSample(limit)
+	var readErr error
+	if limit.Tail == 0 {
+		_, readErr = io.Copy(text, io.LimitReader(stdout, int64(limit.Head)))
+	} else {
+		_, readErr = io.Copy(text, stdout)
+	}
+	reachedLimit := text.Done()
+	if reachedLimit {
+		cancel()
+	}
+	waitErr := cmd.Wait()
+
+	if readErr != nil && !reachedLimit {
+		return "", fmt.Errorf("error: %s failed to read text of %s: %s", ce.name, bk.Filepath, readErr.Error())
+	}
+	if waitErr != nil && !reachedLimit {
+		return "", fmt.Errorf("error: %s failed for %s: %s: %s", ce.name, bk.Filepath, waitErr.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	s := text.String()
+	if len(strings.TrimSpace(s)) == 0 {
+		return "", fmt.Errorf("error: %s found no text in %s", ce.name, bk.Filepath)
+	}
+	return s, nil
+}
+
+func (ce *CommandExtractor) SelfCheck() (bool, string) {
+	_, err := exec.LookPath(ce.command[0])
+	if err != nil {
+		return false, fmt.Sprintf("%s not found, install it or fix the command of %s: %s", ce.command[0], ce.name, err.Error())
+	}
+	return true, ""
+}
+
+func (ce *CommandExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
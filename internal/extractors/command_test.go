@@ -0,0 +1,46 @@
+package extractors_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommandExtractText(t *testing.T) {
+	if _, err := exec.LookPath("cat"); err != nil {
+		t.Skip("the command is cat")
+	}
+	path := filepath.Join(t.TempDir(), "Notes On Rust.TXT")
+	assert.NoError(t, os.WriteFile(path, []byte("ISBN 978-1-7185-0126-3\nchapter one\n"), 0o644))
+
+	conf := config.Config{Commands: []config.CommandConfig{{Name: "cat", Command: "cat {file}", Extensions: []string{"txt"}}}}
+	assert.NoError(t, conf.Validate())
+	extractor := extractors.NewCommandExtractor(&conf.Commands[0])
+	assert.True(t, extractor.Accepts(path))
+	assert.False(t, extractor.Accepts("/books/rust.pdf"))
+
+	text, err := extractor.ExtractText(context.Background(), &book.Book{Filepath: path}, extractors.TextLimit{Head: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, "ISBN 978-1", text)
+
+	// a character the limit falls in the middle of is left out, rather than cut in half
+	assert.NoError(t, os.WriteFile(path, []byte("Les Misérables, tome 1\n"), 0o644))
+	text, err = extractor.ExtractText(context.Background(), &book.Book{Filepath: path}, extractors.TextLimit{Head: 8})
+	assert.NoError(t, err)
+	assert.Equal(t, "Les Mis", text)
+	text, err = extractor.ExtractText(context.Background(), &book.Book{Filepath: path}, extractors.TextLimit{Head: 8, Tail: 16})
+	assert.NoError(t, err)
+	assert.Equal(t, "Les Mis\nrables, tome 1\n", text)
+
+	_, err = extractor.ExtractText(context.Background(), &book.Book{Filepath: filepath.Join(t.TempDir(), "gone.txt")}, extractors.TextLimit{Head: 10})
+	assert.Error(t, err)
+
+	conf.Commands[0].Command = "cat"
+	assert.Error(t, conf.Validate())
+}
@@ -0,0 +1,103 @@
+package extractors
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DjvuExtractor reads the hidden text layer of DjVu files with djvutxt from DjVuLibre
+type DjvuExtractor struct {
+	command string
+}
+
+func NewDjvuExtractor(conf *config.DjvuConfig) *DjvuExtractor {
+	return &DjvuExtractor{
+		command: conf.Command,
+	}
+}
+
+func (de *DjvuExtractor) Shutdown() {
+}
+
+func (de *DjvuExtractor) Name() string {
+	return "DjVu"
+}
+
+func (de *DjvuExtractor) Accepts(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".djvu" || ext == ".djv"
+}
+
+func (de *DjvuExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, de.command, util.LongPath(bk.Filepath))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("error: djvu unable to create pipe: %s", err.Error())
+	}
+	stderr := strings.Builder{}
+	cmd.Stderr = &stderr
+	// don't wait on anything djvutxt left behind holding its output open once it is killed
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("error: djvu unable to run %s: %s", de.command, err.Error())
+	}
+
+	// djvutxt goes through every page, but unless the end of the book is wanted only the front is needed
+	text := newTextSample(limit)
+	var readErr error
+	if limit.Tail == 0 {
+		_, readErr = io.Copy(text, io.LimitReader(stdout, int64(limit.Head)))
+	} else {
+		_, readErr = io.Copy(text, stdout)
+	}
+	reachedLimit := text.Done()
+	if reachedLimit {
+		cancel()
+	}
+	waitErr := cmd.Wait()
+
+	if readErr != nil && !reachedLimit {
+		return "", fmt.Errorf("error: djvu failed to read text of %s: %s", bk.Filepath, readErr.Error())
+	}
+	if waitErr != nil && !reachedLimit {
+		return "", fmt.Errorf("error: djvu %s failed for %s: %s: %s", de.command, bk.Filepath, waitErr.Error(), strings.TrimSpace(stderr.String()))
+	}
+
+	// pages, columns and lines are separated by control characters
+	s := strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\n' && r != '\t' {
+			return '\n'
+		}
+		return r
+	}, text.String())
+
+	if len(strings.TrimSpace(s)) == 0 {
+		return "", fmt.Errorf("error: djvu has no text layer: %s", bk.Filepath)
+	}
+	return s, nil
+}
+
+func (de *DjvuExtractor) SelfCheck() (bool, string) {
+	_, err := exec.LookPath(de.command)
+	if err != nil {
+		return false, fmt.Sprintf("%s not found, install DjVuLibre or set djvu.command: %s", de.command, err.Error())
+	}
+	return true, ""
+}
+
+func (de *DjvuExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
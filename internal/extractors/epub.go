@@ -0,0 +1,333 @@
+package extractors
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubIdentifier struct {
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// epubMeta is either an EPUB 2 <meta name content> or an EPUB 3 <meta property refines>value</meta>
+type epubMeta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Id       string `xml:"id,attr"`
+	Value    string `xml:",chardata"`
+}
+
+// epubSeries reads the series of a book from an EPUB 3 collection, or the metadata Calibre adds to EPUB 2 books
+func epubSeries(metas []epubMeta) (string, mo.Option[float64]) {
+	for _, meta := range metas {
+		if meta.Property != "belongs-to-collection" || len(strings.TrimSpace(meta.Value)) == 0 {
+			continue
+		}
+		for _, refinement := range metas {
+			if refinement.Refines == "#"+meta.Id && refinement.Property == "group-position" && len(meta.Id) > 0 {
+				if index, err := strconv.ParseFloat(strings.TrimSpace(refinement.Value), 64); err == nil {
+					return strings.TrimSpace(meta.Value), mo.Some(index)
+				}
+			}
+		}
+		return strings.TrimSpace(meta.Value), mo.None[float64]()
+	}
+
+	series, index := "", mo.None[float64]()
+	for _, meta := range metas {
+		switch meta.Name {
+		case "calibre:series":
+			series = strings.TrimSpace(meta.Content)
+		case "calibre:series_index":
+			if value, err := strconv.ParseFloat(strings.TrimSpace(meta.Content), 64); err == nil {
+				index = mo.Some(value)
+			}
+		}
+	}
+	return series, index
+}
+
+type epubPackage struct {
+	Metadata struct {
+		Titles      []string         `xml:"title"`
+		Creators    []string         `xml:"creator"`
+		Identifiers []epubIdentifier `xml:"identifier"`
+		Dates       []string         `xml:"date"`
+		Publishers  []string         `xml:"publisher"`
+		Languages   []string         `xml:"language"`
+		Subjects    []string         `xml:"subject"`
+		Description string           `xml:"description"`
+		Metas       []epubMeta       `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		Id   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IdRef string `xml:"idref,attr"`
+	} `xml:"spine>itemref"`
+}
+
+type EpubExtractor struct {
+}
+
+func NewEpubExtractor() *EpubExtractor {
+	return &EpubExtractor{}
+}
+
+func (ee *EpubExtractor) Shutdown() {
+}
+
+func (ee *EpubExtractor) Name() string {
+	return "EPUB"
+}
+
+func (ee *EpubExtractor) Accepts(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".epub"
+}
+
+func readZipFile(archive *zip.ReadCloser, name string) ([]byte, error) {
+	fh, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	return io.ReadAll(fh)
+}
+
+// openPackage locates the OPF package document through META-INF/container.xml and parses it
+func (ee *EpubExtractor) openPackage(filePath string) (*zip.ReadCloser, *epubPackage, string, error) {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("error: epub unable to open file: %s: %s", filePath, err.Error())
+	}
+
+	containerData, err := readZipFile(archive, "META-INF/container.xml")
+	if err != nil {
+		archive.Close()
+		return nil, nil, "", fmt.Errorf("error: epub has no container: %s: %s", filePath, err.Error())
+	}
+
+	var container epubContainer
+	err = xml.Unmarshal(containerData, &container)
+	if err != nil {
+		archive.Close()
+		return nil, nil, "", fmt.Errorf("error: epub container is invalid: %s: %s", filePath, err.Error())
+	}
+	if len(container.Rootfiles) == 0 {
+		archive.Close()
+		return nil, nil, "", fmt.Errorf("error: epub container lists no package document: %s", filePath)
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	opfData, err := readZipFile(archive, opfPath)
+	if err != nil {
+		archive.Close()
+		return nil, nil, "", fmt.Errorf("error: epub unable to read package document %s: %s: %s", opfPath, filePath, err.Error())
+	}
+
+	var pkg epubPackage
+	err = xml.Unmarshal(opfData, &pkg)
+	if err != nil {
+		archive.Close()
+		return nil, nil, "", fmt.Errorf("error: epub package document is invalid: %s: %s", filePath, err.Error())
+	}
+
+	return archive, &pkg, path.Dir(opfPath), nil
+}
+
+func (ee *EpubExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	archive, pkg, root, err := ee.openPackage(bk.Filepath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+
+	hrefs := make(map[string]string)
+	for _, item := range pkg.Manifest {
+		hrefs[item.Id] = item.Href
+	}
+
+	chapterText := func(idRef string) string {
+		href, ok := hrefs[idRef]
+		if !ok {
+			return ""
+		}
+		data, err := readZipFile(archive, path.Join(root, href))
+		if err != nil {
+			return ""
+		}
+		text := strings.Builder{}
+		writeMarkupText(&text, data)
+		return text.String()
+	}
+
+	head := strings.Builder{}
+	next := 0
+	for ; next < len(pkg.Spine) && uint(head.Len()) < limit.Head; next++ {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		head.WriteString(chapterText(pkg.Spine[next].IdRef))
+	}
+
+	// the back of the book is read a chapter at a time from the end, stopping short of what the head already covered
+	tail := ""
+	for idx := len(pkg.Spine) - 1; idx >= next && uint(len(tail)) < limit.Tail; idx-- {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		tail = chapterText(pkg.Spine[idx].IdRef) + tail
+	}
+
+	if head.Len() == 0 && len(tail) == 0 {
+		return "", fmt.Errorf("error: epub contained no text: %s", bk.Filepath)
+	}
+
+	return joinSample(firstCharacters(head.String(), limit.Head), lastCharacters(tail, limit.Tail)), nil
+}
+
+// writeMarkupText writes only the character data of an (X)HTML document, separating elements by newlines
+func writeMarkupText(text *strings.Builder, data []byte) {
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			text.WriteString("\n")
+		}
+	}
+}
+
+func (ee *EpubExtractor) ExtractMetadata(ctx context.Context, bk *book.Book) (book.BookResult, error) {
+	archive, pkg, _, err := ee.openPackage(bk.Filepath)
+	if err != nil {
+		return book.BookResult{}, err
+	}
+	defer archive.Close()
+
+	metadata := pkg.Metadata
+
+	result := book.BookResult{
+		Filepath:           bk.Filepath,
+		Confidence:         50,
+		SourceProviderName: "epub",
+	}
+
+	if len(metadata.Titles) > 0 && strings.TrimSpace(metadata.Titles[0]) != "" {
+		result.Title = mo.Some(strings.TrimSpace(metadata.Titles[0]))
+	}
+
+	authors := make([]string, 0)
+	for _, creator := range metadata.Creators {
+		if creator = strings.TrimSpace(creator); creator != "" {
+			authors = append(authors, creator)
+		}
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	for _, identifier := range metadata.Identifiers {
+		value := strings.TrimSpace(identifier.Value)
+		lowered := strings.ToLower(value)
+		if !strings.EqualFold(identifier.Scheme, "isbn") && !strings.HasPrefix(lowered, "urn:isbn:") && !strings.HasPrefix(lowered, "isbn") {
+			continue
+		}
+
+		value = value[strings.LastIndex(value, ":")+1:]
+		value = strings.TrimPrefix(strings.ToUpper(value), "ISBN")
+		value = strings.NewReplacer("-", "", " ", "").Replace(value)
+
+		if !book.IsIsbnCandidate(value) {
+			continue
+		}
+
+		switch len(value) {
+		case 10:
+			isbn := book.ISBN10(value)
+			if isbn.IsValid() {
+				result.Isbn10 = mo.Some(isbn)
+			}
+		case 13:
+			isbn := book.ISBN13(value)
+			if isbn.IsValid() {
+				result.Isbn13 = mo.Some(isbn)
+			}
+		}
+	}
+
+	if len(metadata.Dates) > 0 && strings.TrimSpace(metadata.Dates[0]) != "" {
+		result.PublishDate = mo.Some(strings.TrimSpace(metadata.Dates[0]))
+	}
+
+	if len(metadata.Publishers) > 0 && strings.TrimSpace(metadata.Publishers[0]) != "" {
+		result.Publisher = mo.Some(strings.TrimSpace(metadata.Publishers[0]))
+	}
+
+	subjects := make([]string, 0)
+	for _, subject := range metadata.Subjects {
+		if subject = strings.TrimSpace(subject); len(subject) > 0 {
+			subjects = append(subjects, subject)
+		}
+	}
+	if len(subjects) > 0 {
+		result.Subjects = mo.Some(subjects)
+	}
+	if description := util.ShortDescription(metadata.Description); len(description) > 0 {
+		result.Description = mo.Some(description)
+	}
+
+	if series, index := epubSeries(metadata.Metas); len(series) > 0 {
+		result.Series = mo.Some(series)
+		result.SeriesIndex = index
+	}
+
+	if len(metadata.Languages) > 0 {
+		if language := util.PrimaryLanguage(metadata.Languages[0]); len(language) > 0 {
+			result.Language = mo.Some(language)
+		}
+	}
+
+	if result.IsUnidentified() {
+		return result, fmt.Errorf("error: epub has no usable embedded metadata: %s", bk.Filepath)
+	}
+
+	return result, nil
+}
+
+func (ee *EpubExtractor) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+func (ee *EpubExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
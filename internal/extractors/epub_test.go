@@ -0,0 +1,96 @@
+package extractors_test
+
+import (
+	"archive/zip"
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeEpub writes an EPUB 3 book of three chapters, identified by a UUID before its ISBN and in a series
+func writeEpub(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "book.epub")
+	fh, err := os.Create(path)
+	assert.NoError(t, err)
+	w := zip.NewWriter(fh)
+	for _, entry := range []struct {
+		name     string
+		contents string
+	}{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"OEBPS/content.opf", `<?xml version="1.0"?>
+<package version="3.0" xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uuid">urn:uuid:0b6a1b4e-60ab-4b54-bd3a-4b4d3b0e7b4f</dc:identifier>
+    <dc:identifier id="isbn">urn:isbn:978-1-60706-601-9</dc:identifier>
+    <meta refines="#isbn" property="identifier-type" scheme="onix:codelist5">15</meta>
+    <dc:title id="title"> Saga, Volume One </dc:title>
+    <meta refines="#title" property="title-type">main</meta>
+    <dc:creator id="creator1">Brian K. Vaughan</dc:creator>
+    <meta refines="#creator1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="creator2">Fiona Staples</dc:creator>
+    <dc:language>en-US</dc:language>
+    <meta property="belongs-to-collection" id="collection">Saga</meta>
+    <meta refines="#collection" property="collection-type">series</meta>
+    <meta refines="#collection" property="group-position">1</meta>
+  </metadata>
+  <manifest>
+    <item id="ch1" href="text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="text/ch2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch3" href="text/ch3.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="ch1"/><itemref idref="ch2"/><itemref idref="ch3"/></spine>
+</package>`},
+		{"OEBPS/text/ch1.xhtml", "<html><body><p>Copyright &amp; all that</p></body></html>"},
+		{"OEBPS/text/ch2.xhtml", "<html><body><p>The middle of the story</p></body></html>"},
+		{"OEBPS/text/ch3.xhtml", "<html><body><p>The end</p></body></html>"},
+	} {
+		file, err := w.Create(entry.name)
+		assert.NoError(t, err)
+		file.Write([]byte(entry.contents))
+	}
+	assert.NoError(t, w.Close())
+	assert.NoError(t, fh.Close())
+	return path
+}
+
+func TestEpubExtractMetadata(t *testing.T) {
+	path := writeEpub(t)
+	epub := extractors.NewEpubExtractor()
+	assert.True(t, epub.Accepts(path))
+
+	result, err := epub.ExtractMetadata(context.Background(), &book.Book{Filepath: path})
+	assert.NoError(t, err)
+	assert.Equal(t, "Saga, Volume One", result.Title.OrEmpty())
+	assert.Equal(t, []string{"Brian K. Vaughan", "Fiona Staples"}, result.Authors.OrEmpty())
+	// the UUID is passed over for the ISBN after it
+	assert.Equal(t, book.ISBN13("9781607066019"), result.Isbn13.OrEmpty())
+	assert.Equal(t, "en", result.Language.OrEmpty())
+	// the series position refines the collection rather than following it
+	assert.Equal(t, "Saga", result.Series.OrEmpty())
+	assert.Equal(t, 1.0, result.SeriesIndex.OrEmpty())
+}
+
+func TestEpubExtractText(t *testing.T) {
+	path := writeEpub(t)
+	epub := extractors.NewEpubExtractor()
+	bk := book.Book{Filepath: path}
+
+	// the head is read from the first chapters and the tail from the last, leaving out what is between
+	text, err := epub.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 9, Tail: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, "Copyright\nThe end\n\n\n", text)
+
+	// and a limit that covers the book reads each chapter once
+	text, err = epub.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 1000, Tail: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, "Copyright & all that\n\n\nThe middle of the story\n\n\nThe end\n\n\n", text)
+}
@@ -1,6 +1,7 @@
 package extractors
 
 import (
+	"context"
 	"github.com/larkwiot/booker/internal/book"
 	"github.com/larkwiot/booker/internal/service"
 )
@@ -8,6 +9,19 @@ import (
 type Extractor interface {
 	service.Service
 	Name() string
-	ExtractText(bk *book.Book, maxCharacters uint) (string, error)
+	Accepts(filePath string) bool
+	ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error)
 	Shutdown()
 }
+
+// MetadataExtractor is implemented by extractors that can read metadata embedded in the file itself
+type MetadataExtractor interface {
+	Extractor
+	ExtractMetadata(ctx context.Context, bk *book.Book) (book.BookResult, error)
+}
+
+// PageCounter is implemented by extractors that can count the pages of the file, which tells editions apart
+type PageCounter interface {
+	Extractor
+	PageCount(ctx context.Context, bk *book.Book) (uint, error)
+}
@@ -0,0 +1,381 @@
+package extractors
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"golang.org/x/text/encoding/charmap"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// EXTH record types, see https://wiki.mobileread.com/wiki/MOBI#EXTH_Header
+const (
+	exthAuthor          = 100
+	exthPublisher       = 101
+	exthIsbn            = 104
+	exthPublishingDate  = 106
+	exthAsin            = 113
+	exthUpdatedTitle    = 503
+	exthAsinAlternative = 504
+	exthLanguage        = 524
+)
+
+const (
+	mobiCompressionNone    = 1
+	mobiCompressionPalmDoc = 2
+	mobiEncodingUtf8       = 65001
+)
+
+// mobiBook is what booker reads out of a MOBI or KF8 (AZW3) file's headers
+type mobiBook struct {
+	title       string
+	authors     []string
+	isbn        string
+	asin        string
+	publisher   string
+	publishDate string
+	language    string
+
+	data        []byte
+	records     []uint32
+	compression uint16
+	encrypted   bool
+	utf8        bool
+	textRecords int
+	// extraDataFlags tells which trailing entries follow the text of each record
+	extraDataFlags uint16
+}
+
+// record returns the data of the PDB record at idx
+func (mb *mobiBook) record(idx int) ([]byte, error) {
+	if idx >= len(mb.records) {
+		return nil, fmt.Errorf("record %d does not exist", idx)
+	}
+	start := mb.records[idx]
+	end := uint32(len(mb.data))
+	if idx+1 < len(mb.records) {
+		end = mb.records[idx+1]
+	}
+	if start > end || end > uint32(len(mb.data)) {
+		return nil, fmt.Errorf("record %d is out of bounds", idx)
+	}
+	return mb.data[start:end], nil
+}
+
+func readMobi(filePath string) (*mobiBook, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error: mobi unable to read file: %s: %s", filePath, err.Error())
+	}
+	if len(data) < 78 || string(data[60:68]) != "BOOKMOBI" {
+		return nil, fmt.Errorf("error: mobi is not a MOBI file: %s", filePath)
+	}
+
+	mb := &mobiBook{data: data}
+	recordCount := int(binary.BigEndian.Uint16(data[76:78]))
+	if len(data) < 78+recordCount*8 {
+		return nil, fmt.Errorf("error: mobi record list is truncated: %s", filePath)
+	}
+	for idx := 0; idx < recordCount; idx++ {
+		mb.records = append(mb.records, binary.BigEndian.Uint32(data[78+idx*8:]))
+	}
+
+	header, err := mb.record(0)
+	if err != nil || len(header) < 24 || string(header[16:20]) != "MOBI" {
+		return nil, fmt.Errorf("error: mobi has no MOBI header: %s", filePath)
+	}
+	mb.compression = binary.BigEndian.Uint16(header[0:2])
+	mb.textRecords = int(binary.BigEndian.Uint16(header[8:10]))
+	mb.encrypted = binary.BigEndian.Uint16(header[12:14]) != 0
+
+	headerLength := int(binary.BigEndian.Uint32(header[20:24]))
+	if len(header) >= 32 {
+		mb.utf8 = binary.BigEndian.Uint32(header[28:32]) == mobiEncodingUtf8
+	}
+	if headerLength >= 0xE4 && len(header) >= 0xF4 {
+		mb.extraDataFlags = binary.BigEndian.Uint16(header[0xF2:0xF4])
+	}
+
+	if len(header) >= 92 {
+		offset := binary.BigEndian.Uint32(header[84:88])
+		length := binary.BigEndian.Uint32(header[88:92])
+		if uint64(offset)+uint64(length) <= uint64(len(header)) {
+			mb.title = mb.decode(header[offset : offset+length])
+		}
+	}
+	if len(mb.title) == 0 {
+		// the PDB name is a shortened title with underscores for spaces
+		mb.title = strings.ReplaceAll(string(bytes.TrimRight(data[:32], "\x00")), "_", " ")
+	}
+
+	if len(header) >= 132 && binary.BigEndian.Uint32(header[128:132])&0x40 != 0 {
+		mb.readExth(header[min(16+headerLength, len(header)):])
+	}
+
+	return mb, nil
+}
+
+func (mb *mobiBook) decode(data []byte) string {
+	if mb.utf8 {
+		return strings.TrimSpace(strings.ToValidUTF8(string(data), ""))
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(decoded))
+}
+
+func (mb *mobiBook) readExth(exth []byte) {
+	if len(exth) < 12 || string(exth[:4]) != "EXTH" {
+		return
+	}
+	count := binary.BigEndian.Uint32(exth[8:12])
+
+	i := 12
+	for n := uint32(0); n < count && i+8 <= len(exth); n++ {
+		recordType := binary.BigEndian.Uint32(exth[i : i+4])
+		length := int(binary.BigEndian.Uint32(exth[i+4 : i+8]))
+		if length < 8 || i+length > len(exth) {
+			return
+		}
+		value := mb.decode(exth[i+8 : i+length])
+		i += length
+
+		if len(value) == 0 {
+			continue
+		}
+		switch recordType {
+		case exthAuthor:
+			mb.authors = append(mb.authors, value)
+		case exthPublisher:
+			mb.publisher = value
+		case exthIsbn:
+			mb.isbn = value
+		case exthPublishingDate:
+			mb.publishDate = value
+		case exthAsin, exthAsinAlternative:
+			if len(mb.asin) == 0 {
+				mb.asin = value
+			}
+		case exthUpdatedTitle:
+			mb.title = value
+		case exthLanguage:
+			mb.language = value
+		}
+	}
+}
+
+// trailingEntriesSize returns how many bytes at the end of a text record are trailing entries rather than text,
+// see https://wiki.mobileread.com/wiki/MOBI#Variable-width_integers
+func trailingEntriesSize(record []byte, flags uint16) int {
+	size := 0
+	for testFlags := flags >> 1; testFlags != 0; testFlags >>= 1 {
+		if testFlags&1 == 0 {
+			continue
+		}
+		// each entry ends with its own size as a backwards variable-width integer
+		entrySize, shift := 0, 0
+		for end := len(record) - size; end > 0; end-- {
+			b := record[end-1]
+			entrySize |= int(b&0x7F) << shift
+			shift += 7
+			if b&0x80 != 0 || shift >= 28 {
+				break
+			}
+		}
+		size += entrySize
+	}
+	if flags&1 != 0 && size < len(record) {
+		size += int(record[len(record)-size-1]&0x3) + 1
+	}
+	return min(size, len(record))
+}
+
+// decompressPalmDoc undoes PalmDOC's LZ77 compression,
+// see https://wiki.mobileread.com/wiki/PalmDOC#PalmDoc_byte_pair_compression
+func decompressPalmDoc(data []byte) []byte {
+	out := make([]byte, 0, len(data)*2)
+	for i := 0; i < len(data); {
+		c := data[i]
+		i++
+		switch {
+		case c >= 1 && c <= 8:
+			end := min(i+int(c), len(data))
+			out = append(out, data[i:end]...)
+			i = end
+		case c < 0x80:
+			out = append(out, c)
+		case c >= 0xC0:
+			out = append(out, ' ', c^0x80)
+		default:
+			if i >= len(data) {
+				return out
+			}
+			pair := (int(c)<<8 | int(data[i])) & 0x3FFF
+			i++
+			distance, length := pair>>3, pair&7+3
+			if distance == 0 || distance > len(out) {
+				continue
+			}
+			// the copy may overlap what it is writing, so it goes a byte at a time
+			for n := 0; n < length; n++ {
+				out = append(out, out[len(out)-distance])
+			}
+		}
+	}
+	return out
+}
+
+// MobiExtractor reads the EXTH metadata and text of MOBI and KF8 (AZW3) files, which Tika flattens into text and
+// loses the ISBN and ASIN of
+type MobiExtractor struct {
+}
+
+func NewMobiExtractor() *MobiExtractor {
+	return &MobiExtractor{}
+}
+
+func (me *MobiExtractor) Shutdown() {
+}
+
+func (me *MobiExtractor) Name() string {
+	return "MOBI"
+}
+
+func (me *MobiExtractor) Accepts(filePath string) bool {
+	ext := strings.ToLower(filepath.Ext(filePath))
+	return ext == ".mobi" || ext == ".azw" || ext == ".azw3"
+}
+
+func (me *MobiExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	mb, err := readMobi(bk.Filepath)
+	if err != nil {
+		return "", err
+	}
+	if mb.encrypted {
+		return "", fmt.Errorf("error: mobi is DRM protected, only its metadata can be read: %s", bk.Filepath)
+	}
+	if mb.compression != mobiCompressionNone && mb.compression != mobiCompressionPalmDoc {
+		return "", fmt.Errorf("error: mobi uses unsupported compression %d: %s", mb.compression, bk.Filepath)
+	}
+
+	// markup is about twice the size of the text in it
+	head := bytes.Buffer{}
+	next := 1
+	for ; next <= mb.textRecords && uint(head.Len()) < limit.Head*2; next++ {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		record, err := mb.textRecord(next)
+		if err != nil {
+			break
+		}
+		head.Write(record)
+	}
+
+	tail := make([][]byte, 0)
+	tailLength := uint(0)
+	for idx := mb.textRecords; idx >= next && tailLength < limit.Tail*2; idx-- {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		record, err := mb.textRecord(idx)
+		if err != nil {
+			break
+		}
+		tail = append(tail, record)
+		tailLength += uint(len(record))
+	}
+	slices.Reverse(tail)
+
+	headText := strings.Builder{}
+	writeMarkupText(&headText, []byte(mb.decode(head.Bytes())))
+	tailText := strings.Builder{}
+	writeMarkupText(&tailText, []byte(mb.decode(bytes.Join(tail, nil))))
+	if len(strings.TrimSpace(headText.String()+tailText.String())) == 0 {
+		return "", fmt.Errorf("error: mobi contained no text: %s", bk.Filepath)
+	}
+
+	return joinSample(firstCharacters(headText.String(), limit.Head), lastCharacters(tailText.String(), limit.Tail)), nil
+}
+
+// textRecord is text record idx without its trailing entries, decompressed
+func (mb *mobiBook) textRecord(idx int) ([]byte, error) {
+	record, err := mb.record(idx)
+	if err != nil {
+		return nil, err
+	}
+	record = record[:len(record)-trailingEntriesSize(record, mb.extraDataFlags)]
+	if mb.compression == mobiCompressionPalmDoc {
+		record = decompressPalmDoc(record)
+	}
+	return record, nil
+}
+
+func (me *MobiExtractor) ExtractMetadata(ctx context.Context, bk *book.Book) (book.BookResult, error) {
+	mb, err := readMobi(bk.Filepath)
+	if err != nil {
+		return book.BookResult{}, err
+	}
+
+	result := book.BookResult{
+		Filepath:           bk.Filepath,
+		Confidence:         50,
+		SourceProviderName: "mobi",
+	}
+
+	if len(mb.title) > 0 {
+		result.Title = mo.Some(mb.title)
+	}
+	if len(mb.authors) > 0 {
+		result.Authors = mo.Some(mb.authors)
+	}
+
+	isbn := strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(mb.isbn))
+	switch {
+	case len(isbn) == 13:
+		if isbn13 := book.ISBN13(isbn); isbn13.IsValid() {
+			result.Isbn13 = mo.Some(isbn13)
+		}
+	case len(isbn) == 10:
+		if isbn10 := book.ISBN10(isbn); isbn10.IsValid() {
+			result.Isbn10 = mo.Some(isbn10)
+		}
+	}
+
+	if asin := book.ASIN(strings.ToUpper(mb.asin)); asin.IsValid() {
+		result.Asin = mo.Some(asin)
+	}
+
+	if len(mb.publisher) > 0 {
+		result.Publisher = mo.Some(mb.publisher)
+	}
+	if len(mb.publishDate) > 0 {
+		result.PublishDate = mo.Some(mb.publishDate)
+	}
+	if language := util.PrimaryLanguage(mb.language); len(language) > 0 {
+		result.Language = mo.Some(language)
+	}
+
+	if result.IsUnidentified() {
+		return result, fmt.Errorf("error: mobi has no usable embedded metadata: %s", bk.Filepath)
+	}
+
+	return result, nil
+}
+
+func (me *MobiExtractor) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+func (me *MobiExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
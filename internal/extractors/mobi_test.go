@@ -0,0 +1,91 @@
+package extractors_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeMobi(t *testing.T) string {
+	exthRecords := []struct {
+		recordType uint32
+		value      string
+	}{
+		{100, "Frank Herbert"},
+		{101, "Ace"},
+		{104, "978-0-441-01359-3"},
+		{113, "B00B7NPRY8"},
+	}
+	exth := bytes.Buffer{}
+	for _, record := range exthRecords {
+		binary.Write(&exth, binary.BigEndian, record.recordType)
+		binary.Write(&exth, binary.BigEndian, uint32(8+len(record.value)))
+		exth.WriteString(record.value)
+	}
+
+	// "Dune " and then a copy of it overlapping itself, " F" packed into one byte, and trailing entries
+	text := []byte("<html><body><p>Dune ")
+	text = append(text, 0x80, 0x2E)
+	text = append(text, []byte("</p><p>by")...)
+	text = append(text, 0xC6)
+	text = append(text, []byte("rank</p></body></html>")...)
+	text = append(text, 0x00, 0x11, 0x22, 0x83)
+
+	header := make([]byte, 16+0xE8)
+	binary.BigEndian.PutUint16(header[0:], 2)
+	binary.BigEndian.PutUint16(header[8:], 1)
+	copy(header[16:], "MOBI")
+	binary.BigEndian.PutUint32(header[20:], 0xE8)
+	binary.BigEndian.PutUint32(header[28:], 65001)
+	binary.BigEndian.PutUint32(header[128:], 0x40)
+	binary.BigEndian.PutUint16(header[0xF2:], 0x3)
+	header = append(header, "EXTH"...)
+	header = binary.BigEndian.AppendUint32(header, uint32(12+exth.Len()))
+	header = binary.BigEndian.AppendUint32(header, uint32(len(exthRecords)))
+	header = append(header, exth.Bytes()...)
+	binary.BigEndian.PutUint32(header[84:], uint32(len(header)))
+	binary.BigEndian.PutUint32(header[88:], 4)
+	header = append(header, "Dune"...)
+
+	pdb := make([]byte, 78)
+	copy(pdb, "Dune")
+	copy(pdb[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(pdb[76:], 2)
+	offset := uint32(78 + 2*8 + 2)
+	pdb = binary.BigEndian.AppendUint32(pdb, offset)
+	pdb = binary.BigEndian.AppendUint32(pdb, 0)
+	pdb = binary.BigEndian.AppendUint32(pdb, offset+uint32(len(header)))
+	pdb = binary.BigEndian.AppendUint32(pdb, 1)
+	pdb = append(pdb, 0, 0)
+	pdb = append(pdb, header...)
+	pdb = append(pdb, text...)
+
+	path := filepath.Join(t.TempDir(), "dune.azw3")
+	assert.NoError(t, os.WriteFile(path, pdb, 0644))
+	return path
+}
+
+func TestMobi(t *testing.T) {
+	mobi := extractors.NewMobiExtractor()
+	path := writeMobi(t)
+	assert.True(t, mobi.Accepts(path))
+
+	bk := book.Book{Filepath: path}
+	result, err := mobi.ExtractMetadata(context.Background(), &bk)
+	assert.NoError(t, err)
+	assert.Equal(t, "Dune", result.Title.OrEmpty())
+	assert.Equal(t, []string{"Frank Herbert"}, result.Authors.OrEmpty())
+	assert.Equal(t, book.ISBN13("9780441013593"), result.Isbn13.OrEmpty())
+	assert.Equal(t, "Ace", result.Publisher.OrEmpty())
+	assert.Equal(t, book.ASIN("B00B7NPRY8"), result.Asin.OrEmpty())
+
+	text, err := mobi.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, "Dune Dune Dune\nby Frank\n\n\n", text)
+}
@@ -0,0 +1,78 @@
+package extractors
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/pdf"
+	"path/filepath"
+	"strings"
+)
+
+// PdfExtractor reads the text of PDFs itself, so small installs don't need a Tika server
+type PdfExtractor struct {
+}
+
+func NewPdfExtractor() *PdfExtractor {
+	return &PdfExtractor{}
+}
+
+func (pe *PdfExtractor) Shutdown() {
+}
+
+func (pe *PdfExtractor) Name() string {
+	return "PDF"
+}
+
+func (pe *PdfExtractor) Accepts(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".pdf"
+}
+
+func (pe *PdfExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+
+	file, err := pdf.Open(bk.Filepath)
+	if err != nil {
+		return "", fmt.Errorf("error: pdf unable to open %s: %s", bk.Filepath, err.Error())
+	}
+	defer file.Close()
+
+	head, tail, err := file.Sample(int(limit.Head), int(limit.Tail))
+	if err != nil {
+		return "", fmt.Errorf("error: pdf failed to read text of %s: %s", bk.Filepath, err.Error())
+	}
+	text := joinSample(head, lastCharacters(tail, limit.Tail))
+	if len(strings.TrimSpace(text)) == 0 {
+		return "", fmt.Errorf("error: pdf has no text, it may be a scan that was never OCRed: %s", bk.Filepath)
+	}
+	return text, nil
+}
+
+func (pe *PdfExtractor) PageCount(ctx context.Context, bk *book.Book) (uint, error) {
+	return pdfPageCount(bk)
+}
+
+// pdfPageCount counts the pages of a PDF for both of its extractors, since either can be enabled without the other
+func pdfPageCount(bk *book.Book) (uint, error) {
+	file, err := pdf.Open(bk.Filepath)
+	if err != nil {
+		return 0, fmt.Errorf("error: pdf unable to open %s: %s", bk.Filepath, err.Error())
+	}
+	defer file.Close()
+
+	count, err := file.PageCount()
+	if err != nil {
+		return 0, fmt.Errorf("error: pdf failed to count pages of %s: %s", bk.Filepath, err.Error())
+	}
+	return uint(count), nil
+}
+
+func (pe *PdfExtractor) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+func (pe *PdfExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
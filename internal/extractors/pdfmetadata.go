@@ -0,0 +1,223 @@
+package extractors
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/pdf"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const rdfNamespace = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// xmpNamespaces shortens the namespaces of the XMP properties that carry book metadata
+var xmpNamespaces = map[string]string{
+	"http://purl.org/dc/elements/1.1/": "dc",
+	"http://ns.adobe.com/pdf/1.3/":     "pdf",
+	"http://ns.adobe.com/xap/1.0/":     "xmp",
+}
+
+// pdfJunkTitle matches titles that authoring tools fill in on their own
+var pdfJunkTitle = regexp.MustCompile(`(?i)^(untitled|title|document\d*|microsoft word|slide \d+)$|\.(docx?|odt|rtf|pdf|indd|qxd|tex|dvi|ps|txt|html?|pages|key|pptx?|xlsx?)$`)
+
+// pdfJunkAuthors are account names that authoring tools fill in for the author
+var pdfJunkAuthors = []string{"administrator", "admin", "user", "owner", "unknown", "author"}
+
+// readXmp collects the text of each property in an XMP packet by its short name, like "dc:title", flattening the
+// RDF containers that hold its values
+func readXmp(data []byte) map[string][]string {
+	properties := make(map[string][]string)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// XMP is always UTF-8, but some writers declare otherwise
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	stack := make([]string, 0)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return properties
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := ""
+			if prefix, known := propertyPrefix(t.Name.Space); known {
+				name = prefix + ":" + t.Name.Local
+			} else if t.Name.Space == rdfNamespace && t.Name.Local == "Description" {
+				// simple properties can also be written as attributes of the description
+				for _, attr := range t.Attr {
+					if prefix, known := propertyPrefix(attr.Name.Space); known {
+						key := prefix + ":" + attr.Name.Local
+						properties[key] = append(properties[key], strings.TrimSpace(attr.Value))
+					}
+				}
+			} else if t.Name.Space == rdfNamespace && len(stack) > 0 {
+				// containers and values belong to the property around them
+				name = stack[len(stack)-1]
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			value := strings.TrimSpace(string(t))
+			if len(stack) > 0 && len(stack[len(stack)-1]) > 0 && len(value) > 0 {
+				key := stack[len(stack)-1]
+				properties[key] = append(properties[key], value)
+			}
+		}
+	}
+}
+
+func propertyPrefix(namespace string) (string, bool) {
+	if prefix, known := xmpNamespaces[namespace]; known {
+		return prefix, true
+	}
+	// PRISM has moved between several versions of its namespace
+	if strings.HasPrefix(namespace, "http://prismstandard.org/namespaces/") {
+		return "prism", true
+	}
+	return "", false
+}
+
+// splitPdfAuthors splits the single Author entry of the Info dictionary, only splitting on commas when that
+// wouldn't break up a name written "Last, First"
+func splitPdfAuthors(author string) []string {
+	pieces := strings.Split(author, ";")
+	if len(pieces) == 1 {
+		commaPieces := strings.Split(author, ",")
+		if len(commaPieces) > 1 && !slices.ContainsFunc(commaPieces, func(p string) bool {
+			return !strings.Contains(strings.TrimSpace(p), " ")
+		}) {
+			pieces = commaPieces
+		}
+	}
+
+	authors := make([]string, 0)
+	for _, piece := range pieces {
+		piece = strings.TrimSpace(piece)
+		if len(piece) > 0 && !slices.Contains(pdfJunkAuthors, strings.ToLower(piece)) {
+			authors = append(authors, piece)
+		}
+	}
+	return authors
+}
+
+// PdfMetadataExtractor reads the title, authors and identifiers that PDFs carry in their Info dictionary and XMP
+// metadata, without extracting any text
+type PdfMetadataExtractor struct {
+}
+
+func NewPdfMetadataExtractor() *PdfMetadataExtractor {
+	return &PdfMetadataExtractor{}
+}
+
+func (pme *PdfMetadataExtractor) Shutdown() {
+}
+
+func (pme *PdfMetadataExtractor) Name() string {
+	return "PDF Metadata"
+}
+
+func (pme *PdfMetadataExtractor) Accepts(filePath string) bool {
+	return strings.ToLower(filepath.Ext(filePath)) == ".pdf"
+}
+
+func (pme *PdfMetadataExtractor) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	return "", fmt.Errorf("error: pdf metadata extractor does not extract text")
+}
+
+func (pme *PdfMetadataExtractor) PageCount(ctx context.Context, bk *book.Book) (uint, error) {
+	return pdfPageCount(bk)
+}
+
+func (pme *PdfMetadataExtractor) ExtractMetadata(ctx context.Context, bk *book.Book) (book.BookResult, error) {
+	file, err := pdf.Open(bk.Filepath)
+	if err != nil {
+		return book.BookResult{}, fmt.Errorf("error: pdf unable to open %s: %s", bk.Filepath, err.Error())
+	}
+	defer file.Close()
+
+	info := file.Info()
+	xmp := make(map[string][]string)
+	if data, err := file.Metadata(); err == nil {
+		xmp = readXmp(data)
+	}
+
+	result := book.BookResult{
+		Filepath: bk.Filepath,
+		// authoring tools fill these in as often as publishers do, so they are trusted less than an EPUB's
+		Confidence:         40,
+		SourceProviderName: "pdf",
+	}
+
+	// XMP is newer and kept more carefully, so it wins over the Info dictionary
+	titles := slices.Concat(xmp["dc:title"], []string{info["Title"]})
+	for _, title := range titles {
+		title = strings.TrimSpace(strings.TrimPrefix(title, "Microsoft Word - "))
+		if len(title) > 0 && !pdfJunkTitle.MatchString(title) {
+			result.Title = mo.Some(title)
+			break
+		}
+	}
+
+	authors := make([]string, 0)
+	for _, creator := range xmp["dc:creator"] {
+		authors = append(authors, splitPdfAuthors(creator)...)
+	}
+	if len(authors) == 0 {
+		authors = splitPdfAuthors(info["Author"])
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	// identifiers turn up in dedicated fields as well as wherever the publisher had room for them
+	identifierFields := slices.Concat(
+		xmp["prism:isbn"], xmp["prism:doi"], xmp["dc:identifier"], xmp["xmp:Identifier"], xmp["pdf:Keywords"],
+		xmp["dc:subject"], xmp["dc:description"],
+		[]string{info["ISBN"], info["DOI"], info["Subject"], info["Keywords"]},
+	)
+	identifiers := strings.Join(identifierFields, "\n")
+	if isbns := util.IdentifyIsbn13s(identifiers); len(isbns) > 0 {
+		result.Isbn13 = mo.Some(isbns[0])
+	}
+	if isbns := util.IdentifyIsbn10s(identifiers); len(isbns) > 0 {
+		result.Isbn10 = mo.Some(isbns[0])
+	}
+	if dois := util.IdentifyDois(identifiers); len(dois) > 0 {
+		result.Doi = mo.Some(dois[0])
+	}
+
+	if dates := xmp["dc:date"]; len(dates) > 0 {
+		result.PublishDate = mo.Some(dates[0])
+	}
+	if publishers := xmp["dc:publisher"]; len(publishers) > 0 {
+		result.Publisher = mo.Some(publishers[0])
+	}
+
+	if result.IsUnidentified() {
+		return result, fmt.Errorf("error: pdf has no usable embedded metadata: %s", bk.Filepath)
+	}
+
+	return result, nil
+}
+
+func (pme *PdfMetadataExtractor) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+func (pme *PdfMetadataExtractor) HealthCheck() (bool, string) {
+	return true, ""
+}
@@ -0,0 +1,92 @@
+package extractors
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// TextLimit is how much of a book's text is searched, its first Head characters and its last Tail, where colophons and
+// copyright pages at the back of the book put the ISBN
+type TextLimit struct {
+	Head uint
+	Tail uint
+}
+
+// textSample keeps the head and tail of text written to it as it streams past
+type textSample struct {
+	limit TextLimit
+	head  []byte
+	tail  []byte
+}
+
+func newTextSample(limit TextLimit) *textSample {
+	return &textSample{limit: limit}
+}
+
+func (ts *textSample) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := int(ts.limit.Head) - len(ts.head); room > 0 {
+		take := min(room, len(p))
+		ts.head = append(ts.head, p[:take]...)
+		p = p[take:]
+	}
+	if ts.limit.Tail == 0 || len(p) == 0 {
+		return n, nil
+	}
+
+	ts.tail = append(ts.tail, p...)
+	// trimming only once there is twice as much as needed keeps this from copying on every write
+	if uint(len(ts.tail)) > 2*ts.limit.Tail {
+		ts.tail = append(ts.tail[:0], ts.tail[uint(len(ts.tail))-ts.limit.Tail:]...)
+	}
+	return n, nil
+}
+
+// Done is whether nothing more written would be kept
+func (ts *textSample) Done() bool {
+	return ts.limit.Tail == 0 && uint(len(ts.head)) >= ts.limit.Head
+}
+
+func (ts *textSample) String() string {
+	return joinSample(firstCharacters(string(ts.head), ts.limit.Head), lastCharacters(string(ts.tail), ts.limit.Tail))
+}
+
+// firstCharacters is the start of s at most n bytes long, without ending halfway through a character
+func firstCharacters(s string, n uint) string {
+	if uint(len(s)) > n {
+		s = s[:n]
+	}
+	last := len(s)
+	for last > 0 && len(s)-last < utf8.UTFMax {
+		last--
+		if utf8.RuneStart(s[last]) {
+			break
+		}
+	}
+	if !utf8.FullRuneInString(s[last:]) {
+		s = s[:last]
+	}
+	return s
+}
+
+// lastCharacters is the end of s at most n bytes long, without starting halfway through a character
+func lastCharacters(s string, n uint) string {
+	if uint(len(s)) > n {
+		s = s[uint(len(s))-n:]
+	}
+	for len(s) > 0 && !utf8.RuneStart(s[0]) {
+		s = s[1:]
+	}
+	return s
+}
+
+// joinSample puts the head and tail of a book's text together, on separate lines so no ISBN spans them
+func joinSample(head string, tail string) string {
+	if len(tail) == 0 {
+		return head
+	}
+	if len(head) == 0 || strings.HasSuffix(head, "\n") {
+		return head + tail
+	}
+	return head + "\n" + tail
+}
@@ -1,6 +1,7 @@
 package extractors
 
 import (
+	"context"
 	"fmt"
 	"github.com/hashicorp/go-retryablehttp"
 	"github.com/larkwiot/booker/internal/book"
@@ -13,55 +14,116 @@ import (
 )
 
 type TikaServer struct {
-	url string
+	endpoint string
+	url      string
+	headers  http.Header
+	retries  int
+	client   *http.Client
 }
 
-func NewTikaServer(conf *config.TikaConfig) *TikaServer {
+// NewTikaServer talks to the Tika server at endpoint, given as host:port, retrying a failed request up to retries times
+func NewTikaServer(conf *config.TikaConfig, endpoint string, retries int, client *http.Client) *TikaServer {
+	headers := make(http.Header)
+	for name, value := range conf.Headers {
+		headers.Set(name, value)
+	}
+	if len(conf.AuthToken) != 0 {
+		headers.Set("Authorization", "Bearer "+conf.AuthToken)
+	}
+
 	return &TikaServer{
-		url: fmt.Sprintf("http://%s:%d/tika", conf.Host, conf.Port),
+		endpoint: endpoint,
+		url:      fmt.Sprintf("%s://%s/tika", conf.Scheme, endpoint),
+		headers:  headers,
+		retries:  retries,
+		client:   client,
+	}
+}
+
+func (ts *TikaServer) newRequest(ctx context.Context, method string, body any) (*retryablehttp.Request, error) {
+	request, err := retryablehttp.NewRequestWithContext(ctx, method, ts.url, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range ts.headers {
+		request.Header[name] = values
 	}
+	return request, nil
 }
 
 func (ts *TikaServer) Shutdown() {
 }
 
 func (ts *TikaServer) Name() string {
-	return "Tika"
+	return "Tika " + ts.endpoint
 }
 
-func (ts *TikaServer) ExtractText(bk *book.Book, maxCharacters uint) (string, error) {
-	fh, err := os.Open(bk.Filepath)
+func (ts *TikaServer) Accepts(filePath string) bool {
+	return true
+}
+
+func (ts *TikaServer) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	fh, err := openForTika(bk)
 	if err != nil {
-		return "", fmt.Errorf("error: tika unable to open file: %s: %s", bk.Filepath, err.Error())
+		return "", err
 	}
 	defer fh.Close()
 
-	request, err := retryablehttp.NewRequest("PUT", ts.url, fh)
+	response, err := ts.put(ctx, fh)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	return readTikaText(response, bk, limit)
+}
+
+func openForTika(bk *book.Book) (*os.File, error) {
+	fh, err := os.Open(bk.Filepath)
+	if err != nil {
+		return nil, fmt.Errorf("error: tika unable to open file: %s: %s", bk.Filepath, err.Error())
+	}
+	return fh, nil
+}
+
+// put sends the book to the server, a failure here is the server's fault rather than the book's
+func (ts *TikaServer) put(ctx context.Context, body io.Reader) (*http.Response, error) {
+	request, err := ts.newRequest(ctx, "PUT", body)
 	if err != nil {
-		return "", fmt.Errorf("error: unable to create request: %s", err.Error())
+		return nil, fmt.Errorf("error: unable to create request: %s", err.Error())
 	}
 	client := retryablehttp.NewClient()
-	client.RetryMax = 50
+	client.HTTPClient = ts.client
+	client.RetryMax = ts.retries
 	client.Logger = nil
 	response, err := client.Do(request)
 	if err != nil {
-		return "", fmt.Errorf("error: unable to complete request: %s", err.Error())
+		return nil, fmt.Errorf("error: unable to complete request to %s: %s", ts.endpoint, err.Error())
 	}
-	if response.Close {
-		defer response.Body.Close()
+	return response, nil
+}
+
+func readTikaText(response *http.Response, bk *book.Book, limit TextLimit) (string, error) {
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error: tika returned status code %d for file: %s", response.StatusCode, bk.Filepath)
 	}
 
-	text := strings.Builder{}
-	count, err := io.CopyN(&text, response.Body, int64(maxCharacters))
-	if err != nil {
+	// short documents are read to the end, long ones only until there is enough unless their end is wanted too
+	sample := newTextSample(limit)
+	var err error
+	if limit.Tail == 0 {
+		_, err = io.CopyN(sample, response.Body, int64(limit.Head))
+	} else {
+		_, err = io.Copy(sample, response.Body)
+	}
+	if err != nil && err != io.EOF {
 		return "", fmt.Errorf("error: tika failed to read response buffer into string for file: %s: %s", bk.Filepath, err.Error())
 	}
 
-	if uint(count) != maxCharacters {
-		return "", fmt.Errorf("error: tika: expected %d bytes to be written but %d were written", maxCharacters, count)
+	text := sample.String()
+	if len(strings.TrimSpace(text)) == 0 {
+		return "", fmt.Errorf("error: tika found no text in file: %s", bk.Filepath)
 	}
-
-	return text.String(), nil
+	return text, nil
 }
 
 func (ts *TikaServer) SelfCheck() (bool, string) {
@@ -70,20 +132,25 @@ func (ts *TikaServer) SelfCheck() (bool, string) {
 
 func (ts *TikaServer) HealthCheck() (bool, string) {
 	client := retryablehttp.NewClient()
+	client.HTTPClient = &http.Client{Transport: ts.client.Transport, Timeout: time.Second * 2}
 	client.RetryMax = 2
-	client.HTTPClient.Timeout = time.Second * 2
 	client.Logger = nil
-	response, err := client.Get(ts.url)
+	request, err := ts.newRequest(context.Background(), "GET", nil)
+	if err != nil {
+		return false, err.Error()
+	}
+	response, err := client.Do(request)
 	if err != nil {
 		return false, err.Error()
 	}
+	defer response.Body.Close()
 	if response.StatusCode != http.StatusOK {
 		body := strings.Builder{}
 		_, err := io.Copy(&body, response.Body)
 		if err != nil {
 			return false, fmt.Sprintf("could not read response body from tika server: %s", err.Error())
 		}
-		return false, fmt.Sprintf("tika server returned status code %d: %s", response.StatusCode, body)
+		return false, fmt.Sprintf("tika server returned status code %d: %s", response.StatusCode, body.String())
 	}
 	return true, ""
 }
@@ -0,0 +1,44 @@
+package extractors_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/extractors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTikaExtractText(t *testing.T) {
+	text := "ISBN 978-0-441-01359-3 " + strings.Repeat("spice ", 1000) + "printed with ISBN 978-0-13-468599-1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, text)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "dune.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("Dune"), 0644))
+	bk := book.Book{Filepath: path}
+
+	tika := extractors.NewTikaServer(&config.TikaConfig{Scheme: "http"}, strings.TrimPrefix(server.URL, "http://"), 0, http.DefaultClient)
+
+	// a document shorter than the limit is all there is, not an error
+	extracted, err := tika.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 100000})
+	assert.NoError(t, err)
+	assert.Equal(t, text, extracted)
+
+	extracted, err = tika.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 23})
+	assert.NoError(t, err)
+	assert.Equal(t, "ISBN 978-0-441-01359-3 ", extracted)
+
+	extracted, err = tika.ExtractText(context.Background(), &bk, extractors.TextLimit{Head: 22, Tail: 22})
+	assert.NoError(t, err)
+	assert.Equal(t, "ISBN 978-0-441-01359-3\nISBN 978-0-13-468599-1", extracted)
+}
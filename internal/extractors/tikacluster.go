@@ -0,0 +1,173 @@
+package extractors
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/service"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// tikaClusterRetries is how often a request is retried on one server before moving on to the next, when there is one
+const tikaClusterRetries = 3
+
+// TikaCluster spreads books across every configured Tika server in turn, skipping servers that are down and moving a
+// book on to the next server when its request fails
+type TikaCluster struct {
+	nodes              []*TikaServer
+	managed            *ManagedTika
+	health             *service.ServiceManager
+	next               atomic.Uint64
+	maxFileSize        int64
+	frontMatterBytes   int64
+	frontMatterFormats map[string]bool
+}
+
+func NewTikaCluster(conf *config.TikaConfig, client *http.Client) *TikaCluster {
+	endpoints := conf.Endpoints()
+	retries := 50
+	if len(endpoints) > 1 || (len(endpoints) == 1 && conf.Managed) {
+		retries = tikaClusterRetries
+	}
+
+	tc := &TikaCluster{
+		nodes:              make([]*TikaServer, 0, len(endpoints)+1),
+		health:             service.NewServiceManager(15 * time.Second),
+		maxFileSize:        int64(conf.MaxFileSize) * 1024 * 1024,
+		frontMatterBytes:   int64(conf.FrontMatterBytes),
+		frontMatterFormats: make(map[string]bool),
+	}
+	for _, format := range conf.FrontMatterFormats {
+		tc.frontMatterFormats[strings.ToLower(format)] = true
+	}
+	if conf.Managed {
+		tc.managed = NewManagedTika(conf, retries, client)
+		tc.nodes = append(tc.nodes, tc.managed.server)
+	}
+	for _, endpoint := range endpoints {
+		tc.nodes = append(tc.nodes, NewTikaServer(conf, endpoint, retries, client))
+	}
+	for _, node := range tc.nodes {
+		tc.health.Manage(node)
+	}
+	return tc
+}
+
+// Start launches the managed Tika server, if there is one
+func (tc *TikaCluster) Start() error {
+	if tc.managed == nil {
+		return nil
+	}
+	return tc.managed.Start()
+}
+
+func (tc *TikaCluster) Shutdown() {
+	tc.health.Close()
+	if tc.managed != nil {
+		tc.managed.Stop()
+	}
+}
+
+func (tc *TikaCluster) Name() string {
+	return "Tika"
+}
+
+// Accepts anything but files over the maximum size, those with only front matter to send are never too big
+func (tc *TikaCluster) Accepts(filePath string) bool {
+	if tc.maxFileSize == 0 || tc.frontMatterOnly(filePath) {
+		return true
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return true
+	}
+	if info.Size() > tc.maxFileSize {
+		slog.Debug("not sending file to tika, it is over tika.max_file_size", "path", filePath, "size", info.Size())
+		return false
+	}
+	return true
+}
+
+// frontMatterOnly is whether the file is in a format which can be cut off and still be read, so that only the front of
+// it needs to be sent
+func (tc *TikaCluster) frontMatterOnly(filePath string) bool {
+	return tc.frontMatterFormats[strings.ToLower(filepath.Ext(filePath))]
+}
+
+func (tc *TikaCluster) ExtractText(ctx context.Context, bk *book.Book, limit TextLimit) (string, error) {
+	fh, err := openForTika(bk)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	var lastErr error
+	start := tc.next.Add(1) - 1
+	for i := range tc.nodes {
+		node := tc.nodes[(start+uint64(i))%uint64(len(tc.nodes))]
+		if !tc.health.IsLive(node) {
+			continue
+		}
+
+		body, err := tc.upload(fh, bk, limit)
+		if err != nil {
+			return "", err
+		}
+		response, err := node.put(ctx, body)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", err
+			}
+			slog.Debug("tika server failed, trying the next one", "server", node.endpoint, "path", bk.Filepath, "error", err)
+			lastErr = err
+			continue
+		}
+		defer response.Body.Close()
+		return readTikaText(response, bk, limit)
+	}
+
+	if lastErr == nil {
+		return "", fmt.Errorf("error: no tika servers are up")
+	}
+	return "", lastErr
+}
+
+// upload is what is sent of the file, all of it or for the formats that can be cut off only its front, along with its
+// back if the end of the book is wanted
+func (tc *TikaCluster) upload(fh *os.File, bk *book.Book, limit TextLimit) (io.Reader, error) {
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error: tika unable to read file: %s: %s", bk.Filepath, err.Error())
+	}
+	size := info.Size()
+	n := tc.frontMatterBytes
+	if !tc.frontMatterOnly(bk.Filepath) || size <= n || (limit.Tail != 0 && size <= 2*n) {
+		return io.NewSectionReader(fh, 0, size), nil
+	}
+
+	front := io.NewSectionReader(fh, 0, n)
+	if limit.Tail == 0 {
+		return front, nil
+	}
+	return io.MultiReader(front, strings.NewReader("\n"), io.NewSectionReader(fh, size-n, n)), nil
+}
+
+func (tc *TikaCluster) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+// HealthCheck is up as long as one server is, the servers themselves are checked by the cluster's own ServiceManager
+func (tc *TikaCluster) HealthCheck() (bool, string) {
+	if len(tc.health.GetLiveServices()) == 0 {
+		return false, "no tika servers are up"
+	}
+	return true, ""
+}
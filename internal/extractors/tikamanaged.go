@@ -0,0 +1,195 @@
+package extractors
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// tikaReadyTimeout is how long a managed Tika server has to start answering, the first run of a container includes
+// pulling its image
+const tikaReadyTimeout = 5 * time.Minute
+
+const tikaJarUrl = "https://repo1.maven.org/maven2/org/apache/tika/tika-server-standard/%s/tika-server-standard-%s.jar"
+
+// ManagedTika is a Tika server Booker runs itself for the length of the scan, in a container or from the server jar
+type ManagedTika struct {
+	conf      *config.TikaConfig
+	server    *TikaServer
+	cmd       *exec.Cmd
+	container string
+	exited    chan struct{}
+	exitErr   error
+}
+
+// NewManagedTika prepares a Tika server listening on localhost at the configured port, which is launched by Start
+func NewManagedTika(conf *config.TikaConfig, retries int, client *http.Client) *ManagedTika {
+	endpoint := "127.0.0.1:" + strconv.Itoa(conf.Port)
+	// the server is local, so it is never behind the proxy the other hosts might be
+	local := config.TikaConfig{Scheme: "http"}
+	return &ManagedTika{
+		conf:   conf,
+		server: NewTikaServer(&local, endpoint, retries, client),
+		exited: make(chan struct{}),
+	}
+}
+
+// Start launches the server and waits until it answers
+func (mt *ManagedTika) Start() error {
+	conf := mt.conf
+	endpoint := mt.server.endpoint
+	switch conf.Launcher {
+	case "docker":
+		mt.container = "booker-tika-" + strconv.Itoa(conf.Port)
+		// a crashed run can leave its container behind holding the port
+		exec.Command("docker", "rm", "-f", mt.container).Run()
+		mt.cmd = exec.Command("docker", "run", "--rm", "--name", mt.container, "-p", endpoint+":9998", conf.Image)
+	case "java":
+		jar, err := tikaJar(conf, mt.server.client)
+		if err != nil {
+			return err
+		}
+		mt.cmd = exec.Command("java", "-jar", jar, "--host", "127.0.0.1", "--port", strconv.Itoa(conf.Port))
+	default:
+		return fmt.Errorf("error: unknown tika launcher %s", conf.Launcher)
+	}
+
+	output, input := io.Pipe()
+	mt.cmd.Stdout = input
+	mt.cmd.Stderr = input
+	detach(mt.cmd)
+
+	slog.Info("starting tika server", "launcher", conf.Launcher, "endpoint", endpoint)
+	err := mt.cmd.Start()
+	if err != nil {
+		mt.cmd = nil
+		return fmt.Errorf("error: unable to start tika server with %s: %s", conf.Launcher, err.Error())
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(output)
+		for scanner.Scan() {
+			slog.Debug("tika server output", "line", scanner.Text())
+		}
+	}()
+	go func() {
+		mt.exitErr = mt.cmd.Wait()
+		input.Close()
+		close(mt.exited)
+	}()
+
+	err = mt.waitUntilReady()
+	if err != nil {
+		mt.Stop()
+		return err
+	}
+	slog.Info("tika server is ready", "endpoint", endpoint)
+	return nil
+}
+
+func (mt *ManagedTika) waitUntilReady() error {
+	deadline := time.After(tikaReadyTimeout)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-mt.exited:
+			return fmt.Errorf("error: tika server exited before it was ready: %v, run with --verbose to see its output", mt.exitErr)
+		case <-deadline:
+			return fmt.Errorf("error: tika server was not ready after %s", tikaReadyTimeout)
+		case <-ticker.C:
+			if up, _ := mt.server.HealthCheck(); up {
+				return nil
+			}
+		}
+	}
+}
+
+// Stop shuts the server down, killing it if it hasn't exited after a while
+func (mt *ManagedTika) Stop() {
+	if mt.cmd == nil {
+		return
+	}
+	select {
+	case <-mt.exited:
+		return
+	default:
+	}
+
+	slog.Info("stopping tika server")
+	if len(mt.container) != 0 {
+		exec.Command("docker", "stop", mt.container).Run()
+	} else {
+		terminate(mt.cmd.Process)
+	}
+
+	select {
+	case <-mt.exited:
+	case <-time.After(15 * time.Second):
+		mt.cmd.Process.Kill()
+		<-mt.exited
+	}
+}
+
+// tikaJar is the configured server jar, or the configured version downloaded into the user's cache
+func tikaJar(conf *config.TikaConfig, client *http.Client) (string, error) {
+	if len(conf.Jar) != 0 {
+		return util.ExpandUser(conf.Jar), nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error: no cache directory to download the tika server into, set tika.jar instead: %s", err.Error())
+	}
+	jar := filepath.Join(cacheDir, "booker", fmt.Sprintf("tika-server-standard-%s.jar", conf.Version))
+	if exists, _ := util.PathExists(jar); exists {
+		return jar, nil
+	}
+
+	url := fmt.Sprintf(tikaJarUrl, conf.Version, conf.Version)
+	slog.Info("downloading tika server", "url", url, "path", jar)
+	err = download(client, url, jar)
+	if err != nil {
+		return "", fmt.Errorf("error: unable to download tika server %s: %s", conf.Version, err.Error())
+	}
+	return jar, nil
+}
+
+func download(client *http.Client, url string, filePath string) error {
+	response, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status code %d", url, response.StatusCode)
+	}
+
+	err = os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+	tmp := filePath + ".tmp"
+	fh, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fh, response.Body)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
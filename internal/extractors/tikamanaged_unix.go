@@ -0,0 +1,18 @@
+//go:build !windows
+
+package extractors
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach keeps the terminal's Ctrl-C from reaching the server, which has to outlive the books still in flight
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func terminate(process *os.Process) {
+	process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"syscall"
+)
+
+// fileId is the same for every path to a file or directory, through symlinks and bind mounts alike
+func fileId(path string, info fs.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino)
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return path
+	}
+	return real
+}
+
+// deviceId is the same for every file and directory on one file system
+func deviceId(_ string, info fs.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprint(stat.Dev)
+	}
+	return ""
+}
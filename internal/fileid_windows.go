@@ -0,0 +1,28 @@
+//go:build windows
+
+package internal
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// fileId is the same for every path to a file or directory through symlinks and junctions, which resolve to the same
+// real path. Paths are case-insensitive on Windows.
+func fileId(path string, _ fs.FileInfo) string {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return strings.ToLower(path)
+	}
+	return strings.ToLower(real)
+}
+
+// deviceId is the same for every file and directory on one volume, which is the drive or share its real path is on
+func deviceId(path string, _ fs.FileInfo) string {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		real = path
+	}
+	return strings.ToLower(filepath.VolumeName(real))
+}
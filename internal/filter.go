@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"path/filepath"
+	"slices"
+)
+
+// pathFilter decides which files and directories under a scan path are looked at, so that excluded directories are
+// never walked at all
+type pathFilter struct {
+	include []string
+	exclude []string
+}
+
+func newPathFilter(conf *config.ScanConfig) pathFilter {
+	return pathFilter{
+		include: conf.Include,
+		exclude: conf.Exclude,
+	}
+}
+
+func matchesAny(patterns []string, rel string, isDir bool) bool {
+	for _, pattern := range patterns {
+		if util.MatchPath(pattern, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// relativeTo returns filePath relative to root with forward slashes, which is what patterns are written against
+func relativeTo(root string, filePath string) string {
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil || rel == "." {
+		return filepath.Base(filePath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// skipDir reports whether the directory at dirPath under root should not be walked
+func (f *pathFilter) skipDir(root string, dirPath string) bool {
+	// the scan path itself was asked for explicitly
+	if dirPath == root {
+		return false
+	}
+	return matchesAny(f.exclude, relativeTo(root, dirPath), true)
+}
+
+// skipFile reports whether the file at filePath under root should not be processed
+func (f *pathFilter) skipFile(root string, filePath string) bool {
+	rel := relativeTo(root, filePath)
+	if matchesAny(f.exclude, rel, false) {
+		return true
+	}
+	return len(f.include) > 0 && !matchesAny(f.include, rel, false)
+}
+
+// RetryFilter picks the failed books of a cache that are processed again, the zero value picks every one of them
+type RetryFilter struct {
+	// ErrorCodes only picks books that failed with one of these codes
+	ErrorCodes []book.ErrorCode
+	// Paths only picks books whose paths under Root match one of these patterns, written like scan.include
+	Paths []string
+	Root  string
+}
+
+func (f *RetryFilter) matches(bk *book.Book) bool {
+	if len(bk.ErrorMessage) == 0 {
+		return false
+	}
+	// books from before error codes were recorded didn't say how they failed
+	code := bk.ErrorCode
+	if len(code) == 0 {
+		code = book.ErrorFailed
+	}
+	if len(f.ErrorCodes) > 0 && !slices.Contains(f.ErrorCodes, code) {
+		return false
+	}
+	return len(f.Paths) == 0 || matchesAny(f.Paths, relativeTo(f.Root, bk.Filepath), false)
+}
@@ -0,0 +1,93 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/larkwiot/booker/internal/config"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// New makes the client that the providers, Tika servers and cover downloads share, so they all go through the same
+// proxy, trust the same certificates and draw on one pool of connections. A configured proxy takes the place of the
+// HTTP_PROXY and HTTPS_PROXY environment variables, which are used otherwise.
+func New(conf *config.HttpConfig) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = conf.MaxIdleConnections
+	transport.MaxIdleConnsPerHost = conf.MaxIdleConnectionsPerHost
+	transport.MaxConnsPerHost = conf.MaxConnectionsPerHost
+
+	if len(conf.Proxy) > 0 {
+		proxy, err := url.Parse(conf.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("error: http.proxy is not a URL: %s", err.Error())
+		}
+		noProxy := conf.NoProxy
+		if noProxy == nil {
+			noProxy = strings.Split(os.Getenv("NO_PROXY")+","+os.Getenv("no_proxy"), ",")
+		}
+		transport.Proxy = func(request *http.Request) (*url.URL, error) {
+			if bypassesProxy(request.URL.Hostname(), noProxy) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+
+	if len(conf.CaBundle) > 0 {
+		bundle, err := os.ReadFile(conf.CaBundle)
+		if err != nil {
+			return nil, fmt.Errorf("error: could not read http.ca_bundle %s: %s", conf.CaBundle, err.Error())
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("error: http.ca_bundle %s has no PEM certificates in it", conf.CaBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(conf.TimeoutSeconds) * time.Second,
+	}, nil
+}
+
+// bypassesProxy reports whether a host is reached directly rather than through the proxy, which is the case for the
+// local machine and for the hosts and domains in noProxy, like "tika.internal" or ".example.com"
+func bypassesProxy(host string, noProxy []string) bool {
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+
+	host = strings.ToLower(host)
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "*" {
+			return true
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if len(domain) > 0 && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithoutTimeout is the client with no overall limit on how long a request may take, for requests like sending a whole
+// book to Tika that are rightly slow. They still share its proxy, certificates and connections.
+func WithoutTimeout(client *http.Client) *http.Client {
+	untimed := *client
+	untimed.Timeout = 0
+	return &untimed
+}
@@ -0,0 +1,56 @@
+package httpclient_test
+
+import (
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/httpclient"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewProxy(t *testing.T) {
+	client, err := httpclient.New(&config.HttpConfig{
+		Proxy:          "http://proxy.corp:3128",
+		NoProxy:        []string{".internal.corp", "tika"},
+		TimeoutSeconds: 60,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, client.Timeout)
+
+	proxy := client.Transport.(*http.Transport).Proxy
+	for host, proxied := range map[string]bool{
+		"www.googleapis.com":     true,
+		"localhost:9998":         false,
+		"127.0.0.1:9998":         false,
+		"tika:9998":              false,
+		"books.internal.corp":    false,
+		"internal.corp":          false,
+		"notinternal.corp":       true,
+		"tika.example.com:9998":  true,
+		"[::1]:9998":             false,
+		"covers.openlibrary.org": true,
+	} {
+		request, _ := http.NewRequest(http.MethodGet, "https://"+host+"/", nil)
+		through, err := proxy(request)
+		assert.NoError(t, err)
+		if proxied {
+			assert.Equal(t, "proxy.corp:3128", through.Host, host)
+		} else {
+			assert.Nil(t, through, host)
+		}
+	}
+
+	assert.Equal(t, time.Duration(0), httpclient.WithoutTimeout(client).Timeout)
+	assert.Equal(t, client.Transport, httpclient.WithoutTimeout(client).Transport)
+}
+
+func TestNewCaBundle(t *testing.T) {
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, os.WriteFile(bundle, []byte("not a certificate"), 0644))
+
+	_, err := httpclient.New(&config.HttpConfig{CaBundle: bundle})
+	assert.Error(t, err)
+}
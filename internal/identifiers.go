@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/pipeline"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/larkwiot/booker/internal/util"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Identifiers is what was found in a book before any provider was asked about it, which an extraction only run writes
+// out for a later run, maybe on another machine, to search from. A book that failed before then has its error instead.
+type Identifiers struct {
+	Filepath        string            `json:"filepath"`
+	Hash            string            `json:"hash,omitempty"`
+	Size            int64             `json:"size,omitempty"`
+	ModTime         int64             `json:"mtime,omitempty"`
+	DuplicateOf     string            `json:"duplicate_of,omitempty"`
+	Isbn10s         []book.ISBN10     `json:"isbn10s"`
+	Isbn13s         []book.ISBN13     `json:"isbn13s"`
+	UnsearchedIsbns []book.ISBN       `json:"unsearched_isbns,omitempty"`
+	Dois            []book.DOI        `json:"dois"`
+	Asins           []book.ASIN       `json:"asins"`
+	Arxivs          []book.ArxivId    `json:"arxivs"`
+	Title           string            `json:"title,omitempty"`
+	Author          string            `json:"author,omitempty"`
+	Year            uint              `json:"year,omitempty"`
+	Pages           uint              `json:"pages,omitempty"`
+	Language        string            `json:"language,omitempty"`
+	TextLanguage    string            `json:"text_language,omitempty"`
+	Lcc             string            `json:"lcc,omitempty"`
+	Ddc             string            `json:"ddc,omitempty"`
+	Snippet         string            `json:"snippet,omitempty"`
+	Embedded        []book.BookResult `json:"embedded,omitempty"`
+	ErrorMessage    string            `json:"error,omitempty"`
+	ErrorCode       book.ErrorCode    `json:"error_code,omitempty"`
+}
+
+// newIdentifiers is what was found in the book of job, which failed with err if it isn't nil
+func newIdentifiers(job *bookJob, err error) Identifiers {
+	ids := Identifiers{
+		Filepath:        job.book.Filepath,
+		Hash:            job.book.Hash,
+		Size:            job.book.Size,
+		ModTime:         job.book.ModTime,
+		DuplicateOf:     job.book.DuplicateOf,
+		Isbn10s:         job.search.Isbn10s,
+		Isbn13s:         job.search.Isbn13s,
+		UnsearchedIsbns: job.book.UnsearchedIsbns,
+		Dois:            job.search.Dois,
+		Asins:           job.search.Asins,
+		Arxivs:          job.search.Arxivs,
+		Title:           job.search.Title,
+		Author:          job.search.Author,
+		Year:            job.search.Year,
+		Pages:           job.search.Pages,
+		Language:        job.search.Language,
+		TextLanguage:    job.language,
+		Lcc:             job.lcc,
+		Ddc:             job.ddc,
+		Snippet:         job.snippet,
+		Embedded:        job.search.Embedded,
+	}
+	if err != nil {
+		ids.ErrorMessage = err.Error()
+		ids.ErrorCode = book.ErrorCodeOf(err)
+	}
+	return ids
+}
+
+// job picks the book back up where the run that found its identifiers left it
+func (ids *Identifiers) job() bookJob {
+	bk := book.Book{
+		Filepath:        ids.Filepath,
+		Hash:            ids.Hash,
+		Size:            ids.Size,
+		ModTime:         ids.ModTime,
+		DuplicateOf:     ids.DuplicateOf,
+		UnsearchedIsbns: ids.UnsearchedIsbns,
+	}
+	search := providers.SearchTerms{
+		Isbn10s:  ids.Isbn10s,
+		Isbn13s:  ids.Isbn13s,
+		Dois:     ids.Dois,
+		Asins:    ids.Asins,
+		Arxivs:   ids.Arxivs,
+		Title:    ids.Title,
+		Author:   ids.Author,
+		Year:     ids.Year,
+		Pages:    ids.Pages,
+		Filepath: ids.Filepath,
+		Embedded: slices.Clone(ids.Embedded),
+		Language: ids.Language,
+	}
+	for idx := range search.Embedded {
+		search.Embedded[idx].Filepath = ids.Filepath
+	}
+	return bookJob{book: bk, search: search, snippet: ids.Snippet, language: ids.TextLanguage, lcc: ids.Lcc, ddc: ids.Ddc}
+}
+
+// SetExtractOnly has books stop once their identifiers are found, which are written to writer instead of being searched
+func (bm *BookManager) SetExtractOnly(writer util.ObjectWriter[*Identifiers]) {
+	bm.identifiersWriter = writer
+}
+
+// stopsBeforeSearch reports whether books are written out before any provider is asked about them, on a dry run or
+// an extraction only run
+func (bm *BookManager) stopsBeforeSearch() bool {
+	return bm.IsDryRun() || bm.identifiersWriter != nil
+}
+
+// stopBeforeSearch is what is written out for a book that stops before it is searched, which failed with err if it
+// isn't nil
+func (bm *BookManager) stopBeforeSearch(job *bookJob, err error) any {
+	ids := newIdentifiers(job, err)
+	if bm.IsDryRun() {
+		return bm.planSearch(job, ids)
+	}
+	return ids
+}
+
+// resumeIdentifiers continues a book from the identifiers an earlier run found in it, unless that run failed it
+func (bm *BookManager) resumeIdentifiers(ids Identifiers) (any, error) {
+	job := ids.job()
+	bm.stats.Start(job.book.Filepath)
+	if len(ids.ErrorMessage) > 0 {
+		return job.book, book.NewError(ids.ErrorCode, errors.New(ids.ErrorMessage))
+	}
+	if cached, ok := bm.cachedByHash(job.book); ok {
+		return pipeline.Complete(cached), nil
+	}
+	return job, nil
+}
+
+// ScanIdentifiers searches the books of the output of an extraction only run, instead of finding their identifiers
+// again. Books that were already processed are skipped, like with Scan.
+func (bm *BookManager) ScanIdentifiers(ctx context.Context, identifiersPath string, dryRun bool, writer util.ObjectWriter[*book.Book]) error {
+	data, err := util.ReadFile(util.ExpandUser(identifiersPath))
+	if err != nil {
+		return fmt.Errorf("error: could not read identifiers %s: %s", identifiersPath, err.Error())
+	}
+	var entries map[string]Identifiers
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return fmt.Errorf("error: could not parse identifiers %s: %s", identifiersPath, err.Error())
+	}
+
+	bm.Start(ctx, dryRun, writer)
+
+	slog.Info("beginning search of extracted identifiers", "path", identifiersPath, "books", len(entries))
+
+	pending := make([]Identifiers, 0, len(entries))
+	for _, ids := range entries {
+		bm.stats.Discover()
+		if bm.isBookProcessed(ids.Filepath) {
+			bm.stats.CacheHit()
+			continue
+		}
+		pending = append(pending, ids)
+	}
+	// in the order of their paths, like a scan walks them
+	slices.SortFunc(pending, func(a, b Identifiers) int {
+		return strings.Compare(a.Filepath, b.Filepath)
+	})
+	bm.pipe.Discover(int64(len(pending)))
+
+	for _, ids := range pending {
+		if !bm.pipe.Submit(ids) {
+			break
+		}
+	}
+
+	return bm.finishScan(false)
+}
@@ -0,0 +1,116 @@
+package intake
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/config"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// reconnectWait is how long to wait before connecting to a queue server that went away again
+const reconnectWait = 5 * time.Second
+
+// dialTimeout is how long connecting to a queue server may take
+const dialTimeout = 10 * time.Second
+
+// queue is a connection to a queue server that paths are taken from
+type queue interface {
+	// next waits for the next path until ctx is cancelled, an error other than that means the connection is broken
+	next(ctx context.Context) (string, error)
+	close() error
+}
+
+// Intake hands out the paths of books as they arrive on a Redis list or NATS subject, connecting again whenever the
+// server goes away
+type Intake struct {
+	conf     config.IntakeConfig
+	endpoint *url.URL
+	conn     queue
+}
+
+// Open connects to the queue server of conf, so that a misconfigured one is found before anything is processed
+func Open(ctx context.Context, conf config.IntakeConfig) (*Intake, error) {
+	endpoint, err := url.Parse(conf.Url)
+	if err != nil {
+		return nil, fmt.Errorf("error: intake.url is invalid: %s", err.Error())
+	}
+	i := &Intake{conf: conf, endpoint: endpoint}
+	i.conn, err = i.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error: could not connect to intake %s: %s", i, err.Error())
+	}
+	return i, nil
+}
+
+func (i *Intake) dial(ctx context.Context) (queue, error) {
+	switch i.endpoint.Scheme {
+	case "redis":
+		return dialRedis(ctx, i.endpoint, i.conf.Key)
+	case "nats":
+		return dialNats(ctx, i.endpoint, i.conf.Key, i.conf.QueueGroup)
+	}
+	return nil, fmt.Errorf("unsupported scheme %s", i.endpoint.Scheme)
+}
+
+// String is the server and the list or subject paths are taken from, without any password
+func (i *Intake) String() string {
+	return fmt.Sprintf("%s %s", i.endpoint.Redacted(), i.conf.Key)
+}
+
+// Next waits for the next path that isn't blank, until ctx is cancelled
+func (i *Intake) Next(ctx context.Context) (string, error) {
+	for {
+		if i.conn == nil {
+			conn, err := i.dial(ctx)
+			if err != nil {
+				if ctx.Err() != nil {
+					return "", ctx.Err()
+				}
+				slog.Warn("could not connect to intake, trying again", "intake", i.String(), "error", err, "wait", reconnectWait)
+				if !wait(ctx, reconnectWait) {
+					return "", ctx.Err()
+				}
+				continue
+			}
+			slog.Info("connected to intake again", "intake", i.String())
+			i.conn = conn
+		}
+
+		path, err := i.conn.next(ctx)
+		if err == nil {
+			if path = strings.TrimSpace(path); len(path) > 0 {
+				return path, nil
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		slog.Warn("lost connection to intake", "intake", i.String(), "error", err)
+		i.conn.close()
+		i.conn = nil
+	}
+}
+
+// Close disconnects from the queue server
+func (i *Intake) Close() error {
+	if i.conn == nil {
+		return nil
+	}
+	err := i.conn.close()
+	i.conn = nil
+	return err
+}
+
+// wait reports whether d passed before ctx was cancelled
+func wait(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,161 @@
+package intake_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/intake"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve accepts a single connection on a local port and hands it to handle
+func serve(t *testing.T, handle func(conn net.Conn, reader *bufio.Reader)) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn, bufio.NewReader(conn))
+	}()
+	return listener.Addr().String()
+}
+
+// readCommand reads a Redis command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, 0, count)
+	for range count {
+		if _, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(arg, "\r\n"))
+	}
+	return args, nil
+}
+
+func TestRedis(t *testing.T) {
+	commands := make(chan []string, 10)
+	list := []string{"/books/a.epub", "  ", "/books/b.pdf\n"}
+	address := serve(t, func(conn net.Conn, reader *bufio.Reader) {
+		for {
+			args, err := readCommand(reader)
+			if err != nil {
+				return
+			}
+			select {
+			case commands <- args:
+			default:
+			}
+			if args[0] != "BLPOP" {
+				fmt.Fprint(conn, "+OK\r\n")
+				continue
+			}
+			if len(list) == 0 {
+				fmt.Fprint(conn, "*-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(list[0]), list[0])
+			list = list[1:]
+		}
+	})
+
+	source, err := intake.Open(context.Background(), config.IntakeConfig{Url: "redis://:secret@" + address + "/2", Key: "booker:paths"})
+	assert.NoError(t, err)
+	defer source.Close()
+	assert.Equal(t, []string{"AUTH", "secret"}, <-commands)
+	assert.Equal(t, []string{"SELECT", "2"}, <-commands)
+	assert.NotContains(t, source.String(), "secret")
+
+	path, err := source.Next(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "/books/a.epub", path)
+	assert.Equal(t, []string{"BLPOP", "booker:paths", "1"}, <-commands)
+
+	// blank paths are skipped
+	path, err = source.Next(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "/books/b.pdf", path)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = source.Next(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRedisRefused(t *testing.T) {
+	address := serve(t, func(conn net.Conn, reader *bufio.Reader) {
+		readCommand(reader)
+		fmt.Fprint(conn, "-WRONGPASS invalid username-password pair\r\n")
+	})
+
+	_, err := intake.Open(context.Background(), config.IntakeConfig{Url: "redis://:wrong@" + address, Key: "booker:paths"})
+	assert.ErrorContains(t, err, "WRONGPASS")
+}
+
+func TestNats(t *testing.T) {
+	lines := make(chan string, 10)
+	address := serve(t, func(conn net.Conn, reader *bufio.Reader) {
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\r\n")
+			lines <- line
+			switch {
+			case line == "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+				// the client must answer pings while it waits for messages
+				fmt.Fprint(conn, "PING\r\n")
+			case line == "PONG":
+				fmt.Fprint(conn, "MSG books.new 1 13\r\n/books/a.epub\r\n")
+				fmt.Fprint(conn, "MSG books.new 1 _INBOX.reply 12\r\n/books/b.pdf\r\n")
+			}
+		}
+	})
+
+	source, err := intake.Open(context.Background(), config.IntakeConfig{Url: "nats://user:pass@" + address, Key: "books.new", QueueGroup: "booker"})
+	assert.NoError(t, err)
+	defer source.Close()
+	assert.Contains(t, <-lines, `"user":"user","pass":"pass"`)
+	assert.Equal(t, "SUB books.new booker 1", <-lines)
+
+	path, err := source.Next(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "/books/a.epub", path)
+	path, err = source.Next(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "/books/b.pdf", path)
+	assert.Equal(t, "PING", <-lines)
+	assert.Equal(t, "PONG", <-lines)
+}
+
+func TestNatsRefused(t *testing.T) {
+	address := serve(t, func(conn net.Conn, reader *bufio.Reader) {
+		fmt.Fprint(conn, "INFO {\"auth_required\":true}\r\n")
+		reader.ReadString('\n')
+		fmt.Fprint(conn, "-ERR 'Authorization Violation'\r\n")
+	})
+
+	_, err := intake.Open(context.Background(), config.IntakeConfig{Url: "nats://" + address, Key: "books.new"})
+	assert.ErrorContains(t, err, "Authorization Violation")
+}
@@ -0,0 +1,138 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// console is stderr, shared by log records and the progress line so that neither garbles the other
+type console struct {
+	lock         sync.Mutex
+	out          io.Writer
+	showProgress bool
+	progress     string
+}
+
+var stderr = &console{out: os.Stderr}
+
+func (c *console) clear() {
+	if len(c.progress) > 0 {
+		fmt.Fprintf(c.out, "\r%s\r", strings.Repeat(" ", len(c.progress)))
+	}
+}
+
+// Write writes a log record above the progress line, which is drawn again after it
+func (c *console) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.clear()
+	n, err := c.out.Write(p)
+	if len(c.progress) > 0 {
+		fmt.Fprint(c.out, c.progress)
+	}
+	return n, err
+}
+
+// Progress replaces the progress line, it is only drawn when stderr is a terminal and progress isn't hidden
+func Progress(line string) {
+	stderr.lock.Lock()
+	defer stderr.lock.Unlock()
+
+	if !stderr.showProgress {
+		return
+	}
+	stderr.clear()
+	stderr.progress = line
+	fmt.Fprint(stderr.out, line)
+}
+
+// ClearProgress erases the progress line
+func ClearProgress() {
+	stderr.lock.Lock()
+	defer stderr.lock.Unlock()
+
+	stderr.clear()
+	stderr.progress = ""
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// fanout sends every record to each of its handlers that is enabled for it
+type fanout []slog.Handler
+
+func (f fanout) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range f {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f fanout) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range f {
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f fanout) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanout, 0, len(f))
+	for _, handler := range f {
+		handlers = append(handlers, handler.WithAttrs(attrs))
+	}
+	return handlers
+}
+
+func (f fanout) WithGroup(name string) slog.Handler {
+	handlers := make(fanout, 0, len(f))
+	for _, handler := range f {
+		handlers = append(handlers, handler.WithGroup(name))
+	}
+	return handlers
+}
+
+// Setup makes slog write records at level or above to stderr, and to logFile as JSON lines if it is given. quiet hides
+// the progress line as well. The returned function closes the log file.
+func Setup(level slog.Level, quiet bool, logFile string) (func(), error) {
+	stderr.showProgress = !quiet && isTerminal(os.Stderr)
+
+	handlers := fanout{slog.NewTextHandler(stderr, &slog.HandlerOptions{
+		Level: level,
+		// the time is only worth the space in the log file
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})}
+
+	closeLog := func() {}
+	if len(logFile) > 0 {
+		fh, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+		if err != nil {
+			return nil, fmt.Errorf("error: could not open log file %s: %s", logFile, err.Error())
+		}
+		handlers = append(handlers, slog.NewJSONHandler(fh, &slog.HandlerOptions{Level: level}))
+		closeLog = func() {
+			fh.Close()
+		}
+	}
+
+	slog.SetDefault(slog.New(handlers))
+	return closeLog, nil
+}
@@ -0,0 +1,263 @@
+package opds
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/larkwiot/booker/internal/book"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	AtomMediaType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	JsonMediaType = "application/opds+json"
+
+	acquisitionRel = "http://opds-spec.org/acquisition"
+)
+
+var mediaTypes = map[string]string{
+	".azw3": "application/vnd.amazon.ebook",
+	".cbz":  "application/vnd.comicbook+zip",
+	".djvu": "image/vnd.djvu",
+	".epub": "application/epub+zip",
+	".mobi": "application/x-mobipocket-ebook",
+	".pdf":  "application/pdf",
+	".txt":  "text/plain",
+}
+
+// MediaType guesses the media type of a book from its extension
+func MediaType(filePath string) string {
+	if mediaType, exists := mediaTypes[strings.ToLower(filepath.Ext(filePath))]; exists {
+		return mediaType
+	}
+	return "application/octet-stream"
+}
+
+// Catalog is a single acquisition feed listing every book that has metadata
+type Catalog struct {
+	Title    string
+	Updated  time.Time
+	SelfHref string
+	Books    []book.Book
+	// Href returns where a book can be downloaded from, books it returns an empty string for are left out
+	Href func(bk *book.Book) string
+}
+
+type entry struct {
+	book *book.Book
+	href string
+}
+
+// entries returns the books to list, sorted by title
+func (c *Catalog) entries() []entry {
+	entries := make([]entry, 0, len(c.Books))
+	for idx := range c.Books {
+		bk := &c.Books[idx]
+		if len(bk.ErrorMessage) > 0 || len(bk.Title) == 0 {
+			continue
+		}
+		href := c.Href(bk)
+		if len(href) == 0 {
+			continue
+		}
+		entries = append(entries, entry{book: bk, href: href})
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		if order := strings.Compare(strings.ToLower(a.book.Title), strings.ToLower(b.book.Title)); order != 0 {
+			return order
+		}
+		return strings.Compare(a.book.Filepath, b.book.Filepath)
+	})
+	return entries
+}
+
+// bookId is a URN for the book, by ISBN when possible so that readers recognize the same book from other catalogs
+func bookId(bk *book.Book) string {
+	if len(bk.Isbn13) > 0 {
+		return "urn:isbn:" + string(bk.Isbn13)
+	}
+	if len(bk.Isbn10) > 0 {
+		return "urn:isbn:" + string(bk.Isbn10)
+	}
+	if len(bk.Doi) > 0 {
+		return "urn:doi:" + string(bk.Doi)
+	}
+	if len(bk.Hash) > 0 {
+		return "urn:sha256:" + bk.Hash
+	}
+	return "urn:booker:" + bk.Filepath
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	Id         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Summary    string         `xml:"summary,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Links      []atomLink     `xml:"link"`
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDc   string      `xml:"xmlns:dc,attr"`
+	XmlnsOpds string      `xml:"xmlns:opds,attr"`
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+// Atom renders the catalog as an OPDS 1.2 Atom feed
+func (c *Catalog) Atom() ([]byte, error) {
+	updated := c.Updated.UTC().Format(time.RFC3339)
+
+	feed := atomFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDc:   "http://purl.org/dc/terms/",
+		XmlnsOpds: "http://opds-spec.org/2010/catalog",
+		Id:        "urn:booker:catalog",
+		Title:     c.Title,
+		Updated:   updated,
+		Author:    atomAuthor{Name: "booker"},
+		Links: []atomLink{
+			{Rel: "self", Href: c.SelfHref, Type: AtomMediaType},
+			{Rel: "start", Href: c.SelfHref, Type: AtomMediaType},
+		},
+	}
+
+	for _, e := range c.entries() {
+		atom := atomEntry{
+			Title:     e.book.Title,
+			Id:        bookId(e.book),
+			Updated:   updated,
+			Publisher: e.book.Publisher,
+			Issued:    e.book.PublishDate,
+			Language:  e.book.Language,
+			Summary:   e.book.Description,
+			Links:     []atomLink{{Rel: acquisitionRel, Href: e.href, Type: MediaType(e.book.Filepath)}},
+		}
+		for _, author := range e.book.Authors {
+			atom.Authors = append(atom.Authors, atomAuthor{Name: author})
+		}
+		for _, subject := range e.book.Subjects {
+			atom.Categories = append(atom.Categories, atomCategory{Term: subject, Label: subject})
+		}
+		feed.Entries = append(feed.Entries, atom)
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+type jsonLink struct {
+	Rel  string `json:"rel"`
+	Href string `json:"href"`
+	Type string `json:"type"`
+}
+
+type jsonContributor struct {
+	Name string `json:"name"`
+}
+
+type jsonSeries struct {
+	Name     string  `json:"name"`
+	Position float64 `json:"position,omitempty"`
+}
+
+type jsonBelongsTo struct {
+	Series []jsonSeries `json:"series"`
+}
+
+type jsonMetadata struct {
+	Type        string            `json:"@type"`
+	Title       string            `json:"title"`
+	Identifier  string            `json:"identifier"`
+	Authors     []jsonContributor `json:"author,omitempty"`
+	Publisher   string            `json:"publisher,omitempty"`
+	Published   string            `json:"published,omitempty"`
+	Language    string            `json:"language,omitempty"`
+	BelongsTo   *jsonBelongsTo    `json:"belongsTo,omitempty"`
+	Subjects    []string          `json:"subject,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Modified    string            `json:"modified"`
+}
+
+type jsonPublication struct {
+	Metadata jsonMetadata `json:"metadata"`
+	Links    []jsonLink   `json:"links"`
+}
+
+type jsonFeed struct {
+	Metadata struct {
+		Title    string `json:"title"`
+		Modified string `json:"modified"`
+	} `json:"metadata"`
+	Links        []jsonLink        `json:"links"`
+	Publications []jsonPublication `json:"publications"`
+}
+
+// Json renders the catalog as an OPDS 2.0 feed
+func (c *Catalog) Json() ([]byte, error) {
+	updated := c.Updated.UTC().Format(time.RFC3339)
+
+	feed := jsonFeed{
+		Links:        []jsonLink{{Rel: "self", Href: c.SelfHref, Type: JsonMediaType}},
+		Publications: make([]jsonPublication, 0),
+	}
+	feed.Metadata.Title = c.Title
+	feed.Metadata.Modified = updated
+
+	for _, e := range c.entries() {
+		publication := jsonPublication{
+			Metadata: jsonMetadata{
+				Type:        "http://schema.org/Book",
+				Title:       e.book.Title,
+				Identifier:  bookId(e.book),
+				Publisher:   e.book.Publisher,
+				Published:   e.book.PublishDate,
+				Language:    e.book.Language,
+				Subjects:    e.book.Subjects,
+				Description: e.book.Description,
+				Modified:    updated,
+			},
+			Links: []jsonLink{{Rel: acquisitionRel, Href: e.href, Type: MediaType(e.book.Filepath)}},
+		}
+		if len(e.book.Series) > 0 {
+			publication.Metadata.BelongsTo = &jsonBelongsTo{Series: []jsonSeries{{Name: e.book.Series, Position: e.book.SeriesIndex}}}
+		}
+		for _, author := range e.book.Authors {
+			publication.Metadata.Authors = append(publication.Metadata.Authors, jsonContributor{Name: author})
+		}
+		feed.Publications = append(feed.Publications, publication)
+	}
+
+	return json.MarshalIndent(feed, "", "  ")
+}
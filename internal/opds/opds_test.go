@@ -0,0 +1,91 @@
+package opds_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/opds"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newCatalog() opds.Catalog {
+	return opds.Catalog{
+		Title:    "Test Library",
+		Updated:  time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		SelfHref: "catalog.xml",
+		Books: []book.Book{
+			{Title: "Zen & Go", Authors: []string{"Ann Author"}, Isbn13: "9781718501263", Filepath: "/books/zen.epub"},
+			{Title: "Algorithms", Publisher: "No Starch Press", Hash: "abc", Filepath: "/books/algorithms.pdf"},
+			{Title: "", Filepath: "/books/failed.pdf", ErrorMessage: "no results"},
+		},
+		Href: func(bk *book.Book) string {
+			return filepath.Base(bk.Filepath)
+		},
+	}
+}
+
+func TestAtom(t *testing.T) {
+	catalog := newCatalog()
+	data, err := catalog.Atom()
+	assert.NoError(t, err)
+
+	var feed struct {
+		Updated string `xml:"updated"`
+		Entries []struct {
+			Title   string   `xml:"title"`
+			Id      string   `xml:"id"`
+			Authors []string `xml:"author>name"`
+			Links   []struct {
+				Rel  string `xml:"rel,attr"`
+				Href string `xml:"href,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"link"`
+		} `xml:"entry"`
+	}
+	assert.NoError(t, xml.Unmarshal(data, &feed))
+
+	assert.Equal(t, "2024-03-01T12:00:00Z", feed.Updated)
+	assert.Len(t, feed.Entries, 2)
+	assert.Equal(t, "Algorithms", feed.Entries[0].Title)
+	assert.Equal(t, "urn:sha256:abc", feed.Entries[0].Id)
+	assert.Equal(t, "application/pdf", feed.Entries[0].Links[0].Type)
+	assert.Equal(t, "Zen & Go", feed.Entries[1].Title)
+	assert.Equal(t, "urn:isbn:9781718501263", feed.Entries[1].Id)
+	assert.Equal(t, []string{"Ann Author"}, feed.Entries[1].Authors)
+	assert.Equal(t, "http://opds-spec.org/acquisition", feed.Entries[1].Links[0].Rel)
+	assert.Equal(t, "zen.epub", feed.Entries[1].Links[0].Href)
+}
+
+func TestJson(t *testing.T) {
+	catalog := newCatalog()
+	// books without a link are left out
+	catalog.Href = func(bk *book.Book) string {
+		if len(bk.Hash) == 0 {
+			return ""
+		}
+		return "/books/" + bk.Hash + "/file"
+	}
+	data, err := catalog.Json()
+	assert.NoError(t, err)
+
+	var feed struct {
+		Publications []struct {
+			Metadata struct {
+				Title     string `json:"title"`
+				Publisher string `json:"publisher"`
+			} `json:"metadata"`
+			Links []struct {
+				Href string `json:"href"`
+			} `json:"links"`
+		} `json:"publications"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &feed))
+
+	assert.Len(t, feed.Publications, 1)
+	assert.Equal(t, "Algorithms", feed.Publications[0].Metadata.Title)
+	assert.Equal(t, "No Starch Press", feed.Publications[0].Metadata.Publisher)
+	assert.Equal(t, "/books/abc/file", feed.Publications[0].Links[0].Href)
+}
@@ -0,0 +1,301 @@
+package pdf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func isWhitespace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	default:
+		return false
+	}
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	default:
+		return false
+	}
+}
+
+// skipSpace skips whitespace and comments starting at i
+func skipSpace(data []byte, i int) int {
+	for i < len(data) {
+		if isWhitespace(data[i]) {
+			i++
+			continue
+		}
+		if data[i] == '%' {
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+			continue
+		}
+		break
+	}
+	return i
+}
+
+// readToken returns the end of the regular token (a number, keyword, or boolean) starting at i
+func readToken(data []byte, i int) int {
+	for i < len(data) && !isWhitespace(data[i]) && !isDelimiter(data[i]) {
+		i++
+	}
+	return i
+}
+
+func isInteger(token []byte) bool {
+	if len(token) == 0 {
+		return false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// readValue returns the start and end of the value starting at or after i. An indirect reference like "12 0 R" is
+// read as a single value
+func readValue(data []byte, i int) (int, int, error) {
+	i = skipSpace(data, i)
+	if i >= len(data) {
+		return 0, 0, fmt.Errorf("pdf: unexpected end of data")
+	}
+	start := i
+
+	switch {
+	case data[i] == '<' && i+1 < len(data) && data[i+1] == '<':
+		i += 2
+		for {
+			i = skipSpace(data, i)
+			if i+1 >= len(data) {
+				return 0, 0, fmt.Errorf("pdf: unterminated dictionary")
+			}
+			if data[i] == '>' && data[i+1] == '>' {
+				return start, i + 2, nil
+			}
+			_, end, err := readValue(data, i)
+			if err != nil {
+				return 0, 0, err
+			}
+			i = end
+		}
+	case data[i] == '<':
+		end := strings.IndexByte(string(data[i:]), '>')
+		if end < 0 {
+			return 0, 0, fmt.Errorf("pdf: unterminated hex string")
+		}
+		return start, i + end + 1, nil
+	case data[i] == '(':
+		depth := 0
+		for ; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					return start, i + 1, nil
+				}
+			}
+		}
+		return 0, 0, fmt.Errorf("pdf: unterminated string")
+	case data[i] == '[':
+		i++
+		for {
+			i = skipSpace(data, i)
+			if i >= len(data) {
+				return 0, 0, fmt.Errorf("pdf: unterminated array")
+			}
+			if data[i] == ']' {
+				return start, i + 1, nil
+			}
+			_, end, err := readValue(data, i)
+			if err != nil {
+				return 0, 0, err
+			}
+			i = end
+		}
+	case data[i] == '/':
+		return start, readToken(data, i+1), nil
+	case isDelimiter(data[i]):
+		return 0, 0, fmt.Errorf("pdf: unexpected %q", data[i])
+	}
+
+	end := readToken(data, i)
+	if !isInteger(data[start:end]) {
+		return start, end, nil
+	}
+
+	// an integer may be the start of an indirect reference
+	genStart := skipSpace(data, end)
+	genEnd := readToken(data, genStart)
+	if !isInteger(data[genStart:genEnd]) {
+		return start, end, nil
+	}
+	rStart := skipSpace(data, genEnd)
+	rEnd := readToken(data, rStart)
+	if string(data[rStart:rEnd]) != "R" {
+		return start, end, nil
+	}
+	return start, rEnd, nil
+}
+
+type Ref struct {
+	Num int
+	Gen int
+}
+
+func (r Ref) String() string {
+	return fmt.Sprintf("%d %d R", r.Num, r.Gen)
+}
+
+// ParseRef parses an indirect reference like "12 0 R"
+func ParseRef(value string) (Ref, bool) {
+	fields := strings.Fields(value)
+	if len(fields) != 3 || fields[2] != "R" {
+		return Ref{}, false
+	}
+	num, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Ref{}, false
+	}
+	gen, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Ref{}, false
+	}
+	return Ref{Num: num, Gen: gen}, true
+}
+
+type dictEntry struct {
+	key   string
+	value string
+}
+
+// Dict is a dictionary kept as raw PDF syntax, so entries that aren't touched are written back exactly as they were
+type Dict struct {
+	entries []dictEntry
+}
+
+func NewDict() *Dict {
+	return &Dict{}
+}
+
+// ParseDict parses a dictionary like "<< /Type /Catalog /Pages 2 0 R >>"
+func ParseDict(raw []byte) (*Dict, error) {
+	i := skipSpace(raw, 0)
+	if i+1 >= len(raw) || raw[i] != '<' || raw[i+1] != '<' {
+		return nil, fmt.Errorf("pdf: not a dictionary")
+	}
+	i += 2
+
+	d := &Dict{}
+	for {
+		i = skipSpace(raw, i)
+		if i+1 >= len(raw) {
+			return nil, fmt.Errorf("pdf: unterminated dictionary")
+		}
+		if raw[i] == '>' && raw[i+1] == '>' {
+			return d, nil
+		}
+		if raw[i] != '/' {
+			return nil, fmt.Errorf("pdf: dictionary key is not a name")
+		}
+		keyEnd := readToken(raw, i+1)
+		key := string(raw[i+1 : keyEnd])
+
+		valueStart, valueEnd, err := readValue(raw, keyEnd)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = append(d.entries, dictEntry{key: key, value: string(raw[valueStart:valueEnd])})
+		i = valueEnd
+	}
+}
+
+// Get returns the raw value of key, which is given without the leading slash
+func (d *Dict) Get(key string) (string, bool) {
+	for _, entry := range d.entries {
+		if entry.key == key {
+			return entry.value, true
+		}
+	}
+	return "", false
+}
+
+func (d *Dict) GetInt(key string) (int, bool) {
+	value, exists := d.Get(key)
+	if !exists {
+		return 0, false
+	}
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+func (d *Dict) GetRef(key string) (Ref, bool) {
+	value, exists := d.Get(key)
+	if !exists {
+		return Ref{}, false
+	}
+	return ParseRef(value)
+}
+
+// Set replaces the raw value of key, adding it if it doesn't exist yet
+func (d *Dict) Set(key string, value string) {
+	for idx := range d.entries {
+		if d.entries[idx].key == key {
+			d.entries[idx].value = value
+			return
+		}
+	}
+	d.entries = append(d.entries, dictEntry{key: key, value: value})
+}
+
+func (d *Dict) Delete(key string) {
+	for idx := range d.entries {
+		if d.entries[idx].key == key {
+			d.entries = append(d.entries[:idx], d.entries[idx+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Dict) Clone() *Dict {
+	return &Dict{entries: append([]dictEntry{}, d.entries...)}
+}
+
+func (d *Dict) String() string {
+	var s strings.Builder
+	s.WriteString("<<")
+	for _, entry := range d.entries {
+		s.WriteString(fmt.Sprintf(" /%s %s", entry.key, entry.value))
+	}
+	s.WriteString(" >>")
+	return s.String()
+}
+
+// EncodeString encodes s as a UTF-16BE hex string, which holds any text regardless of the PDF's own encodings
+func EncodeString(s string) string {
+	var encoded strings.Builder
+	encoded.WriteString("<FEFF")
+	for _, r := range s {
+		if r > 0xFFFF {
+			r -= 0x10000
+			encoded.WriteString(fmt.Sprintf("%04X%04X", 0xD800+(r>>10), 0xDC00+(r&0x3FF)))
+			continue
+		}
+		encoded.WriteString(fmt.Sprintf("%04X", r))
+	}
+	encoded.WriteString(">")
+	return encoded.String()
+}
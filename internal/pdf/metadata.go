@@ -0,0 +1,98 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// pdfDocEncoding holds the characters of PDFDocEncoding that differ from Latin-1
+var pdfDocEncoding = map[byte]rune{
+	0x80: '•', 0x81: '†', 0x82: '‡', 0x83: '…', 0x84: '—', 0x85: '–', 0x86: 'ƒ', 0x87: '⁄',
+	0x88: '‹', 0x89: '›', 0x8A: '−', 0x8B: '‰', 0x8C: '„', 0x8D: '“', 0x8E: '”', 0x8F: '‘',
+	0x90: '’', 0x91: '‚', 0x92: '™', 0x93: 'ﬁ', 0x94: 'ﬂ', 0x95: 'Ł', 0x96: 'Œ', 0x97: 'Š',
+	0x98: 'Ÿ', 0x99: 'Ž', 0x9A: 'ı', 0x9B: 'ł', 0x9C: 'œ', 0x9D: 'š', 0x9E: 'ž', 0xA0: '€',
+}
+
+// DecodeString decodes a literal or hex string used as text, like the entries of the Info dictionary, which are
+// either UTF-16BE with a byte order mark, UTF-8 with one, or PDFDocEncoding
+func DecodeString(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == 0 || (raw[0] != '(' && raw[0] != '<') || strings.HasPrefix(raw, "<<") {
+		return "", fmt.Errorf("pdf: %s is not a string", raw)
+	}
+
+	tok, _ := newContentLexer([]byte(raw)).next()
+	data := tok.bytes
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return decodeUtf16(data[2:]), nil
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}) && utf8.Valid(data[3:]):
+		return string(data[3:]), nil
+	}
+
+	var s strings.Builder
+	for _, b := range data {
+		if r, exists := pdfDocEncoding[b]; exists {
+			s.WriteRune(r)
+			continue
+		}
+		s.WriteRune(rune(b))
+	}
+	return s.String(), nil
+}
+
+// Info returns the text entries of the document information dictionary, like Title and Author
+func (f *File) Info() map[string]string {
+	info := make(map[string]string)
+
+	raw, exists := f.trailer.Get("Info")
+	if !exists {
+		return info
+	}
+	dict, err := f.resolveDict(raw)
+	if err != nil {
+		return info
+	}
+
+	for _, entry := range dict.entries {
+		obj, err := f.resolve(entry.value)
+		if err != nil {
+			continue
+		}
+		s, err := DecodeString(obj.Raw)
+		if err != nil {
+			continue
+		}
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			info[entry.key] = s
+		}
+	}
+	return info
+}
+
+// Metadata returns the document's XMP packet, if it has one
+func (f *File) Metadata() ([]byte, error) {
+	root, exists := f.trailer.Get("Root")
+	if !exists {
+		return nil, fmt.Errorf("pdf: trailer has no root")
+	}
+	catalog, err := f.resolveDict(root)
+	if err != nil {
+		return nil, err
+	}
+	raw, exists := catalog.Get("Metadata")
+	if !exists {
+		return nil, fmt.Errorf("pdf: document has no metadata stream")
+	}
+	obj, err := f.resolve(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !obj.IsStream {
+		return nil, fmt.Errorf("pdf: metadata is not a stream")
+	}
+	return f.Decode(obj)
+}
@@ -0,0 +1,571 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var startxrefPattern = regexp.MustCompile(`startxref\s+(\d+)`)
+var objectHeaderPattern = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+obj\b`)
+
+type xrefEntry struct {
+	offset int64
+	gen    int
+	// stream is the object stream that holds a compressed object, or -1
+	stream int
+	index  int
+}
+
+type objectStream struct {
+	data    []byte
+	offsets []int
+}
+
+type Object struct {
+	Ref Ref
+	// Raw is the object's value in PDF syntax
+	Raw string
+	// Dict is the object's value if it is a dictionary or a stream
+	Dict *Dict
+	// Stream is the undecoded stream data if the object is a stream
+	Stream   []byte
+	IsStream bool
+}
+
+// File reads objects out of a PDF through its cross-reference sections, without loading the whole file
+type File struct {
+	file          *os.File
+	size          int64
+	startxref     int64
+	trailer       *Dict
+	xrefIsStream  bool
+	entries       map[int]xrefEntry
+	objectStreams map[int]*objectStream
+	nextNum       int
+}
+
+func Open(filePath string) (*File, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &File{
+		file:          file,
+		size:          info.Size(),
+		entries:       make(map[int]xrefEntry),
+		objectStreams: make(map[int]*objectStream),
+	}
+
+	err = f.loadXref()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) Close() error {
+	return f.file.Close()
+}
+
+func (f *File) Trailer() *Dict {
+	return f.trailer
+}
+
+func (f *File) IsEncrypted() bool {
+	_, encrypted := f.trailer.Get("Encrypt")
+	return encrypted
+}
+
+func (f *File) readAt(offset int64, n int64) ([]byte, error) {
+	if offset < 0 || offset >= f.size {
+		return nil, fmt.Errorf("pdf: offset %d is outside the file", offset)
+	}
+	if offset+n > f.size {
+		n = f.size - offset
+	}
+	data := make([]byte, n)
+	_, err := f.file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// parseAt calls parse on a growing window of the file at offset until it has enough data to succeed
+func (f *File) parseAt(offset int64, parse func(data []byte) error) error {
+	for window := int64(64 * 1024); ; window *= 4 {
+		data, err := f.readAt(offset, window)
+		if err != nil {
+			return err
+		}
+		err = parse(data)
+		if err == nil || offset+int64(len(data)) >= f.size {
+			return err
+		}
+	}
+}
+
+func (f *File) loadXref() error {
+	tail, err := f.readAt(max(0, f.size-2048), 2048)
+	if err != nil {
+		return err
+	}
+
+	matches := startxrefPattern.FindAllSubmatch(tail, -1)
+	if len(matches) == 0 {
+		return fmt.Errorf("pdf: no startxref found")
+	}
+	f.startxref, err = strconv.ParseInt(string(matches[len(matches)-1][1]), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	visited := make(map[int64]struct{})
+	offset := f.startxref
+	for {
+		if _, seen := visited[offset]; seen {
+			break
+		}
+		visited[offset] = struct{}{}
+
+		trailer, isStream, err := f.loadXrefSection(offset)
+		if err != nil {
+			return err
+		}
+		if f.trailer == nil {
+			f.trailer = trailer
+			f.xrefIsStream = isStream
+		}
+
+		// hybrid files list their compressed objects in a separate stream
+		if stmOffset, exists := trailer.GetInt("XRefStm"); exists {
+			_, _, err = f.loadXrefSection(int64(stmOffset))
+			if err != nil {
+				return err
+			}
+		}
+
+		prev, exists := trailer.GetInt("Prev")
+		if !exists {
+			break
+		}
+		offset = int64(prev)
+	}
+
+	size, exists := f.trailer.GetInt("Size")
+	if !exists {
+		return fmt.Errorf("pdf: trailer has no size")
+	}
+	f.nextNum = size
+
+	return nil
+}
+
+// addEntry records where an object is unless a newer section already did
+func (f *File) addEntry(num int, entry xrefEntry) {
+	if _, exists := f.entries[num]; !exists {
+		f.entries[num] = entry
+	}
+}
+
+func (f *File) loadXrefSection(offset int64) (*Dict, bool, error) {
+	var trailer *Dict
+	var isStream bool
+
+	err := f.parseAt(offset, func(data []byte) error {
+		if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n\f\x00"), []byte("xref")) {
+			isStream = true
+			return nil
+		}
+
+		trailerIdx := bytes.Index(data, []byte("trailer"))
+		if trailerIdx < 0 {
+			return fmt.Errorf("pdf: cross-reference table at %d has no trailer", offset)
+		}
+
+		fields := strings.Fields(string(data[:trailerIdx]))[1:]
+		for len(fields) >= 2 {
+			start, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return fmt.Errorf("pdf: invalid cross-reference table at %d", offset)
+			}
+			count, err := strconv.Atoi(fields[1])
+			if err != nil || len(fields) < 2+count*3 {
+				return fmt.Errorf("pdf: invalid cross-reference table at %d", offset)
+			}
+			for n := 0; n < count; n++ {
+				entry := fields[2+n*3 : 5+n*3]
+				if entry[2] != "n" {
+					continue
+				}
+				entryOffset, err := strconv.ParseInt(entry[0], 10, 64)
+				if err != nil {
+					return fmt.Errorf("pdf: invalid cross-reference entry at %d", offset)
+				}
+				gen, _ := strconv.Atoi(entry[1])
+				f.addEntry(start+n, xrefEntry{offset: entryOffset, gen: gen, stream: -1})
+			}
+			fields = fields[2+count*3:]
+		}
+
+		start, end, err := readValue(data, trailerIdx+len("trailer"))
+		if err != nil {
+			return err
+		}
+		trailer, err = ParseDict(data[start:end])
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	if !isStream {
+		return trailer, false, nil
+	}
+
+	obj, err := f.objectAt(offset, -1)
+	if err != nil {
+		return nil, false, err
+	}
+	if !obj.IsStream {
+		return nil, false, fmt.Errorf("pdf: no cross-reference at %d", offset)
+	}
+
+	data, err := f.Decode(obj)
+	if err != nil {
+		return nil, false, err
+	}
+
+	widths, err := intArray(obj.Dict, "W")
+	if err != nil || len(widths) != 3 {
+		return nil, false, fmt.Errorf("pdf: cross-reference stream at %d has invalid widths", offset)
+	}
+	index, err := intArray(obj.Dict, "Index")
+	if err != nil {
+		size, _ := obj.Dict.GetInt("Size")
+		index = []int{0, size}
+	}
+
+	readField := func(field []byte, fallback int64) int64 {
+		if len(field) == 0 {
+			return fallback
+		}
+		var n int64
+		for _, b := range field {
+			n = n<<8 | int64(b)
+		}
+		return n
+	}
+
+	entryWidth := widths[0] + widths[1] + widths[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		for num := index[i]; num < index[i]+index[i+1]; num++ {
+			if pos+entryWidth > len(data) {
+				return obj.Dict, true, nil
+			}
+			entry := data[pos : pos+entryWidth]
+			pos += entryWidth
+
+			kind := readField(entry[:widths[0]], 1)
+			second := readField(entry[widths[0]:widths[0]+widths[1]], 0)
+			third := readField(entry[widths[0]+widths[1]:], 0)
+			switch kind {
+			case 1:
+				f.addEntry(num, xrefEntry{offset: second, gen: int(third), stream: -1})
+			case 2:
+				f.addEntry(num, xrefEntry{stream: int(second), index: int(third)})
+			}
+		}
+	}
+
+	return obj.Dict, true, nil
+}
+
+func intArray(d *Dict, key string) ([]int, error) {
+	value, exists := d.Get(key)
+	if !exists {
+		return nil, fmt.Errorf("pdf: no %s", key)
+	}
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("pdf: %s is not an array", key)
+	}
+	ints := make([]int, 0)
+	for _, field := range strings.Fields(value[1 : len(value)-1]) {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+// objectAt parses the object at offset, num is -1 if it isn't known in advance
+func (f *File) objectAt(offset int64, num int) (*Object, error) {
+	obj := &Object{}
+	var streamStart int64 = -1
+
+	err := f.parseAt(offset, func(data []byte) error {
+		header := objectHeaderPattern.FindSubmatchIndex(data)
+		if header == nil {
+			return fmt.Errorf("pdf: no object at %d", offset)
+		}
+		obj.Ref.Num, _ = strconv.Atoi(string(data[header[2]:header[3]]))
+		obj.Ref.Gen, _ = strconv.Atoi(string(data[header[4]:header[5]]))
+
+		start, end, err := readValue(data, header[1])
+		if err != nil {
+			return err
+		}
+		obj.Raw = string(data[start:end])
+
+		i := skipSpace(data, end)
+		if !bytes.HasPrefix(data[i:], []byte("stream")) {
+			return nil
+		}
+		i += len("stream")
+		if i < len(data) && data[i] == '\r' {
+			i++
+		}
+		if i < len(data) && data[i] == '\n' {
+			i++
+		}
+		streamStart = offset + int64(i)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if num >= 0 && obj.Ref.Num != num {
+		return nil, fmt.Errorf("pdf: expected object %d at %d but found %d", num, offset, obj.Ref.Num)
+	}
+
+	if strings.HasPrefix(obj.Raw, "<<") {
+		obj.Dict, err = ParseDict([]byte(obj.Raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if streamStart < 0 || obj.Dict == nil {
+		return obj, nil
+	}
+
+	length, exists := obj.Dict.GetInt("Length")
+	if !exists {
+		lengthRef, isRef := obj.Dict.GetRef("Length")
+		if !isRef {
+			return nil, fmt.Errorf("pdf: stream %d has no length", obj.Ref.Num)
+		}
+		lengthObj, err := f.Object(lengthRef)
+		if err != nil {
+			return nil, err
+		}
+		length, err = strconv.Atoi(strings.TrimSpace(lengthObj.Raw))
+		if err != nil {
+			return nil, fmt.Errorf("pdf: stream %d has an invalid length", obj.Ref.Num)
+		}
+	}
+
+	obj.Stream, err = f.readAt(streamStart, int64(length))
+	if err != nil {
+		return nil, err
+	}
+	obj.IsStream = true
+
+	return obj, nil
+}
+
+// Object returns the current revision of the object ref points to
+func (f *File) Object(ref Ref) (*Object, error) {
+	entry, exists := f.entries[ref.Num]
+	if !exists {
+		return nil, fmt.Errorf("pdf: object %d not found", ref.Num)
+	}
+
+	if entry.stream < 0 {
+		return f.objectAt(entry.offset, ref.Num)
+	}
+
+	objStream, err := f.objectStream(entry.stream)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.index >= len(objStream.offsets) {
+		return nil, fmt.Errorf("pdf: object %d not found in object stream %d", ref.Num, entry.stream)
+	}
+
+	start, end, err := readValue(objStream.data, objStream.offsets[entry.index])
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &Object{Ref: Ref{Num: ref.Num}, Raw: string(objStream.data[start:end])}
+	if strings.HasPrefix(obj.Raw, "<<") {
+		obj.Dict, err = ParseDict([]byte(obj.Raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// objectStream decodes and indexes an object stream the first time one of its objects is needed
+func (f *File) objectStream(num int) (*objectStream, error) {
+	if objStream, exists := f.objectStreams[num]; exists {
+		return objStream, nil
+	}
+
+	streamObj, err := f.Object(Ref{Num: num})
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.Decode(streamObj)
+	if err != nil {
+		return nil, err
+	}
+
+	first, exists := streamObj.Dict.GetInt("First")
+	if !exists || first > len(data) {
+		return nil, fmt.Errorf("pdf: object stream %d is invalid", num)
+	}
+	count, _ := streamObj.Dict.GetInt("N")
+
+	// the header is pairs of object number and offset relative to First
+	header := strings.Fields(string(data[:first]))
+	objStream := &objectStream{data: data}
+	for idx := 0; idx < count && 2*idx+1 < len(header); idx++ {
+		offset, err := strconv.Atoi(header[2*idx+1])
+		if err != nil || first+offset > len(data) {
+			return nil, fmt.Errorf("pdf: object stream %d is invalid", num)
+		}
+		objStream.offsets = append(objStream.offsets, first+offset)
+	}
+
+	f.objectStreams[num] = objStream
+	return objStream, nil
+}
+
+// Decode returns the decoded data of a stream, only FlateDecode (with or without PNG predictors) is supported
+func (f *File) Decode(obj *Object) ([]byte, error) {
+	if !obj.IsStream {
+		return nil, fmt.Errorf("pdf: object %d is not a stream", obj.Ref.Num)
+	}
+
+	filter, _ := obj.Dict.Get("Filter")
+	filter = strings.Trim(strings.TrimSpace(filter), "[] ")
+	switch filter {
+	case "":
+		return obj.Stream, nil
+	case "/FlateDecode":
+	default:
+		return nil, fmt.Errorf("pdf: unsupported stream filter %s", filter)
+	}
+
+	reader, err := zlib.NewReader(bytes.NewReader(obj.Stream))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+
+	parms, exists := obj.Dict.Get("DecodeParms")
+	if !exists {
+		return data, nil
+	}
+	parmsDict, err := ParseDict([]byte(strings.Trim(strings.TrimSpace(parms), "[]")))
+	if err != nil {
+		return data, nil
+	}
+	predictor, _ := parmsDict.GetInt("Predictor")
+	if predictor < 10 {
+		return data, nil
+	}
+
+	columns, exists := parmsDict.GetInt("Columns")
+	if !exists {
+		columns = 1
+	}
+	colors, exists := parmsDict.GetInt("Colors")
+	if !exists {
+		colors = 1
+	}
+	bitsPerComponent, exists := parmsDict.GetInt("BitsPerComponent")
+	if !exists {
+		bitsPerComponent = 8
+	}
+	return unpredictPng(data, columns, max(1, colors*bitsPerComponent/8))
+}
+
+func unpredictPng(data []byte, columns int, bytesPerPixel int) ([]byte, error) {
+	rowLength := columns*bytesPerPixel + 1
+	if len(data)%rowLength != 0 {
+		return nil, fmt.Errorf("pdf: predicted data is not a whole number of rows")
+	}
+
+	out := make([]byte, 0, len(data)/rowLength*(rowLength-1))
+	prev := make([]byte, rowLength-1)
+	for pos := 0; pos < len(data); pos += rowLength {
+		filter := data[pos]
+		row := append([]byte{}, data[pos+1:pos+rowLength]...)
+		for i := range row {
+			var left, upLeft byte
+			if i >= bytesPerPixel {
+				left = row[i-bytesPerPixel]
+				upLeft = prev[i-bytesPerPixel]
+			}
+			up := prev[i]
+			switch filter {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				p := int(left) + int(up) - int(upLeft)
+				pa, pb, pc := abs(p-int(left)), abs(p-int(up)), abs(p-int(upLeft))
+				if pa <= pb && pa <= pc {
+					row[i] += left
+				} else if pb <= pc {
+					row[i] += up
+				} else {
+					row[i] += upLeft
+				}
+			default:
+				return nil, fmt.Errorf("pdf: unsupported PNG predictor %d", filter)
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
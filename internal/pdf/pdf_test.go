@@ -0,0 +1,186 @@
+package pdf_test
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/pdf"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePdf writes objects numbered from 1 with a cross-reference table and trailer
+func writePdf(t *testing.T, objects []string, trailer string) string {
+	var data strings.Builder
+	data.WriteString("%PDF-1.4\n")
+	offsets := make([]int, 0)
+	for idx, object := range objects {
+		offsets = append(offsets, data.Len())
+		data.WriteString(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", idx+1, object))
+	}
+	xref := data.Len()
+	data.WriteString(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(objects)+1))
+	for _, offset := range offsets {
+		data.WriteString(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	data.WriteString(fmt.Sprintf("trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref))
+
+	path := filepath.Join(t.TempDir(), "test.pdf")
+	assert.NoError(t, os.WriteFile(path, []byte(data.String()), 0644))
+	return path
+}
+
+func writeMinimalPdf(t *testing.T) string {
+	return writePdf(t, []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 200 200] >>",
+		"<< /Title (Old \\(Title\\)) /Producer (test) >>",
+	}, "/Root 1 0 R /Info 4 0 R")
+}
+
+func stream(content string) string {
+	return fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content)
+}
+
+func TestParseDict(t *testing.T) {
+	d, err := pdf.ParseDict([]byte("<< /Type /Page /Kids [1 0 R 2 0 R] /Title (a (nested) \\) string) /Res << /F1 5 0 R >> /N 3 >>"))
+	assert.NoError(t, err)
+
+	kids, _ := d.Get("Kids")
+	assert.Equal(t, "[1 0 R 2 0 R]", kids)
+	title, _ := d.Get("Title")
+	assert.Equal(t, "(a (nested) \\) string)", title)
+	res, _ := d.Get("Res")
+	assert.Equal(t, "<< /F1 5 0 R >>", res)
+	n, _ := d.GetInt("N")
+	assert.Equal(t, 3, n)
+}
+
+func TestUpdate(t *testing.T) {
+	path := writeMinimalPdf(t)
+
+	file, err := pdf.Open(path)
+	assert.NoError(t, err)
+
+	infoRef, exists := file.Trailer().GetRef("Info")
+	assert.True(t, exists)
+	info, err := file.Object(infoRef)
+	assert.NoError(t, err)
+	title, _ := info.Dict.Get("Title")
+	assert.Equal(t, "(Old \\(Title\\))", title)
+
+	update := file.NewUpdate()
+	newInfo := info.Dict.Clone()
+	newInfo.Set("Title", pdf.EncodeString("New"))
+	update.SetObject(infoRef, newInfo.String())
+	extraRef := update.NewRef()
+	update.SetStream(extraRef, pdf.NewDict(), []byte("stream data"))
+	assert.NoError(t, update.Write())
+	assert.NoError(t, file.Close())
+
+	file, err = pdf.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	info, err = file.Object(infoRef)
+	assert.NoError(t, err)
+	title, _ = info.Dict.Get("Title")
+	assert.Equal(t, "<FEFF004E00650077>", title)
+	producer, _ := info.Dict.Get("Producer")
+	assert.Equal(t, "(test)", producer)
+
+	extra, err := file.Object(extraRef)
+	assert.NoError(t, err)
+	data, err := file.Decode(extra)
+	assert.NoError(t, err)
+	assert.Equal(t, "stream data", string(data))
+
+	root, err := file.Object(pdf.Ref{Num: 1})
+	assert.NoError(t, err)
+	pages, _ := root.Dict.Get("Pages")
+	assert.Equal(t, "2 0 R", pages)
+}
+
+func TestText(t *testing.T) {
+	path := writePdf(t, []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R 4 0 R] /Count 2 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+		"<< /Type /Page /Parent 2 0 R /Contents 7 0 R >>",
+		"<< /Type /Page /Parent 2 0 R /Contents [8 0 R 9 0 R] >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /FirstChar 32 /Widths [278] >>",
+		"<< /Type /Font /Subtype /Type0 /BaseFont /Test /Encoding /Identity-H /ToUnicode 10 0 R >>",
+		stream("BT /F1 12 Tf 72 700 Td (ISBN) Tj 30 0 Td (978-0-13-468599-1) Tj 0 -14 Td [(The) -300 (Go) -20 (pher) ( \\(2nd\\) \\223ed.\\224)] TJ ET"),
+		stream("BT /F2 10 Tf 1 0 0 1 72 700 Tm <00010002"),
+		stream("0003> Tj T* (ignored) Tj ET"),
+		stream("/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+			"1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+			"1 beginbfchar <0001> <0048> endbfchar\n" +
+			"1 beginbfrange <0002> <0003> <0069> endbfrange\n" +
+			"endcmap CMapName currentdict /CMap defineresource pop end end"),
+	}, "/Root 1 0 R")
+
+	file, err := pdf.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	text, err := file.Text(1000)
+	assert.NoError(t, err)
+	assert.Equal(t, "ISBN 978-0-13-468599-1\nThe Gopher (2nd) \u201ced.\u201d\nHij\n", text)
+
+	// pages past the limit are left alone
+	text, err = file.Text(5)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(text, "ISBN 978"))
+	assert.NotContains(t, text, "Hij")
+
+	// the tail comes from the last pages, which the head didn't reach
+	head, tail, err := file.Sample(5, 3)
+	assert.NoError(t, err)
+	assert.NotContains(t, head, "Hij")
+	assert.Equal(t, "Hij\n", tail)
+
+	// and never repeats them once it did
+	_, tail, err = file.Sample(1000, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, tail)
+
+	count, err := file.PageCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestMetadata(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title><rdf:Alt><rdf:li>Dune</rdf:li></rdf:Alt></dc:title>` +
+		`</rdf:Description></rdf:RDF></x:xmpmeta>`
+	path := writePdf(t, []string{
+		"<< /Type /Catalog /Pages 2 0 R /Metadata 5 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R >>",
+		"<< /Title <FEFF00440075006E006500200032> /Author (Frank \\215Herbert\\216) /Subject 6 0 R /Trapped /False >>",
+		stream(xmp),
+		"(ISBN 978-0-441-01359-3)",
+	}, "/Root 1 0 R /Info 4 0 R")
+
+	file, err := pdf.Open(path)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	assert.Equal(t, map[string]string{
+		"Title":   "Dune 2",
+		"Author":  "Frank \u201cHerbert\u201d",
+		"Subject": "ISBN 978-0-441-01359-3",
+	}, file.Info())
+
+	data, err := file.Metadata()
+	assert.NoError(t, err)
+	assert.Equal(t, xmp, string(data))
+
+	s, err := pdf.DecodeString("(caf\\351)")
+	assert.NoError(t, err)
+	assert.Equal(t, "café", s)
+	_, err = pdf.DecodeString("/Name")
+	assert.Error(t, err)
+}
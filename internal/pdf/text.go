@@ -0,0 +1,894 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// maxPageTreeDepth guards against page trees that refer back to themselves
+const maxPageTreeDepth = 64
+
+// maxFormDepth limits how deeply form XObjects drawn inside each other are followed
+const maxFormDepth = 8
+
+// page is a leaf of the page tree along with the resources it inherits
+type page struct {
+	dict      *Dict
+	resources *Dict
+}
+
+// resolveDict returns the dictionary raw is, or refers to
+func (f *File) resolveDict(raw string) (*Dict, error) {
+	obj, err := f.resolve(raw)
+	if err != nil {
+		return nil, err
+	}
+	if obj.Dict == nil {
+		return nil, fmt.Errorf("pdf: %s is not a dictionary", raw)
+	}
+	return obj.Dict, nil
+}
+
+// resolve returns the object raw refers to, or raw itself if it is a direct object
+func (f *File) resolve(raw string) (*Object, error) {
+	if ref, isRef := ParseRef(raw); isRef {
+		return f.Object(ref)
+	}
+	obj := &Object{Raw: raw}
+	if strings.HasPrefix(strings.TrimSpace(raw), "<<") {
+		dict, err := ParseDict([]byte(raw))
+		if err != nil {
+			return nil, err
+		}
+		obj.Dict = dict
+	}
+	return obj, nil
+}
+
+// arrayValues splits a raw array like "[1 0 R 2 0 R]" into its raw values
+func arrayValues(raw string) []string {
+	data := []byte(strings.TrimSpace(raw))
+	if len(data) < 2 || data[0] != '[' {
+		return []string{raw}
+	}
+	values := make([]string, 0)
+	for i := 1; ; {
+		i = skipSpace(data, i)
+		if i >= len(data) || data[i] == ']' {
+			return values
+		}
+		start, end, err := readValue(data, i)
+		if err != nil {
+			return values
+		}
+		values = append(values, string(data[start:end]))
+		i = end
+	}
+}
+
+// pages returns the pages of the document in order
+func (f *File) pages() ([]page, error) {
+	root, exists := f.trailer.Get("Root")
+	if !exists {
+		return nil, fmt.Errorf("pdf: trailer has no root")
+	}
+	catalog, err := f.resolveDict(root)
+	if err != nil {
+		return nil, err
+	}
+	tree, exists := catalog.Get("Pages")
+	if !exists {
+		return nil, fmt.Errorf("pdf: catalog has no pages")
+	}
+
+	pages := make([]page, 0)
+	var walk func(raw string, resources *Dict, depth int) error
+	walk = func(raw string, resources *Dict, depth int) error {
+		if depth > maxPageTreeDepth {
+			return fmt.Errorf("pdf: page tree is too deep")
+		}
+		node, err := f.resolveDict(raw)
+		if err != nil {
+			return err
+		}
+
+		// resources are inherited from the nearest ancestor that has them
+		if raw, exists := node.Get("Resources"); exists {
+			if dict, err := f.resolveDict(raw); err == nil {
+				resources = dict
+			}
+		}
+
+		kids, exists := node.Get("Kids")
+		if !exists {
+			pages = append(pages, page{dict: node, resources: resources})
+			return nil
+		}
+		kidsObj, err := f.resolve(kids)
+		if err != nil {
+			return err
+		}
+		for _, kid := range arrayValues(kidsObj.Raw) {
+			err = walk(kid, resources, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = walk(tree, NewDict(), 0)
+	return pages, err
+}
+
+// font maps the character codes shown with a font to text
+type font struct {
+	// codeLengths are the lengths in bytes that codes may have, shortest first
+	codeLengths []int
+	toUnicode   map[string]string
+	// simple fonts without a ToUnicode map are assumed to use WinAnsiEncoding, which nearly all of them do
+	simple bool
+	// widths are how far each code advances in thousandths of the font size
+	widths       map[uint32]float64
+	defaultWidth float64
+}
+
+// decode returns the text of the codes in data and how far they advance in thousandths of the font size
+func (fn *font) decode(data []byte) (string, float64) {
+	var text strings.Builder
+	advance := 0.0
+	for i := 0; i < len(data); {
+		length := 0
+		for _, codeLength := range fn.codeLengths {
+			if i+codeLength > len(data) {
+				break
+			}
+			if s, exists := fn.toUnicode[string(data[i:i+codeLength])]; exists {
+				text.WriteString(s)
+				length = codeLength
+				break
+			}
+		}
+
+		if length == 0 {
+			if fn.simple {
+				text.WriteRune(charmap.Windows1252.DecodeByte(data[i]))
+				length = 1
+			} else {
+				// an unmapped code of a composite font carries no text we can recover
+				length = min(fn.codeLengths[len(fn.codeLengths)-1], len(data)-i)
+			}
+		}
+
+		width, exists := fn.widths[codeValue(data[i:i+length])]
+		if !exists {
+			width = fn.defaultWidth
+		}
+		advance += width
+		i += length
+	}
+	return text.String(), advance
+}
+
+// decodeUtf16 decodes the UTF-16BE destination of a ToUnicode mapping
+func decodeUtf16(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+	return string(utf16.Decode(units))
+}
+
+// parseToUnicode reads the bfchar and bfrange mappings of a ToUnicode CMap
+func parseToUnicode(data []byte, fn *font) {
+	tokens := newContentLexer(data)
+	lengths := make(map[int]bool)
+
+	section := ""
+	operands := make([]token, 0)
+	for {
+		tok, ok := tokens.next()
+		if !ok {
+			break
+		}
+		if tok.kind != tokenOperator {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch tok.value {
+		case "begincodespacerange", "beginbfchar", "beginbfrange":
+			section = tok.value
+		case "endcodespacerange":
+			for idx := 0; idx+1 < len(operands); idx += 2 {
+				lengths[len(operands[idx].bytes)] = true
+			}
+			section = ""
+		case "endbfchar":
+			for idx := 0; idx+1 < len(operands); idx += 2 {
+				fn.toUnicode[string(operands[idx].bytes)] = decodeUtf16(operands[idx+1].bytes)
+				lengths[len(operands[idx].bytes)] = true
+			}
+			section = ""
+		case "endbfrange":
+			for idx := 0; idx+2 < len(operands); idx += 3 {
+				low, high, destination := operands[idx].bytes, operands[idx+1].bytes, operands[idx+2]
+				if len(low) != len(high) || len(low) == 0 || len(low) > 4 {
+					continue
+				}
+				lengths[len(low)] = true
+				start, end := codeValue(low), codeValue(high)
+				for code := start; code <= end && code-start < 0x10000; code++ {
+					key := string(codeBytes(code, len(low)))
+					if destination.kind == tokenArray {
+						if int(code-start) < len(destination.items) {
+							fn.toUnicode[key] = decodeUtf16(destination.items[code-start].bytes)
+						}
+						continue
+					}
+					// the last byte of the destination counts up through the range
+					dest := append([]byte{}, destination.bytes...)
+					if len(dest) > 0 {
+						dest[len(dest)-1] += byte(code - start)
+					}
+					fn.toUnicode[key] = decodeUtf16(dest)
+				}
+			}
+			section = ""
+		}
+		if section == "" || strings.HasPrefix(tok.value, "begin") {
+			operands = operands[:0]
+		}
+	}
+
+	if len(lengths) > 0 {
+		fn.codeLengths = fn.codeLengths[:0]
+		for length := 1; length <= 4; length++ {
+			if lengths[length] {
+				fn.codeLengths = append(fn.codeLengths, length)
+			}
+		}
+	}
+}
+
+func codeValue(data []byte) uint32 {
+	var value uint32
+	for _, b := range data {
+		value = value<<8 | uint32(b)
+	}
+	return value
+}
+
+func codeBytes(value uint32, length int) []byte {
+	data := make([]byte, length)
+	for idx := length - 1; idx >= 0; idx-- {
+		data[idx] = byte(value)
+		value >>= 8
+	}
+	return data
+}
+
+// resolveArray returns the values of the array raw is, or refers to
+func (f *File) resolveArray(raw string) []string {
+	obj, err := f.resolve(raw)
+	if err != nil {
+		return nil
+	}
+	return arrayValues(obj.Raw)
+}
+
+func parseNumber(raw string) (float64, bool) {
+	number, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	return number, err == nil
+}
+
+// loadWidths reads the widths of a simple font, which are listed from its first character code on
+func (f *File) loadWidths(dict *Dict, fn *font) {
+	firstChar, _ := dict.GetInt("FirstChar")
+	raw, exists := dict.Get("Widths")
+	if !exists {
+		return
+	}
+	for idx, value := range f.resolveArray(raw) {
+		if width, ok := parseNumber(value); ok {
+			fn.widths[uint32(firstChar+idx)] = width
+		}
+	}
+}
+
+// loadCidWidths reads the widths of a composite font's descendant, which lists them as either "c [w1 w2 ...]" or
+// "first last w"
+func (f *File) loadCidWidths(dict *Dict, fn *font) {
+	descendants, exists := dict.Get("DescendantFonts")
+	if !exists {
+		return
+	}
+	values := f.resolveArray(descendants)
+	if len(values) == 0 {
+		return
+	}
+	descendant, err := f.resolveDict(values[0])
+	if err != nil {
+		return
+	}
+
+	if raw, exists := descendant.Get("DW"); exists {
+		if width, ok := parseNumber(raw); ok {
+			fn.defaultWidth = width
+		}
+	}
+	raw, exists := descendant.Get("W")
+	if !exists {
+		return
+	}
+	w := f.resolveArray(raw)
+	for idx := 0; idx+1 < len(w); {
+		first, ok := parseNumber(w[idx])
+		if !ok {
+			return
+		}
+		if strings.HasPrefix(w[idx+1], "[") {
+			for offset, value := range arrayValues(w[idx+1]) {
+				if width, ok := parseNumber(value); ok {
+					fn.widths[uint32(first)+uint32(offset)] = width
+				}
+			}
+			idx += 2
+			continue
+		}
+		if idx+2 >= len(w) {
+			return
+		}
+		last, lastOk := parseNumber(w[idx+1])
+		width, widthOk := parseNumber(w[idx+2])
+		if !lastOk || !widthOk {
+			return
+		}
+		for code := uint32(first); code <= uint32(last) && code-uint32(first) < 0x10000; code++ {
+			fn.widths[code] = width
+		}
+		idx += 3
+	}
+}
+
+// loadFont reads what is needed to decode text shown with the font dictionary raw refers to
+func (f *File) loadFont(raw string) *font {
+	fn := &font{
+		codeLengths:  []int{1},
+		toUnicode:    make(map[string]string),
+		simple:       true,
+		widths:       make(map[uint32]float64),
+		defaultWidth: 500,
+	}
+
+	dict, err := f.resolveDict(raw)
+	if err != nil {
+		return fn
+	}
+
+	if subtype, _ := dict.Get("Subtype"); subtype == "/Type0" {
+		fn.codeLengths = []int{2}
+		fn.simple = false
+		fn.defaultWidth = 1000
+		f.loadCidWidths(dict, fn)
+	} else {
+		f.loadWidths(dict, fn)
+	}
+
+	toUnicode, exists := dict.Get("ToUnicode")
+	if !exists {
+		return fn
+	}
+	obj, err := f.resolve(toUnicode)
+	if err != nil || !obj.IsStream {
+		return fn
+	}
+	data, err := f.Decode(obj)
+	if err != nil {
+		return fn
+	}
+	parseToUnicode(data, fn)
+	return fn
+}
+
+// textWriter collects the text shown by content streams
+type textWriter struct {
+	text  strings.Builder
+	limit int
+}
+
+func (w *textWriter) full() bool {
+	return w.text.Len() >= w.limit
+}
+
+func (w *textWriter) separate(separator byte) {
+	s := w.text.String()
+	if len(s) == 0 || s[len(s)-1] == '\n' || (separator == ' ' && s[len(s)-1] == ' ') {
+		return
+	}
+	w.text.WriteByte(separator)
+}
+
+// textState follows where text is drawn closely enough to tell where words and lines break, since PDFs only place
+// glyphs and rarely draw the spaces between words
+type textState struct {
+	font     *font
+	fontSize float64
+	leading  float64
+	// matrix is the text matrix, and line is where the current line started
+	matrix [6]float64
+	line   [6]float64
+	// where the last text shown ended, if any has been
+	shown      bool
+	endX, endY float64
+	newline    bool
+}
+
+func (st *textState) begin() {
+	st.matrix = [6]float64{1, 0, 0, 1, 0, 0}
+	st.line = st.matrix
+}
+
+// translate moves the text matrix by tx and ty in text space
+func (st *textState) translate(tx float64, ty float64) {
+	m := &st.matrix
+	m[4] += tx*m[0] + ty*m[2]
+	m[5] += tx*m[1] + ty*m[3]
+}
+
+// nextLine starts a new line offset from the start of the current one
+func (st *textState) nextLine(tx float64, ty float64) {
+	st.matrix = st.line
+	st.translate(tx, ty)
+	st.line = st.matrix
+}
+
+func (st *textState) show(data []byte, w *textWriter) {
+	if st.font == nil {
+		return
+	}
+	s, advance := st.font.decode(data)
+
+	width := math.Abs(st.fontSize) * math.Hypot(st.matrix[0], st.matrix[1])
+	height := math.Abs(st.fontSize) * math.Hypot(st.matrix[2], st.matrix[3])
+	x, y := st.matrix[4], st.matrix[5]
+	if st.shown {
+		gap := x - st.endX
+		switch {
+		case st.newline || math.Abs(y-st.endY) > height/2:
+			w.separate('\n')
+		case gap > width*0.15 || gap < -width:
+			w.separate(' ')
+		}
+	}
+	w.text.WriteString(s)
+
+	st.translate(advance/1000*st.fontSize, 0)
+	st.shown = true
+	st.newline = false
+	st.endX, st.endY = st.matrix[4], st.matrix[5]
+}
+
+func operandNumbers(operands []token, count int) ([]float64, bool) {
+	if len(operands) < count {
+		return nil, false
+	}
+	numbers := make([]float64, count)
+	for idx, operand := range operands[len(operands)-count:] {
+		if operand.kind != tokenNumber {
+			return nil, false
+		}
+		numbers[idx] = operand.number
+	}
+	return numbers, true
+}
+
+// contentText writes the text of a content stream, following form XObjects it draws
+func (f *File) contentText(data []byte, resources *Dict, fonts map[string]*font, w *textWriter, depth int) {
+	fontResources := NewDict()
+	if raw, exists := resources.Get("Font"); exists {
+		if dict, err := f.resolveDict(raw); err == nil {
+			fontResources = dict
+		}
+	}
+
+	st := textState{}
+	st.begin()
+	operands := make([]token, 0)
+	tokens := newContentLexer(data)
+	for !w.full() {
+		tok, ok := tokens.next()
+		if !ok {
+			return
+		}
+		if tok.kind != tokenOperator {
+			operands = append(operands, tok)
+			continue
+		}
+
+		switch tok.value {
+		case "BT":
+			st.begin()
+		case "Tf":
+			if size, ok := operandNumbers(operands, 1); ok && len(operands) >= 2 && operands[len(operands)-2].kind == tokenName {
+				st.fontSize = size[0]
+				name := operands[len(operands)-2].value
+				if raw, exists := fontResources.Get(name); exists {
+					key := raw
+					if _, isRef := ParseRef(raw); !isRef {
+						key = name
+					}
+					if _, loaded := fonts[key]; !loaded {
+						fonts[key] = f.loadFont(raw)
+					}
+					st.font = fonts[key]
+				}
+			}
+		case "TL":
+			if leading, ok := operandNumbers(operands, 1); ok {
+				st.leading = leading[0]
+			}
+		case "Td", "TD":
+			if offset, ok := operandNumbers(operands, 2); ok {
+				if tok.value == "TD" {
+					st.leading = -offset[1]
+				}
+				st.nextLine(offset[0], offset[1])
+			}
+		case "Tm":
+			if m, ok := operandNumbers(operands, 6); ok {
+				copy(st.matrix[:], m)
+				st.line = st.matrix
+			}
+		case "T*":
+			st.nextLine(0, -st.leading)
+			st.newline = true
+		case "Tj", "'", "\"":
+			if tok.value != "Tj" {
+				st.nextLine(0, -st.leading)
+				st.newline = true
+			}
+			if len(operands) > 0 && operands[len(operands)-1].kind == tokenString {
+				st.show(operands[len(operands)-1].bytes, w)
+			}
+		case "TJ":
+			if len(operands) > 0 {
+				for _, item := range operands[len(operands)-1].items {
+					if item.kind == tokenString {
+						st.show(item.bytes, w)
+					} else if item.kind == tokenNumber {
+						// adjustments move the next glyph back, or forward across the gap between words
+						st.translate(-item.number/1000*st.fontSize, 0)
+					}
+				}
+			}
+		case "BI":
+			tokens.skipInlineImage()
+		case "Do":
+			if depth < maxFormDepth && len(operands) > 0 && operands[0].kind == tokenName {
+				w.separate('\n')
+				f.formText(operands[0].value, resources, w, depth)
+				w.separate('\n')
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+// formText writes the text of the form XObject named name, which has its own resources and fonts
+func (f *File) formText(name string, resources *Dict, w *textWriter, depth int) {
+	raw, exists := resources.Get("XObject")
+	if !exists {
+		return
+	}
+	xobjects, err := f.resolveDict(raw)
+	if err != nil {
+		return
+	}
+	raw, exists = xobjects.Get(name)
+	if !exists {
+		return
+	}
+	obj, err := f.resolve(raw)
+	if err != nil || !obj.IsStream {
+		return
+	}
+	if subtype, _ := obj.Dict.Get("Subtype"); subtype != "/Form" {
+		return
+	}
+	data, err := f.Decode(obj)
+	if err != nil {
+		return
+	}
+
+	formResources := resources
+	if raw, exists := obj.Dict.Get("Resources"); exists {
+		if dict, err := f.resolveDict(raw); err == nil {
+			formResources = dict
+		}
+	}
+	f.contentText(data, formResources, make(map[string]*font), w, depth+1)
+}
+
+// PageCount is how many pages the document has, counted from its page tree rather than taken from the /Count the
+// tree claims, which broken files get wrong
+func (f *File) PageCount() (int, error) {
+	pages, err := f.pages()
+	if err != nil && len(pages) == 0 {
+		return 0, err
+	}
+	return len(pages), nil
+}
+
+// Text extracts the text of the pages in order, stopping once it has at least maxCharacters
+func (f *File) Text(maxCharacters int) (string, error) {
+	text, _, err := f.Sample(maxCharacters, 0)
+	return text, err
+}
+
+// Sample extracts the text of the first pages until it has at least head characters, and then of the last pages until
+// it has at least tail, without reading any page twice. Each is in page order.
+func (f *File) Sample(head int, tail int) (string, string, error) {
+	if f.IsEncrypted() {
+		return "", "", fmt.Errorf("pdf: file is encrypted")
+	}
+
+	pages, err := f.pages()
+	if err != nil && len(pages) == 0 {
+		return "", "", err
+	}
+
+	w := &textWriter{limit: head}
+	next := 0
+	for ; next < len(pages) && !w.full(); next++ {
+		f.pageText(pages[next], w)
+	}
+
+	// a colophon is often the end of the last page, so those pages are read in full
+	tailPages := make([]string, 0)
+	tailLength := 0
+	for idx := len(pages) - 1; idx >= next && tailLength < tail; idx-- {
+		pw := &textWriter{limit: math.MaxInt}
+		f.pageText(pages[idx], pw)
+		tailPages = append(tailPages, pw.text.String())
+		tailLength += pw.text.Len()
+	}
+	slices.Reverse(tailPages)
+
+	return w.text.String(), strings.Join(tailPages, ""), nil
+}
+
+func (f *File) pageText(pg page, w *textWriter) {
+	contents, exists := pg.dict.Get("Contents")
+	if !exists {
+		return
+	}
+	contentsObj, err := f.resolve(contents)
+	if err != nil {
+		return
+	}
+
+	// the page's content is split across any number of streams, which may split operators between them
+	data := bytes.Buffer{}
+	streams := []string{contents}
+	if !contentsObj.IsStream {
+		streams = arrayValues(contentsObj.Raw)
+	}
+	for _, stream := range streams {
+		obj, err := f.resolve(stream)
+		if err != nil || !obj.IsStream {
+			continue
+		}
+		decoded, err := f.Decode(obj)
+		if err != nil {
+			continue
+		}
+		data.Write(decoded)
+		data.WriteByte('\n')
+	}
+
+	f.contentText(data.Bytes(), pg.resources, make(map[string]*font), w, 0)
+	w.separate('\n')
+}
+
+type tokenKind int
+
+const (
+	tokenOperator tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenName
+	tokenArray
+	tokenOther
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	// bytes is the decoded value of a string
+	bytes  []byte
+	number float64
+	items  []token
+}
+
+// contentLexer splits a content stream or CMap into operands and operators
+type contentLexer struct {
+	data []byte
+	i    int
+}
+
+func newContentLexer(data []byte) *contentLexer {
+	return &contentLexer{data: data}
+}
+
+func (l *contentLexer) next() (token, bool) {
+	l.i = skipSpace(l.data, l.i)
+	if l.i >= len(l.data) {
+		return token{}, false
+	}
+
+	c := l.data[l.i]
+	switch {
+	case c == '(':
+		return token{kind: tokenString, bytes: l.literalString()}, true
+	case c == '<' && l.i+1 < len(l.data) && l.data[l.i+1] == '<':
+		_, end, err := readValue(l.data, l.i)
+		if err != nil {
+			l.i = len(l.data)
+			return token{}, false
+		}
+		l.i = end
+		return token{kind: tokenOther}, true
+	case c == '<':
+		return token{kind: tokenString, bytes: l.hexString()}, true
+	case c == '[':
+		l.i++
+		items := make([]token, 0)
+		for {
+			l.i = skipSpace(l.data, l.i)
+			if l.i >= len(l.data) {
+				return token{kind: tokenArray, items: items}, true
+			}
+			if l.data[l.i] == ']' {
+				l.i++
+				return token{kind: tokenArray, items: items}, true
+			}
+			item, ok := l.next()
+			if !ok {
+				return token{kind: tokenArray, items: items}, true
+			}
+			items = append(items, item)
+		}
+	case c == '/':
+		end := readToken(l.data, l.i+1)
+		value := string(l.data[l.i+1 : end])
+		l.i = end
+		return token{kind: tokenName, value: value}, true
+	case c == ']' || c == ')' || c == '>' || c == '{' || c == '}':
+		l.i++
+		return token{kind: tokenOther}, true
+	}
+
+	end := readToken(l.data, l.i)
+	if end == l.i {
+		end++
+	}
+	value := string(l.data[l.i:end])
+	l.i = end
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		return token{kind: tokenNumber, value: value, number: number}, true
+	}
+	return token{kind: tokenOperator, value: value}, true
+}
+
+func (l *contentLexer) literalString() []byte {
+	var s []byte
+	depth := 0
+	for l.i < len(l.data) {
+		c := l.data[l.i]
+		l.i++
+		switch c {
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return s
+			}
+		case '\\':
+			if l.i >= len(l.data) {
+				return s
+			}
+			c = l.data[l.i]
+			l.i++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// an escaped line break continues the string on the next line
+				if l.i < len(l.data) && l.data[l.i] == '\n' {
+					l.i++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for digits := 1; digits < 3 && l.i < len(l.data) && l.data[l.i] >= '0' && l.data[l.i] <= '7'; digits++ {
+						value = value*8 + int(l.data[l.i]-'0')
+						l.i++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		s = append(s, c)
+	}
+	return s
+}
+
+func (l *contentLexer) hexString() []byte {
+	l.i++
+	var s []byte
+	var digits []byte
+	for l.i < len(l.data) && l.data[l.i] != '>' {
+		c := l.data[l.i]
+		l.i++
+		if isWhitespace(c) {
+			continue
+		}
+		digits = append(digits, c)
+		if len(digits) == 2 {
+			value, _ := strconv.ParseUint(string(digits), 16, 8)
+			s = append(s, byte(value))
+			digits = digits[:0]
+		}
+	}
+	l.i++
+	// a missing final digit is taken to be 0
+	if len(digits) == 1 {
+		value, _ := strconv.ParseUint(string(digits)+"0", 16, 8)
+		s = append(s, byte(value))
+	}
+	return s
+}
+
+// skipInlineImage skips past the data of an inline image, which isn't PDF syntax
+func (l *contentLexer) skipInlineImage() {
+	id := bytes.Index(l.data[l.i:], []byte("ID"))
+	if id < 0 {
+		l.i = len(l.data)
+		return
+	}
+	l.i += id + 2
+	for l.i < len(l.data) {
+		ei := bytes.Index(l.data[l.i:], []byte("EI"))
+		if ei < 0 {
+			l.i = len(l.data)
+			return
+		}
+		l.i += ei + 2
+		// EI only ends the image when it stands on its own
+		if isWhitespace(l.data[l.i-3]) && (l.i >= len(l.data) || isWhitespace(l.data[l.i]) || isDelimiter(l.data[l.i])) {
+			return
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// Update collects new and replaced objects to append to a PDF as an incremental update, which leaves every earlier
+// revision of the file intact
+type Update struct {
+	file    *File
+	objects map[int]string
+	trailer *Dict
+}
+
+func (f *File) NewUpdate() *Update {
+	trailer := f.trailer.Clone()
+	// these only describe the cross-reference section the trailer came from
+	for _, key := range []string{"Prev", "XRefStm", "Type", "W", "Index", "Length", "Filter", "DecodeParms"} {
+		trailer.Delete(key)
+	}
+
+	return &Update{
+		file:    f,
+		objects: make(map[int]string),
+		trailer: trailer,
+	}
+}
+
+// Trailer is the trailer the update will be written with
+func (u *Update) Trailer() *Dict {
+	return u.trailer
+}
+
+// NewRef reserves a new object number
+func (u *Update) NewRef() Ref {
+	ref := Ref{Num: u.file.nextNum}
+	u.file.nextNum++
+	return ref
+}
+
+// SetObject sets the value of the object ref points to, replacing the existing object if there is one
+func (u *Update) SetObject(ref Ref, value string) {
+	u.objects[ref.Num] = value
+}
+
+// SetStream sets the object ref points to to a stream holding data uncompressed
+func (u *Update) SetStream(ref Ref, dict *Dict, data []byte) {
+	dict = dict.Clone()
+	dict.Set("Length", strconv.Itoa(len(data)))
+	u.objects[ref.Num] = fmt.Sprintf("%s\nstream\n%s\nendstream", dict.String(), data)
+}
+
+// Write appends the update to the end of the file, the file is truncated back to its original size if that fails
+func (u *Update) Write() error {
+	if len(u.objects) == 0 {
+		return nil
+	}
+
+	nums := make([]int, 0, len(u.objects))
+	for num := range u.objects {
+		nums = append(nums, num)
+	}
+
+	update := bytes.Buffer{}
+	update.WriteString("\n")
+
+	offsets := make(map[int]int64)
+	writeObject := func(num int, value string) {
+		offsets[num] = u.file.size + int64(update.Len())
+		update.WriteString(fmt.Sprintf("%d %d obj\n%s\nendobj\n", num, u.generation(num), value))
+	}
+
+	slices.Sort(nums)
+	for _, num := range nums {
+		writeObject(num, u.objects[num])
+	}
+
+	trailer := u.trailer.Clone()
+	trailer.Set("Prev", strconv.FormatInt(u.file.startxref, 10))
+
+	var xrefOffset int64
+	if !u.file.xrefIsStream {
+		xrefOffset = u.file.size + int64(update.Len())
+		trailer.Set("Size", strconv.Itoa(u.file.nextNum))
+
+		update.WriteString("xref\n")
+		for _, section := range sections(nums) {
+			update.WriteString(fmt.Sprintf("%d %d\n", section[0], len(section)))
+			for _, num := range section {
+				update.WriteString(fmt.Sprintf("%010d %05d n\r\n", offsets[num], u.generation(num)))
+			}
+		}
+		update.WriteString(fmt.Sprintf("trailer\n%s\n", trailer.String()))
+	} else {
+		// a file that uses cross-reference streams must keep using them
+		xrefRef := u.NewRef()
+		nums = append(nums, xrefRef.Num)
+		xrefOffset = u.file.size + int64(update.Len())
+		offsets[xrefRef.Num] = xrefOffset
+
+		offsetWidth := 4
+		if xrefOffset >= 1<<32 {
+			offsetWidth = 8
+		}
+
+		index := bytes.Buffer{}
+		entries := bytes.Buffer{}
+		for _, section := range sections(nums) {
+			index.WriteString(fmt.Sprintf(" %d %d", section[0], len(section)))
+			for _, num := range section {
+				entries.WriteByte(1)
+				for shift := offsetWidth - 1; shift >= 0; shift-- {
+					entries.WriteByte(byte(offsets[num] >> (8 * shift)))
+				}
+				gen := u.generation(num)
+				entries.WriteByte(byte(gen >> 8))
+				entries.WriteByte(byte(gen))
+			}
+		}
+
+		trailer.Set("Type", "/XRef")
+		trailer.Set("Size", strconv.Itoa(u.file.nextNum))
+		trailer.Set("W", fmt.Sprintf("[1 %d 2]", offsetWidth))
+		trailer.Set("Index", fmt.Sprintf("[%s ]", index.String()))
+		trailer.Set("Length", strconv.Itoa(entries.Len()))
+		update.WriteString(fmt.Sprintf("%d 0 obj\n%s\nstream\n", xrefRef.Num, trailer.String()))
+		update.Write(entries.Bytes())
+		update.WriteString("\nendstream\nendobj\n")
+	}
+	update.WriteString(fmt.Sprintf("startxref\n%d\n%%%%EOF\n", xrefOffset))
+
+	out, err := os.OpenFile(u.file.file.Name(), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.WriteAt(update.Bytes(), u.file.size)
+	if err == nil {
+		err = out.Sync()
+	}
+	if err != nil {
+		out.Truncate(u.file.size)
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (u *Update) generation(num int) int {
+	if entry, exists := u.file.entries[num]; exists && entry.stream < 0 {
+		return entry.gen
+	}
+	return 0
+}
+
+// sections splits sorted object numbers into runs of consecutive numbers
+func sections(nums []int) [][]int {
+	result := make([][]int, 0)
+	for _, num := range nums {
+		if len(result) > 0 {
+			last := result[len(result)-1]
+			if last[len(last)-1] == num-1 {
+				result[len(result)-1] = append(last, num)
+				continue
+			}
+		}
+		result = append(result, []int{num})
+	}
+	return result
+}
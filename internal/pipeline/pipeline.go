@@ -1,17 +1,44 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/larkwiot/booker/internal/util"
-	"log"
+	"github.com/larkwiot/booker/internal/logging"
+	"log/slog"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// ErrInterrupted is given to stage fail handlers for items that were skipped because the pipeline was interrupted
+var ErrInterrupted = errors.New("pipeline interrupted")
+
+// timedOutError is the error of an item that ran out of time in a stage, which reads as the error the stage failed with
+// but also is context.DeadlineExceeded, whether or not the stage passed that on
+type timedOutError struct {
+	err error
+}
+
+func (e *timedOutError) Error() string {
+	return e.err.Error()
+}
+
+func (e *timedOutError) Unwrap() []error {
+	return []error{e.err, context.DeadlineExceeded}
+}
+
+// TimedOut marks err as the error of an item that ran out of time
+func TimedOut(err error) error {
+	return &timedOutError{err: err}
+}
+
 type stageDescription struct {
-	Name   string
-	Worker func(any) (any, error)
+	Name string
+	// Weight is the stage's share of the threads relative to the other stages
+	Weight int64
+	Worker func(context.Context, any) (any, error)
 }
 
 type Pipeline struct {
@@ -21,117 +48,345 @@ type Pipeline struct {
 	channels          []chan any
 	Backend           chan any
 	TotalThreadCount  int64
+	itemTimeout       time.Duration
 	collector         *CollectorStage
 	status            <-chan time.Time
 	failCount         atomic.Int64
+	inFlight          atomic.Int64
+	discovered        atomic.Int64
+	submitted         atomic.Int64
+	started           time.Time
+	quit              chan struct{}
+	interrupt         chan struct{}
+	interruptOnce     sync.Once
+	// submitLock keeps Submit from sending to the frontend once Drain has closed it
+	submitLock sync.RWMutex
+	draining   bool
+	drainOnce  sync.Once
+	// drained is closed once the collector has taken the last item
+	drained chan struct{}
+	// pauses counts the calls to Pause that haven't been resumed yet, resumed being closed while there are none
+	pauseLock sync.Mutex
+	pauses    int
+	resumed   chan struct{}
 }
 
 func NewPipeline(totalThreadCount int64) *Pipeline {
+	resumed := make(chan struct{})
+	close(resumed)
 	return &Pipeline{
 		stageDescriptions: []stageDescription{},
 		TotalThreadCount:  totalThreadCount,
-		Frontend:          make(chan any),
-		Backend:           make(chan any),
+		Frontend:          make(chan any, totalThreadCount),
+		Backend:           make(chan any, totalThreadCount),
 		status:            time.Tick(100 * time.Millisecond),
+		quit:              make(chan struct{}),
+		interrupt:         make(chan struct{}),
+		drained:           make(chan struct{}),
+		resumed:           resumed,
+	}
+}
+
+func (p *Pipeline) AppendStage(name string, worker func(context.Context, any) (any, error)) {
+	p.AppendWeightedStage(name, 1, worker)
+}
+
+// AppendWeightedStage appends a stage that gets weight times the threads of a stage appended with AppendStage, for a
+// stage whose items spend most of their time waiting
+func (p *Pipeline) AppendWeightedStage(name string, weight int64, worker func(context.Context, any) (any, error)) {
+	p.stageDescriptions = append(p.stageDescriptions, stageDescription{Name: name, Weight: max(weight, 1), Worker: worker})
+}
+
+// stageThreadCount is a stage's share of the threads by its weight, and at least one thread so that every item gets
+// through however few threads there are
+func (p *Pipeline) stageThreadCount(weight int64) int64 {
+	totalWeight := int64(0)
+	for _, stageDesc := range p.stageDescriptions {
+		totalWeight += stageDesc.Weight
 	}
+	return max(p.TotalThreadCount*weight/totalWeight, 1)
+}
+
+// Interrupt stops the pipeline from accepting new items, items already submitted are still processed
+func (p *Pipeline) Interrupt() {
+	p.interruptOnce.Do(func() {
+		close(p.interrupt)
+	})
+}
+
+func (p *Pipeline) IsInterrupted() bool {
+	select {
+	case <-p.interrupt:
+		return true
+	default:
+		return false
+	}
+}
+
+// Interrupted is closed once the pipeline has been interrupted
+func (p *Pipeline) Interrupted() <-chan struct{} {
+	return p.interrupt
+}
+
+// Pause stops the pipeline from starting on new items until Resume is called as many times as Pause was. Items already
+// started carry on, and those submitted in the meantime wait their turn, so nothing is lost while a service is down.
+// It reports whether the pipeline was running until now.
+func (p *Pipeline) Pause() bool {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	p.pauses++
+	if p.pauses > 1 {
+		return false
+	}
+	p.resumed = make(chan struct{})
+	return true
+}
+
+// Resume undoes a call to Pause, reporting whether the pipeline is running again
+func (p *Pipeline) Resume() bool {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if p.pauses == 0 {
+		return false
+	}
+	p.pauses--
+	if p.pauses > 0 {
+		return false
+	}
+	close(p.resumed)
+	return true
+}
+
+func (p *Pipeline) IsPaused() bool {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	return p.pauses > 0
+}
+
+// Resumed is closed once the pipeline isn't paused
+func (p *Pipeline) Resumed() <-chan struct{} {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	return p.resumed
+}
+
+// Submit sends an item to the frontend, returning false without sending it if the pipeline was interrupted or is
+// draining
+func (p *Pipeline) Submit(item any) bool {
+	p.submitLock.RLock()
+	defer p.submitLock.RUnlock()
+	if p.draining || p.IsInterrupted() {
+		return false
+	}
+
+	p.inFlight.Add(1)
+	select {
+	case p.Frontend <- item:
+		p.submitted.Add(1)
+		return true
+	case <-p.interrupt:
+		p.inFlight.Add(-1)
+		return false
+	}
+}
+
+// Discover adds n items that are about to be submitted to the total the progress line estimates from
+func (p *Pipeline) Discover(n int64) {
+	p.discovered.Add(n)
+}
+
+// Total is the number of items discovered or submitted, whichever is more
+func (p *Pipeline) Total() int64 {
+	return max(p.discovered.Load(), p.submitted.Load())
 }
 
-func (p *Pipeline) AppendStage(name string, worker func(any) (any, error)) {
-	p.stageDescriptions = append(p.stageDescriptions, stageDescription{Name: name, Worker: worker})
+func (p *Pipeline) FailedCount() int64 {
+	return p.failCount.Load()
+}
+
+// InFlight is the number of submitted items that have neither been collected nor failed yet
+func (p *Pipeline) InFlight() int64 {
+	return p.inFlight.Load()
+}
+
+// SetItemTimeout limits how long a single item may spend in any one stage, 0 means no limit
+func (p *Pipeline) SetItemTimeout(timeout time.Duration) {
+	p.itemTimeout = timeout
+}
+
+// ItemTimeout is the limit set by SetItemTimeout
+func (p *Pipeline) ItemTimeout() time.Duration {
+	return p.itemTimeout
 }
 
 func (p *Pipeline) CollectorStage(collector func(any)) {
-	p.collector = NewCollectorStage(collector)
+	p.collector = NewCollectorStage(func(a any) {
+		defer p.inFlight.Add(-1)
+		collector(a)
+	})
 }
 
-func (p *Pipeline) Run(failHandler func(any, error)) {
+// Run starts all stages. Cancelling ctx interrupts the pipeline and cancels the work of items already in flight
+func (p *Pipeline) Run(ctx context.Context, failHandler func(any, error)) {
 	wrappedFailHandler := func(a any, err error) {
+		p.inFlight.Add(-1)
+		if errors.Is(err, ErrInterrupted) {
+			return
+		}
 		p.failCount.Add(1)
 		failHandler(a, err)
 	}
 
-	if len(p.stageDescriptions) == 0 {
-		log.Println("warning: pipeline not running because no stageDescriptions were specified")
-		return
-	}
+	p.started = time.Now()
 
-	if len(p.stageDescriptions) == 1 {
-		stageDesc := p.stageDescriptions[0]
-		stage := NewStage(stageDesc.Name, p.TotalThreadCount, stageDesc.Worker)
-		go stage.Run(p.Frontend, p.Backend, wrappedFailHandler)
+	if len(p.stageDescriptions) == 0 {
+		slog.Warn("pipeline not running because no stageDescriptions were specified")
+		close(p.drained)
 		return
 	}
 
-	perStageThreadCount := p.TotalThreadCount / int64(len(p.stageDescriptions))
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Interrupt()
+		case <-p.quit:
+		}
+	}()
 
 	var lastOutput = p.Frontend
 	for i, stageDesc := range p.stageDescriptions {
+		threadCount := p.stageThreadCount(stageDesc.Weight)
 		var output chan any
 		if i == len(p.stageDescriptions)-1 {
 			output = p.Backend
 		} else {
-			output = make(chan any)
-			p.channels = append(p.channels, output)
+			// only enough room for the next stage to always have an item ready
+			output = make(chan any, threadCount+1)
+		}
+
+		stage := NewStage(stageDesc.Name, threadCount, stageDesc.Worker)
+		stage.timeout = p.itemTimeout
+		stage.backend = p.Backend
+		if i == 0 {
+			// only items that have not started yet are skipped or held back, anything further along is finished
+			stage.interrupt = p.interrupt
+			stage.resumed = p.Resumed
 		}
 
-		stage := NewStage(stageDesc.Name, perStageThreadCount, stageDesc.Worker)
+		stage.Run(ctx, lastOutput, output, wrappedFailHandler)
 
-		go stage.Run(lastOutput, output, wrappedFailHandler)
+		// each stage's workers stop once their input is closed and empty, so closing the frontend drains the stages in
+		// order. The backend is shared, since any stage can complete an item, so it is closed after all of them
+		if output != p.Backend {
+			go func() {
+				stage.Wait()
+				close(output)
+			}()
+		}
 
 		p.stages = append(p.stages, stage)
 
 		lastOutput = output
 	}
 
-	if p.collector != nil {
-		go p.collector.Run(p.Backend)
+	go func() {
+		for _, stage := range p.stages {
+			stage.Wait()
+		}
+		close(p.Backend)
+	}()
+
+	if p.collector == nil {
+		p.CollectorStage(func(any) {})
 	}
+	p.collector.wait.Add(1)
+	go func() {
+		p.collector.Run(p.Backend)
+		close(p.drained)
+	}()
 
 	go func() {
 		for {
 			select {
+			case <-p.quit:
+				logging.ClearProgress()
+				return
 			case _, isOpen := <-p.status:
 				if !isOpen {
-					fmt.Printf(util.ClearTermLineString())
+					logging.ClearProgress()
 					return
 				}
 
-				statuses := make([]string, 0)
-				for _, stage := range p.stages {
-					statuses = append(statuses, (*stage).Status())
-				}
-				if p.collector != nil {
-					statuses = append(statuses, p.collector.Status())
-				}
-				statuses = append(statuses, fmt.Sprintf("failed %d", p.failCount.Load()))
-
-				fmt.Printf("%sprocessing: %s", util.ClearTermLineString(), strings.Join(statuses, " -> "))
+				logging.Progress(p.progressLine())
 			}
 		}
 	}()
 }
 
+// progressLine shows how many of the items have finished, the rate they are finishing at and how long the rest should
+// take, followed by the status of each stage. It is kept short so that it doesn't wrap, which would stop it being
+// redrawn in place
+func (p *Pipeline) progressLine() string {
+	elapsed := time.Since(p.started)
+	failed := p.failCount.Load()
+	finished := failed
+	if p.collector != nil {
+		finished += p.collector.Count()
+	}
+	total := p.Total()
+
+	line := fmt.Sprintf("processing: %d/%d, %d failed", finished, total, failed)
+	if p.IsPaused() {
+		line = "paused, " + line
+	}
+	if finished > 0 {
+		rate := float64(finished) / elapsed.Seconds()
+		line += fmt.Sprintf(", %.1f/s", rate)
+		if remaining := total - finished; remaining > 0 {
+			eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+			line += fmt.Sprintf(", eta %s", eta.Round(time.Second))
+		}
+	}
+
+	statuses := make([]string, 0)
+	for _, stage := range p.stages {
+		statuses = append(statuses, stage.Status(elapsed))
+	}
+	return line + " | " + strings.Join(statuses, " -> ")
+}
+
+// Drain stops the pipeline from accepting new items, then waits until every item already submitted has been collected
+// or has failed
+func (p *Pipeline) Drain() {
+	p.drainOnce.Do(func() {
+		p.submitLock.Lock()
+		defer p.submitLock.Unlock()
+		p.draining = true
+		close(p.Frontend)
+	})
+	<-p.drained
+}
+
+// Wait blocks until the workers of every stage and the collector have stopped
 func (p *Pipeline) Wait() {
 	for _, stage := range p.stages {
 		stage.Wait()
 	}
 	if p.collector != nil {
-		p.collector.Quit <- struct{}{}
 		p.collector.Wait()
 	}
 }
 
+// Close stops the pipeline, abandoning any items that haven't been drained
 func (p *Pipeline) Close() {
-	close(p.Frontend)
+	close(p.quit)
 	for _, stage := range p.stages {
 		stage.Close()
 	}
-	for _, channel := range p.channels {
-		close(channel)
-	}
-	close(p.Backend)
 	if p.collector != nil {
+		p.collector.Stop()
+		p.collector.Wait()
 		p.collector.Close()
 	}
-	fmt.Printf(util.ClearTermLineString())
+	logging.ClearProgress()
 }
@@ -0,0 +1,239 @@
+package pipeline_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/pipeline"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	p := pipeline.NewPipeline(4)
+	p.AppendStage("double", func(ctx context.Context, a any) (any, error) {
+		n := a.(int)
+		if n%10 == 0 {
+			return nil, fmt.Errorf("%d is a multiple of 10", n)
+		}
+		if n%7 == 0 {
+			return pipeline.Complete(n), nil
+		}
+		return n * 2, nil
+	})
+	p.AppendStage("increment", func(ctx context.Context, a any) (any, error) {
+		return a.(int) + 1000, nil
+	})
+
+	var lock sync.Mutex
+	collected := make(map[int]bool)
+	p.CollectorStage(func(a any) {
+		lock.Lock()
+		defer lock.Unlock()
+		collected[a.(int)] = true
+	})
+
+	failed := make([]any, 0)
+	p.Run(context.Background(), func(a any, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		failed = append(failed, a)
+	})
+
+	for n := 1; n <= 100; n++ {
+		assert.True(t, p.Submit(n))
+	}
+	p.Drain()
+	assert.False(t, p.Submit(101))
+	p.Close()
+
+	assert.Len(t, failed, 10)
+	assert.Len(t, collected, 90)
+	assert.True(t, collected[7], "completed items skip the remaining stages")
+	assert.True(t, collected[1006])
+	assert.Equal(t, int64(0), p.InFlight())
+	assert.Equal(t, int64(100), p.Total())
+}
+
+func TestDrainInterrupted(t *testing.T) {
+	p := pipeline.NewPipeline(2)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	p.AppendStage("block", func(ctx context.Context, a any) (any, error) {
+		if a.(int) == 1 {
+			close(started)
+			<-release
+		}
+		return a, nil
+	})
+
+	count := 0
+	p.CollectorStage(func(a any) {
+		count++
+	})
+	p.Run(context.Background(), func(a any, err error) {})
+
+	assert.True(t, p.Submit(1))
+	<-started
+	p.Interrupt()
+	assert.False(t, p.Submit(2))
+	close(release)
+
+	p.Drain()
+	p.Close()
+	assert.Equal(t, 1, count, "items already started are still finished")
+	assert.Equal(t, int64(0), p.InFlight())
+}
+
+func TestItemTimeout(t *testing.T) {
+	p := pipeline.NewPipeline(1)
+	p.SetItemTimeout(10 * time.Millisecond)
+	p.AppendStage("slow", func(ctx context.Context, a any) (any, error) {
+		<-ctx.Done()
+		// a stage that doesn't pass the context's error on still times out
+		return a, fmt.Errorf("gave up")
+	})
+	p.CollectorStage(func(a any) {})
+
+	var failure error
+	p.Run(context.Background(), func(a any, err error) {
+		failure = err
+	})
+	assert.True(t, p.Submit(1))
+	p.Drain()
+	p.Close()
+
+	assert.EqualError(t, failure, "gave up")
+	assert.ErrorIs(t, failure, context.DeadlineExceeded)
+}
+
+func TestPause(t *testing.T) {
+	p := pipeline.NewPipeline(2)
+	p.AppendStage("pass", func(ctx context.Context, a any) (any, error) {
+		return a, nil
+	})
+	var lock sync.Mutex
+	collected := make([]any, 0)
+	p.CollectorStage(func(a any) {
+		lock.Lock()
+		defer lock.Unlock()
+		collected = append(collected, a)
+	})
+	p.Run(context.Background(), func(any, error) {})
+
+	assert.True(t, p.Pause())
+	assert.False(t, p.Pause())
+	assert.True(t, p.IsPaused())
+	// the three workers may have been waiting on the frontend already, so at most that many items can start
+	go func() {
+		for n := 1; n <= 10; n++ {
+			p.Submit(n)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	lock.Lock()
+	assert.LessOrEqual(t, len(collected), 3)
+	lock.Unlock()
+
+	assert.False(t, p.Resume())
+	assert.True(t, p.Resume())
+	assert.False(t, p.IsPaused())
+	assert.Eventually(t, func() bool { return p.InFlight() == 0 && p.Total() == 10 }, time.Second, time.Millisecond)
+	p.Drain()
+	p.Close()
+	assert.Len(t, collected, 10)
+}
+
+func TestHold(t *testing.T) {
+	p := pipeline.NewPipeline(1)
+	p.SetItemTimeout(20 * time.Millisecond)
+	outage := make(chan struct{})
+	p.AppendStage("await", func(ctx context.Context, a any) (any, error) {
+		if a.(int) == 1 {
+			// waiting out an outage longer than the item timeout doesn't time the item out
+			release := pipeline.Hold(ctx)
+			<-outage
+			release()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return a, nil
+		}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	var lock sync.Mutex
+	collected := make([]any, 0)
+	p.CollectorStage(func(a any) {
+		lock.Lock()
+		defer lock.Unlock()
+		collected = append(collected, a)
+	})
+	failures := make([]error, 0)
+	p.Run(context.Background(), func(a any, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		failures = append(failures, err)
+	})
+
+	assert.True(t, p.Submit(1))
+	time.Sleep(100 * time.Millisecond)
+	close(outage)
+	// an item that isn't held still runs out of time
+	assert.True(t, p.Submit(2))
+	p.Drain()
+	p.Close()
+
+	assert.Equal(t, []any{1}, collected)
+	assert.Len(t, failures, 1)
+	assert.ErrorIs(t, failures[0], context.DeadlineExceeded)
+}
+
+func TestStageThreads(t *testing.T) {
+	p := pipeline.NewPipeline(4)
+	p.AppendStage("pass", func(ctx context.Context, a any) (any, error) {
+		return a, nil
+	})
+	var lock sync.Mutex
+	running, most := 0, 0
+	p.AppendWeightedStage("wait", 3, func(ctx context.Context, a any) (any, error) {
+		lock.Lock()
+		running++
+		most = max(most, running)
+		lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		lock.Lock()
+		running--
+		lock.Unlock()
+		return a, nil
+	})
+	p.CollectorStage(func(a any) {})
+	p.Run(context.Background(), func(a any, err error) {})
+	for n := 0; n < 30; n++ {
+		assert.True(t, p.Submit(n))
+	}
+	p.Drain()
+	p.Close()
+	// the weighted stage gets three of the four threads
+	assert.Equal(t, 3, most)
+
+	// a stage still gets a thread when there are fewer threads than stages
+	p = pipeline.NewPipeline(1)
+	for _, name := range []string{"first", "second", "third"} {
+		p.AppendStage(name, func(ctx context.Context, a any) (any, error) {
+			return a, nil
+		})
+	}
+	collected := 0
+	p.CollectorStage(func(a any) {
+		collected++
+	})
+	p.Run(context.Background(), func(a any, err error) {})
+	for n := 0; n < 5; n++ {
+		assert.True(t, p.Submit(n))
+	}
+	p.Drain()
+	p.Close()
+	assert.Equal(t, 5, collected)
+}
@@ -1,73 +1,245 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"github.com/larkwiot/booker/internal/util"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+type completedItem struct {
+	item any
+}
+
+// Complete wraps a worker result so that it skips any remaining stages and goes straight to the collector
+func Complete(item any) any {
+	return completedItem{item: item}
+}
+
+// Completed unwraps an item given to Complete, for running the workers of the stages one after another by hand
+func Completed(result any) (any, bool) {
+	completed, ok := result.(completedItem)
+	return completed.item, ok
+}
+
+type itemContextKey struct{}
+
+// itemContext is the context of an item, which runs out once the item has been worked on for its timeout. The time it
+// is held doesn't count.
+type itemContext struct {
+	context.Context
+	lock    sync.Mutex
+	timer   *time.Timer
+	left    time.Duration
+	started time.Time
+	holds   int
+	expired bool
+}
+
+// WithItemTimeout is ctx limited to timeout, not counting the time it is held with Hold
+func WithItemTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	inner, cancel := context.WithCancel(ctx)
+	c := &itemContext{Context: inner, left: timeout, started: time.Now()}
+	c.timer = time.AfterFunc(timeout, func() {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		// the timer went off just as the item was held, so it goes off again once the item is let go
+		if c.holds > 0 {
+			return
+		}
+		c.expired = true
+		cancel()
+	})
+	return c, func() {
+		c.timer.Stop()
+		cancel()
+	}
+}
+
+func (c *itemContext) Err() error {
+	err := c.Context.Err()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err != nil && c.expired {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (c *itemContext) Value(key any) any {
+	if key == (itemContextKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+// Hold stops the timeout of the item ctx belongs to, if it has one, until the returned function is called. It is for
+// waiting on what isn't up to the item, like a service that is down, which would otherwise use up the item's time.
+func Hold(ctx context.Context) func() {
+	c, ok := ctx.Value(itemContextKey{}).(*itemContext)
+	if !ok {
+		return func() {}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.holds++
+	if c.holds == 1 {
+		c.timer.Stop()
+		c.left -= time.Since(c.started)
+	}
+
+	var release sync.Once
+	return func() {
+		release.Do(func() {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			c.holds--
+			if c.holds == 0 && !c.expired {
+				c.started = time.Now()
+				c.timer.Reset(max(c.left, 0))
+			}
+		})
+	}
+}
+
 type Stage struct {
-	Name   string
-	pool   util.ThreadPool
-	worker func(any) (any, error)
-	quit   chan struct{}
+	Name      string
+	workers   int64
+	worker    func(context.Context, any) (any, error)
+	quit      chan struct{}
+	interrupt <-chan struct{}
+	// resumed, if set, is waited on before taking each item
+	resumed func() <-chan struct{}
+	timeout time.Duration
+	backend chan any
+	// running are the workers that haven't stopped yet, active those of them working on an item
+	running sync.WaitGroup
+	active  atomic.Int64
+	// done counts the items the worker has finished with, successfully or not
+	done atomic.Int64
 }
 
-func NewStage(name string, poolSize int64, worker func(any) (any, error)) *Stage {
+// NewStage makes a stage that runs worker on up to poolSize items at a time
+func NewStage(name string, poolSize int64, worker func(context.Context, any) (any, error)) *Stage {
 	s := &Stage{
-		Name:   name,
-		pool:   util.ThreadPool{Size: poolSize + 1},
-		worker: worker,
-		quit:   make(chan struct{}),
+		Name:    name,
+		workers: poolSize,
+		worker:  worker,
+		quit:    make(chan struct{}),
 	}
 
 	return s
 }
 
+// Wait blocks until every worker has stopped, which they do once the input is closed and empty or the stage is closed
 func (s *Stage) Wait() {
-	s.pool.Wait()
+	s.running.Wait()
 }
 
 func (s *Stage) Close() {
 	close(s.quit)
-	s.pool.Wait()
+	s.running.Wait()
 }
 
-func (s *Stage) Run(input chan any, output chan any, failHandler func(any, error)) {
+// send passes an item on, giving up if the stage is closed before anything takes it
+func (s *Stage) send(output chan any, item any) {
+	select {
+	case output <- item:
+	case <-s.quit:
+	}
+}
+
+// Run starts the stage's workers and returns, each worker takes items from input until it is closed or the stage is. Since there
+// are only so many workers and the channels between stages are bounded, a slow stage holds back the ones before it
+// instead of letting items pile up in memory.
+func (s *Stage) Run(ctx context.Context, input chan any, output chan any, failHandler func(any, error)) {
 	work := func(i any) {
-		s.pool.StartThread()
-		defer s.pool.StopThread()
-		result, err := s.worker(i)
+		s.active.Add(1)
+		defer s.active.Add(-1)
+		if s.isInterrupted() {
+			failHandler(i, ErrInterrupted)
+			return
+		}
+
+		itemCtx := ctx
+		if s.timeout > 0 {
+			var cancel context.CancelFunc
+			itemCtx, cancel = WithItemTimeout(ctx, s.timeout)
+			defer cancel()
+		}
+
+		result, err := s.worker(itemCtx, i)
+		s.done.Add(1)
+		if ctx.Err() != nil {
+			// the whole pipeline was cancelled, so this is not a failure of the item itself
+			failHandler(i, ErrInterrupted)
+			return
+		}
+		if err != nil && errors.Is(itemCtx.Err(), context.DeadlineExceeded) {
+			err = TimedOut(err)
+		}
 		if result == nil || err != nil {
 			failHandler(i, err)
 			return
 		}
-		output <- result
+		if completed, ok := result.(completedItem); ok && s.backend != nil {
+			s.send(s.backend, completed.item)
+			return
+		}
+		s.send(output, result)
 	}
 
-	for {
-		select {
-		case i, isOpen := <-input:
-			if !isOpen {
-				return
+	s.running.Add(int(s.workers))
+	for range s.workers {
+		go func() {
+			defer s.running.Done()
+			for {
+				if s.resumed != nil {
+					select {
+					case <-s.resumed():
+					// an interrupted pipeline skips the items it was holding back
+					case <-s.interrupt:
+					case <-s.quit:
+						return
+					}
+				}
+				select {
+				case i, isOpen := <-input:
+					if !isOpen {
+						return
+					}
+					work(i)
+				case <-s.quit:
+					return
+				}
 			}
+		}()
+	}
+}
 
-			go work(i)
-		case <-s.quit:
-			return
-		}
+func (s *Stage) isInterrupted() bool {
+	select {
+	case <-s.interrupt:
+		return true
+	default:
+		return false
 	}
 }
 
-func (s *Stage) Status() string {
-	return fmt.Sprintf("%s %d", s.Name, s.pool.Count.Load())
+// Status shows how many items the stage is working on, and the rate it has finished them at over elapsed
+func (s *Stage) Status(elapsed time.Duration) string {
+	rate := float64(s.done.Load()) / max(elapsed.Seconds(), 1)
+	return fmt.Sprintf("%s %d @ %.1f/s", s.Name, s.active.Load(), rate)
 }
 
 type CollectorStage struct {
 	collector func(any)
 	wait      sync.WaitGroup
-	count     uint64
+	count     atomic.Int64
 	Quit      chan struct{}
+	stopOnce  sync.Once
 }
 
 func NewCollectorStage(collector func(any)) *CollectorStage {
@@ -77,8 +249,9 @@ func NewCollectorStage(collector func(any)) *CollectorStage {
 	}
 }
 
+// Run collects items until input is closed or the collector is stopped. Add it to wait before starting it, so that
+// Wait can't miss it
 func (s *CollectorStage) Run(input chan any) {
-	s.wait.Add(1)
 	defer s.wait.Done()
 
 	for {
@@ -87,7 +260,7 @@ func (s *CollectorStage) Run(input chan any) {
 			if !isOpen {
 				return
 			}
-			s.count++
+			s.count.Add(1)
 			s.collector(output)
 		case <-s.Quit:
 			return
@@ -95,14 +268,22 @@ func (s *CollectorStage) Run(input chan any) {
 	}
 }
 
+// Stop makes Run return, it is safe to call more than once
+func (s *CollectorStage) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.Quit)
+	})
+}
+
 func (s *CollectorStage) Wait() {
 	s.wait.Wait()
 }
 
 func (s *CollectorStage) Close() {
-	s.count = 0
+	s.count.Store(0)
 }
 
-func (s *CollectorStage) Status() string {
-	return fmt.Sprintf("collected %d", s.count)
+// Count is the number of items collected
+func (s *CollectorStage) Count() int64 {
+	return s.count.Load()
 }
@@ -0,0 +1,112 @@
+package providercache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"log/slog"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS results (
+	provider TEXT NOT NULL,
+	key      TEXT NOT NULL,
+	result   TEXT NOT NULL,
+	stored   INTEGER NOT NULL,
+	PRIMARY KEY (provider, key)
+);
+`
+
+// queryPrefix starts the keys of title and author searches, the rest being identifiers
+const queryPrefix = "query:"
+
+// Cache keeps what the providers found in a SQLite database between runs, so that a book searched again doesn't take
+// another request. Results found by identifier and by title and author search expire separately, since the records of
+// an identifier rarely change while a search may find a better match once a provider knows more books.
+type Cache struct {
+	db            *sql.DB
+	identifierTtl time.Duration
+	queryTtl      time.Duration
+}
+
+// Open opens or creates the cache at filePath, dropping the results that have expired
+func Open(filePath string, identifierTtl time.Duration, queryTtl time.Duration) (*Cache, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error: unable to create provider cache directory: %s", err.Error())
+	}
+
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error: unable to open provider cache %s: %s", filePath, err.Error())
+	}
+	// every provider shares the one connection, so their writes never run into each other
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(schema)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error: unable to create provider cache %s: %s", filePath, err.Error())
+	}
+
+	cache := &Cache{db: db, identifierTtl: identifierTtl, queryTtl: queryTtl}
+	now := time.Now()
+	_, err = db.Exec("DELETE FROM results WHERE (key LIKE ? AND stored < ?) OR (key NOT LIKE ? AND stored < ?)",
+		queryPrefix+"%", now.Add(-queryTtl).Unix(), queryPrefix+"%", now.Add(-identifierTtl).Unix())
+	if err != nil {
+		slog.Warn("failed to drop expired provider results", "path", filePath, "error", err)
+	}
+	return cache, nil
+}
+
+func (c *Cache) ttl(key string) time.Duration {
+	if strings.HasPrefix(key, queryPrefix) {
+		return c.queryTtl
+	}
+	return c.identifierTtl
+}
+
+// Load returns the result provider found for key, unless there is none or it has expired
+func (c *Cache) Load(provider string, key string) (book.BookResult, bool) {
+	var data string
+	var stored int64
+	err := c.db.QueryRow("SELECT result, stored FROM results WHERE provider = ? AND key = ?", provider, key).Scan(&data, &stored)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			slog.Warn("failed to read provider cache", "provider", provider, "key", key, "error", err)
+		}
+		return book.BookResult{}, false
+	}
+	if time.Since(time.Unix(stored, 0)) > c.ttl(key) {
+		return book.BookResult{}, false
+	}
+
+	var result book.BookResult
+	err = json.Unmarshal([]byte(data), &result)
+	if err != nil {
+		slog.Warn("failed to decode provider cache", "provider", provider, "key", key, "error", err)
+		return book.BookResult{}, false
+	}
+	return result, true
+}
+
+// Store keeps the result provider found for key, replacing any it had
+func (c *Cache) Store(provider string, key string, result book.BookResult) {
+	data, err := json.Marshal(result)
+	if err == nil {
+		_, err = c.db.Exec("INSERT OR REPLACE INTO results (provider, key, result, stored) VALUES (?, ?, ?, ?)", provider, key, string(data), time.Now().Unix())
+	}
+	if err != nil {
+		slog.Warn("failed to write provider cache", "provider", provider, "key", key, "error", err)
+	}
+}
+
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
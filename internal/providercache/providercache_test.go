@@ -0,0 +1,47 @@
+package providercache_test
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/providercache"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "cache", "providers.db")
+	cache, err := providercache.Open(filePath, time.Hour, time.Hour)
+	assert.NoError(t, err)
+
+	result := book.BookResult{
+		Title:              mo.Some("How to Hack Like a Ghost"),
+		Authors:            mo.Some([]string{"Sparc Flow"}),
+		Isbn13:             mo.Some(book.ISBN13("9781718501263")),
+		Confidence:         100,
+		SourceProviderName: "google",
+	}
+	cache.Store("Google", "9781718501263", result)
+	cache.Store("Google", "query:how to hack like a ghost|sparc flow", result)
+
+	_, found := cache.Load("Isbndb", "9781718501263")
+	assert.False(t, found)
+	assert.NoError(t, cache.Close())
+
+	cache, err = providercache.Open(filePath, time.Hour, time.Hour)
+	assert.NoError(t, err)
+	stored, found := cache.Load("Google", "9781718501263")
+	assert.True(t, found)
+	assert.Equal(t, result, stored)
+	assert.NoError(t, cache.Close())
+
+	// a negative TTL has everything stored so far expire, whatever the clock's resolution
+	cache, err = providercache.Open(filePath, time.Hour, -time.Second)
+	assert.NoError(t, err)
+	_, found = cache.Load("Google", "query:how to hack like a ghost|sparc flow")
+	assert.False(t, found)
+	_, found = cache.Load("Google", "9781718501263")
+	assert.True(t, found)
+	assert.NoError(t, cache.Close())
+}
@@ -0,0 +1,304 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const amazonService = "ProductAdvertisingAPI"
+const amazonGetItemsTarget = "com.amazon.paapi5.v1.ProductAdvertisingAPIv1.GetItems"
+
+type amazonDisplayValue struct {
+	DisplayValue string `json:"DisplayValue"`
+}
+
+type amazonItem struct {
+	Asin     string `json:"ASIN"`
+	ItemInfo struct {
+		Title      amazonDisplayValue `json:"Title"`
+		ByLineInfo struct {
+			Contributors []struct {
+				Name     string `json:"Name"`
+				RoleType string `json:"RoleType"`
+			} `json:"Contributors"`
+			Manufacturer amazonDisplayValue `json:"Manufacturer"`
+		} `json:"ByLineInfo"`
+		Classifications struct {
+			Binding amazonDisplayValue `json:"Binding"`
+		} `json:"Classifications"`
+		ContentInfo struct {
+			PagesCount struct {
+				DisplayValue uint `json:"DisplayValue"`
+			} `json:"PagesCount"`
+			PublicationDate amazonDisplayValue `json:"PublicationDate"`
+		} `json:"ContentInfo"`
+		ExternalIds struct {
+			Isbns struct {
+				DisplayValues []string `json:"DisplayValues"`
+			} `json:"ISBNs"`
+			Eans struct {
+				DisplayValues []string `json:"DisplayValues"`
+			} `json:"EANs"`
+		} `json:"ExternalIds"`
+	} `json:"ItemInfo"`
+	Images struct {
+		Primary struct {
+			Large struct {
+				Url string `json:"URL"`
+			} `json:"Large"`
+		} `json:"Primary"`
+	} `json:"Images"`
+}
+
+type amazonGetItemsResponse struct {
+	ItemsResult struct {
+		Items []amazonItem `json:"Items"`
+	} `json:"ItemsResult"`
+	Errors []struct {
+		Code    string `json:"Code"`
+		Message string `json:"Message"`
+	} `json:"Errors"`
+}
+
+// Amazon looks books up by ASIN through the Product Advertising API, which needs an Amazon Associates account
+type Amazon struct {
+	name        string
+	host        string
+	region      string
+	marketplace string
+	accessKey   string
+	secretKey   string
+	partnerTag  string
+	client      *http.Client
+}
+
+func NewAmazon(conf *config.AmazonConfig, client *http.Client) Provider {
+	amazon := Amazon{
+		name:        instanceName(conf.Name, "Amazon"),
+		host:        conf.Host,
+		region:      conf.Region,
+		marketplace: conf.Marketplace,
+		accessKey:   conf.AccessKey,
+		secretKey:   conf.SecretKey,
+		partnerTag:  conf.PartnerTag,
+		client:      client,
+	}
+	return NewGeneric(&amazon, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (a *Amazon) Name() string {
+	return a.name
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// sign adds AWS Signature Version 4 headers to a request with the given body,
+// see https://webservices.amazon.com/paapi5/documentation/sending-request.html
+func (a *Amazon) sign(request *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	request.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := []string{"content-encoding", "content-type", "host", "x-amz-date", "x-amz-target"}
+	canonicalHeaders := strings.Builder{}
+	for _, header := range signedHeaders {
+		value := request.Header.Get(header)
+		if header == "host" {
+			value = request.Host
+		}
+		canonicalHeaders.WriteString(fmt.Sprintf("%s:%s\n", header, strings.TrimSpace(value)))
+	}
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		sha256Hex(body),
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, a.region, amazonService)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSha256([]byte("AWS4"+a.secretKey), date)
+	key = hmacSha256(key, a.region)
+	key = hmacSha256(key, amazonService)
+	key = hmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.accessKey, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func (a *Amazon) getItem(ctx context.Context, asin book.ASIN) (*amazonItem, error, int) {
+	body, err := json.Marshal(map[string]any{
+		"ItemIds":     []string{string(asin)},
+		"ItemIdType":  "ASIN",
+		"PartnerTag":  a.partnerTag,
+		"PartnerType": "Associates",
+		"Marketplace": a.marketplace,
+		"Resources": []string{
+			"ItemInfo.Title",
+			"ItemInfo.ByLineInfo",
+			"ItemInfo.Classifications",
+			"ItemInfo.ContentInfo",
+			"ItemInfo.ExternalIds",
+			"Images.Primary.Large",
+		},
+	})
+	if err != nil {
+		return nil, err, 0
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("https://%s/paapi5/getitems", a.host), bytes.NewReader(body))
+	if err != nil {
+		return nil, err, 0
+	}
+	request.Header.Set("Content-Encoding", "amz-1.0")
+	request.Header.Set("Content-Type", "application/json; charset=utf-8")
+	request.Header.Set("X-Amz-Target", amazonGetItemsTarget)
+	a.sign(request, body, time.Now())
+
+	response, err := send(a.client, request)
+	if err != nil {
+		return nil, err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, nil, response.StatusCode
+	}
+
+	if response.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(response.Body)
+		return nil, rateLimited(response, fmt.Errorf("amazon returned bad status code %d: %s", response.StatusCode, string(data))), response.StatusCode
+	}
+
+	var result amazonGetItemsResponse
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err, response.StatusCode
+	}
+
+	// items that don't exist come back as an error alongside a successful status
+	if len(result.ItemsResult.Items) == 0 {
+		for _, e := range result.Errors {
+			if e.Code != "InvalidParameterValue" && e.Code != "ItemNotAccessible" {
+				return nil, fmt.Errorf("amazon returned error %s: %s", e.Code, e.Message), response.StatusCode
+			}
+		}
+		return nil, nil, http.StatusNotFound
+	}
+
+	return &result.ItemsResult.Items[0], nil, response.StatusCode
+}
+
+func (a *Amazon) FindResultByAsin(ctx context.Context, asin book.ASIN, filePath string) (book.BookResult, error, int) {
+	item, err, statusCode := a.getItem(ctx, asin)
+	if err != nil || item == nil {
+		return book.BookResult{}, err, statusCode
+	}
+	return a.toBookResult(item, filePath, 100), nil, statusCode
+}
+
+// FindResult looks up print books, whose ASIN is their ISBN-10
+func (a *Amazon) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	isbn10 := book.ISBN10(isbn)
+	if len(isbn) == 13 {
+		isbn13 := book.ISBN13(isbn)
+		var ok bool
+		isbn10, ok = isbn13.Isbn10()
+		if !ok {
+			return book.BookResult{}, nil, http.StatusNotFound
+		}
+	}
+	return a.FindResultByAsin(ctx, book.ASIN(isbn10), filePath)
+}
+
+func (a *Amazon) toBookResult(item *amazonItem, filePath string, confidence float64) book.BookResult {
+	info := &item.ItemInfo
+	result := book.BookResult{
+		Filepath:           filePath,
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(a.name),
+	}
+
+	if len(item.Asin) > 0 {
+		result.Asin = mo.Some(book.ASIN(item.Asin))
+	}
+	if len(info.Title.DisplayValue) > 0 {
+		result.Title = mo.Some(info.Title.DisplayValue)
+	}
+
+	authors := make([]string, 0)
+	for _, contributor := range info.ByLineInfo.Contributors {
+		if contributor.RoleType == "author" && len(contributor.Name) > 0 {
+			authors = append(authors, contributor.Name)
+		}
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	for _, isbn := range info.ExternalIds.Isbns.DisplayValues {
+		if isbn10 := book.ISBN10(isbn); len(isbn) == 10 && isbn10.IsValid() {
+			result.Isbn10 = mo.Some(isbn10)
+			break
+		}
+	}
+	for _, ean := range info.ExternalIds.Eans.DisplayValues {
+		// EANs of books are their ISBN-13
+		if isbn13 := book.ISBN13(ean); len(ean) == 13 && (strings.HasPrefix(ean, "978") || strings.HasPrefix(ean, "979")) && isbn13.IsValid() {
+			result.Isbn13 = mo.Some(isbn13)
+			break
+		}
+	}
+
+	if len(info.ByLineInfo.Manufacturer.DisplayValue) > 0 {
+		result.Publisher = mo.Some(info.ByLineInfo.Manufacturer.DisplayValue)
+	}
+	if date := info.ContentInfo.PublicationDate.DisplayValue; len(date) >= 4 {
+		date, _, _ = strings.Cut(date, "T")
+		result.PublishDate = mo.Some(date)
+	}
+	if len(info.Classifications.Binding.DisplayValue) > 0 {
+		result.Binding = mo.Some(info.Classifications.Binding.DisplayValue)
+	}
+	if info.ContentInfo.PagesCount.DisplayValue > 0 {
+		result.Pages = mo.Some(info.ContentInfo.PagesCount.DisplayValue)
+	}
+	if len(item.Images.Primary.Large.Url) > 0 {
+		result.CoverUrl = mo.Some(item.Images.Primary.Large.Url)
+	}
+
+	return result
+}
+
+func (a *Amazon) Shutdown() {
+}
+
+func (a *Amazon) HealthCheck() (bool, string) {
+	return probe(a.client, "https://"+a.host)
+}
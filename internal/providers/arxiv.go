@@ -0,0 +1,155 @@
+package providers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type arxivEntry struct {
+	Id        string `xml:"id"`
+	Published string `xml:"published"`
+	Title     string `xml:"title"`
+	Summary   string `xml:"summary"`
+	Authors   []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Doi        string `xml:"doi"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+}
+
+type arxivFeed struct {
+	Entries []arxivEntry `xml:"entry"`
+}
+
+// Arxiv looks preprints up by their arXiv identifiers, so that papers with neither an ISBN nor a DOI are identified
+type Arxiv struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+func NewArxiv(conf *config.ArxivConfig, client *http.Client) Provider {
+	arxiv := Arxiv{
+		name:   instanceName(conf.Name, "arXiv"),
+		url:    fmt.Sprintf("https://%s", conf.Url),
+		client: client,
+	}
+	return NewGeneric(&arxiv, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (a *Arxiv) Name() string {
+	return a.name
+}
+
+func (a *Arxiv) Accepts(search *SearchTerms) bool {
+	return len(search.Arxivs) > 0
+}
+
+func (a *Arxiv) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	return book.BookResult{}, nil, http.StatusNotFound
+}
+
+func (a *Arxiv) FindResultByArxiv(ctx context.Context, arxiv book.ArxivId, filePath string) (book.BookResult, error, int) {
+	query := url.Values{}
+	query.Set("id_list", string(arxiv))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", a.url, query.Encode()), nil)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+
+	response, err := send(a.client, request)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return book.BookResult{}, rateLimited(response, fmt.Errorf("arxiv returned bad status code %d: %s", response.StatusCode, string(body))), response.StatusCode
+	}
+
+	var feed arxivFeed
+	err = xml.NewDecoder(response.Body).Decode(&feed)
+	if err != nil {
+		return book.BookResult{}, fmt.Errorf("error: unable to decode arxiv response: %s", err.Error()), response.StatusCode
+	}
+	if len(feed.Entries) == 0 {
+		return book.BookResult{}, nil, http.StatusNotFound
+	}
+
+	entry := &feed.Entries[0]
+	// an identifier arXiv can't make sense of is answered with an entry describing the error
+	if strings.Contains(entry.Id, "/api/errors") {
+		return book.BookResult{}, fmt.Errorf("arxiv could not look up %s: %s", arxiv, strings.TrimSpace(entry.Summary)), http.StatusBadRequest
+	}
+	if len(strings.TrimSpace(entry.Title)) == 0 {
+		return book.BookResult{}, nil, http.StatusNotFound
+	}
+
+	return a.toBookResult(entry, arxiv, filePath), nil, response.StatusCode
+}
+
+func (a *Arxiv) toBookResult(entry *arxivEntry, arxiv book.ArxivId, filePath string) book.BookResult {
+	result := book.BookResult{
+		Filepath: filePath,
+		// titles and abstracts are wrapped over several lines
+		Title:              mo.Some(strings.Join(strings.Fields(entry.Title), " ")),
+		Arxiv:              mo.Some(arxiv),
+		Publisher:          mo.Some("arXiv"),
+		Description:        mo.EmptyableToOption(util.ShortDescription(strings.Join(strings.Fields(entry.Summary), " "))),
+		Confidence:         100,
+		SourceProviderName: strings.ToLower(a.name),
+	}
+
+	authors := make([]string, 0, len(entry.Authors))
+	for _, author := range entry.Authors {
+		if name := strings.TrimSpace(author.Name); len(name) > 0 {
+			authors = append(authors, name)
+		}
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	// the DOI of the published version if there is one, and otherwise the one arXiv registers for every preprint
+	if doi := strings.TrimSpace(entry.Doi); len(doi) > 0 {
+		result.Doi = mo.Some(book.DOI(strings.ToLower(doi)))
+	} else {
+		result.Doi = mo.Some(book.DOI(strings.ToLower(fmt.Sprintf("10.48550/arXiv.%s", arxiv))))
+	}
+
+	if date, _, _ := strings.Cut(entry.Published, "T"); len(date) > 0 {
+		result.PublishDate = mo.Some(date)
+	}
+
+	subjects := make([]string, 0, len(entry.Categories))
+	for _, category := range entry.Categories {
+		if len(category.Term) > 0 {
+			subjects = append(subjects, category.Term)
+		}
+	}
+	if len(subjects) > 0 {
+		result.Subjects = mo.Some(subjects)
+	}
+
+	return result
+}
+
+func (a *Arxiv) Shutdown() {
+}
+
+func (a *Arxiv) HealthCheck() (bool, string) {
+	return probe(a.client, a.url)
+}
@@ -0,0 +1,85 @@
+package providers_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const arxivResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:arxiv="http://arxiv.org/schemas/atom">
+  <entry>
+    <id>http://arxiv.org/abs/1706.03762v7</id>
+    <published>2017-06-12T17:57:34Z</published>
+    <title>Attention Is All
+  You Need</title>
+    <summary>  The dominant sequence transduction models are based on complex recurrent or
+convolutional neural networks.</summary>
+    <author><name>Ashish Vaswani</name></author>
+    <author><name>Noam Shazeer</name></author>
+    <arxiv:primary_category term="cs.CL" scheme="http://arxiv.org/schemas/atom"/>
+    <category term="cs.CL" scheme="http://arxiv.org/schemas/atom"/>
+    <category term="cs.LG" scheme="http://arxiv.org/schemas/atom"/>
+  </entry>
+</feed>`
+
+const arxivErrorResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <entry>
+    <id>http://arxiv.org/api/errors#incorrect_id_format_for_1706.0376</id>
+    <title>Error</title>
+    <summary>incorrect id format for 1706.0376</summary>
+  </entry>
+</feed>`
+
+func TestArxiv(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		if r.URL.Query().Get("id_list") == "1706.03762" {
+			w.Write([]byte(arxivResponse))
+		} else {
+			w.Write([]byte(arxivErrorResponse))
+		}
+	}))
+	defer server.Close()
+
+	conf := config.ArxivConfig{Url: strings.TrimPrefix(server.URL, "https://"), Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider := providers.NewArxiv(&conf, server.Client())
+
+	// books without an arXiv identifier aren't looked up
+	results, err := provider.GetBookMetadata(context.Background(), &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263"}})
+	assert.NoError(t, err)
+	assert.Empty(t, results)
+
+	results, err = provider.GetBookMetadata(context.Background(), &providers.SearchTerms{Arxivs: []book.ArxivId{"1706.03762"}, Filepath: "/papers/attention.pdf"})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, mo.Some("Attention Is All You Need"), results[0].Title)
+	assert.Equal(t, mo.Some([]string{"Ashish Vaswani", "Noam Shazeer"}), results[0].Authors)
+	assert.Equal(t, mo.Some(book.ArxivId("1706.03762")), results[0].Arxiv)
+	assert.Equal(t, mo.Some(book.DOI("10.48550/arxiv.1706.03762")), results[0].Doi)
+	assert.Equal(t, mo.Some("2017-06-12"), results[0].PublishDate)
+	assert.Equal(t, mo.Some([]string{"cs.CL", "cs.LG"}), results[0].Subjects)
+
+	assert.Equal(t, "arxiv", results[0].SourceProviderName)
+
+	_, err = provider.GetBookMetadata(context.Background(), &providers.SearchTerms{Arxivs: []book.ArxivId{"1706.0376"}, Filepath: "/papers/typo.pdf"})
+	assert.ErrorContains(t, err, "incorrect id format")
+
+	// another instance finds its results under its own name
+	conf.Name = "arXiv-Mirror"
+	mirror := providers.NewArxiv(&conf, server.Client())
+	assert.Equal(t, "arXiv-Mirror", mirror.Name())
+	results, err = mirror.GetBookMetadata(context.Background(), &providers.SearchTerms{Arxivs: []book.ArxivId{"1706.03762"}, Filepath: "/papers/attention.pdf"})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 1) {
+		assert.Equal(t, "arxiv-mirror", results[0].SourceProviderName)
+	}
+}
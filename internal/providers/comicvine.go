@@ -0,0 +1,164 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// comicvineRateLimited is the status ComicVine reports in its body once too many requests were made
+const comicvineRateLimited = 107
+
+type comicvineIssue struct {
+	Name        string `json:"name"`
+	IssueNumber string `json:"issue_number"`
+	CoverDate   string `json:"cover_date"`
+	Volume      struct {
+		Name string `json:"name"`
+	} `json:"volume"`
+}
+
+type comicvineSearchResponse struct {
+	Error      string           `json:"error"`
+	StatusCode int              `json:"status_code"`
+	Results    []comicvineIssue `json:"results"`
+}
+
+// Comicvine searches the ComicVine wiki for comic book issues by their title, it has no ISBNs to search by
+type Comicvine struct {
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewComicvine(conf *config.ComicvineConfig, client *http.Client) Provider {
+	comicvine := Comicvine{
+		name:   instanceName(conf.Name, "ComicVine"),
+		url:    fmt.Sprintf("https://%s", conf.Url),
+		apiKey: conf.ApiKey,
+		client: client,
+	}
+	return NewGeneric(&comicvine, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (c *Comicvine) Name() string {
+	return c.name
+}
+
+// Accepts only comics without any identifiers, since ComicVine can only be searched by title
+func (c *Comicvine) Accepts(search *SearchTerms) bool {
+	ext := strings.ToLower(filepath.Ext(search.Filepath))
+	return (ext == ".cbz" || ext == ".cbr") && !search.HasIdentifiers()
+}
+
+func (c *Comicvine) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	return book.BookResult{}, nil, http.StatusNotFound
+}
+
+func (c *Comicvine) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	query := url.Values{}
+	query.Set("api_key", c.apiKey)
+	query.Set("format", "json")
+	query.Set("resources", "issue")
+	query.Set("query", title)
+	query.Set("limit", "10")
+	query.Set("field_list", "name,issue_number,cover_date,volume")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/search/?%s", c.url, query.Encode()), nil)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	// ComicVine turns away requests without a user agent of their own
+	request.Header.Set("User-Agent", "booker")
+
+	response, err := send(c.client, request)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return book.BookResult{}, rateLimited(response, fmt.Errorf("comicvine returned bad status code %d: %s", response.StatusCode, string(body))), response.StatusCode
+	}
+
+	var result comicvineSearchResponse
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return book.BookResult{}, err, response.StatusCode
+	}
+	if result.StatusCode == comicvineRateLimited {
+		return book.BookResult{}, fmt.Errorf("comicvine rate limit exceeded: %s", result.Error), http.StatusTooManyRequests
+	}
+	if result.StatusCode != 1 {
+		return book.BookResult{}, fmt.Errorf("comicvine returned error %d: %s", result.StatusCode, result.Error), response.StatusCode
+	}
+	if len(result.Results) == 0 {
+		return book.BookResult{}, nil, http.StatusNotFound
+	}
+
+	best := &result.Results[0]
+	bestMatch := util.TitleSimilarity(comicvineTitle(best), title)
+	for idx := range result.Results {
+		similarity := util.TitleSimilarity(comicvineTitle(&result.Results[idx]), title)
+		if similarity > bestMatch {
+			bestMatch = similarity
+			best = &result.Results[idx]
+		}
+	}
+
+	return c.toBookResult(best, filePath), nil, response.StatusCode
+}
+
+// comicvineTitle names an issue the way the comic extractor does, by series and number and then the story
+func comicvineTitle(issue *comicvineIssue) string {
+	title := strings.TrimSpace(issue.Volume.Name)
+	if len(issue.IssueNumber) > 0 {
+		title = fmt.Sprintf("%s #%s", title, issue.IssueNumber)
+	}
+	if name := strings.TrimSpace(issue.Name); len(name) > 0 {
+		title = fmt.Sprintf("%s: %s", title, name)
+	}
+	return strings.TrimSpace(title)
+}
+
+func (c *Comicvine) toBookResult(issue *comicvineIssue, filePath string) book.BookResult {
+	result := book.BookResult{
+		Filepath:           filePath,
+		Confidence:         75,
+		SourceProviderName: strings.ToLower(c.name),
+	}
+
+	if title := comicvineTitle(issue); len(title) > 0 {
+		result.Title = mo.Some(title)
+	}
+	if len(issue.CoverDate) > 0 {
+		result.PublishDate = mo.Some(issue.CoverDate)
+	}
+	if series := strings.TrimSpace(issue.Volume.Name); len(series) > 0 {
+		result.Series = mo.Some(series)
+		if number, err := strconv.ParseFloat(issue.IssueNumber, 64); err == nil {
+			result.SeriesIndex = mo.Some(number)
+		}
+	}
+
+	return result
+}
+
+func (c *Comicvine) Shutdown() {
+}
+
+func (c *Comicvine) HealthCheck() (bool, string) {
+	return probe(c.client, c.url)
+}
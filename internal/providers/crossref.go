@@ -0,0 +1,230 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/lo"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type crossrefAuthor struct {
+	Given  string `json:"given"`
+	Family string `json:"family"`
+	Name   string `json:"name"`
+}
+
+type crossrefWork struct {
+	Doi       string           `json:"DOI"`
+	Title     []string         `json:"title"`
+	Author    []crossrefAuthor `json:"author"`
+	Publisher string           `json:"publisher"`
+	Isbn      []string         `json:"ISBN"`
+	Subject   []string         `json:"subject"`
+	Abstract  string           `json:"abstract"`
+	Issued    struct {
+		DateParts [][]int `json:"date-parts"`
+	} `json:"issued"`
+}
+
+type crossrefWorkResponse struct {
+	Message crossrefWork `json:"message"`
+}
+
+type crossrefWorksResponse struct {
+	Message struct {
+		TotalResults int            `json:"total-results"`
+		Items        []crossrefWork `json:"items"`
+	} `json:"message"`
+}
+
+type Crossref struct {
+	name   string
+	url    string
+	mailto string
+	client *http.Client
+}
+
+func NewCrossref(conf *config.CrossrefConfig, client *http.Client) Provider {
+	crossref := Crossref{
+		name:   instanceName(conf.Name, "Crossref"),
+		url:    fmt.Sprintf("https://%s", conf.Url),
+		mailto: conf.Mailto,
+		client: client,
+	}
+	return NewGeneric(&crossref, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (c *Crossref) Name() string {
+	return c.name
+}
+
+func (c *Crossref) get(ctx context.Context, path string, query url.Values, into any) (error, int) {
+	if len(c.mailto) > 0 {
+		// identifies us so requests are served from Crossref's "polite" pool
+		query.Set("mailto", c.mailto)
+	}
+
+	queryUrl := fmt.Sprintf("%s%s", c.url, path)
+	if len(query) > 0 {
+		queryUrl = fmt.Sprintf("%s?%s", queryUrl, query.Encode())
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, queryUrl, nil)
+	if err != nil {
+		return err, 0
+	}
+
+	response, err := send(c.client, request)
+	if err != nil {
+		return err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, response.StatusCode
+	}
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return rateLimited(response, fmt.Errorf("crossref returned bad status code %d: %s", response.StatusCode, string(body))), response.StatusCode
+	}
+
+	return json.NewDecoder(response.Body).Decode(into), response.StatusCode
+}
+
+func (c *Crossref) FindResultByDoi(ctx context.Context, doi book.DOI, filePath string) (book.BookResult, error, int) {
+	var result crossrefWorkResponse
+
+	err, statusCode := c.get(ctx, fmt.Sprintf("/works/%s", url.PathEscape(string(doi))), url.Values{}, &result)
+	if err != nil || statusCode == http.StatusNotFound {
+		return book.BookResult{}, err, statusCode
+	}
+
+	return c.toBookResult(&result.Message, filePath, 100), nil, statusCode
+}
+
+func (c *Crossref) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	query := url.Values{}
+	query.Set("filter", fmt.Sprintf("isbn:%s", isbn))
+	query.Set("rows", "5")
+	return c.findWork(ctx, query, filePath, 100)
+}
+
+func (c *Crossref) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	query := url.Values{}
+	query.Set("query.bibliographic", title)
+	if len(author) > 0 {
+		query.Set("query.author", author)
+	}
+	query.Set("rows", "5")
+	return c.findWork(ctx, query, filePath, 75)
+}
+
+func (c *Crossref) findWork(ctx context.Context, query url.Values, filePath string, confidence float64) (book.BookResult, error, int) {
+	var result crossrefWorksResponse
+
+	err, statusCode := c.get(ctx, "/works", query, &result)
+	if err != nil || statusCode == http.StatusNotFound || len(result.Message.Items) == 0 {
+		return book.BookResult{}, err, statusCode
+	}
+
+	filenameTitle := util.FilenameTitle(filePath)
+	items := result.Message.Items
+	best := &items[0]
+	bestMatch := util.TitleSimilarity(crossrefTitle(best), filenameTitle)
+	for idx := range items {
+		similarity := util.TitleSimilarity(crossrefTitle(&items[idx]), filenameTitle)
+		if similarity > bestMatch {
+			bestMatch = similarity
+			best = &items[idx]
+		}
+	}
+
+	return c.toBookResult(best, filePath, confidence), nil, statusCode
+}
+
+func crossrefTitle(work *crossrefWork) string {
+	if len(work.Title) == 0 {
+		return ""
+	}
+	return work.Title[0]
+}
+
+func (c *Crossref) toBookResult(work *crossrefWork, filePath string, confidence float64) book.BookResult {
+	result := book.BookResult{
+		Filepath:           filePath,
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(c.name),
+	}
+
+	if title := crossrefTitle(work); len(title) > 0 {
+		result.Title = mo.Some(title)
+	}
+
+	authors := make([]string, 0)
+	for _, author := range work.Author {
+		name := strings.TrimSpace(fmt.Sprintf("%s %s", author.Given, author.Family))
+		if len(name) == 0 {
+			name = strings.TrimSpace(author.Name)
+		}
+		if len(name) > 0 {
+			authors = append(authors, name)
+		}
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	if len(work.Doi) > 0 {
+		result.Doi = mo.Some(book.DOI(strings.ToLower(work.Doi)))
+	}
+
+	for _, isbn := range work.Isbn {
+		isbn = strings.ReplaceAll(isbn, "-", "")
+		switch len(isbn) {
+		case 10:
+			if result.Isbn10.IsAbsent() {
+				result.Isbn10 = mo.Some(book.ISBN10(isbn))
+			}
+		case 13:
+			if result.Isbn13.IsAbsent() {
+				result.Isbn13 = mo.Some(book.ISBN13(isbn))
+			}
+		}
+	}
+
+	if len(work.Issued.DateParts) > 0 && len(work.Issued.DateParts[0]) > 0 {
+		parts := lo.Map(work.Issued.DateParts[0], func(part int, _ int) string {
+			return fmt.Sprintf("%02d", part)
+		})
+		result.PublishDate = mo.Some(strings.Join(parts, "-"))
+	}
+
+	if len(work.Publisher) > 0 {
+		result.Publisher = mo.Some(work.Publisher)
+	}
+	if len(work.Subject) > 0 {
+		result.Subjects = mo.Some(work.Subject)
+	}
+	// abstracts are JATS XML, which the tags are stripped from like HTML
+	if abstract := util.ShortDescription(work.Abstract); len(abstract) > 0 {
+		result.Description = mo.Some(abstract)
+	}
+
+	return result
+}
+
+func (c *Crossref) Shutdown() {
+}
+
+func (c *Crossref) HealthCheck() (bool, string) {
+	return probe(c.client, c.url)
+}
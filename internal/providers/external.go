@@ -0,0 +1,210 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// externalTempFail is the exit status (EX_TEMPFAIL) with which a plugin says that its lookup failed but might succeed
+// if it is tried again
+const externalTempFail = 75
+
+// externalRequest is what a plugin is sent on its standard input, with only the fields of its lookup set
+type externalRequest struct {
+	Lookup   string `json:"lookup"`
+	Isbn     string `json:"isbn,omitempty"`
+	Doi      string `json:"doi,omitempty"`
+	Asin     string `json:"asin,omitempty"`
+	Arxiv    string `json:"arxiv,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Author   string `json:"author,omitempty"`
+	Language string `json:"language,omitempty"`
+	Filepath string `json:"filepath"`
+}
+
+// externalBook is what a plugin answers with on its standard output, using the field names of Booker's own output
+type externalBook struct {
+	Title       string   `json:"title"`
+	Authors     []string `json:"authors"`
+	Editors     []string `json:"editors"`
+	Isbn10      string   `json:"isbn10"`
+	Isbn13      string   `json:"isbn13"`
+	Uom         string   `json:"uom"`
+	Oclc        string   `json:"oclc"`
+	Doi         string   `json:"doi"`
+	Asin        string   `json:"asin"`
+	Arxiv       string   `json:"arxiv"`
+	LowYear     uint     `json:"low_year"`
+	HighYear    uint     `json:"high_year"`
+	PublishDate string   `json:"publish_date"`
+	Publisher   string   `json:"publisher"`
+	Edition     string   `json:"edition"`
+	Binding     string   `json:"binding"`
+	Pages       uint     `json:"pages"`
+	Series      string   `json:"series"`
+	SeriesIndex float64  `json:"series_index"`
+	Subjects    []string `json:"subjects"`
+	Lcc         string   `json:"lcc"`
+	Ddc         string   `json:"ddc"`
+	Description string   `json:"description"`
+	Language    string   `json:"language"`
+	CoverUrl    string   `json:"cover_url"`
+	// Confidence is out of 100, and defaults to how sure a lookup of its kind usually is
+	Confidence float64 `json:"confidence"`
+}
+
+// ExternalProvider looks books up with a plugin, a command that is run once for every lookup and speaks JSON over its
+// standard input and output, so that sources Booker doesn't know about can be added without changing it
+type ExternalProvider struct {
+	name    string
+	command string
+	args    []string
+	lookups []string
+}
+
+func NewExternalProvider(conf *config.PluginConfig) Provider {
+	external := ExternalProvider{
+		name:    conf.Name,
+		command: util.ExpandUser(conf.Command),
+		args:    conf.Args,
+		lookups: conf.Lookups,
+	}
+	return NewGeneric(&external, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (e *ExternalProvider) Name() string {
+	return e.name
+}
+
+func (e *ExternalProvider) Supports(lookup string) bool {
+	return slices.Contains(e.lookups, lookup)
+}
+
+// run sends the request to the plugin and reads its answer, which is empty or null if it found nothing. A plugin that
+// fails is reported like a server error if it exited with externalTempFail, so that it is retried, and like a client
+// error otherwise
+func (e *ExternalProvider) run(ctx context.Context, request *externalRequest, confidence float64) (book.BookResult, error, int) {
+	input, err := json.Marshal(request)
+	if err != nil {
+		return book.BookResult{}, err, http.StatusBadRequest
+	}
+
+	cmd := exec.CommandContext(ctx, e.command, e.args...)
+	cmd.Stdin = bytes.NewReader(input)
+	stdout := bytes.Buffer{}
+	cmd.Stdout = &stdout
+	stderr := strings.Builder{}
+	cmd.Stderr = &stderr
+	// don't wait on anything the plugin left behind holding its output open once it is killed
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	record(ctx, Response{Url: e.command, Request: input, Status: cmd.ProcessState.ExitCode()}, stdout.Bytes())
+	if err != nil {
+		statusCode := http.StatusServiceUnavailable
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() != externalTempFail {
+			statusCode = http.StatusBadRequest
+		}
+		return book.BookResult{}, fmt.Errorf("plugin %s failed for %s: %s: %s", e.name, request.Filepath, err.Error(), strings.TrimSpace(stderr.String())), statusCode
+	}
+
+	output := bytes.TrimSpace(stdout.Bytes())
+	if len(output) == 0 || bytes.Equal(output, []byte("null")) {
+		return book.BookResult{}, nil, http.StatusNotFound
+	}
+
+	answer := externalBook{Confidence: confidence}
+	if err := json.Unmarshal(output, &answer); err != nil {
+		return book.BookResult{}, fmt.Errorf("plugin %s answered with invalid JSON for %s: %s", e.name, request.Filepath, err.Error()), http.StatusBadRequest
+	}
+	return e.toBookResult(&answer, request.Filepath), nil, http.StatusOK
+}
+
+func (e *ExternalProvider) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	return e.run(ctx, &externalRequest{Lookup: "isbn", Isbn: string(isbn), Filepath: filePath}, 100)
+}
+
+func (e *ExternalProvider) FindResultByDoi(ctx context.Context, doi book.DOI, filePath string) (book.BookResult, error, int) {
+	return e.run(ctx, &externalRequest{Lookup: "doi", Doi: string(doi), Filepath: filePath}, 100)
+}
+
+func (e *ExternalProvider) FindResultByAsin(ctx context.Context, asin book.ASIN, filePath string) (book.BookResult, error, int) {
+	return e.run(ctx, &externalRequest{Lookup: "asin", Asin: string(asin), Filepath: filePath}, 100)
+}
+
+func (e *ExternalProvider) FindResultByArxiv(ctx context.Context, arxiv book.ArxivId, filePath string) (book.BookResult, error, int) {
+	return e.run(ctx, &externalRequest{Lookup: "arxiv", Arxiv: string(arxiv), Filepath: filePath}, 100)
+}
+
+func (e *ExternalProvider) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	return e.FindResultByQueryInLanguage(ctx, title, author, "", filePath)
+}
+
+func (e *ExternalProvider) FindResultByQueryInLanguage(ctx context.Context, title string, author string, language string, filePath string) (book.BookResult, error, int) {
+	request := externalRequest{Lookup: "query", Title: title, Author: author, Language: language, Filepath: filePath}
+	return e.run(ctx, &request, 75)
+}
+
+func (e *ExternalProvider) toBookResult(answer *externalBook, filePath string) book.BookResult {
+	result := book.BookResult{
+		Filepath:           filePath,
+		Title:              mo.EmptyableToOption(answer.Title),
+		Isbn10:             mo.EmptyableToOption(book.ISBN10(strings.ReplaceAll(answer.Isbn10, "-", ""))),
+		Isbn13:             mo.EmptyableToOption(book.ISBN13(strings.ReplaceAll(answer.Isbn13, "-", ""))),
+		Uom:                mo.EmptyableToOption(answer.Uom),
+		Oclc:               mo.EmptyableToOption(answer.Oclc),
+		Doi:                mo.EmptyableToOption(book.DOI(strings.ToLower(answer.Doi))),
+		Asin:               mo.EmptyableToOption(book.ASIN(answer.Asin)),
+		Arxiv:              mo.EmptyableToOption(book.ArxivId(answer.Arxiv)),
+		LowYear:            mo.EmptyableToOption(answer.LowYear),
+		HighYear:           mo.EmptyableToOption(answer.HighYear),
+		PublishDate:        mo.EmptyableToOption(answer.PublishDate),
+		Publisher:          mo.EmptyableToOption(answer.Publisher),
+		Edition:            mo.EmptyableToOption(answer.Edition),
+		Binding:            mo.EmptyableToOption(answer.Binding),
+		Pages:              mo.EmptyableToOption(answer.Pages),
+		Series:             mo.EmptyableToOption(answer.Series),
+		SeriesIndex:        mo.EmptyableToOption(answer.SeriesIndex),
+		Lcc:                mo.EmptyableToOption(answer.Lcc),
+		Ddc:                mo.EmptyableToOption(answer.Ddc),
+		Description:        mo.EmptyableToOption(answer.Description),
+		Language:           mo.EmptyableToOption(answer.Language),
+		CoverUrl:           mo.EmptyableToOption(answer.CoverUrl),
+		Confidence:         min(max(answer.Confidence, 0), 100),
+		SourceProviderName: strings.ToLower(e.name),
+	}
+	if len(answer.Authors) > 0 {
+		result.Authors = mo.Some(answer.Authors)
+	}
+	if len(answer.Editors) > 0 {
+		result.Editors = mo.Some(answer.Editors)
+	}
+	if len(answer.Subjects) > 0 {
+		result.Subjects = mo.Some(answer.Subjects)
+	}
+	return result
+}
+
+func (e *ExternalProvider) Shutdown() {
+}
+
+func (e *ExternalProvider) HealthCheck() (bool, string) {
+	_, err := exec.LookPath(e.command)
+	if err != nil {
+		return false, fmt.Sprintf("plugin %s command %s not found: %s", e.name, e.command, err.Error())
+	}
+	return true, ""
+}
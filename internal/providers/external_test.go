@@ -0,0 +1,57 @@
+package providers_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// plugin answers ISBN lookups for one book, finds nothing for any other and fails for queries
+const plugin = `#!/bin/sh
+request=$(cat)
+case "$request" in
+*'"isbn":"9781718501263"'*)
+	echo '{"title": "The Rust Programming Language", "authors": ["Steve Klabnik", "Carol Nichols"], "isbn13": "978-1-7185-0126-3", "low_year": 2019}' ;;
+*'"lookup":"query"'*)
+	echo "catalog is down" >&2
+	exit 2 ;;
+esac
+`
+
+func TestExternalProvider(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("plugins are run with sh")
+	}
+	command := filepath.Join(t.TempDir(), "plugin")
+	assert.NoError(t, os.WriteFile(command, []byte(plugin), 0o755))
+
+	conf := config.PluginConfig{
+		Name:    "Catalog",
+		Command: command,
+		Lookups: []string{"isbn", "query"},
+		Retry:   config.RetryConfig{MaxAttempts: 1},
+	}
+	provider := providers.NewExternalProvider(&conf)
+
+	search := &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263", "9780000000002"}, Dois: []book.DOI{"10.1000/1"}, Filepath: "/books/rust.pdf"}
+	results, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	// the DOI isn't looked up since the plugin doesn't make that lookup
+	assert.Len(t, results, 2)
+	assert.Equal(t, mo.Some("The Rust Programming Language"), results[0].Title)
+	assert.Equal(t, mo.Some(book.ISBN13("9781718501263")), results[0].Isbn13)
+	assert.Equal(t, mo.Some(uint(2019)), results[0].LowYear)
+	assert.Equal(t, 100.0, results[0].Confidence)
+	assert.Equal(t, "catalog", results[0].SourceProviderName)
+	assert.True(t, results[1].IsUnidentified())
+
+	_, err = provider.GetBookMetadata(context.Background(), &providers.SearchTerms{Title: "Rust", Filepath: "/books/rust.pdf"})
+	assert.ErrorContains(t, err, "catalog is down")
+}
@@ -1,70 +1,358 @@
 package providers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/ratelimit"
 	"github.com/samber/lo"
-	"log"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
 	"slices"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
 )
 
 type GenericImpl interface {
 	Name() string
-	FindResult(isbn book.ISBN, filePath string) (book.BookResult, error, int)
+	FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int)
 	Shutdown()
 	HealthCheck() (bool, string)
 }
 
+// GenericQueryImpl is implemented by providers that support free-text title/author searches
+type GenericQueryImpl interface {
+	FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int)
+}
+
+// GenericLanguageQueryImpl is implemented by providers whose title/author searches can be kept to books in one language
+type GenericLanguageQueryImpl interface {
+	FindResultByQueryInLanguage(ctx context.Context, title string, author string, language string, filePath string) (book.BookResult, error, int)
+}
+
+// GenericDoiImpl is implemented by providers that can resolve DOIs
+type GenericDoiImpl interface {
+	FindResultByDoi(ctx context.Context, doi book.DOI, filePath string) (book.BookResult, error, int)
+}
+
+// GenericAsinImpl is implemented by providers that can resolve Amazon ASINs
+type GenericAsinImpl interface {
+	FindResultByAsin(ctx context.Context, asin book.ASIN, filePath string) (book.BookResult, error, int)
+}
+
+// GenericArxivImpl is implemented by providers that can resolve arXiv identifiers
+type GenericArxivImpl interface {
+	FindResultByArxiv(ctx context.Context, arxiv book.ArxivId, filePath string) (book.BookResult, error, int)
+}
+
+// GenericScopedImpl is implemented by providers that only know some kinds of books, and are only asked about those
+type GenericScopedImpl interface {
+	Accepts(search *SearchTerms) bool
+}
+
+// GenericLookupImpl is implemented by providers that only make some of the lookups they have methods for, named isbn,
+// doi, asin, arxiv and query, and are only asked to make those
+type GenericLookupImpl interface {
+	Supports(lookup string) bool
+}
+
 type Generic struct {
 	GenericImpl
 
-	cache       sync.Map
-	rateLimiter <-chan time.Time
-	disabled    bool
+	cache         sync.Map
+	rateLimiter   *ratelimit.Limiter
+	retry         config.RetryConfig
+	lock          sync.Mutex
+	cooldownUntil time.Time
+	trips         uint
+	flights       map[string]*flight
+	store         Store
 }
 
-func NewGeneric(impl GenericImpl, millisecondsPerRequest uint) Provider {
+// Store keeps the results of providers between runs, by the same keys as the cache of each
+type Store interface {
+	Load(provider string, key string) (book.BookResult, bool)
+	Store(provider string, key string, result book.BookResult)
+}
+
+// flight is a request in progress, which books that need the same answer wait on instead of sending their own
+type flight struct {
+	done   chan struct{}
+	result book.BookResult
+	err    error
+	// abandoned is set when the book that sent the request gave up on it, so that those waiting send their own
+	abandoned bool
+}
+
+func NewGeneric(impl GenericImpl, millisecondsPerRequest uint, retry config.RetryConfig) Provider {
 	g := &Generic{
 		GenericImpl: impl,
-		rateLimiter: time.Tick(time.Duration(millisecondsPerRequest) * time.Millisecond),
+		rateLimiter: ratelimit.New(time.Duration(millisecondsPerRequest) * time.Millisecond),
 		cache:       sync.Map{},
-		disabled:    false,
+		retry:       retry,
+		lock:        sync.Mutex{},
+		flights:     make(map[string]*flight),
 	}
 
 	return g
 }
 
-func (g *Generic) findResult(isbn book.ISBN, filePath string) (book.BookResult, error) {
-	if cachedResult, cached := g.cache.Load(isbn); cached {
-		return cachedResult.(book.BookResult), nil
+// instanceName is the name a provider's results are found under, the one its instance was given in the config if any
+func instanceName(name string, provider string) string {
+	if len(name) > 0 {
+		return name
+	}
+	return provider
+}
+
+// retryable reports whether a failed request might succeed if it is sent again, which is the case for server errors and
+// requests that never got an answer
+func retryable(err error, statusCode int) bool {
+	return err != nil && (statusCode == 0 || statusCode >= http.StatusInternalServerError)
+}
+
+// rateLimitError is what a provider returns when the API answered that it was sent too many requests, with how long
+// the API asked to be left alone for, if it said
+type rateLimitError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *rateLimitError) Unwrap() error {
+	return e.err
+}
+
+// IsRateLimited reports whether a provider failed with err because it was rate limited, or is cooling down after it
+func IsRateLimited(err error) bool {
+	var rateLimit *rateLimitError
+	return errors.As(err, &rateLimit)
+}
+
+// rateLimited turns err into a rateLimitError if the response is a 429
+func rateLimited(response *http.Response, err error) error {
+	if response.StatusCode != http.StatusTooManyRequests {
+		return err
+	}
+	retryAfter, _ := ratelimit.RetryAfter(response.Header, time.Now())
+	return &rateLimitError{err: err, retryAfter: retryAfter}
+}
+
+// send sends a request to the API, letting the rate limiter of the provider it is for see the response and the recorder
+// keep it
+func send(client *http.Client, request *http.Request) (*http.Response, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if limiter, ok := ratelimit.FromContext(request.Context()); ok {
+		limiter.Observe(response.Header)
+	}
+	recordResponse(response)
+	return response, nil
+}
+
+// maxCooldown is the longest a provider is left alone after being rate limited, which is long enough for any daily
+// quota to reset
+const maxCooldown = 24 * time.Hour
+
+// trip stops requests to the provider for a while after it was rate limited, for as long as the API asked or else for
+// the cooldown, doubled for every time in a row the provider was rate limited
+func (g *Generic) trip(err error) time.Duration {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	cooldown := time.Duration(g.retry.CooldownSeconds) * time.Second
+	for range g.trips {
+		if cooldown >= maxCooldown {
+			break
+		}
+		cooldown *= 2
+	}
+	cooldown = min(cooldown, maxCooldown)
+
+	var rateLimit *rateLimitError
+	if errors.As(err, &rateLimit) && rateLimit.retryAfter > 0 {
+		cooldown = min(rateLimit.retryAfter, maxCooldown)
+	}
+
+	g.trips++
+	g.cooldownUntil = time.Now().Add(cooldown)
+	return cooldown
+}
+
+// reset lets the provider be rate limited for just the cooldown again, once a request got through
+func (g *Generic) reset() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.trips > 0 {
+		slog.Info("provider recovered from rate limit", "provider", g.Name())
+	}
+	g.trips = 0
+}
+
+// coolingDown reports whether the provider is being left alone after being rate limited, and until when
+func (g *Generic) coolingDown() (bool, time.Time) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return time.Now().Before(g.cooldownUntil), g.cooldownUntil
+}
+
+// maxBackoff is the longest a retry waits, however many attempts came before it
+const maxBackoff = time.Minute
+
+// backoff is how long to wait after the given attempt failed, doubling with every attempt and spread by the jitter so
+// that retries don't all land at once
+func (g *Generic) backoff(attempt uint) time.Duration {
+	wait := time.Duration(g.retry.BackoffMilliseconds) * time.Millisecond
+	for range attempt - 1 {
+		if wait >= maxBackoff {
+			break
+		}
+		wait *= 2
+	}
+	wait = min(wait, maxBackoff)
+	return time.Duration(float64(wait) * (1 + g.retry.Jitter*(2*rand.Float64()-1)))
+}
+
+// findResult looks up key for the book at filePath, which the result is given even if it was found for another book
+func (g *Generic) findResult(ctx context.Context, key string, filePath string, find func() (book.BookResult, error, int)) (book.BookResult, error) {
+	result, err := g.sharedResult(ctx, key, find)
+	result.Filepath = filePath
+	return result, err
+}
+
+// sharedResult looks up key once however many books ask for it at the same time, the first sending the request and the
+// others waiting for its answer
+func (g *Generic) sharedResult(ctx context.Context, key string, find func() (book.BookResult, error, int)) (book.BookResult, error) {
+	for {
+		if cachedResult, cached := g.cache.Load(key); cached {
+			return cachedResult.(book.BookResult), nil
+		}
+
+		g.lock.Lock()
+		f, inFlight := g.flights[key]
+		if !inFlight {
+			f = &flight{done: make(chan struct{})}
+			g.flights[key] = f
+		}
+		g.lock.Unlock()
+
+		if inFlight {
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return book.BookResult{}, ctx.Err()
+			}
+			if f.abandoned {
+				continue
+			}
+			return f.result, f.err
+		}
+
+		f.result, f.err = g.lookup(ctx, key, find)
+		f.abandoned = f.err != nil && ctx.Err() != nil
+		g.lock.Lock()
+		delete(g.flights, key)
+		g.lock.Unlock()
+		close(f.done)
+		return f.result, f.err
 	}
+}
 
-	if g.disabled {
-		return book.BookResult{}, fmt.Errorf("%s provider self-disabled, probably due to rate limit", g.Name())
+func (g *Generic) lookup(ctx context.Context, key string, find func() (book.BookResult, error, int)) (book.BookResult, error) {
+	if g.store != nil {
+		if result, stored := g.store.Load(g.Name(), key); stored {
+			g.cache.Store(key, result)
+			return result, nil
+		}
 	}
 
-	<-g.rateLimiter
+	if coolingDown, until := g.coolingDown(); coolingDown {
+		err := fmt.Errorf("%s provider is cooling down after being rate limited, until %s", g.Name(), until.Format(time.TimeOnly))
+		return book.BookResult{}, &rateLimitError{err: err, retryAfter: time.Until(until)}
+	}
 
-	result, err, statusCode := g.FindResult(isbn, filePath)
+	var result book.BookResult
+	var err error
+	var statusCode int
+	for attempt := uint(1); ; attempt++ {
+		if err := g.rateLimiter.Wait(ctx); err != nil {
+			return book.BookResult{}, err
+		}
+
+		result, err, statusCode = find()
+		if !retryable(err, statusCode) || attempt >= g.retry.MaxAttempts || ctx.Err() != nil {
+			break
+		}
+
+		wait := g.backoff(attempt)
+		slog.Debug("provider request failed, retrying", "provider", g.Name(), "attempt", attempt, "status", statusCode, "error", err, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return book.BookResult{}, ctx.Err()
+		}
+	}
 
 	if statusCode == http.StatusTooManyRequests {
-		g.disabled = true
-		log.Printf("error: provider %s rate limit exceeded, self-disabling provider\n", g.Name())
+		cooldown := g.trip(err)
+		slog.Error("provider rate limit exceeded, cooling down", "provider", g.Name(), "cooldown", cooldown)
 		return book.BookResult{}, err
 	}
 
 	if err == nil {
-		g.cache.Store(isbn, result)
+		g.reset()
+		g.cache.Store(key, result)
+		if g.store != nil {
+			g.store.Store(g.Name(), key, result)
+		}
 	}
 
 	return result, err
 }
 
-func (g *Generic) GetBookMetadata(search *SearchTerms) ([]book.BookResult, error) {
-	results := make([]book.BookResult, 0)
+// SetStore has the results of the provider kept in store, and looked for there before sending a request
+func (g *Generic) SetStore(store Store) {
+	g.store = store
+}
+
+// normalizeQuery reduces a title or author to lowercase words, so that searches that only differ in case, punctuation
+// or spacing share their results
+func normalizeQuery(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+func (g *Generic) supports(lookup string) bool {
+	lookupImpl, ok := g.GenericImpl.(GenericLookupImpl)
+	return !ok || lookupImpl.Supports(lookup)
+}
+
+// lookup is one request the provider would send for a book, with the key its answer is cached by and how it is written
+// in a dry run report
+type lookup struct {
+	key   string
+	query string
+	find  func() (book.BookResult, error, int)
+}
+
+// lookups are the requests the provider sends for a book, in the order it sends them
+func (g *Generic) lookups(ctx context.Context, search *SearchTerms) []lookup {
+	lookups := make([]lookup, 0)
+	if scopedImpl, ok := g.GenericImpl.(GenericScopedImpl); ok && !scopedImpl.Accepts(search) {
+		return lookups
+	}
 
 	isbn10s := lo.Map(search.Isbn10s, func(isbn book.ISBN10, _ int) book.ISBN {
 		return book.ISBN(isbn)
@@ -75,9 +363,74 @@ func (g *Generic) GetBookMetadata(search *SearchTerms) ([]book.BookResult, error
 	})
 
 	allIsbns := slices.Concat(isbn10s, isbn13s)
+	if !g.supports("isbn") {
+		allIsbns = nil
+	}
 
 	for _, isbn := range allIsbns {
-		result, err := g.findResult(isbn, search.Filepath)
+		lookups = append(lookups, lookup{key: string(isbn), query: fmt.Sprintf("isbn:%s", isbn), find: func() (book.BookResult, error, int) {
+			return g.FindResult(ctx, isbn, search.Filepath)
+		}})
+	}
+
+	if doiImpl, ok := g.GenericImpl.(GenericDoiImpl); ok && g.supports("doi") {
+		for _, doi := range search.Dois {
+			key := fmt.Sprintf("doi:%s", doi)
+			lookups = append(lookups, lookup{key: key, query: key, find: func() (book.BookResult, error, int) {
+				return doiImpl.FindResultByDoi(ctx, doi, search.Filepath)
+			}})
+		}
+	}
+
+	if asinImpl, ok := g.GenericImpl.(GenericAsinImpl); ok && g.supports("asin") {
+		for _, asin := range search.Asins {
+			key := fmt.Sprintf("asin:%s", asin)
+			lookups = append(lookups, lookup{key: key, query: key, find: func() (book.BookResult, error, int) {
+				return asinImpl.FindResultByAsin(ctx, asin, search.Filepath)
+			}})
+		}
+	}
+
+	if arxivImpl, ok := g.GenericImpl.(GenericArxivImpl); ok && g.supports("arxiv") {
+		for _, arxiv := range search.Arxivs {
+			key := fmt.Sprintf("arxiv:%s", arxiv)
+			lookups = append(lookups, lookup{key: key, query: key, find: func() (book.BookResult, error, int) {
+				return arxivImpl.FindResultByArxiv(ctx, arxiv, search.Filepath)
+			}})
+		}
+	}
+
+	if search.HasIdentifiers() || len(search.Title) == 0 {
+		return lookups
+	}
+
+	queryImpl, ok := g.GenericImpl.(GenericQueryImpl)
+	if !ok || !g.supports("query") {
+		return lookups
+	}
+
+	key := fmt.Sprintf("query:%s|%s", normalizeQuery(search.Title), normalizeQuery(search.Author))
+	find := func() (book.BookResult, error, int) {
+		return queryImpl.FindResultByQuery(ctx, search.Title, search.Author, search.Filepath)
+	}
+	// a translation usually shares its title with the original, so the search is kept to the language of the file
+	if languageImpl, ok := g.GenericImpl.(GenericLanguageQueryImpl); ok && len(search.Language) > 0 {
+		key = fmt.Sprintf("%s|%s", key, search.Language)
+		find = func() (book.BookResult, error, int) {
+			return languageImpl.FindResultByQueryInLanguage(ctx, search.Title, search.Author, search.Language, search.Filepath)
+		}
+	}
+	return append(lookups, lookup{key: key, query: key, find: find})
+}
+
+func (g *Generic) GetBookMetadata(ctx context.Context, search *SearchTerms) ([]book.BookResult, error) {
+	results := make([]book.BookResult, 0)
+	// the provider's requests are paced by what the API says about its rate limit in its responses
+	ctx = ratelimit.WithLimiter(ctx, g.rateLimiter)
+	ctx = context.WithValue(ctx, providerKey{}, g.Name())
+
+	for _, l := range g.lookups(ctx, search) {
+		result, err := g.findResult(ctx, l.key, search.Filepath, l.find)
 		if err != nil {
 			return nil, err
 		}
@@ -87,14 +440,29 @@ func (g *Generic) GetBookMetadata(search *SearchTerms) ([]book.BookResult, error
 	return results, nil
 }
 
+// Queries are the lookups GetBookMetadata would make for a book, like isbn:9781718501263 or query:the rust programming
+// language|klabnik, without sending any of them
+func (g *Generic) Queries(search *SearchTerms) []string {
+	return lo.Map(g.lookups(context.Background(), search), func(l lookup, _ int) string {
+		return l.query
+	})
+}
+
 func (g *Generic) ClearCache() {
 	g.cache = sync.Map{}
 }
 
+// Disabled reports whether the provider is cooling down after being rate limited
 func (g *Generic) Disabled() bool {
-	return g.disabled
+	coolingDown, _ := g.coolingDown()
+	return coolingDown
 }
 
+// SelfCheck reports the provider as down while it is cooling down after being rate limited, so that it isn't counted on
+// until it turns itself back on
 func (g *Generic) SelfCheck() (bool, string) {
-	return !g.Disabled(), ""
+	if coolingDown, until := g.coolingDown(); coolingDown {
+		return false, fmt.Sprintf("rate limited until %s", until.Format(time.TimeOnly))
+	}
+	return true, ""
 }
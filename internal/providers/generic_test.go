@@ -0,0 +1,198 @@
+package providers_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyImpl answers with each of its status codes in turn, succeeding once it gets to a 200
+type flakyImpl struct {
+	statusCodes []int
+	calls       int
+}
+
+func (f *flakyImpl) Name() string {
+	return "flaky"
+}
+
+func (f *flakyImpl) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	statusCode := f.statusCodes[min(f.calls, len(f.statusCodes)-1)]
+	f.calls++
+	if statusCode != http.StatusOK {
+		return book.BookResult{}, fmt.Errorf("flaky returned bad status code %d", statusCode), statusCode
+	}
+	return book.BookResult{Title: mo.Some("Found"), Filepath: filePath}, nil, statusCode
+}
+
+func (f *flakyImpl) Shutdown() {}
+
+func (f *flakyImpl) HealthCheck() (bool, string) {
+	return true, ""
+}
+
+func TestGenericRetry(t *testing.T) {
+	retry := config.RetryConfig{MaxAttempts: 3, BackoffMilliseconds: 1, Jitter: 0.5}
+	search := &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263"}, Filepath: "/books/a.pdf"}
+
+	impl := &flakyImpl{statusCodes: []int{http.StatusServiceUnavailable, 0, http.StatusOK}}
+	results, err := providers.NewGeneric(impl, 1, retry).GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, 3, impl.calls)
+
+	impl = &flakyImpl{statusCodes: []int{http.StatusBadGateway}}
+	_, err = providers.NewGeneric(impl, 1, retry).GetBookMetadata(context.Background(), search)
+	assert.Error(t, err)
+	assert.Equal(t, 3, impl.calls)
+
+	// a client error will only ever get the same answer
+	impl = &flakyImpl{statusCodes: []int{http.StatusNotFound, http.StatusOK}}
+	_, err = providers.NewGeneric(impl, 1, retry).GetBookMetadata(context.Background(), search)
+	assert.Error(t, err)
+	assert.Equal(t, 1, impl.calls)
+}
+
+func TestGenericCooldown(t *testing.T) {
+	search := &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263"}, Filepath: "/books/a.pdf"}
+
+	impl := &flakyImpl{statusCodes: []int{http.StatusTooManyRequests, http.StatusOK}}
+	provider := providers.NewGeneric(impl, 1, config.RetryConfig{MaxAttempts: 3, CooldownSeconds: 60})
+	_, err := provider.GetBookMetadata(context.Background(), search)
+	assert.Error(t, err)
+	assert.True(t, provider.Disabled())
+	// the provider is down until the cooldown is over
+	up, _ := provider.SelfCheck()
+	assert.False(t, up)
+	_, err = provider.GetBookMetadata(context.Background(), search)
+	assert.Error(t, err)
+	assert.Equal(t, 1, impl.calls)
+
+	impl = &flakyImpl{statusCodes: []int{http.StatusTooManyRequests, http.StatusOK}}
+	provider = providers.NewGeneric(impl, 1, config.RetryConfig{MaxAttempts: 3})
+	_, err = provider.GetBookMetadata(context.Background(), search)
+	assert.Error(t, err)
+	results, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.False(t, provider.Disabled())
+	up, _ = provider.SelfCheck()
+	assert.True(t, up)
+}
+
+// slowImpl takes a while to answer, so that books looking up the same ISBN ask for it at the same time
+type slowImpl struct {
+	calls atomic.Int32
+}
+
+func (s *slowImpl) Name() string {
+	return "slow"
+}
+
+func (s *slowImpl) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	s.calls.Add(1)
+	select {
+	case <-time.After(100 * time.Millisecond):
+	case <-ctx.Done():
+		return book.BookResult{}, ctx.Err(), 0
+	}
+	return book.BookResult{Title: mo.Some("Found"), Filepath: filePath}, nil, http.StatusOK
+}
+
+func (s *slowImpl) Shutdown() {}
+
+func (s *slowImpl) HealthCheck() (bool, string) {
+	return true, ""
+}
+
+func TestGenericSingleFlight(t *testing.T) {
+	impl := &slowImpl{}
+	provider := providers.NewGeneric(impl, 1, config.RetryConfig{MaxAttempts: 1})
+
+	var wg sync.WaitGroup
+	for idx := range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			search := &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263"}, Filepath: fmt.Sprintf("/books/%d.pdf", idx)}
+			results, err := provider.GetBookMetadata(context.Background(), search)
+			assert.NoError(t, err)
+			assert.Len(t, results, 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), impl.calls.Load())
+
+	// a book that gave up on its request leaves the others to send their own
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	search := &providers.SearchTerms{Isbn10s: []book.ISBN10{"1718501269"}, Filepath: "/books/a.pdf"}
+	go provider.GetBookMetadata(ctx, search)
+	time.Sleep(time.Millisecond)
+	results, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, int32(3), impl.calls.Load())
+}
+
+// mapStore keeps results in memory, like the provider cache does on disk
+type mapStore struct {
+	lock    sync.Mutex
+	results map[string]book.BookResult
+}
+
+func (m *mapStore) Load(provider string, key string) (book.BookResult, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	result, ok := m.results[provider+"|"+key]
+	return result, ok
+}
+
+func (m *mapStore) Store(provider string, key string, result book.BookResult) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.results[provider+"|"+key] = result
+}
+
+func TestGenericStore(t *testing.T) {
+	store := &mapStore{results: map[string]book.BookResult{}}
+	impl := &slowImpl{}
+	provider := providers.NewGeneric(impl, 1, config.RetryConfig{MaxAttempts: 1}).(*providers.Generic)
+	provider.SetStore(store)
+
+	search := &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263"}, Filepath: "/books/a.pdf"}
+	_, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Contains(t, store.results, "slow|9781718501263")
+
+	// the next run finds the result without sending a request, for the book it is searching for
+	provider = providers.NewGeneric(impl, 1, config.RetryConfig{MaxAttempts: 1}).(*providers.Generic)
+	provider.SetStore(store)
+	search.Filepath = "/books/b.pdf"
+	results, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.Equal(t, "/books/b.pdf", results[0].Filepath)
+	assert.Equal(t, int32(1), impl.calls.Load())
+}
+
+func TestGenericQueries(t *testing.T) {
+	impl := &flakyImpl{statusCodes: []int{http.StatusOK}}
+	provider := providers.NewGeneric(impl, 1, config.RetryConfig{MaxAttempts: 1})
+
+	search := &providers.SearchTerms{Isbn10s: []book.ISBN10{"1718501269"}, Isbn13s: []book.ISBN13{"9781718501263"}, Filepath: "/books/a.pdf"}
+	assert.Equal(t, []string{"isbn:1718501269", "isbn:9781718501263"}, provider.Queries(search))
+	assert.Equal(t, 0, impl.calls)
+
+	// it can't search by title
+	assert.Empty(t, provider.Queries(&providers.SearchTerms{Title: "The Rust Programming Language", Filepath: "/books/a.pdf"}))
+}
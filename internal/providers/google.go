@@ -1,15 +1,16 @@
 package providers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/larkwiot/booker/internal/book"
 	"github.com/larkwiot/booker/internal/config"
 	"github.com/larkwiot/booker/internal/util"
 	"github.com/samber/mo"
-	"log"
+	"log/slog"
 	"net/http"
-	"path/filepath"
+	"net/url"
 	"strings"
 )
 
@@ -18,11 +19,39 @@ type googleIdentifier struct {
 	Identifier string `json:"identifier"`
 }
 
+// googleImageLinks are the sizes Google has the cover of a volume in, volume searches only give the two thumbnails
+type googleImageLinks struct {
+	SmallThumbnail string `json:"smallThumbnail"`
+	Thumbnail      string `json:"thumbnail"`
+	Small          string `json:"small"`
+	Medium         string `json:"medium"`
+	Large          string `json:"large"`
+	ExtraLarge     string `json:"extraLarge"`
+}
+
+// largest returns the URL of the largest cover, or an empty string if there is none
+func (links *googleImageLinks) largest() string {
+	for _, link := range []string{links.ExtraLarge, links.Large, links.Medium, links.Small, links.Thumbnail, links.SmallThumbnail} {
+		if len(link) > 0 {
+			// the links are plain http, and curl the corner of the page unless asked not to
+			link = strings.Replace(link, "http://", "https://", 1)
+			return strings.Replace(link, "&edge=curl", "", 1)
+		}
+	}
+	return ""
+}
+
 type googleVolumeInfo struct {
 	Title               string             `json:"title"`
 	Authors             []string           `json:"authors"`
 	IndustryIdentifiers []googleIdentifier `json:"industryIdentifiers"`
 	PublishedDate       string             `json:"publishedDate"`
+	Publisher           string             `json:"publisher"`
+	PageCount           uint               `json:"pageCount"`
+	Categories          []string           `json:"categories"`
+	Description         string             `json:"description"`
+	Language            string             `json:"language"`
+	ImageLinks          googleImageLinks   `json:"imageLinks"`
 }
 
 type googleItem struct {
@@ -35,37 +64,64 @@ type googleResponse struct {
 }
 
 type Google struct {
-	url          string
-	apiKey       string
-	isbnQueryUrl string
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
 }
 
-func NewGoogle(conf *config.GoogleConfig) Provider {
+func NewGoogle(conf *config.GoogleConfig, client *http.Client) Provider {
 	google := Google{
+		name:   instanceName(conf.Name, "Google"),
 		url:    fmt.Sprintf("https://%s", conf.Url),
 		apiKey: conf.ApiKey,
+		client: client,
 	}
-	if google.apiKey != "" {
-		google.isbnQueryUrl = fmt.Sprintf("%s?key=%s", google.url, google.apiKey)
-	} else {
-		google.isbnQueryUrl = fmt.Sprintf("%s?", google.url)
-	}
-	return NewGeneric(&google, conf.MillisecondsPerRequest)
+	return NewGeneric(&google, conf.MillisecondsPerRequest, conf.Retry)
 }
 
 func (g *Google) Name() string {
-	return "Google"
+	return g.name
+}
+
+func (g *Google) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	return g.query(ctx, fmt.Sprintf("isbn:%s", isbn), "", filePath, 100)
+}
+
+func (g *Google) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	return g.FindResultByQueryInLanguage(ctx, title, author, "", filePath)
+}
+
+func (g *Google) FindResultByQueryInLanguage(ctx context.Context, title string, author string, language string, filePath string) (book.BookResult, error, int) {
+	q := fmt.Sprintf("intitle:%s", title)
+	if len(author) > 0 {
+		q = fmt.Sprintf("%s inauthor:%s", q, author)
+	}
+	// a title search is much more likely to hit the wrong work than an ISBN search
+	return g.query(ctx, q, language, filePath, 75)
 }
 
-func (g *Google) FindResult(isbn book.ISBN, filePath string) (book.BookResult, error, int) {
-	queryUrl := fmt.Sprintf("%s&q=isbn:%s", g.isbnQueryUrl, isbn)
-	response, err := http.Get(queryUrl)
+func (g *Google) query(ctx context.Context, q string, langRestrict string, filePath string, confidence float64) (book.BookResult, error, int) {
+	query := url.Values{}
+	if len(g.apiKey) > 0 {
+		query.Set("key", g.apiKey)
+	}
+	query.Set("q", q)
+	if len(langRestrict) > 0 {
+		query.Set("langRestrict", langRestrict)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", g.url, query.Encode()), nil)
 	if err != nil {
 		return book.BookResult{}, err, 0
 	}
+	response, err := send(g.client, request)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
-		return book.BookResult{}, fmt.Errorf("google returned bad status code %d: %s", response.StatusCode, response.Body), response.StatusCode
+		return book.BookResult{}, rateLimited(response, fmt.Errorf("google returned bad status code %d: %s", response.StatusCode, response.Body)), response.StatusCode
 	}
 
 	var result googleResponse
@@ -80,18 +136,17 @@ func (g *Google) FindResult(isbn book.ISBN, filePath string) (book.BookResult, e
 	}
 
 	var bestResult googleItem
-	magic := 999999999
-	bestMatch := magic
+	bestMatch := -1.0
 
-	filename := filepath.Base(filePath)
+	filenameTitle := util.FilenameTitle(filePath)
 	for _, item := range result.Items {
-		distance := util.LevenshteinDistance(item.VolumeInfo.Title, filename)
-		if distance < bestMatch {
-			bestMatch = distance
+		similarity := util.TitleSimilarity(item.VolumeInfo.Title, filenameTitle)
+		if similarity > bestMatch {
+			bestMatch = similarity
 			bestResult = item
 		}
 	}
-	if bestMatch == magic {
+	if bestMatch < 0 {
 		return book.BookResult{}, fmt.Errorf("unable to identify a good match from multiple returned works"), response.StatusCode
 	}
 
@@ -110,10 +165,37 @@ func (g *Google) FindResult(isbn book.ISBN, filePath string) (book.BookResult, e
 		case "other":
 			break
 		default:
-			log.Printf("info: google returned unsupported identifier type %s: %s", identifier.Type, identifier.Identifier)
+			slog.Debug("google returned unsupported identifier type", "type", identifier.Type, "identifier", identifier.Identifier)
 		}
 	}
 
+	var language mo.Option[string]
+	if code := util.PrimaryLanguage(bestResult.VolumeInfo.Language); len(code) > 0 {
+		language = mo.Some(code)
+	}
+
+	info := &bestResult.VolumeInfo
+	var publisher, description mo.Option[string]
+	if len(info.Publisher) > 0 {
+		publisher = mo.Some(info.Publisher)
+	}
+	if short := util.ShortDescription(info.Description); len(short) > 0 {
+		description = mo.Some(short)
+	}
+	var pages mo.Option[uint]
+	if info.PageCount > 0 {
+		pages = mo.Some(info.PageCount)
+	}
+	var subjects mo.Option[[]string]
+	if len(info.Categories) > 0 {
+		subjects = mo.Some(info.Categories)
+	}
+
+	var coverUrl mo.Option[string]
+	if link := bestResult.VolumeInfo.ImageLinks.largest(); len(link) > 0 {
+		coverUrl = mo.Some(link)
+	}
+
 	return book.BookResult{
 		Filepath:           filePath,
 		Title:              mo.Some(bestResult.VolumeInfo.Title),
@@ -122,8 +204,14 @@ func (g *Google) FindResult(isbn book.ISBN, filePath string) (book.BookResult, e
 		Isbn13:             isbn13,
 		Uom:                uom,
 		PublishDate:        mo.Some(bestResult.VolumeInfo.PublishedDate),
-		Confidence:         100,
-		SourceProviderName: "google",
+		Publisher:          publisher,
+		Pages:              pages,
+		Subjects:           subjects,
+		Description:        description,
+		Language:           language,
+		CoverUrl:           coverUrl,
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(g.name),
 	}, nil, response.StatusCode
 }
 
@@ -131,5 +219,5 @@ func (g *Google) Shutdown() {
 }
 
 func (g *Google) HealthCheck() (bool, string) {
-	return true, ""
+	return probe(g.client, g.url)
 }
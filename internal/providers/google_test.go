@@ -0,0 +1,55 @@
+package providers_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const googleResponse = `{
+  "totalItems": 1,
+  "items": [
+    {
+      "volumeInfo": {
+        "title": "Pride & Prejudice",
+        "authors": ["Jane Austen"],
+        "industryIdentifiers": [{"type": "ISBN_13", "identifier": "9780141439518"}],
+        "language": "en"
+      }
+    }
+  ]
+}`
+
+func TestGoogleQuery(t *testing.T) {
+	var queries []map[string][]string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(googleResponse))
+	}))
+	defer server.Close()
+
+	conf := config.GoogleConfig{Url: strings.TrimPrefix(server.URL, "https://"), ApiKey: "secret", Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider := providers.NewGoogle(&conf, server.Client())
+	search := &providers.SearchTerms{Title: "Pride & Prejudice", Author: "Jane Austen", Filepath: "/books/Pride & Prejudice.epub"}
+	results, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	// the & of the title stays in the search rather than starting another parameter
+	assert.Len(t, queries, 1)
+	assert.Equal(t, []string{"intitle:Pride & Prejudice inauthor:Jane Austen"}, queries[0]["q"])
+	assert.Equal(t, []string{"secret"}, queries[0]["key"])
+	assert.Len(t, results, 1)
+	assert.Equal(t, mo.Some("Pride & Prejudice"), results[0].Title)
+
+	search = &providers.SearchTerms{Title: "C++ Primer", Filepath: "/books/C++ Primer.pdf"}
+	_, err = provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Len(t, queries, 2)
+	assert.Equal(t, []string{"intitle:C++ Primer"}, queries[1]["q"])
+}
@@ -0,0 +1,208 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// isbndbStrings is a field ISBNdb gives as either one string or a list of them, depending on the book
+type isbndbStrings []string
+
+func (s *isbndbStrings) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = isbndbStrings{one}
+		return nil
+	}
+	var many []string
+	err := json.Unmarshal(data, &many)
+	*s = many
+	return err
+}
+
+type isbndbBook struct {
+	Title         string        `json:"title"`
+	TitleLong     string        `json:"title_long"`
+	Isbn          string        `json:"isbn"`
+	Isbn10        string        `json:"isbn10"`
+	Isbn13        string        `json:"isbn13"`
+	Authors       []string      `json:"authors"`
+	Publisher     string        `json:"publisher"`
+	DatePublished string        `json:"date_published"`
+	Binding       string        `json:"binding"`
+	Pages         uint          `json:"pages"`
+	Language      string        `json:"language"`
+	Image         string        `json:"image"`
+	Subjects      []string      `json:"subjects"`
+	Synopsis      string        `json:"synopsis"`
+	DeweyDecimal  isbndbStrings `json:"dewey_decimal"`
+}
+
+type isbndbBookResponse struct {
+	Book isbndbBook `json:"book"`
+}
+
+type isbndbBooksResponse struct {
+	Total int          `json:"total"`
+	Books []isbndbBook `json:"books"`
+}
+
+type Isbndb struct {
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewIsbndb(conf *config.IsbndbConfig, client *http.Client) Provider {
+	isbndb := Isbndb{
+		name:   instanceName(conf.Name, "ISBNdb"),
+		url:    fmt.Sprintf("https://%s", conf.Url),
+		apiKey: conf.ApiKey,
+		client: client,
+	}
+	return NewGeneric(&isbndb, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (i *Isbndb) Name() string {
+	return i.name
+}
+
+func (i *Isbndb) get(ctx context.Context, queryUrl string, into any) (error, int) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, queryUrl, nil)
+	if err != nil {
+		return err, 0
+	}
+	request.Header.Set("Authorization", i.apiKey)
+	request.Header.Set("Accept", "application/json")
+
+	response, err := send(i.client, request)
+	if err != nil {
+		return err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, response.StatusCode
+	}
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return rateLimited(response, fmt.Errorf("isbndb returned bad status code %d: %s", response.StatusCode, string(body))), response.StatusCode
+	}
+
+	return json.NewDecoder(response.Body).Decode(into), response.StatusCode
+}
+
+func (i *Isbndb) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	var result isbndbBookResponse
+
+	err, statusCode := i.get(ctx, fmt.Sprintf("%s/book/%s", i.url, isbn), &result)
+	if err != nil || statusCode == http.StatusNotFound {
+		return book.BookResult{}, err, statusCode
+	}
+
+	return i.toBookResult(&result.Book, filePath, 100), nil, statusCode
+}
+
+func (i *Isbndb) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	var result isbndbBooksResponse
+
+	queryUrl := fmt.Sprintf("%s/books/%s?column=title&pageSize=20", i.url, url.PathEscape(title))
+	err, statusCode := i.get(ctx, queryUrl, &result)
+	if err != nil || statusCode == http.StatusNotFound || len(result.Books) == 0 {
+		return book.BookResult{}, err, statusCode
+	}
+
+	filenameTitle := util.FilenameTitle(filePath)
+	best := &result.Books[0]
+	bestMatch := util.TitleSimilarity(best.Title, filenameTitle)
+	for idx := range result.Books {
+		candidate := &result.Books[idx]
+		if len(author) > 0 && !strings.Contains(strings.ToLower(strings.Join(candidate.Authors, " ")), strings.ToLower(author)) {
+			continue
+		}
+		similarity := util.TitleSimilarity(candidate.Title, filenameTitle)
+		if similarity > bestMatch {
+			bestMatch = similarity
+			best = candidate
+		}
+	}
+
+	return i.toBookResult(best, filePath, 75), nil, statusCode
+}
+
+func (i *Isbndb) toBookResult(bk *isbndbBook, filePath string, confidence float64) book.BookResult {
+	optional := func(s string) mo.Option[string] {
+		if len(s) == 0 {
+			return mo.None[string]()
+		}
+		return mo.Some(s)
+	}
+
+	result := book.BookResult{
+		Filepath:           filePath,
+		Title:              optional(bk.Title),
+		PublishDate:        optional(bk.DatePublished),
+		Publisher:          optional(bk.Publisher),
+		Binding:            optional(bk.Binding),
+		Language:           optional(util.PrimaryLanguage(bk.Language)),
+		CoverUrl:           optional(bk.Image),
+		Description:        optional(util.ShortDescription(bk.Synopsis)),
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(i.name),
+	}
+
+	if len(bk.TitleLong) > 0 {
+		result.Title = mo.Some(bk.TitleLong)
+	}
+
+	if len(bk.Authors) > 0 {
+		result.Authors = mo.Some(bk.Authors)
+	}
+
+	if bk.Pages > 0 {
+		result.Pages = mo.Some(bk.Pages)
+	}
+
+	if len(bk.Subjects) > 0 {
+		result.Subjects = mo.Some(bk.Subjects)
+	}
+
+	for _, ddc := range bk.DeweyDecimal {
+		if ddc = util.NormalizeDdc(ddc); len(ddc) > 0 {
+			result.Ddc = mo.Some(ddc)
+			break
+		}
+	}
+
+	isbn10 := bk.Isbn10
+	if len(isbn10) == 0 && len(bk.Isbn) == 10 {
+		isbn10 = bk.Isbn
+	}
+	if len(isbn10) > 0 {
+		result.Isbn10 = mo.Some(book.ISBN10(isbn10))
+	}
+
+	if len(bk.Isbn13) > 0 {
+		result.Isbn13 = mo.Some(book.ISBN13(bk.Isbn13))
+	}
+
+	return result
+}
+
+func (i *Isbndb) Shutdown() {
+}
+
+func (i *Isbndb) HealthCheck() (bool, string) {
+	return probe(i.client, i.url)
+}
@@ -1,25 +1,69 @@
 package providers
 
 import (
+	"context"
+	"fmt"
 	"github.com/larkwiot/booker/internal/book"
 	"github.com/larkwiot/booker/internal/service"
+	"net/http"
+	"time"
 )
 
 type SearchTerms struct {
 	Isbn10s  []book.ISBN10
 	Isbn13s  []book.ISBN13
+	Dois     []book.DOI
+	Asins    []book.ASIN
+	Arxivs   []book.ArxivId
+	Title    string
+	Author   string
+	Year     uint
+	Pages    uint
 	Filepath string
+	// Embedded holds metadata read from the file itself by extractors
+	Embedded []book.BookResult
+	// Language is the ISO 639-1 code of the language the book's text was detected to be in, if it could be
+	Language string
+}
+
+func (s *SearchTerms) HasIdentifiers() bool {
+	return len(s.Isbn10s) > 0 || len(s.Isbn13s) > 0 || len(s.Dois) > 0 || len(s.Asins) > 0 || len(s.Arxivs) > 0
 }
 
 func (s *SearchTerms) HasAnyTerms() bool {
-	return len(s.Isbn10s) > 0 || len(s.Isbn13s) > 0
+	return s.HasIdentifiers() || len(s.Title) > 0
 }
 
 type Provider interface {
 	service.Service
 	Name() string
-	GetBookMetadata(search *SearchTerms) ([]book.BookResult, error)
+	GetBookMetadata(ctx context.Context, search *SearchTerms) ([]book.BookResult, error)
+	// Queries are the lookups GetBookMetadata would make for search, for a dry run to report
+	Queries(search *SearchTerms) []string
 	ClearCache()
 	Shutdown()
 	Disabled() bool
 }
+
+// probeTimeout is the longest the health check of a provider waits on its API
+const probeTimeout = 5 * time.Second
+
+// probe is the health check of a provider with an API at endpoint, which is up if the API answers without a server
+// error. Only the headers are asked for, so the check doesn't use up a request of the API's quota.
+func probe(client *http.Client, endpoint string) (bool, string) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, endpoint, nil)
+	if err != nil {
+		return false, fmt.Sprintf("invalid endpoint %s: %s", redact(endpoint), err.Error())
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return false, fmt.Sprintf("%s is unreachable: %s", redact(endpoint), err.Error())
+	}
+	response.Body.Close()
+	if response.StatusCode >= http.StatusInternalServerError {
+		return false, fmt.Sprintf("%s answered %s", redact(endpoint), response.Status)
+	}
+	return true, ""
+}
@@ -0,0 +1,111 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Response is the answer a provider got to one of its requests, as it was sent
+type Response struct {
+	Provider string `json:"provider"`
+	// Url is the request of an API, and the command of a plugin along with the Request it was sent
+	Url     string          `json:"url"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Status  int             `json:"status"`
+	// Body is kept as it was if it is JSON, and as a string otherwise
+	Body json.RawMessage `json:"body"`
+}
+
+// Recorder keeps the responses to the requests that providers make with a context given to WithRecorder, to see why a
+// book was identified the way it was
+type Recorder struct {
+	lock      sync.Mutex
+	responses []Response
+}
+
+type recorderKey struct{}
+type providerKey struct{}
+
+func WithRecorder(ctx context.Context, recorder *Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, recorder)
+}
+
+func (r *Recorder) Responses() []Response {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return slices.Clone(r.responses)
+}
+
+// record keeps a response with the body given if the context has a recorder
+func record(ctx context.Context, response Response, body []byte) {
+	recorder, ok := ctx.Value(recorderKey{}).(*Recorder)
+	if !ok {
+		return
+	}
+	response.Provider, _ = ctx.Value(providerKey{}).(string)
+
+	response.Body = bytes.TrimSpace(body)
+	if !json.Valid(response.Body) {
+		response.Body, _ = json.Marshal(string(body))
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	recorder.responses = append(recorder.responses, response)
+}
+
+// redact hides the API keys and tokens some providers send in their query strings
+func redact(target string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	query := parsed.Query()
+	redacted := false
+	for name := range query {
+		lower := strings.ToLower(name)
+		if strings.Contains(lower, "key") || strings.Contains(lower, "token") || strings.Contains(lower, "secret") {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return target
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// recordedBody records the body of a response once it has been read and closed
+type recordedBody struct {
+	io.ReadCloser
+	ctx      context.Context
+	response *http.Response
+	data     bytes.Buffer
+}
+
+func (rb *recordedBody) Read(p []byte) (int, error) {
+	n, err := rb.ReadCloser.Read(p)
+	rb.data.Write(p[:n])
+	return n, err
+}
+
+func (rb *recordedBody) Close() error {
+	record(rb.ctx, Response{Url: redact(rb.response.Request.URL.String()), Status: rb.response.StatusCode}, rb.data.Bytes())
+	return rb.ReadCloser.Close()
+}
+
+// recordResponse has the body of response recorded if the request's context has a recorder
+func recordResponse(response *http.Response) {
+	ctx := response.Request.Context()
+	if _, ok := ctx.Value(recorderKey{}).(*Recorder); ok {
+		response.Body = &recordedBody{ReadCloser: response.Body, ctx: ctx, response: response}
+	}
+}
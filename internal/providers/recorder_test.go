@@ -0,0 +1,39 @@
+package providers_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"totalItems": 0}`))
+	}))
+	defer server.Close()
+
+	conf := config.GoogleConfig{Url: strings.TrimPrefix(server.URL, "https://"), ApiKey: "secret", Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider := providers.NewGoogle(&conf, server.Client())
+
+	recorder := &providers.Recorder{}
+	ctx := providers.WithRecorder(context.Background(), recorder)
+	search := &providers.SearchTerms{Isbn13s: []book.ISBN13{"9781718501263"}, Filepath: "/books/a.pdf"}
+	_, err := provider.GetBookMetadata(ctx, search)
+	assert.NoError(t, err)
+
+	responses := recorder.Responses()
+	assert.Len(t, responses, 1)
+	assert.Equal(t, "Google", responses[0].Provider)
+	assert.Equal(t, http.StatusOK, responses[0].Status)
+	assert.JSONEq(t, `{"totalItems": 0}`, string(responses[0].Body))
+	// the API key is left out
+	assert.Contains(t, responses[0].Url, "key=REDACTED")
+	assert.NotContains(t, responses[0].Url, "secret")
+}
@@ -0,0 +1,232 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+type springerName struct {
+	Creator    string `json:"creator"`
+	BookEditor string `json:"bookEditor"`
+}
+
+type springerRecord struct {
+	ContentType     string         `json:"contentType"`
+	Title           string         `json:"title"`
+	Creators        []springerName `json:"creators"`
+	BookEditors     []springerName `json:"bookEditors"`
+	PublicationName string         `json:"publicationName"`
+	Doi             string         `json:"doi"`
+	Publisher       string         `json:"publisher"`
+	PublicationDate string         `json:"publicationDate"`
+	PrintIsbn       string         `json:"printIsbn"`
+	ElectronicIsbn  string         `json:"electronicIsbn"`
+	Isbn            string         `json:"isbn"`
+	Language        string         `json:"language"`
+	Abstract        string         `json:"abstract"`
+	Subjects        []string       `json:"subjects"`
+}
+
+type springerResponse struct {
+	Records []springerRecord `json:"records"`
+}
+
+// springerChapterRegex matches the suffix Springer gives the DOIs of chapters on top of the DOI of their book, like
+// the _3 of 10.1007/978-3-030-12345-6_3
+var springerChapterRegex = regexp.MustCompile(`_\d+$`)
+
+// springerEditionRegex matches the edition at the end of a title, like "Second Edition" or "3rd ed."
+var springerEditionRegex = regexp.MustCompile(`(?i)[\s,:(]+((?:\d+(?:st|nd|rd|th)|first|second|third|fourth|fifth|sixth|seventh|eighth|ninth|tenth)\s+ed(?:ition|\.)?)\)?$`)
+
+// Springer looks books up in the Springer Nature metadata API, which has the technical and academic books of Springer,
+// Apress and their imprints with their editors, and the chapters of those books by their own DOIs
+type Springer struct {
+	name   string
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewSpringer(conf *config.SpringerConfig, client *http.Client) Provider {
+	springer := Springer{
+		name:   instanceName(conf.Name, "Springer"),
+		url:    fmt.Sprintf("https://%s", conf.Url),
+		apiKey: conf.ApiKey,
+		client: client,
+	}
+	return NewGeneric(&springer, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (s *Springer) Name() string {
+	return s.name
+}
+
+func (s *Springer) search(ctx context.Context, q string, filePath string, confidence float64) (book.BookResult, error, int) {
+	query := url.Values{}
+	query.Set("q", q)
+	query.Set("p", "5")
+	query.Set("api_key", s.apiKey)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", s.url, query.Encode()), nil)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+
+	response, err := send(s.client, request)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return book.BookResult{}, rateLimited(response, fmt.Errorf("springer returned bad status code %d: %s", response.StatusCode, string(body))), response.StatusCode
+	}
+
+	var result springerResponse
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return book.BookResult{}, err, response.StatusCode
+	}
+	if len(result.Records) == 0 {
+		return book.BookResult{}, nil, http.StatusNotFound
+	}
+
+	filenameTitle := util.FilenameTitle(filePath)
+	best := &result.Records[0]
+	bestMatch := util.TitleSimilarity(springerTitle(best), filenameTitle)
+	for idx := range result.Records {
+		record := &result.Records[idx]
+		similarity := util.TitleSimilarity(springerTitle(record), filenameTitle)
+		// the book itself is better than any of its chapters
+		if (record.ContentType == "Book") != (best.ContentType == "Book") {
+			if record.ContentType == "Book" {
+				bestMatch = similarity
+				best = record
+			}
+			continue
+		}
+		if similarity > bestMatch {
+			bestMatch = similarity
+			best = record
+		}
+	}
+
+	return s.toBookResult(best, filePath, confidence), nil, response.StatusCode
+}
+
+func (s *Springer) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	return s.search(ctx, fmt.Sprintf("isbn:%s", isbn), filePath, 100)
+}
+
+// FindResultByDoi finds the book of a DOI, which is the book a chapter is in when the DOI is a chapter's, since the
+// chapters of Springer books have their DOIs printed on every page
+func (s *Springer) FindResultByDoi(ctx context.Context, doi book.DOI, filePath string) (book.BookResult, error, int) {
+	return s.search(ctx, fmt.Sprintf("doi:%s", doi), filePath, 100)
+}
+
+func (s *Springer) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	q := fmt.Sprintf(`title:"%s"`, strings.ReplaceAll(title, `"`, ""))
+	if len(author) > 0 {
+		q = fmt.Sprintf(`%s name:"%s"`, q, strings.ReplaceAll(author, `"`, ""))
+	}
+	return s.search(ctx, q, filePath, 75)
+}
+
+// springerTitle is the title of the book a record is of or in
+func springerTitle(record *springerRecord) string {
+	if record.ContentType == "Chapter" && len(record.PublicationName) > 0 {
+		return record.PublicationName
+	}
+	return record.Title
+}
+
+// springerNames turns names written "Last, First" around
+func springerNames(names []springerName) []string {
+	turned := make([]string, 0, len(names))
+	for _, name := range names {
+		full := strings.TrimSpace(name.Creator + name.BookEditor)
+		if last, first, inverted := strings.Cut(full, ","); inverted {
+			full = fmt.Sprintf("%s %s", strings.TrimSpace(first), strings.TrimSpace(last))
+		}
+		if len(full) > 0 {
+			turned = append(turned, full)
+		}
+	}
+	return turned
+}
+
+func (s *Springer) toBookResult(record *springerRecord, filePath string, confidence float64) book.BookResult {
+	result := book.BookResult{
+		Filepath:           filePath,
+		Publisher:          mo.EmptyableToOption(record.Publisher),
+		PublishDate:        mo.EmptyableToOption(record.PublicationDate),
+		Language:           mo.EmptyableToOption(util.PrimaryLanguage(record.Language)),
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(s.name),
+	}
+
+	title := springerTitle(record)
+	if match := springerEditionRegex.FindStringSubmatchIndex(title); match != nil {
+		result.Edition = mo.Some(title[match[2]:match[3]])
+		title = title[:match[0]]
+	}
+	result.Title = mo.EmptyableToOption(title)
+
+	// the creators of a chapter are only its own authors, and the book is by its editors
+	if authors := springerNames(record.Creators); len(authors) > 0 && record.ContentType != "Chapter" {
+		result.Authors = mo.Some(authors)
+	}
+	if editors := springerNames(record.BookEditors); len(editors) > 0 {
+		result.Editors = mo.Some(editors)
+	}
+
+	if len(record.Doi) > 0 {
+		doi := strings.ToLower(record.Doi)
+		if record.ContentType == "Chapter" {
+			doi = springerChapterRegex.ReplaceAllString(doi, "")
+		}
+		result.Doi = mo.Some(book.DOI(doi))
+	}
+
+	for _, isbn := range []string{record.ElectronicIsbn, record.PrintIsbn, record.Isbn} {
+		isbn = strings.ReplaceAll(isbn, "-", "")
+		switch len(isbn) {
+		case 10:
+			if result.Isbn10.IsAbsent() {
+				result.Isbn10 = mo.Some(book.ISBN10(isbn))
+			}
+		case 13:
+			if result.Isbn13.IsAbsent() {
+				result.Isbn13 = mo.Some(book.ISBN13(isbn))
+			}
+		}
+	}
+
+	if len(record.Subjects) > 0 {
+		result.Subjects = mo.Some(record.Subjects)
+	}
+	// abstracts come with HTML tags
+	if abstract := util.ShortDescription(record.Abstract); len(abstract) > 0 && record.ContentType != "Chapter" {
+		result.Description = mo.Some(abstract)
+	}
+
+	return result
+}
+
+func (s *Springer) Shutdown() {
+}
+
+func (s *Springer) HealthCheck() (bool, string) {
+	return probe(s.client, s.url)
+}
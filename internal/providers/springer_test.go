@@ -0,0 +1,64 @@
+package providers_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const springerChapterResponse = `{
+  "records": [
+    {
+      "contentType": "Chapter",
+      "title": "Force-Directed Drawing Algorithms",
+      "creators": [{"creator": "Kobourov, Stephen"}],
+      "bookEditors": [{"bookEditor": "Tamassia, Roberto"}],
+      "publicationName": "Handbook of Graph Drawing, Second Edition",
+      "doi": "10.1007/978-1-4842-0076-6_1",
+      "publisher": "Apress",
+      "publicationDate": "2014-11-18",
+      "printIsbn": "978-1-4842-0077-3",
+      "electronicIsbn": "978-1-4842-0076-6",
+      "language": "en",
+      "abstract": "<p>This chapter is about drawing graphs with springs.</p>",
+      "subjects": ["Computer Science"]
+    }
+  ]
+}`
+
+func TestSpringer(t *testing.T) {
+	var query string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("q")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(springerChapterResponse))
+	}))
+	defer server.Close()
+
+	conf := config.SpringerConfig{Url: strings.TrimPrefix(server.URL, "https://"), ApiKey: "secret", Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider := providers.NewSpringer(&conf, server.Client())
+	search := &providers.SearchTerms{Dois: []book.DOI{"10.1007/978-1-4842-0076-6_1"}, Filepath: "/books/graph-drawing.pdf"}
+	results, err := provider.GetBookMetadata(context.Background(), search)
+	assert.NoError(t, err)
+	assert.Equal(t, "doi:10.1007/978-1-4842-0076-6_1", query)
+	assert.Len(t, results, 1)
+
+	// a chapter's DOI finds the book it is in
+	result := results[0]
+	assert.Equal(t, mo.Some("Handbook of Graph Drawing"), result.Title)
+	assert.Equal(t, mo.Some("Second Edition"), result.Edition)
+	assert.True(t, result.Authors.IsAbsent())
+	assert.Equal(t, mo.Some([]string{"Roberto Tamassia"}), result.Editors)
+	assert.Equal(t, mo.Some(book.DOI("10.1007/978-1-4842-0076-6")), result.Doi)
+	assert.Equal(t, mo.Some(book.ISBN13("9781484200766")), result.Isbn13)
+	assert.True(t, result.Description.IsAbsent())
+	assert.Equal(t, mo.Some("en"), result.Language)
+	assert.Equal(t, "springer", result.SourceProviderName)
+}
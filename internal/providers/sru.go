@@ -0,0 +1,435 @@
+package providers
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/lo"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type marcXmlSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcXmlDatafield struct {
+	Tag       string            `xml:"tag,attr"`
+	Subfields []marcXmlSubfield `xml:"subfield"`
+}
+
+type marcXmlControlfield struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+// marcXmlRecord is a MARCXML record, which MARC 21 and UNIMARC records share the layout of
+type marcXmlRecord struct {
+	Controlfields []marcXmlControlfield `xml:"controlfield"`
+	Datafields    []marcXmlDatafield    `xml:"datafield"`
+}
+
+type sruDiagnostic struct {
+	Message string `xml:"message"`
+	Details string `xml:"details"`
+}
+
+type sruResponse struct {
+	Records []struct {
+		Data struct {
+			Record marcXmlRecord `xml:"record"`
+		} `xml:"recordData"`
+	} `xml:"records>record"`
+	Diagnostics []sruDiagnostic `xml:"diagnostics>diagnostic"`
+}
+
+// marcLanguages maps the ISO 639-2 codes of MARC records to the ISO 639-1 codes the rest of Booker uses
+var marcLanguages = map[string]string{
+	"ara": "ar", "chi": "zh", "zho": "zh", "cze": "cs", "ces": "cs", "dan": "da", "dut": "nl", "nld": "nl", "eng": "en",
+	"fin": "fi", "fre": "fr", "fra": "fr", "ger": "de", "deu": "de", "gre": "el", "ell": "el", "heb": "he", "hun": "hu",
+	"ita": "it", "jpn": "ja", "kor": "ko", "lat": "la", "nor": "no", "pol": "pl", "por": "pt", "rus": "ru", "spa": "es",
+	"swe": "sv", "tur": "tr", "ukr": "uk",
+}
+
+var marcIsbnRegex = regexp.MustCompile(`^[0-9Xx-]+`)
+var marcNumberRegex = regexp.MustCompile(`\d+`)
+var marcYearRegex = regexp.MustCompile(`\d{4}`)
+
+// marcTags are where a MARC format keeps each field, as the tag followed by the codes of its subfields
+type marcTags struct {
+	isbn        string
+	title       string
+	subtitle    string
+	authors     []string
+	publishers  []string
+	pages       string
+	series      string
+	subjects    []string
+	lcc         string
+	ddc         string
+	description string
+	language    string
+}
+
+var marc21Tags = marcTags{
+	isbn:        "020a",
+	title:       "245a",
+	subtitle:    "245b",
+	authors:     []string{"100", "700"},
+	publishers:  []string{"264", "260"},
+	pages:       "300a",
+	series:      "490",
+	subjects:    []string{"650a", "689a"},
+	lcc:         "050a",
+	ddc:         "082a",
+	description: "520a",
+	language:    "041a",
+}
+
+var unimarcTags = marcTags{
+	isbn:        "010a",
+	title:       "200a",
+	subtitle:    "200e",
+	authors:     []string{"700", "701"},
+	publishers:  []string{"210", "214"},
+	pages:       "215a",
+	series:      "225",
+	subjects:    []string{"606a"},
+	lcc:         "680a",
+	ddc:         "676a",
+	description: "330a",
+	language:    "101a",
+}
+
+// Sru searches a library catalog over SRU, which many national libraries offer and which knows far more of the books
+// published in their countries than Google does
+type Sru struct {
+	name         string
+	url          string
+	version      string
+	recordSchema string
+	format       string
+	tags         *marcTags
+	isbnIndex    string
+	titleIndex   string
+	authorIndex  string
+	client       *http.Client
+}
+
+func NewSru(conf *config.SruConfig, client *http.Client) Provider {
+	sru := Sru{
+		name:         conf.Name,
+		url:          conf.Url,
+		version:      conf.Version,
+		recordSchema: conf.RecordSchema,
+		format:       conf.Format,
+		tags:         &marc21Tags,
+		isbnIndex:    conf.IsbnIndex,
+		titleIndex:   conf.TitleIndex,
+		authorIndex:  conf.AuthorIndex,
+		client:       client,
+	}
+	if conf.Format == "unimarc" {
+		sru.tags = &unimarcTags
+	}
+	return NewGeneric(&sru, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (s *Sru) Name() string {
+	return s.name
+}
+
+// cqlTerm quotes a search term for a CQL query
+func cqlTerm(term string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(term) + `"`
+}
+
+func (s *Sru) search(ctx context.Context, cql string) (*sruResponse, error, int) {
+	query := url.Values{}
+	query.Set("operation", "searchRetrieve")
+	query.Set("version", s.version)
+	query.Set("query", cql)
+	query.Set("recordSchema", s.recordSchema)
+	query.Set("maximumRecords", "5")
+	// SRU 2.0 renamed the parameter asking for the records as XML rather than as escaped strings
+	if strings.HasPrefix(s.version, "2") {
+		query.Set("recordXMLEscaping", "xml")
+	} else {
+		query.Set("recordPacking", "xml")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?%s", s.url, query.Encode()), nil)
+	if err != nil {
+		return nil, err, 0
+	}
+
+	response, err := send(s.client, request)
+	if err != nil {
+		return nil, err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return nil, rateLimited(response, fmt.Errorf("%s returned bad status code %d: %s", s.name, response.StatusCode, string(body))), response.StatusCode
+	}
+
+	var result sruResponse
+	if err := xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error: unable to decode %s response: %s", s.name, err.Error()), response.StatusCode
+	}
+	// a catalog that doesn't understand a query says so in its diagnostics, usually with a 200
+	if len(result.Diagnostics) > 0 && len(result.Records) == 0 {
+		diagnostic := result.Diagnostics[0]
+		return nil, fmt.Errorf("%s could not search for %s: %s %s", s.name, cql, diagnostic.Message, diagnostic.Details), http.StatusBadRequest
+	}
+	if len(result.Records) == 0 {
+		return nil, nil, http.StatusNotFound
+	}
+	return &result, nil, response.StatusCode
+}
+
+func (s *Sru) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	result, err, statusCode := s.search(ctx, fmt.Sprintf("%s=%s", s.isbnIndex, cqlTerm(string(isbn))))
+	if result == nil {
+		return book.BookResult{}, err, statusCode
+	}
+	return s.toBookResult(&result.Records[0].Data.Record, filePath, 100), nil, statusCode
+}
+
+func (s *Sru) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	cql := fmt.Sprintf("%s=%s", s.titleIndex, cqlTerm(title))
+	if len(author) > 0 {
+		cql = fmt.Sprintf("%s and %s=%s", cql, s.authorIndex, cqlTerm(author))
+	}
+	result, err, statusCode := s.search(ctx, cql)
+	if result == nil {
+		return book.BookResult{}, err, statusCode
+	}
+
+	filenameTitle := util.FilenameTitle(filePath)
+	best := &result.Records[0].Data.Record
+	bestMatch := util.TitleSimilarity(s.title(best), filenameTitle)
+	for idx := range result.Records {
+		record := &result.Records[idx].Data.Record
+		similarity := util.TitleSimilarity(s.title(record), filenameTitle)
+		if similarity > bestMatch {
+			bestMatch = similarity
+			best = record
+		}
+	}
+	return s.toBookResult(best, filePath, 75), nil, statusCode
+}
+
+// subfields are the values of every subfield with code in the fields with tag
+func (r *marcXmlRecord) subfields(tag string, code string) []string {
+	values := make([]string, 0)
+	for _, field := range r.Datafields {
+		if field.Tag != tag {
+			continue
+		}
+		for _, subfield := range field.Subfields {
+			if subfield.Code == code {
+				values = append(values, cleanMarc(subfield.Value))
+			}
+		}
+	}
+	return values
+}
+
+// subfield is the first value of a tag and subfield code like "245a", or empty if the record has none
+func (r *marcXmlRecord) subfield(tagCode string) string {
+	for _, value := range r.subfields(tagCode[:3], tagCode[3:]) {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+func (r *marcXmlRecord) controlfield(tag string) string {
+	for _, field := range r.Controlfields {
+		if field.Tag == tag {
+			return field.Value
+		}
+	}
+	return ""
+}
+
+// cleanMarc drops the punctuation catalogers end subfields with to separate them, like the " /" after a title, and the
+// marks around the articles that are skipped when sorting
+func cleanMarc(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '\u0098' || r == '\u009c' || r == '¬' {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(value), " /:;,="))
+	// a final period is punctuation too, unless it ends an initial or an abbreviation like "Jr."
+	if trimmed, ok := strings.CutSuffix(value, "."); ok && !strings.Contains(trimmed[max(len(trimmed)-3, 0):], " ") {
+		value = trimmed
+	}
+	return value
+}
+
+func (s *Sru) title(record *marcXmlRecord) string {
+	title := record.subfield(s.tags.title)
+	if subtitle := record.subfield(s.tags.subtitle); len(title) > 0 && len(subtitle) > 0 {
+		title = fmt.Sprintf("%s: %s", title, subtitle)
+	}
+	return title
+}
+
+// authors are the names of the record's people, written first name first. MARC 21 records keep them inverted in one
+// subfield and UNIMARC records keep the first names in a subfield of their own
+func (s *Sru) authors(record *marcXmlRecord) []string {
+	authors := make([]string, 0)
+	for _, tag := range s.tags.authors {
+		for _, field := range record.Datafields {
+			if field.Tag != tag {
+				continue
+			}
+			var name, firstNames string
+			relators := make([]string, 0)
+			for _, subfield := range field.Subfields {
+				switch {
+				case subfield.Code == "a" && len(name) == 0:
+					name = cleanMarc(subfield.Value)
+				case subfield.Code == "b" && s.format == "unimarc":
+					firstNames = cleanMarc(subfield.Value)
+				case subfield.Code == "4":
+					relators = append(relators, cleanMarc(subfield.Value))
+				}
+			}
+			// editors, translators and illustrators are only listed when their role is given
+			if len(relators) > 0 && !lo.Contains(relators, "aut") && !lo.Contains(relators, "070") {
+				continue
+			}
+			if last, first, inverted := strings.Cut(name, ","); inverted && len(firstNames) == 0 {
+				name, firstNames = strings.TrimSpace(last), strings.TrimSpace(first)
+			}
+			if len(firstNames) > 0 {
+				name = fmt.Sprintf("%s %s", firstNames, name)
+			}
+			if len(name) > 0 && !lo.Contains(authors, name) {
+				authors = append(authors, name)
+			}
+		}
+	}
+	return authors
+}
+
+func (s *Sru) toBookResult(record *marcXmlRecord, filePath string, confidence float64) book.BookResult {
+	tags := s.tags
+	result := book.BookResult{
+		Filepath:           filePath,
+		Title:              mo.EmptyableToOption(s.title(record)),
+		Lcc:                mo.EmptyableToOption(record.subfield(tags.lcc)),
+		Ddc:                mo.EmptyableToOption(record.subfield(tags.ddc)),
+		Description:        mo.EmptyableToOption(util.ShortDescription(record.subfield(tags.description))),
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(s.name),
+	}
+
+	if authors := s.authors(record); len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	for _, value := range record.subfields(tags.isbn[:3], tags.isbn[3:]) {
+		isbn := strings.ToUpper(strings.ReplaceAll(marcIsbnRegex.FindString(value), "-", ""))
+		switch len(isbn) {
+		case 10:
+			if result.Isbn10.IsAbsent() {
+				result.Isbn10 = mo.Some(book.ISBN10(isbn))
+			}
+		case 13:
+			if result.Isbn13.IsAbsent() {
+				result.Isbn13 = mo.Some(book.ISBN13(isbn))
+			}
+		}
+	}
+
+	if s.format == "marc21" {
+		for _, field := range record.Datafields {
+			if field.Tag != "024" && field.Tag != "035" {
+				continue
+			}
+			values := lo.SliceToMap(field.Subfields, func(subfield marcXmlSubfield) (string, string) {
+				return subfield.Code, strings.TrimSpace(subfield.Value)
+			})
+			if field.Tag == "024" && strings.EqualFold(values["2"], "doi") && result.Doi.IsAbsent() {
+				result.Doi = mo.EmptyableToOption(book.DOI(strings.ToLower(values["a"])))
+			}
+			if oclc, ok := strings.CutPrefix(values["a"], "(OCoLC)"); field.Tag == "035" && ok && result.Oclc.IsAbsent() {
+				result.Oclc = mo.EmptyableToOption(strings.TrimLeft(oclc, "ocmn"))
+			}
+		}
+	}
+
+	// MARC 21 puts the publisher in $b and the date in $c, UNIMARC in $c and $d
+	publisherCode, dateCode := "b", "c"
+	if s.format == "unimarc" {
+		publisherCode, dateCode = "c", "d"
+	}
+	for _, tag := range tags.publishers {
+		if publisher := record.subfield(tag + publisherCode); len(publisher) > 0 && result.Publisher.IsAbsent() {
+			result.Publisher = mo.Some(strings.Trim(publisher, "[]"))
+		}
+		if year := marcYearRegex.FindString(record.subfield(tag + dateCode)); len(year) > 0 && result.PublishDate.IsAbsent() {
+			result.PublishDate = mo.Some(year)
+		}
+	}
+
+	// the extent is like "xii, 345 p." or "1 Online-Ressource (345 Seiten)", the most pages listed being the book's
+	var pages uint
+	for _, number := range marcNumberRegex.FindAllString(record.subfield(tags.pages), -1) {
+		if count, err := strconv.ParseUint(number, 10, 32); err == nil {
+			pages = max(pages, uint(count))
+		}
+	}
+	result.Pages = mo.EmptyableToOption(pages)
+
+	if series := record.subfield(tags.series + "a"); len(series) > 0 {
+		result.Series = mo.Some(series)
+		if index, err := strconv.ParseFloat(marcNumberRegex.FindString(record.subfield(tags.series+"v")), 64); err == nil {
+			result.SeriesIndex = mo.Some(index)
+		}
+	}
+
+	subjects := make([]string, 0)
+	for _, tagCode := range tags.subjects {
+		for _, subject := range record.subfields(tagCode[:3], tagCode[3:]) {
+			if len(subject) > 0 && !lo.Contains(subjects, subject) {
+				subjects = append(subjects, subject)
+			}
+		}
+	}
+	if len(subjects) > 0 {
+		result.Subjects = mo.Some(subjects)
+	}
+
+	language := record.subfield(tags.language)
+	if fixed := record.controlfield("008"); len(language) == 0 && s.format == "marc21" && len(fixed) >= 38 {
+		language = fixed[35:38]
+	}
+	result.Language = mo.EmptyableToOption(marcLanguages[strings.ToLower(language)])
+
+	return result
+}
+
+func (s *Sru) Shutdown() {
+}
+
+func (s *Sru) HealthCheck() (bool, string) {
+	return probe(s.client, s.url)
+}
@@ -0,0 +1,161 @@
+package providers_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/providers"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const sruMarc21Response = `<?xml version="1.0" encoding="UTF-8"?>
+<searchRetrieveResponse xmlns="http://www.loc.gov/zing/srw/">
+  <version>1.1</version>
+  <numberOfRecords>1</numberOfRecords>
+  <records>
+    <record>
+      <recordSchema>MARC21-xml</recordSchema>
+      <recordPacking>xml</recordPacking>
+      <recordData>
+        <record xmlns="http://www.loc.gov/MARC21/slim" type="Bibliographic">
+          <controlfield tag="001">1153919005</controlfield>
+          <controlfield tag="008">180307s2018    gw |||||o|||| 00||||ger  </controlfield>
+          <datafield tag="020" ind1=" " ind2=" ">
+            <subfield code="a">978-3-446-45849-1</subfield>
+            <subfield code="c">Festeinband : EUR 32.00</subfield>
+          </datafield>
+          <datafield tag="035" ind1=" " ind2=" ">
+            <subfield code="a">(OCoLC)1027799527</subfield>
+          </datafield>
+          <datafield tag="100" ind1="1" ind2=" ">
+            <subfield code="a">Eco, Umberto</subfield>
+            <subfield code="4">aut</subfield>
+          </datafield>
+          <datafield tag="245" ind1="1" ind2="0">
+            <subfield code="a">&#152;Der&#156; Name der Rose :</subfield>
+            <subfield code="b">Roman /</subfield>
+            <subfield code="c">Umberto Eco</subfield>
+          </datafield>
+          <datafield tag="264" ind1=" " ind2="1">
+            <subfield code="a">München</subfield>
+            <subfield code="b">Hanser,</subfield>
+            <subfield code="c">[2018]</subfield>
+          </datafield>
+          <datafield tag="300" ind1=" " ind2=" ">
+            <subfield code="a">655 Seiten</subfield>
+          </datafield>
+          <datafield tag="700" ind1="1" ind2=" ">
+            <subfield code="a">Kroeber, Burkhart</subfield>
+            <subfield code="4">trl</subfield>
+          </datafield>
+        </record>
+      </recordData>
+    </record>
+  </records>
+</searchRetrieveResponse>`
+
+const sruUnimarcResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<srw:searchRetrieveResponse xmlns:srw="http://www.loc.gov/zing/srw/">
+  <srw:records>
+    <srw:record>
+      <srw:recordData>
+        <mxc:record xmlns:mxc="info:lc/xmlns/marcxchange-v2" format="Unimarc">
+          <mxc:datafield tag="010" ind1=" " ind2=" ">
+            <mxc:subfield code="a">2-07-036822-X</mxc:subfield>
+          </mxc:datafield>
+          <mxc:datafield tag="101" ind1="0" ind2=" ">
+            <mxc:subfield code="a">fre</mxc:subfield>
+          </mxc:datafield>
+          <mxc:datafield tag="200" ind1="1" ind2=" ">
+            <mxc:subfield code="a">L'étranger</mxc:subfield>
+          </mxc:datafield>
+          <mxc:datafield tag="210" ind1=" " ind2=" ">
+            <mxc:subfield code="c">Gallimard</mxc:subfield>
+            <mxc:subfield code="d">1972</mxc:subfield>
+          </mxc:datafield>
+          <mxc:datafield tag="700" ind1=" " ind2="|">
+            <mxc:subfield code="a">Camus</mxc:subfield>
+            <mxc:subfield code="b">Albert</mxc:subfield>
+            <mxc:subfield code="4">070</mxc:subfield>
+          </mxc:datafield>
+        </mxc:record>
+      </srw:recordData>
+    </srw:record>
+  </srw:records>
+</srw:searchRetrieveResponse>`
+
+func TestSru(t *testing.T) {
+	var queries []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query().Get("query"))
+		w.Header().Set("Content-Type", "application/xml")
+		switch r.URL.Query().Get("recordSchema") {
+		case "MARC21-xml":
+			w.Write([]byte(sruMarc21Response))
+		default:
+			w.Write([]byte(sruUnimarcResponse))
+		}
+	}))
+	defer server.Close()
+
+	conf := config.SruConfig{Name: "DNB", Url: server.URL, Version: "1.1", RecordSchema: "MARC21-xml", Format: "marc21", IsbnIndex: "num", TitleIndex: "tit", AuthorIndex: "per", Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider := providers.NewSru(&conf, server.Client())
+	results, err := provider.GetBookMetadata(context.Background(), &providers.SearchTerms{Isbn13s: []book.ISBN13{"9783446458491"}, Filepath: "/books/rose.epub"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`num="9783446458491"`}, queries)
+	assert.Len(t, results, 1)
+	assert.Equal(t, mo.Some("Der Name der Rose: Roman"), results[0].Title)
+	// the translator is left out
+	assert.Equal(t, mo.Some([]string{"Umberto Eco"}), results[0].Authors)
+	assert.Equal(t, mo.Some(book.ISBN13("9783446458491")), results[0].Isbn13)
+	assert.Equal(t, mo.Some("1027799527"), results[0].Oclc)
+	assert.Equal(t, mo.Some("Hanser"), results[0].Publisher)
+	assert.Equal(t, mo.Some("2018"), results[0].PublishDate)
+	assert.Equal(t, mo.Some(uint(655)), results[0].Pages)
+	assert.Equal(t, mo.Some("de"), results[0].Language)
+	assert.Equal(t, "dnb", results[0].SourceProviderName)
+
+	conf = config.SruConfig{Name: "BnF", Url: server.URL, Version: "1.2", RecordSchema: "unimarcXchange", Format: "unimarc", IsbnIndex: "bib.isbn", TitleIndex: "bib.title", AuthorIndex: "bib.author", Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider = providers.NewSru(&conf, server.Client())
+	results, err = provider.GetBookMetadata(context.Background(), &providers.SearchTerms{Title: `L'étranger "poche"`, Author: "Camus", Filepath: "/books/etranger.epub"})
+	assert.NoError(t, err)
+	assert.Equal(t, `bib.title="L'étranger \"poche\"" and bib.author="Camus"`, queries[1])
+	assert.Len(t, results, 1)
+	assert.Equal(t, mo.Some("L'étranger"), results[0].Title)
+	assert.Equal(t, mo.Some([]string{"Albert Camus"}), results[0].Authors)
+	assert.Equal(t, mo.Some(book.ISBN10("207036822X")), results[0].Isbn10)
+	assert.Equal(t, mo.Some("Gallimard"), results[0].Publisher)
+	assert.Equal(t, mo.Some("fr"), results[0].Language)
+	assert.Equal(t, float64(75), results[0].Confidence)
+}
+
+func TestSruHealthCheck(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	conf := config.SruConfig{Name: "DNB", Url: server.URL, Format: "marc21", Retry: config.RetryConfig{MaxAttempts: 1}}
+	provider := providers.NewSru(&conf, server.Client())
+	up, _ := provider.HealthCheck()
+	assert.True(t, up)
+
+	// a server that doesn't take HEAD requests still answers
+	status = http.StatusMethodNotAllowed
+	up, _ = provider.HealthCheck()
+	assert.True(t, up)
+
+	status = http.StatusServiceUnavailable
+	up, _ = provider.HealthCheck()
+	assert.False(t, up)
+
+	server.Close()
+	up, _ = provider.HealthCheck()
+	assert.False(t, up)
+}
@@ -0,0 +1,268 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type worldcatText struct {
+	Text string `json:"text"`
+}
+
+type worldcatCreator struct {
+	FirstName     worldcatText `json:"firstName"`
+	SecondName    worldcatText `json:"secondName"`
+	NonPersonName worldcatText `json:"nonPersonName"`
+}
+
+type worldcatRecord struct {
+	Identifier struct {
+		OclcNumber string   `json:"oclcNumber"`
+		Isbns      []string `json:"isbns"`
+	} `json:"identifier"`
+	Title struct {
+		MainTitles []worldcatText `json:"mainTitles"`
+	} `json:"title"`
+	Contributor struct {
+		Creators []worldcatCreator `json:"creators"`
+	} `json:"contributor"`
+	Date struct {
+		PublicationDate string `json:"publicationDate"`
+	} `json:"date"`
+	Publishers []struct {
+		PublisherName worldcatText `json:"publisherName"`
+	} `json:"publishers"`
+	Classification struct {
+		Dewey string `json:"dewey"`
+		Lc    string `json:"lc"`
+	} `json:"classification"`
+}
+
+type worldcatResponse struct {
+	NumberOfRecords int              `json:"numberOfRecords"`
+	BibRecords      []worldcatRecord `json:"bibRecords"`
+}
+
+type worldcatToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type Worldcat struct {
+	name         string
+	url          string
+	tokenUrl     string
+	clientId     string
+	clientSecret string
+	token        string
+	tokenExpiry  time.Time
+	tokenLock    sync.Mutex
+	client       *http.Client
+}
+
+func NewWorldcat(conf *config.WorldcatConfig, client *http.Client) Provider {
+	worldcat := Worldcat{
+		name:         instanceName(conf.Name, "WorldCat"),
+		url:          fmt.Sprintf("https://%s", conf.Url),
+		tokenUrl:     fmt.Sprintf("https://%s", conf.TokenUrl),
+		clientId:     conf.ClientId,
+		clientSecret: conf.ClientSecret,
+		client:       client,
+	}
+	return NewGeneric(&worldcat, conf.MillisecondsPerRequest, conf.Retry)
+}
+
+func (w *Worldcat) Name() string {
+	return w.name
+}
+
+// accessToken returns a cached OAuth token, requesting a new one with the WSKey client credentials when it expires
+func (w *Worldcat) accessToken(ctx context.Context) (string, error) {
+	w.tokenLock.Lock()
+	defer w.tokenLock.Unlock()
+
+	if len(w.token) > 0 && time.Now().Before(w.tokenExpiry) {
+		return w.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("scope", "wcapi")
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, w.tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.SetBasicAuth(w.clientId, w.clientSecret)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return "", fmt.Errorf("worldcat token request returned bad status code %d: %s", response.StatusCode, string(body))
+	}
+
+	var token worldcatToken
+	err = json.NewDecoder(response.Body).Decode(&token)
+	if err != nil {
+		return "", err
+	}
+
+	w.token = token.AccessToken
+	// refresh a little early so a request never goes out with a token that expires in flight
+	w.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return w.token, nil
+}
+
+func (w *Worldcat) search(ctx context.Context, q string, filePath string, confidence float64) (book.BookResult, error, int) {
+	token, err := w.accessToken(ctx)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+
+	queryUrl := fmt.Sprintf("%s/bibs?q=%s&limit=10", w.url, url.QueryEscape(q))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, queryUrl, nil)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	request.Header.Set("Accept", "application/json")
+
+	response, err := send(w.client, request)
+	if err != nil {
+		return book.BookResult{}, err, 0
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return book.BookResult{}, rateLimited(response, fmt.Errorf("worldcat returned bad status code %d: %s", response.StatusCode, string(body))), response.StatusCode
+	}
+
+	var result worldcatResponse
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return book.BookResult{}, err, response.StatusCode
+	}
+
+	if result.NumberOfRecords == 0 || len(result.BibRecords) == 0 {
+		return book.BookResult{}, nil, response.StatusCode
+	}
+
+	filenameTitle := util.FilenameTitle(filePath)
+	best := &result.BibRecords[0]
+	bestMatch := util.TitleSimilarity(worldcatTitle(best), filenameTitle)
+	for idx := range result.BibRecords {
+		similarity := util.TitleSimilarity(worldcatTitle(&result.BibRecords[idx]), filenameTitle)
+		if similarity > bestMatch {
+			bestMatch = similarity
+			best = &result.BibRecords[idx]
+		}
+	}
+
+	return w.toBookResult(best, filePath, confidence), nil, response.StatusCode
+}
+
+func (w *Worldcat) FindResult(ctx context.Context, isbn book.ISBN, filePath string) (book.BookResult, error, int) {
+	return w.search(ctx, fmt.Sprintf("bn:%s", isbn), filePath, 100)
+}
+
+func (w *Worldcat) FindResultByQuery(ctx context.Context, title string, author string, filePath string) (book.BookResult, error, int) {
+	q := fmt.Sprintf("ti:\"%s\"", title)
+	if len(author) > 0 {
+		q = fmt.Sprintf("%s AND au:\"%s\"", q, author)
+	}
+	return w.search(ctx, q, filePath, 75)
+}
+
+func worldcatTitle(record *worldcatRecord) string {
+	if len(record.Title.MainTitles) == 0 {
+		return ""
+	}
+	return record.Title.MainTitles[0].Text
+}
+
+func (w *Worldcat) toBookResult(record *worldcatRecord, filePath string, confidence float64) book.BookResult {
+	result := book.BookResult{
+		Filepath:           filePath,
+		Confidence:         confidence,
+		SourceProviderName: strings.ToLower(w.name),
+	}
+
+	if title := worldcatTitle(record); len(title) > 0 {
+		result.Title = mo.Some(title)
+	}
+
+	authors := make([]string, 0)
+	for _, creator := range record.Contributor.Creators {
+		name := strings.TrimSpace(fmt.Sprintf("%s %s", creator.FirstName.Text, creator.SecondName.Text))
+		if len(name) == 0 {
+			name = strings.TrimSpace(creator.NonPersonName.Text)
+		}
+		if len(name) > 0 {
+			authors = append(authors, name)
+		}
+	}
+	if len(authors) > 0 {
+		result.Authors = mo.Some(authors)
+	}
+
+	if len(record.Identifier.OclcNumber) > 0 {
+		result.Oclc = mo.Some(record.Identifier.OclcNumber)
+	}
+
+	for _, isbn := range record.Identifier.Isbns {
+		switch len(isbn) {
+		case 10:
+			if result.Isbn10.IsAbsent() {
+				result.Isbn10 = mo.Some(book.ISBN10(isbn))
+			}
+		case 13:
+			if result.Isbn13.IsAbsent() {
+				result.Isbn13 = mo.Some(book.ISBN13(isbn))
+			}
+		}
+	}
+
+	if len(record.Date.PublicationDate) > 0 {
+		result.PublishDate = mo.Some(record.Date.PublicationDate)
+	}
+
+	if len(record.Publishers) > 0 && len(record.Publishers[0].PublisherName.Text) > 0 {
+		result.Publisher = mo.Some(record.Publishers[0].PublisherName.Text)
+	}
+
+	if lcc := strings.Join(strings.Fields(record.Classification.Lc), " "); len(lcc) > 0 {
+		result.Lcc = mo.Some(lcc)
+	}
+
+	if ddc := util.NormalizeDdc(record.Classification.Dewey); len(ddc) > 0 {
+		result.Ddc = mo.Some(ddc)
+	}
+
+	return result
+}
+
+func (w *Worldcat) Shutdown() {
+}
+
+func (w *Worldcat) HealthCheck() (bool, string) {
+	return probe(w.client, w.url)
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// epochThreshold tells a reset header holding a Unix timestamp from one holding a number of seconds, since no rate limit
+// window lasts anywhere near as long as 31 years
+const epochThreshold = 1_000_000_000
+
+// Limiter paces requests to an API. It never sends them faster than its interval, and slows down when the API's
+// response headers say that it is running out of requests or asks to be left alone for a while.
+type Limiter struct {
+	lock     sync.Mutex
+	interval time.Duration
+	pace     time.Duration
+	next     time.Time
+}
+
+func New(interval time.Duration) *Limiter {
+	return &Limiter{
+		lock:     sync.Mutex{},
+		interval: interval,
+		pace:     interval,
+	}
+}
+
+// Wait blocks until the next request may be sent, or the context is done
+func (l *Limiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+	slot := time.Now()
+	if l.next.After(slot) {
+		slot = l.next
+	}
+	l.next = slot.Add(l.pace)
+	l.lock.Unlock()
+
+	timer := time.NewTimer(time.Until(slot))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pace is how long the limiter currently waits between requests
+func (l *Limiter) Pace() time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.pace
+}
+
+// Observe adjusts the pace to the rate limit headers of a response. The remaining requests are spread over what is
+// left of the window (X-RateLimit-Remaining and X-RateLimit-Reset, or their standard RateLimit- forms), a limit of so
+// many requests per interval is kept to (X-Rate-Limit-Limit and X-Rate-Limit-Interval, as Crossref sends), and nothing
+// is sent before a Retry-After.
+func (l *Limiter) Observe(header http.Header) {
+	now := time.Now()
+	pace := l.interval
+	var resume time.Time
+
+	remaining, hasRemaining := headerInt(header, "X-RateLimit-Remaining", "RateLimit-Remaining")
+	reset, hasReset := resetAfter(header, now)
+	if hasRemaining && hasReset {
+		if remaining <= 0 {
+			resume = now.Add(reset)
+		} else {
+			pace = max(pace, reset/time.Duration(remaining))
+		}
+	}
+
+	if limit, ok := headerInt(header, "X-Rate-Limit-Limit"); ok && limit > 0 {
+		if window, err := time.ParseDuration(header.Get("X-Rate-Limit-Interval")); err == nil {
+			pace = max(pace, window/time.Duration(limit))
+		}
+	}
+
+	if retryAfter, ok := RetryAfter(header, now); ok {
+		resume = now.Add(retryAfter)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.pace = pace
+	if resume.After(l.next) {
+		l.next = resume
+	}
+}
+
+func headerInt(header http.Header, names ...string) (int64, bool) {
+	for _, name := range names {
+		if value, err := strconv.ParseInt(header.Get(name), 10, 64); err == nil {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+// resetAfter is how long until the rate limit window resets, given either as a number of seconds or a Unix timestamp
+func resetAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	reset, ok := headerInt(header, "X-RateLimit-Reset", "RateLimit-Reset")
+	if !ok || reset < 0 {
+		return 0, false
+	}
+	if reset > epochThreshold {
+		return max(0, time.Unix(reset, 0).Sub(now)), true
+	}
+	return time.Duration(reset) * time.Second, true
+}
+
+// RetryAfter reads a Retry-After header, which is either a number of seconds or a date
+func RetryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now)), true
+	}
+	return 0, false
+}
+
+type limiterKey struct{}
+
+// WithLimiter makes the limiter the one that the responses to requests made with the context are observed by
+func WithLimiter(ctx context.Context, limiter *Limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, limiter)
+}
+
+// FromContext is the limiter given to WithLimiter, if any
+func FromContext(ctx context.Context) (*Limiter, bool) {
+	limiter, ok := ctx.Value(limiterKey{}).(*Limiter)
+	return limiter, ok
+}
@@ -0,0 +1,60 @@
+package ratelimit_test
+
+import (
+	"context"
+	"github.com/larkwiot/booker/internal/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLimiterObserve(t *testing.T) {
+	limiter := ratelimit.New(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, limiter.Pace())
+
+	// 10 requests left for the next 60 seconds
+	limiter.Observe(http.Header{"X-Ratelimit-Remaining": {"10"}, "X-Ratelimit-Reset": {"60"}})
+	assert.Equal(t, 6*time.Second, limiter.Pace())
+
+	// the window resetting at a Unix timestamp
+	reset := time.Now().Add(50 * time.Second).Unix()
+	limiter.Observe(http.Header{"Ratelimit-Remaining": {"100"}, "Ratelimit-Reset": {strconv.FormatInt(reset, 10)}})
+	assert.InDelta(t, 500*time.Millisecond, limiter.Pace(), float64(20*time.Millisecond))
+
+	// plenty left never makes it faster than its interval
+	limiter.Observe(http.Header{"X-Ratelimit-Remaining": {"5000"}, "X-Ratelimit-Reset": {"1"}})
+	assert.Equal(t, 100*time.Millisecond, limiter.Pace())
+
+	limiter.Observe(http.Header{"X-Rate-Limit-Limit": {"5"}, "X-Rate-Limit-Interval": {"1s"}})
+	assert.Equal(t, 200*time.Millisecond, limiter.Pace())
+
+	limiter.Observe(http.Header{})
+	assert.Equal(t, 100*time.Millisecond, limiter.Pace())
+}
+
+func TestLimiterWait(t *testing.T) {
+	limiter := ratelimit.New(time.Millisecond)
+	assert.NoError(t, limiter.Wait(context.Background()))
+
+	limiter.Observe(http.Header{"Retry-After": {"60"}})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, limiter.Wait(ctx), context.DeadlineExceeded)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	wait, ok := ratelimit.RetryAfter(http.Header{"Retry-After": {"120"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, wait)
+
+	wait, ok = ratelimit.RetryAfter(http.Header{"Retry-After": {"Fri, 01 Mar 2024 12:05:00 GMT"}}, now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Minute, wait)
+
+	_, ok = ratelimit.RetryAfter(http.Header{}, now)
+	assert.False(t, ok)
+}
@@ -0,0 +1,339 @@
+package rename
+
+import (
+	"errors"
+	"fmt"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/webdav"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode"
+)
+
+const DefaultTemplate = "{author}/{title} ({year}).{ext}"
+
+const (
+	CollisionNumber = "number"
+	CollisionSkip   = "skip"
+)
+
+// templateFields are the placeholders a template may use
+var templateFields = map[string]func(bk *book.Book) string{
+	"title": func(bk *book.Book) string {
+		return bk.Title
+	},
+	"author": func(bk *book.Book) string {
+		if len(bk.Authors) == 0 {
+			return ""
+		}
+		return bk.Authors[0]
+	},
+	"authors": func(bk *book.Book) string {
+		return strings.Join(bk.Authors, ", ")
+	},
+	"year": bookYear,
+	"ext": func(bk *book.Book) string {
+		return strings.TrimPrefix(filepath.Ext(bk.Filepath), ".")
+	},
+	"isbn": func(bk *book.Book) string {
+		if len(bk.Isbn13) > 0 {
+			return string(bk.Isbn13)
+		}
+		return string(bk.Isbn10)
+	},
+	"isbn10": func(bk *book.Book) string {
+		return string(bk.Isbn10)
+	},
+	"isbn13": func(bk *book.Book) string {
+		return string(bk.Isbn13)
+	},
+	"publisher": func(bk *book.Book) string {
+		return bk.Publisher
+	},
+	"language": func(bk *book.Book) string {
+		return bk.Language
+	},
+	"series": func(bk *book.Book) string {
+		return bk.Series
+	},
+	"series_index": seriesIndex,
+	"lcc": func(bk *book.Book) string {
+		return bk.Lcc
+	},
+	"ddc": func(bk *book.Book) string {
+		return bk.Ddc
+	},
+}
+
+var yearPattern = regexp.MustCompile(`\b(1[5-9]|20)[0-9]{2}\b`)
+var emptyGroupPattern = regexp.MustCompile(`\(\s*\)|\[\s*]|\{\s*}`)
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+func bookYear(bk *book.Book) string {
+	if year := yearPattern.FindString(bk.PublishDate); len(year) > 0 {
+		return year
+	}
+	if bk.LowYear > 0 {
+		return strconv.FormatUint(uint64(bk.LowYear), 10)
+	}
+	return ""
+}
+
+// seriesIndex pads the number of a book in its series to two digits, so directory listings keep the series in order
+func seriesIndex(bk *book.Book) string {
+	if len(bk.Series) == 0 {
+		return ""
+	}
+	index := strconv.FormatFloat(bk.SeriesIndex, 'f', -1, 64)
+	if bk.SeriesIndex < 10 {
+		index = "0" + index
+	}
+	return index
+}
+
+type templatePart struct {
+	literal string
+	field   func(bk *book.Book) string
+}
+
+type Template struct {
+	parts []templatePart
+}
+
+// NewTemplate parses a template such as DefaultTemplate, where "/" separates directories and fields are in braces
+func NewTemplate(template string) (*Template, error) {
+	t := &Template{}
+
+	// the template is always relative to the destination
+	rest := strings.Trim(filepath.ToSlash(template), "/")
+	for len(rest) > 0 {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("error: template %s has an unclosed {", template)
+		}
+		name := rest[start+1 : start+end]
+		field, exists := templateFields[name]
+		if !exists {
+			return nil, fmt.Errorf("error: template %s uses unknown field {%s}", template, name)
+		}
+		t.parts = append(t.parts, templatePart{field: field})
+
+		rest = rest[start+end+1:]
+	}
+
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("error: template is empty")
+	}
+
+	return t, nil
+}
+
+// Expand returns the relative path bk should be stored at. Missing fields are left out, and directories or filenames
+// that end up empty are named "Unknown"
+func (t *Template) Expand(bk *book.Book) (string, error) {
+	if len(bk.Title) == 0 {
+		return "", fmt.Errorf("no title")
+	}
+
+	var expanded strings.Builder
+	for _, part := range t.parts {
+		if part.field == nil {
+			expanded.WriteString(part.literal)
+			continue
+		}
+		expanded.WriteString(sanitizeValue(part.field(bk)))
+	}
+
+	components := strings.Split(filepath.ToSlash(expanded.String()), "/")
+	for idx, component := range components {
+		components[idx] = cleanComponent(component)
+	}
+
+	return filepath.Join(components...), nil
+}
+
+// sanitizeValue keeps a field from adding directories or control characters to the path
+func sanitizeValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' {
+			return '-'
+		}
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(value, " "))
+}
+
+// cleanComponent tidies up what missing fields leave behind, like "Title ().pdf"
+func cleanComponent(component string) string {
+	component = emptyGroupPattern.ReplaceAllString(component, "")
+	component = whitespacePattern.ReplaceAllString(component, " ")
+	component = strings.ReplaceAll(component, " .", ".")
+	component = strings.TrimRight(strings.TrimLeft(component, " "), " .")
+	if len(component) == 0 || strings.HasPrefix(component, ".") {
+		return "Unknown" + component
+	}
+	return component
+}
+
+type Move struct {
+	From string
+	To   string
+}
+
+type Skipped struct {
+	Filepath string
+	Reason   string
+}
+
+// Plan works out where every book goes under destination. A book whose target is taken, either by an existing file
+// or by another book, gets a numbered target like "Title (2).pdf" or is skipped, depending on onCollision
+func Plan(books []book.Book, template *Template, destination string, onCollision string) ([]Move, []Skipped) {
+	moves := make([]Move, 0)
+	skipped := make([]Skipped, 0)
+	claimed := make(map[string]struct{})
+
+	// sorted so that the same output always produces the same plan
+	books = slices.Clone(books)
+	slices.SortFunc(books, func(a book.Book, b book.Book) int {
+		return strings.Compare(a.Filepath, b.Filepath)
+	})
+
+	isTaken := func(target string) bool {
+		if _, isClaimed := claimed[target]; isClaimed {
+			return true
+		}
+		_, err := os.Lstat(target)
+		return err == nil
+	}
+
+	for _, bk := range books {
+		if len(bk.ErrorMessage) > 0 {
+			skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: "no metadata"})
+			continue
+		}
+
+		if archive.IsMember(bk.Filepath) {
+			skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: "inside an archive"})
+			continue
+		}
+		if webdav.IsUrl(bk.Filepath) {
+			skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: "on a WebDAV server"})
+			continue
+		}
+
+		if _, err := os.Lstat(bk.Filepath); err != nil {
+			skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: "file no longer exists"})
+			continue
+		}
+
+		relative, err := template.Expand(&bk)
+		if err != nil {
+			skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: err.Error()})
+			continue
+		}
+		target := filepath.Join(destination, relative)
+
+		if target == bk.Filepath {
+			claimed[target] = struct{}{}
+			skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: "already in place"})
+			continue
+		}
+
+		if isTaken(target) {
+			if onCollision == CollisionSkip {
+				skipped = append(skipped, Skipped{Filepath: bk.Filepath, Reason: fmt.Sprintf("%s is taken", target)})
+				continue
+			}
+
+			ext := filepath.Ext(target)
+			base := strings.TrimSuffix(target, ext)
+			for n := 2; isTaken(target); n++ {
+				target = fmt.Sprintf("%s (%d)%s", base, n, ext)
+			}
+		}
+
+		claimed[target] = struct{}{}
+		moves = append(moves, Move{From: bk.Filepath, To: target})
+	}
+
+	return moves, skipped
+}
+
+// Apply moves the file, or hard-links it if link is set, creating any missing directories. It never overwrites
+func (m *Move) Apply(link bool) error {
+	err := os.MkdirAll(filepath.Dir(m.To), 0755)
+	if err != nil {
+		return err
+	}
+
+	if link {
+		return os.Link(m.From, m.To)
+	}
+
+	// os.Rename silently replaces an existing file, so make sure nothing appeared since planning
+	if _, err = os.Lstat(m.To); err == nil {
+		return fmt.Errorf("%s already exists", m.To)
+	}
+
+	err = os.Rename(m.From, m.To)
+	if errors.Is(err, syscall.EXDEV) {
+		// renaming doesn't work across filesystems, so copy instead
+		err = copyFile(m.From, m.To)
+		if err != nil {
+			return err
+		}
+		return os.Remove(m.From)
+	}
+	return err
+}
+
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	info, err := source.Stat()
+	if err != nil {
+		return err
+	}
+
+	destination, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(destination, source)
+	if err == nil {
+		err = destination.Sync()
+	}
+	closeErr := destination.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(to)
+		return err
+	}
+	return nil
+}
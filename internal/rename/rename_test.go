@@ -0,0 +1,83 @@
+package rename_test
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/rename"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	template, err := rename.NewTemplate(rename.DefaultTemplate)
+	assert.NoError(t, err)
+
+	bk := book.Book{
+		Title:       "How to Hack Like a Ghost",
+		Authors:     []string{"Sparc Flow"},
+		PublishDate: "2021-05-04",
+		Filepath:    "/books/ghost.PDF",
+	}
+	path, err := template.Expand(&bk)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("Sparc Flow", "How to Hack Like a Ghost (2021).PDF"), path)
+
+	bk = book.Book{
+		Title:    "Cloud/Security: Notes",
+		Filepath: "/books/notes.epub",
+	}
+	path, err = template.Expand(&bk)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("Unknown", "Cloud-Security: Notes.epub"), path)
+
+	_, err = template.Expand(&book.Book{Filepath: "/books/untitled.pdf"})
+	assert.Error(t, err)
+
+	series, err := rename.NewTemplate("{author}/{series}/{series_index} - {title}.{ext}")
+	assert.NoError(t, err)
+	path, err = series.Expand(&book.Book{Title: "Pyramids", Authors: []string{"Terry Pratchett"}, Series: "Discworld", SeriesIndex: 7, Filepath: "/books/pyramids.epub"})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("Terry Pratchett", "Discworld", "07 - Pyramids.epub"), path)
+
+	_, err = rename.NewTemplate("{author}/{name}")
+	assert.Error(t, err)
+
+	_, err = rename.NewTemplate("{author/{title}")
+	assert.Error(t, err)
+}
+
+func TestPlan(t *testing.T) {
+	source := t.TempDir()
+	destination := t.TempDir()
+
+	books := make([]book.Book, 0)
+	for _, name := range []string{"a.pdf", "b.pdf", "c.pdf"} {
+		path := filepath.Join(source, name)
+		assert.NoError(t, os.WriteFile(path, []byte(name), 0644))
+		books = append(books, book.Book{Title: "Same Title", Authors: []string{"Author"}, Filepath: path})
+	}
+	books = append(books, book.Book{Filepath: filepath.Join(source, "failed.pdf"), ErrorMessage: "error: no results found"})
+
+	template, err := rename.NewTemplate("{author}/{title}.{ext}")
+	assert.NoError(t, err)
+
+	moves, skipped := rename.Plan(books, template, destination, rename.CollisionNumber)
+	assert.Equal(t, []rename.Move{
+		{From: filepath.Join(source, "a.pdf"), To: filepath.Join(destination, "Author", "Same Title.pdf")},
+		{From: filepath.Join(source, "b.pdf"), To: filepath.Join(destination, "Author", "Same Title (2).pdf")},
+		{From: filepath.Join(source, "c.pdf"), To: filepath.Join(destination, "Author", "Same Title (3).pdf")},
+	}, moves)
+	assert.Equal(t, 1, len(skipped))
+
+	moves, _ = rename.Plan(books, template, destination, rename.CollisionSkip)
+	assert.Equal(t, 1, len(moves))
+
+	for _, move := range moves {
+		assert.NoError(t, move.Apply(false))
+	}
+	_, err = os.Stat(filepath.Join(destination, "Author", "Same Title.pdf"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(source, "a.pdf"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/review"
+	"github.com/larkwiot/booker/internal/util"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// snippetLength is about how many characters of a book's text are kept for reviewing it
+const snippetLength = 300
+
+var snippetWhitespace = regexp.MustCompile(`\s+`)
+
+// snippet returns some of the extracted text to show when reviewing the book, around the first "ISBN" since that is
+// usually the copyright page
+func snippet(texts []string) string {
+	for _, text := range texts {
+		text = strings.TrimSpace(snippetWhitespace.ReplaceAllString(text, " "))
+		if len(text) == 0 {
+			continue
+		}
+		start := max(0, strings.Index(text, "ISBN")-snippetLength/2)
+		end := min(len(text), start+snippetLength)
+		return strings.ToValidUTF8(text[start:end], "")
+	}
+	return ""
+}
+
+// SetReview makes books whose best result has a confidence under minConfidence fail with a "needs review" error,
+// writing them to writer along with all of their candidate results
+func (bm *BookManager) SetReview(minConfidence float64, writer util.ObjectWriter[*review.Entry]) {
+	bm.minConfidence = minConfidence
+	bm.reviewWriter = writer
+}
+
+// needsReview writes bk to the review output if its confidence is too low, returning the error to record for it
+func (bm *BookManager) needsReview(bk book.Book, results []book.BookResult, snippet string) error {
+	if bm.reviewWriter == nil || bk.Confidence >= bm.minConfidence {
+		return nil
+	}
+
+	entry := review.Entry{Book: bk, Snippet: snippet, Candidates: make([]review.Candidate, 0, len(results))}
+	for _, result := range results {
+		entry.Candidates = append(entry.Candidates, review.Candidate{Book: result.ToBook(), Source: result.SourceProviderName})
+	}
+	slices.SortStableFunc(entry.Candidates, func(a, b review.Candidate) int {
+		return cmp.Compare(b.Confidence, a.Confidence)
+	})
+	bm.reviewWriter.WriteObject(&entry)
+
+	return book.NewError(book.ErrorNeedsReview, fmt.Errorf("needs review: confidence %.0f is under the minimum of %.0f", bk.Confidence, bm.minConfidence))
+}
@@ -0,0 +1,217 @@
+package review
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"io"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// Candidate is one of the results found for a book that needs review
+type Candidate struct {
+	book.Book
+	Source string `json:"source"`
+}
+
+// Entry is a book whose best result scored under the minimum confidence, with every result found for it so the right
+// one can be picked by hand
+type Entry struct {
+	book.Book
+	// Snippet is some of the text extracted from the file, around its first ISBN if it has one
+	Snippet    string      `json:"snippet,omitempty"`
+	Candidates []Candidate `json:"candidates"`
+}
+
+type Decision int
+
+const (
+	// Skip leaves the entry to be reviewed another time
+	Skip Decision = iota
+	// Accept replaces the book in the output with the chosen or edited record
+	Accept
+	// Quit stops reviewing, leaving this and the remaining entries for another time
+	Quit
+)
+
+// Reviewer asks which candidate of each entry is right, or for the right record outright
+type Reviewer struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func NewReviewer(in io.Reader, out io.Writer) *Reviewer {
+	return &Reviewer{in: bufio.NewScanner(in), out: out}
+}
+
+// prompt asks for a line of input, returning false once there is no more
+func (r *Reviewer) prompt(format string, a ...any) (string, bool) {
+	fmt.Fprintf(r.out, format, a...)
+	if !r.in.Scan() {
+		return "", false
+	}
+	return strings.TrimSpace(r.in.Text()), true
+}
+
+func describe(bk *book.Book) string {
+	parts := []string{fmt.Sprintf("%q", bk.Title)}
+	if len(bk.Authors) > 0 {
+		parts = append(parts, "by "+strings.Join(bk.Authors, ", "))
+	}
+	if len(bk.PublishDate) > 0 {
+		parts = append(parts, "("+bk.PublishDate+")")
+	}
+	if len(bk.Publisher) > 0 {
+		parts = append(parts, bk.Publisher)
+	}
+	if identifier := bk.BestIdentifier(); identifier != bk.Title && identifier != bk.Filepath {
+		parts = append(parts, "["+identifier+"]")
+	}
+	return strings.Join(parts, " ")
+}
+
+// Review shows the entry and asks what to do with it, position and total only say how far along the review is
+func (r *Reviewer) Review(entry *Entry, position int, total int) (book.Book, Decision) {
+	fmt.Fprintf(r.out, "\n[%d/%d] %s\n", position, total, entry.Filepath)
+	if len(entry.ErrorMessage) > 0 {
+		fmt.Fprintf(r.out, "  %s\n", entry.ErrorMessage)
+	}
+	if len(entry.Snippet) > 0 {
+		fmt.Fprintf(r.out, "  text: %s\n", entry.Snippet)
+	}
+	fmt.Fprintf(r.out, "  candidates:\n")
+	for idx := range entry.Candidates {
+		candidate := &entry.Candidates[idx]
+		fmt.Fprintf(r.out, "  %2d) %s %.0f: %s\n", idx+1, candidate.Source, candidate.Confidence, describe(&candidate.Book))
+	}
+
+	for {
+		answer, ok := r.prompt("pick [1-%d], (e)dit, (s)kip or (q)uit: ", len(entry.Candidates))
+		if !ok {
+			return book.Book{}, Quit
+		}
+
+		switch strings.ToLower(answer) {
+		case "s", "skip":
+			return book.Book{}, Skip
+		case "q", "quit":
+			return book.Book{}, Quit
+		case "e", "edit":
+			bk, ok := r.edit(entry.Book)
+			if !ok {
+				return book.Book{}, Quit
+			}
+			return r.reviewed(entry, bk), Accept
+		}
+
+		choice, err := strconv.Atoi(answer)
+		if err != nil || choice < 1 || choice > len(entry.Candidates) {
+			fmt.Fprintf(r.out, "  %s is not one of the choices\n", answer)
+			continue
+		}
+
+		chosen := entry.Candidates[choice-1].Book
+		answer, ok = r.prompt("  edit it before accepting? [y/N]: ")
+		if ok && strings.HasPrefix(strings.ToLower(answer), "y") {
+			chosen, ok = r.edit(chosen)
+		}
+		if !ok {
+			return book.Book{}, Quit
+		}
+		return r.reviewed(entry, chosen), Accept
+	}
+}
+
+// edit asks for each field of bk in turn, keeping the current value when nothing is entered. Fields that are changed
+// get "review" as their provenance
+func (r *Reviewer) edit(bk book.Book) (book.Book, bool) {
+	bk.Provenance = maps.Clone(bk.Provenance)
+	if bk.Provenance == nil {
+		bk.Provenance = make(map[string]string)
+	}
+
+	fields := []struct {
+		name   string
+		output string
+		value  *string
+	}{
+		{"title", "title", &bk.Title},
+		{"publisher", "publisher", &bk.Publisher},
+		{"publish date", "publish_date", &bk.PublishDate},
+	}
+
+	for _, field := range fields {
+		answer, ok := r.prompt("  %s [%s]: ", field.name, *field.value)
+		if !ok {
+			return bk, false
+		}
+		if len(answer) > 0 {
+			*field.value = answer
+			bk.Provenance[field.output] = "review"
+		}
+	}
+
+	answer, ok := r.prompt("  authors, separated by ; [%s]: ", strings.Join(bk.Authors, "; "))
+	if !ok {
+		return bk, false
+	}
+	if len(answer) > 0 {
+		bk.Authors = make([]string, 0)
+		for _, author := range strings.Split(answer, ";") {
+			if author = strings.TrimSpace(author); len(author) > 0 {
+				bk.Authors = append(bk.Authors, author)
+			}
+		}
+		bk.Provenance["authors"] = "review"
+	}
+
+	for {
+		current := string(bk.Isbn13)
+		if len(current) == 0 {
+			current = string(bk.Isbn10)
+		}
+		answer, ok = r.prompt("  isbn [%s]: ", current)
+		if !ok {
+			return bk, false
+		}
+		answer = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(answer))
+		if len(answer) == 0 {
+			break
+		}
+		isbn10, isbn13 := book.ISBN10(answer), book.ISBN13(answer)
+		// the other ISBN belonged to the record being corrected, so it is dropped rather than left to contradict
+		if len(answer) == 10 && isbn10.IsValid() {
+			bk.Isbn10, bk.Isbn13 = isbn10, ""
+			bk.Provenance["isbn10"] = "review"
+			delete(bk.Provenance, "isbn13")
+			break
+		}
+		if len(answer) == 13 && isbn13.IsValid() {
+			bk.Isbn13, bk.Isbn10 = isbn13, ""
+			bk.Provenance["isbn13"] = "review"
+			delete(bk.Provenance, "isbn10")
+			if converted, ok := isbn13.Isbn10(); ok {
+				bk.Isbn10 = converted
+				bk.Provenance["isbn10"] = "review"
+			}
+			break
+		}
+		fmt.Fprintf(r.out, "  %s is not a valid ISBN\n", answer)
+	}
+
+	return bk, true
+}
+
+// reviewed makes bk the entry's record, marked as confirmed by hand
+func (r *Reviewer) reviewed(entry *Entry, bk book.Book) book.Book {
+	bk.Filepath = entry.Filepath
+	bk.Hash = entry.Hash
+	bk.Size = entry.Size
+	bk.ModTime = entry.ModTime
+	bk.DuplicateOf = entry.DuplicateOf
+	bk.ClearError()
+	bk.Confidence = 100
+	return bk
+}
@@ -0,0 +1,38 @@
+package review_test
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/review"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReview(t *testing.T) {
+	entry := review.Entry{
+		Book: book.Book{Title: "Ghost", Filepath: "/books/ghost.pdf", Hash: "abc", ErrorMessage: "needs review", Confidence: 40},
+		Candidates: []review.Candidate{
+			{Book: book.Book{Title: "Gardening for Beginners", Confidence: 40}, Source: "google"},
+			{Book: book.Book{Title: "How to Hack Like a Ghost", Isbn13: "9781718501263", Confidence: 35}, Source: "isbndb"},
+		},
+	}
+
+	bk, decision := review.NewReviewer(strings.NewReader("3\n2\n\n"), io.Discard).Review(&entry, 1, 1)
+	assert.Equal(t, review.Accept, decision)
+	assert.Equal(t, book.Book{Title: "How to Hack Like a Ghost", Isbn13: "9781718501263", Filepath: "/books/ghost.pdf", Hash: "abc", Confidence: 100}, bk)
+
+	bk, decision = review.NewReviewer(strings.NewReader("e\nHow to Hack Like a Ghost\n\n2021\nSparc Flow\n1718501269\n"), io.Discard).Review(&entry, 1, 1)
+	assert.Equal(t, review.Accept, decision)
+	assert.Equal(t, []string{"Sparc Flow"}, bk.Authors)
+	assert.Equal(t, book.ISBN10("1718501269"), bk.Isbn10)
+	assert.Equal(t, "2021", bk.PublishDate)
+	assert.Equal(t, map[string]string{"title": "review", "publish_date": "review", "authors": "review", "isbn10": "review"}, bk.Provenance)
+
+	_, decision = review.NewReviewer(strings.NewReader("s\n"), io.Discard).Review(&entry, 1, 1)
+	assert.Equal(t, review.Skip, decision)
+
+	// running out of input is the same as quitting
+	_, decision = review.NewReviewer(strings.NewReader("1\n"), io.Discard).Review(&entry, 1, 1)
+	assert.Equal(t, review.Quit, decision)
+}
@@ -0,0 +1,216 @@
+package scoring
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/samber/mo"
+	"math"
+	"strings"
+)
+
+// the most each signal adds to a score out of 100
+const (
+	matchPoints        = 40
+	identifierPoints   = 15
+	similarityPoints   = 20
+	agreementPoints    = 15
+	completenessPoints = 10
+)
+
+// languageMismatchFactor scales the score of a result in another language than the book, which is most likely a
+// translation of it
+const languageMismatchFactor = 0.8
+
+// editionMismatchFactor scales the score of a result for each way it doesn't fit the edition in the file
+const editionMismatchFactor = 0.85
+
+// pageTolerance is how far from the file's page count a result's can be and still be the same edition, as a share of
+// the file's, since covers and front matter are counted by some and not others
+const pageTolerance = 0.1
+
+// fullAgreement is how many other sources have to agree with a result for it to get all of agreementPoints
+const fullAgreement = 3
+
+// identifierScore is 1 for a valid ISBN, or other identifier when there is no ISBN, and 0 for an invalid ISBN or none
+func identifierScore(br *book.BookResult) float64 {
+	isbn13, has13 := br.Isbn13.Get()
+	isbn10, has10 := br.Isbn10.Get()
+	if has13 || has10 {
+		if (has13 && !isbn13.IsValid()) || (has10 && !isbn10.IsValid()) {
+			return 0
+		}
+		// an ISBN-10 that isn't the ISBN-13's is a sign the record mixes up editions
+		if converted, ok := isbn13.Isbn10(); has13 && has10 && ok && converted != isbn10 {
+			return 0.5
+		}
+		return 1
+	}
+	if br.Doi.IsPresent() || br.Asin.IsPresent() || br.Arxiv.IsPresent() || br.Oclc.IsPresent() {
+		return 1
+	}
+	return 0
+}
+
+// completenessScore is the share of the fields readers care most about that a result has filled in
+func completenessScore(br *book.BookResult) float64 {
+	present := []bool{
+		br.Title.IsPresent(),
+		br.Authors.IsPresent(),
+		br.Isbn13.IsPresent() || br.Isbn10.IsPresent() || br.Doi.IsPresent() || br.Arxiv.IsPresent(),
+		br.Publisher.IsPresent(),
+		br.PublishDate.IsPresent() || br.LowYear.IsPresent(),
+		br.Pages.IsPresent(),
+	}
+	count := 0
+	for _, p := range present {
+		if p {
+			count++
+		}
+	}
+	return float64(count) / float64(len(present))
+}
+
+// similarityScore compares the result's title to the file name and the titles embedded in the file, whichever is
+// closest. It is neutral when there was nothing to compare it to.
+func similarityScore(br *book.BookResult, filePath string, embedded []book.BookResult) float64 {
+	title, ok := br.Title.Get()
+	if !ok {
+		return 0
+	}
+
+	references := make([]string, 0)
+	if terms := util.ParseFilename(filePath); len(terms.Title) > 0 {
+		references = append(references, terms.Title)
+	}
+	for _, result := range embedded {
+		// a file's own metadata always matches itself
+		if embeddedTitle, ok := result.Title.Get(); ok && result.SourceProviderName != br.SourceProviderName {
+			references = append(references, embeddedTitle)
+		}
+	}
+	if len(references) == 0 {
+		return 0.5
+	}
+
+	best := 0.0
+	for _, reference := range references {
+		best = max(best, util.TitleSimilarity(title, reference))
+	}
+	return best
+}
+
+// agreementScore counts how many other sources found the same book
+func agreementScore(idx int, results []book.BookResult) float64 {
+	sources := make(map[string]struct{})
+	for other := range results {
+		if other == idx || results[other].SourceProviderName == results[idx].SourceProviderName {
+			continue
+		}
+		if results[idx].Agrees(&results[other]) {
+			sources[results[other].SourceProviderName] = struct{}{}
+		}
+	}
+	return float64(min(len(sources), fullAgreement)) / fullAgreement
+}
+
+// Score replaces the confidence each source gave its results, which only says how the result was found, with a score
+// out of 100 that also weighs how well the result fits the file: its title against the file name and embedded
+// metadata, whether its ISBNs are valid, how many other sources agree with it, and how complete it is. embedded are the
+// results read out of the file itself, which are also among results.
+func Score(results []book.BookResult, filePath string, embedded []book.BookResult) {
+	scores := make([]float64, len(results))
+	for idx := range results {
+		br := &results[idx]
+		scores[idx] = matchPoints*min(br.Confidence, 100)/100 +
+			identifierPoints*identifierScore(br) +
+			similarityPoints*similarityScore(br, filePath, embedded) +
+			agreementPoints*agreementScore(idx, results) +
+			completenessPoints*completenessScore(br)
+	}
+	// agreement compares results to each other, so none of them can change until all are scored
+	for idx := range results {
+		results[idx].Confidence = scores[idx]
+	}
+}
+
+// PreferLanguage lowers the score of the results known to be in another language than the book, language being its
+// ISO 639-1 code. Results that don't say what language they are in, and all of them when the book's language is
+// unknown, are left as they are.
+func PreferLanguage(results []book.BookResult, language string) {
+	if len(language) == 0 {
+		return
+	}
+	for idx := range results {
+		if resultLanguage, ok := results[idx].Language.Get(); ok && resultLanguage != language {
+			results[idx].Confidence *= languageMismatchFactor
+		}
+	}
+}
+
+// resultYears is when a result says its book was published, from its years or else its publish date
+func resultYears(result *book.BookResult) (uint, uint, bool) {
+	if low, ok := result.LowYear.Get(); ok {
+		return low, result.HighYear.OrElse(low), true
+	}
+	return util.ParseYears(result.PublishDate.OrEmpty())
+}
+
+// PreferEdition lowers the score of the results for another edition than the one in the file, year being when that
+// edition was published and pages how many pages the file has, either of which is 0 when unknown. A result is only
+// taken for another edition when some other result does fit the file, so that a misread year or page count isn't held
+// against all of them. It returns why each result that could be told apart was or wasn't lowered.
+func PreferEdition(results []book.BookResult, year uint, pages uint) []string {
+	yearFits := make([]mo.Option[bool], len(results))
+	pagesFit := make([]mo.Option[bool], len(results))
+	anyYearFits, anyPagesFit := false, false
+	for idx := range results {
+		if low, high, ok := resultYears(&results[idx]); ok && year > 0 {
+			fits := low <= year && year <= high
+			yearFits[idx] = mo.Some(fits)
+			anyYearFits = anyYearFits || fits
+		}
+		if resultPages, ok := results[idx].Pages.Get(); ok && resultPages > 0 && pages > 0 {
+			fits := math.Abs(float64(resultPages)-float64(pages)) <= float64(pages)*pageTolerance
+			pagesFit[idx] = mo.Some(fits)
+			anyPagesFit = anyPagesFit || fits
+		}
+	}
+
+	rationale := make([]string, 0)
+	for idx := range results {
+		result := &results[idx]
+		fits := make([]string, 0)
+		misfits := make([]string, 0)
+		if fit, ok := yearFits[idx].Get(); ok {
+			low, high, _ := resultYears(result)
+			published := fmt.Sprintf("published %d", low)
+			if high != low {
+				published = fmt.Sprintf("published %d-%d", low, high)
+			}
+			if fit {
+				fits = append(fits, published)
+			} else if anyYearFits {
+				misfits = append(misfits, fmt.Sprintf("%s but the file is from %d", published, year))
+			}
+		}
+		if fit, ok := pagesFit[idx].Get(); ok {
+			if fit {
+				fits = append(fits, fmt.Sprintf("%d pages", result.Pages.MustGet()))
+			} else if anyPagesFit {
+				misfits = append(misfits, fmt.Sprintf("%d pages but the file has %d", result.Pages.MustGet(), pages))
+			}
+		}
+
+		source := fmt.Sprintf("%s %q", result.SourceProviderName, result.Title.OrEmpty())
+		if len(misfits) > 0 {
+			for range misfits {
+				result.Confidence *= editionMismatchFactor
+			}
+			rationale = append(rationale, fmt.Sprintf("%s lowered to %.1f: %s", source, result.Confidence, strings.Join(misfits, ", ")))
+		} else if len(fits) > 0 {
+			rationale = append(rationale, fmt.Sprintf("%s fits the file: %s", source, strings.Join(fits, ", ")))
+		}
+	}
+	return rationale
+}
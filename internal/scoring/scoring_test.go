@@ -0,0 +1,71 @@
+package scoring_test
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/scoring"
+	"github.com/samber/mo"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestScore(t *testing.T) {
+	isbn := mo.Some(book.ISBN13("9781718501263"))
+	results := []book.BookResult{
+		{Title: mo.Some("How to Hack Like a Ghost"), Isbn13: isbn, Confidence: 50, SourceProviderName: "epub"},
+		{Title: mo.Some("How to Hack Like a Ghost"), Authors: mo.Some([]string{"Sparc Flow"}), Isbn13: isbn, Publisher: mo.Some("No Starch Press"), Confidence: 100, SourceProviderName: "google"},
+		{Title: mo.Some("Gardening for Beginners"), Isbn13: mo.Some(book.ISBN13("9781718501260")), Confidence: 100, SourceProviderName: "isbndb"},
+	}
+	embedded := results[:1]
+
+	scoring.Score(results, "/books/Sparc Flow - How to Hack Like a Ghost (2021).epub", embedded)
+
+	// valid, agreed on, and matching the file name
+	assert.InDelta(t, 40+15+20+5+10*4/6.0, results[1].Confidence, 0.001)
+	// an invalid ISBN for a book nobody else found, with a title unlike the file's
+	assert.InDelta(t, 40+10*2/6.0, results[2].Confidence, 0.001)
+	assert.Greater(t, results[1].Confidence, results[0].Confidence)
+
+	results = []book.BookResult{{Title: mo.Some("Anything"), Confidence: 75, SourceProviderName: "google"}}
+	scoring.Score(results, "/books/B08BXKZBT1_EBOK.azw", nil)
+	// with no title to compare against, similarity counts half
+	assert.InDelta(t, 30+10+10/6.0, results[0].Confidence, 0.001)
+}
+
+func TestPreferLanguage(t *testing.T) {
+	results := []book.BookResult{
+		{Title: mo.Some("Der Process"), Language: mo.Some("de"), Confidence: 80, SourceProviderName: "google"},
+		{Title: mo.Some("The Trial"), Language: mo.Some("en"), Confidence: 80, SourceProviderName: "google"},
+		{Title: mo.Some("The Trial"), Confidence: 80, SourceProviderName: "isbndb"},
+	}
+
+	scoring.PreferLanguage(results, "de")
+	assert.Equal(t, 80.0, results[0].Confidence)
+	assert.InDelta(t, 64, results[1].Confidence, 0.001)
+	assert.Equal(t, 80.0, results[2].Confidence, "results without a language are left alone")
+
+	scoring.PreferLanguage(results, "")
+	assert.InDelta(t, 64, results[1].Confidence, 0.001)
+}
+
+func TestPreferEdition(t *testing.T) {
+	results := []book.BookResult{
+		{Title: mo.Some("Clean Code"), PublishDate: mo.Some("2008-08-01"), Pages: mo.Some(uint(520)), Confidence: 80, SourceProviderName: "google"},
+		{Title: mo.Some("Clean Code"), LowYear: mo.Some(uint(2012)), HighYear: mo.Some(uint(2012)), Pages: mo.Some(uint(431)), Confidence: 80, SourceProviderName: "openlibrary"},
+		{Title: mo.Some("Clean Code"), Confidence: 80, SourceProviderName: "isbndb"},
+	}
+
+	rationale := scoring.PreferEdition(results, 2012, 440)
+	assert.InDelta(t, 80*0.85*0.85, results[0].Confidence, 0.001)
+	assert.Equal(t, 80.0, results[1].Confidence)
+	assert.Equal(t, 80.0, results[2].Confidence, "results that can't be told apart are left alone")
+	assert.Equal(t, []string{
+		`google "Clean Code" lowered to 57.8: published 2008 but the file is from 2012, 520 pages but the file has 440`,
+		`openlibrary "Clean Code" fits the file: published 2012, 431 pages`,
+	}, rationale)
+
+	// with nothing that fits, the year is taken to be wrong and not the results
+	results = results[:1]
+	results[0].Confidence = 80
+	scoring.PreferEdition(results, 1999, 0)
+	assert.Equal(t, 80.0, results[0].Confidence)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/pipeline"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// claimWait is how long a worker asking for a book is kept waiting for one before it is told to ask again
+const claimWait = 30 * time.Second
+
+// job is a book waiting for a worker, or being processed by one
+type job struct {
+	Id       string `json:"id"`
+	Filepath string `json:"filepath"`
+	result   chan book.Book
+	// late is set once the book manager has given up waiting, the book having failed already
+	late bool
+}
+
+// jobQueue hands the books of the book manager out to the workers that ask for them, and waits for what they found
+type jobQueue struct {
+	pending chan *job
+	lock    sync.Mutex
+	claimed map[string]*job
+	nextId  atomic.Uint64
+	// timeout is how long a worker has to send a book back once it took it
+	timeout time.Duration
+}
+
+func newJobQueue(timeout time.Duration) *jobQueue {
+	return &jobQueue{
+		pending: make(chan *job),
+		claimed: make(map[string]*job),
+		timeout: timeout,
+	}
+}
+
+// Dispatch waits for a worker to take the book and send back what it found. The book's own timeout is for the work
+// done on it, which the worker limits, so waiting doesn't count against it. The worker has the queue's timeout instead,
+// once it took the book, and its result is still taken if it comes later.
+func (q *jobQueue) Dispatch(ctx context.Context, bk book.Book) (book.Book, error) {
+	j := &job{
+		Id:       strconv.FormatUint(q.nextId.Add(1), 10),
+		Filepath: bk.Filepath,
+		result:   make(chan book.Book, 1),
+	}
+	defer pipeline.Hold(ctx)()
+
+	select {
+	case q.pending <- j:
+	case <-ctx.Done():
+		return bk, fmt.Errorf("error: no worker took the book: %s", ctx.Err().Error())
+	}
+
+	remoteCtx, cancel := context.WithTimeout(ctx, q.timeout)
+	defer cancel()
+	select {
+	case result := <-j.result:
+		return result, nil
+	case <-remoteCtx.Done():
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	select {
+	case result := <-j.result:
+		// sent back just as the time ran out
+		return result, nil
+	default:
+	}
+	if ctx.Err() != nil {
+		delete(q.claimed, j.Id)
+		return bk, fmt.Errorf("error: the worker didn't send the book back: %s", ctx.Err().Error())
+	}
+	j.late = true
+	return bk, pipeline.TimedOut(fmt.Errorf("error: the worker didn't send the book back within %s", q.timeout.String()))
+}
+
+// claim waits for a book to hand out, reporting false if there was none in time
+func (q *jobQueue) claim(ctx context.Context) (*job, bool) {
+	select {
+	case j := <-q.pending:
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		q.claimed[j.Id] = j
+		return j, true
+	case <-ctx.Done():
+	case <-time.After(claimWait):
+	}
+	return nil, false
+}
+
+// job is the claimed job with id, which is gone once its result is in or no longer waited for
+func (q *jobQueue) job(id string) (*job, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	j, ok := q.claimed[id]
+	return j, ok
+}
+
+// complete hands the result of a job to the book manager waiting for it, reporting false if there is no such job. The
+// result of a late job is left to the caller, since the book manager has stopped waiting.
+func (q *jobQueue) complete(id string, bk book.Book) (*job, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	j, ok := q.claimed[id]
+	if !ok {
+		return nil, false
+	}
+	delete(q.claimed, id)
+	if !j.late {
+		j.result <- bk
+	}
+	return j, true
+}
+
+// Distribute has the books the server scans processed by workers started with booker worker, instead of by itself
+func (s *Server) Distribute(timeout time.Duration) {
+	s.jobs = newJobQueue(timeout)
+	s.bm.SetDispatcher(s.jobs)
+}
+
+func (s *Server) handleClaim(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the server isn't handing books out to workers"))
+		return
+	}
+	j, ok := s.jobs.claim(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJson(w, http.StatusOK, j)
+}
+
+// handleJobFile serves the file of a job to a worker that can't read it where it is, a book inside an archive is
+// extracted from it first and one on a WebDAV server downloaded
+func (s *Server) handleJobFile(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the server isn't handing books out to workers"))
+		return
+	}
+	j, ok := s.jobs.job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job with id %s", r.PathValue("id")))
+		return
+	}
+
+	s.serveBook(w, r, j.Filepath)
+}
+
+func (s *Server) handleResult(w http.ResponseWriter, r *http.Request) {
+	if s.jobs == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the server isn't handing books out to workers"))
+		return
+	}
+	var bk book.Book
+	err := json.NewDecoder(r.Body).Decode(&bk)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid result: %s", err.Error()))
+		return
+	}
+	j, ok := s.jobs.complete(r.PathValue("id"), bk)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job with id %s", r.PathValue("id")))
+		return
+	}
+	if j.late {
+		// the book manager gave up waiting, so the book has already failed, but the work needn't be lost
+		slog.Warn("worker sent back a book too late, recording it in place of its timeout", "job", j.Id, "path", j.Filepath)
+		s.bm.FinishLate(j.Filepath, bk)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/server"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// coordinator serves a book manager that hands the books under a new library out to workers, who have timeout to send
+// each one back. The books have a second each to be processed in.
+func coordinator(t *testing.T, timeout time.Duration) (*internal.BookManager, string, *httptest.Server) {
+	conf := offline()
+	conf.Advanced.TimeoutSeconds = 1
+	bm, err := internal.NewBookManager(conf, 2)
+	assert.NoError(t, err)
+	t.Cleanup(bm.Shutdown)
+	srv := server.NewServer(bm, "")
+	srv.Distribute(timeout)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	lib := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(lib, "dune.epub"), []byte("dune on the coordinator"), 0o644))
+	bm.Start(context.Background(), false, util.DiscardWriter[*book.Book]{})
+	_, err = bm.SubmitPath(lib)
+	assert.NoError(t, err)
+	return bm, lib, ts
+}
+
+type claimedJob struct {
+	Id       string `json:"id"`
+	Filepath string `json:"filepath"`
+}
+
+// claim takes the next book from the coordinator at url, like a worker
+func claim(t *testing.T, url string) claimedJob {
+	response, err := http.Post(url+"/jobs/claim", "application/json", nil)
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	var j claimedJob
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&j))
+	return j
+}
+
+// sendBack posts the book found for a job to the coordinator at url, returning the status it answered with
+func sendBack(t *testing.T, url string, j claimedJob, bk book.Book) int {
+	data, err := json.Marshal(bk)
+	assert.NoError(t, err)
+	response, err := http.Post(fmt.Sprintf("%s/jobs/%s/result", url, j.Id), "application/json", bytes.NewReader(data))
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	return response.StatusCode
+}
+
+func TestDistribute(t *testing.T) {
+	bm, lib, ts := coordinator(t, time.Minute)
+	// waiting for a worker doesn't count against the book's own timeout, nor does the worker's time
+	time.Sleep(1500 * time.Millisecond)
+	j := claim(t, ts.URL)
+	assert.Equal(t, filepath.Join(lib, "dune.epub"), j.Filepath)
+
+	response, err := http.Get(fmt.Sprintf("%s/jobs/%s/file", ts.URL, j.Id))
+	assert.NoError(t, err)
+	contents, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "dune on the coordinator", string(contents))
+	time.Sleep(1500 * time.Millisecond)
+
+	// the book is recorded by its path on the coordinator, whatever the worker read it from
+	assert.Equal(t, http.StatusNoContent, sendBack(t, ts.URL, j, book.Book{Filepath: "/tmp/copy/dune.epub", Title: "Dune"}))
+	assert.NoError(t, bm.Stop())
+	assert.Equal(t, "Dune", bm.Books()[j.Filepath].Title)
+
+	assert.Equal(t, http.StatusNotFound, sendBack(t, ts.URL, j, book.Book{Title: "Dune"}))
+}
+
+func TestDistributeLate(t *testing.T) {
+	bm, _, ts := coordinator(t, 100*time.Millisecond)
+	j := claim(t, ts.URL)
+	assert.Eventually(t, func() bool {
+		return bm.Books()[j.Filepath].ErrorCode == book.ErrorTimeout
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// a book sent back after it timed out replaces its timeout, rather than being thrown away
+	assert.Equal(t, http.StatusNoContent, sendBack(t, ts.URL, j, book.Book{Filepath: j.Filepath, Title: "Dune"}))
+	assert.NoError(t, bm.Stop())
+	bk := bm.Books()[j.Filepath]
+	assert.Equal(t, "Dune", bk.Title)
+	assert.Empty(t, bk.ErrorMessage)
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/opds"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/larkwiot/booker/internal/webdav"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type scanRequest struct {
+	Path string `json:"path"`
+}
+
+type scanResponse struct {
+	Path string `json:"path"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type Server struct {
+	bm     *internal.BookManager
+	server *http.Server
+	// jobs hands the books out to workers, if the server distributes them
+	jobs *jobQueue
+}
+
+func NewServer(bm *internal.BookManager, listen string) *Server {
+	s := &Server{
+		bm: bm,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scan", s.handleScan)
+	mux.HandleFunc("GET /books", s.handleBooks)
+	mux.HandleFunc("GET /books/{hash}", s.handleBook)
+	mux.HandleFunc("GET /books/{hash}/file", s.handleBookFile)
+	mux.HandleFunc("GET /opds", s.handleOpds)
+	mux.HandleFunc("GET /opds/v2", s.handleOpds)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /dry-run", s.handleDryRun)
+	mux.HandleFunc("POST /jobs/claim", s.handleClaim)
+	mux.HandleFunc("GET /jobs/{id}/file", s.handleJobFile)
+	mux.HandleFunc("POST /jobs/{id}/result", s.handleResult)
+
+	s.server = &http.Server{
+		Addr:    listen,
+		Handler: mux,
+	}
+
+	return s
+}
+
+// Handler answers the server's requests, for serving them some other way than by Serve
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Serve starts the book manager and answers requests until it is interrupted, then finishes any books in flight
+func (s *Server) Serve(ctx context.Context, dryRun bool, writer util.ObjectWriter[*book.Book]) error {
+	s.bm.Start(ctx, dryRun, writer)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("server listening", "address", s.server.Addr)
+		serveErr <- s.server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		s.bm.Interrupt()
+	case <-s.bm.Interrupted():
+		// the books out with workers only finish once the workers can send them back
+		if s.jobs != nil {
+			slog.Info("server interrupted, waiting for workers to send back their books")
+			s.bm.Drain()
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = s.server.Shutdown(shutdownCtx)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	if err != nil {
+		err = fmt.Errorf("error: server failed: %s", err.Error())
+	}
+
+	slog.Info("server stopped, waiting for in-flight books to finish")
+
+	stopErr := s.bm.Stop()
+	if err != nil {
+		return err
+	}
+	return stopErr
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Warn("server failed to write response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJson(w, statusCode, errorResponse{Error: err.Error()})
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	var request scanRequest
+	err := json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid scan request: %s", err.Error()))
+		return
+	}
+
+	if len(request.Path) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("scan request is missing a path"))
+		return
+	}
+
+	if err := webdav.CheckSource(request.Path); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// a collection on a WebDAV server is only found to be missing once it is walked
+	scanPath := strings.TrimSuffix(request.Path, "/")
+	if !webdav.IsUrl(request.Path) {
+		scanPath, err = util.AbsPath(request.Path)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("could not get absolute scan path: %s", err.Error()))
+			return
+		}
+
+		if exists, err := util.PathExists(scanPath); !exists {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("could not stat scan path: %s", err))
+			return
+		}
+	}
+
+	select {
+	case <-s.bm.Interrupted():
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("server is shutting down"))
+		return
+	default:
+	}
+
+	// walking a large library takes a while, so the books are submitted in the background
+	go func() {
+		slog.Info("server beginning scan", "path", scanPath)
+		submitted, err := s.bm.SubmitPath(scanPath)
+		if err != nil {
+			slog.Error("failed to completely scan", "path", scanPath, "error", err)
+		}
+		slog.Info("server submitted books", "path", scanPath, "books", submitted)
+	}()
+
+	writeJson(w, http.StatusAccepted, scanResponse{Path: scanPath})
+}
+
+func (s *Server) handleBooks(w http.ResponseWriter, r *http.Request) {
+	books := s.bm.Books()
+	writeJson(w, http.StatusOK, books)
+}
+
+func (s *Server) handleBook(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	bk, found := s.bm.BookByHash(hash)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no book with hash %s", hash))
+		return
+	}
+	writeJson(w, http.StatusOK, bk)
+}
+
+func (s *Server) handleBookFile(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	bk, found := s.bm.BookByHash(hash)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no book with hash %s", hash))
+		return
+	}
+	s.serveBook(w, r, bk.Filepath)
+}
+
+// serveBook serves the file of the book at filePath, downloading it first from a WebDAV server or extracting it from
+// its archive
+func (s *Server) serveBook(w http.ResponseWriter, r *http.Request, filePath string) {
+	if webdav.IsUrl(filePath) {
+		downloaded, err := s.bm.Download(r.Context(), filePath)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Errorf("could not download from WebDAV server: %s", err.Error()))
+			return
+		}
+		defer os.Remove(downloaded)
+		filePath = downloaded
+	} else if archivePath, member, ok := archive.Split(filePath); ok {
+		extracted, err := archive.Extract(archivePath, member)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("could not extract from archive: %s", err.Error()))
+			return
+		}
+		defer os.Remove(extracted)
+		filePath = extracted
+	}
+	http.ServeFile(w, r, filePath)
+}
+
+// handleOpds serves an OPDS 1.2 catalog at /opds and an OPDS 2.0 catalog at /opds/v2, linking books to their files
+func (s *Server) handleOpds(w http.ResponseWriter, r *http.Request) {
+	books := s.bm.Books()
+	catalog := opds.Catalog{
+		Title:    "Booker Library",
+		Updated:  time.Now(),
+		SelfHref: r.URL.Path,
+		Books:    make([]book.Book, 0, len(books)),
+		Href: func(bk *book.Book) string {
+			if len(bk.Hash) == 0 {
+				return ""
+			}
+			return fmt.Sprintf("/books/%s/file", bk.Hash)
+		},
+	}
+	for _, bk := range books {
+		catalog.Books = append(catalog.Books, bk)
+	}
+
+	var data []byte
+	var err error
+	mediaType := opds.AtomMediaType
+	if r.URL.Path == "/opds/v2" {
+		data, err = catalog.Json()
+		mediaType = opds.JsonMediaType
+	} else {
+		data, err = catalog.Atom()
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("could not render catalog: %s", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	_, err = w.Write(data)
+	if err != nil {
+		slog.Warn("server failed to write response", "error", err)
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, s.bm.Status())
+}
+
+// handleDryRun lists what a dry run found in every book so far, and the lookups the providers would have been sent
+func (s *Server) handleDryRun(w http.ResponseWriter, r *http.Request) {
+	if !s.bm.IsDryRun() {
+		writeError(w, http.StatusNotFound, fmt.Errorf("the server isn't doing a dry run"))
+		return
+	}
+	writeJson(w, http.StatusOK, s.bm.DryRunReport())
+}
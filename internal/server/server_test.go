@@ -0,0 +1,105 @@
+package server_test
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/server"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// offline is a config that only reads EPUBs and searches no providers
+func offline() *config.Config {
+	conf := &config.Config{Offline: true}
+	conf.Epub.Enable = true
+	return conf
+}
+
+// newServer serves a book manager made with conf
+func newServer(t *testing.T, conf *config.Config) (*internal.BookManager, *httptest.Server) {
+	bm, err := internal.NewBookManager(conf, 2)
+	assert.NoError(t, err)
+	t.Cleanup(bm.Shutdown)
+	ts := httptest.NewServer(server.NewServer(bm, "").Handler())
+	t.Cleanup(ts.Close)
+	return bm, ts
+}
+
+func TestScanUnsupportedUrl(t *testing.T) {
+	_, ts := newServer(t, offline())
+	response, err := http.Post(ts.URL+"/scan", "application/json", strings.NewReader(`{"path": "sftp://nas.local/books"}`))
+	assert.NoError(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	var body struct {
+		Error string `json:"error"`
+	}
+	assert.NoError(t, json.NewDecoder(response.Body).Decode(&body))
+	assert.Equal(t, "SFTP servers can't be read from yet, mount sftp://nas.local/books with sshfs and use the mount instead", body.Error)
+}
+
+func TestBookFile(t *testing.T) {
+	dir := t.TempDir()
+	archivePath := filepath.Join(dir, "books.zip")
+	fh, err := os.Create(archivePath)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(fh)
+	member, err := zw.Create("inner/dune.epub")
+	assert.NoError(t, err)
+	_, err = member.Write([]byte("dune from the archive"))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, fh.Close())
+
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dav/Books/Sci Fi/Hyperion.epub" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Last-Modified", "Mon, 06 May 2024 10:00:00 GMT")
+		w.Write([]byte("hyperion from the server"))
+	}))
+	defer dav.Close()
+
+	books := map[string]book.Book{
+		archive.Join(archivePath, "inner/dune.epub"): {Filepath: archive.Join(archivePath, "inner/dune.epub"), Hash: "dune", Title: "Dune"},
+		dav.URL + "/dav/Books/Sci Fi/Hyperion.epub":  {Filepath: dav.URL + "/dav/Books/Sci Fi/Hyperion.epub", Hash: "hyperion", Title: "Hyperion"},
+	}
+	data, err := json.Marshal(books)
+	assert.NoError(t, err)
+	cache := filepath.Join(dir, "books.json")
+	assert.NoError(t, os.WriteFile(cache, data, 0o644))
+
+	conf := offline()
+	conf.Webdav.Enable = true
+	bm, ts := newServer(t, conf)
+	assert.NoError(t, bm.Import(cache, nil))
+
+	get := func(path string) (int, string) {
+		response, err := http.Get(ts.URL + path)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		assert.NoError(t, err)
+		return response.StatusCode, string(body)
+	}
+	// a book in an archive is extracted and one on a WebDAV server downloaded, rather than looked for on disk
+	status, body := get("/books/dune/file")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "dune from the archive", body)
+	status, body = get("/books/hyperion/file")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hyperion from the server", body)
+	status, _ = get("/books/missing/file")
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryWait is how long a worker waits before asking a coordinator it couldn't reach again
+const retryWait = 5 * time.Second
+
+// Worker processes the books a coordinator started with serve --distribute hands out, and sends back what it found
+type Worker struct {
+	bm     *internal.BookManager
+	url    string
+	client *http.Client
+}
+
+// NewWorker works for the coordinator at join, an address like host:8080 or a URL
+func NewWorker(bm *internal.BookManager, join string) *Worker {
+	url := strings.TrimSuffix(join, "/")
+	if !strings.Contains(url, "://") {
+		url = fmt.Sprintf("http://%s", url)
+	}
+	return &Worker{
+		bm:     bm,
+		url:    url,
+		client: &http.Client{},
+	}
+}
+
+// Run processes as many books at once as jobs until ctx is cancelled. The books it was working on then are failed by
+// the coordinator once they time out.
+func (w *Worker) Run(ctx context.Context, jobs int) {
+	slog.Info("worker joining coordinator", "url", w.url, "jobs", jobs)
+	var running sync.WaitGroup
+	for range jobs {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for ctx.Err() == nil {
+				w.work(ctx)
+			}
+		}()
+	}
+	running.Wait()
+}
+
+// work processes the next book the coordinator hands out, if it has one
+func (w *Worker) work(ctx context.Context) {
+	j, err := w.claim(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("could not get a book from the coordinator, trying again", "url", w.url, "error", err, "wait", retryWait)
+			select {
+			case <-time.After(retryWait):
+			case <-ctx.Done():
+			}
+		}
+		return
+	}
+	if j == nil {
+		return
+	}
+
+	slog.Debug("worker processing book", "job", j.Id, "path", j.Filepath)
+	bk := w.process(ctx, j)
+	if ctx.Err() != nil {
+		return
+	}
+	err = w.report(ctx, j, &bk)
+	if err != nil {
+		slog.Error("could not send a book back to the coordinator", "job", j.Id, "path", j.Filepath, "error", err)
+		return
+	}
+	slog.Info("worker processed book", "path", j.Filepath, "error", bk.ErrorMessage)
+}
+
+// claim asks the coordinator for a book, which is nil if it had none to hand out for a while
+func (w *Worker) claim(ctx context.Context) (*job, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/jobs/claim", w.url), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		body, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("coordinator returned bad status code %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var j job
+	err = json.NewDecoder(response.Body).Decode(&j)
+	if err != nil {
+		return nil, fmt.Errorf("error: unable to decode job: %s", err.Error())
+	}
+	return &j, nil
+}
+
+// process identifies the book of a job, from its path if the file is shared with the coordinator and otherwise from a
+// copy downloaded from it. A book that couldn't be identified is returned with its error.
+func (w *Worker) process(ctx context.Context, j *job) book.Book {
+	filePath := j.Filepath
+	if exists, _ := util.PathExists(filePath); !exists || archive.IsMember(filePath) {
+		dir, err := os.MkdirTemp("", "booker-worker-")
+		if err != nil {
+			return failedJob(j, fmt.Errorf("error: could not create a directory to download to: %s", err.Error()))
+		}
+		defer os.RemoveAll(dir)
+
+		// named like the original, since the file name is a hint to what the book is
+		name := filepath.Base(j.Filepath)
+		if _, member, ok := archive.Split(j.Filepath); ok {
+			name = filepath.Base(member)
+		}
+		filePath = filepath.Join(dir, name)
+		err = w.download(ctx, j, filePath)
+		if err != nil {
+			return failedJob(j, fmt.Errorf("error: could not download from the coordinator: %s", err.Error()))
+		}
+	}
+
+	bk, err := w.bm.Identify(ctx, filePath)
+	if len(bk.Filepath) == 0 {
+		return failedJob(j, err)
+	}
+	bk.Filepath = j.Filepath
+	return bk
+}
+
+func failedJob(j *job, err error) book.Book {
+	bk := book.Book{Filepath: j.Filepath}
+	bk.SetError(err)
+	return bk
+}
+
+// download copies the file of a job from the coordinator to filePath
+func (w *Worker) download(ctx context.Context, j *job, filePath string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/jobs/%s/file", w.url, j.Id), nil)
+	if err != nil {
+		return err
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("coordinator returned bad status code %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	fh, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fh, response.Body)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// report sends the book of a job back to the coordinator
+func (w *Worker) report(ctx context.Context, j *job, bk *book.Book) error {
+	data, err := json.Marshal(bk)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/jobs/%s/result", w.url, j.Id), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(response.Body)
+		return fmt.Errorf("coordinator returned bad status code %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
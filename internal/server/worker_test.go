@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/server"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWorkerDownloads(t *testing.T) {
+	bm, err := internal.NewBookManager(offline(), 2)
+	assert.NoError(t, err)
+	defer bm.Shutdown()
+
+	// a coordinator with one book, at a path that isn't on this machine
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var lock sync.Mutex
+	claimed, downloaded := false, false
+	var result book.Book
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs/claim", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if claimed {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		claimed = true
+		w.Write([]byte(`{"id": "1", "filepath": "/elsewhere/library/dune.epub"}`))
+	})
+	mux.HandleFunc("GET /jobs/1/file", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		downloaded = true
+		lock.Unlock()
+		w.Write([]byte("not really an epub"))
+	})
+	mux.HandleFunc("POST /jobs/1/result", func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&result))
+		w.WriteHeader(http.StatusNoContent)
+		cancel()
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	server.NewWorker(bm, ts.URL).Run(ctx, 1)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.True(t, downloaded)
+	// the copy is sent back as the book at the coordinator's path, failed here since it isn't really an EPUB
+	assert.Equal(t, "/elsewhere/library/dune.epub", result.Filepath)
+	assert.NotEmpty(t, result.ErrorMessage)
+}
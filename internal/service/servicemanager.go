@@ -1,7 +1,8 @@
 package service
 
 import (
-	"log"
+	"log/slog"
+	"slices"
 	"sync"
 	"time"
 )
@@ -12,6 +13,24 @@ type Service interface {
 	HealthCheck() (bool, string)
 }
 
+const (
+	// failuresToDown is how many checks in a row a live service has to fail to be taken out, and successesToUp how many a
+	// service that is down has to pass to be put back in, so that a single dropped connection doesn't bounce it
+	failuresToDown = 3
+	successesToUp  = 2
+	// maxBackoffIntervals is the most health check intervals a service that is down waits between its checks, the wait
+	// doubling after each check it fails
+	maxBackoffIntervals = 16
+)
+
+// serviceHealth is how a service did in its last checks
+type serviceHealth struct {
+	failures  uint
+	successes uint
+	backoff   time.Duration
+	nextCheck time.Time
+}
+
 type ServiceManager struct {
 	services            []Service
 	servicesLock        sync.RWMutex
@@ -50,7 +69,11 @@ func (dd *ServiceManager) Close() {
 	close(dd.quit)
 }
 
+// watch checks the services each interval. A live service that fails failuresToDown checks in a row is taken out of
+// the live services, and then checked less and less often until it passes successesToUp in a row and is put back in,
+// so that a Tika server that restarts mid-scan is used again once it is up.
 func (dd *ServiceManager) watch() {
+	health := make(map[string]*serviceHealth)
 	for {
 		select {
 		case <-dd.quit:
@@ -60,29 +83,84 @@ func (dd *ServiceManager) watch() {
 
 		time.Sleep(dd.healthCheckInterval)
 
-		for _, service := range dd.services {
+		dd.servicesLock.RLock()
+		services := slices.Clone(dd.services)
+		dd.servicesLock.RUnlock()
+
+		now := time.Now()
+		for _, service := range services {
+			state, ok := health[service.Name()]
+			if !ok {
+				state = &serviceHealth{}
+				health[service.Name()] = state
+			}
+			if now.Before(state.nextCheck) {
+				continue
+			}
+
 			up, reason := service.SelfCheck()
 			if up {
 				up, reason = service.HealthCheck()
 			}
+			dd.record(service, state, up, reason)
+			state.nextCheck = now.Add(state.backoff)
+		}
+	}
+}
 
-			if !up {
-				log.Printf("warning: %s is down because: %s\n", service.Name(), reason)
+// record counts a check of service towards taking it out of or putting it back in the live services, backing off from
+// checking it while it is down
+func (dd *ServiceManager) record(service Service, state *serviceHealth, up bool, reason string) {
+	dd.liveServicesLock.Lock()
+	defer dd.liveServicesLock.Unlock()
+	_, live := dd.liveServices[service.Name()]
 
-				dd.liveServicesLock.Lock()
-				delete(dd.liveServices, service.Name())
-				dd.liveServicesLock.Unlock()
+	if up {
+		state.failures = 0
+		state.backoff = 0
+		if !live {
+			state.successes++
+			if state.successes >= successesToUp {
+				slog.Info("service is back up", "service", service.Name())
+				dd.liveServices[service.Name()] = service
+				state.successes = 0
 			}
 		}
+		return
+	}
+
+	state.successes = 0
+	state.failures++
+	if live {
+		if state.failures < failuresToDown {
+			slog.Debug("service failed a health check", "service", service.Name(), "failures", state.failures, "reason", reason)
+			return
+		}
+		slog.Warn("service is down", "service", service.Name(), "reason", reason)
+		delete(dd.liveServices, service.Name())
 	}
+	state.backoff = min(max(2*state.backoff, dd.healthCheckInterval), maxBackoffIntervals*dd.healthCheckInterval)
 }
 
+func (dd *ServiceManager) IsLive(service Service) bool {
+	dd.liveServicesLock.RLock()
+	defer dd.liveServicesLock.RUnlock()
+	_, live := dd.liveServices[service.Name()]
+	return live
+}
+
+// GetLiveServices returns the live services in the order they were managed in, so that searches break ties the same
+// way every time
 func (dd *ServiceManager) GetLiveServices() []Service {
+	dd.servicesLock.RLock()
+	defer dd.servicesLock.RUnlock()
 	dd.liveServicesLock.RLock()
 	defer dd.liveServicesLock.RUnlock()
 	services := make([]Service, 0)
-	for _, service := range dd.liveServices {
-		services = append(services, service)
+	for _, service := range dd.services {
+		if _, live := dd.liveServices[service.Name()]; live {
+			services = append(services, service)
+		}
 	}
 	return services
 }
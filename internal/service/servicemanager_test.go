@@ -0,0 +1,84 @@
+package service_test
+
+import (
+	"github.com/larkwiot/booker/internal/service"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyService is up or down as the test sets it, and fails the checks in fail as well
+type flakyService struct {
+	name   string
+	up     atomic.Bool
+	checks atomic.Int32
+	fail   map[int32]bool
+}
+
+func (f *flakyService) Name() string {
+	return "flaky" + f.name
+}
+
+func (f *flakyService) SelfCheck() (bool, string) {
+	return true, ""
+}
+
+func (f *flakyService) HealthCheck() (bool, string) {
+	return f.up.Load() && !f.fail[f.checks.Add(1)], "unreachable"
+}
+
+func TestRecovery(t *testing.T) {
+	svcmgr := service.NewServiceManager(time.Millisecond)
+	defer svcmgr.Close()
+	flaky := &flakyService{}
+	flaky.up.Store(true)
+	svcmgr.Manage(flaky)
+	assert.True(t, svcmgr.IsLive(flaky))
+
+	flaky.up.Store(false)
+	assert.Eventually(t, func() bool { return !svcmgr.IsLive(flaky) }, time.Second, time.Millisecond)
+	assert.Empty(t, svcmgr.GetLiveServices())
+
+	// a service that passes its checks again is used again
+	flaky.up.Store(true)
+	assert.Eventually(t, func() bool { return svcmgr.IsLive(flaky) }, time.Second, time.Millisecond)
+	assert.Len(t, svcmgr.GetLiveServices(), 1)
+}
+
+func TestFlapping(t *testing.T) {
+	svcmgr := service.NewServiceManager(time.Millisecond)
+	defer svcmgr.Close()
+	// failing a check now and then doesn't take a service out
+	flaky := &flakyService{fail: map[int32]bool{2: true, 4: true, 5: true, 8: true}}
+	flaky.up.Store(true)
+	svcmgr.Manage(flaky)
+	assert.Never(t, func() bool { return !svcmgr.IsLive(flaky) }, 50*time.Millisecond, time.Millisecond)
+	assert.Greater(t, flaky.checks.Load(), int32(8))
+
+	flaky.up.Store(false)
+	assert.Eventually(t, func() bool { return !svcmgr.IsLive(flaky) }, time.Second, time.Millisecond)
+
+	// nor does passing one check put it back in
+	checks := flaky.checks.Load()
+	flaky.up.Store(true)
+	assert.Eventually(t, func() bool { return svcmgr.IsLive(flaky) }, time.Second, time.Millisecond)
+	assert.GreaterOrEqual(t, flaky.checks.Load()-checks, int32(2))
+}
+
+func TestLiveServicesOrder(t *testing.T) {
+	svcmgr := service.NewServiceManager(time.Hour)
+	defer svcmgr.Close()
+	names := []string{"c", "a", "d", "b", "e"}
+	for _, name := range names {
+		svcmgr.Manage(&flakyService{name: name})
+	}
+	// always in the order they were managed in, however the live services are kept
+	for range 20 {
+		live := make([]string, 0)
+		for _, svc := range svcmgr.GetLiveServices() {
+			live = append(live, svc.(*flakyService).name)
+		}
+		assert.Equal(t, names, live)
+	}
+}
@@ -0,0 +1,204 @@
+package stats
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slowestCount is how many of the slowest books are kept
+const slowestCount = 10
+
+// Stats counts what a scan did, to see where the time went and what to tune. A nil Stats counts nothing, so the book
+// manager can record to one while it isn't scanning.
+type Stats struct {
+	lock    sync.Mutex
+	began   time.Time
+	report  Report
+	started map[string]time.Time
+}
+
+// Report is what a scan did, as it is written to the stats output
+type Report struct {
+	Seconds    float64 `json:"seconds"`
+	Discovered int64   `json:"discovered"`
+	// SkippedByExtension counts the files that aren't books by their extension, "" being files with none
+	SkippedByExtension map[string]int64 `json:"skipped_by_extension"`
+	Excluded           int64            `json:"excluded"`
+	// CacheHits are the books skipped since the cache had them by path or by contents
+	CacheHits  int64 `json:"cache_hits"`
+	Identified int64 `json:"identified"`
+	Failed     int64 `json:"failed"`
+	// IdentificationRate is the share of the books processed that were identified, from 0 to 1
+	IdentificationRate float64                   `json:"identification_rate"`
+	Providers          map[string]ProviderCounts `json:"providers"`
+	Slowest            []Timing                  `json:"slowest"`
+}
+
+// ProviderCounts are how the searches of a provider went
+type ProviderCounts struct {
+	Found  int64 `json:"found"`
+	Empty  int64 `json:"empty"`
+	Errors int64 `json:"errors"`
+}
+
+// Timing is how long a book took from being extracted to being written out
+type Timing struct {
+	Filepath string  `json:"filepath"`
+	Seconds  float64 `json:"seconds"`
+}
+
+func New() *Stats {
+	return &Stats{
+		lock:  sync.Mutex{},
+		began: time.Now(),
+		report: Report{
+			SkippedByExtension: make(map[string]int64),
+			Providers:          make(map[string]ProviderCounts),
+			Slowest:            make([]Timing, 0, slowestCount+1),
+		},
+		started: make(map[string]time.Time),
+	}
+}
+
+func (s *Stats) update(f func(r *Report)) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(&s.report)
+}
+
+// Discover counts a book found by the scan, whether or not it is cached
+func (s *Stats) Discover() {
+	s.update(func(r *Report) {
+		r.Discovered++
+	})
+}
+
+func (s *Stats) SkipExtension(ext string) {
+	s.update(func(r *Report) {
+		r.SkippedByExtension[strings.ToLower(ext)]++
+	})
+}
+
+func (s *Stats) Exclude() {
+	s.update(func(r *Report) {
+		r.Excluded++
+	})
+}
+
+func (s *Stats) CacheHit() {
+	s.update(func(r *Report) {
+		r.CacheHits++
+	})
+}
+
+// Search counts one search of a provider by whether it failed or how many results it found
+func (s *Stats) Search(provider string, results int, err error) {
+	s.update(func(r *Report) {
+		counts := r.Providers[provider]
+		switch {
+		case err != nil:
+			counts.Errors++
+		case results == 0:
+			counts.Empty++
+		default:
+			counts.Found++
+		}
+		r.Providers[provider] = counts
+	})
+}
+
+// Start times a book from when it starts to be extracted
+func (s *Stats) Start(filePath string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.started[filePath] = time.Now()
+}
+
+// Finish counts a book that was written out, keeping it among the slowest if it was
+func (s *Stats) Finish(filePath string, identified bool) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if identified {
+		s.report.Identified++
+	} else {
+		s.report.Failed++
+	}
+
+	started, ok := s.started[filePath]
+	if !ok {
+		return
+	}
+	delete(s.started, filePath)
+
+	timing := Timing{Filepath: filePath, Seconds: time.Since(started).Seconds()}
+	idx, _ := slices.BinarySearchFunc(s.report.Slowest, timing, func(a, b Timing) int {
+		return cmp.Compare(b.Seconds, a.Seconds)
+	})
+	if idx < slowestCount {
+		s.report.Slowest = slices.Insert(s.report.Slowest, idx, timing)
+		s.report.Slowest = s.report.Slowest[:min(len(s.report.Slowest), slowestCount)]
+	}
+}
+
+// Report is a copy of what has been counted so far
+func (s *Stats) Report() Report {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	report := s.report
+	report.Seconds = time.Since(s.began).Seconds()
+	report.SkippedByExtension = maps.Clone(s.report.SkippedByExtension)
+	report.Providers = maps.Clone(s.report.Providers)
+	report.Slowest = slices.Clone(s.report.Slowest)
+	if processed := report.Identified + report.Failed; processed > 0 {
+		report.IdentificationRate = float64(report.Identified) / float64(processed)
+	}
+	return report
+}
+
+// Print writes the report as a summary for people to read
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "scan took %s\n", time.Duration(r.Seconds*float64(time.Second)).Round(time.Second).String())
+	fmt.Fprintf(w, "  discovered:  %d books, %d of them cached\n", r.Discovered, r.CacheHits)
+	fmt.Fprintf(w, "  identified:  %d of %d processed (%.1f%%), %d failed\n", r.Identified, r.Identified+r.Failed, r.IdentificationRate*100, r.Failed)
+
+	if r.Excluded > 0 || len(r.SkippedByExtension) > 0 {
+		skipped := make([]string, 0, len(r.SkippedByExtension))
+		for _, ext := range slices.Sorted(maps.Keys(r.SkippedByExtension)) {
+			name := ext
+			if len(name) == 0 {
+				name = "(none)"
+			}
+			skipped = append(skipped, fmt.Sprintf("%s %d", name, r.SkippedByExtension[ext]))
+		}
+		fmt.Fprintf(w, "  skipped:     %d excluded, by extension: %s\n", r.Excluded, strings.Join(skipped, ", "))
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(r.Providers)) {
+		counts := r.Providers[name]
+		fmt.Fprintf(w, "  %-12s %d found, %d empty, %d errors\n", name+":", counts.Found, counts.Empty, counts.Errors)
+	}
+
+	if len(r.Slowest) > 0 {
+		fmt.Fprintln(w, "  slowest:")
+		for _, timing := range r.Slowest {
+			fmt.Fprintf(w, "    %6.1fs %s\n", timing.Seconds, timing.Filepath)
+		}
+	}
+}
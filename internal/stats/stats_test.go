@@ -0,0 +1,47 @@
+package stats_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/larkwiot/booker/internal/stats"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	s := stats.New()
+	for idx := range 12 {
+		s.Discover()
+		path := fmt.Sprintf("/books/%02d.pdf", idx)
+		s.Start(path)
+		s.Finish(path, idx%4 != 0)
+	}
+	s.CacheHit()
+	s.SkipExtension(".JPG")
+	s.SkipExtension(".jpg")
+	s.Search("google", 2, nil)
+	s.Search("google", 0, nil)
+	s.Search("google", 0, errors.New("google returned bad status code 503"))
+
+	report := s.Report()
+	assert.Equal(t, int64(12), report.Discovered)
+	assert.Equal(t, int64(9), report.Identified)
+	assert.Equal(t, int64(3), report.Failed)
+	assert.Equal(t, 0.75, report.IdentificationRate)
+	assert.Equal(t, map[string]int64{".jpg": 2}, report.SkippedByExtension)
+	assert.Equal(t, stats.ProviderCounts{Found: 1, Empty: 1, Errors: 1}, report.Providers["google"])
+	assert.Len(t, report.Slowest, 10)
+	for idx := 1; idx < len(report.Slowest); idx++ {
+		assert.GreaterOrEqual(t, report.Slowest[idx-1].Seconds, report.Slowest[idx].Seconds)
+	}
+
+	var summary bytes.Buffer
+	report.Print(&summary)
+	assert.Contains(t, summary.String(), "9 of 12 processed (75.0%)")
+
+	// nothing is counted while not scanning
+	var none *stats.Stats
+	none.Discover()
+	none.Finish("/books/a.pdf", true)
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"github.com/samber/lo"
+	"regexp"
+	"strings"
+)
+
+// lccIdentifier only matches labelled call numbers, like the "LCC TK5105.59 .F624 2021" of a CIP data block, since
+// bare ones can't be told from other codes. The class, cutters and year are kept, anything after them is not.
+var lccIdentifier = regexp.MustCompile(`(?:\bLCC|(?i:\bLC\s+classification|\bLibrary\s+of\s+Congress\s+classification)):?\s+([A-Z]{1,3}\s?[0-9]{1,4}(?:\.[0-9]+)?(?:\s*\.?[A-Z][0-9]+)*(?:\s[0-9]{4}[a-z]?)?)\b`)
+
+// ddcIdentifier only matches labelled Dewey numbers, like the "DDC 005.8/7--dc23" of a CIP data block
+var ddcIdentifier = regexp.MustCompile(`(?:\bDDC|(?i:\bDewey(?:\s+decimal)?(?:\s+classification)?(?:\s+number)?)):?\s*([0-9]{3}['/]?(?:\.[0-9/']*[0-9])?)\b`)
+
+// IdentifyLccs finds Library of Congress call numbers in text, with their spacing tidied
+func IdentifyLccs(text string) []string {
+	return lo.Uniq(lo.Map(lccIdentifier.FindAllStringSubmatch(text, -1), func(match []string, _ int) string {
+		return strings.Join(strings.Fields(match[1]), " ")
+	}))
+}
+
+// IdentifyDdcs finds Dewey Decimal Classification numbers in text. The prime marks that show where a number may be
+// cut short are dropped, so "005.8/7" is found as "005.87".
+func IdentifyDdcs(text string) []string {
+	return lo.Uniq(lo.Map(ddcIdentifier.FindAllStringSubmatch(text, -1), func(match []string, _ int) string {
+		return NormalizeDdc(match[1])
+	}))
+}
+
+// NormalizeDdc drops the prime marks from a Dewey number and anything after the number itself, like the edition in
+// "005.8/7--dc23"
+func NormalizeDdc(ddc string) string {
+	ddc, _, _ = strings.Cut(strings.TrimSpace(ddc), " ")
+	ddc, _, _ = strings.Cut(ddc, "--")
+	return strings.NewReplacer("/", "", "'", "").Replace(ddc)
+}
@@ -0,0 +1,99 @@
+package util
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Compression is how a file is compressed, picked by its extension
+type Compression string
+
+const (
+	NoCompression Compression = ""
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+// CompressionOf picks the compression of a file by its extension, .gz for gzip and .zst for zstd
+func CompressionOf(filePath string) Compression {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".gz":
+		return Gzip
+	case ".zst":
+		return Zstd
+	default:
+		return NoCompression
+	}
+}
+
+// Compressor is written to like the file it wraps, Flush pushes out everything written so far and Close finishes the
+// compressed stream without closing the file
+type Compressor interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+type uncompressed struct {
+	io.Writer
+}
+
+func (uncompressed) Flush() error {
+	return nil
+}
+
+func (uncompressed) Close() error {
+	return nil
+}
+
+// Writer wraps w in the compressor
+func (c Compression) Writer(w io.Writer) (Compressor, error) {
+	switch c {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return uncompressed{w}, nil
+	}
+}
+
+// ReadFile reads a whole file like os.ReadFile, decompressing it if its extension says it is compressed
+func ReadFile(filePath string) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+	switch CompressionOf(filePath) {
+	case Gzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error: %s is not gzip compressed: %s", filePath, err.Error())
+		}
+		defer gz.Close()
+		reader = gz
+	case Zstd:
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error: %s is not zstd compressed: %s", filePath, err.Error())
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return data, nil
+	}
+
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error: could not decompress %s: %s", filePath, err.Error())
+	}
+	return data, nil
+}
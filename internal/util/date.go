@@ -0,0 +1,63 @@
+package util
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// earliestYear is before the first printed books, so that other four digit numbers in a date aren't taken for years
+const earliestYear = 1450
+
+// yearPattern matches a year and what follows it, which can be the end of a range written with two digits like the 99
+// of 1998/99, or the month of an ISO date like the 05 of 2021-05
+var yearPattern = regexp.MustCompile(`(?:^|[^0-9])([0-9]{4})(?:\s*([-–/])\s*([0-9]{2})(?:[^0-9]|$))?`)
+
+// ParseYears finds the earliest and latest year a publish date mentions. Dates are written in many ways, like 2021,
+// 2021-05, May 2021, [c2021], as a range like 1998-2001 or 1998/99, or listing several editions like "1995, 2nd ed.
+// 2003", so any four digit number that could be a year is taken as one. It reports false if there is none.
+func ParseYears(date string) (uint, uint, bool) {
+	latestYear := uint(time.Now().Year() + 1)
+	var low, high uint
+	add := func(year uint) {
+		if year < earliestYear || year > latestYear {
+			return
+		}
+		if low == 0 || year < low {
+			low = year
+		}
+		high = max(high, year)
+	}
+
+	for _, match := range yearPattern.FindAllStringSubmatch(date, -1) {
+		year, _ := strconv.ParseUint(match[1], 10, 32)
+		add(uint(year))
+		if len(match[3]) == 0 {
+			continue
+		}
+		end, _ := strconv.ParseUint(match[3], 10, 32)
+		// after a dash, two digits that could be a month are one
+		if match[2] == "-" && end >= 1 && end <= 12 {
+			continue
+		}
+		if end > year%100 {
+			add(uint(year - year%100 + end))
+		}
+	}
+	return low, high, low > 0
+}
+
+// copyrightPattern matches a copyright notice and the years that follow it, like "Copyright © 2005, 2012" or "(c)2019"
+var copyrightPattern = regexp.MustCompile(`(?i)(?:copyright|©|\(c\))(?:\s|©|\(c\)|:)*([0-9]{4}(?:\s*(?:[-–,/]|and)\s*[0-9]{2,4})*)`)
+
+// CopyrightYear is the latest year the copyright notices of a text mention, which is when the edition it is of came out
+// since a new edition adds its year to those of the ones before it. It reports false if there is no notice.
+func CopyrightYear(text string) (uint, bool) {
+	var latest uint
+	for _, match := range copyrightPattern.FindAllStringSubmatch(text, -1) {
+		if _, high, ok := ParseYears(match[1]); ok {
+			latest = max(latest, high)
+		}
+	}
+	return latest, latest > 0
+}
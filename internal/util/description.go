@@ -0,0 +1,31 @@
+package util
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// MaxDescriptionLength is how many characters a description is cut down to, enough for a blurb but not a whole chapter
+const MaxDescriptionLength = 1000
+
+var descriptionBreakPattern = regexp.MustCompile(`(?i)</?(?:\w+:)?(?:p|br|div|li|ul|ol|h[1-6])\b[^>]*>`)
+var descriptionTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// ShortDescription makes a plain text blurb out of a description, removing any HTML and cutting it down to
+// MaxDescriptionLength characters at the end of a word
+func ShortDescription(description string) string {
+	description = descriptionBreakPattern.ReplaceAllString(description, " ")
+	description = html.UnescapeString(descriptionTagPattern.ReplaceAllString(description, ""))
+	description = strings.Join(strings.Fields(description), " ")
+	if utf8.RuneCountInString(description) <= MaxDescriptionLength {
+		return description
+	}
+
+	cut := string([]rune(description)[:MaxDescriptionLength])
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
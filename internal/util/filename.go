@@ -0,0 +1,103 @@
+package util
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type FilenameTerms struct {
+	Title       string
+	Author      string
+	Year        uint
+	Asin        book.ASIN
+	Series      string
+	SeriesIndex float64
+}
+
+var filenameYearPattern = regexp.MustCompile(`[(\[]((?:1[5-9]|20)[0-9]{2})[)\]]`)
+var filenameBracketPattern = regexp.MustCompile(`\([^)]*\)|\[[^\]]*]|\{[^}]*}`)
+var filenameWhitespacePattern = regexp.MustCompile(`\s+`)
+
+// Kindle downloads are named after their ASIN, like "B00B7NPRY8_EBOK.azw"
+var filenameAsinPattern = regexp.MustCompile(`\bB0[0-9A-Z]{8}\b(?: EB[A-Z]{2}\b)?`)
+
+// a series and the number of the book in it, like "Discworld 07", "Discworld #7" or "Discworld, Book 7". Only a number
+// marked as one, or of at most 3 digits, is taken, so that years aren't
+var filenameSeriesPattern = regexp.MustCompile(`(?i)^(.*?\pL.*?)(?:,?\s*#\s*|,?\s+(?:book|vol\.?|volume|part|no\.?|tome|band)\s*|\s+)(\d{1,3}(?:\.\d+)?)$`)
+var filenameBracketContentsPattern = regexp.MustCompile(`\(([^)]*)\)|\[([^\]]*)]`)
+
+// parseSeries splits a part of a file name into a series and the number of the book in it
+func parseSeries(s string) (string, float64, bool) {
+	match := filenameSeriesPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return "", 0, false
+	}
+	index, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return strings.TrimSpace(match[1]), index, true
+}
+
+// ParseFilename guesses title, author, year, ASIN and series from common naming schemes like "Author - Title (Year).pdf",
+// "Series 07 - Title.epub" or "Title (Series #7).mobi"
+func ParseFilename(filePath string) FilenameTerms {
+	name := filepath.Base(filePath)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = strings.ReplaceAll(name, "_", " ")
+
+	var terms FilenameTerms
+
+	if match := filenameAsinPattern.FindString(name); len(match) > 0 {
+		terms.Asin = book.ASIN(match[:10])
+		name = strings.Replace(name, match, " ", 1)
+	}
+
+	if match := filenameYearPattern.FindStringSubmatch(name); match != nil {
+		year, err := strconv.ParseUint(match[1], 10, 32)
+		if err == nil {
+			terms.Year = uint(year)
+		}
+	}
+
+	for _, match := range filenameBracketContentsPattern.FindAllStringSubmatch(name, -1) {
+		if series, index, ok := parseSeries(match[1] + match[2]); ok {
+			terms.Series, terms.SeriesIndex = series, index
+			break
+		}
+	}
+
+	name = filenameBracketPattern.ReplaceAllString(name, " ")
+	name = strings.TrimSpace(filenameWhitespacePattern.ReplaceAllString(name, " "))
+
+	parts := strings.Split(name, " - ")
+	if len(terms.Series) == 0 && len(parts) > 1 {
+		// "Series 07 - Title" or "Author - Series 07 - Title"
+		if series, index, ok := parseSeries(parts[0]); ok {
+			terms.Series, terms.SeriesIndex = series, index
+			terms.Title = strings.TrimSpace(strings.Join(parts[1:], " - "))
+			return terms
+		}
+		if series, index, ok := parseSeries(parts[1]); ok && len(parts) > 2 {
+			terms.Series, terms.SeriesIndex = series, index
+			terms.Author = strings.TrimSpace(parts[0])
+			terms.Title = strings.TrimSpace(strings.Join(parts[2:], " - "))
+			return terms
+		}
+	}
+
+	if author, title, found := strings.Cut(name, " - "); found {
+		terms.Author = strings.TrimSpace(author)
+		terms.Title = strings.TrimSpace(title)
+	} else if title, author, found := strings.Cut(name, " by "); found {
+		terms.Title = strings.TrimSpace(title)
+		terms.Author = strings.TrimSpace(author)
+	} else {
+		terms.Title = name
+	}
+
+	return terms
+}
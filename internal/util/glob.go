@@ -0,0 +1,44 @@
+package util
+
+import (
+	"path"
+	"strings"
+)
+
+// matchSegments matches the parts of a glob between its slashes against those of a path, a ** part matching any
+// number of parts of the path
+func matchSegments(patterns []string, names []string) bool {
+	if len(patterns) == 0 {
+		return len(names) == 0
+	}
+	if patterns[0] == "**" {
+		for skip := 0; skip <= len(names); skip++ {
+			if matchSegments(patterns[1:], names[skip:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(names) == 0 {
+		return false
+	}
+	matched, _ := path.Match(patterns[0], names[0])
+	return matched && matchSegments(patterns[1:], names[1:])
+}
+
+// MatchPath matches a glob against the name of the file or directory at rel, a path with forward slashes, or against
+// all of rel if the glob has a slash in it, where ** matches any number of directories. A trailing slash only matches
+// directories.
+func MatchPath(pattern string, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	if !strings.Contains(pattern, "/") {
+		return matchSegments([]string{pattern}, []string{path.Base(rel)})
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
@@ -0,0 +1,159 @@
+package util
+
+import (
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/samber/lo"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// how strongly the surroundings of a digit run suggest it is an ISBN
+const (
+	isbnScoreBare     = 1
+	isbnScoreGrouped  = 2
+	isbnScoreLabelled = 3
+)
+
+// isbnRun matches digit groups joined by single hyphens or spaces, which is how ISBNs are printed
+var isbnRun = regexp.MustCompile(`[0-9]+(?:[-‐‑ ][0-9]+)*(?:[-‐‑ ]?[Xx]\b)?`)
+var isbnSeparator = regexp.MustCompile(`[-‐‑ ]`)
+
+// isbnLabel matches labels like "ISBN", "eISBN:" and "ISBN-13 (ebook):" at the end of the text before a digit run
+var isbnLabel = regexp.MustCompile(`(?i)ISBN(?:[-‐‑ ]?1[03])?s?\s*(?:\([^()\n]{1,20}\))?\s*[:#]?\s*$`)
+
+// otherLabel matches labels of numbers that pass ISBN checksums often enough to be mistaken for one
+var otherLabel = regexp.MustCompile(`(?i)\b(?:LCCN|ISSN|OCLC|control (?:no\.?|number)|phone|tel\.?|fax)\s*[:#]?\s*$`)
+
+// isbnLabelLookback is how far before a digit run a label is looked for
+const isbnLabelLookback = 32
+
+type isbnCandidate struct {
+	value string
+	score int
+}
+
+// hasIsbnGroups reports whether hyphen or space separated groups are laid out the way an ISBN's are, with the check
+// digit on its own and an ISBN-13's prefix first
+func hasIsbnGroups(groups []string, length int) bool {
+	if len(groups) == 1 {
+		return true
+	}
+	if len(groups) > 5 || (len(groups) == 5 && length == 10) {
+		return false
+	}
+	if length == 13 && groups[0] != "978" && groups[0] != "979" {
+		return false
+	}
+	// the prefix can be split off the rest on its own, like "978-1718501263"
+	if length == 13 && len(groups) == 2 {
+		return true
+	}
+	return len(groups[len(groups)-1]) == 1
+}
+
+// scanIsbns finds the 10 and 13 digit sequences in text and ranks them by how likely they are to be ISBNs, closest to
+// an "ISBN" label first. Bare digit runs are dropped when labelled ones were found, which keeps LCCNs, phone numbers
+// and the like out of copyright pages.
+func scanIsbns(text string) []isbnCandidate {
+	candidates := make([]isbnCandidate, 0)
+	labelled := false
+
+	for _, run := range isbnRun.FindAllStringIndex(text, -1) {
+		groups := isbnSeparator.Split(text[run[0]:run[1]], -1)
+		offsets := []int{run[0]}
+		for _, separator := range isbnSeparator.FindAllStringIndex(text[run[0]:run[1]], -1) {
+			offsets = append(offsets, run[0]+separator[1])
+		}
+
+		for start := 0; start < len(groups); {
+			// the longest window of groups that is laid out like an ISBN, so an ISBN-13 wins over the ten digits it starts with
+			end := -1
+			for i, length := start, 0; i < len(groups) && length < 13; i++ {
+				length += len(groups[i])
+				if (length == 10 || length == 13) && hasIsbnGroups(groups[start:i+1], length) {
+					end = i
+				}
+			}
+			if end < 0 {
+				start++
+				continue
+			}
+
+			window := groups[start : end+1]
+			value := strings.ToUpper(strings.Join(window, ""))
+			before := text[max(0, offsets[start]-isbnLabelLookback):offsets[start]]
+			switch {
+			case otherLabel.MatchString(before):
+			case isbnLabel.MatchString(before):
+				candidates = append(candidates, isbnCandidate{value, isbnScoreLabelled})
+				labelled = true
+			case len(window) > 1 || (len(value) == 13 && (strings.HasPrefix(value, "978") || strings.HasPrefix(value, "979"))):
+				candidates = append(candidates, isbnCandidate{value, isbnScoreGrouped})
+			default:
+				candidates = append(candidates, isbnCandidate{value, isbnScoreBare})
+			}
+			start = end + 1
+		}
+	}
+
+	if labelled {
+		candidates = lo.Filter(candidates, func(c isbnCandidate, _ int) bool {
+			return c.score > isbnScoreBare
+		})
+	}
+	// among equally likely ones, an ISBN printed more than once is more likely the book's own
+	counts := lo.CountValuesBy(candidates, func(c isbnCandidate) string {
+		return c.value
+	})
+	slices.SortStableFunc(candidates, func(a, b isbnCandidate) int {
+		if a.score != b.score {
+			return b.score - a.score
+		}
+		return counts[b.value] - counts[a.value]
+	})
+	return candidates
+}
+
+func identifyIsbns[I comparable](text string, length int, maker func(string) (I, bool)) []I {
+	return lo.Uniq(lo.FilterMap(scanIsbns(text), func(c isbnCandidate, _ int) (I, bool) {
+		if len(c.value) != length || !book.IsIsbnCandidate(c.value) {
+			var none I
+			return none, false
+		}
+		return maker(c.value)
+	}))
+}
+
+// IdentifyIsbns returns the valid ISBN-10s and ISBN-13s in text together, the most likely ones first
+func IdentifyIsbns(text string) []book.ISBN {
+	return lo.Uniq(lo.FilterMap(scanIsbns(text), func(c isbnCandidate, _ int) (book.ISBN, bool) {
+		if !book.IsIsbnCandidate(c.value) {
+			return "", false
+		}
+		switch len(c.value) {
+		case 10:
+			isbn := book.ISBN10(c.value)
+			return book.ISBN(isbn), isbn.IsValid()
+		default:
+			isbn := book.ISBN13(c.value)
+			return book.ISBN(isbn), isbn.IsValid()
+		}
+	}))
+}
+
+// IdentifyIsbn10s returns the valid ISBN-10s in text, the most likely ones first
+func IdentifyIsbn10s(text string) []book.ISBN10 {
+	return identifyIsbns(text, 10, func(s string) (book.ISBN10, bool) {
+		isbn := book.ISBN10(s)
+		return isbn, isbn.IsValid()
+	})
+}
+
+// IdentifyIsbn13s returns the valid ISBN-13s in text, the most likely ones first
+func IdentifyIsbn13s(text string) []book.ISBN13 {
+	return identifyIsbns(text, 13, func(s string) (book.ISBN13, bool) {
+		isbn := book.ISBN13(s)
+		return isbn, isbn.IsValid()
+	})
+}
@@ -2,7 +2,8 @@ package util
 
 import (
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"strings"
 	"sync"
@@ -18,32 +19,40 @@ type JsonStreamWriter[I any] struct {
 	Input          chan JsonStreamWriterItem
 	waiter         sync.WaitGroup
 	fh             *os.File
+	out            Compressor
 	lock           sync.Mutex
 	isInitialized  bool
 	batchThreshold int
 	convert        func(I) (JsonStreamWriterItem, error)
+	closeOnce      sync.Once
 }
 
-func NewJsonStreamWriter[I any](filePath string, convert func(I) (JsonStreamWriterItem, error)) (*JsonStreamWriter[I], error) {
+func NewJsonStreamWriter[I any](filePath string, compression Compression, convert func(I) (JsonStreamWriterItem, error)) (*JsonStreamWriter[I], error) {
 	fh, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return nil, err
 	}
+	out, err := compression.Writer(fh)
+	if err != nil {
+		fh.Close()
+		return nil, err
+	}
 	stream := &JsonStreamWriter[I]{
 		Filepath:       filePath,
 		Input:          make(chan JsonStreamWriterItem, 10000),
 		waiter:         sync.WaitGroup{},
 		fh:             fh,
+		out:            out,
 		lock:           sync.Mutex{},
 		isInitialized:  false,
 		batchThreshold: 10,
 		convert:        convert,
 	}
-	_, err = stream.fh.WriteString("{")
+	_, err = io.WriteString(stream.out, "{")
 	if err != nil {
 		return nil, err
 	}
-	err = stream.fh.Sync()
+	err = stream.sync()
 	if err != nil {
 		return nil, err
 	}
@@ -70,6 +79,15 @@ func (stream *JsonStreamWriter[I]) writer() {
 	}
 }
 
+// sync pushes everything written so far through the compressor and onto the disk
+func (stream *JsonStreamWriter[I]) sync() error {
+	err := stream.out.Flush()
+	if err != nil {
+		return err
+	}
+	return stream.fh.Sync()
+}
+
 func formatBuffer(key string, data []byte, initialized bool) string {
 	if initialized {
 		return fmt.Sprintf(",\"%s\": %s", strings.ReplaceAll(key, "\"", ""), string(data))
@@ -84,12 +102,12 @@ func (stream *JsonStreamWriter[I]) WriteItem(key string, data []byte) error {
 	s := formatBuffer(key, data, stream.isInitialized)
 	stream.isInitialized = true
 
-	_, err := stream.fh.WriteString(s)
+	_, err := io.WriteString(stream.out, s)
 	if err != nil {
 		return err
 	}
 
-	return stream.fh.Sync()
+	return stream.sync()
 }
 
 func (stream *JsonStreamWriter[I]) WriteBatch(items []*JsonStreamWriterItem) error {
@@ -99,28 +117,29 @@ func (stream *JsonStreamWriter[I]) WriteBatch(items []*JsonStreamWriterItem) err
 	for _, item := range items {
 		s := formatBuffer(item.Key, item.Data, stream.isInitialized)
 		stream.isInitialized = true
-		_, err := stream.fh.WriteString(s)
+		_, err := io.WriteString(stream.out, s)
 		if err != nil {
 			return err
 		}
 	}
 
-	return stream.fh.Sync()
+	return stream.sync()
 }
 
 func (stream *JsonStreamWriter[I]) WriteObject(obj I) {
 	item, err := stream.convert(obj)
 	if err != nil {
-		log.Printf("warning: could not write item to json stream because conversion failed: %s\n", err.Error())
+		slog.Warn("could not write item to json stream because conversion failed", "error", err)
 	}
 	stream.Input <- item
 }
 
+// Close writes the items left and the closing bracket, it is safe to call more than once
 func (stream *JsonStreamWriter[I]) Close() {
-	if stream.Input == nil {
-		return
-	}
+	stream.closeOnce.Do(stream.close)
+}
 
+func (stream *JsonStreamWriter[I]) close() {
 	close(stream.Input)
 
 	stream.waiter.Wait()
@@ -128,18 +147,21 @@ func (stream *JsonStreamWriter[I]) Close() {
 	stream.lock.Lock()
 	defer stream.lock.Unlock()
 
-	_, err := stream.fh.WriteString("}")
+	_, err := io.WriteString(stream.out, "}")
+	if err == nil {
+		err = stream.out.Close()
+	}
 	if err != nil {
-		log.Printf("error: failed to write closing bracket: %s\n", err.Error())
+		slog.Error("failed to write closing bracket", "error", err)
 		return
 	}
 	err = stream.fh.Sync()
 	if err != nil {
-		log.Printf("error: failed to sync, bracket might not be committed to file: %s\n", err.Error())
+		slog.Error("failed to sync, bracket might not be committed to file", "error", err)
 	}
 
 	err = stream.fh.Close()
 	if err != nil {
-		log.Printf("error: failed to close file handle: %s\n", err.Error())
+		slog.Error("failed to close file handle", "error", err)
 	}
 }
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageSampleRunes is how much of a text is looked at to detect its language, which is plenty and keeps whole books
+// from being split into words
+const languageSampleRunes = 20000
+
+// minStopwords is how many of a language's most common words a text needs before it is said to be in that language
+const minStopwords = 10
+
+// stopwords are the most common short words of each language written in the Latin script, picked so that few of them
+// are shared between languages
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "that", "it", "with", "for", "was", "this", "you", "which", "are", "be"},
+	"fr": {"le", "les", "des", "est", "et", "une", "dans", "qui", "pour", "pas", "sur", "au", "du", "avec", "sont"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ein", "sich", "mit", "auf", "den", "dem", "zu", "auch", "wird"},
+	"es": {"el", "los", "las", "del", "que", "por", "una", "con", "para", "y", "al", "lo", "como", "más", "pero"},
+	"it": {"il", "che", "di", "della", "per", "non", "una", "sono", "gli", "nel", "anche", "alla", "come", "più", "delle"},
+	"pt": {"os", "que", "não", "uma", "do", "da", "em", "para", "com", "dos", "das", "mais", "como", "ao", "foi"},
+	"nl": {"het", "een", "van", "niet", "dat", "zijn", "op", "voor", "met", "ook", "maar", "wordt", "naar", "bij", "deze"},
+	"sv": {"och", "att", "det", "som", "för", "med", "är", "inte", "på", "av", "till", "den", "har", "om", "ett"},
+	"pl": {"nie", "się", "jest", "że", "na", "do", "jak", "po", "tak", "przez", "od", "ale", "dla", "czy", "jego"},
+}
+
+var stopwordLanguages = invertStopwords()
+
+func invertStopwords() map[string][]string {
+	languages := make(map[string][]string)
+	for language, words := range stopwords {
+		for _, word := range words {
+			languages[word] = append(languages[word], language)
+		}
+	}
+	return languages
+}
+
+// scriptLanguage names the language of a text written mostly in a script used by only one language, or one language
+// far more than the others
+func scriptLanguage(counts map[*unicode.RangeTable]int, letters int, text string) string {
+	switch {
+	// Japanese mixes kana in with the Chinese characters it borrows
+	case counts[unicode.Hiragana]+counts[unicode.Katakana] > letters/10:
+		return "ja"
+	case counts[unicode.Hangul] > letters/2:
+		return "ko"
+	case counts[unicode.Han] > letters/2:
+		return "zh"
+	case counts[unicode.Cyrillic] > letters/2:
+		if strings.ContainsAny(text, "іїєґІЇЄҐ") {
+			return "uk"
+		}
+		return "ru"
+	case counts[unicode.Greek] > letters/2:
+		return "el"
+	case counts[unicode.Arabic] > letters/2:
+		return "ar"
+	case counts[unicode.Hebrew] > letters/2:
+		return "he"
+	case counts[unicode.Thai] > letters/2:
+		return "th"
+	case counts[unicode.Devanagari] > letters/2:
+		return "hi"
+	}
+	return ""
+}
+
+var scripts = []*unicode.RangeTable{
+	unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Han, unicode.Cyrillic, unicode.Greek, unicode.Arabic,
+	unicode.Hebrew, unicode.Thai, unicode.Devanagari,
+}
+
+// DetectLanguage guesses the ISO 639-1 code of the language a text is written in, or returns an empty string if it
+// can't tell. Texts in a script of their own are told apart by it, and those in the Latin script by their most common
+// words.
+func DetectLanguage(text string) string {
+	if runes := []rune(text); len(runes) > languageSampleRunes {
+		text = string(runes[:languageSampleRunes])
+	}
+
+	counts := make(map[*unicode.RangeTable]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, script := range scripts {
+			if unicode.Is(script, r) {
+				counts[script]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	if language := scriptLanguage(counts, letters, text); len(language) > 0 {
+		return language
+	}
+
+	hits := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		for _, language := range stopwordLanguages[word] {
+			hits[language]++
+		}
+	}
+
+	best, runnerUp := "", 0
+	for language, count := range hits {
+		if count > hits[best] {
+			runnerUp = max(runnerUp, hits[best])
+			best = language
+		} else {
+			runnerUp = max(runnerUp, count)
+		}
+	}
+	// a text mixing languages, like a translation printed alongside its original, has no one language to give
+	if hits[best] < minStopwords || float64(hits[best]) < 1.5*float64(runnerUp) {
+		return ""
+	}
+	return best
+}
+
+// PrimaryLanguage reduces a language tag like "en-US" or "EN_gb" to its lowercase ISO 639 code, "en"
+func PrimaryLanguage(tag string) string {
+	tag, _, _ = strings.Cut(strings.TrimSpace(tag), "-")
+	tag, _, _ = strings.Cut(tag, "_")
+	return strings.ToLower(tag)
+}
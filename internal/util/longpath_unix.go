@@ -0,0 +1,8 @@
+//go:build !windows
+
+package util
+
+// LongPath is p, since only Windows limits how long a path can be
+func LongPath(p string) string {
+	return p
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package util
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is how long a path can be before Windows needs the \\?\ prefix for it, which is MAX_PATH less room for an 8.3
+// file name, since that is the limit for a directory
+const maxPath = 248
+
+// LongPath is p with the \\?\ prefix if it is too long for Windows otherwise, for handing to other programs. Go adds the
+// prefix by itself for the files it opens, but a program given a long path as an argument fails to open it.
+func LongPath(p string) string {
+	if len(p) < maxPath || !filepath.IsAbs(p) || strings.HasPrefix(p, `\\?\`) {
+		return p
+	}
+	p = filepath.Clean(p)
+	// \\nas\share\books is \\?\UNC\nas\share\books
+	if strings.HasPrefix(p, `\\`) {
+		return `\\?\UNC\` + p[2:]
+	}
+	return `\\?\` + p
+}
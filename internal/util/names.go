@@ -0,0 +1,168 @@
+package util
+
+import (
+	"strings"
+	"unicode"
+)
+
+// nameTitles are the honorifics put before names, which aren't part of them
+var nameTitles = map[string]struct{}{
+	"dr": {}, "prof": {}, "professor": {}, "mr": {}, "mrs": {}, "ms": {}, "miss": {}, "sir": {}, "dame": {}, "rev": {},
+}
+
+// nameDegrees are the degrees put after names, which aren't part of them either
+var nameDegrees = map[string]struct{}{
+	"phd": {}, "md": {}, "dphil": {}, "msc": {}, "bsc": {}, "mba": {}, "frs": {},
+}
+
+// nameSuffixes are part of a name but come after the family name, so "Smith, Jr." isn't Jr. Smith
+var nameSuffixes = map[string]struct{}{
+	"jr": {}, "sr": {}, "ii": {}, "iii": {}, "iv": {},
+}
+
+// nameParticles belong with the family name but are written lowercase, and sorted after the given names, like the van
+// of "Beethoven, Ludwig van"
+var nameParticles = map[string]struct{}{
+	"van": {}, "von": {}, "de": {}, "der": {}, "den": {}, "da": {}, "di": {}, "du": {}, "del": {}, "della": {}, "la": {},
+	"le": {}, "ter": {}, "ten": {},
+}
+
+// companyWords end the names of companies, which have commas in them without being inverted
+var companyWords = map[string]struct{}{
+	"inc": {}, "ltd": {}, "llc": {}, "corp": {}, "co": {}, "gmbh": {}, "ag": {}, "plc": {},
+}
+
+// givenNames reports whether a part of a name after its comma looks like given names, which are capitalized apart
+// from particles, rather than a description like the "Queen of Scots" of "Mary, Queen of Scots"
+func givenNames(part string) bool {
+	for _, word := range strings.Fields(part) {
+		if _, particle := nameParticles[nameWord(word)]; particle {
+			continue
+		}
+		if first := []rune(word)[0]; !unicode.IsUpper(first) {
+			return false
+		}
+	}
+	return true
+}
+
+// nameWord is what a word of a name is compared by, without its case, accents or full stops
+func nameWord(word string) string {
+	return strings.ReplaceAll(fold(word), ".", "")
+}
+
+// spaceInitials puts a space after every full stop followed by a letter, so that J.R.R. and J. R. R. are the same
+func spaceInitials(name string) string {
+	var spaced strings.Builder
+	runes := []rune(name)
+	for idx, r := range runes {
+		spaced.WriteRune(r)
+		if r == '.' && idx+1 < len(runes) && unicode.IsLetter(runes[idx+1]) {
+			spaced.WriteRune(' ')
+		}
+	}
+	return spaced.String()
+}
+
+// capitalize turns a name written in capitals, like catalogs often do, into one that isn't
+func capitalize(name string) string {
+	if strings.ToUpper(name) != name || strings.ToLower(name) == name {
+		return name
+	}
+	words := strings.Fields(name)
+	for idx, word := range words {
+		runes := []rune(strings.ToLower(word))
+		// initials stay as they are
+		if len(runes) > 1 && runes[1] != '.' {
+			runes[0] = unicode.ToUpper(runes[0])
+			words[idx] = string(runes)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// NormalizeAuthor writes a name the same way whichever source it came from: given names first, like "John Smith" for
+// "Smith, John", without honorifics like Dr. or degrees like PhD, with initials spaced out and not in capitals
+func NormalizeAuthor(name string) string {
+	parts := strings.Split(name, ",")
+	suffixes := make([]string, 0)
+	names := make([]string, 0, len(parts))
+	for _, part := range parts {
+		words := make([]string, 0)
+		for _, word := range strings.Fields(part) {
+			// before the initials are spaced out, which would split Ph.D.
+			key := nameWord(word)
+			if _, title := nameTitles[key]; title {
+				continue
+			}
+			if _, degree := nameDegrees[key]; degree {
+				continue
+			}
+			words = append(words, strings.Fields(spaceInitials(word))...)
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if _, company := companyWords[nameWord(words[len(words)-1])]; company {
+			return strings.Join(strings.Fields(name), " ")
+		}
+		if _, suffix := nameSuffixes[nameWord(words[0])]; suffix && len(words) == 1 {
+			suffixes = append(suffixes, words[0])
+			continue
+		}
+		names = append(names, strings.Join(words, " "))
+	}
+
+	// "Smith, John" is inverted, but "John Smith, Jr." and "Mary, Queen of Scots" aren't
+	if len(names) != 2 || !givenNames(names[1]) {
+		return capitalize(strings.Join(append([]string{strings.Join(names, ", ")}, suffixes...), " "))
+	}
+	return capitalize(strings.Join(append([]string{names[1], names[0]}, suffixes...), " "))
+}
+
+// NormalizeAuthors normalizes each of names and drops those that are the same person as one before them, spelled with
+// or without accents or initials spaced differently
+func NormalizeAuthors(names []string) []string {
+	if len(names) == 0 {
+		return names
+	}
+	normalized := make([]string, 0, len(names))
+	seen := make(map[string]struct{})
+	for _, name := range names {
+		name = NormalizeAuthor(name)
+		key := strings.Join(strings.Fields(nameWord(name)), " ")
+		if _, duplicate := seen[key]; duplicate || len(key) == 0 {
+			continue
+		}
+		seen[key] = struct{}{}
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
+
+// InvertAuthor writes a normalized name family name first, like "Smith, John" or "Beethoven, Ludwig van", for sorting
+// by. A name of a single word, or that of a company, is left as it is.
+func InvertAuthor(name string) string {
+	// one that still has a comma after normalizing can't be told apart
+	if strings.Contains(name, ",") {
+		return name
+	}
+	words := strings.Fields(name)
+	suffixes := make([]string, 0)
+	for len(words) > 1 {
+		if _, suffix := nameSuffixes[nameWord(words[len(words)-1])]; !suffix {
+			break
+		}
+		suffixes = append([]string{words[len(words)-1]}, suffixes...)
+		words = words[:len(words)-1]
+	}
+	if len(words) < 2 {
+		return name
+	}
+
+	inverted := words[len(words)-1] + ", " + strings.Join(words[:len(words)-1], " ")
+	if len(suffixes) > 0 {
+		inverted += ", " + strings.Join(suffixes, " ")
+	}
+	return inverted
+}
@@ -0,0 +1,152 @@
+package util
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// tokenMatch is how similar two words have to be, by Jaro-Winkler, to be taken for the same word misspelled or spelled
+// another way, like "color" and "colour"
+const tokenMatch = 0.9
+
+// titleStopwords are left out when comparing titles, since they are dropped or moved by whoever names a file. The
+// single letters are French and Italian articles cut short, like the l' of L'Étranger
+var titleStopwords = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "at": {}, "by": {}, "for": {}, "from": {}, "in": {}, "into": {}, "of": {}, "on": {},
+	"or": {}, "the": {}, "to": {}, "with": {},
+	"d": {}, "das": {}, "de": {}, "del": {}, "der": {}, "des": {}, "die": {}, "du": {}, "el": {}, "et": {}, "l": {},
+	"la": {}, "le": {}, "les": {}, "los": {}, "und": {}, "y": {},
+}
+
+// fold lowercases s and takes the accents off its letters, so that words differing only in those compare equal
+func fold(s string) string {
+	folded := make([]rune, 0, len(s))
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			folded = append(folded, unicode.ToLower(r))
+		}
+	}
+	return string(folded)
+}
+
+// TitleTokens splits a title into the words that tell it apart, lowercased, without accents and without stopwords,
+// unless the title is nothing but stopwords
+func TitleTokens(title string) []string {
+	tokens := strings.FieldsFunc(fold(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	words := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if _, stopword := titleStopwords[token]; !stopword {
+			words = append(words, token)
+		}
+	}
+	if len(words) == 0 {
+		return tokens
+	}
+	return words
+}
+
+// FilenameTitle is what a file name says the title of its book is, to compare the titles of results against
+func FilenameTitle(filePath string) string {
+	if title := ParseFilename(filePath).Title; len(title) > 0 {
+		return title
+	}
+	name := filepath.Base(filePath)
+	return strings.ReplaceAll(strings.TrimSuffix(name, filepath.Ext(name)), "_", " ")
+}
+
+// TitleSimilarity is how alike two titles are from 0 to 1, as the share of their words they have in common regardless
+// of order, where words that are nearly the same count for how alike they are
+func TitleSimilarity(a, b string) float64 {
+	aTokens, bTokens := TitleTokens(a), TitleTokens(b)
+	if len(aTokens) == 0 || len(bTokens) == 0 {
+		return 0
+	}
+
+	used := make([]bool, len(bTokens))
+	shared := 0.0
+	for _, aToken := range aTokens {
+		best, bestIdx := 0.0, -1
+		for idx, bToken := range bTokens {
+			if used[idx] {
+				continue
+			}
+			if similarity := tokenSimilarity(aToken, bToken); similarity > best {
+				best, bestIdx = similarity, idx
+			}
+		}
+		if best >= tokenMatch {
+			used[bestIdx] = true
+			shared += best
+		}
+	}
+	return 2 * shared / float64(len(aTokens)+len(bTokens))
+}
+
+// tokenSimilarity is how alike two words are, where numbers, like volumes and years, are only alike when they are equal
+func tokenSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if strings.ContainsFunc(a, unicode.IsDigit) || strings.ContainsFunc(b, unicode.IsDigit) {
+		return 0
+	}
+	return JaroWinkler(a, b)
+}
+
+// JaroWinkler is the Jaro-Winkler similarity of two strings from 0 to 1, which favours strings that start the same
+// https://en.wikipedia.org/wiki/Jaro%E2%80%93Winkler_distance
+func JaroWinkler(a, b string) float64 {
+	aRunes, bRunes := []rune(a), []rune(b)
+	if len(aRunes) == 0 && len(bRunes) == 0 {
+		return 1
+	}
+	if len(aRunes) == 0 || len(bRunes) == 0 {
+		return 0
+	}
+
+	window := max(max(len(aRunes), len(bRunes))/2-1, 0)
+	aMatched := make([]bool, len(aRunes))
+	bMatched := make([]bool, len(bRunes))
+	matches := 0
+	for i, r := range aRunes {
+		for j := max(0, i-window); j < min(len(bRunes), i+window+1); j++ {
+			if !bMatched[j] && bRunes[j] == r {
+				aMatched[i], bMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i, r := range aRunes {
+		if !aMatched[i] {
+			continue
+		}
+		for !bMatched[j] {
+			j++
+		}
+		if r != bRunes[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(aRunes)) + m/float64(len(bRunes)) + (m-float64(transpositions/2))/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(aRunes), len(bRunes)) && aRunes[prefix] == bRunes[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
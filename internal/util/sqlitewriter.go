@@ -0,0 +1,168 @@
+package util
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/larkwiot/booker/internal/book"
+	"log/slog"
+	_ "modernc.org/sqlite"
+	"strings"
+	"sync"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS books (
+	filepath     TEXT NOT NULL,
+	title        TEXT,
+	authors      TEXT,
+	isbn10       TEXT,
+	isbn13       TEXT,
+	uom          TEXT,
+	low_year     INTEGER,
+	high_year    INTEGER,
+	publish_date TEXT,
+	publisher    TEXT,
+	error        TEXT,
+	data         TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS books_filepath ON books (filepath);
+CREATE INDEX IF NOT EXISTS books_isbn10 ON books (isbn10);
+CREATE INDEX IF NOT EXISTS books_isbn13 ON books (isbn13);
+`
+
+const sqliteInsert = `
+INSERT OR REPLACE INTO books (filepath, title, authors, isbn10, isbn13, uom, low_year, high_year, publish_date, publisher, error, data)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+// SqliteWriter writes books into a "books" table, with the full JSON record kept in the data column
+type SqliteWriter struct {
+	Filepath       string
+	Input          chan *book.Book
+	waiter         sync.WaitGroup
+	db             *sql.DB
+	batchThreshold int
+	closeOnce      sync.Once
+}
+
+func NewSqliteWriter(filePath string) (*SqliteWriter, error) {
+	db, err := sql.Open("sqlite", filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(sqliteSchema)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	writer := &SqliteWriter{
+		Filepath:       filePath,
+		Input:          make(chan *book.Book, 10000),
+		waiter:         sync.WaitGroup{},
+		db:             db,
+		batchThreshold: 100,
+	}
+
+	writer.waiter.Add(1)
+	go writer.writer()
+
+	return writer, nil
+}
+
+func (writer *SqliteWriter) writer() {
+	defer writer.waiter.Done()
+
+	for {
+		bk, isOpen := <-writer.Input
+		if !isOpen {
+			return
+		}
+
+		// group whatever is already queued into a single transaction
+		batch := []*book.Book{bk}
+	drain:
+		for len(batch) < writer.batchThreshold {
+			select {
+			case bk, isOpen := <-writer.Input:
+				if !isOpen {
+					break drain
+				}
+				batch = append(batch, bk)
+			default:
+				break drain
+			}
+		}
+
+		err := writer.WriteBatch(batch)
+		if err != nil {
+			slog.Error("failed to write books to sqlite database", "path", writer.Filepath, "books", len(batch), "error", err)
+		}
+	}
+}
+
+func (writer *SqliteWriter) WriteBatch(books []*book.Book) error {
+	tx, err := writer.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	statement, err := tx.Prepare(sqliteInsert)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer statement.Close()
+
+	for _, bk := range books {
+		data, err := json.Marshal(bk)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = statement.Exec(
+			bk.Filepath,
+			bk.Title,
+			strings.Join(bk.Authors, "; "),
+			string(bk.Isbn10),
+			string(bk.Isbn13),
+			bk.Uom,
+			bk.LowYear,
+			bk.HighYear,
+			bk.PublishDate,
+			bk.Publisher,
+			bk.ErrorMessage,
+			string(data),
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (writer *SqliteWriter) WriteObject(bk *book.Book) {
+	// copy so callers are free to reuse their book
+	cp := *bk
+	writer.Input <- &cp
+}
+
+// Close writes the books left and closes the database, it is safe to call more than once
+func (writer *SqliteWriter) Close() {
+	writer.closeOnce.Do(writer.close)
+}
+
+func (writer *SqliteWriter) close() {
+	close(writer.Input)
+
+	writer.waiter.Wait()
+
+	err := writer.db.Close()
+	if err != nil {
+		slog.Error("failed to close sqlite database", "path", writer.Filepath, "error", err)
+	}
+}
@@ -1,90 +1,123 @@
 package util
 
 import (
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/larkwiot/booker/internal/book"
 	"github.com/samber/lo"
+	"io"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
 
-const Isbn10Pattern = "([0-9\\-\\s]+[0-9Xx])"
-const Isbn13Pattern = "([0-9\\-\\s]+[0-9])"
-
-func identifyIsbns[I any](text string, pattern string, maker func(string) I) []I {
-	ws := regexp.MustCompile("[\\s\\-]+")
-	identifier := regexp.MustCompile(pattern)
-	occurrences := identifier.FindAllString(text, -1)
-	return lo.FilterMap(occurrences, func(occ string, _ int) (I, bool) {
-		clean := string(ws.ReplaceAll([]byte(occ), []byte("")))
-		if book.IsIsbnCandidate(clean) {
-			isbn := maker(strings.ToUpper(clean))
-			return isbn, true
-		}
-		return maker(""), false
-	})
+const DoiPattern = `(?i)\b10\.[0-9]{4,9}/[-._;()/:a-z0-9]+`
+
+var doiIdentifier = regexp.MustCompile(DoiPattern)
+
+// asinIdentifier only matches labelled ASINs, since a bare one is indistinguishable from any other code
+var asinIdentifier = regexp.MustCompile(`\bASIN:?\s*(B0[0-9A-Z]{8}|[0-9]{9}[0-9X])\b`)
+
+// arxivIdentifier only matches labelled arXiv identifiers and links to them, since a bare new style one looks like any
+// decimal number
+var arxivIdentifier = regexp.MustCompile(`(?i)(?:\barxiv:\s*|\barxiv\.org/(?:abs|pdf)/)([a-z-]+(?:\.[a-z]{2})?/[0-9]{7}|[0-9]{4}\.[0-9]{4,5})(?:v[0-9]+)?\b`)
+
+func IdentifyDois(text string) []book.DOI {
+	return lo.Uniq(lo.Map(doiIdentifier.FindAllString(text, -1), func(occ string, _ int) book.DOI {
+		// DOIs are case-insensitive and sentence punctuation commonly follows them
+		return book.DOI(strings.ToLower(strings.TrimRight(occ, ".,;:)")))
+	}))
 }
 
-func IdentifyIsbn10s(text string) []book.ISBN10 {
-	return lo.Filter(identifyIsbns(text, Isbn10Pattern, func(s string) book.ISBN10 {
-		return book.ISBN10(s)
-	}), func(isbn book.ISBN10, _ int) bool {
-		return isbn.IsValid()
-	})
+func IdentifyAsins(text string) []book.ASIN {
+	return lo.Uniq(lo.FilterMap(asinIdentifier.FindAllStringSubmatch(text, -1), func(match []string, _ int) (book.ASIN, bool) {
+		asin := book.ASIN(match[1])
+		return asin, asin.IsValid()
+	}))
 }
 
-func IdentifyIsbn13s(text string) []book.ISBN13 {
-	return lo.Filter(identifyIsbns(text, Isbn13Pattern, func(s string) book.ISBN13 {
-		return book.ISBN13(s)
-	}), func(isbn book.ISBN13, _ int) bool {
-		return isbn.IsValid()
-	})
+// IdentifyArxivs finds the arXiv identifiers in text, which arXiv prints down the margin of the first page of every paper
+func IdentifyArxivs(text string) []book.ArxivId {
+	return lo.Uniq(lo.Map(arxivIdentifier.FindAllStringSubmatch(text, -1), func(match []string, _ int) book.ArxivId {
+		return book.ArxivId(match[1])
+	}))
 }
 
+// LevenshteinDistance is how many characters have to be inserted, deleted or substituted to turn a into b, counting
+// characters rather than bytes so that an accented letter is one
 // https://en.wikipedia.org/wiki/Levenshtein_distance#Iterative_with_two_matrix_rows
 func LevenshteinDistance(a, b string) int {
-	m := len(a)
-	n := len(b)
+	aRunes, bRunes := []rune(a), []rune(b)
 
-	previousDistances := make([]int, n)
-	currentDistances := make([]int, n)
+	previousDistances := make([]int, len(bRunes)+1)
+	currentDistances := make([]int, len(bRunes)+1)
 
-	for i := 0; i < n; i++ {
-		previousDistances[i] = i
+	for j := range previousDistances {
+		previousDistances[j] = j
 	}
 
-	for i := 0; i < m-1; i++ {
+	for i, aRune := range aRunes {
 		currentDistances[0] = i + 1
 
-		for j := 0; j < n-1; j++ {
+		for j, bRune := range bRunes {
 			deletionCost := previousDistances[j+1] + 1
 			insertionCost := currentDistances[j] + 1
-			var substitutionCost int
-			if a[i] == b[j] {
-				substitutionCost = previousDistances[j]
-			} else {
-				substitutionCost = previousDistances[j] + 1
+			substitutionCost := previousDistances[j]
+			if aRune != bRune {
+				substitutionCost++
 			}
 
 			currentDistances[j+1] = min(deletionCost, insertionCost, substitutionCost)
 		}
 
-		previousDistances = currentDistances
+		previousDistances, currentDistances = currentDistances, previousDistances
 	}
 
-	return previousDistances[n-1]
+	return previousDistances[len(bRunes)]
 }
 
-func ClearTermLineString() string {
-	return fmt.Sprintf("\r%s\r", strings.Repeat(" ", 80))
+// ExpandUser replaces a leading ~ with the home directory of the current user, as in ~/books or ~\books on Windows.
+// Paths like ~bob/books are left as they are, and so is everything if there is no home directory.
+func ExpandUser(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") && !strings.HasPrefix(p, "~"+string(filepath.Separator)) {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, p[1:])
 }
 
-func ExpandUser(p string) string {
-	if strings.HasPrefix(p, "~") {
-		return os.Getenv("HOME") + p[1:]
+// AbsPath is p made absolute after expanding ~. On Windows the drive letter is upper cased, since c:\books and
+// C:\books are the same directory and the path of a book has to be recorded the same way every time.
+func AbsPath(p string) (string, error) {
+	abs, err := filepath.Abs(ExpandUser(p))
+	if err != nil {
+		return "", err
+	}
+	if volume := filepath.VolumeName(abs); len(volume) == 2 && volume[1] == ':' {
+		abs = strings.ToUpper(volume) + abs[2:]
 	}
-	return p
+	return abs, nil
+}
+
+// HashFile returns the hex encoded SHA-256 of the file's contents
+func HashFile(p string) (string, error) {
+	fh, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, fh)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 func PathExists(p string) (bool, error) {
@@ -96,3 +129,10 @@ type ObjectWriter[I any] interface {
 	WriteObject(I)
 	Close()
 }
+
+// DiscardWriter drops everything written to it, for runs that don't write an output
+type DiscardWriter[I any] struct{}
+
+func (DiscardWriter[I]) WriteObject(I) {}
+
+func (DiscardWriter[I]) Close() {}
@@ -4,25 +4,342 @@ import (
 	"github.com/larkwiot/booker/internal/book"
 	"github.com/larkwiot/booker/internal/util"
 	"github.com/stretchr/testify/assert"
-	"regexp"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"testing/quick"
+	"unicode/utf8"
 )
 
 const howToHackLikeAGhost = "            <p>ISBN-13: 978-1-7185-0126-3 (print) \nISBN-13: 978-1-7185-0127-0 (ebook)\n</p>\nIdentifiers: LCCN 2020052503 (print) | LCCN 2020052504 (ebook) | ISBN \n   9781718501263 (paperback) | ISBN 1718501269 (paperback) | ISBN \n   9781718501270 (ebook)  \nSubjects: LCSH: Computer networks--Security measures. | Hacking. | Cloud \n   computing--Security measures. | Penetration testing (Computer networks) \nClassification: LCC TK5105.59 .F624 2021  (print) | LCC TK5105.59  (ebook) \n   | DDC 005.8/7--dc23 \nLC record available at https://lccn.loc.gov/2020052503\nLC ebook record available at https://lccn.loc.gov/2020052504\n</p>"
 
-func TestRegexes(t *testing.T) {
-	matches := regexp.MustCompile(util.Isbn10Pattern).FindAllString(howToHackLikeAGhost, -1)
-	assert.Equal(t, 18, len(matches))
+func TestIdentifyIsbn10s(t *testing.T) {
+	isbns := util.IdentifyIsbn10s(howToHackLikeAGhost)
+	assert.Equal(t, []book.ISBN10{"1718501269"}, isbns)
+
+	// without any labels, bare digit runs are all there is to go on
+	isbns = util.IdentifyIsbn10s("Printed in 2021. 0-306-40615-2 1718501269")
+	assert.Equal(t, []book.ISBN10{"0306406152", "1718501269"}, isbns)
 
-	matches = regexp.MustCompile(util.Isbn13Pattern).FindAllString(howToHackLikeAGhost, -1)
-	assert.Equal(t, 18, len(matches))
+	isbns = util.IdentifyIsbn10s("Library of Congress Control Number: 2020052504")
+	assert.Empty(t, isbns)
 }
 
-func TestIdentifyIsbn10s(t *testing.T) {
-	isbns := util.IdentifyIsbn10s(howToHackLikeAGhost)
-	assert.Equal(t, []book.ISBN10{"2020052504", "1718501269", "2020052504"}, isbns)
+func TestIdentifyDois(t *testing.T) {
+	text := "Available at https://doi.org/10.1145/3290605.3300233. See also doi:10.1007/978-3-030-58580-8_1, and 10.1145/3290605.3300233"
+	assert.Equal(t, []book.DOI{"10.1145/3290605.3300233", "10.1007/978-3-030-58580-8_1"}, util.IdentifyDois(text))
 }
 
 func TestIdentifyIsbn13s(t *testing.T) {
+	isbns := util.IdentifyIsbn13s(howToHackLikeAGhost)
+	assert.Equal(t, []book.ISBN13{"9781718501263", "9781718501270"}, isbns)
+
+	// the page number joined on by a space isn't part of the ISBN
+	isbns = util.IdentifyIsbn13s("iv 12 978 1 7185 0126 3")
+	assert.Equal(t, []book.ISBN13{"9781718501263"}, isbns)
+
+	isbns = util.IdentifyIsbn13s("eISBN 978-1718501270, also from 9781718501263 on the back")
+	assert.Equal(t, []book.ISBN13{"9781718501270", "9781718501263"}, isbns)
+}
+
+func TestIdentifyIsbns(t *testing.T) {
+	isbns := util.IdentifyIsbns(howToHackLikeAGhost)
+	assert.Equal(t, []book.ISBN{"9781718501263", "9781718501270", "1718501269"}, isbns)
+
+	// the ISBN printed on every page is the book's, the one in the bibliography isn't
+	isbns = util.IdentifyIsbns("see 0-306-40615-2\n978-1-7185-0126-3\nchapter 2 978-1-7185-0126-3")
+	assert.Equal(t, []book.ISBN{"9781718501263", "0306406152"}, isbns)
+}
+
+func TestIdentifyClassifications(t *testing.T) {
+	assert.Equal(t, []string{"TK5105.59 .F624 2021", "TK5105.59"}, util.IdentifyLccs(howToHackLikeAGhost))
+	assert.Equal(t, []string{"005.87"}, util.IdentifyDdcs(howToHackLikeAGhost))
+
+	assert.Equal(t, []string{"QA76.73.P98 L86 2013"}, util.IdentifyLccs("Library of Congress Classification: QA76.73.P98  L86 2013"))
+	assert.Equal(t, []string{"823.914"}, util.IdentifyDdcs("Dewey Decimal Classification: 823'.914"))
+	assert.Empty(t, util.IdentifyLccs("LCCN 2021 12345"))
+}
+
+func TestParseFilename(t *testing.T) {
+	terms := util.ParseFilename("/books/Sparc Flow - How to Hack Like a Ghost (2021).pdf")
+	assert.Equal(t, util.FilenameTerms{Title: "How to Hack Like a Ghost", Author: "Sparc Flow", Year: 2021}, terms)
+
+	terms = util.ParseFilename("How_to_Hack_Like_a_Ghost_[retail].epub")
+	assert.Equal(t, util.FilenameTerms{Title: "How to Hack Like a Ghost"}, terms)
+
+	terms = util.ParseFilename("How to Hack Like a Ghost by Sparc Flow.mobi")
+	assert.Equal(t, util.FilenameTerms{Title: "How to Hack Like a Ghost", Author: "Sparc Flow"}, terms)
+
+	terms = util.ParseFilename("B08BXKZBT1_EBOK.azw")
+	assert.Equal(t, util.FilenameTerms{Title: "", Asin: "B08BXKZBT1"}, terms)
+
+	terms = util.ParseFilename("Sparc Flow - How to Hack Like a Ghost [B08BXKZBT1].azw3")
+	assert.Equal(t, util.FilenameTerms{Title: "How to Hack Like a Ghost", Author: "Sparc Flow", Asin: "B08BXKZBT1"}, terms)
+
+	terms = util.ParseFilename("Discworld 07 - Pyramids.epub")
+	assert.Equal(t, util.FilenameTerms{Title: "Pyramids", Series: "Discworld", SeriesIndex: 7}, terms)
+
+	terms = util.ParseFilename("Terry Pratchett - Discworld #7 - Pyramids (1989).epub")
+	assert.Equal(t, util.FilenameTerms{Title: "Pyramids", Author: "Terry Pratchett", Year: 1989, Series: "Discworld", SeriesIndex: 7}, terms)
+
+	terms = util.ParseFilename("Terry Pratchett - Pyramids (Discworld, Book 7).mobi")
+	assert.Equal(t, util.FilenameTerms{Title: "Pyramids", Author: "Terry Pratchett", Series: "Discworld", SeriesIndex: 7}, terms)
+
+	terms = util.ParseFilename("The Expanse 5.5 - The Churn.epub")
+	assert.Equal(t, util.FilenameTerms{Title: "The Churn", Series: "The Expanse", SeriesIndex: 5.5}, terms)
+}
+
+func TestIdentifyAsins(t *testing.T) {
+	assert.Equal(t, []book.ASIN{"B08BXKZBT1", "1718501269"}, util.IdentifyAsins("ASIN: B08BXKZBT1\nASIN 1718501269\nB0000000ZZ ASIN: 1718501260"))
+}
+
+func TestIdentifyArxivs(t *testing.T) {
+	text := "arXiv:2101.00027v2 [cs.CL] 31 Dec 2020\nbuilds on https://arxiv.org/abs/1706.03762 and arXiv: hep-th/9711200v3, " +
+		"version 1.2345 of arXiv:2101.00027"
+	assert.Equal(t, []book.ArxivId{"2101.00027", "1706.03762", "hep-th/9711200"}, util.IdentifyArxivs(text))
+}
+
+func TestParseYears(t *testing.T) {
+	for date, years := range map[string][2]uint{
+		"2021":                  {2021, 2021},
+		"2021-05":               {2021, 2021},
+		"2021-05-12T00:00:00Z":  {2021, 2021},
+		"May 2021":              {2021, 2021},
+		"[c2021]":               {2021, 2021},
+		"1998-2001":             {1998, 2001},
+		"1998 – 2001":           {1998, 2001},
+		"1998/99":               {1998, 1999},
+		"1998-99":               {1998, 1999},
+		"1995, 2nd ed. 2003":    {1995, 2003},
+		"Printed 1612, 1250 pp": {1612, 1612},
+	} {
+		low, high, ok := util.ParseYears(date)
+		assert.True(t, ok, date)
+		assert.Equal(t, years, [2]uint{low, high}, date)
+	}
+
+	_, _, ok := util.ParseYears("n.d.")
+	assert.False(t, ok)
+	_, _, ok = util.ParseYears("99")
+	assert.False(t, ok)
+}
+
+func TestCopyrightYear(t *testing.T) {
+	text := "Clean Code. Copyright © 2005, 2012 by Robert C. Martin. All rights reserved.\n" +
+		"Figure 3 reproduced from (c)1998 Elsevier. First printing, 2015."
+	year, ok := util.CopyrightYear(text)
+	assert.True(t, ok)
+	// printings aren't editions
+	assert.Equal(t, uint(2012), year)
+
+	year, ok = util.CopyrightYear("COPYRIGHT: 1999-2003")
+	assert.True(t, ok)
+	assert.Equal(t, uint(2003), year)
+
+	_, ok = util.CopyrightYear("Published in 2012. All rights reserved.")
+	assert.False(t, ok)
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	assert.Equal(t, []string{"lord", "rings"}, util.TitleTokens("The Lord of the Rings"))
+	assert.Equal(t, []string{"etranger"}, util.TitleTokens("L'Étranger"))
+	assert.Equal(t, []string{"the", "the"}, util.TitleTokens("The The"))
+
+	assert.Equal(t, "The Pragmatic Programmer", util.FilenameTitle("/books/Hunt - The Pragmatic Programmer (1999).pdf"))
+	assert.Equal(t, "pragmatic programmer", util.FilenameTitle("/books/pragmatic_programmer.epub"))
+
+	filenameTitle := util.FilenameTitle("/books/the_go_programming_language.pdf")
+	// word order, case and stopwords don't matter
+	assert.InDelta(t, 1, util.TitleSimilarity("Go Programming Language, The", filenameTitle), 0.001)
+	// a misspelling counts for nearly as much as the word
+	assert.Greater(t, util.TitleSimilarity("The Go Programing Language", filenameTitle), 0.95)
+	// where a short title as close in edits as the right one doesn't
+	assert.Greater(t, util.TitleSimilarity("The Go Programming Language", filenameTitle), util.TitleSimilarity("Go", filenameTitle))
+	assert.Equal(t, 0.0, util.TitleSimilarity("Learning Python", filenameTitle))
+	// numbers only match themselves
+	assert.Equal(t, 0.5, util.TitleSimilarity("Dune 2", "Dune 3"))
+	assert.Equal(t, 0.0, util.TitleSimilarity("", filenameTitle))
+
+	assert.InDelta(t, 0.961, util.JaroWinkler("martha", "marhta"), 0.001)
+	assert.InDelta(t, 0.840, util.JaroWinkler("dwayne", "duane"), 0.001)
+	assert.Equal(t, 1.0, util.JaroWinkler("café", "café"))
+	assert.Equal(t, 0.0, util.JaroWinkler("abc", ""))
+}
+
+func TestLevenshteinDistance(t *testing.T) {
+	for pair, distance := range map[[2]string]int{
+		{"", ""}:                 0,
+		{"", "abc"}:              3,
+		{"abc", ""}:              3,
+		{"a", "b"}:               1,
+		{"kitten", "sitting"}:    3,
+		{"flaw", "lawn"}:         2,
+		{"gopher", "gopher"}:     0,
+		{"book", "books"}:        1,
+		{"café", "cafe"}:         1,
+		{"Straße", "Strasse"}:    2,
+		{"東京都", "京都"}:            1,
+		{"Étranger", "étranger"}: 1,
+	} {
+		assert.Equal(t, distance, util.LevenshteinDistance(pair[0], pair[1]), pair)
+	}
+
+	config := &quick.Config{MaxCount: 500}
+	length := utf8.RuneCountInString
+	properties := map[string]any{
+		"identity": func(a string) bool {
+			return util.LevenshteinDistance(a, a) == 0
+		},
+		"symmetry": func(a, b string) bool {
+			return util.LevenshteinDistance(a, b) == util.LevenshteinDistance(b, a)
+		},
+		"bounds": func(a, b string) bool {
+			distance := util.LevenshteinDistance(a, b)
+			return distance >= max(length(a)-length(b), length(b)-length(a)) && distance <= max(length(a), length(b))
+		},
+		"triangle inequality": func(a, b, c string) bool {
+			return util.LevenshteinDistance(a, c) <= util.LevenshteinDistance(a, b)+util.LevenshteinDistance(b, c)
+		},
+		"appending": func(a, b string) bool {
+			return util.LevenshteinDistance(a, a+b) == length(b)
+		},
+	}
+	for name, property := range properties {
+		assert.NoError(t, quick.Check(property, config), name)
+	}
+}
+
+func TestNormalizeAuthor(t *testing.T) {
+	for name, normalized := range map[string]string{
+		"John Smith":               "John Smith",
+		"Smith, John":              "John Smith",
+		"  Smith ,  John ":         "John Smith",
+		"Dr. Jane Doe, Ph.D.":      "Jane Doe",
+		"Prof Jane Doe":            "Jane Doe",
+		"Tolkien, J.R.R.":          "J. R. R. Tolkien",
+		"TOLKIEN, J. R. R.":        "J. R. R. Tolkien",
+		"King, Martin Luther, Jr.": "Martin Luther King Jr.",
+		"Martin Luther King, Jr.":  "Martin Luther King Jr.",
+		"Beethoven, Ludwig van":    "Ludwig van Beethoven",
+		"Mary, Queen of Scots":     "Mary, Queen of Scots",
+		"O'Reilly Media, Inc.":     "O'Reilly Media, Inc.",
+		"Plato":                    "Plato",
+	} {
+		assert.Equal(t, normalized, util.NormalizeAuthor(name), name)
+	}
+
+	authors := util.NormalizeAuthors([]string{"García Márquez, Gabriel", "Gabriel Garcia Marquez", "Edith Grossman", "Dr."})
+	assert.Equal(t, []string{"Gabriel García Márquez", "Edith Grossman"}, authors)
+
+	assert.Equal(t, "Smith, John", util.InvertAuthor("John Smith"))
+	assert.Equal(t, "Beethoven, Ludwig van", util.InvertAuthor("Ludwig van Beethoven"))
+	assert.Equal(t, "King, Martin Luther, Jr.", util.InvertAuthor("Martin Luther King Jr."))
+	assert.Equal(t, "Plato", util.InvertAuthor("Plato"))
+	assert.Equal(t, "Mary, Queen of Scots", util.InvertAuthor("Mary, Queen of Scots"))
+}
+
+func TestExpandUser(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	assert.Equal(t, home, util.ExpandUser("~"))
+	assert.Equal(t, filepath.Join(home, "books"), util.ExpandUser("~/books"))
+	assert.Equal(t, "~bob/books", util.ExpandUser("~bob/books"))
+	assert.Equal(t, "/books/~", util.ExpandUser("/books/~"))
+
+	abs, err := util.AbsPath("~/books/../papers")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "papers"), abs)
+}
+
+func TestMatchPath(t *testing.T) {
+	assert.True(t, util.MatchPath("*.pdf", "fiction/dune.pdf", false))
+	assert.True(t, util.MatchPath("fiction/*", "fiction/dune.pdf", false))
+	assert.False(t, util.MatchPath("fiction/*", "fiction/herbert/dune.pdf", false))
+	assert.True(t, util.MatchPath("fiction/**", "fiction/herbert/dune.pdf", false))
+	assert.True(t, util.MatchPath("**/herbert/*.pdf", "fiction/herbert/dune.pdf", false))
+	assert.True(t, util.MatchPath("**/dune.pdf", "dune.pdf", false))
+	assert.False(t, util.MatchPath("fiction/**", "science/dune.pdf", false))
+	assert.True(t, util.MatchPath("drafts/", "drafts", true))
+	assert.False(t, util.MatchPath("drafts/", "drafts", false))
+}
+
+func TestDetectLanguage(t *testing.T) {
+	english := "It was the best of times, it was the worst of times, it was the age of wisdom, it was the age of foolishness. " +
+		"There were a king with a large jaw and a queen with a plain face, on the throne of England; and that is all."
+	assert.Equal(t, "en", util.DetectLanguage(english))
+
+	french := "Longtemps, je me suis couché de bonne heure. Parfois, à peine ma bougie éteinte, mes yeux se fermaient si vite " +
+		"que je n'avais pas le temps de me dire: je m'endors. Et, une demi-heure après, la pensée qu'il était temps de " +
+		"chercher le sommeil m'éveillait; je voulais poser le volume que je croyais avoir dans les mains et souffler ma " +
+		"lumière; je n'avais pas cessé en dormant de faire des réflexions sur ce que je venais de lire, mais ces " +
+		"réflexions avaient pris un tour un peu particulier; il me semblait que j'étais moi-même ce dont parlait " +
+		"l'ouvrage: une église, un quatuor, la rivalité de François Ier et de Charles Quint. Cette croyance survivait " +
+		"pendant quelques secondes à mon réveil; elle ne choquait pas ma raison mais pesait comme des écailles sur mes " +
+		"yeux et les empêchait de se rendre compte que le bougeoir n'était plus allumé. Puis elle commençait à me " +
+		"devenir inintelligible, comme après la métempsycose les pensées d'une existence antérieure; le sujet du livre " +
+		"se détachait de moi, et sur le lit au milieu des rideaux."
+	assert.Equal(t, "fr", util.DetectLanguage(french))
+
+	german := "Als Gregor Samsa eines Morgens aus unruhigen Träumen erwachte, fand er sich in seinem Bett zu einem ungeheueren " +
+		"Ungeziefer verwandelt. Er lag auf seinem panzerartig harten Rücken und sah, wenn er den Kopf ein wenig hob, " +
+		"seinen gewölbten, braunen, von bogenförmigen Versteifungen geteilten Bauch, auf dessen Höhe sich die " +
+		"Bettdecke, zum gänzlichen Niedergleiten bereit, kaum noch erhalten konnte. Seine vielen, im Vergleich zu " +
+		"seinem sonstigen Umfang kläglich dünnen Beine flimmerten ihm hilflos vor den Augen. Was ist mit mir " +
+		"geschehen? dachte er. Es war kein Traum. Sein Zimmer, ein richtiges, nur etwas zu kleines Menschenzimmer, " +
+		"lag ruhig zwischen den vier wohlbekannten Wänden. Über dem Tisch, auf dem eine auseinandergepackte Musterkollektion " +
+		"von Tuchwaren ausgebreitet war, hing das Bild, das er vor kurzem aus einer illustrierten Zeitschrift " +
+		"ausgeschnitten und in einem hübschen, vergoldeten Rahmen untergebracht hatte. Es stellte eine Dame dar, die " +
+		"mit einem Pelzhut und einer Pelzboa versehen, aufrecht dasaß und einen schweren Pelzmuff, in dem ihr ganzer " +
+		"Unterarm verschwunden war, dem Beschauer entgegenhob. Das ist nicht der Fall, und auch die Mutter wird das " +
+		"nicht sehen, sagte er sich und das ist auch gut so."
+	assert.Equal(t, "de", util.DetectLanguage(german))
+
+	assert.Equal(t, "ru", util.DetectLanguage("Все счастливые семьи похожи друг на друга, каждая несчастливая семья несчастлива по-своему."))
+	assert.Equal(t, "ja", util.DetectLanguage("吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかぬ。"))
+	assert.Equal(t, "", util.DetectLanguage("ISBN 978-1-7185-0126-3"))
+	assert.Equal(t, "", util.DetectLanguage("the end"))
+
+	assert.Equal(t, "en", util.PrimaryLanguage("en-US"))
+	assert.Equal(t, "pt", util.PrimaryLanguage(" PT_br"))
+}
+
+func TestShortDescription(t *testing.T) {
+	assert.Equal(t, "A guide to hacking & cloud security.", util.ShortDescription("<p>A guide to <b>hack</b>ing &amp; cloud\n security.</p>"))
+
+	long := util.ShortDescription(strings.Repeat("word ", 300))
+	assert.LessOrEqual(t, utf8.RuneCountInString(long), util.MaxDescriptionLength+1)
+	assert.True(t, strings.HasSuffix(long, "word…"))
+}
+
+func TestJsonStreamWriterCompression(t *testing.T) {
+	for _, name := range []string{"books.json", "books.json.gz", "books.json.zst"} {
+		output := filepath.Join(t.TempDir(), name)
+		writer, err := util.NewJsonStreamWriter[string](output, util.CompressionOf(output), func(s string) (util.JsonStreamWriterItem, error) {
+			return util.JsonStreamWriterItem{Key: s, Data: []byte(`"` + s + `"`)}, nil
+		})
+		assert.NoError(t, err)
+		writer.WriteObject("a")
+		writer.WriteObject("b")
+		writer.Close()
+		// closing again, like stopping a scan after it was interrupted, does nothing
+		assert.NotPanics(t, writer.Close)
+
+		data, err := util.ReadFile(output)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"a": "a", "b": "b"}`, string(data), name)
+
+		raw, err := os.ReadFile(output)
+		assert.NoError(t, err)
+		assert.Equal(t, util.CompressionOf(output) == util.NoCompression, string(raw) == string(data), name)
+	}
+}
 
+func TestSqliteWriterClose(t *testing.T) {
+	writer, err := util.NewSqliteWriter(filepath.Join(t.TempDir(), "books.db"))
+	assert.NoError(t, err)
+	writer.WriteObject(&book.Book{Filepath: "/books/a.pdf"})
+	writer.Close()
+	assert.NotPanics(t, writer.Close)
 }
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/util"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchSettleTime is how long a file must go without changes before it is processed, so that books still being
+// copied or downloaded aren't picked up half-written
+const watchSettleTime = 2 * time.Second
+
+// isWatchedFile is whether a change to the file can mean a new or modified book
+func (bm *BookManager) isWatchedFile(d fs.DirEntry, path string) bool {
+	return bm.isAcceptedFile(d) || (bm.scanArchives && d.Type().IsRegular() && archive.IsArchive(path))
+}
+
+// watch submits new and modified books under scanPath until the pipeline is interrupted
+func (bm *BookManager) watch(scanPath string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	pending := make(map[string]time.Time)
+
+	// fsnotify is not recursive, so every directory is watched individually
+	addDirectory := func(dirPath string) error {
+		return filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() {
+				if bm.filter.skipDir(scanPath, path) {
+					return filepath.SkipDir
+				}
+				err = watcher.Add(path)
+				if err != nil {
+					slog.Warn("unable to watch directory", "path", path, "error", err)
+				}
+				return nil
+			}
+			// anything already inside a newly created directory generates no events of its own
+			if dirPath != scanPath && bm.isWatchedFile(d, path) && !bm.filter.skipFile(scanPath, path) {
+				pending[path] = time.Now()
+			}
+			return nil
+		})
+	}
+
+	err = addDirectory(scanPath)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, isOpen := <-watcher.Events:
+			if !isOpen {
+				return nil
+			}
+
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+
+			info, err := os.Lstat(event.Name)
+			if err != nil {
+				continue
+			}
+
+			if info.IsDir() {
+				if event.Has(fsnotify.Create) {
+					err = addDirectory(event.Name)
+					if err != nil {
+						slog.Warn("unable to watch new directory", "path", event.Name, "error", err)
+					}
+				}
+				continue
+			}
+
+			if bm.isWatchedFile(fs.FileInfoToDirEntry(info), event.Name) && !bm.filter.skipFile(scanPath, event.Name) {
+				pending[event.Name] = time.Now()
+			}
+		case err, isOpen := <-watcher.Errors:
+			if !isOpen {
+				return nil
+			}
+			slog.Warn("file watcher error", "error", err)
+		case <-ticker.C:
+			if bm.pipe.IsInterrupted() {
+				return nil
+			}
+
+			for path, lastChanged := range pending {
+				if time.Since(lastChanged) < watchSettleTime {
+					continue
+				}
+				delete(pending, path)
+
+				path, err := filepath.Abs(path)
+				if err != nil {
+					continue
+				}
+
+				// only the books added to an archive are picked up, like those added to a directory
+				if bm.scanArchives && archive.IsArchive(path) {
+					submitted := true
+					bm.walkArchive(scanPath, path, bm.stats, func(memberPath string) bool {
+						submitted = bm.pipe.Submit(book.Book{Filepath: memberPath})
+						return submitted
+					})
+					if !submitted {
+						return nil
+					}
+					continue
+				}
+
+				// a modified book needs to be processed again, unless only its timestamps changed or booker wrote to it
+				if bm.isBookProcessed(path) {
+					hash, err := util.HashFile(path)
+					if err == nil && hash == bm.getProcessedBook(path).Hash {
+						continue
+					}
+					bm.removeProcessedBook(path)
+				}
+
+				bm.stats.Discover()
+				if !bm.pipe.Submit(book.Book{Filepath: path}) {
+					return nil
+				}
+			}
+		}
+	}
+}
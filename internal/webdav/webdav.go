@@ -0,0 +1,245 @@
+package webdav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/httpclient"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// propfindBody asks for only the properties the walk needs
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// IsUrl is whether the path of a book is on a WebDAV server rather than on disk
+func IsUrl(filePath string) bool {
+	return strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://")
+}
+
+// urlScheme matches the scheme a URL starts with, which a path on disk never does
+var urlScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// CheckSource is why books can't be read from filePath, if it is the URL of a server other than a WebDAV one. Paths on
+// disk and WebDAV URLs are nil, whether or not they exist.
+func CheckSource(filePath string) error {
+	switch {
+	case IsUrl(filePath) || !urlScheme.MatchString(filePath):
+		return nil
+	case strings.HasPrefix(filePath, "sftp://"):
+		return fmt.Errorf("SFTP servers can't be read from yet, mount %s with sshfs and use the mount instead", filePath)
+	default:
+		return fmt.Errorf("%s is a URL, but only WebDAV servers over http and https can be read from", filePath)
+	}
+}
+
+// Entry is a file or collection on the server. Its Url is the way books are recorded, with the path unescaped like a
+// path on disk, as in https://nas.local/dav/Books/Frank Herbert/Dune.epub.
+type Entry struct {
+	Url     string
+	Name    string
+	Dir     bool
+	Size    int64
+	ModTime time.Time
+}
+
+// Client walks and downloads from WebDAV servers
+type Client struct {
+	conf   *config.WebdavConfig
+	client *http.Client
+	// downloads aren't limited by the timeout of client, since books can be big
+	downloadClient *http.Client
+}
+
+// NewClient reaches the servers through client, logging in as the user of conf if it has one
+func NewClient(conf *config.WebdavConfig, client *http.Client) *Client {
+	return &Client{conf: conf, client: client, downloadClient: httpclient.WithoutTimeout(client)}
+}
+
+// requestUrl escapes the recorded url of an entry for a request. The path is escaped here rather than recorded escaped,
+// since a name can have anything in it.
+func requestUrl(entryUrl string) (*url.URL, error) {
+	scheme, rest, _ := strings.Cut(entryUrl, "://")
+	host, filePath, _ := strings.Cut(rest, "/")
+	if len(host) == 0 {
+		return nil, fmt.Errorf("error: %s has no host", entryUrl)
+	}
+	if strings.Contains(host, "@") {
+		return nil, fmt.Errorf("error: %s has credentials in it, which belong in [webdav] instead", entryUrl)
+	}
+	return &url.URL{Scheme: scheme, Host: host, Path: "/" + filePath}, nil
+}
+
+// EscapeUrl is the recorded url of an entry escaped for linking to, or as it is if it isn't a URL that can be requested
+func EscapeUrl(entryUrl string) string {
+	escaped, err := requestUrl(entryUrl)
+	if err != nil {
+		return entryUrl
+	}
+	return escaped.String()
+}
+
+func (c *Client) request(ctx context.Context, method string, entryUrl string, body io.Reader) (*http.Response, error) {
+	target, err := requestUrl(entryUrl)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.conf.Username) > 0 {
+		request.SetBasicAuth(c.conf.Username, c.conf.Password)
+	}
+	if method != "PROPFIND" {
+		return c.downloadClient.Do(request)
+	}
+	request.Header.Set("Depth", "1")
+	request.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	return c.client.Do(request)
+}
+
+// Walk calls visit with every entry under root in lexical order, like filepath.WalkDir. Returning fs.SkipDir for a
+// collection skips what is in it, and fs.SkipAll stops the walk.
+func (c *Client) Walk(ctx context.Context, root string, visit func(entry Entry) error) error {
+	err := c.walk(ctx, strings.TrimSuffix(root, "/"), visit)
+	if err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (c *Client) walk(ctx context.Context, dirUrl string, visit func(entry Entry) error) error {
+	entries, err := c.list(ctx, dirUrl)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = visit(entry)
+		if entry.Dir && err == nil {
+			err = c.walk(ctx, entry.Url, visit)
+		}
+		if err == fs.SkipDir {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// multistatus is the answer to a PROPFIND, only with the properties asked for
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Status string `xml:"status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength string `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// list is what is directly in the collection at dirUrl, sorted by name
+func (c *Client) list(ctx context.Context, dirUrl string) ([]Entry, error) {
+	response, err := c.request(ctx, "PROPFIND", dirUrl+"/", strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("error: listing %s returned bad status code %d", dirUrl, response.StatusCode)
+	}
+
+	var status multistatus
+	err = xml.NewDecoder(response.Body).Decode(&status)
+	if err != nil {
+		return nil, fmt.Errorf("error: unable to decode listing of %s: %s", dirUrl, err.Error())
+	}
+
+	base, _ := requestUrl(dirUrl + "/")
+	entries := make([]Entry, 0, len(status.Responses))
+	for _, r := range status.Responses {
+		// the href is a path on the server, or sometimes a whole URL
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		entryPath := strings.TrimSuffix(base.ResolveReference(href).Path, "/")
+		// the collection itself is listed along with what is in it
+		if entryPath == strings.TrimSuffix(base.Path, "/") {
+			continue
+		}
+
+		entry := Entry{
+			Url:  fmt.Sprintf("%s://%s%s", base.Scheme, base.Host, entryPath),
+			Name: path.Base(entryPath),
+		}
+		for _, propstat := range r.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			entry.Dir = entry.Dir || propstat.Prop.ResourceType.Collection != nil
+			if size, err := strconv.ParseInt(strings.TrimSpace(propstat.Prop.ContentLength), 10, 64); err == nil {
+				entry.Size = size
+			}
+			if modTime, err := http.ParseTime(strings.TrimSpace(propstat.Prop.LastModified)); err == nil {
+				entry.ModTime = modTime
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return entries, nil
+}
+
+// Download copies the file at fileUrl to a temporary file with the same extension, which the caller removes, along with
+// its size and modification time
+func (c *Client) Download(ctx context.Context, fileUrl string) (string, Entry, error) {
+	entry := Entry{Url: fileUrl, Name: path.Base(fileUrl)}
+	response, err := c.request(ctx, http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return "", entry, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", entry, fmt.Errorf("error: downloading %s returned bad status code %d", fileUrl, response.StatusCode)
+	}
+	if modTime, err := http.ParseTime(response.Header.Get("Last-Modified")); err == nil {
+		entry.ModTime = modTime
+	}
+
+	fh, err := os.CreateTemp("", "booker-*"+path.Ext(entry.Name))
+	if err != nil {
+		return "", entry, err
+	}
+	entry.Size, err = io.Copy(fh, response.Body)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(fh.Name())
+		return "", entry, err
+	}
+	return fh.Name(), entry, nil
+}
@@ -0,0 +1,144 @@
+package webdav_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/webdav"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const modified = "Mon, 06 May 2024 10:00:00 GMT"
+
+func response(href string, collection bool, size int) string {
+	resourceType := ""
+	if collection {
+		resourceType = "<d:collection/>"
+	}
+	return fmt.Sprintf(`<d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype>%s</d:resourcetype>
+<d:getcontentlength>%d</d:getcontentlength><d:getlastmodified>%s</d:getlastmodified></d:prop>
+<d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, href, resourceType, size, modified)
+}
+
+// nas serves a library like a Nextcloud share, with a name that has to be escaped
+func nas(t *testing.T) *httptest.Server {
+	listings := map[string][]string{
+		"/dav/Books/": {
+			response("/dav/Books/", true, 0),
+			response("/dav/Books/Sci%20Fi/", true, 0),
+			response("/dav/Books/manual.pdf", false, 4),
+		},
+		"/dav/Books/Sci Fi/": {
+			response("/dav/Books/Sci%20Fi/", true, 0),
+			response("/dav/Books/Sci%20Fi/Dune%20%2350.epub", false, 6),
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "reader" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case "PROPFIND":
+			assert.Equal(t, "1", r.Header.Get("Depth"))
+			listing, ok := listings[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:">%s</d:multistatus>`, strings.Join(listing, ""))
+		case http.MethodGet:
+			if r.URL.Path != "/dav/Books/Sci Fi/Dune #50.epub" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Last-Modified", modified)
+			fmt.Fprint(w, "spice!")
+		}
+	}))
+}
+
+func TestWalk(t *testing.T) {
+	server := nas(t)
+	defer server.Close()
+	client := webdav.NewClient(&config.WebdavConfig{Enable: true, Username: "reader", Password: "secret"}, http.DefaultClient)
+
+	entries := make([]webdav.Entry, 0)
+	err := client.Walk(context.Background(), server.URL+"/dav/Books/", func(entry webdav.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, server.URL+"/dav/Books/Sci Fi", entries[0].Url)
+		assert.True(t, entries[0].Dir)
+		assert.Equal(t, server.URL+"/dav/Books/Sci Fi/Dune #50.epub", entries[1].Url)
+		assert.Equal(t, "Dune #50.epub", entries[1].Name)
+		assert.Equal(t, int64(6), entries[1].Size)
+		assert.Equal(t, time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC), entries[1].ModTime.UTC())
+		assert.Equal(t, server.URL+"/dav/Books/manual.pdf", entries[2].Url)
+		assert.False(t, entries[2].Dir)
+	}
+
+	// skipping a collection doesn't list it
+	entries = entries[:0]
+	err = client.Walk(context.Background(), server.URL+"/dav/Books", func(entry webdav.Entry) error {
+		entries = append(entries, entry)
+		if entry.Dir {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	err = client.Walk(context.Background(), server.URL+"/dav/Missing", func(webdav.Entry) error { return nil })
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestDownload(t *testing.T) {
+	server := nas(t)
+	defer server.Close()
+	client := webdav.NewClient(&config.WebdavConfig{Enable: true, Username: "reader", Password: "secret"}, http.DefaultClient)
+
+	local, entry, err := client.Download(context.Background(), server.URL+"/dav/Books/Sci Fi/Dune #50.epub")
+	assert.NoError(t, err)
+	defer os.Remove(local)
+	assert.True(t, strings.HasSuffix(local, ".epub"))
+	data, err := os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, "spice!", string(data))
+	assert.Equal(t, int64(6), entry.Size)
+	assert.Equal(t, time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC), entry.ModTime.UTC())
+
+	_, _, err = client.Download(context.Background(), strings.Replace(server.URL, "://", "://reader:secret@", 1)+"/dav/Books/manual.pdf")
+	assert.ErrorContains(t, err, "credentials")
+
+	wrong := webdav.NewClient(&config.WebdavConfig{Enable: true, Username: "reader", Password: "wrong"}, http.DefaultClient)
+	_, _, err = wrong.Download(context.Background(), server.URL+"/dav/Books/Sci Fi/Dune #50.epub")
+	assert.ErrorContains(t, err, "401")
+}
+
+func TestIsUrl(t *testing.T) {
+	assert.True(t, webdav.IsUrl("https://nas.local/dav/Books/Dune.epub"))
+	assert.True(t, webdav.IsUrl("http://nas.local/dav/Books"))
+	assert.False(t, webdav.IsUrl("/books/https/Dune.epub"))
+	assert.False(t, webdav.IsUrl("books.zip::Dune.epub"))
+}
+
+func TestCheckSource(t *testing.T) {
+	assert.NoError(t, webdav.CheckSource("https://nas.local/dav/Books"))
+	assert.NoError(t, webdav.CheckSource("/books/sftp:/Dune.epub"))
+	assert.NoError(t, webdav.CheckSource(`C:\Books\Dune.epub`))
+	assert.NoError(t, webdav.CheckSource("books.zip::Dune.epub"))
+	assert.EqualError(t, webdav.CheckSource("sftp://nas.local/books"), "SFTP servers can't be read from yet, mount sftp://nas.local/books with sshfs and use the mount instead")
+	assert.EqualError(t, webdav.CheckSource("ftp://nas.local/books"), "ftp://nas.local/books is a URL, but only WebDAV servers over http and https can be read from")
+}
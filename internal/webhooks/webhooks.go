@@ -0,0 +1,189 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/stats"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the events webhooks are configured for, as they are named in the JSON sent
+const (
+	BookIdentified = "book_identified"
+	BookFailed     = "book_failed"
+	ScanComplete   = "scan_complete"
+)
+
+// queueSize is how many events can wait to be sent before more are dropped, so that a slow receiver never holds up
+// the books
+const queueSize = 1024
+
+// attempts is how many times an event is sent to a receiver that fails to take it, waiting a little longer each time
+const attempts = 3
+
+// retryWait is how long to wait before sending an event again the first time
+const retryWait = time.Second
+
+// closeWait is the longest Close waits for the events still queued to be sent
+const closeWait = 30 * time.Second
+
+// Event is what is POSTed to a webhook, with the book for the book events and the stats of the scan for scan_complete
+type Event struct {
+	Event string        `json:"event"`
+	Time  time.Time     `json:"time"`
+	Book  *book.Book    `json:"book,omitempty"`
+	Stats *stats.Report `json:"stats,omitempty"`
+	// Error is why the scan didn't finish, if it didn't
+	Error string `json:"error,omitempty"`
+}
+
+type delivery struct {
+	url   string
+	event *Event
+}
+
+// Notifier sends events to the webhooks they are configured for, one at a time and in the order they happened
+type Notifier struct {
+	conf   *config.WebhooksConfig
+	client *http.Client
+	queue  chan delivery
+	// closing stops events from being sent again once Close is waiting on them
+	closing chan struct{}
+	done    chan struct{}
+}
+
+// NewNotifier sends events through client, with the timeout of conf in place of its own. It is nil if no webhooks are
+// configured, which sends nothing.
+func NewNotifier(conf *config.WebhooksConfig, client *http.Client) *Notifier {
+	if len(conf.OnBookIdentified) == 0 && len(conf.OnBookFailed) == 0 && len(conf.OnScanComplete) == 0 {
+		return nil
+	}
+	timed := *client
+	timed.Timeout = time.Duration(conf.TimeoutSeconds) * time.Second
+	n := &Notifier{
+		conf:    conf,
+		client:  &timed,
+		queue:   make(chan delivery, queueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go n.send()
+	return n
+}
+
+// BookFinished sends book_identified or book_failed for a book written to the output
+func (n *Notifier) BookFinished(bk *book.Book) {
+	if n == nil {
+		return
+	}
+	if len(bk.ErrorMessage) > 0 {
+		n.notify(n.conf.OnBookFailed, &Event{Event: BookFailed, Time: time.Now(), Book: bk})
+		return
+	}
+	n.notify(n.conf.OnBookIdentified, &Event{Event: BookIdentified, Time: time.Now(), Book: bk})
+}
+
+// ScanFinished sends scan_complete with what the scan did, and err if it didn't finish
+func (n *Notifier) ScanFinished(report stats.Report, err error) {
+	if n == nil {
+		return
+	}
+	event := &Event{Event: ScanComplete, Time: time.Now(), Stats: &report}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	n.notify(n.conf.OnScanComplete, event)
+}
+
+func (n *Notifier) notify(urls []string, event *Event) {
+	for _, url := range urls {
+		select {
+		case n.queue <- delivery{url: url, event: event}:
+		default:
+			slog.Warn("too many webhook events waiting to be sent, dropping one", "url", url, "event", event.Event)
+		}
+	}
+}
+
+// Close waits for the events still queued to be sent, for a while, after which the rest are dropped
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	select {
+	case <-n.done:
+	case <-time.After(closeWait):
+		close(n.closing)
+		slog.Warn("gave up sending the remaining webhook events", "events", len(n.queue))
+	}
+}
+
+func (n *Notifier) send() {
+	defer close(n.done)
+	for delivery := range n.queue {
+		select {
+		case <-n.closing:
+			return
+		default:
+		}
+		n.deliver(delivery)
+	}
+}
+
+// deliver sends an event to a webhook, trying again if the receiver fails to take it
+func (n *Notifier) deliver(d delivery) {
+	data, err := json.Marshal(d.event)
+	if err != nil {
+		slog.Error("could not marshal webhook event", "event", d.event.Event, "error", err)
+		return
+	}
+
+	wait := retryWait
+	for attempt := 1; ; attempt++ {
+		err = n.post(d.url, data)
+		if err == nil {
+			return
+		}
+		if attempt == attempts {
+			slog.Warn("could not send webhook event", "url", d.url, "event", d.event.Event, "error", err)
+			return
+		}
+		select {
+		case <-time.After(wait):
+		case <-n.closing:
+			return
+		}
+		wait *= 2
+	}
+}
+
+func (n *Notifier) post(url string, data []byte) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "booker")
+	for key, value := range n.conf.Headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("receiver returned bad status code %d: %s", response.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package webhooks_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/stats"
+	"github.com/larkwiot/booker/internal/webhooks"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// receiver records the events POSTed to it by path, failing the first request to /flaky
+type receiver struct {
+	lock    sync.Mutex
+	events  map[string][]webhooks.Event
+	headers http.Header
+	failed  bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if request.URL.Path == "/flaky" && !r.failed {
+		r.failed = true
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var event webhooks.Event
+	if err := json.NewDecoder(request.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.events[request.URL.Path] = append(r.events[request.URL.Path], event)
+	r.headers = request.Header.Clone()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestNotifier(t *testing.T) {
+	rec := &receiver{events: make(map[string][]webhooks.Event)}
+	server := httptest.NewServer(rec)
+	defer server.Close()
+
+	n := webhooks.NewNotifier(&config.WebhooksConfig{
+		OnBookIdentified: []string{server.URL + "/books"},
+		OnBookFailed:     []string{server.URL + "/books", server.URL + "/failed"},
+		OnScanComplete:   []string{server.URL + "/flaky"},
+		Headers:          map[string]string{"Authorization": "Bearer token"},
+		TimeoutSeconds:   5,
+	}, http.DefaultClient)
+
+	n.BookFinished(&book.Book{Filepath: "/books/dune.epub", Title: "Dune"})
+	failed := book.Book{Filepath: "/books/scan.pdf"}
+	failed.SetError(book.NewError(book.ErrorNoResults, errors.New("error: no results")))
+	n.BookFinished(&failed)
+	n.ScanFinished(stats.Report{Identified: 1, Failed: 1}, nil)
+	n.Close()
+
+	books := rec.events["/books"]
+	if assert.Len(t, books, 2) {
+		assert.Equal(t, webhooks.BookIdentified, books[0].Event)
+		assert.Equal(t, "Dune", books[0].Book.Title)
+		assert.Equal(t, webhooks.BookFailed, books[1].Event)
+		assert.Equal(t, book.ErrorNoResults, books[1].Book.ErrorCode)
+	}
+	assert.Len(t, rec.events["/failed"], 1)
+
+	// sent again after the receiver failed to take it
+	scans := rec.events["/flaky"]
+	if assert.Len(t, scans, 1) {
+		assert.Equal(t, webhooks.ScanComplete, scans[0].Event)
+		assert.Equal(t, int64(1), scans[0].Stats.Identified)
+		assert.Empty(t, scans[0].Error)
+	}
+	assert.Equal(t, "Bearer token", rec.headers.Get("Authorization"))
+	assert.Equal(t, "application/json", rec.headers.Get("Content-Type"))
+}
+
+func TestNotifierNone(t *testing.T) {
+	n := webhooks.NewNotifier(&config.WebhooksConfig{}, http.DefaultClient)
+	assert.Nil(t, n)
+	// a nil notifier sends nothing
+	n.BookFinished(&book.Book{Filepath: "/books/dune.epub"})
+	n.ScanFinished(stats.Report{}, nil)
+	n.Close()
+}
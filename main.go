@@ -1,93 +1,496 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/jessevdk/go-flags"
 	"github.com/larkwiot/booker/internal"
 	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/calibre"
 	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/export"
+	"github.com/larkwiot/booker/internal/logging"
+	"github.com/larkwiot/booker/internal/review"
 	"github.com/larkwiot/booker/internal/util"
-	"log"
+	"log/slog"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"runtime/debug"
+	"syscall"
 )
 
+type globalOptions struct {
+	ConfigPath string   `short:"c" long:"config" description:"filepath to configuration file, by default booker.toml if there is one and otherwise the built-in defaults, which use Tika on localhost and Google Books"`
+	Profile    string   `long:"profile" env:"BOOKER_PROFILE" description:"use the keys of this [profile.<name>] of the configuration file in place of its own"`
+	Set        []string `long:"set" description:"override a key of the configuration as key=value, like tika.host=tika or isbndb.enable=true, can be given more than once"`
+	Version    bool     `long:"version" description:"print version"`
+	Verbose    bool     `short:"v" long:"verbose" description:"also log debug messages"`
+	Quiet      bool     `short:"q" long:"quiet" description:"only log warnings and errors, and hide the progress line"`
+	LogFile    string   `long:"log-file" description:"filepath to also append logs to, as JSON lines"`
+}
+
+// configSource is where the configuration is read from
+func (g *globalOptions) configSource() config.Source {
+	return config.Source{Path: g.ConfigPath, Profile: g.Profile, Settings: g.Set}
+}
+
+// runOptions are shared by every command that processes books
+type runOptions struct {
+	OutputPath     string   `short:"o" long:"output" description:"filepath to write output to, or the library directory for calibre" default:"./books.json"`
+	OutputFormat   string   `long:"output-format" description:"format to write output in" choice:"json" choice:"sqlite" choice:"calibre" choice:"bibtex" choice:"csljson" choice:"marc" choice:"marcxml" choice:"onix" default:"json"`
+	Threads        int      `short:"t" long:"threads" description:"number of threads to use, set to 0 to automatically determine best count" default:"0"`
+	DryRun         bool     `long:"dry-run" description:"only find the identifiers in every book and print them with the lookups the providers would be sent as JSON, without sending any or writing the output"`
+	Offline        bool     `long:"offline" description:"don't use any providers, identifying books only by their embedded metadata and the cache"`
+	EmbedMetadata  bool     `long:"embed-metadata" description:"write the title, authors, and ISBN found back into EPUB and PDF files"`
+	Include        []string `long:"include" description:"only process files matching this glob, can be given more than once (added to scan.include)"`
+	Exclude        []string `long:"exclude" description:"skip files and directories matching this glob, can be given more than once (added to scan.exclude)"`
+	CoversDir      string   `long:"covers-dir" description:"download the cover of every book found into this directory with a thumbnail, recording their paths in the output"`
+	Archives       bool     `long:"archives" description:"also process books inside .zip, .tar, .tar.gz and .rar archives (same as scan.archives)"`
+	FollowSymlinks bool     `long:"follow-symlinks" description:"also process the books and directories symlinks point to, each only once (same as scan.follow_symlinks)"`
+	OneFileSystem  bool     `long:"one-file-system" description:"don't walk into directories on other file systems than the scan path, like mounts (same as scan.one_file_system)"`
+	MinConfidence  float64  `long:"min-confidence" description:"books whose best result has a lower confidence (0-100) fail and are written to --review-output with all of their candidates"`
+	ReviewOutput   string   `long:"review-output" description:"filepath to write the books that need review to as JSON, required with --min-confidence"`
+	Merge          bool     `long:"merge" description:"add to an existing JSON output or Calibre library, skipping the books it already has and writing them back out along with the new ones"`
+	Force          bool     `long:"force" description:"replace the output if it already exists"`
+	Resume         bool     `long:"resume" description:"continue a run that crashed from the checkpoint next to its output"`
+	DebugOutput    string   `long:"debug-output" description:"directory to write a JSON file to for every book searched, with its text, the identifiers found in it and the raw responses of the providers"`
+	// the two halves of a run, which can be split across runs or machines
+	ExtractOnly     bool   `long:"extract-only" description:"only find the identifiers in every book and write them to the output as JSON, for a later run to search with --from-identifiers"`
+	FromIdentifiers string `long:"from-identifiers" description:"filepath to the output of an --extract-only run, whose books are searched without being read again"`
+}
+
 func main() {
-	log.SetFlags(0)
+	var globals globalOptions
+	var scan scanCommand
+	var retry retryCommand
+	var serve serveCommand
+	var renameBooks renameCommand
+	var catalog opdsCommand
+	var reviewBooks reviewCommand
+	var identify identifyCommand
+	var cacheBooks cacheCommand
+	var worker workerCommand
+	var configure configCommand
+
+	parser := flags.NewParser(&globals, flags.Default)
+	// only so that --version works on its own, every other invocation needs a command
+	parser.SubcommandsOptional = true
 
-	var opts struct {
-		ConfigPath  string `short:"c" long:"config" description:"filepath to configuration file" default:"./booker.toml"`
-		ScanPath    string `short:"s" long:"scan" description:"directory path to scan" default:"./"`
-		OutputPath  string `short:"o" long:"output" description:"filepath to write JSON output to" default:"./books.json"`
-		Cache       string `long:"cache" description:"filepath to previous JSON output to use as cache"`
-		Threads     int    `short:"t" long:"threads" description:"number of threads to use, set to 0 to automatically determine best count" default:"0"`
-		DryRun      bool   `long:"dry-run" description:"do a dry-run (don't make any requests to providers)'"`
-		RetryFailed bool   `long:"retry" descrption:"retry failed books (must also specify --cache)"`
-		Version     bool   `long:"version" description:"print version"`
+	commands := []struct {
+		name    string
+		short   string
+		long    string
+		command any
+	}{
+		{"scan", "scan a directory for books", "Scan a directory for books and write their metadata to the output", &scan},
+		{"retry", "retry the failed books from a previous output", "Scan a directory again, skipping only the books that succeeded in the previous output given with --cache", &retry},
+		{"identify", "identify a single book", "Process one file right away and print its metadata to stdout as JSON, without writing any output", &identify},
+		{"rename", "organize books by their metadata", "Move or hard-link the books from a previous output into a directory layout built from their metadata", &renameBooks},
+		{"review", "review uncertain matches by hand", "Walk through the books a previous scan with --min-confidence wrote to its --review-output, picking or correcting the record of each and updating the output in place", &reviewBooks},
+		{"cache", "inspect and maintain a previous output", "List, prune, rebase or validate the books of a previous output, so that it doesn't need to be edited by hand", &cacheBooks},
+		{"opds", "write an OPDS catalog of the books", "Write an OPDS catalog listing the books from a previous output, so e-reader apps can browse and download them", &catalog},
+		{"serve", "serve a REST API", "Keep running and serve a REST API that scans paths on request and reports the books processed so far", &serve},
+		{"worker", "process books for a coordinator", "Process the books a coordinator started with serve --distribute hands out, sending back what was found", &worker},
+		{"config", "write or check the configuration", "Write a configuration file to start from, or check one for mistakes, like keys that aren't used, and print the configuration it resolves to", &configure},
+	}
+	for _, command := range commands {
+		_, err := parser.AddCommand(command.name, command.short, command.long, command.command)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	}
 
-	_, err := flags.Parse(&opts)
+	_, err := parser.Parse()
 	if err != nil {
-		log.Fatal(err)
+		// the parser has already printed the error or help
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
 	}
 
-	if opts.Version {
+	if globals.Version {
 		info, ok := debug.ReadBuildInfo()
 		if !ok {
-			log.Fatal("error: unable to get build info")
+			fmt.Fprintln(os.Stderr, "error: unable to get build info")
+			os.Exit(1)
 		}
-		log.Println(info)
+		fmt.Println(info)
 		os.Exit(0)
 	}
 
-	if opts.RetryFailed && opts.Cache == "" {
-		log.Fatal("error: --cache must be specified you want to retry failed files")
+	level := slog.LevelInfo
+	if globals.Verbose {
+		level = slog.LevelDebug
+	} else if globals.Quiet {
+		level = slog.LevelWarn
+	}
+	closeLog, err := logging.Setup(level, globals.Quiet, globals.LogFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if parser.Active == nil {
+		parser.WriteHelp(os.Stderr)
+		os.Exit(1)
 	}
 
-	conf, err := config.NewConfig(opts.ConfigPath)
+	switch parser.Active.Name {
+	case "scan":
+		err = scan.run(&globals)
+	case "retry":
+		err = retry.run(&globals)
+	case "identify":
+		err = identify.run(&globals)
+	case "rename":
+		err = renameBooks.run(&globals)
+	case "review":
+		err = reviewBooks.run(&globals)
+	case "cache":
+		err = cacheBooks.run(&globals)
+	case "opds":
+		err = catalog.run(&globals)
+	case "serve":
+		err = serve.run(&globals)
+	case "worker":
+		err = worker.run(&globals)
+	case "config":
+		err = configure.run(&globals, parser.Active.Active.Name)
+	}
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("command failed", "command", parser.Active.Name, "error", err)
+		closeLog()
+		os.Exit(1)
 	}
+	closeLog()
+}
 
-	output, err := filepath.Abs(util.ExpandUser(opts.OutputPath))
+// session is everything a command needs to process books, close it once done
+type session struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	bm           *internal.BookManager
+	conf         *config.Config
+	outputWriter *closeRecorder
+	reviewWriter util.ObjectWriter[*review.Entry]
+	// output is where the books end up, pendingOutput where they are written until the run finishes
+	output        string
+	pendingOutput string
+	outputExisted bool
+	// a dry run leaves the output as it was
+	dryRun bool
+}
+
+// newSession loads the configuration, opens the output and starts a book manager that is interrupted by Ctrl-C,
+// importing cache first if one is given
+func newSession(globals *globalOptions, opts *runOptions, cache string, retry *internal.RetryFilter) (*session, error) {
+	conf, err := config.NewConfig(globals.configSource())
 	if err != nil {
-		log.Printf("error: could not get absolute output path: %s\n", err.Error())
-		return
+		return nil, err
 	}
-	if exists, _ := util.PathExists(output); exists {
-		log.Printf("error: output filepath %s already exists, refusing to overwrite\n", output)
-		return
+	conf.Scan.Include = append(conf.Scan.Include, opts.Include...)
+	conf.Scan.Exclude = append(conf.Scan.Exclude, opts.Exclude...)
+	conf.Scan.Archives = conf.Scan.Archives || opts.Archives
+	conf.Scan.FollowSymlinks = conf.Scan.FollowSymlinks || opts.FollowSymlinks
+	conf.Scan.OneFileSystem = conf.Scan.OneFileSystem || opts.OneFileSystem
+	// an extraction only run never asks the providers, so the machine it runs on doesn't need any set up
+	conf.Offline = opts.Offline || opts.ExtractOnly
+	if opts.Offline && len(opts.CoversDir) > 0 {
+		return nil, fmt.Errorf("error: --covers-dir downloads covers, which can't be done --offline")
+	}
+	if opts.ExtractOnly {
+		if opts.OutputFormat != "json" {
+			return nil, fmt.Errorf("error: --extract-only writes the identifiers it finds as JSON, not %s output", opts.OutputFormat)
+		}
+		if opts.DryRun || opts.Merge || len(opts.FromIdentifiers) > 0 {
+			return nil, fmt.Errorf("error: --extract-only can't be combined with --dry-run, --merge or --from-identifiers")
+		}
+	}
+
+	var output string
+	if opts.OutputFormat == "calibre" {
+		// books are added to an existing library rather than a new file
+		output, err = util.AbsPath(opts.OutputPath)
+	} else {
+		output, err = resolveOutputPath(opts.OutputPath, "output", opts.Force || opts.Resume || opts.Merge || opts.DryRun)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	outputWriter, err := util.NewJsonStreamWriter[*book.Book](output, func(bk *book.Book) (util.JsonStreamWriterItem, error) {
-		bkData, err := json.Marshal(bk)
+	var reviewOutput string
+	if opts.MinConfidence > 0 {
+		if len(opts.ReviewOutput) == 0 {
+			return nil, fmt.Errorf("error: --min-confidence needs --review-output to write the books that need review to")
+		}
+		reviewOutput, err = resolveOutputPath(opts.ReviewOutput, "review output", opts.Force || opts.Resume)
 		if err != nil {
-			return util.JsonStreamWriterItem{}, err
+			return nil, err
 		}
-		return util.JsonStreamWriterItem{
-			Key:  bk.Filepath,
-			Data: bkData,
-		}, nil
-	})
-	if err != nil {
-		log.Printf("error: unable to open to output path %s\n", output)
-		return
 	}
 
 	bm, err := internal.NewBookManager(conf, int64(opts.Threads))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	bm.SetEmbedMetadata(opts.EmbedMetadata)
+	if len(opts.CoversDir) > 0 {
+		err = bm.SetCoversDir(util.ExpandUser(opts.CoversDir))
+		if err != nil {
+			bm.Shutdown()
+			return nil, err
+		}
+	}
+	if len(opts.DebugOutput) > 0 {
+		err = bm.SetDebugOutput(util.ExpandUser(opts.DebugOutput))
+		if err != nil {
+			bm.Shutdown()
+			return nil, err
+		}
+	}
+
+	if len(cache) != 0 {
+		err = bm.Import(cache, retry)
+		if err != nil {
+			bm.Shutdown()
+			return nil, fmt.Errorf("error: book manager failed to import cache %s: %s", cache, err.Error())
+		}
+	}
+
+	outputExists, _ := util.PathExists(output)
+
+	// the existing output is read as a cache before it is replaced, cached books are all written back out
+	if outputExists && opts.Merge {
+		if opts.OutputFormat != "json" && opts.OutputFormat != "calibre" {
+			bm.Shutdown()
+			return nil, fmt.Errorf("error: --merge only works with json and calibre output, booker can't read %s output back", opts.OutputFormat)
+		}
+		err = bm.Import(output, retry)
+		if err != nil {
+			bm.Shutdown()
+			return nil, fmt.Errorf("error: book manager failed to import output %s to merge with: %s", output, err.Error())
+		}
+	}
+
+	// neither has books to checkpoint, and mustn't take the checkpoint of a run still to be resumed
+	if !opts.DryRun && !opts.ExtractOnly {
+		err = bm.SetCheckpoint(output+".checkpoint", opts.Resume)
+		if err != nil {
+			bm.Shutdown()
+			return nil, err
+		}
+	}
+
+	// everything but a Calibre library is written beside the output and only moved into place by finish
+	pendingOutput := output
+	if opts.OutputFormat != "calibre" {
+		pendingOutput = output + ".tmp"
+		// left by a run that crashed, which the checkpoint has the books of if there is anything to resume
+		os.Remove(pendingOutput)
+	}
+
+	// the pending output has its own extension, so the compression is picked by the output's
+	var outputWriter util.ObjectWriter[*book.Book]
+	var identifiersWriter util.ObjectWriter[*internal.Identifiers]
+	switch {
+	case opts.DryRun:
+		// the report is printed instead
+		outputWriter = util.DiscardWriter[*book.Book]{}
+	case opts.ExtractOnly:
+		identifiersWriter, err = util.NewJsonStreamWriter[*internal.Identifiers](pendingOutput, util.CompressionOf(output), func(ids *internal.Identifiers) (util.JsonStreamWriterItem, error) {
+			data, err := json.Marshal(ids)
+			if err != nil {
+				return util.JsonStreamWriterItem{}, err
+			}
+			return util.JsonStreamWriterItem{Key: ids.Filepath, Data: data}, nil
+		})
+		outputWriter = util.DiscardWriter[*book.Book]{}
+	default:
+		outputWriter, err = newOutputWriter(pendingOutput, opts.OutputFormat, util.CompressionOf(output))
+	}
+	if err != nil {
+		bm.Shutdown()
+		return nil, fmt.Errorf("error: unable to open to output path %s: %s", pendingOutput, err.Error())
+	}
+	if opts.ExtractOnly {
+		bm.SetExtractOnly(identifiersWriter)
+	}
+
+	var reviewWriter util.ObjectWriter[*review.Entry]
+	if len(reviewOutput) > 0 {
+		reviewWriter, err = util.NewJsonStreamWriter[*review.Entry](reviewOutput, util.CompressionOf(reviewOutput), func(entry *review.Entry) (util.JsonStreamWriterItem, error) {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return util.JsonStreamWriterItem{}, err
+			}
+			return util.JsonStreamWriterItem{Key: entry.Filepath, Data: data}, nil
+		})
+		if err != nil {
+			outputWriter.Close()
+			os.Remove(pendingOutput)
+			bm.Shutdown()
+			return nil, fmt.Errorf("error: unable to open review output path %s: %s", reviewOutput, err.Error())
+		}
+		bm.SetReview(opts.MinConfidence, reviewWriter)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	interrupts := make(chan os.Signal, 3)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		slog.Info("interrupted, finishing in-flight books (interrupt again to abandon them)")
+		bm.Interrupt()
+		<-interrupts
+		slog.Info("interrupted again, abandoning in-flight books (interrupt again to exit immediately)")
+		cancel()
+		<-interrupts
+		slog.Error("interrupted a third time, exiting without closing output")
+		os.Exit(130)
+	}()
+
+	return &session{
+		ctx:           ctx,
+		cancel:        cancel,
+		bm:            bm,
+		conf:          conf,
+		outputWriter:  &closeRecorder{ObjectWriter: outputWriter},
+		reviewWriter:  reviewWriter,
+		output:        output,
+		pendingOutput: pendingOutput,
+		outputExisted: outputExists,
+		dryRun:        opts.DryRun,
+	}, nil
+}
+
+// closeRecorder records whether the book manager has closed the output, which it does once every book is written
+type closeRecorder struct {
+	util.ObjectWriter[*book.Book]
+	closed bool
+}
+
+func (w *closeRecorder) Close() {
+	w.ObjectWriter.Close()
+	w.closed = true
+}
+
+// finish moves the output into place once the book manager has closed it, and deletes the checkpoint. An existing
+// output is only replaced if the run succeeded, since an interrupted or failed run may be missing some of its books.
+func (s *session) finish(runErr error) {
+	if !s.outputWriter.closed || s.dryRun {
+		// the scan never started, so there is nothing in it
+		if !s.outputWriter.closed {
+			s.outputWriter.Close()
+		}
+		if s.pendingOutput != s.output {
+			os.Remove(s.pendingOutput)
+		}
+		return
+	}
+
+	if runErr != nil && s.outputExisted && s.pendingOutput != s.output {
+		slog.Warn("kept the existing output since the run didn't finish, its books were left beside it", "output", s.output, "unfinished", s.pendingOutput)
+		return
 	}
-	defer bm.Shutdown()
 
-	if len(opts.Cache) != 0 {
-		err = bm.Import(opts.Cache, opts.RetryFailed)
+	if s.pendingOutput != s.output {
+		err := os.Rename(s.pendingOutput, s.output)
 		if err != nil {
-			log.Printf("error: book manager failed to import cache %s: %s\n", opts.Cache, err.Error())
+			slog.Error("failed to move the output into place", "output", s.output, "unfinished", s.pendingOutput, "error", err)
 			return
 		}
 	}
+	s.bm.RemoveCheckpoint()
+}
+
+func (s *session) Close() {
+	s.cancel()
+	s.bm.Shutdown()
+	// books are only written for review while the pipeline runs, so this waits until it has shut down
+	if s.reviewWriter != nil {
+		s.reviewWriter.Close()
+	}
+}
+
+// resolveOutputPath makes path absolute, refusing to overwrite an existing file unless overwrite is set
+func resolveOutputPath(path string, description string, overwrite bool) (string, error) {
+	resolved, err := util.AbsPath(path)
+	if err != nil {
+		return "", fmt.Errorf("error: could not get absolute %s path: %s", description, err.Error())
+	}
+	if exists, _ := util.PathExists(resolved); exists && !overwrite {
+		return "", fmt.Errorf("error: %s filepath %s already exists, refusing to overwrite", description, resolved)
+	}
+	return resolved, nil
+}
+
+// readOutputMap reads the books from a previous JSON output keyed by filepath
+func readOutputMap(path string) (map[string]book.Book, error) {
+	data, err := util.ReadFile(util.ExpandUser(path))
+	if err != nil {
+		return nil, fmt.Errorf("error: could not read input %s: %s", path, err.Error())
+	}
+
+	var books map[string]book.Book
+	err = json.Unmarshal(data, &books)
+	if err != nil {
+		return nil, fmt.Errorf("error: could not parse input %s: %s", path, err.Error())
+	}
+	return books, nil
+}
 
-	bm.Scan(opts.ScanPath, opts.DryRun, outputWriter)
+// readOutput reads the books from a previous JSON output
+func readOutput(path string) ([]book.Book, error) {
+	books, err := readOutputMap(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bookList := make([]book.Book, 0, len(books))
+	for _, bk := range books {
+		bookList = append(bookList, bk)
+	}
+	return bookList, nil
+}
+
+// newOutputWriter opens output in format, compressed with compression
+func newOutputWriter(output string, format string, compression util.Compression) (util.ObjectWriter[*book.Book], error) {
+	switch format {
+	case "sqlite", "calibre":
+		if compression != util.NoCompression {
+			return nil, fmt.Errorf("%s output can't be compressed", format)
+		}
+	}
+
+	switch format {
+	case "sqlite":
+		return util.NewSqliteWriter(output)
+	case "calibre":
+		return calibre.NewWriter(output)
+	case "bibtex":
+		return export.NewWriter(output, compression, export.NewBibtex())
+	case "csljson":
+		return export.NewWriter(output, compression, export.NewCsl())
+	case "marc":
+		return export.NewWriter(output, compression, export.NewMarc(false))
+	case "marcxml":
+		return export.NewWriter(output, compression, export.NewMarc(true))
+	case "onix":
+		return export.NewWriter(output, compression, export.NewOnix())
+	default:
+		return util.NewJsonStreamWriter[*book.Book](output, compression, func(bk *book.Book) (util.JsonStreamWriterItem, error) {
+			bkData, err := json.Marshal(bk)
+			if err != nil {
+				return util.JsonStreamWriterItem{}, err
+			}
+			return util.JsonStreamWriterItem{
+				Key:  bk.Filepath,
+				Data: bkData,
+			}, nil
+		})
+	}
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// library writes a library of two books that aren't really EPUBs, returning its path and the paths of the books
+func library(t *testing.T) (string, []string) {
+	lib := t.TempDir()
+	paths := []string{filepath.Join(lib, "a.epub"), filepath.Join(lib, "b.epub")}
+	for _, p := range paths {
+		assert.NoError(t, os.WriteFile(p, []byte("not really an epub"), 0o644))
+	}
+	return lib, paths
+}
+
+// scanSession scans lib offline with only the EPUB extractor, writing to output, and returns the session unfinished
+func scanSession(t *testing.T, lib string, output string, opts runOptions) *session {
+	configPath := filepath.Join(t.TempDir(), "booker.toml")
+	assert.NoError(t, os.WriteFile(configPath, []byte("[tika]\nenable = false\n[epub]\nenable = true\n"), 0o600))
+	opts.OutputPath = output
+	opts.OutputFormat = "json"
+	opts.Offline = true
+	opts.Threads = 2
+	s, err := newSession(&globalOptions{ConfigPath: configPath}, &opts, "", nil)
+	assert.NoError(t, err)
+	t.Cleanup(s.Close)
+	assert.NoError(t, s.bm.Scan(s.ctx, lib, false, false, s.outputWriter))
+	return s
+}
+
+// outputPaths are the sorted paths of the books in the JSON output at path
+func outputPaths(t *testing.T, path string) []string {
+	books, err := readOutputMap(path)
+	assert.NoError(t, err)
+	paths := make([]string, 0, len(books))
+	for p := range books {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestFinish(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "books.json")
+	previous := []byte(`{"/elsewhere/old.epub": {"title": "Old", "filepath": "/elsewhere/old.epub"}}`)
+	assert.NoError(t, os.WriteFile(output, previous, 0o644))
+
+	// a run that didn't finish may be missing books, so the output it would have replaced is kept
+	lib, paths := library(t)
+	s := scanSession(t, lib, output, runOptions{Force: true})
+	s.finish(errors.New("interrupted"))
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, previous, data)
+	assert.Equal(t, paths, outputPaths(t, output+".tmp"))
+	assert.FileExists(t, output+".checkpoint")
+	// given up on rather than resumed
+	assert.NoError(t, os.Remove(output+".checkpoint"))
+
+	// one that did replaces it, and has no more use for its checkpoint
+	s = scanSession(t, lib, output, runOptions{Force: true})
+	s.finish(nil)
+	assert.Equal(t, paths, outputPaths(t, output))
+	assert.NoFileExists(t, output+".tmp")
+	assert.NoFileExists(t, output+".checkpoint")
+
+	// nor is an output that didn't exist before kept back
+	fresh := filepath.Join(t.TempDir(), "books.json")
+	s = scanSession(t, lib, fresh, runOptions{})
+	s.finish(errors.New("interrupted"))
+	assert.Equal(t, paths, outputPaths(t, fresh))
+	assert.NoFileExists(t, fresh+".tmp")
+}
+
+func TestMerge(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "books.json")
+	previous := map[string]book.Book{"/elsewhere/old.epub": {Title: "Old", Filepath: "/elsewhere/old.epub"}}
+	data, err := json.Marshal(previous)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(output, data, 0o644))
+
+	// the books of the output are written back out along with the new ones, not replaced by them
+	lib, paths := library(t)
+	s := scanSession(t, lib, output, runOptions{Merge: true})
+	s.finish(nil)
+	books, err := readOutputMap(output)
+	assert.NoError(t, err)
+	assert.Equal(t, append([]string{"/elsewhere/old.epub"}, paths...), outputPaths(t, output))
+	assert.Equal(t, "Old", books["/elsewhere/old.epub"].Title)
+
+	// and a book it already has isn't processed again
+	kept := books[paths[0]]
+	kept.Title = "Kept"
+	kept.ErrorMessage = ""
+	books[paths[0]] = kept
+	data, err = json.Marshal(books)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(output, data, 0o644))
+	s = scanSession(t, lib, output, runOptions{Merge: true})
+	s.finish(nil)
+	books, err = readOutputMap(output)
+	assert.NoError(t, err)
+	assert.Len(t, books, 3)
+	assert.Equal(t, "Kept", books[paths[0]].Title)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/archive"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/opds"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/larkwiot/booker/internal/webdav"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type opdsCommand struct {
+	InputPath  string `short:"i" long:"input" description:"filepath to previous JSON output to build the catalog from" required:"true"`
+	OutputPath string `short:"o" long:"output" description:"filepath to write the catalog to, books are linked relative to it" default:"./catalog.xml"`
+	Format     string `long:"format" description:"OPDS version to write, 1.2 (Atom) or 2.0 (JSON)" choice:"atom" choice:"json" default:"atom"`
+	Title      string `long:"title" description:"title of the catalog" default:"Booker Library"`
+}
+
+// relativeHref links to filePath relative to dir, falling back to a file URL if there is no relative path. A book on
+// a WebDAV server is linked to by its URL, and a book in an archive isn't linked to, since nothing can open it there.
+func relativeHref(dir string, filePath string) string {
+	if webdav.IsUrl(filePath) {
+		return webdav.EscapeUrl(filePath)
+	}
+	if archive.IsMember(filePath) {
+		return ""
+	}
+	rel, err := filepath.Rel(dir, filePath)
+	if err != nil {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filePath)}).String()
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for idx := range segments {
+		segments[idx] = url.PathEscape(segments[idx])
+	}
+	return strings.Join(segments, "/")
+}
+
+func (c *opdsCommand) run(globals *globalOptions) error {
+	books, err := readOutput(c.InputPath)
+	if err != nil {
+		return err
+	}
+
+	output, err := util.AbsPath(c.OutputPath)
+	if err != nil {
+		return fmt.Errorf("error: could not get absolute output path: %s", err.Error())
+	}
+	outputDir := filepath.Dir(output)
+
+	catalog := opds.Catalog{
+		Title:    c.Title,
+		Updated:  time.Now(),
+		SelfHref: filepath.Base(output),
+		Books:    books,
+		Href: func(bk *book.Book) string {
+			return relativeHref(outputDir, bk.Filepath)
+		},
+	}
+
+	var data []byte
+	if c.Format == "json" {
+		data, err = catalog.Json()
+	} else {
+		data, err = catalog.Atom()
+	}
+	if err != nil {
+		return fmt.Errorf("error: could not render catalog: %s", err.Error())
+	}
+
+	// the catalog is only derived from the output, so unlike the output itself it is fine to overwrite
+	err = os.WriteFile(output, data, 0644)
+	if err != nil {
+		return fmt.Errorf("error: could not write catalog %s: %s", output, err.Error())
+	}
+
+	slog.Info("wrote catalog", "path", output)
+	return nil
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelativeHref(t *testing.T) {
+	library := filepath.Join(t.TempDir(), "Books")
+	assert.Equal(t, "Sci%20Fi/Dune%20%2350.epub", relativeHref(library, filepath.Join(library, "Sci Fi", "Dune #50.epub")))
+	assert.Equal(t, "../manual.pdf", relativeHref(library, filepath.Join(filepath.Dir(library), "manual.pdf")))
+	// a URL is linked to as it is, only escaped, and nothing can open a book inside an archive
+	assert.Equal(t, "https://nas.local/dav/Books/Sci%20Fi/Dune%20%2350.epub", relativeHref(library, "https://nas.local/dav/Books/Sci Fi/Dune #50.epub"))
+	assert.Empty(t, relativeHref(library, filepath.Join(library, "books.zip")+"::inner/dune.epub"))
+}
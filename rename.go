@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/rename"
+	"github.com/larkwiot/booker/internal/util"
+	"log/slog"
+)
+
+type renameCommand struct {
+	InputPath   string `short:"i" long:"input" description:"filepath to previous JSON output to organize the books of" required:"true"`
+	Destination string `short:"d" long:"destination" description:"directory to organize books into" default:"./"`
+	Template    string `long:"template" description:"path template for each book, relative to the destination" default:"{author}/{title} ({year}).{ext}"`
+	Link        bool   `long:"link" description:"hard-link books into place instead of moving them"`
+	OnCollision string `long:"on-collision" description:"what to do when a book's path is already taken" choice:"number" choice:"skip" default:"number"`
+	DryRun      bool   `long:"dry-run" description:"only print what would be moved or linked"`
+}
+
+func (c *renameCommand) run(globals *globalOptions) error {
+	template, err := rename.NewTemplate(c.Template)
+	if err != nil {
+		return err
+	}
+
+	destination, err := util.AbsPath(c.Destination)
+	if err != nil {
+		return fmt.Errorf("error: could not get absolute destination path: %s", err.Error())
+	}
+
+	bookList, err := readOutput(c.InputPath)
+	if err != nil {
+		return err
+	}
+
+	moves, skipped := rename.Plan(bookList, template, destination, c.OnCollision)
+
+	for _, skip := range skipped {
+		slog.Info("skipping book", "path", skip.Filepath, "reason", skip.Reason)
+	}
+
+	verb := "moved"
+	if c.Link {
+		verb = "linked"
+	}
+
+	var done, failed int
+	for _, move := range moves {
+		if c.DryRun {
+			slog.Info("would rename", "from", move.From, "to", move.To)
+			continue
+		}
+
+		err = move.Apply(c.Link)
+		if err != nil {
+			slog.Error("failed to rename", "from", move.From, "to", move.To, "error", err)
+			failed++
+			continue
+		}
+		done++
+	}
+
+	if c.DryRun {
+		slog.Info("dry-run finished", verb, len(moves), "skipped", len(skipped))
+		return nil
+	}
+
+	slog.Info("rename finished", verb, done, "skipped", len(skipped))
+	if failed > 0 {
+		return fmt.Errorf("error: failed to rename %d books", failed)
+	}
+	return nil
+}
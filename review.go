@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/review"
+	"github.com/larkwiot/booker/internal/util"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+)
+
+type reviewCommand struct {
+	InputPath  string `short:"i" long:"input" description:"filepath to previous JSON output to update with the reviewed books" required:"true"`
+	ReviewPath string `short:"r" long:"review" description:"filepath to the --review-output written along with it" required:"true"`
+	SaveEvery  int    `long:"save-every" description:"save both files after this many accepted books, they are also saved on quitting or being interrupted" default:"10"`
+}
+
+// writeJsonFile replaces the file at path with v, through a temporary file so an interruption can't leave it half
+// written. The file keeps its permissions, rather than getting the temporary file's.
+func writeJsonFile(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if info, statErr := os.Stat(path); statErr == nil {
+		err = tmp.Chmod(info.Mode().Perm())
+	}
+	var out util.Compressor
+	if err == nil {
+		out, err = util.CompressionOf(path).Writer(tmp)
+	}
+	if err == nil {
+		_, err = out.Write(data)
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (c *reviewCommand) run(globals *globalOptions) error {
+	input := util.ExpandUser(c.InputPath)
+	reviewPath := util.ExpandUser(c.ReviewPath)
+
+	books, err := readOutputMap(input)
+	if err != nil {
+		return err
+	}
+
+	data, err := util.ReadFile(reviewPath)
+	if err != nil {
+		return fmt.Errorf("error: could not read review %s: %s", c.ReviewPath, err.Error())
+	}
+	var entries map[string]review.Entry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return fmt.Errorf("error: could not parse review %s: %s", c.ReviewPath, err.Error())
+	}
+
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.review(ctx, os.Stdin, os.Stdout, input, books, reviewPath, entries, paths)
+}
+
+// review asks about the entries at paths in turn, saving the books accepted to the output at input and the entries left
+// to the review at reviewPath. They are saved every SaveEvery books rather than after each one, since both files are
+// written out in full, and once more on quitting or when ctx is cancelled, so nothing accepted is lost.
+func (c *reviewCommand) review(ctx context.Context, in io.Reader, out io.Writer, input string, books map[string]book.Book, reviewPath string, entries map[string]review.Entry, paths []string) error {
+	// the entries are reviewed in the background, so that an interrupt can save them while waiting for an answer
+	var lock sync.Mutex
+	var accepted, skipped, unsaved int
+	save := func() error {
+		if unsaved == 0 {
+			return nil
+		}
+		// the output first, so that a book is in one or the other of the files even if the review can't be saved
+		err := writeJsonFile(input, books)
+		if err != nil {
+			return fmt.Errorf("error: failed to update %s: %s", c.InputPath, err.Error())
+		}
+		err = writeJsonFile(reviewPath, entries)
+		if err != nil {
+			return fmt.Errorf("error: failed to update %s: %s", c.ReviewPath, err.Error())
+		}
+		unsaved = 0
+		return nil
+	}
+
+	reviewed := make(chan error, 1)
+	go func() {
+		reviewer := review.NewReviewer(in, out)
+		for idx, p := range paths {
+			entry := entries[p]
+			bk, decision := reviewer.Review(&entry, idx+1, len(paths))
+			if decision == review.Quit {
+				break
+			}
+
+			lock.Lock()
+			var err error
+			if decision == review.Skip {
+				skipped++
+			} else {
+				books[p] = bk
+				delete(entries, p)
+				accepted++
+				unsaved++
+				if unsaved >= c.SaveEvery {
+					err = save()
+				}
+			}
+			lock.Unlock()
+			if err != nil {
+				reviewed <- err
+				return
+			}
+		}
+		reviewed <- nil
+	}()
+
+	var err error
+	select {
+	case err = <-reviewed:
+	case <-ctx.Done():
+		fmt.Fprintln(out)
+		slog.Info("interrupted, saving the books reviewed so far")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if err == nil {
+		err = save()
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("review finished", "accepted", accepted, "skipped", skipped, "remaining", len(entries))
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/review"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestWriteJsonFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "books.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{}"), 0o644))
+	assert.NoError(t, writeJsonFile(path, map[string]string{"title": "Dune"}))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"title": "Dune"}`, string(data))
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		// the temporary file it was written through would have been private
+		assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+	}
+}
+
+// reviewFiles writes an output and a review with entries for the books at paths, which each have one candidate
+func reviewFiles(t *testing.T, paths ...string) (string, map[string]book.Book, string, map[string]review.Entry) {
+	dir := t.TempDir()
+	books := make(map[string]book.Book)
+	entries := make(map[string]review.Entry)
+	for _, p := range paths {
+		books[p] = book.Book{Filepath: p, ErrorMessage: "needs review"}
+		entries[p] = review.Entry{Book: books[p], Candidates: []review.Candidate{{Book: book.Book{Title: "Title of " + p}, Source: "google"}}}
+	}
+	input := filepath.Join(dir, "books.json")
+	reviewPath := filepath.Join(dir, "review.json")
+	for path, v := range map[string]any{input: books, reviewPath: entries} {
+		data, err := json.Marshal(v)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+	return input, books, reviewPath, entries
+}
+
+// savedEntries reads back the entries saved to the review at path
+func savedEntries(t *testing.T, path string) map[string]review.Entry {
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var entries map[string]review.Entry
+	assert.NoError(t, json.Unmarshal(data, &entries))
+	return entries
+}
+
+func TestReviewSaves(t *testing.T) {
+	paths := []string{"/books/a.pdf", "/books/b.pdf", "/books/c.pdf"}
+	input, books, reviewPath, entries := reviewFiles(t, paths...)
+	c := reviewCommand{InputPath: input, ReviewPath: reviewPath, SaveEvery: 10}
+
+	// accepting a book doesn't save it right away, but quitting does
+	in, answers := io.Pipe()
+	done := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		done <- c.review(ctx, in, io.Discard, input, books, reviewPath, entries, paths)
+	}()
+	// the answers to each book are only taken once the one before has been dealt with
+	_, err := io.WriteString(answers, "1\n\n")
+	assert.NoError(t, err)
+	_, err = io.WriteString(answers, "s\n")
+	assert.NoError(t, err)
+	assert.Len(t, savedEntries(t, reviewPath), 3)
+
+	// interrupting, while asked about the last book, saves the one accepted and keeps the others for later
+	cancel()
+	assert.NoError(t, <-done)
+	assert.ElementsMatch(t, []string{"/books/b.pdf", "/books/c.pdf"}, slices.Collect(maps.Keys(savedEntries(t, reviewPath))))
+	output, err := readOutputMap(input)
+	assert.NoError(t, err)
+	assert.Equal(t, "Title of /books/a.pdf", output["/books/a.pdf"].Title)
+	assert.Equal(t, 100.0, output["/books/a.pdf"].Confidence)
+	answers.Close()
+
+	// with a save after every book, each is saved as soon as it is accepted
+	input, books, reviewPath, entries = reviewFiles(t, paths...)
+	c = reviewCommand{InputPath: input, ReviewPath: reviewPath, SaveEvery: 1}
+	in, answers = io.Pipe()
+	go func() {
+		done <- c.review(context.Background(), in, io.Discard, input, books, reviewPath, entries, paths)
+	}()
+	_, err = io.WriteString(answers, "1\n\n")
+	assert.NoError(t, err)
+	_, err = io.WriteString(answers, "q\n")
+	assert.NoError(t, err)
+	assert.Len(t, savedEntries(t, reviewPath), 2)
+	assert.NoError(t, <-done)
+}
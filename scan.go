@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/book"
+	"github.com/larkwiot/booker/internal/intake"
+	"github.com/larkwiot/booker/internal/util"
+	"github.com/larkwiot/booker/internal/webdav"
+	"github.com/samber/lo"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+type scanCommand struct {
+	runOptions
+	ScanPath         string   `short:"s" long:"scan" description:"directory path to scan" default:"./"`
+	FilesFrom        string   `long:"files-from" description:"filepath to a list of paths to process instead of scanning, one per line, or - to read it from stdin"`
+	Cache            string   `long:"cache" description:"filepath to previous JSON output to use as cache"`
+	DuplicatesOutput string   `long:"duplicates-output" description:"filepath to write a JSON report of duplicate files and works to"`
+	StatsOutput      string   `long:"stats-output" description:"filepath to write the statistics printed at the end of the scan to as JSON, like stats.json"`
+	Watch            bool     `long:"watch" description:"keep running after the scan and process new or modified files as they appear"`
+	Intake           bool     `long:"intake" description:"process the paths that arrive on the Redis list or NATS subject configured in [intake] until interrupted, instead of scanning"`
+	Args             scanArgs `positional-args:"yes"`
+}
+
+// scanArgs lets the scan path be given as in booker scan ~/Books
+type scanArgs struct {
+	Path string `positional-arg-name:"path" description:"directory path to scan, in place of --scan"`
+}
+
+// scanPath is the path given with --scan, or after the command
+func (a *scanArgs) scanPath(flag string) (string, error) {
+	if len(a.Path) == 0 {
+		return flag, nil
+	}
+	if flag != "./" {
+		return "", fmt.Errorf("error: the scan path is given both with --scan and after the command")
+	}
+	return a.Path, nil
+}
+
+func (c *scanCommand) run(globals *globalOptions) error {
+	scanPath, err := c.Args.scanPath(c.ScanPath)
+	if err != nil {
+		return err
+	}
+	return runScan(globals, &c.runOptions, scanPath, c.FilesFrom, c.Cache, nil, c.DuplicatesOutput, c.StatsOutput, c.Watch, c.Intake)
+}
+
+type retryCommand struct {
+	runOptions
+	ScanPath         string `short:"s" long:"scan" description:"directory path to scan" default:"./"`
+	FilesFrom        string `long:"files-from" description:"filepath to a list of paths to process instead of scanning, one per line, or - to read it from stdin"`
+	Cache            string `long:"cache" description:"filepath to previous JSON output whose failed books are retried" required:"true"`
+	DuplicatesOutput string `long:"duplicates-output" description:"filepath to write a JSON report of duplicate files and works to"`
+	StatsOutput      string `long:"stats-output" description:"filepath to write the statistics printed at the end of the scan to as JSON, like stats.json"`
+	// with neither, every failed book is retried
+	RetryErrors []string `long:"retry-errors" description:"only retry the books that failed with these error codes, comma separated, like no_results,rate_limited"`
+	RetryPaths  []string `long:"retry-paths" description:"only retry the books whose paths under the scan path match this pattern, like 'fiction/**', can be given more than once"`
+	Args        scanArgs `positional-args:"yes"`
+}
+
+func (c *retryCommand) run(globals *globalOptions) error {
+	given, err := c.Args.scanPath(c.ScanPath)
+	if err != nil {
+		return err
+	}
+	if err := webdav.CheckSource(given); err != nil {
+		return fmt.Errorf("error: %s", err.Error())
+	}
+	scanPath := strings.TrimSuffix(given, "/")
+	if !webdav.IsUrl(given) {
+		scanPath, err = util.AbsPath(given)
+		if err != nil {
+			return fmt.Errorf("error: could not get absolute scan path: %s", err.Error())
+		}
+	}
+	retry := internal.RetryFilter{Paths: c.RetryPaths, Root: scanPath}
+	known := lo.Map(book.ErrorCodes, func(code book.ErrorCode, _ int) string {
+		return string(code)
+	})
+	for _, codes := range c.RetryErrors {
+		for _, code := range strings.Split(codes, ",") {
+			code := book.ErrorCode(strings.TrimSpace(code))
+			if !slices.Contains(book.ErrorCodes, code) {
+				return fmt.Errorf("error: unknown error code %s in --retry-errors, must be one of %s", code, strings.Join(known, ", "))
+			}
+			retry.ErrorCodes = append(retry.ErrorCodes, code)
+		}
+	}
+	for _, pattern := range c.RetryPaths {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return fmt.Errorf("error: --retry-paths pattern %s is invalid: %s", pattern, err.Error())
+		}
+	}
+	return runScan(globals, &c.runOptions, given, c.FilesFrom, c.Cache, &retry, c.DuplicatesOutput, c.StatsOutput, false, false)
+}
+
+func runScan(globals *globalOptions, opts *runOptions, scanPath string, filesFrom string, cache string, retry *internal.RetryFilter, duplicatesOutput string, statsOutput string, watch bool, fromIntake bool) error {
+	var err error
+	if fromIntake && (len(filesFrom) > 0 || watch || len(opts.FromIdentifiers) > 0) {
+		return fmt.Errorf("error: --intake takes the paths to process from the queue, which can't be combined with --files-from, --watch or --from-identifiers")
+	}
+	if len(opts.FromIdentifiers) > 0 && (len(filesFrom) > 0 || watch) {
+		return fmt.Errorf("error: --from-identifiers searches the books it lists, which can't be combined with --files-from or --watch")
+	}
+
+	var list io.Reader
+	if len(filesFrom) > 0 {
+		if watch {
+			return fmt.Errorf("error: --watch watches the scan path, which isn't used with --files-from")
+		}
+		if filesFrom == "-" {
+			list = os.Stdin
+		} else {
+			listFile, err := os.Open(util.ExpandUser(filesFrom))
+			if err != nil {
+				return fmt.Errorf("error: could not open list of paths %s: %s", filesFrom, err.Error())
+			}
+			defer listFile.Close()
+			list = listFile
+		}
+	}
+
+	if len(duplicatesOutput) > 0 {
+		duplicatesOutput, err = resolveOutputPath(duplicatesOutput, "duplicates output", opts.Force || opts.Resume)
+		if err != nil {
+			return err
+		}
+	}
+	if len(statsOutput) > 0 {
+		statsOutput, err = resolveOutputPath(statsOutput, "stats output", opts.Force || opts.Resume)
+		if err != nil {
+			return err
+		}
+	}
+
+	s, err := newSession(globals, opts, cache, retry)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if fromIntake {
+		var source *intake.Intake
+		if len(s.conf.Intake.Url) == 0 {
+			err = fmt.Errorf("error: --intake needs intake.url and intake.key to be configured")
+		} else if source, err = intake.Open(s.ctx, s.conf.Intake); err == nil {
+			defer source.Close()
+			err = s.bm.ScanIntake(s.ctx, source, opts.DryRun, s.outputWriter)
+		}
+	} else if len(opts.FromIdentifiers) > 0 {
+		err = s.bm.ScanIdentifiers(s.ctx, opts.FromIdentifiers, opts.DryRun, s.outputWriter)
+	} else if list != nil {
+		err = s.bm.ScanList(s.ctx, list, opts.DryRun, s.outputWriter)
+	} else {
+		err = s.bm.Scan(s.ctx, scanPath, opts.DryRun, watch, s.outputWriter)
+	}
+	s.finish(err)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		data, err := json.MarshalIndent(s.bm.DryRunReport(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("error: could not marshal dry run report: %s", err.Error())
+		}
+		fmt.Println(string(data))
+	}
+
+	if scanStats := s.bm.Stats(); scanStats != nil {
+		report := scanStats.Report()
+		if !globals.Quiet {
+			report.Print(os.Stderr)
+		}
+		if len(statsOutput) > 0 {
+			err = writeJsonFile(statsOutput, report)
+			if err != nil {
+				return fmt.Errorf("error: failed to write stats to %s: %s", statsOutput, err.Error())
+			}
+			slog.Info("wrote stats", "path", statsOutput)
+		}
+	}
+
+	if len(duplicatesOutput) > 0 {
+		err = s.bm.WriteDuplicates(duplicatesOutput)
+		if err != nil {
+			return fmt.Errorf("error: failed to write duplicates to %s: %s", duplicatesOutput, err.Error())
+		}
+		slog.Info("wrote duplicates report", "path", duplicatesOutput)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"github.com/larkwiot/booker/internal/server"
+	"time"
+)
+
+type serveCommand struct {
+	runOptions
+	Listen string `short:"l" long:"listen" description:"address to serve the REST API on" default:"127.0.0.1:8080"`
+	Cache  string `long:"cache" description:"filepath to previous JSON output to use as cache"`
+	// Distribute makes the server the coordinator of workers started with booker worker --join
+	Distribute bool `long:"distribute" description:"hand the books out to workers started with booker worker --join instead of processing them here"`
+}
+
+func (c *serveCommand) run(globals *globalOptions) error {
+	if c.ExtractOnly || len(c.FromIdentifiers) > 0 {
+		return fmt.Errorf("error: --extract-only and --from-identifiers are for scan and retry")
+	}
+
+	s, err := newSession(globals, &c.runOptions, c.Cache, nil)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	srv := server.NewServer(s.bm, c.Listen)
+	if c.Distribute {
+		srv.Distribute(time.Duration(s.conf.Advanced.WorkerTimeoutSeconds) * time.Second)
+	}
+	err = srv.Serve(s.ctx, c.DryRun, s.outputWriter)
+	s.finish(err)
+	return err
+}
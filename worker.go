@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/larkwiot/booker/internal"
+	"github.com/larkwiot/booker/internal/config"
+	"github.com/larkwiot/booker/internal/server"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+type workerCommand struct {
+	Join    string `long:"join" description:"address of the coordinator to work for, which is serving with --distribute, like host:8080" required:"true"`
+	Jobs    int    `short:"j" long:"jobs" description:"number of books to process at once" default:"4"`
+	Cache   string `long:"cache" description:"filepath to previous JSON output to use as cache"`
+	Offline bool   `long:"offline" description:"don't use any providers, identifying books only by their embedded metadata and the cache"`
+}
+
+// run processes the books the coordinator hands out until interrupted, with the extractors and providers of its own
+// configuration
+func (c *workerCommand) run(globals *globalOptions) error {
+	if c.Jobs < 1 {
+		return fmt.Errorf("error: --jobs must be at least 1 but was %d", c.Jobs)
+	}
+
+	conf, err := config.NewConfig(globals.configSource())
+	if err != nil {
+		return err
+	}
+	conf.Offline = c.Offline
+
+	bm, err := internal.NewBookManager(conf, 0)
+	if err != nil {
+		return err
+	}
+	defer bm.Shutdown()
+
+	if len(c.Cache) != 0 {
+		err = bm.Import(c.Cache, nil)
+		if err != nil {
+			return fmt.Errorf("error: book manager failed to import cache %s: %s", c.Cache, err.Error())
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server.NewWorker(bm, c.Join).Run(ctx, c.Jobs)
+	return nil
+}